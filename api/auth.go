@@ -1,5 +1,16 @@
 package api
 
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+)
+
 // Auth is used to perform credential backend related operations.
 type Auth struct {
 	c *Client
@@ -9,3 +20,145 @@ type Auth struct {
 func (c *Client) Auth() *Auth {
 	return &Auth{c: c}
 }
+
+// AuthMethod is implemented by the auth method login helpers, such as
+// AppRoleAuth or UserpassAuth. Login returns the secret of a successful login,
+// without modifying the client.
+type AuthMethod interface {
+	Login(ctx context.Context, client *Client) (*Secret, error)
+}
+
+// Login logs in with the given auth method and sets the resulting token on
+// the client.
+func (a *Auth) Login(ctx context.Context, authMethod AuthMethod) (*Secret, error) {
+	if authMethod == nil {
+		return nil, errors.New("no auth method provided")
+	}
+
+	secret, err := authMethod.Login(ctx, a.c)
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil || secret.Auth == nil || secret.Auth.ClientToken == "" {
+		return nil, errors.New("login response did not contain a token")
+	}
+
+	a.c.SetToken(secret.Auth.ClientToken)
+	return secret, nil
+}
+
+// KeepLoggedIn logs in with the given auth method and keeps the token of the
+// client valid until ctx is done. The token is renewed with a Renewer, and a
+// new login is made once it can't be renewed any longer, for instance when
+// it reaches its max TTL. onLogin, if set, is called after every login.
+//
+// KeepLoggedIn returns nil once ctx is done, or the error of a failed login.
+func (a *Auth) KeepLoggedIn(ctx context.Context, authMethod AuthMethod, onLogin func(*Secret)) error {
+	for {
+		secret, err := a.Login(ctx, authMethod)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		if onLogin != nil {
+			onLogin(secret)
+		}
+
+		if !a.waitForLogin(ctx, secret) {
+			return nil
+		}
+	}
+}
+
+// waitForLogin returns true once a new login is needed, renewing the token
+// in the meantime, or false once ctx is done
+func (a *Auth) waitForLogin(ctx context.Context, secret *Secret) bool {
+	if !secret.Auth.Renewable {
+		// Tokens that don't expire never need a new login, the others are
+		// replaced before they expire
+		if secret.Auth.LeaseDuration == 0 {
+			<-ctx.Done()
+			return false
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(time.Duration(secret.Auth.LeaseDuration) * time.Second * 2 / 3):
+			return true
+		}
+	}
+
+	renewer, err := a.c.NewRenewer(&RenewerInput{
+		Secret: secret,
+	})
+	if err != nil {
+		return true
+	}
+	go renewer.Renew()
+	defer renewer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-renewer.RenewCh():
+		case <-renewer.DoneCh():
+			// Renewal stopped, either because of an error or because the
+			// token reached its max TTL
+			return true
+		}
+	}
+}
+
+// login writes data to the login endpoint at path. The request is made
+// without the token of the client and is never wrapped.
+func (c *Client) login(ctx context.Context, path string, data map[string]interface{}) (*Secret, error) {
+	r := c.NewRequest("PUT", "/v1/"+path)
+	r.ClientToken = ""
+	r.WrapTTL = ""
+	if err := r.SetJSONBody(data); err != nil {
+		return nil, err
+	}
+
+	resp, err := c.RawRequestWithContext(ctx, r)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseSecret(resp.Body)
+}
+
+// loginPath returns the path of the login endpoint of the auth method mounted
+// at mountPath, or at defaultMountPath if mountPath is empty
+func loginPath(mountPath, defaultMountPath, suffix string) string {
+	if mountPath == "" {
+		mountPath = defaultMountPath
+	}
+	return "auth/" + strings.Trim(mountPath, "/") + "/login" + suffix
+}
+
+// readCredential returns value, or the trimmed contents of file if set, so
+// that credentials rotated on disk are picked up by the next login
+func readCredential(value, file, name string) (string, error) {
+	if file == "" {
+		if value == "" {
+			return "", fmt.Errorf("no %s provided", name)
+		}
+		return value, nil
+	}
+
+	contents, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", errwrap.Wrapf("error reading "+name+" file: {{err}}", err)
+	}
+	value = strings.TrimSpace(string(contents))
+	if value == "" {
+		return "", fmt.Errorf("%s file %q is empty", name, file)
+	}
+	return value, nil
+}
@@ -0,0 +1,82 @@
+package api
+
+import (
+	"context"
+	"errors"
+)
+
+// AppRoleAuth logs in with the AppRole auth method
+type AppRoleAuth struct {
+	// MountPath is the mount path of the auth method, "approle" by default
+	MountPath string
+
+	// RoleID is the role ID to log in with
+	RoleID string
+
+	// SecretID is the secret ID to log in with. If SecretIDFile is set, the
+	// secret ID is read from that file on every login instead. Neither is
+	// needed for roles that don't bind a secret ID.
+	SecretID     string
+	SecretIDFile string
+
+	// WrappedSecretID indicates that the secret ID is a response-wrapping
+	// token, which is unwrapped on login. Wrapping tokens can only be
+	// unwrapped once, so SecretIDFile should be used to log in again.
+	WrappedSecretID bool
+}
+
+var _ AuthMethod = (*AppRoleAuth)(nil)
+
+// Login logs in with the role ID and secret ID
+func (a *AppRoleAuth) Login(ctx context.Context, client *Client) (*Secret, error) {
+	if a.RoleID == "" {
+		return nil, errors.New("no role ID provided")
+	}
+
+	data := map[string]interface{}{
+		"role_id": a.RoleID,
+	}
+
+	if a.SecretID != "" || a.SecretIDFile != "" {
+		secretID, err := readCredential(a.SecretID, a.SecretIDFile, "secret ID")
+		if err != nil {
+			return nil, err
+		}
+		if a.WrappedSecretID {
+			if secretID, err = client.unwrapSecretID(ctx, secretID); err != nil {
+				return nil, err
+			}
+		}
+		data["secret_id"] = secretID
+	}
+
+	return client.login(ctx, loginPath(a.MountPath, "approle", ""), data)
+}
+
+// unwrapSecretID returns the secret ID wrapped by wrappingToken
+func (c *Client) unwrapSecretID(ctx context.Context, wrappingToken string) (string, error) {
+	r := c.NewRequest("PUT", "/v1/sys/wrapping/unwrap")
+	r.ClientToken = wrappingToken
+	r.WrapTTL = ""
+
+	resp, err := c.RawRequestWithContext(ctx, r)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		return "", err
+	}
+
+	secret, err := ParseSecret(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if secret == nil || secret.Data == nil {
+		return "", errors.New("no data in unwrapped secret ID response")
+	}
+	secretID, _ := secret.Data["secret_id"].(string)
+	if secretID == "" {
+		return "", errors.New("no secret ID in unwrapped response")
+	}
+	return secretID, nil
+}
@@ -0,0 +1,30 @@
+package api
+
+import (
+	"context"
+)
+
+// CertAuth logs in with the TLS certificates auth method. The client
+// certificate is the one the client is configured with, for instance with
+// Config.ConfigureTLS or the VAULT_CLIENT_CERT and VAULT_CLIENT_KEY
+// environment variables.
+type CertAuth struct {
+	// MountPath is the mount path of the auth method, "cert" by default
+	MountPath string
+
+	// Name is the name of the certificate role to log in with. If empty,
+	// all the roles are tried.
+	Name string
+}
+
+var _ AuthMethod = (*CertAuth)(nil)
+
+// Login logs in with the client certificate
+func (a *CertAuth) Login(ctx context.Context, client *Client) (*Secret, error) {
+	data := map[string]interface{}{}
+	if a.Name != "" {
+		data["name"] = a.Name
+	}
+
+	return client.login(ctx, loginPath(a.MountPath, "cert", ""), data)
+}
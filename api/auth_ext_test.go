@@ -0,0 +1,199 @@
+package api_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	log "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/api"
+	credAppRole "github.com/hashicorp/vault/builtin/credential/approle"
+	credUserpass "github.com/hashicorp/vault/builtin/credential/userpass"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/vault"
+)
+
+func testAuthServer(t *testing.T) (*api.Client, func()) {
+	t.Helper()
+
+	client, _, closer := testVaultServerCoreConfig(t, &vault.CoreConfig{
+		DisableMlock: true,
+		DisableCache: true,
+		Logger:       log.NewNullLogger(),
+		CredentialBackends: map[string]logical.Factory{
+			"approle":  credAppRole.Factory,
+			"userpass": credUserpass.Factory,
+		},
+	})
+
+	for _, method := range []string{"approle", "userpass"} {
+		if err := client.Sys().EnableAuthWithOptions(method, &api.EnableAuthOptions{
+			Type: method,
+		}); err != nil {
+			closer()
+			t.Fatal(err)
+		}
+	}
+
+	return client, closer
+}
+
+func TestAuth_Login(t *testing.T) {
+	client, closer := testAuthServer(t)
+	defer closer()
+
+	if _, err := client.Logical().Write("auth/userpass/users/alice", map[string]interface{}{
+		"password": "secret",
+		"policies": "default",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Logical().Write("auth/approle/role/app", map[string]interface{}{
+		"policies": "default",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	secret, err := client.Logical().Read("auth/approle/role/app/role-id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	roleID := secret.Data["role_id"].(string)
+
+	// Wrap a secret ID and write it to a file
+	rootClient, err := client.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootClient.SetToken(client.Token())
+	rootClient.SetWrappingLookupFunc(func(string, string) string { return "5m" })
+	secret, err = rootClient.Logical().Write("auth/approle/role/app/secret-id", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "vault-auth-login")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	secretIDFile := filepath.Join(dir, "secret-id")
+	if err := ioutil.WriteFile(secretIDFile, []byte(secret.WrapInfo.Token+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, method := range map[string]api.AuthMethod{
+		"userpass": &api.UserpassAuth{
+			Username: "alice",
+			Password: "secret",
+		},
+		"approle": &api.AppRoleAuth{
+			RoleID:          roleID,
+			SecretIDFile:    secretIDFile,
+			WrappedSecretID: true,
+		},
+	} {
+		loginClient, err := client.Clone()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		secret, err := loginClient.Auth().Login(context.Background(), method)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if loginClient.Token() == "" || loginClient.Token() != secret.Auth.ClientToken {
+			t.Fatalf("%s: token not set on the client", name)
+		}
+		if _, err := loginClient.Auth().Token().LookupSelf(); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+	}
+
+	// The wrapping token was used by the first login
+	loginClient, err := client.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loginClient.Auth().Login(context.Background(), &api.AppRoleAuth{
+		RoleID:          roleID,
+		SecretIDFile:    secretIDFile,
+		WrappedSecretID: true,
+	}); err == nil {
+		t.Fatal("expected an error reusing the wrapping token")
+	}
+
+	if _, err := loginClient.Auth().Login(context.Background(), &api.UserpassAuth{
+		Username: "alice",
+		Password: "wrong",
+	}); err == nil {
+		t.Fatal("expected an error with a bad password")
+	}
+	if loginClient.Token() != "" {
+		t.Fatal("expected no token after failed logins")
+	}
+}
+
+func TestAuth_KeepLoggedIn(t *testing.T) {
+	client, closer := testAuthServer(t)
+	defer closer()
+
+	if _, err := client.Logical().Write("auth/userpass/users/alice", map[string]interface{}{
+		"password": "secret",
+		"policies": "default",
+		"ttl":      "2s",
+		"max_ttl":  "3s",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	loginClient, err := client.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	loginCh := make(chan string, 10)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- loginClient.Auth().KeepLoggedIn(ctx, &api.UserpassAuth{
+			Username: "alice",
+			Password: "secret",
+		}, func(secret *api.Secret) {
+			loginCh <- secret.Auth.ClientToken
+		})
+	}()
+
+	// The token reaches its max TTL, so a second login is made
+	var tokens []string
+	timeout := time.After(15 * time.Second)
+	for len(tokens) < 2 {
+		select {
+		case token := <-loginCh:
+			tokens = append(tokens, token)
+		case err := <-errCh:
+			t.Fatalf("stopped early: %v", err)
+		case <-timeout:
+			t.Fatalf("timed out waiting for logins, got %d", len(tokens))
+		}
+	}
+	if tokens[0] == tokens[1] {
+		t.Fatal("expected a new token")
+	}
+	if loginClient.Token() != tokens[1] {
+		t.Fatal("expected the client to use the new token")
+	}
+
+	cancel()
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("KeepLoggedIn didn't return")
+	}
+}
@@ -0,0 +1,48 @@
+package api
+
+import (
+	"context"
+	"errors"
+)
+
+// DefaultServiceAccountTokenPath is where Kubernetes mounts the service
+// account token in pods
+const DefaultServiceAccountTokenPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// KubernetesAuth logs in with the Kubernetes auth method
+type KubernetesAuth struct {
+	// MountPath is the mount path of the auth method, "kubernetes" by default
+	MountPath string
+
+	// Role is the role to log in with
+	Role string
+
+	// JWT is the service account token to log in with. If it is empty, the
+	// token is read on every login from JWTFile, or from
+	// DefaultServiceAccountTokenPath.
+	JWT     string
+	JWTFile string
+}
+
+var _ AuthMethod = (*KubernetesAuth)(nil)
+
+// Login logs in with the service account token
+func (a *KubernetesAuth) Login(ctx context.Context, client *Client) (*Secret, error) {
+	if a.Role == "" {
+		return nil, errors.New("no role provided")
+	}
+
+	jwtFile := a.JWTFile
+	if a.JWT == "" && jwtFile == "" {
+		jwtFile = DefaultServiceAccountTokenPath
+	}
+	jwt, err := readCredential(a.JWT, jwtFile, "service account token")
+	if err != nil {
+		return nil, err
+	}
+
+	return client.login(ctx, loginPath(a.MountPath, "kubernetes", ""), map[string]interface{}{
+		"role": a.Role,
+		"jwt":  jwt,
+	})
+}
@@ -0,0 +1,64 @@
+package api
+
+import (
+	"context"
+	"errors"
+)
+
+// UserpassAuth logs in with the userpass auth method
+type UserpassAuth struct {
+	// MountPath is the mount path of the auth method, "userpass" by default
+	MountPath string
+
+	// Username is the user to log in as
+	Username string
+
+	// Password is the password of the user. If PasswordFile is set, the
+	// password is read from that file on every login instead.
+	Password     string
+	PasswordFile string
+}
+
+var _ AuthMethod = (*UserpassAuth)(nil)
+
+// Login logs in with the username and password
+func (a *UserpassAuth) Login(ctx context.Context, client *Client) (*Secret, error) {
+	return passwordLogin(ctx, client, loginPath(a.MountPath, "userpass", "/"), a.Username, a.Password, a.PasswordFile)
+}
+
+// LDAPAuth logs in with the LDAP auth method
+type LDAPAuth struct {
+	// MountPath is the mount path of the auth method, "ldap" by default
+	MountPath string
+
+	// Username is the user to log in as
+	Username string
+
+	// Password is the password of the user. If PasswordFile is set, the
+	// password is read from that file on every login instead.
+	Password     string
+	PasswordFile string
+}
+
+var _ AuthMethod = (*LDAPAuth)(nil)
+
+// Login logs in with the username and password
+func (a *LDAPAuth) Login(ctx context.Context, client *Client) (*Secret, error) {
+	return passwordLogin(ctx, client, loginPath(a.MountPath, "ldap", "/"), a.Username, a.Password, a.PasswordFile)
+}
+
+// passwordLogin logs in at path followed by the username, as done by the
+// auth methods using a username and password
+func passwordLogin(ctx context.Context, client *Client, path, username, password, passwordFile string) (*Secret, error) {
+	if username == "" {
+		return nil, errors.New("no username provided")
+	}
+	password, err := readCredential(password, passwordFile, "password")
+	if err != nil {
+		return nil, err
+	}
+
+	return client.login(ctx, path+username, map[string]interface{}{
+		"password": password,
+	})
+}
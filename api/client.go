@@ -370,6 +370,25 @@ func NewClient(c *Config) (*Client, error) {
 		c.HttpClient.Transport = def.HttpClient.Transport
 	}
 
+	if u.Scheme == "unix" {
+		transport, ok := c.HttpClient.Transport.(*http.Transport)
+		if !ok {
+			return nil, fmt.Errorf("unix socket addresses require an *http.Transport")
+		}
+		socket := u.Path
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		}
+
+		// The client URL describes the application protocol, so talk plain
+		// HTTP over the socket
+		u = &url.URL{
+			Scheme: "http",
+			Host:   "localhost",
+		}
+	}
+
 	client := &Client{
 		addr:   u,
 		config: c,
@@ -565,6 +584,67 @@ func (c *Client) Clone() (*Client, error) {
 	return NewClient(newConfig)
 }
 
+// CloneConfig returns a copy of the configuration the client was created
+// with, which can be changed without affecting the client. The HTTP client is
+// copied along with its transport and TLS configuration, so calling
+// ConfigureTLS on the copy only changes the TLS settings of clients created
+// from it.
+func (c *Client) CloneConfig() *Config {
+	c.modifyLock.RLock()
+	c.config.modifyLock.RLock()
+	config := c.config
+	c.modifyLock.RUnlock()
+	defer config.modifyLock.RUnlock()
+
+	newConfig := &Config{
+		Address:    config.Address,
+		MaxRetries: config.MaxRetries,
+		Timeout:    config.Timeout,
+		Backoff:    config.Backoff,
+		Limiter:    config.Limiter,
+	}
+
+	httpClient := *config.HttpClient
+	if orig, ok := httpClient.Transport.(*http.Transport); ok {
+		// The fields are copied by hand rather than with Transport.Clone,
+		// which needs Go 1.13, and the idle connections aren't shared
+		transport := &http.Transport{
+			Proxy:                  orig.Proxy,
+			DialContext:            orig.DialContext,
+			Dial:                   orig.Dial,
+			DialTLS:                orig.DialTLS,
+			TLSHandshakeTimeout:    orig.TLSHandshakeTimeout,
+			DisableKeepAlives:      orig.DisableKeepAlives,
+			DisableCompression:     orig.DisableCompression,
+			MaxIdleConns:           orig.MaxIdleConns,
+			MaxIdleConnsPerHost:    orig.MaxIdleConnsPerHost,
+			IdleConnTimeout:        orig.IdleConnTimeout,
+			ResponseHeaderTimeout:  orig.ResponseHeaderTimeout,
+			ExpectContinueTimeout:  orig.ExpectContinueTimeout,
+			ProxyConnectHeader:     orig.ProxyConnectHeader,
+			MaxResponseHeaderBytes: orig.MaxResponseHeaderBytes,
+		}
+		if orig.TLSClientConfig != nil {
+			transport.TLSClientConfig = orig.TLSClientConfig.Clone()
+		} else {
+			transport.TLSClientConfig = &tls.Config{
+				MinVersion: tls.VersionTLS12,
+			}
+		}
+		// The HTTP/2 connections must not be pooled with the ones of the
+		// original transport, which may use other TLS settings
+		if _, ok := orig.TLSNextProto["h2"]; ok {
+			if err := http2.ConfigureTransport(transport); err != nil {
+				newConfig.Error = err
+			}
+		}
+		httpClient.Transport = transport
+	}
+	newConfig.HttpClient = &httpClient
+
+	return newConfig
+}
+
 // SetPolicyOverride sets whether requests should be sent with the policy
 // override flag to request overriding soft-mandatory Sentinel policies (both
 // RGPs and EGPs)
@@ -3,8 +3,11 @@ package api
 import (
 	"bytes"
 	"io"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 )
@@ -69,6 +72,44 @@ func TestClientSetAddress(t *testing.T) {
 	}
 }
 
+func TestClientUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vault-api-unix")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "vault.sock")
+
+	ln, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go http.Serve(ln, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Path))
+	}))
+
+	config := DefaultConfig()
+	config.Address = "unix://" + socket
+	client, err := NewClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := client.RawRequest(client.NewRequest("GET", "/v1/sys/health"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "/v1/sys/health" {
+		t.Fatalf("bad: %q", body)
+	}
+}
+
 func TestClientToken(t *testing.T) {
 	tokenValue := "foo"
 	handler := func(w http.ResponseWriter, req *http.Request) {}
@@ -280,3 +321,31 @@ func TestClone(t *testing.T) {
 
 	_ = client2
 }
+
+func TestCloneConfig(t *testing.T) {
+	client, err := NewClient(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetMaxRetries(5)
+
+	config := client.CloneConfig()
+	if config.Error != nil {
+		t.Fatal(config.Error)
+	}
+	if config.Address != client.Address() || config.MaxRetries != 5 {
+		t.Fatalf("bad: %#v", config)
+	}
+
+	// Changing the TLS settings of the copy doesn't change the client's
+	if err := config.ConfigureTLS(&TLSConfig{Insecure: true, TLSServerName: "example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	tlsConfig := client.config.HttpClient.Transport.(*http.Transport).TLSClientConfig
+	if tlsConfig.InsecureSkipVerify || tlsConfig.ServerName != "" {
+		t.Fatalf("the TLS configuration of the client changed: %#v", tlsConfig)
+	}
+	if !config.HttpClient.Transport.(*http.Transport).TLSClientConfig.InsecureSkipVerify {
+		t.Fatal("the TLS configuration of the copy didn't change")
+	}
+}
@@ -0,0 +1,357 @@
+package fake
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/helper/parseutil"
+)
+
+// defaultMaxVersions is the number of versions KV v2 keeps by default
+const defaultMaxVersions = 10
+
+// kvV1Backend is a fake KV v1 secrets engine
+type kvV1Backend struct {
+	secrets map[string]map[string]interface{}
+}
+
+func newKVv1Backend() *kvV1Backend {
+	return &kvV1Backend{
+		secrets: make(map[string]map[string]interface{}),
+	}
+}
+
+func (b *kvV1Backend) paths() []string {
+	paths := make([]string, 0, len(b.secrets))
+	for path := range b.secrets {
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+func (b *kvV1Backend) handleRequest(s *Server, req *request, path string) (*api.Secret, error) {
+	path = strings.Trim(path, "/")
+
+	switch req.method {
+	case "GET":
+		data, ok := b.secrets[path]
+		if !ok {
+			return nil, errNotFound
+		}
+		return &api.Secret{
+			Data:          copyData(data),
+			LeaseDuration: int((768 * time.Hour).Seconds()),
+		}, nil
+
+	case "LIST":
+		return listResponse(listKeys(b.paths(), path))
+
+	case "PUT", "POST":
+		if path == "" {
+			return nil, errorf(http.StatusBadRequest, "missing path")
+		}
+		b.secrets[path] = copyData(req.data)
+		return nil, nil
+
+	case "DELETE":
+		delete(b.secrets, path)
+		return nil, nil
+	}
+
+	return nil, errorf(http.StatusMethodNotAllowed, "unsupported operation")
+}
+
+// kvV2Backend is a fake KV v2 secrets engine
+type kvV2Backend struct {
+	secrets map[string]*kvV2Secret
+}
+
+type kvV2Secret struct {
+	versions       map[int]*kvV2Version
+	currentVersion int
+	oldestVersion  int
+	maxVersions    int
+	casRequired    bool
+	createdTime    time.Time
+	updatedTime    time.Time
+}
+
+type kvV2Version struct {
+	data         map[string]interface{}
+	createdTime  time.Time
+	deletionTime time.Time
+	destroyed    bool
+}
+
+func (v *kvV2Version) metadata() map[string]interface{} {
+	return map[string]interface{}{
+		"created_time":  formatTime(v.createdTime),
+		"deletion_time": formatTime(v.deletionTime),
+		"destroyed":     v.destroyed,
+	}
+}
+
+func newKVv2Backend() *kvV2Backend {
+	return &kvV2Backend{
+		secrets: make(map[string]*kvV2Secret),
+	}
+}
+
+func (b *kvV2Backend) paths() []string {
+	paths := make([]string, 0, len(b.secrets))
+	for path := range b.secrets {
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+func (b *kvV2Backend) handleRequest(s *Server, req *request, path string) (*api.Secret, error) {
+	prefix := path
+	if i := strings.Index(path, "/"); i >= 0 {
+		prefix, path = path[:i], strings.Trim(path[i+1:], "/")
+	} else {
+		path = ""
+	}
+
+	switch {
+	case prefix == "data":
+		return b.handleData(s, req, path)
+	case prefix == "metadata":
+		return b.handleMetadata(s, req, path)
+	case prefix == "delete" || prefix == "undelete" || prefix == "destroy":
+		return b.handleVersions(s, req, prefix, path)
+	}
+
+	return nil, errorf(http.StatusNotFound, "no handler for route '%s'", req.path)
+}
+
+func (b *kvV2Backend) handleData(s *Server, req *request, path string) (*api.Secret, error) {
+	if path == "" {
+		return nil, errorf(http.StatusBadRequest, "missing path")
+	}
+	secret := b.secrets[path]
+
+	switch req.method {
+	case "GET":
+		if secret == nil || secret.currentVersion == 0 {
+			return nil, errNotFound
+		}
+		version := secret.currentVersion
+		if raw := req.params.Get("version"); raw != "" && raw != "0" {
+			var err error
+			if version, err = strconv.Atoi(raw); err != nil {
+				return nil, errorf(http.StatusBadRequest, "invalid version %q", raw)
+			}
+		}
+		v, ok := secret.versions[version]
+		if !ok {
+			return nil, errNotFound
+		}
+
+		metadata := v.metadata()
+		metadata["version"] = version
+		resp := &api.Secret{
+			Data: map[string]interface{}{
+				"data":     nil,
+				"metadata": metadata,
+			},
+		}
+		if !v.deletionTime.IsZero() || v.destroyed {
+			return nil, &notFoundWithData{secret: resp}
+		}
+		resp.Data["data"] = copyData(v.data)
+		return resp, nil
+
+	case "PUT", "POST":
+		data, ok := req.data["data"].(map[string]interface{})
+		if !ok {
+			return nil, errorf(http.StatusBadRequest, "no data provided")
+		}
+
+		options, _ := req.data["options"].(map[string]interface{})
+		cas, casSet, err := intField(options, "cas")
+		if err != nil {
+			return nil, err
+		}
+		current := 0
+		if secret != nil {
+			current = secret.currentVersion
+		}
+		if secret != nil && secret.casRequired && !casSet {
+			return nil, errorf(http.StatusBadRequest, "check-and-set parameter required for this call")
+		}
+		if casSet && cas != current {
+			return nil, errorf(http.StatusBadRequest, "check-and-set parameter did not match the current version")
+		}
+
+		now := s.now()
+		if secret == nil {
+			secret = &kvV2Secret{
+				versions:    make(map[int]*kvV2Version),
+				createdTime: now,
+			}
+			b.secrets[path] = secret
+		}
+		secret.currentVersion++
+		secret.updatedTime = now
+		if secret.oldestVersion == 0 {
+			secret.oldestVersion = secret.currentVersion
+		}
+		v := &kvV2Version{
+			data:        copyData(data),
+			createdTime: now,
+		}
+		secret.versions[secret.currentVersion] = v
+		secret.trim()
+
+		metadata := v.metadata()
+		metadata["version"] = secret.currentVersion
+		return &api.Secret{
+			Data: metadata,
+		}, nil
+
+	case "DELETE":
+		if secret != nil {
+			if v, ok := secret.versions[secret.currentVersion]; ok && v.deletionTime.IsZero() {
+				v.deletionTime = s.now()
+			}
+		}
+		return nil, nil
+	}
+
+	return nil, errorf(http.StatusMethodNotAllowed, "unsupported operation")
+}
+
+// trim removes the oldest versions beyond the max number of versions
+func (secret *kvV2Secret) trim() {
+	maxVersions := secret.maxVersions
+	if maxVersions == 0 {
+		maxVersions = defaultMaxVersions
+	}
+	for secret.currentVersion-secret.oldestVersion >= maxVersions {
+		delete(secret.versions, secret.oldestVersion)
+		secret.oldestVersion++
+	}
+}
+
+func (b *kvV2Backend) handleMetadata(s *Server, req *request, path string) (*api.Secret, error) {
+	if req.method == "LIST" {
+		return listResponse(listKeys(b.paths(), path))
+	}
+	if path == "" {
+		return nil, errorf(http.StatusBadRequest, "missing path")
+	}
+	secret := b.secrets[path]
+
+	switch req.method {
+	case "GET":
+		if secret == nil {
+			return nil, errNotFound
+		}
+
+		versions := make(map[string]interface{}, len(secret.versions))
+		for version, v := range secret.versions {
+			versions[strconv.Itoa(version)] = v.metadata()
+		}
+		return &api.Secret{
+			Data: map[string]interface{}{
+				"versions":        versions,
+				"current_version": secret.currentVersion,
+				"oldest_version":  secret.oldestVersion,
+				"max_versions":    secret.maxVersions,
+				"cas_required":    secret.casRequired,
+				"created_time":    formatTime(secret.createdTime),
+				"updated_time":    formatTime(secret.updatedTime),
+			},
+		}, nil
+
+	case "PUT", "POST":
+		if secret == nil {
+			now := s.now()
+			secret = &kvV2Secret{
+				versions:    make(map[int]*kvV2Version),
+				createdTime: now,
+				updatedTime: now,
+			}
+			b.secrets[path] = secret
+		}
+		maxVersions, ok, err := intField(req.data, "max_versions")
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			secret.maxVersions = maxVersions
+			secret.trim()
+		}
+		if raw, ok := req.data["cas_required"]; ok {
+			if secret.casRequired, err = parseutil.ParseBool(raw); err != nil {
+				return nil, errorf(http.StatusBadRequest, "invalid cas_required: %v", err)
+			}
+		}
+		return nil, nil
+
+	case "DELETE":
+		delete(b.secrets, path)
+		return nil, nil
+	}
+
+	return nil, errorf(http.StatusMethodNotAllowed, "unsupported operation")
+}
+
+func (b *kvV2Backend) handleVersions(s *Server, req *request, operation, path string) (*api.Secret, error) {
+	if req.method != "PUT" && req.method != "POST" {
+		return nil, errorf(http.StatusMethodNotAllowed, "unsupported operation")
+	}
+	if path == "" {
+		return nil, errorf(http.StatusBadRequest, "missing path")
+	}
+
+	rawVersions, _ := req.data["versions"].([]interface{})
+	if len(rawVersions) == 0 {
+		return nil, errorf(http.StatusBadRequest, "no version number provided")
+	}
+
+	secret := b.secrets[path]
+	if secret == nil {
+		return nil, nil
+	}
+
+	now := s.now()
+	for _, raw := range rawVersions {
+		version, err := parseutil.ParseInt(raw)
+		if err != nil {
+			return nil, errorf(http.StatusBadRequest, "invalid version: %v", err)
+		}
+		v, ok := secret.versions[int(version)]
+		if !ok {
+			continue
+		}
+
+		switch operation {
+		case "delete":
+			if v.deletionTime.IsZero() {
+				v.deletionTime = now
+			}
+		case "undelete":
+			if !v.destroyed {
+				v.deletionTime = time.Time{}
+			}
+		case "destroy":
+			v.destroyed = true
+			v.data = nil
+		}
+	}
+
+	return nil, nil
+}
+
+func copyData(data map[string]interface{}) map[string]interface{} {
+	copied := make(map[string]interface{}, len(data))
+	for k, v := range data {
+		copied[k] = v
+	}
+	return copied
+}
@@ -0,0 +1,183 @@
+package fake
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+)
+
+// LeasedSecret is a secret returned with a new lease on every read, like the
+// credentials of dynamic secrets engines
+type LeasedSecret struct {
+	// Data is the data of the secret
+	Data map[string]interface{}
+
+	// TTL is the TTL of the leases
+	TTL time.Duration
+
+	// MaxTTL limits the renewals of the leases, if set
+	MaxTTL time.Duration
+
+	// Renewable is whether the leases can be renewed
+	Renewable bool
+}
+
+type lease struct {
+	id         string
+	token      string
+	ttl        time.Duration
+	renewable  bool
+	issueTime  time.Time
+	expireTime time.Time
+	maxExpire  time.Time
+	lastRenew  time.Time
+}
+
+// SetLeasedSecret makes reads of path return the secret with a new lease
+func (s *Server) SetLeasedSecret(path string, secret *LeasedSecret) {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	copied := *secret
+	s.leasedSecrets[strings.Trim(path, "/")] = &copied
+}
+
+// LeaseIDs returns the IDs of the current leases, sorted
+func (s *Server) LeaseIDs() []string {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	now := s.now()
+	var ids []string
+	for id, l := range s.leases {
+		if now.Before(l.expireTime) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	return ids
+}
+
+func (s *Server) issueLease(req *request, secret *LeasedSecret) *api.Secret {
+	now := s.now()
+	l := &lease{
+		id:         req.path + "/" + mustUUID(),
+		token:      req.token.ID,
+		ttl:        secret.TTL,
+		renewable:  secret.Renewable,
+		issueTime:  now,
+		expireTime: now.Add(secret.TTL),
+	}
+	if secret.MaxTTL > 0 {
+		l.maxExpire = now.Add(secret.MaxTTL)
+	}
+	s.leases[l.id] = l
+
+	data := make(map[string]interface{}, len(secret.Data))
+	for k, v := range secret.Data {
+		data[k] = v
+	}
+
+	return &api.Secret{
+		LeaseID:       l.id,
+		LeaseDuration: int(secret.TTL.Seconds()),
+		Renewable:     secret.Renewable,
+		Data:          data,
+	}
+}
+
+func (s *Server) handleLeases(req *request) (*api.Secret, error) {
+	path := strings.TrimPrefix(req.path, "sys/")
+	path = strings.TrimPrefix(path, "leases/")
+
+	// The lease ID is given either in the path or in the body
+	leaseID, _ := req.data["lease_id"].(string)
+	operation := path
+	if i := strings.Index(path, "/"); i >= 0 {
+		operation, leaseID = path[:i], path[i+1:]
+	}
+
+	if leaseID == "" {
+		return nil, errorf(http.StatusBadRequest, "missing lease ID")
+	}
+
+	switch operation {
+	case "renew":
+		return s.renewLease(req, leaseID)
+
+	case "lookup":
+		l, err := s.lease(leaseID)
+		if err != nil {
+			return nil, err
+		}
+		now := s.now()
+		return &api.Secret{
+			Data: map[string]interface{}{
+				"id":           l.id,
+				"issue_time":   formatTime(l.issueTime),
+				"expire_time":  formatTime(l.expireTime),
+				"last_renewal": formatTime(l.lastRenew),
+				"renewable":    l.renewable,
+				"ttl":          int(l.expireTime.Sub(now).Seconds()),
+			},
+		}, nil
+
+	case "revoke":
+		delete(s.leases, leaseID)
+		return nil, nil
+
+	case "revoke-prefix", "revoke-force":
+		for id := range s.leases {
+			if strings.HasPrefix(id, leaseID) {
+				delete(s.leases, id)
+			}
+		}
+		return nil, nil
+	}
+
+	return nil, errorf(http.StatusNotFound, "no handler for route '%s'", req.path)
+}
+
+// lease returns the lease with the given ID, unless it expired
+func (s *Server) lease(leaseID string) (*lease, error) {
+	l, ok := s.leases[leaseID]
+	if !ok || !s.now().Before(l.expireTime) {
+		return nil, errorf(http.StatusBadRequest, "invalid lease")
+	}
+	return l, nil
+}
+
+func (s *Server) renewLease(req *request, leaseID string) (*api.Secret, error) {
+	l, err := s.lease(leaseID)
+	if err != nil {
+		return nil, err
+	}
+	if !l.renewable {
+		return nil, errorf(http.StatusBadRequest, "lease is not renewable")
+	}
+
+	increment, err := durationField(req.data, "increment")
+	if err != nil {
+		return nil, err
+	}
+	if increment == 0 {
+		increment = l.ttl
+	}
+
+	now := s.now()
+	l.expireTime = now.Add(increment)
+	if !l.maxExpire.IsZero() && l.expireTime.After(l.maxExpire) {
+		l.expireTime = l.maxExpire
+	}
+	l.lastRenew = now
+
+	return &api.Secret{
+		LeaseID:       l.id,
+		LeaseDuration: int(l.expireTime.Sub(now).Seconds()),
+		Renewable:     l.renewable,
+	}, nil
+}
@@ -0,0 +1,105 @@
+package fake
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/vault/api"
+)
+
+// backend is a fake secrets engine
+type backend interface {
+	// handleRequest handles a request to path, relative to the mount
+	handleRequest(s *Server, req *request, path string) (*api.Secret, error)
+}
+
+type mount struct {
+	path    string
+	typ     string
+	options map[string]string
+	backend backend
+}
+
+// MountKV mounts an empty KV secrets engine of the given version at path,
+// replacing any engine mounted there
+func (s *Server) MountKV(path string, version int) {
+	path = mountPath(path)
+
+	var b backend
+	switch version {
+	case 2:
+		b = newKVv2Backend()
+	default:
+		version = 1
+		b = newKVv1Backend()
+	}
+
+	s.mount(&mount{
+		path:    path,
+		typ:     "kv",
+		options: map[string]string{"version": strconv.Itoa(version)},
+		backend: b,
+	})
+}
+
+// MountTransit mounts a transit secrets engine without keys at path,
+// replacing any engine mounted there
+func (s *Server) MountTransit(path string) {
+	s.mount(&mount{
+		path:    mountPath(path),
+		typ:     "transit",
+		backend: newTransitBackend(),
+	})
+}
+
+func (s *Server) mount(m *mount) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	s.mounts[m.path] = m
+}
+
+// findMount returns the mount with the longest path prefixing path, and the
+// path relative to it
+func (s *Server) findMount(path string) (*mount, string) {
+	var found *mount
+	for p, m := range s.mounts {
+		if !strings.HasPrefix(path+"/", p) {
+			continue
+		}
+		if found == nil || len(p) > len(found.path) {
+			found = m
+		}
+	}
+	if found == nil {
+		return nil, ""
+	}
+
+	return found, strings.TrimSuffix(strings.TrimPrefix(path+"/", found.path), "/")
+}
+
+// handleMountLookup handles sys/internal/ui/mounts, used by clients to find
+// the version of KV mounts
+func (s *Server) handleMountLookup(req *request, path string) (*api.Secret, error) {
+	m, _ := s.findMount(path)
+	if m == nil {
+		return nil, errorf(http.StatusBadRequest, "no mount found at path %q", path)
+	}
+
+	options := make(map[string]interface{}, len(m.options))
+	for k, v := range m.options {
+		options[k] = v
+	}
+
+	return &api.Secret{
+		Data: map[string]interface{}{
+			"path":    m.path,
+			"type":    m.typ,
+			"options": options,
+		},
+	}, nil
+}
+
+func mountPath(path string) string {
+	return strings.Trim(path, "/") + "/"
+}
@@ -0,0 +1,529 @@
+// Package fake provides an in-process fake Vault server for unit testing code
+// built on the api package, without starting a Vault core.
+//
+// The server implements the common endpoints: the KV secrets engine (both
+// versions), token creation, lookup, renewal and revocation, lease renewal
+// and revocation, response wrapping, the transit encrypt and decrypt
+// endpoints, and sys/health. Policies aren't enforced; any valid token is
+// allowed to use every endpoint.
+//
+//	server := fake.NewServer(t)
+//	defer server.Close()
+//
+//	client := server.Client(t)
+//	server.AddFault(&fake.Fault{
+//		PathPrefix: "secret/",
+//		StatusCode: 503,
+//	})
+package fake
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	uuid "github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/helper/consts"
+	"github.com/hashicorp/vault/helper/parseutil"
+)
+
+// Server is a fake Vault server. Its state is kept in memory and is lost when
+// it is closed.
+type Server struct {
+	// URL is the address of the server
+	URL string
+
+	httpServer *httptest.Server
+
+	l             sync.Mutex
+	now           func() time.Time
+	latency       time.Duration
+	faults        []*Fault
+	rootToken     string
+	tokens        map[string]*token
+	mounts        map[string]*mount
+	leases        map[string]*lease
+	leasedSecrets map[string]*LeasedSecret
+	wrapped       map[string]*wrappedResponse
+}
+
+// Fault makes the server fail or delay the requests it matches
+type Fault struct {
+	// Method is the HTTP method to match, or "LIST". All the methods match
+	// if empty.
+	Method string
+
+	// PathPrefix is the prefix of the paths to match, without "/v1/"
+	PathPrefix string
+
+	// StatusCode is the status code of the error response. Matching
+	// requests are only delayed if it is zero.
+	StatusCode int
+
+	// Errors are the errors of the error response
+	Errors []string
+
+	// Latency is added before responding to matching requests
+	Latency time.Duration
+
+	// Count is the number of requests the fault applies to, after which it
+	// is removed. It applies to all requests if zero.
+	Count int
+}
+
+// NewServer starts a fake server. The KV v2 secrets engine is mounted at
+// "secret/", and the transit secrets engine at "transit/".
+func NewServer(t testing.TB) *Server {
+	t.Helper()
+
+	rootToken, err := uuid.GenerateUUID()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Server{
+		now:           time.Now,
+		rootToken:     rootToken,
+		tokens:        make(map[string]*token),
+		mounts:        make(map[string]*mount),
+		leases:        make(map[string]*lease),
+		leasedSecrets: make(map[string]*LeasedSecret),
+		wrapped:       make(map[string]*wrappedResponse),
+	}
+	s.tokens[rootToken] = &token{
+		ID:           rootToken,
+		Accessor:     mustUUID(),
+		Policies:     []string{"root"},
+		CreationTime: s.now(),
+	}
+	s.MountKV("secret", 2)
+	s.MountTransit("transit")
+
+	s.httpServer = httptest.NewServer(s)
+	s.URL = s.httpServer.URL
+
+	return s
+}
+
+// Close shuts down the server
+func (s *Server) Close() {
+	s.httpServer.Close()
+}
+
+// Client returns a client for the server, authenticated with the root token.
+// Retries are disabled so that injected faults are seen by the caller.
+func (s *Server) Client(t testing.TB) *api.Client {
+	t.Helper()
+
+	config := api.DefaultConfig()
+	config.Address = s.URL
+	config.MaxRetries = 0
+	client, err := api.NewClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetToken(s.rootToken)
+
+	return client
+}
+
+// RootToken returns the root token of the server
+func (s *Server) RootToken() string {
+	return s.rootToken
+}
+
+// SetNow replaces the clock of the server, used for the expiration of tokens,
+// leases and wrapping tokens
+func (s *Server) SetNow(now func() time.Time) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	s.now = now
+}
+
+// SetLatency sets the latency added to every request
+func (s *Server) SetLatency(latency time.Duration) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	s.latency = latency
+}
+
+// AddFault adds a fault. Faults are matched in the order they were added,
+// and only the first matching fault applies.
+func (s *Server) AddFault(fault *Fault) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	copied := *fault
+	s.faults = append(s.faults, &copied)
+}
+
+// ClearFaults removes all the faults
+func (s *Server) ClearFaults() {
+	s.l.Lock()
+	defer s.l.Unlock()
+	s.faults = nil
+}
+
+// request is a parsed request to the server
+type request struct {
+	method  string
+	path    string
+	token   *token
+	data    map[string]interface{}
+	params  url.Values
+	wrapTTL time.Duration
+	raw     *http.Request
+}
+
+// responseError is returned by the handlers to respond with an error
+type responseError struct {
+	statusCode int
+	errors     []string
+}
+
+func (e *responseError) Error() string {
+	return strings.Join(e.errors, ", ")
+}
+
+func errorf(statusCode int, format string, args ...interface{}) error {
+	return &responseError{
+		statusCode: statusCode,
+		errors:     []string{fmt.Sprintf(format, args...)},
+	}
+}
+
+var (
+	errPermissionDenied = errorf(http.StatusForbidden, "permission denied")
+	errNotFound         = &responseError{statusCode: http.StatusNotFound, errors: []string{}}
+)
+
+// notFoundWithData is returned by the handlers to respond with a 404 status
+// code and a body, as Vault does for deleted KV v2 versions
+type notFoundWithData struct {
+	secret *api.Secret
+}
+
+func (e *notFoundWithData) Error() string {
+	return "not found"
+}
+
+// ServeHTTP implements http.Handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.URL.Path, "/v1/") {
+		respondError(w, errNotFound)
+		return
+	}
+
+	req := &request{
+		method: r.Method,
+		path:   strings.TrimPrefix(r.URL.Path, "/v1/"),
+		params: r.URL.Query(),
+		raw:    r,
+	}
+	if req.method == "GET" && req.params.Get("list") == "true" {
+		req.method = "LIST"
+	}
+
+	fault := s.matchFault(req)
+	if err := s.delay(r, fault); err != nil {
+		return
+	}
+	if fault != nil && fault.StatusCode != 0 {
+		respondError(w, &responseError{statusCode: fault.StatusCode, errors: fault.Errors})
+		return
+	}
+
+	if req.path == "sys/health" {
+		s.handleHealth(w, req)
+		return
+	}
+
+	if err := parseBody(r.Body, req); err != nil {
+		respondError(w, errorf(http.StatusBadRequest, "failed to parse JSON input: %v", err))
+		return
+	}
+	if wrapTTL := r.Header.Get("X-Vault-Wrap-TTL"); wrapTTL != "" {
+		ttl, err := parseutil.ParseDurationSecond(wrapTTL)
+		if err != nil {
+			respondError(w, errorf(http.StatusBadRequest, "error parsing wrap TTL: %v", err))
+			return
+		}
+		req.wrapTTL = ttl
+	}
+
+	s.l.Lock()
+	secret, err := s.handleRequest(req)
+	s.l.Unlock()
+
+	switch err := err.(type) {
+	case nil:
+	case *notFoundWithData:
+		respond(w, http.StatusNotFound, err.secret)
+		return
+	default:
+		respondError(w, err)
+		return
+	}
+
+	if secret == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	respond(w, http.StatusOK, secret)
+}
+
+// matchFault returns the fault applying to the request, if any
+func (s *Server) matchFault(req *request) *Fault {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	for i, fault := range s.faults {
+		if fault.Method != "" && fault.Method != req.method {
+			continue
+		}
+		if !strings.HasPrefix(req.path, fault.PathPrefix) {
+			continue
+		}
+
+		matched := *fault
+		if fault.Count > 0 {
+			fault.Count--
+			if fault.Count == 0 {
+				s.faults = append(s.faults[:i], s.faults[i+1:]...)
+			}
+		}
+		return &matched
+	}
+
+	return nil
+}
+
+// delay waits for the latency of the server and of the fault, or until the
+// request is canceled
+func (s *Server) delay(r *http.Request, fault *Fault) error {
+	s.l.Lock()
+	latency := s.latency
+	s.l.Unlock()
+	if fault != nil {
+		latency += fault.Latency
+	}
+	if latency == 0 {
+		return nil
+	}
+
+	select {
+	case <-r.Context().Done():
+		return r.Context().Err()
+	case <-time.After(latency):
+		return nil
+	}
+}
+
+// handleRequest authenticates and routes the request, and wraps the response
+// if requested
+func (s *Server) handleRequest(req *request) (*api.Secret, error) {
+	if strings.HasPrefix(req.path, "sys/wrapping/") {
+		return s.handleWrapping(req)
+	}
+
+	if err := s.authenticate(req); err != nil {
+		return nil, err
+	}
+
+	secret, err := s.route(req)
+	if err != nil || secret == nil || req.wrapTTL == 0 {
+		return secret, err
+	}
+
+	return s.wrap(req, secret)
+}
+
+// authenticate sets the token of the request, or returns an error if the
+// token isn't valid
+func (s *Server) authenticate(req *request) error {
+	id := req.raw.Header.Get(consts.AuthHeaderName)
+	if id == "" {
+		return errorf(http.StatusBadRequest, "missing client token")
+	}
+
+	t, ok := s.tokens[id]
+	if !ok || t.expired(s.now()) {
+		return errPermissionDenied
+	}
+	req.token = t
+	return nil
+}
+
+func (s *Server) route(req *request) (*api.Secret, error) {
+	switch {
+	case strings.HasPrefix(req.path, "auth/token/"):
+		return s.handleToken(req, strings.TrimPrefix(req.path, "auth/token/"))
+
+	case strings.HasPrefix(req.path, "sys/internal/ui/mounts/"):
+		return s.handleMountLookup(req, strings.TrimPrefix(req.path, "sys/internal/ui/mounts/"))
+
+	case strings.HasPrefix(req.path, "sys/leases/"), strings.HasPrefix(req.path, "sys/renew"), strings.HasPrefix(req.path, "sys/revoke"):
+		return s.handleLeases(req)
+	}
+
+	if secret, ok := s.leasedSecrets[req.path]; ok && req.method == "GET" {
+		return s.issueLease(req, secret), nil
+	}
+
+	if m, subpath := s.findMount(req.path); m != nil {
+		return m.backend.handleRequest(s, req, subpath)
+	}
+
+	return nil, errorf(http.StatusNotFound, "no handler for route '%s'", req.path)
+}
+
+func (s *Server) handleHealth(w http.ResponseWriter, req *request) {
+	s.l.Lock()
+	now := s.now()
+	s.l.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(&api.HealthResponse{
+		Initialized:   true,
+		ServerTimeUTC: now.UTC().Unix(),
+		Version:       "fake",
+		ClusterName:   "fake",
+	})
+}
+
+func parseBody(body io.Reader, req *request) error {
+	dec := json.NewDecoder(body)
+	dec.UseNumber()
+	err := dec.Decode(&req.data)
+	if err == io.EOF {
+		err = nil
+	}
+	if req.data == nil {
+		req.data = make(map[string]interface{})
+	}
+	return err
+}
+
+func respond(w http.ResponseWriter, statusCode int, secret *api.Secret) {
+	if secret.RequestID == "" {
+		secret.RequestID = mustUUID()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(secret)
+}
+
+func respondError(w http.ResponseWriter, err error) {
+	respErr, ok := err.(*responseError)
+	if !ok {
+		respErr = &responseError{
+			statusCode: http.StatusInternalServerError,
+			errors:     []string{err.Error()},
+		}
+	}
+	if respErr.errors == nil {
+		respErr.errors = []string{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(respErr.statusCode)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"errors": respErr.errors,
+	})
+}
+
+// listKeys returns the keys directly under prefix, with a trailing slash for
+// the directories, as returned by LIST requests
+func listKeys(paths []string, prefix string) []string {
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	seen := make(map[string]bool)
+	var keys []string
+	for _, p := range paths {
+		if !strings.HasPrefix(p, prefix) {
+			continue
+		}
+		key := strings.TrimPrefix(p, prefix)
+		if i := strings.Index(key, "/"); i >= 0 {
+			key = key[:i+1]
+		}
+		if !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// listResponse returns the response to a LIST request, which is a 404 if
+// there are no keys
+func listResponse(keys []string) (*api.Secret, error) {
+	if len(keys) == 0 {
+		return nil, errNotFound
+	}
+
+	raw := make([]interface{}, len(keys))
+	for i, key := range keys {
+		raw[i] = key
+	}
+	return &api.Secret{
+		Data: map[string]interface{}{
+			"keys": raw,
+		},
+	}, nil
+}
+
+// durationField parses the duration at key in data, in seconds or as a
+// duration string
+func durationField(data map[string]interface{}, key string) (time.Duration, error) {
+	raw, ok := data[key]
+	if !ok {
+		return 0, nil
+	}
+	d, err := parseutil.ParseDurationSecond(raw)
+	if err != nil {
+		return 0, errorf(http.StatusBadRequest, "invalid %s: %v", key, err)
+	}
+	return d, nil
+}
+
+// intField parses the integer at key in data
+func intField(data map[string]interface{}, key string) (int, bool, error) {
+	raw, ok := data[key]
+	if !ok {
+		return 0, false, nil
+	}
+	i, err := parseutil.ParseInt(raw)
+	if err != nil {
+		return 0, false, errorf(http.StatusBadRequest, "invalid %s: %v", key, err)
+	}
+	return int(i), true, nil
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+func mustUUID() string {
+	id, err := uuid.GenerateUUID()
+	if err != nil {
+		panic(err)
+	}
+	return id
+}
@@ -0,0 +1,362 @@
+package fake
+
+import (
+	"context"
+	"encoding/base64"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+)
+
+func TestServer_KV(t *testing.T) {
+	s := NewServer(t)
+	defer s.Close()
+	s.MountKV("kv", 1)
+
+	client := s.Client(t)
+
+	for mount, version := range map[string]int{"kv": 1, "secret": 2} {
+		_, mountVersion, err := client.KVMountVersion(mount + "/foo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mountVersion != version {
+			t.Fatalf("%s: expected version %d, got %d", mount, version, mountVersion)
+		}
+	}
+
+	kv1 := client.KVv1("kv")
+	if _, err := kv1.Put("foo/bar", map[string]interface{}{"a": "1"}); err != nil {
+		t.Fatal(err)
+	}
+	secret, err := kv1.Get("foo/bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(secret.Data, map[string]interface{}{"a": "1"}) {
+		t.Fatalf("bad data: %v", secret.Data)
+	}
+	list, err := client.Logical().List("kv/foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(list.Data["keys"], []interface{}{"bar"}) {
+		t.Fatalf("bad keys: %v", list.Data["keys"])
+	}
+	if err := kv1.Delete("foo/bar"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := kv1.Get("foo/bar"); err != api.ErrSecretNotFound {
+		t.Fatalf("expected ErrSecretNotFound, got %v", err)
+	}
+
+	kv2 := client.KVv2("secret")
+	if _, err := kv2.Put("foo", map[string]interface{}{"a": "1"}, api.WithCheckAndSet(0)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := kv2.Put("foo", map[string]interface{}{"a": "2"}, api.WithCheckAndSet(0)); err == nil {
+		t.Fatal("expected a check-and-set failure")
+	}
+	if _, err := kv2.Patch("foo", map[string]interface{}{"b": "2"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := kv2.Delete("foo"); err != nil {
+		t.Fatal(err)
+	}
+	secret, err = kv2.Get("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret.Data != nil || !secret.VersionMetadata.Deleted() {
+		t.Fatalf("expected a deleted version: %#v", secret)
+	}
+	secret, err = kv2.Rollback("foo", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret.VersionMetadata.Version != 3 {
+		t.Fatalf("expected version 3, got %d", secret.VersionMetadata.Version)
+	}
+	versions, err := kv2.Versions("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 3 || !versions[1].Deleted() {
+		t.Fatalf("bad versions: %#v", versions)
+	}
+}
+
+func TestServer_Tokens(t *testing.T) {
+	s := NewServer(t)
+	defer s.Close()
+
+	now := time.Now()
+	var nowLock sync.Mutex
+	s.SetNow(func() time.Time {
+		nowLock.Lock()
+		defer nowLock.Unlock()
+		return now
+	})
+	advance := func(d time.Duration) {
+		nowLock.Lock()
+		defer nowLock.Unlock()
+		now = now.Add(d)
+	}
+
+	client := s.Client(t)
+	secret, err := client.Auth().Token().Create(&api.TokenCreateRequest{
+		Policies:       []string{"app"},
+		TTL:            "1h",
+		ExplicitMaxTTL: "2h",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret.Auth.LeaseDuration != 3600 || !secret.Auth.Renewable {
+		t.Fatalf("bad auth: %#v", secret.Auth)
+	}
+
+	tokenClient := s.Client(t)
+	tokenClient.SetToken(secret.Auth.ClientToken)
+	lookup, err := tokenClient.Auth().Token().LookupSelf()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if policies, _ := lookup.TokenPolicies(); !reflect.DeepEqual(policies, []string{"app"}) {
+		t.Fatalf("bad policies: %v", policies)
+	}
+
+	// Renewals are capped by the explicit max TTL
+	advance(45 * time.Minute)
+	secret, err = tokenClient.Auth().Token().RenewSelf(7200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret.Auth.LeaseDuration != 4500 {
+		t.Fatalf("expected a TTL of 4500, got %d", secret.Auth.LeaseDuration)
+	}
+
+	advance(2 * time.Hour)
+	if _, err := tokenClient.Auth().Token().LookupSelf(); err == nil {
+		t.Fatal("expected an error with an expired token")
+	}
+}
+
+func TestServer_Leases(t *testing.T) {
+	s := NewServer(t)
+	defer s.Close()
+
+	s.SetLeasedSecret("database/creds/app", &LeasedSecret{
+		Data:      map[string]interface{}{"username": "app"},
+		TTL:       time.Hour,
+		MaxTTL:    90 * time.Minute,
+		Renewable: true,
+	})
+
+	client := s.Client(t)
+	secret, err := client.Logical().Read("database/creds/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(secret.LeaseID, "database/creds/app/") || secret.LeaseDuration != 3600 {
+		t.Fatalf("bad secret: %#v", secret)
+	}
+
+	renewal, err := client.Sys().Renew(secret.LeaseID, 7200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if renewal.LeaseDuration > 5400 || renewal.LeaseDuration < 5390 {
+		t.Fatalf("expected the max TTL to apply, got %d", renewal.LeaseDuration)
+	}
+
+	if _, err := client.Logical().Read("database/creds/app"); err != nil {
+		t.Fatal(err)
+	}
+	if ids := s.LeaseIDs(); len(ids) != 2 {
+		t.Fatalf("expected 2 leases, got %v", ids)
+	}
+	if err := client.Sys().Revoke(secret.LeaseID); err != nil {
+		t.Fatal(err)
+	}
+	if ids := s.LeaseIDs(); len(ids) != 1 {
+		t.Fatalf("expected 1 lease, got %v", ids)
+	}
+	if err := client.Sys().RevokePrefix("database/creds/app"); err != nil {
+		t.Fatal(err)
+	}
+	if ids := s.LeaseIDs(); len(ids) != 0 {
+		t.Fatalf("expected no leases, got %v", ids)
+	}
+	if _, err := client.Sys().Renew(secret.LeaseID, 0); err == nil {
+		t.Fatal("expected an error renewing a revoked lease")
+	}
+}
+
+func TestServer_Wrapping(t *testing.T) {
+	s := NewServer(t)
+	defer s.Close()
+
+	client := s.Client(t)
+	if _, err := client.Logical().Write("secret/data/foo", map[string]interface{}{
+		"data": map[string]interface{}{"a": "1"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	client.SetWrappingLookupFunc(func(string, string) string { return "5m" })
+	wrapped, err := client.Logical().Read("secret/data/foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetWrappingLookupFunc(nil)
+	if wrapped.WrapInfo == nil || wrapped.WrapInfo.TTL != 300 || wrapped.WrapInfo.CreationPath != "secret/data/foo" {
+		t.Fatalf("bad wrap info: %#v", wrapped.WrapInfo)
+	}
+
+	// Unwrap with the wrapping token as the client token
+	unwrapClient := s.Client(t)
+	unwrapClient.SetToken("")
+	secret, err := unwrapClient.Logical().Unwrap(wrapped.WrapInfo.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := secret.Data["data"].(map[string]interface{})
+	if data["a"] != "1" {
+		t.Fatalf("bad unwrapped data: %v", secret.Data)
+	}
+	if _, err := client.Logical().Unwrap(wrapped.WrapInfo.Token); err == nil {
+		t.Fatal("expected an error unwrapping twice")
+	}
+
+	// Wrap arbitrary data, which uses the default wrapping TTL
+	wrapped, err = client.Logical().Write("sys/wrapping/wrap", map[string]interface{}{"b": "2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, err = client.Logical().Unwrap(wrapped.WrapInfo.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret.Data["b"] != "2" {
+		t.Fatalf("bad unwrapped data: %v", secret.Data)
+	}
+}
+
+func TestServer_Transit(t *testing.T) {
+	s := NewServer(t)
+	defer s.Close()
+
+	client := s.Client(t)
+	plaintext := base64.StdEncoding.EncodeToString([]byte("hello"))
+	secret, err := client.Logical().Write("transit/encrypt/app", map[string]interface{}{
+		"plaintext": plaintext,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphertext := secret.Data["ciphertext"].(string)
+	if !strings.HasPrefix(ciphertext, "vault:v1:") {
+		t.Fatalf("bad ciphertext: %s", ciphertext)
+	}
+
+	if _, err := client.Logical().Write("transit/keys/app/rotate", nil); err != nil {
+		t.Fatal(err)
+	}
+	secret, err = client.Logical().Write("transit/encrypt/app", map[string]interface{}{
+		"plaintext": plaintext,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(secret.Data["ciphertext"].(string), "vault:v2:") {
+		t.Fatalf("expected the rotated key to be used: %v", secret.Data["ciphertext"])
+	}
+
+	secret, err = client.Logical().Write("transit/decrypt/app", map[string]interface{}{
+		"ciphertext": ciphertext,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret.Data["plaintext"] != plaintext {
+		t.Fatalf("bad plaintext: %v", secret.Data["plaintext"])
+	}
+
+	if _, err := client.Logical().Write("transit/decrypt/app", map[string]interface{}{
+		"ciphertext": "vault:v1:AAAA",
+	}); err == nil {
+		t.Fatal("expected an error with a bad ciphertext")
+	}
+}
+
+func TestServer_Faults(t *testing.T) {
+	s := NewServer(t)
+	defer s.Close()
+
+	client := s.Client(t)
+	health, err := client.Sys().Health()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !health.Initialized || health.Sealed {
+		t.Fatalf("bad health: %#v", health)
+	}
+
+	if _, err := client.Logical().Read("secret/data/foo"); err != nil {
+		t.Fatal(err)
+	}
+	client.SetToken("invalid")
+	if _, err := client.Logical().Read("secret/data/foo"); err == nil || !strings.Contains(err.Error(), "permission denied") {
+		t.Fatalf("expected a permission denied error, got %v", err)
+	}
+	client.SetToken(s.RootToken())
+
+	s.AddFault(&Fault{
+		Method:     "GET",
+		PathPrefix: "secret/",
+		StatusCode: 503,
+		Errors:     []string{"Vault is sealed"},
+		Count:      1,
+	})
+	if _, err := client.Logical().Read("secret/data/foo"); err == nil || !strings.Contains(err.Error(), "Vault is sealed") {
+		t.Fatalf("expected the injected error, got %v", err)
+	}
+	if _, err := client.Logical().Read("secret/data/foo"); err != nil {
+		t.Fatalf("expected the fault to be removed, got %v", err)
+	}
+
+	s.AddFault(&Fault{
+		PathPrefix: "sys/health",
+		Latency:    time.Second,
+	})
+	client.SetClientTimeout(100 * time.Millisecond)
+	if _, err := client.Sys().Health(); err == nil {
+		t.Fatal("expected a timeout")
+	}
+	s.ClearFaults()
+	if _, err := client.Sys().Health(); err != nil {
+		t.Fatal(err)
+	}
+
+	s.SetLatency(50 * time.Millisecond)
+	start := time.Now()
+	if _, err := client.Sys().Health(); err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) < 50*time.Millisecond {
+		t.Fatal("expected the latency to apply")
+	}
+
+	// Canceled requests don't wait for the latency
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	s.SetLatency(time.Minute)
+	if _, err := client.RawRequestWithContext(ctx, client.NewRequest("GET", "/v1/sys/health")); err == nil {
+		t.Fatal("expected the request to be canceled")
+	}
+}
@@ -0,0 +1,258 @@
+package fake
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/helper/parseutil"
+)
+
+// defaultTokenTTL is the TTL of tokens created without one
+const defaultTokenTTL = 768 * time.Hour
+
+type token struct {
+	ID             string
+	Accessor       string
+	Policies       []string
+	Meta           map[string]string
+	DisplayName    string
+	Renewable      bool
+	NumUses        int
+	CreationTime   time.Time
+	CreationTTL    time.Duration
+	ExplicitMaxTTL time.Duration
+	ExpireTime     time.Time
+}
+
+func (t *token) expired(now time.Time) bool {
+	return !t.ExpireTime.IsZero() && !now.Before(t.ExpireTime)
+}
+
+func (t *token) auth(now time.Time) *api.SecretAuth {
+	return &api.SecretAuth{
+		ClientToken:   t.ID,
+		Accessor:      t.Accessor,
+		Policies:      t.Policies,
+		TokenPolicies: t.Policies,
+		Metadata:      t.Meta,
+		LeaseDuration: t.ttlSeconds(now),
+		Renewable:     t.Renewable,
+	}
+}
+
+func (t *token) ttlSeconds(now time.Time) int {
+	if t.ExpireTime.IsZero() {
+		return 0
+	}
+	return int(t.ExpireTime.Sub(now).Seconds())
+}
+
+func (t *token) lookup(now time.Time) *api.Secret {
+	policies := make([]interface{}, len(t.Policies))
+	for i, policy := range t.Policies {
+		policies[i] = policy
+	}
+	meta := make(map[string]interface{}, len(t.Meta))
+	for k, v := range t.Meta {
+		meta[k] = v
+	}
+
+	var expireTime interface{}
+	if !t.ExpireTime.IsZero() {
+		expireTime = formatTime(t.ExpireTime)
+	}
+
+	return &api.Secret{
+		Data: map[string]interface{}{
+			"id":               t.ID,
+			"accessor":         t.Accessor,
+			"policies":         policies,
+			"meta":             meta,
+			"display_name":     t.DisplayName,
+			"renewable":        t.Renewable,
+			"num_uses":         t.NumUses,
+			"creation_time":    t.CreationTime.Unix(),
+			"creation_ttl":     int(t.CreationTTL.Seconds()),
+			"explicit_max_ttl": int(t.ExplicitMaxTTL.Seconds()),
+			"expire_time":      expireTime,
+			"ttl":              t.ttlSeconds(now),
+			"path":             "auth/token/create",
+		},
+	}
+}
+
+// CreateToken creates a token with the given policies and TTL, which doesn't
+// expire if zero, and returns its ID
+func (s *Server) CreateToken(policies []string, ttl time.Duration, renewable bool) string {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	return s.createToken(policies, ttl, 0, renewable).ID
+}
+
+func (s *Server) createToken(policies []string, ttl, explicitMaxTTL time.Duration, renewable bool) *token {
+	now := s.now()
+	t := &token{
+		ID:             mustUUID(),
+		Accessor:       mustUUID(),
+		Policies:       policies,
+		DisplayName:    "token",
+		Renewable:      renewable,
+		CreationTime:   now,
+		CreationTTL:    ttl,
+		ExplicitMaxTTL: explicitMaxTTL,
+	}
+	if ttl > 0 {
+		t.ExpireTime = now.Add(ttl)
+	}
+	s.tokens[t.ID] = t
+
+	return t
+}
+
+// revokeToken revokes the token and the leases created with it
+func (s *Server) revokeToken(t *token) {
+	delete(s.tokens, t.ID)
+	for id, l := range s.leases {
+		if l.token == t.ID {
+			delete(s.leases, id)
+		}
+	}
+}
+
+func (s *Server) handleToken(req *request, path string) (*api.Secret, error) {
+	now := s.now()
+
+	switch {
+	case path == "create" || path == "create-orphan":
+		if req.method != "POST" && req.method != "PUT" {
+			return nil, errorf(http.StatusMethodNotAllowed, "unsupported operation")
+		}
+		return s.handleTokenCreate(req)
+
+	case path == "lookup-self":
+		return req.token.lookup(now), nil
+
+	case path == "lookup":
+		t, err := s.requestToken(req)
+		if err != nil {
+			return nil, err
+		}
+		return t.lookup(now), nil
+
+	case path == "renew-self":
+		return s.renewToken(req, req.token)
+
+	case path == "renew":
+		t, err := s.requestToken(req)
+		if err != nil {
+			return nil, err
+		}
+		return s.renewToken(req, t)
+
+	case path == "revoke-self":
+		s.revokeToken(req.token)
+		return nil, nil
+
+	case path == "revoke":
+		t, err := s.requestToken(req)
+		if err != nil {
+			return nil, err
+		}
+		s.revokeToken(t)
+		return nil, nil
+	}
+
+	return nil, errorf(http.StatusNotFound, "no handler for route 'auth/token/%s'", path)
+}
+
+func (s *Server) handleTokenCreate(req *request) (*api.Secret, error) {
+	policies := req.token.Policies
+	if raw, ok := req.data["policies"]; ok {
+		var err error
+		if policies, err = parseutil.ParseCommaStringSlice(raw); err != nil {
+			return nil, errorf(http.StatusBadRequest, "invalid policies: %v", err)
+		}
+	}
+
+	ttl, err := durationField(req.data, "ttl")
+	if err != nil {
+		return nil, err
+	}
+	if ttl == 0 {
+		ttl = defaultTokenTTL
+	}
+	explicitMaxTTL, err := durationField(req.data, "explicit_max_ttl")
+	if err != nil {
+		return nil, err
+	}
+	if explicitMaxTTL > 0 && ttl > explicitMaxTTL {
+		ttl = explicitMaxTTL
+	}
+
+	renewable := true
+	if raw, ok := req.data["renewable"]; ok {
+		if renewable, err = parseutil.ParseBool(raw); err != nil {
+			return nil, errorf(http.StatusBadRequest, "invalid renewable: %v", err)
+		}
+	}
+
+	t := s.createToken(policies, ttl, explicitMaxTTL, renewable)
+	if t.NumUses, _, err = intField(req.data, "num_uses"); err != nil {
+		return nil, err
+	}
+	if displayName, ok := req.data["display_name"].(string); ok {
+		t.DisplayName = "token-" + displayName
+	}
+	if meta, ok := req.data["meta"].(map[string]interface{}); ok {
+		t.Meta = make(map[string]string, len(meta))
+		for k, v := range meta {
+			t.Meta[k], _ = v.(string)
+		}
+	}
+
+	return &api.Secret{
+		Auth: t.auth(s.now()),
+	}, nil
+}
+
+// requestToken returns the token given in the body of the request
+func (s *Server) requestToken(req *request) (*token, error) {
+	id, _ := req.data["token"].(string)
+	if id == "" {
+		return nil, errorf(http.StatusBadRequest, "missing token")
+	}
+	t, ok := s.tokens[id]
+	if !ok || t.expired(s.now()) {
+		return nil, errorf(http.StatusBadRequest, "bad token")
+	}
+	return t, nil
+}
+
+func (s *Server) renewToken(req *request, t *token) (*api.Secret, error) {
+	now := s.now()
+	if !t.Renewable || t.ExpireTime.IsZero() {
+		return nil, errorf(http.StatusBadRequest, "lease is not renewable")
+	}
+
+	increment, err := durationField(req.data, "increment")
+	if err != nil {
+		return nil, err
+	}
+	if increment == 0 {
+		increment = t.CreationTTL
+	}
+
+	expireTime := now.Add(increment)
+	if t.ExplicitMaxTTL > 0 {
+		if maxExpireTime := t.CreationTime.Add(t.ExplicitMaxTTL); expireTime.After(maxExpireTime) {
+			expireTime = maxExpireTime
+		}
+	}
+	t.ExpireTime = expireTime
+
+	return &api.Secret{
+		Auth: t.auth(now),
+	}, nil
+}
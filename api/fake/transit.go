@@ -0,0 +1,209 @@
+package fake
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+)
+
+// transitBackend is a fake transit secrets engine, supporting aes256-gcm96
+// keys only
+type transitBackend struct {
+	keys map[string]*transitKey
+}
+
+type transitKey struct {
+	versions     [][]byte
+	creationTime []time.Time
+}
+
+func newTransitBackend() *transitBackend {
+	return &transitBackend{
+		keys: make(map[string]*transitKey),
+	}
+}
+
+func (b *transitBackend) handleRequest(s *Server, req *request, path string) (*api.Secret, error) {
+	operation, name := path, ""
+	if i := strings.Index(path, "/"); i >= 0 {
+		operation, name = path[:i], strings.Trim(path[i+1:], "/")
+	}
+
+	switch {
+	case operation == "keys" && req.method == "LIST":
+		names := make([]string, 0, len(b.keys))
+		for name := range b.keys {
+			names = append(names, name)
+		}
+		return listResponse(listKeys(names, ""))
+
+	case name == "":
+		return nil, errorf(http.StatusBadRequest, "missing key name")
+
+	case operation == "keys" && strings.HasSuffix(name, "/rotate"):
+		key, ok := b.keys[strings.TrimSuffix(name, "/rotate")]
+		if !ok {
+			return nil, errorf(http.StatusBadRequest, "encryption key not found")
+		}
+		return nil, key.rotate(s.now())
+
+	case operation == "keys":
+		return b.handleKeys(s, req, name)
+
+	case operation == "encrypt":
+		return b.encrypt(s, req, name)
+
+	case operation == "decrypt":
+		return b.decrypt(req, name)
+	}
+
+	return nil, errorf(http.StatusNotFound, "no handler for route '%s'", req.path)
+}
+
+func (b *transitBackend) handleKeys(s *Server, req *request, name string) (*api.Secret, error) {
+	switch req.method {
+	case "GET":
+		key, ok := b.keys[name]
+		if !ok {
+			return nil, errNotFound
+		}
+		versions := make(map[string]interface{}, len(key.versions))
+		for i, t := range key.creationTime {
+			versions[strconv.Itoa(i+1)] = t.Unix()
+		}
+		return &api.Secret{
+			Data: map[string]interface{}{
+				"name":                   name,
+				"type":                   "aes256-gcm96",
+				"keys":                   versions,
+				"latest_version":         len(key.versions),
+				"min_decryption_version": 1,
+				"deletion_allowed":       false,
+				"derived":                false,
+				"exportable":             false,
+				"supports_encryption":    true,
+				"supports_decryption":    true,
+			},
+		}, nil
+
+	case "PUT", "POST":
+		if keyType, ok := req.data["type"].(string); ok && keyType != "aes256-gcm96" {
+			return nil, errorf(http.StatusBadRequest, "unsupported key type %q", keyType)
+		}
+		if _, ok := b.keys[name]; !ok {
+			key := &transitKey{}
+			if err := key.rotate(s.now()); err != nil {
+				return nil, err
+			}
+			b.keys[name] = key
+		}
+		return nil, nil
+
+	case "DELETE":
+		delete(b.keys, name)
+		return nil, nil
+	}
+
+	return nil, errorf(http.StatusMethodNotAllowed, "unsupported operation")
+}
+
+// encrypt encrypts the plaintext, creating the key if it doesn't exist
+func (b *transitBackend) encrypt(s *Server, req *request, name string) (*api.Secret, error) {
+	raw, _ := req.data["plaintext"].(string)
+	plaintext, err := base64.StdEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, errorf(http.StatusBadRequest, "failed to base64-decode plaintext")
+	}
+
+	key, ok := b.keys[name]
+	if !ok {
+		key = &transitKey{}
+		if err := key.rotate(s.now()); err != nil {
+			return nil, err
+		}
+		b.keys[name] = key
+	}
+
+	version := len(key.versions)
+	gcm, err := newGCM(key.versions[version-1])
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	ciphertext := gcm.Seal(nonce, nonce, plaintext, nil)
+
+	return &api.Secret{
+		Data: map[string]interface{}{
+			"ciphertext": fmt.Sprintf("vault:v%d:%s", version, base64.StdEncoding.EncodeToString(ciphertext)),
+		},
+	}, nil
+}
+
+func (b *transitBackend) decrypt(req *request, name string) (*api.Secret, error) {
+	key, ok := b.keys[name]
+	if !ok {
+		return nil, errorf(http.StatusBadRequest, "encryption key not found")
+	}
+
+	raw, _ := req.data["ciphertext"].(string)
+	parts := strings.SplitN(raw, ":", 3)
+	if len(parts) != 3 || parts[0] != "vault" || !strings.HasPrefix(parts[1], "v") {
+		return nil, errorf(http.StatusBadRequest, "invalid ciphertext: no prefix")
+	}
+	version, err := strconv.Atoi(strings.TrimPrefix(parts[1], "v"))
+	if err != nil || version < 1 || version > len(key.versions) {
+		return nil, errorf(http.StatusBadRequest, "invalid key version")
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errorf(http.StatusBadRequest, "invalid ciphertext: could not decode base64")
+	}
+
+	gcm, err := newGCM(key.versions[version-1])
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < gcm.NonceSize() {
+		return nil, errorf(http.StatusBadRequest, "invalid ciphertext: unable to decrypt")
+	}
+	plaintext, err := gcm.Open(nil, ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, errorf(http.StatusBadRequest, "invalid ciphertext: unable to decrypt")
+	}
+
+	return &api.Secret{
+		Data: map[string]interface{}{
+			"plaintext": base64.StdEncoding.EncodeToString(plaintext),
+		},
+	}, nil
+}
+
+// rotate adds a new version of the key
+func (k *transitKey) rotate(now time.Time) error {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return err
+	}
+	k.versions = append(k.versions, key)
+	k.creationTime = append(k.creationTime, now)
+	return nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,109 @@
+package fake
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/helper/consts"
+)
+
+type wrappedResponse struct {
+	secret       *api.Secret
+	accessor     string
+	creationTime time.Time
+	creationPath string
+	ttl          time.Duration
+}
+
+func (w *wrappedResponse) expired(now time.Time) bool {
+	return !now.Before(w.creationTime.Add(w.ttl))
+}
+
+// wrap stores the response and returns a response with its wrapping token
+func (s *Server) wrap(req *request, secret *api.Secret) (*api.Secret, error) {
+	w := &wrappedResponse{
+		secret:       secret,
+		accessor:     mustUUID(),
+		creationTime: s.now(),
+		creationPath: req.path,
+		ttl:          req.wrapTTL,
+	}
+	wrappingToken := mustUUID()
+	s.wrapped[wrappingToken] = w
+
+	wrapInfo := &api.SecretWrapInfo{
+		Token:        wrappingToken,
+		Accessor:     w.accessor,
+		TTL:          int(w.ttl.Seconds()),
+		CreationTime: w.creationTime,
+		CreationPath: w.creationPath,
+	}
+	if secret.Auth != nil {
+		wrapInfo.WrappedAccessor = secret.Auth.Accessor
+	}
+
+	return &api.Secret{
+		WrapInfo: wrapInfo,
+	}, nil
+}
+
+// handleWrapping handles the sys/wrapping endpoints. The wrapping token can
+// be used as the client token to unwrap or look up itself.
+func (s *Server) handleWrapping(req *request) (*api.Secret, error) {
+	operation := strings.TrimPrefix(req.path, "sys/wrapping/")
+
+	wrappingToken, _ := req.data["token"].(string)
+	clientToken := req.raw.Header.Get(consts.AuthHeaderName)
+	if wrappingToken == "" {
+		wrappingToken = clientToken
+	}
+
+	// Wrapping a value, or unwrapping with a wrapping token given in the
+	// body, requires a valid client token
+	if operation == "wrap" || (operation != "lookup" && wrappingToken != clientToken) {
+		if err := s.authenticate(req); err != nil {
+			return nil, err
+		}
+	}
+
+	switch operation {
+	case "wrap":
+		if req.wrapTTL == 0 {
+			return nil, errorf(http.StatusBadRequest, "wrap TTL must be set")
+		}
+		return s.wrap(req, &api.Secret{
+			Data: req.data,
+		})
+
+	case "unwrap", "lookup", "rewrap":
+	default:
+		return nil, errorf(http.StatusNotFound, "no handler for route '%s'", req.path)
+	}
+
+	w, ok := s.wrapped[wrappingToken]
+	if !ok || w.expired(s.now()) {
+		return nil, errorf(http.StatusBadRequest, "wrapping token is not valid or does not exist")
+	}
+
+	switch operation {
+	case "unwrap":
+		delete(s.wrapped, wrappingToken)
+		return w.secret, nil
+
+	case "rewrap":
+		delete(s.wrapped, wrappingToken)
+		req.wrapTTL = w.ttl
+		req.path = w.creationPath
+		return s.wrap(req, w.secret)
+	}
+
+	return &api.Secret{
+		Data: map[string]interface{}{
+			"creation_path": w.creationPath,
+			"creation_time": formatTime(w.creationTime),
+			"creation_ttl":  int(w.ttl.Seconds()),
+		},
+	}, nil
+}
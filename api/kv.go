@@ -0,0 +1,177 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrSecretNotFound is returned by the KV clients when there is no secret at
+// the given path
+var ErrSecretNotFound = errors.New("secret not found")
+
+// KV is implemented by the clients of both versions of the KV secrets
+// engine, KVv1 and KVv2
+type KV interface {
+	// Get returns the secret at secretPath, or ErrSecretNotFound
+	Get(secretPath string) (*KVSecret, error)
+
+	// Put writes data to secretPath, replacing the existing data
+	Put(secretPath string, data map[string]interface{}, opts ...KVOption) (*KVSecret, error)
+
+	// Patch merges data into the existing secret at secretPath. Keys set to
+	// nil are removed.
+	Patch(secretPath string, data map[string]interface{}) (*KVSecret, error)
+
+	// Delete deletes the secret at secretPath. With KV v2, only the latest
+	// version is deleted, and it can be undeleted.
+	Delete(secretPath string) error
+}
+
+// KVSecret is a secret read from or written to the KV secrets engine
+type KVSecret struct {
+	// Data is the secret data. With KV v2, it is nil for a deleted or
+	// destroyed version.
+	Data map[string]interface{}
+
+	// VersionMetadata is the metadata of the version, with KV v2 only
+	VersionMetadata *KVVersionMetadata
+
+	// Raw is the response from Vault
+	Raw *Secret
+}
+
+// KVVersionMetadata is the metadata of a version of a KV v2 secret
+type KVVersionMetadata struct {
+	Version      int
+	CreatedTime  time.Time
+	DeletionTime time.Time
+	Destroyed    bool
+}
+
+// Deleted returns whether the version was deleted
+func (m *KVVersionMetadata) Deleted() bool {
+	return !m.DeletionTime.IsZero()
+}
+
+// KVMetadata is the metadata of a KV v2 secret, including all its versions
+type KVMetadata struct {
+	CASRequired    bool
+	CreatedTime    time.Time
+	UpdatedTime    time.Time
+	CurrentVersion int
+	OldestVersion  int
+	MaxVersions    int
+	Versions       map[int]*KVVersionMetadata
+}
+
+// KVOption is an option of a KV write
+type KVOption func(opts map[string]interface{})
+
+// WithCheckAndSet makes a KV v2 write succeed only if the current version of
+// the secret is version. A version of 0 means the secret must not exist.
+func WithCheckAndSet(version int) KVOption {
+	return func(opts map[string]interface{}) {
+		opts["cas"] = version
+	}
+}
+
+// KVMountVersion returns the mount path and the version of the KV secrets
+// engine mounted at or above path. Servers that can't tell are assumed to
+// mount version 1.
+func (c *Client) KVMountVersion(path string) (string, int, error) {
+	// We don't want to use a wrapping call here so save any custom value and
+	// restore after
+	currentWrappingLookupFunc := c.CurrentWrappingLookupFunc()
+	c.SetWrappingLookupFunc(nil)
+	defer c.SetWrappingLookupFunc(currentWrappingLookupFunc)
+
+	r := c.NewRequest("GET", "/v1/sys/internal/ui/mounts/"+path)
+
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+	resp, err := c.RawRequestWithContext(ctx, r)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		// If we get a 404 we are using an older version of vault, default to
+		// version 1
+		if resp != nil && resp.StatusCode == 404 {
+			return "", 1, nil
+		}
+
+		return "", 0, err
+	}
+
+	secret, err := ParseSecret(resp.Body)
+	if err != nil {
+		return "", 0, err
+	}
+	if secret == nil {
+		return "", 0, errors.New("nil response from mount lookup")
+	}
+
+	mountPath, _ := secret.Data["path"].(string)
+	options, _ := secret.Data["options"].(map[string]interface{})
+	if version, _ := options["version"].(string); version == "2" {
+		return mountPath, 2, nil
+	}
+	return mountPath, 1, nil
+}
+
+// kvPath joins the mount path, the API prefix of KV v2 if any, and the path
+// of the secret
+func kvPath(mountPath, prefix, secretPath string) string {
+	parts := []string{strings.Trim(mountPath, "/")}
+	if prefix != "" {
+		parts = append(parts, prefix)
+	}
+	return strings.Join(append(parts, strings.Trim(secretPath, "/")), "/")
+}
+
+// mergePatch returns existing with data merged in, removing the keys set to
+// nil in data
+func mergePatch(existing, data map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(existing)+len(data))
+	for k, v := range existing {
+		merged[k] = v
+	}
+	for k, v := range data {
+		if v == nil {
+			delete(merged, k)
+			continue
+		}
+		merged[k] = v
+	}
+	return merged
+}
+
+// parseKVTime parses the times returned by KV v2, which are empty when unset
+func parseKVTime(raw interface{}) (time.Time, error) {
+	s, _ := raw.(string)
+	if s == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339Nano, s)
+}
+
+// parseKVInt parses the numbers returned by Vault
+func parseKVInt(raw interface{}) (int, error) {
+	switch v := raw.(type) {
+	case nil:
+		return 0, nil
+	case int:
+		return v, nil
+	case float64:
+		return int(v), nil
+	case json.Number:
+		i, err := v.Int64()
+		return int(i), err
+	default:
+		return 0, fmt.Errorf("unexpected number type %T", raw)
+	}
+}
@@ -0,0 +1,211 @@
+package api_test
+
+import (
+	"reflect"
+	"testing"
+
+	log "github.com/hashicorp/go-hclog"
+	kv "github.com/hashicorp/vault-plugin-secrets-kv"
+	"github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/vault"
+)
+
+func testKVServer(t *testing.T) (*api.Client, func()) {
+	t.Helper()
+
+	client, _, closer := testVaultServerCoreConfig(t, &vault.CoreConfig{
+		DisableMlock: true,
+		DisableCache: true,
+		Logger:       log.NewNullLogger(),
+		LogicalBackends: map[string]logical.Factory{
+			"kv": kv.Factory,
+		},
+	})
+
+	if err := client.Sys().Mount("kv-v1", &api.MountInput{
+		Type:    "kv",
+		Options: map[string]string{"version": "1"},
+	}); err != nil {
+		closer()
+		t.Fatal(err)
+	}
+	if err := client.Sys().Mount("kv-v2", &api.MountInput{
+		Type:    "kv",
+		Options: map[string]string{"version": "2"},
+	}); err != nil {
+		closer()
+		t.Fatal(err)
+	}
+
+	return client, closer
+}
+
+func TestKV_MountVersion(t *testing.T) {
+	client, closer := testKVServer(t)
+	defer closer()
+
+	for path, expected := range map[string]int{
+		"kv-v1/foo":     1,
+		"kv-v2/foo/bar": 2,
+	} {
+		mountPath, version, err := client.KVMountVersion(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if version != expected {
+			t.Fatalf("%s: expected version %d, got %d", path, expected, version)
+		}
+		if mountPath != path[:6] {
+			t.Fatalf("%s: bad mount path %q", path, mountPath)
+		}
+	}
+}
+
+func TestKV_Interface(t *testing.T) {
+	client, closer := testKVServer(t)
+	defer closer()
+
+	for name, store := range map[string]api.KV{
+		"v1": client.KVv1("kv-v1"),
+		"v2": client.KVv2("kv-v2"),
+	} {
+		if _, err := store.Get("foo"); err != api.ErrSecretNotFound {
+			t.Fatalf("%s: expected ErrSecretNotFound, got %v", name, err)
+		}
+
+		if _, err := store.Put("foo", map[string]interface{}{"a": "1", "b": "2"}); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if _, err := store.Patch("foo", map[string]interface{}{"b": nil, "c": "3"}); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		secret, err := store.Get("foo")
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		expected := map[string]interface{}{"a": "1", "c": "3"}
+		if !reflect.DeepEqual(secret.Data, expected) {
+			t.Fatalf("%s: expected %v, got %v", name, expected, secret.Data)
+		}
+
+		if err := store.Delete("foo"); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+	}
+
+	if _, err := client.KVv1("kv-v1").Put("foo", nil, api.WithCheckAndSet(0)); err == nil {
+		t.Fatal("expected an error using options with KV v1")
+	}
+}
+
+func TestKVv2(t *testing.T) {
+	client, closer := testKVServer(t)
+	defer closer()
+
+	store := client.KVv2("kv-v2")
+
+	secret, err := store.Put("foo", map[string]interface{}{"value": "one"}, api.WithCheckAndSet(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret.VersionMetadata.Version != 1 || secret.VersionMetadata.CreatedTime.IsZero() {
+		t.Fatalf("bad version metadata: %#v", secret.VersionMetadata)
+	}
+	if _, err := store.Put("foo", map[string]interface{}{"value": "two"}, api.WithCheckAndSet(0)); err == nil {
+		t.Fatal("expected a check-and-set failure")
+	}
+	if _, err := store.Put("foo", map[string]interface{}{"value": "two"}, api.WithCheckAndSet(1)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Put("foo", map[string]interface{}{"value": "three"}); err != nil {
+		t.Fatal(err)
+	}
+
+	secret, err = store.GetVersion("foo", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret.Data["value"] != "one" || secret.VersionMetadata.Version != 1 {
+		t.Fatalf("bad secret: %#v", secret)
+	}
+
+	// Deleted versions are returned without data
+	if err := store.DeleteVersions("foo", []int{2}); err != nil {
+		t.Fatal(err)
+	}
+	secret, err = store.GetVersion("foo", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret.Data != nil || !secret.VersionMetadata.Deleted() {
+		t.Fatalf("expected a deleted version: %#v", secret)
+	}
+	if err := store.Undelete("foo", []int{2}); err != nil {
+		t.Fatal(err)
+	}
+	secret, err = store.GetVersion("foo", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret.Data["value"] != "two" {
+		t.Fatalf("expected an undeleted version: %#v", secret)
+	}
+
+	if err := store.Destroy("foo", []int{3}); err != nil {
+		t.Fatal(err)
+	}
+
+	versions, err := store.Versions("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 3 {
+		t.Fatalf("expected 3 versions, got %d", len(versions))
+	}
+	for i, v := range versions {
+		if v.Version != i+1 {
+			t.Fatalf("versions not sorted: %d at %d", v.Version, i)
+		}
+	}
+	if !versions[2].Destroyed || versions[0].Destroyed {
+		t.Fatalf("bad destroyed state: %#v", versions)
+	}
+
+	if _, err := store.Rollback("foo", 3); err == nil {
+		t.Fatal("expected an error rolling back to a destroyed version")
+	}
+	secret, err = store.Rollback("foo", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret.VersionMetadata.Version != 4 {
+		t.Fatalf("expected version 4, got %d", secret.VersionMetadata.Version)
+	}
+
+	metadata, err := store.Metadata("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata.CurrentVersion != 4 || metadata.OldestVersion != 0 || len(metadata.Versions) != 4 {
+		t.Fatalf("bad metadata: %#v", metadata)
+	}
+	secret, err = store.Get("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret.Data["value"] != "one" {
+		t.Fatalf("expected rolled back data, got %v", secret.Data)
+	}
+
+	if err := store.DeleteMetadata("foo"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Metadata("foo"); err != api.ErrSecretNotFound {
+		t.Fatalf("expected ErrSecretNotFound, got %v", err)
+	}
+	if _, err := store.Get("foo"); err != api.ErrSecretNotFound {
+		t.Fatalf("expected ErrSecretNotFound, got %v", err)
+	}
+}
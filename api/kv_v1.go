@@ -0,0 +1,73 @@
+package api
+
+import (
+	"errors"
+)
+
+// KVv1 is used to work with secrets in a KV v1 secrets engine
+type KVv1 struct {
+	c         *Client
+	mountPath string
+}
+
+var _ KV = (*KVv1)(nil)
+
+// KVv1 returns a client for the KV v1 secrets engine mounted at mountPath
+func (c *Client) KVv1(mountPath string) *KVv1 {
+	return &KVv1{
+		c:         c,
+		mountPath: mountPath,
+	}
+}
+
+// Get returns the secret at secretPath, or ErrSecretNotFound
+func (kv *KVv1) Get(secretPath string) (*KVSecret, error) {
+	secret, err := kv.c.Logical().Read(kvPath(kv.mountPath, "", secretPath))
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil {
+		return nil, ErrSecretNotFound
+	}
+
+	return &KVSecret{
+		Data: secret.Data,
+		Raw:  secret,
+	}, nil
+}
+
+// Put writes data to secretPath, replacing the existing data. KV v1 doesn't
+// support any option.
+func (kv *KVv1) Put(secretPath string, data map[string]interface{}, opts ...KVOption) (*KVSecret, error) {
+	if len(opts) > 0 {
+		return nil, errors.New("options are not supported by KV v1")
+	}
+
+	secret, err := kv.c.Logical().Write(kvPath(kv.mountPath, "", secretPath), data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &KVSecret{
+		Data: data,
+		Raw:  secret,
+	}, nil
+}
+
+// Patch merges data into the existing secret at secretPath. Keys set to nil
+// are removed. KV v1 has no check-and-set, so concurrent writes between the
+// read and the write are lost.
+func (kv *KVv1) Patch(secretPath string, data map[string]interface{}) (*KVSecret, error) {
+	existing, err := kv.Get(secretPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return kv.Put(secretPath, mergePatch(existing.Data, data))
+}
+
+// Delete deletes the secret at secretPath
+func (kv *KVv1) Delete(secretPath string) error {
+	_, err := kv.c.Logical().Delete(kvPath(kv.mountPath, "", secretPath))
+	return err
+}
@@ -0,0 +1,261 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// KVv2 is used to work with secrets in a KV v2 secrets engine
+type KVv2 struct {
+	c         *Client
+	mountPath string
+}
+
+var _ KV = (*KVv2)(nil)
+
+// KVv2 returns a client for the KV v2 secrets engine mounted at mountPath
+func (c *Client) KVv2(mountPath string) *KVv2 {
+	return &KVv2{
+		c:         c,
+		mountPath: mountPath,
+	}
+}
+
+// Get returns the latest version of the secret at secretPath, or
+// ErrSecretNotFound
+func (kv *KVv2) Get(secretPath string) (*KVSecret, error) {
+	return kv.get(secretPath, nil)
+}
+
+// GetVersion returns the given version of the secret at secretPath, or
+// ErrSecretNotFound
+func (kv *KVv2) GetVersion(secretPath string, version int) (*KVSecret, error) {
+	return kv.get(secretPath, map[string][]string{
+		"version": {strconv.Itoa(version)},
+	})
+}
+
+func (kv *KVv2) get(secretPath string, params map[string][]string) (*KVSecret, error) {
+	secret, err := kv.c.Logical().ReadWithData(kvPath(kv.mountPath, "data", secretPath), params)
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil || secret.Data == nil {
+		return nil, ErrSecretNotFound
+	}
+
+	// Deleted and destroyed versions have metadata but no data
+	metadata, err := parseKVVersionMetadata(secret.Data["metadata"])
+	if err != nil {
+		return nil, err
+	}
+	data, _ := secret.Data["data"].(map[string]interface{})
+
+	return &KVSecret{
+		Data:            data,
+		VersionMetadata: metadata,
+		Raw:             secret,
+	}, nil
+}
+
+// Put writes data to secretPath as a new version. With WithCheckAndSet, the
+// write only succeeds if the current version matches.
+func (kv *KVv2) Put(secretPath string, data map[string]interface{}, opts ...KVOption) (*KVSecret, error) {
+	body := map[string]interface{}{
+		"data": data,
+	}
+	if len(opts) > 0 {
+		options := make(map[string]interface{})
+		for _, opt := range opts {
+			opt(options)
+		}
+		body["options"] = options
+	}
+
+	secret, err := kv.c.Logical().Write(kvPath(kv.mountPath, "data", secretPath), body)
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil {
+		return nil, errors.New("no response from write")
+	}
+
+	metadata, err := parseKVVersionMetadata(secret.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &KVSecret{
+		Data:            data,
+		VersionMetadata: metadata,
+		Raw:             secret,
+	}, nil
+}
+
+// Patch merges data into the latest version of the secret at secretPath,
+// writing the result as a new version. Keys set to nil are removed. The write
+// fails if another version was written since the read.
+func (kv *KVv2) Patch(secretPath string, data map[string]interface{}) (*KVSecret, error) {
+	existing, err := kv.Get(secretPath)
+	if err != nil {
+		return nil, err
+	}
+	if existing.Data == nil {
+		return nil, fmt.Errorf("the latest version of %q is deleted or destroyed", secretPath)
+	}
+
+	return kv.Put(secretPath, mergePatch(existing.Data, data), WithCheckAndSet(existing.VersionMetadata.Version))
+}
+
+// Delete deletes the latest version of the secret at secretPath. It can be
+// undeleted.
+func (kv *KVv2) Delete(secretPath string) error {
+	_, err := kv.c.Logical().Delete(kvPath(kv.mountPath, "data", secretPath))
+	return err
+}
+
+// DeleteVersions deletes the given versions of the secret at secretPath. They
+// can be undeleted.
+func (kv *KVv2) DeleteVersions(secretPath string, versions []int) error {
+	return kv.writeVersions("delete", secretPath, versions)
+}
+
+// Undelete restores the given deleted versions of the secret at secretPath
+func (kv *KVv2) Undelete(secretPath string, versions []int) error {
+	return kv.writeVersions("undelete", secretPath, versions)
+}
+
+// Destroy permanently removes the data of the given versions of the secret
+// at secretPath
+func (kv *KVv2) Destroy(secretPath string, versions []int) error {
+	return kv.writeVersions("destroy", secretPath, versions)
+}
+
+func (kv *KVv2) writeVersions(prefix, secretPath string, versions []int) error {
+	if len(versions) == 0 {
+		return errors.New("no versions provided")
+	}
+	_, err := kv.c.Logical().Write(kvPath(kv.mountPath, prefix, secretPath), map[string]interface{}{
+		"versions": versions,
+	})
+	return err
+}
+
+// DeleteMetadata permanently removes the secret at secretPath, with all its
+// versions and metadata
+func (kv *KVv2) DeleteMetadata(secretPath string) error {
+	_, err := kv.c.Logical().Delete(kvPath(kv.mountPath, "metadata", secretPath))
+	return err
+}
+
+// Metadata returns the metadata of the secret at secretPath, or
+// ErrSecretNotFound
+func (kv *KVv2) Metadata(secretPath string) (*KVMetadata, error) {
+	secret, err := kv.c.Logical().Read(kvPath(kv.mountPath, "metadata", secretPath))
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil || secret.Data == nil {
+		return nil, ErrSecretNotFound
+	}
+
+	metadata := &KVMetadata{
+		Versions: make(map[int]*KVVersionMetadata),
+	}
+	metadata.CASRequired, _ = secret.Data["cas_required"].(bool)
+	if metadata.CreatedTime, err = parseKVTime(secret.Data["created_time"]); err != nil {
+		return nil, err
+	}
+	if metadata.UpdatedTime, err = parseKVTime(secret.Data["updated_time"]); err != nil {
+		return nil, err
+	}
+	if metadata.CurrentVersion, err = parseKVInt(secret.Data["current_version"]); err != nil {
+		return nil, err
+	}
+	if metadata.OldestVersion, err = parseKVInt(secret.Data["oldest_version"]); err != nil {
+		return nil, err
+	}
+	if metadata.MaxVersions, err = parseKVInt(secret.Data["max_versions"]); err != nil {
+		return nil, err
+	}
+
+	versions, _ := secret.Data["versions"].(map[string]interface{})
+	for key, raw := range versions {
+		version, err := strconv.Atoi(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid version %q", key)
+		}
+		versionMetadata, err := parseKVVersionMetadata(raw)
+		if err != nil {
+			return nil, err
+		}
+		versionMetadata.Version = version
+		metadata.Versions[version] = versionMetadata
+	}
+
+	return metadata, nil
+}
+
+// Versions returns the metadata of the versions of the secret at secretPath,
+// sorted by version
+func (kv *KVv2) Versions(secretPath string) ([]*KVVersionMetadata, error) {
+	metadata, err := kv.Metadata(secretPath)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make([]*KVVersionMetadata, 0, len(metadata.Versions))
+	for _, v := range metadata.Versions {
+		versions = append(versions, v)
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].Version < versions[j].Version
+	})
+	return versions, nil
+}
+
+// Rollback writes the data of the given version of the secret at secretPath
+// as a new version. The write fails if another version was written since the
+// current version was read.
+func (kv *KVv2) Rollback(secretPath string, toVersion int) (*KVSecret, error) {
+	metadata, err := kv.Metadata(secretPath)
+	if err != nil {
+		return nil, err
+	}
+
+	target, err := kv.GetVersion(secretPath, toVersion)
+	if err != nil {
+		return nil, err
+	}
+	if target.Data == nil {
+		return nil, fmt.Errorf("version %d of %q is deleted or destroyed", toVersion, secretPath)
+	}
+
+	return kv.Put(secretPath, target.Data, WithCheckAndSet(metadata.CurrentVersion))
+}
+
+// parseKVVersionMetadata parses the metadata of a version, as returned by
+// reads and writes
+func parseKVVersionMetadata(raw interface{}) (*KVVersionMetadata, error) {
+	data, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("missing version metadata")
+	}
+
+	metadata := &KVVersionMetadata{}
+	var err error
+	if metadata.Version, err = parseKVInt(data["version"]); err != nil {
+		return nil, err
+	}
+	if metadata.CreatedTime, err = parseKVTime(data["created_time"]); err != nil {
+		return nil, err
+	}
+	if metadata.DeletionTime, err = parseKVTime(data["deletion_time"]); err != nil {
+		return nil, err
+	}
+	metadata.Destroyed, _ = data["destroyed"].(bool)
+
+	return metadata, nil
+}
@@ -36,6 +36,12 @@ type Backend interface {
 	Invalidate(context.Context)
 }
 
+// Cleanup is implemented by audit backends which run in the background, to
+// stop when the backend is disabled or torn down
+type Cleanup interface {
+	Cleanup(context.Context)
+}
+
 // LogInput contains the input parameters passed into LogRequest and LogResponse
 type LogInput struct {
 	Auth                *logical.Auth
@@ -149,6 +149,7 @@ func (f *AuditFormatter) FormatRequest(ctx context.Context, w io.Writer, config
 			Data:               req.Data,
 			PolicyOverride:     req.PolicyOverride,
 			RemoteAddr:         getRemoteAddr(req),
+			RemoteUnixCreds:    getRemoteUnixCreds(req),
 			ReplicationCluster: req.ReplicationCluster,
 			Headers:            req.Headers,
 		},
@@ -359,6 +360,7 @@ func (f *AuditFormatter) FormatResponse(ctx context.Context, w io.Writer, config
 			Data:               req.Data,
 			PolicyOverride:     req.PolicyOverride,
 			RemoteAddr:         getRemoteAddr(req),
+			RemoteUnixCreds:    getRemoteUnixCreds(req),
 			ReplicationCluster: req.ReplicationCluster,
 			Headers:            req.Headers,
 		},
@@ -413,6 +415,7 @@ type AuditRequest struct {
 	Data                map[string]interface{} `json:"data"`
 	PolicyOverride      bool                   `json:"policy_override"`
 	RemoteAddr          string                 `json:"remote_address"`
+	RemoteUnixCreds     *AuditUnixCredentials  `json:"remote_unix_credentials,omitempty"`
 	WrapTTL             int                    `json:"wrap_ttl"`
 	Headers             map[string][]string    `json:"headers"`
 }
@@ -457,6 +460,26 @@ type AuditNamespace struct {
 	Path string `json:"path"`
 }
 
+type AuditUnixCredentials struct {
+	PID int32  `json:"pid"`
+	UID uint32 `json:"uid"`
+	GID uint32 `json:"gid"`
+}
+
+// getRemoteUnixCreds returns the credentials of the local process that sent
+// the request over a unix socket listener, if any
+func getRemoteUnixCreds(req *logical.Request) *AuditUnixCredentials {
+	if req == nil || req.Connection == nil || req.Connection.UnixCredentials == nil {
+		return nil
+	}
+	creds := req.Connection.UnixCredentials
+	return &AuditUnixCredentials{
+		PID: creds.PID,
+		UID: creds.UID,
+		GID: creds.GID,
+	}
+}
+
 // getRemoteAddr safely gets the remote address avoiding a nil pointer
 func getRemoteAddr(req *logical.Request) string {
 	if req != nil && req.Connection != nil {
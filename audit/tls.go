@@ -0,0 +1,55 @@
+package audit
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strconv"
+
+	rootcerts "github.com/hashicorp/go-rootcerts"
+)
+
+// ClientTLSConfig builds the TLS configuration used by network audit devices
+// to connect to address from the tls_* keys of the device's options.
+func ClientTLSConfig(conf map[string]string, address string) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: conf["tls_server_name"],
+	}
+
+	if tlsConfig.ServerName == "" {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.ServerName = host
+	}
+
+	if raw, ok := conf["tls_skip_verify"]; ok {
+		skip, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.InsecureSkipVerify = skip
+	}
+
+	if caCert := conf["tls_ca_cert"]; caCert != "" {
+		if err := rootcerts.ConfigureTLS(tlsConfig, &rootcerts.Config{CAFile: caCert}); err != nil {
+			return nil, err
+		}
+	}
+
+	clientCert, clientKey := conf["tls_client_cert"], conf["tls_client_key"]
+	switch {
+	case clientCert != "" && clientKey != "":
+		cert, err := tls.LoadX509KeyPair(clientCert, clientKey)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	case clientCert != "" || clientKey != "":
+		return nil, fmt.Errorf("both tls_client_cert and tls_client_key must be provided")
+	}
+
+	return tlsConfig, nil
+}
@@ -3,19 +3,34 @@ package socket
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"fmt"
+	"math/rand"
 	"net"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	multierror "github.com/hashicorp/go-multierror"
+	metrics "github.com/armon/go-metrics"
 	"github.com/hashicorp/vault/audit"
 	"github.com/hashicorp/vault/helper/parseutil"
 	"github.com/hashicorp/vault/helper/salt"
 	"github.com/hashicorp/vault/logical"
 )
 
+const (
+	// deliveryBlocking makes LogRequest and LogResponse wait until the entry
+	// has been written to the socket, failing after write_timeout.
+	deliveryBlocking = "blocking"
+
+	// deliveryBestEffort queues entries and returns immediately, dropping
+	// entries when the buffer is full.
+	deliveryBestEffort = "best_effort"
+
+	minReconnectBackoff = 250 * time.Millisecond
+)
+
 func Factory(ctx context.Context, conf *audit.BackendConfig) (audit.Backend, error) {
 	if conf.SaltConfig == nil {
 		return nil, fmt.Errorf("nil salt config")
@@ -34,6 +49,15 @@ func Factory(ctx context.Context, conf *audit.BackendConfig) (audit.Backend, err
 		socketType = "tcp"
 	}
 
+	var tlsConfig *tls.Config
+	if socketType == "tls" {
+		var err error
+		tlsConfig, err = audit.ClientTLSConfig(conf.Config, address)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	writeDeadline, ok := conf.Config["write_timeout"]
 	if !ok {
 		writeDeadline = "2s"
@@ -43,6 +67,39 @@ func Factory(ctx context.Context, conf *audit.BackendConfig) (audit.Backend, err
 		return nil, err
 	}
 
+	maxBackoffRaw, ok := conf.Config["max_backoff"]
+	if !ok {
+		maxBackoffRaw = "30s"
+	}
+	maxBackoff, err := parseutil.ParseDurationSecond(maxBackoffRaw)
+	if err != nil {
+		return nil, err
+	}
+	if maxBackoff < minReconnectBackoff {
+		maxBackoff = minReconnectBackoff
+	}
+
+	bufferSize := 1000
+	if bufferSizeRaw, ok := conf.Config["buffer_size"]; ok {
+		bufferSize, err = strconv.Atoi(bufferSizeRaw)
+		if err != nil {
+			return nil, err
+		}
+		if bufferSize < 1 {
+			return nil, fmt.Errorf("buffer_size must be at least 1")
+		}
+	}
+
+	delivery, ok := conf.Config["delivery"]
+	if !ok {
+		delivery = deliveryBlocking
+	}
+	switch delivery {
+	case deliveryBlocking, deliveryBestEffort:
+	default:
+		return nil, fmt.Errorf("unknown delivery mode %q", delivery)
+	}
+
 	format, ok := conf.Config["format"]
 	if !ok {
 		format = "json"
@@ -82,8 +139,15 @@ func Factory(ctx context.Context, conf *audit.BackendConfig) (audit.Backend, err
 		},
 
 		writeDuration: writeDuration,
+		maxBackoff:    maxBackoff,
 		address:       address,
 		socketType:    socketType,
+		tlsConfig:     tlsConfig,
+		delivery:      delivery,
+
+		queue:       make(chan *entry, bufferSize),
+		reconnectCh: make(chan struct{}, 1),
+		stopCh:      make(chan struct{}),
 	}
 
 	switch format {
@@ -102,18 +166,34 @@ func Factory(ctx context.Context, conf *audit.BackendConfig) (audit.Backend, err
 	return b, nil
 }
 
-// Backend is the audit backend for the socket audit transport.
+// Backend is the audit backend for the socket audit transport. Entries are
+// queued in a bounded buffer and written by a background goroutine, which
+// reconnects with a jittered exponential backoff when the socket fails.
 type Backend struct {
-	connection net.Conn
-
 	formatter    audit.AuditFormatter
 	formatConfig audit.FormatterConfig
 
 	writeDuration time.Duration
+	maxBackoff    time.Duration
 	address       string
 	socketType    string
+	tlsConfig     *tls.Config
+	delivery      string
 
-	sync.Mutex
+	queue       chan *entry
+	reconnectCh chan struct{}
+
+	// stopCh is closed by Cleanup, once the device is disabled or torn
+	// down, to stop the writer and drop the entries still buffered
+	stopCh   chan struct{}
+	stopOnce sync.Once
+
+	writerLock    sync.Mutex
+	writerRunning bool
+
+	connLock   sync.Mutex
+	connection net.Conn
+	lastErr    error
 
 	saltMutex  sync.RWMutex
 	salt       *salt.Salt
@@ -121,7 +201,10 @@ type Backend struct {
 	saltView   logical.Storage
 }
 
-var _ audit.Backend = (*Backend)(nil)
+var (
+	_ audit.Backend = (*Backend)(nil)
+	_ audit.Cleanup = (*Backend)(nil)
+)
 
 func (b *Backend) GetHash(ctx context.Context, data string) (string, error) {
 	salt, err := b.Salt(ctx)
@@ -137,21 +220,7 @@ func (b *Backend) LogRequest(ctx context.Context, in *audit.LogInput) error {
 		return err
 	}
 
-	b.Lock()
-	defer b.Unlock()
-
-	err := b.write(ctx, buf.Bytes())
-	if err != nil {
-		rErr := b.reconnect(ctx)
-		if rErr != nil {
-			err = multierror.Append(err, rErr)
-		} else {
-			// Try once more after reconnecting
-			err = b.write(ctx, buf.Bytes())
-		}
-	}
-
-	return err
+	return b.enqueue(ctx, buf.Bytes())
 }
 
 func (b *Backend) LogResponse(ctx context.Context, in *audit.LogInput) error {
@@ -160,67 +229,288 @@ func (b *Backend) LogResponse(ctx context.Context, in *audit.LogInput) error {
 		return err
 	}
 
-	b.Lock()
-	defer b.Unlock()
+	return b.enqueue(ctx, buf.Bytes())
+}
 
-	err := b.write(ctx, buf.Bytes())
-	if err != nil {
-		rErr := b.reconnect(ctx)
-		if rErr != nil {
-			err = multierror.Append(err, rErr)
-		} else {
-			// Try once more after reconnecting
-			err = b.write(ctx, buf.Bytes())
+// entry is a formatted audit entry waiting to be written to the socket.
+type entry struct {
+	buf []byte
+
+	// done receives the result of a blocking write; it is nil for
+	// best-effort entries
+	done chan error
+
+	// abandoned is set once a blocking caller has stopped waiting, so that
+	// the writer does not keep retrying an entry that has already failed
+	abandoned int32
+}
+
+func (b *Backend) enqueue(ctx context.Context, buf []byte) error {
+	if b.stopped() {
+		b.emitDropped()
+		if b.delivery == deliveryBestEffort {
+			return nil
+		}
+		return fmt.Errorf("audit device for %q is stopped", b.address)
+	}
+
+	e := &entry{buf: buf}
+
+	if b.delivery == deliveryBestEffort {
+		select {
+		case b.queue <- e:
+			b.startWriter()
+		default:
+			b.emitDropped()
+		}
+		b.emitBuffered()
+		return nil
+	}
+
+	e.done = make(chan error, 1)
+	timer := time.NewTimer(b.writeDuration)
+	defer timer.Stop()
+
+	select {
+	case b.queue <- e:
+		b.startWriter()
+		b.emitBuffered()
+	case <-timer.C:
+		b.emitDropped()
+		return fmt.Errorf("timed out waiting for space in the audit buffer for %q", b.address)
+	case <-ctx.Done():
+		b.emitDropped()
+		return ctx.Err()
+	}
+
+	select {
+	case err := <-e.done:
+		return err
+	case <-timer.C:
+		atomic.StoreInt32(&e.abandoned, 1)
+		if err := b.getLastErr(); err != nil {
+			return fmt.Errorf("timed out writing audit entry to %q: %v", b.address, err)
+		}
+		return fmt.Errorf("timed out writing audit entry to %q", b.address)
+	case <-ctx.Done():
+		atomic.StoreInt32(&e.abandoned, 1)
+		return ctx.Err()
+	}
+}
+
+// startWriter starts the background writer if it is not already running. The
+// writer exits once the buffer is empty, or the device is stopped.
+func (b *Backend) startWriter() {
+	b.writerLock.Lock()
+	defer b.writerLock.Unlock()
+
+	if !b.writerRunning && !b.stopped() {
+		b.writerRunning = true
+		go b.runWriter()
+	}
+}
+
+func (b *Backend) runWriter() {
+	for {
+		select {
+		case <-b.stopCh:
+			b.writerLock.Lock()
+			b.writerRunning = false
+			b.writerLock.Unlock()
+			b.dropBuffered()
+			return
+		default:
+		}
+
+		select {
+		case e := <-b.queue:
+			b.deliver(e)
+			b.emitBuffered()
+		default:
+			b.writerLock.Lock()
+			if len(b.queue) == 0 {
+				b.writerRunning = false
+				b.writerLock.Unlock()
+				return
+			}
+			b.writerLock.Unlock()
+		}
+	}
+}
+
+// deliver writes e to the socket, reconnecting as needed, until it succeeds,
+// a blocking caller gives up on it or the device is stopped.
+func (b *Backend) deliver(e *entry) {
+	var backoff time.Duration
+	for {
+		if atomic.LoadInt32(&e.abandoned) == 1 {
+			b.emitDropped()
+			return
+		}
+		if b.stopped() {
+			b.drop(e)
+			return
+		}
+
+		err := b.write(e.buf)
+		if err == nil {
+			if e.done != nil {
+				e.done <- nil
+			}
+			return
+		}
+
+		switch {
+		case backoff == 0:
+			backoff = minReconnectBackoff
+		case backoff*2 > b.maxBackoff:
+			backoff = b.maxBackoff
+		default:
+			backoff *= 2
+		}
+
+		// Sleep for between half and all of the backoff so that several
+		// Vault nodes do not reconnect to a recovering collector in lockstep
+		wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-b.reconnectCh:
+			timer.Stop()
+			backoff = 0
+		case <-b.stopCh:
+			timer.Stop()
 		}
 	}
+}
 
-	return err
+// drop counts e as dropped because the device is stopped, and fails its
+// blocking caller if it is still waiting
+func (b *Backend) drop(e *entry) {
+	b.emitDropped()
+	if e.done != nil {
+		e.done <- fmt.Errorf("audit device for %q is stopped", b.address)
+	}
 }
 
-func (b *Backend) write(ctx context.Context, buf []byte) error {
+// dropBuffered drops the entries left in the buffer once the device is
+// stopped
+func (b *Backend) dropBuffered() {
+	for {
+		select {
+		case e := <-b.queue:
+			b.drop(e)
+		default:
+			b.emitBuffered()
+			return
+		}
+	}
+}
+
+func (b *Backend) stopped() bool {
+	select {
+	case <-b.stopCh:
+		return true
+	default:
+		return false
+	}
+}
+
+func (b *Backend) write(buf []byte) error {
+	b.connLock.Lock()
+	defer b.connLock.Unlock()
+
+	// Don't reconnect once Cleanup closed the connection
+	if b.stopped() {
+		return fmt.Errorf("audit device for %q is stopped", b.address)
+	}
+
 	if b.connection == nil {
-		if err := b.reconnect(ctx); err != nil {
+		if err := b.connect(); err != nil {
+			b.lastErr = err
 			return err
 		}
 	}
 
 	err := b.connection.SetWriteDeadline(time.Now().Add(b.writeDuration))
+	if err == nil {
+		_, err = b.connection.Write(buf)
+	}
 	if err != nil {
+		b.closeConnection()
+		b.lastErr = err
 		return err
 	}
 
-	_, err = b.connection.Write(buf)
+	b.lastErr = nil
+	return nil
+}
+
+func (b *Backend) connect() error {
+	dialer := &net.Dialer{
+		Timeout: b.writeDuration,
+	}
+
+	var conn net.Conn
+	var err error
+	switch b.socketType {
+	case "tls":
+		conn, err = tls.DialWithDialer(dialer, "tcp", b.address, b.tlsConfig)
+	default:
+		conn, err = dialer.Dial(b.socketType, b.address)
+	}
 	if err != nil {
 		return err
 	}
 
-	return err
+	b.connection = conn
+
+	return nil
 }
 
-func (b *Backend) reconnect(ctx context.Context) error {
+func (b *Backend) closeConnection() {
 	if b.connection != nil {
 		b.connection.Close()
 		b.connection = nil
 	}
+}
 
-	dialer := net.Dialer{}
-	conn, err := dialer.DialContext(ctx, b.socketType, b.address)
-	if err != nil {
-		return err
-	}
+func (b *Backend) getLastErr() error {
+	b.connLock.Lock()
+	defer b.connLock.Unlock()
 
-	b.connection = conn
+	return b.lastErr
+}
 
-	return nil
+// metricLabels returns a new slice on each call since go-metrics filters
+// labels in place.
+func (b *Backend) metricLabels() []metrics.Label {
+	return []metrics.Label{{Name: "address", Value: b.address}}
+}
+
+func (b *Backend) emitBuffered() {
+	metrics.SetGaugeWithLabels([]string{"audit", "socket", "buffered"}, float32(len(b.queue)), b.metricLabels())
+}
+
+// emitDropped counts an entry which is not written, because the buffer is
+// full or a blocking caller stopped waiting for it
+func (b *Backend) emitDropped() {
+	metrics.IncrCounterWithLabels([]string{"audit", "socket", "dropped"}, 1, b.metricLabels())
 }
 
-func (b *Backend) Reload(ctx context.Context) error {
-	b.Lock()
-	defer b.Unlock()
+// Reload drops the current connection and wakes the writer so that it
+// reconnects immediately instead of waiting out its backoff.
+func (b *Backend) Reload(_ context.Context) error {
+	b.connLock.Lock()
+	b.closeConnection()
+	b.connLock.Unlock()
 
-	err := b.reconnect(ctx)
+	select {
+	case b.reconnectCh <- struct{}{}:
+	default:
+	}
 
-	return err
+	return nil
 }
 
 func (b *Backend) Salt(ctx context.Context) (*salt.Salt, error) {
@@ -243,6 +533,18 @@ func (b *Backend) Salt(ctx context.Context) (*salt.Salt, error) {
 	return salt, nil
 }
 
+// Cleanup stops the writer and closes the connection, once the device is
+// disabled or torn down. The salt is only invalidated by Invalidate.
+func (b *Backend) Cleanup(_ context.Context) {
+	b.stopOnce.Do(func() {
+		close(b.stopCh)
+	})
+
+	b.connLock.Lock()
+	b.closeConnection()
+	b.connLock.Unlock()
+}
+
 func (b *Backend) Invalidate(_ context.Context) {
 	b.saltMutex.Lock()
 	defer b.saltMutex.Unlock()
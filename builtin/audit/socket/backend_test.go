@@ -0,0 +1,308 @@
+package socket
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	metrics "github.com/armon/go-metrics"
+	"github.com/hashicorp/vault/audit"
+	"github.com/hashicorp/vault/helper/namespace"
+	"github.com/hashicorp/vault/helper/salt"
+	"github.com/hashicorp/vault/logical"
+)
+
+func testBackend(t *testing.T, config map[string]string) *Backend {
+	t.Helper()
+
+	b, err := Factory(context.Background(), &audit.BackendConfig{
+		SaltConfig: &salt.Config{},
+		SaltView:   &logical.InmemStorage{},
+		Config:     config,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b.(*Backend)
+}
+
+func testLogInput(id string) *audit.LogInput {
+	return &audit.LogInput{
+		Request: &logical.Request{
+			ID:        id,
+			Operation: logical.ReadOperation,
+			Path:      "secret/foo",
+		},
+	}
+}
+
+// unusedAddr returns a loopback address that nothing is listening on.
+func unusedAddr(t *testing.T) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	return addr
+}
+
+// readEntries accepts a single connection on ln and sends every line read
+// from it on the returned channel.
+func readEntries(ln net.Listener) <-chan string {
+	lines := make(chan string, 100)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+	return lines
+}
+
+func TestAuditSocket_blocking(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	lines := readEntries(ln)
+
+	b := testBackend(t, map[string]string{
+		"address": ln.Addr().String(),
+	})
+
+	ctx := namespace.RootContext(nil)
+	for _, id := range []string{"req-1", "req-2"} {
+		if err := b.LogRequest(ctx, testLogInput(id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, id := range []string{"req-1", "req-2"} {
+		select {
+		case line := <-lines:
+			if !strings.Contains(line, id) {
+				t.Fatalf("expected %q in %q", id, line)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for entry")
+		}
+	}
+}
+
+func TestAuditSocket_blockingTimeout(t *testing.T) {
+	b := testBackend(t, map[string]string{
+		"address":       unusedAddr(t),
+		"write_timeout": "300ms",
+	})
+
+	start := time.Now()
+	err := b.LogRequest(namespace.RootContext(nil), testLogInput("req-1"))
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if !strings.Contains(err.Error(), "timed out writing audit entry") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Fatalf("write blocked for %s", time.Since(start))
+	}
+}
+
+func TestAuditSocket_blockingTimeoutDropped(t *testing.T) {
+	inm := metrics.NewInmemSink(time.Minute, time.Minute)
+	conf := metrics.DefaultConfig("vault-test")
+	conf.EnableHostname = false
+	conf.EnableRuntimeMetrics = false
+	if _, err := metrics.NewGlobal(conf, inm); err != nil {
+		t.Fatal(err)
+	}
+	defer metrics.NewGlobal(conf, &metrics.BlackholeSink{})
+
+	addr := unusedAddr(t)
+	b := testBackend(t, map[string]string{
+		"address":       addr,
+		"write_timeout": "300ms",
+		"buffer_size":   "1",
+	})
+
+	// The writer holds one entry and the buffer another, so the third times
+	// out waiting for space. Every entry which isn't written is dropped.
+	ctx := namespace.RootContext(nil)
+	var wg sync.WaitGroup
+	for _, id := range []string{"req-a", "req-b", "req-c"} {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			if err := b.LogRequest(ctx, testLogInput(id)); err == nil {
+				t.Error("expected error")
+			}
+		}(id)
+	}
+	wg.Wait()
+
+	// The writer drops the abandoned entries and exits, and only then are
+	// the metrics read without racing with it
+	writerRunning := func() bool {
+		b.writerLock.Lock()
+		defer b.writerLock.Unlock()
+		return b.writerRunning
+	}
+	for i := 0; writerRunning(); i++ {
+		if i == 50 {
+			t.Fatal("the writer is still running")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	key := "vault-test.audit.socket.dropped;address=" + addr
+	var count int
+	for _, interval := range inm.Data() {
+		if counter, ok := interval.Counters[key]; ok {
+			count += counter.Count
+		}
+	}
+	if count != 3 {
+		t.Fatalf("expected three dropped entries, got %d", count)
+	}
+}
+
+func TestAuditSocket_bestEffort(t *testing.T) {
+	inm := metrics.NewInmemSink(time.Minute, time.Minute)
+	conf := metrics.DefaultConfig("vault-test")
+	conf.EnableHostname = false
+	conf.EnableRuntimeMetrics = false
+	if _, err := metrics.NewGlobal(conf, inm); err != nil {
+		t.Fatal(err)
+	}
+	defer metrics.NewGlobal(conf, &metrics.BlackholeSink{})
+
+	addr := unusedAddr(t)
+	b := testBackend(t, map[string]string{
+		"address":     addr,
+		"delivery":    "best_effort",
+		"buffer_size": "3",
+	})
+
+	// With the collector down, logging must neither block nor fail. The
+	// writer holds one entry while it retries, so five entries fill the
+	// buffer and drop one.
+	ctx := namespace.RootContext(nil)
+	start := time.Now()
+	for i, id := range []string{"req-a", "req-b", "req-c", "req-d", "req-e"} {
+		if err := b.LogRequest(ctx, testLogInput(id)); err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			// Wait for the writer to pick up the first entry
+			for len(b.queue) != 0 {
+				time.Sleep(10 * time.Millisecond)
+			}
+		}
+	}
+	if time.Since(start) > 2*time.Second {
+		t.Fatalf("best effort writes blocked for %s", time.Since(start))
+	}
+
+	intervals := inm.Data()
+	key := "vault-test.audit.socket.dropped;address=" + addr
+	if counter, ok := intervals[len(intervals)-1].Counters[key]; !ok || counter.Count != 1 {
+		t.Fatalf("expected one dropped entry, got %#v", intervals[len(intervals)-1].Counters)
+	}
+	gauge, ok := intervals[len(intervals)-1].Gauges["vault-test.audit.socket.buffered;address="+addr]
+	if !ok || gauge.Value != 3 {
+		t.Fatalf("expected three buffered entries, got %#v", intervals[len(intervals)-1].Gauges)
+	}
+
+	// Once the collector comes up, the buffered entries are delivered
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Skipf("unable to reuse address: %v", err)
+	}
+	defer ln.Close()
+	lines := readEntries(ln)
+
+	if err := b.Reload(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []string{"req-a", "req-b", "req-c", "req-d"} {
+		select {
+		case line := <-lines:
+			if !strings.Contains(line, id) {
+				t.Fatalf("expected %q in %q", id, line)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("timed out waiting for %q", id)
+		}
+	}
+}
+
+func TestAuditSocket_cleanup(t *testing.T) {
+	b := testBackend(t, map[string]string{
+		"address":  unusedAddr(t),
+		"delivery": "best_effort",
+	})
+
+	// With the collector down, the writer retries the entry until the
+	// device is disabled
+	if err := b.LogRequest(namespace.RootContext(nil), testLogInput("req-a")); err != nil {
+		t.Fatal(err)
+	}
+	for len(b.queue) != 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	b.Cleanup(context.Background())
+
+	running := func() bool {
+		buf := make([]byte, 1<<20)
+		return strings.Contains(string(buf[:runtime.Stack(buf, true)]), "(*Backend).runWriter")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for running() {
+		if time.Now().After(deadline) {
+			t.Fatal("the writer is still running")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Entries logged afterwards don't start it again
+	if err := b.LogRequest(namespace.RootContext(nil), testLogInput("req-b")); err != nil {
+		t.Fatal(err)
+	}
+	if running() {
+		t.Fatal("the writer was started after cleanup")
+	}
+}
+
+func TestAuditSocket_config(t *testing.T) {
+	cases := map[string]map[string]string{
+		"no address":   {},
+		"bad delivery": {"address": "127.0.0.1:9090", "delivery": "sometimes"},
+		"bad buffer":   {"address": "127.0.0.1:9090", "buffer_size": "0"},
+		"partial tls":  {"address": "127.0.0.1:9090", "socket_type": "tls", "tls_client_key": "key.pem"},
+	}
+	for name, config := range cases {
+		_, err := Factory(context.Background(), &audit.BackendConfig{
+			SaltConfig: &salt.Config{},
+			SaltView:   &logical.InmemStorage{},
+			Config:     config,
+		})
+		if err == nil {
+			t.Fatalf("%s: expected error", name)
+		}
+	}
+}
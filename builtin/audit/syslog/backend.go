@@ -63,14 +63,7 @@ func Factory(ctx context.Context, conf *audit.BackendConfig) (audit.Backend, err
 		logRaw = b
 	}
 
-	// Get the logger
-	logger, err := gsyslog.NewLogger(gsyslog.LOG_INFO, facility, tag)
-	if err != nil {
-		return nil, err
-	}
-
 	b := &Backend{
-		logger:     logger,
 		saltConfig: conf.SaltConfig,
 		saltView:   conf.SaltView,
 		formatConfig: audit.FormatterConfig{
@@ -79,6 +72,22 @@ func Factory(ctx context.Context, conf *audit.BackendConfig) (audit.Backend, err
 		},
 	}
 
+	// Send directly to a remote collector if an address is given, otherwise
+	// log to the local syslog daemon
+	if _, ok := conf.Config["address"]; ok {
+		remote, err := newRemoteWriter(conf.Config, facility, tag)
+		if err != nil {
+			return nil, err
+		}
+		b.remote = remote
+	} else {
+		logger, err := gsyslog.NewLogger(gsyslog.LOG_INFO, facility, tag)
+		if err != nil {
+			return nil, err
+		}
+		b.logger = logger
+	}
+
 	switch format {
 	case "json":
 		b.formatter.AuditFormatWriter = &audit.JSONFormatWriter{
@@ -98,6 +107,7 @@ func Factory(ctx context.Context, conf *audit.BackendConfig) (audit.Backend, err
 // Backend is the audit backend for the syslog-based audit store.
 type Backend struct {
 	logger gsyslog.Syslogger
+	remote *remoteWriter
 
 	formatter    audit.AuditFormatter
 	formatConfig audit.FormatterConfig
@@ -124,9 +134,7 @@ func (b *Backend) LogRequest(ctx context.Context, in *audit.LogInput) error {
 		return err
 	}
 
-	// Write out to syslog
-	_, err := b.logger.Write(buf.Bytes())
-	return err
+	return b.write("request", in.Request, buf.Bytes())
 }
 
 func (b *Backend) LogResponse(ctx context.Context, in *audit.LogInput) error {
@@ -135,12 +143,23 @@ func (b *Backend) LogResponse(ctx context.Context, in *audit.LogInput) error {
 		return err
 	}
 
+	return b.write("response", in.Request, buf.Bytes())
+}
+
+func (b *Backend) write(msgID string, req *logical.Request, buf []byte) error {
+	if b.remote != nil {
+		return b.remote.Write(msgID, req, buf)
+	}
+
 	// Write out to syslog
-	_, err := b.logger.Write(buf.Bytes())
+	_, err := b.logger.Write(buf)
 	return err
 }
 
 func (b *Backend) Reload(_ context.Context) error {
+	if b.remote != nil {
+		b.remote.Reset()
+	}
 	return nil
 }
 
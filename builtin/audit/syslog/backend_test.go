@@ -0,0 +1,325 @@
+package syslog
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/audit"
+	"github.com/hashicorp/vault/helper/namespace"
+	"github.com/hashicorp/vault/helper/salt"
+	"github.com/hashicorp/vault/logical"
+)
+
+func testRemoteBackend(t *testing.T, config map[string]string) *Backend {
+	t.Helper()
+
+	config["hostname"] = "vault-test"
+	config["tag"] = "vault"
+	config["facility"] = "LOCAL0"
+
+	b, err := Factory(context.Background(), &audit.BackendConfig{
+		SaltConfig: &salt.Config{},
+		SaltView:   &logical.InmemStorage{},
+		Config:     config,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b.(*Backend)
+}
+
+func testLogRequest(t *testing.T, b *Backend) {
+	t.Helper()
+
+	in := &audit.LogInput{
+		Request: &logical.Request{
+			ID:         "req-1",
+			Operation:  logical.UpdateOperation,
+			Path:       "secret/foo",
+			MountPoint: `secret/"quoted"]/`,
+		},
+	}
+	if err := b.LogRequest(namespace.RootContext(nil), in); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readOctetCounted reads a single RFC 6587 octet-counted frame.
+func readOctetCounted(t *testing.T, r *bufio.Reader) string {
+	t.Helper()
+
+	lenStr, err := r.ReadString(' ')
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(lenStr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := make([]byte, n)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		t.Fatal(err)
+	}
+	return string(msg)
+}
+
+func checkMessage(t *testing.T, msg string) {
+	t.Helper()
+
+	// LOCAL0 (16) * 8 + INFO (6)
+	if !strings.HasPrefix(msg, "<134>1 ") {
+		t.Fatalf("bad header: %q", msg)
+	}
+	fields := strings.SplitN(msg, " ", 7)
+	if len(fields) != 7 {
+		t.Fatalf("bad message: %q", msg)
+	}
+	if _, err := time.Parse(time.RFC3339Nano, fields[1]); err != nil {
+		t.Fatalf("bad timestamp %q: %v", fields[1], err)
+	}
+	if fields[2] != "vault-test" || fields[3] != "vault" || fields[5] != "request" {
+		t.Fatalf("bad header fields: %q", fields)
+	}
+
+	expectedSD := `[vault@32473 request_id="req-1" mount="secret/\"quoted\"\]/" operation="update"] {`
+	if !strings.HasPrefix(fields[6], expectedSD) {
+		t.Fatalf("bad structured data:\nexpected prefix: %s\ngot: %s", expectedSD, fields[6])
+	}
+	if strings.HasSuffix(msg, "\n") {
+		t.Fatalf("trailing newline in message: %q", msg)
+	}
+}
+
+func TestAuditSyslog_remoteTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	msgs := make(chan string, 2)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		for i := 0; i < 2; i++ {
+			msgs <- readOctetCounted(t, r)
+		}
+	}()
+
+	b := testRemoteBackend(t, map[string]string{
+		"address": ln.Addr().String(),
+		"network": "tcp",
+	})
+
+	testLogRequest(t, b)
+	testLogRequest(t, b)
+	for i := 0; i < 2; i++ {
+		select {
+		case msg := <-msgs:
+			checkMessage(t, msg)
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for message")
+		}
+	}
+}
+
+func TestAuditSyslog_remoteUDP(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	b := testRemoteBackend(t, map[string]string{
+		"address": pc.LocalAddr().String(),
+	})
+	testLogRequest(t, b)
+
+	pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 64*1024)
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkMessage(t, string(buf[:n]))
+}
+
+func TestAuditSyslog_remoteTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vault-test_audit_syslog-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cert, caFile := testServerCert(t, dir)
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{cert},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	msgs := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		msgs <- readOctetCounted(t, bufio.NewReader(conn))
+	}()
+
+	b := testRemoteBackend(t, map[string]string{
+		"address":     ln.Addr().String(),
+		"network":     "tls",
+		"tls_ca_cert": caFile,
+	})
+	testLogRequest(t, b)
+
+	select {
+	case msg := <-msgs:
+		checkMessage(t, msg)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for message")
+	}
+}
+
+func TestAuditSyslog_remoteBackoff(t *testing.T) {
+	// Reserve a port and close it so that dials are refused
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	b := testRemoteBackend(t, map[string]string{
+		"address":     addr,
+		"network":     "tcp",
+		"max_backoff": "1s",
+	})
+
+	now := time.Now()
+	b.remote.now = func() time.Time { return now }
+
+	in := &audit.LogInput{Request: &logical.Request{ID: "req-1"}}
+	if err := b.LogRequest(namespace.RootContext(nil), in); err == nil {
+		t.Fatal("expected error")
+	}
+	if b.remote.backoff != minReconnectBackoff {
+		t.Fatalf("bad backoff: %s", b.remote.backoff)
+	}
+
+	// Still inside the backoff window, no dial should be attempted
+	err = b.LogRequest(namespace.RootContext(nil), in)
+	if err == nil || !strings.Contains(err.Error(), "next reconnect attempt") {
+		t.Fatalf("expected backoff error, got %v", err)
+	}
+
+	for _, expected := range []time.Duration{500 * time.Millisecond, time.Second, time.Second} {
+		now = b.remote.nextDialAt
+		if err := b.LogRequest(namespace.RootContext(nil), in); err == nil {
+			t.Fatal("expected error")
+		}
+		if b.remote.backoff != expected {
+			t.Fatalf("bad backoff: expected %s, got %s", expected, b.remote.backoff)
+		}
+	}
+
+	// Bring the collector up; a reload skips the remaining backoff
+	ln, err = net.Listen("tcp", addr)
+	if err != nil {
+		t.Skipf("unable to reuse address: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			defer conn.Close()
+			ioutil.ReadAll(conn)
+		}
+	}()
+
+	if err := b.Reload(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.LogRequest(namespace.RootContext(nil), in); err != nil {
+		t.Fatal(err)
+	}
+	if b.remote.backoff != 0 {
+		t.Fatalf("backoff not reset: %s", b.remote.backoff)
+	}
+}
+
+func TestAuditSyslog_remoteConfig(t *testing.T) {
+	cases := map[string]map[string]string{
+		"bad network":  {"address": "127.0.0.1:514", "network": "unix"},
+		"bad facility": {"address": "127.0.0.1:514", "facility": "NOPE"},
+		"bad sd id":    {"address": "127.0.0.1:514", "structured_data_id": "a b"},
+		"partial tls":  {"address": "127.0.0.1:514", "network": "tls", "tls_client_cert": "cert.pem"},
+	}
+	for name, config := range cases {
+		_, err := Factory(context.Background(), &audit.BackendConfig{
+			SaltConfig: &salt.Config{},
+			SaltView:   &logical.InmemStorage{},
+			Config:     config,
+		})
+		if err == nil {
+			t.Fatalf("%s: expected error", name)
+		}
+	}
+}
+
+func testServerCert(t *testing.T, dir string) (tls.Certificate, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	caFile := filepath.Join(dir, "ca.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := ioutil.WriteFile(caFile, certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, caFile
+}
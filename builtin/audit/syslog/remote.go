@@ -0,0 +1,342 @@
+package syslog
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault/audit"
+	"github.com/hashicorp/vault/helper/parseutil"
+	"github.com/hashicorp/vault/logical"
+)
+
+const (
+	// rfc5424TimeFormat is the TIMESTAMP format from RFC 5424 section 6.2.3,
+	// using microsecond precision.
+	rfc5424TimeFormat = "2006-01-02T15:04:05.000000Z07:00"
+
+	// severityInfo is the syslog severity used for all audit entries.
+	severityInfo = 6
+
+	// defaultStructuredDataID uses the example private enterprise number
+	// reserved by RFC 5612 so that collectors can key on it without
+	// colliding with registered IDs.
+	defaultStructuredDataID = "vault@32473"
+
+	minReconnectBackoff = 250 * time.Millisecond
+)
+
+var facilities = map[string]int{
+	"KERN":     0,
+	"USER":     1,
+	"MAIL":     2,
+	"DAEMON":   3,
+	"AUTH":     4,
+	"SYSLOG":   5,
+	"LPR":      6,
+	"NEWS":     7,
+	"UUCP":     8,
+	"CRON":     9,
+	"AUTHPRIV": 10,
+	"FTP":      11,
+	"LOCAL0":   16,
+	"LOCAL1":   17,
+	"LOCAL2":   18,
+	"LOCAL3":   19,
+	"LOCAL4":   20,
+	"LOCAL5":   21,
+	"LOCAL6":   22,
+	"LOCAL7":   23,
+}
+
+// remoteWriter sends RFC 5424 formatted messages to a remote syslog
+// collector over UDP (RFC 5426), TCP (RFC 6587) or TLS (RFC 5425). Stream
+// transports use octet-counting framing. Failed connections are retried with
+// an exponential backoff so that an unreachable collector does not stall
+// every request while it is down.
+type remoteWriter struct {
+	network   string
+	address   string
+	tlsConfig *tls.Config
+
+	priority int
+	hostname string
+	appName  string
+	procID   string
+	sdID     string
+
+	writeTimeout time.Duration
+	maxBackoff   time.Duration
+
+	l          sync.Mutex
+	conn       net.Conn
+	backoff    time.Duration
+	nextDialAt time.Time
+
+	// now is overridden in tests
+	now func() time.Time
+}
+
+func newRemoteWriter(conf map[string]string, facility, tag string) (*remoteWriter, error) {
+	fac, ok := facilities[strings.ToUpper(facility)]
+	if !ok {
+		return nil, fmt.Errorf("invalid syslog facility %q", facility)
+	}
+
+	network, ok := conf["network"]
+	if !ok {
+		network = "udp"
+	}
+	switch network {
+	case "udp", "tcp", "tls":
+	default:
+		return nil, fmt.Errorf("unknown network type %q, must be one of \"udp\", \"tcp\" or \"tls\"", network)
+	}
+
+	hostname, ok := conf["hostname"]
+	if !ok {
+		var err error
+		hostname, err = os.Hostname()
+		if err != nil || hostname == "" {
+			hostname = "-"
+		}
+	}
+
+	sdID, ok := conf["structured_data_id"]
+	if !ok {
+		sdID = defaultStructuredDataID
+	}
+	if !validSDName(sdID) {
+		return nil, fmt.Errorf("invalid structured_data_id %q", sdID)
+	}
+
+	writeTimeout, ok := conf["write_timeout"]
+	if !ok {
+		writeTimeout = "2s"
+	}
+	writeDuration, err := parseutil.ParseDurationSecond(writeTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	maxBackoffRaw, ok := conf["max_backoff"]
+	if !ok {
+		maxBackoffRaw = "30s"
+	}
+	maxBackoff, err := parseutil.ParseDurationSecond(maxBackoffRaw)
+	if err != nil {
+		return nil, err
+	}
+	if maxBackoff < minReconnectBackoff {
+		maxBackoff = minReconnectBackoff
+	}
+
+	w := &remoteWriter{
+		network:      network,
+		address:      conf["address"],
+		priority:     fac*8 + severityInfo,
+		hostname:     headerField(hostname, 255),
+		appName:      headerField(tag, 48),
+		procID:       strconv.Itoa(os.Getpid()),
+		sdID:         sdID,
+		writeTimeout: writeDuration,
+		maxBackoff:   maxBackoff,
+		now:          time.Now,
+	}
+
+	if network == "tls" {
+		w.tlsConfig, err = audit.ClientTLSConfig(conf, w.address)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return w, nil
+}
+
+// Write formats msg as an RFC 5424 message and sends it to the collector.
+func (w *remoteWriter) Write(msgID string, req *logical.Request, msg []byte) error {
+	frame := w.format(msgID, req, msg)
+
+	w.l.Lock()
+	defer w.l.Unlock()
+
+	hadConn := w.conn != nil
+	err := w.write(frame)
+	if err != nil && hadConn {
+		// The collector may have closed an idle connection; redial once
+		// before reporting the failure.
+		w.close()
+		err = w.write(frame)
+	}
+	if err != nil {
+		w.close()
+	}
+
+	return err
+}
+
+func (w *remoteWriter) format(msgID string, req *logical.Request, msg []byte) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "<%d>1 %s %s %s %s %s ",
+		w.priority,
+		w.now().UTC().Format(rfc5424TimeFormat),
+		w.hostname,
+		w.appName,
+		w.procID,
+		msgID)
+
+	if req == nil {
+		buf.WriteString("-")
+	} else {
+		buf.WriteString("[")
+		buf.WriteString(w.sdID)
+		writeSDParam(&buf, "request_id", req.ID)
+		writeSDParam(&buf, "mount", req.MountPoint)
+		writeSDParam(&buf, "operation", string(req.Operation))
+		buf.WriteString("]")
+	}
+
+	msg = bytes.TrimRight(msg, "\n")
+	if len(msg) > 0 {
+		buf.WriteString(" ")
+		buf.Write(msg)
+	}
+
+	if w.network == "udp" {
+		return buf.Bytes()
+	}
+
+	framed := make([]byte, 0, buf.Len()+8)
+	framed = strconv.AppendInt(framed, int64(buf.Len()), 10)
+	framed = append(framed, ' ')
+	return append(framed, buf.Bytes()...)
+}
+
+func (w *remoteWriter) write(frame []byte) error {
+	if w.conn == nil {
+		if err := w.dial(); err != nil {
+			return err
+		}
+	}
+
+	if err := w.conn.SetWriteDeadline(w.now().Add(w.writeTimeout)); err != nil {
+		return err
+	}
+
+	_, err := w.conn.Write(frame)
+	return err
+}
+
+func (w *remoteWriter) dial() error {
+	now := w.now()
+	if now.Before(w.nextDialAt) {
+		return fmt.Errorf("syslog collector %q unavailable, next reconnect attempt in %s", w.address, w.nextDialAt.Sub(now).Round(time.Millisecond))
+	}
+
+	dialer := &net.Dialer{Timeout: w.writeTimeout}
+
+	var conn net.Conn
+	var err error
+	switch w.network {
+	case "tls":
+		conn, err = tls.DialWithDialer(dialer, "tcp", w.address, w.tlsConfig)
+	default:
+		conn, err = dialer.Dial(w.network, w.address)
+	}
+	if err != nil {
+		switch {
+		case w.backoff == 0:
+			w.backoff = minReconnectBackoff
+		case w.backoff*2 > w.maxBackoff:
+			w.backoff = w.maxBackoff
+		default:
+			w.backoff *= 2
+		}
+		w.nextDialAt = now.Add(w.backoff)
+		return err
+	}
+
+	w.conn = conn
+	w.backoff = 0
+	w.nextDialAt = time.Time{}
+	return nil
+}
+
+func (w *remoteWriter) close() {
+	if w.conn != nil {
+		w.conn.Close()
+		w.conn = nil
+	}
+}
+
+// Reset drops the current connection and any pending backoff so that the
+// next write dials the collector again.
+func (w *remoteWriter) Reset() {
+	w.l.Lock()
+	defer w.l.Unlock()
+
+	w.close()
+	w.backoff = 0
+	w.nextDialAt = time.Time{}
+}
+
+// writeSDParam writes a single SD-PARAM, escaping the characters RFC 5424
+// section 6.3.3 requires.
+func writeSDParam(buf *bytes.Buffer, name, value string) {
+	if value == "" {
+		return
+	}
+
+	buf.WriteString(" ")
+	buf.WriteString(name)
+	buf.WriteString(`="`)
+	for _, r := range value {
+		switch r {
+		case '"', '\\', ']':
+			buf.WriteByte('\\')
+		}
+		buf.WriteRune(r)
+	}
+	buf.WriteString(`"`)
+}
+
+// validSDName reports whether s is a valid SD-NAME: 1-32 printable US-ASCII
+// characters excluding '=', ' ', ']' and '"'.
+func validSDName(s string) bool {
+	if len(s) == 0 || len(s) > 32 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < 33 || c > 126 || c == '=' || c == ']' || c == '"' {
+			return false
+		}
+	}
+	return true
+}
+
+// headerField converts s into a valid RFC 5424 header field, replacing
+// characters outside printable US-ASCII and truncating it to max bytes.
+func headerField(s string, max int) string {
+	if s == "" {
+		return "-"
+	}
+
+	b := []byte(s)
+	for i, c := range b {
+		if c < 33 || c > 126 {
+			b[i] = '_'
+		}
+	}
+	if len(b) > max {
+		b = b[:max]
+	}
+	return string(b)
+}
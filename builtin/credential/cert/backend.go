@@ -2,9 +2,11 @@ package cert
 
 import (
 	"context"
+	"net/http"
 	"strings"
 	"sync"
 
+	cleanhttp "github.com/hashicorp/go-cleanhttp"
 	"github.com/hashicorp/vault/logical"
 	"github.com/hashicorp/vault/logical/framework"
 )
@@ -33,12 +35,15 @@ func Backend() *backend {
 			pathCerts(&b),
 			pathCRLs(&b),
 		}),
-		AuthRenew:   b.pathLoginRenew,
-		Invalidate:  b.invalidate,
-		BackendType: logical.TypeCredential,
+		AuthRenew:    b.pathLoginRenew,
+		Invalidate:   b.invalidate,
+		PeriodicFunc: b.periodicFunc,
+		BackendType:  logical.TypeCredential,
 	}
 
 	b.crlUpdateMutex = &sync.RWMutex{}
+	b.dpCRLs = map[string]*distributionPointCRL{}
+	b.httpClient = cleanhttp.DefaultPooledClient()
 
 	return &b
 }
@@ -49,6 +54,12 @@ type backend struct {
 
 	crls           map[string]CRLInfo
 	crlUpdateMutex *sync.RWMutex
+
+	// dpCRLs caches the CRLs fetched from the CRL distribution points of
+	// client certificates, by URL
+	dpCRLs     map[string]*distributionPointCRL
+	dpCRLsLock sync.RWMutex
+	httpClient *http.Client
 }
 
 func (b *backend) invalidate(_ context.Context, key string) {
@@ -57,6 +68,8 @@ func (b *backend) invalidate(_ context.Context, key string) {
 		b.crlUpdateMutex.Lock()
 		defer b.crlUpdateMutex.Unlock()
 		b.crls = nil
+	case strings.HasPrefix(key, "cert/"):
+		b.purgeDistributionPointCRLs()
 	}
 }
 
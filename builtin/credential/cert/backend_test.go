@@ -3,8 +3,11 @@ package cert
 import (
 	"context"
 	"crypto/rand"
+	"encoding/pem"
 	"net/http"
+	"net/http/httptest"
 
+	"golang.org/x/crypto/ocsp"
 	"golang.org/x/net/http2"
 
 	"crypto/rsa"
@@ -1757,3 +1760,325 @@ func Test_Renew(t *testing.T) {
 		t.Fatal("expected error")
 	}
 }
+
+func TestBackend_OCSPAndCRLDistributionPoints(t *testing.T) {
+	caKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Revocation CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caBytes, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, caKey.Public(), caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCert, err := x509.ParseCertificate(caBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caPEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caBytes}))
+
+	// The OCSP responders answer with the status of their variable, or fail
+	// when it is negative
+	ocspQueries := 0
+	ocspResponder := func(status *int) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ocspQueries++
+			body, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ocspReq, err := ocsp.ParseRequest(body)
+			if err != nil || *status < 0 {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			resp, err := ocsp.CreateResponse(caCert, caCert, ocsp.Response{
+				Status:       *status,
+				SerialNumber: ocspReq.SerialNumber,
+				ThisUpdate:   time.Now().Add(-time.Minute),
+				NextUpdate:   time.Now().Add(time.Hour),
+				RevokedAt:    time.Now().Add(-time.Minute),
+			}, caKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w.Write(resp)
+		}))
+	}
+	aiaStatus, overrideStatus := ocsp.Good, ocsp.Good
+	aiaServer := ocspResponder(&aiaStatus)
+	defer aiaServer.Close()
+	overrideServer := ocspResponder(&overrideStatus)
+	defer overrideServer.Close()
+
+	var crlRevoked []pkix.RevokedCertificate
+	crlDown := false
+	crlFetches := 0
+	crlServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		crlFetches++
+		if crlDown {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		crl, err := caCert.CreateCRL(rand.Reader, caKey, crlRevoked, time.Now(), time.Now().Add(time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(crl)
+	}))
+	defer crlServer.Close()
+
+	clientKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientBytes, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber:          big.NewInt(2),
+		Subject:               pkix.Name{CommonName: "client"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		OCSPServer:            []string{aiaServer.URL},
+		CRLDistributionPoints: []string{"ldap://ldap.example.com/crl", crlServer.URL},
+	}, caCert, clientKey.Public(), caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCert, err := x509.ParseCertificate(clientBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	connState := &tls.ConnectionState{PeerCertificates: []*x509.Certificate{clientCert}}
+
+	config := logical.TestBackendConfig()
+	storage := &logical.InmemStorage{}
+	config.StorageView = storage
+	b := Backend()
+	if err := b.Setup(context.Background(), config); err != nil {
+		t.Fatal(err)
+	}
+
+	writeCert := func(data map[string]interface{}) {
+		t.Helper()
+
+		data["certificate"] = caPEM
+		data["policies"] = "foo"
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "certs/revocation",
+			Storage:   storage,
+			Data:      data,
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%v resp:%#v", err, resp)
+		}
+	}
+	loginAs := func(name string, connState *tls.ConnectionState, expectSuccess bool) {
+		t.Helper()
+
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation:       logical.UpdateOperation,
+			Path:            "login",
+			Unauthenticated: true,
+			Storage:         storage,
+			Connection:      &logical.Connection{ConnState: connState},
+			Data: map[string]interface{}{
+				"name": name,
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if success := resp != nil && !resp.IsError() && resp.Auth != nil; success != expectSuccess {
+			t.Fatalf("expected login success %t, got resp:%#v", expectSuccess, resp)
+		}
+	}
+	login := func(expectSuccess bool) {
+		t.Helper()
+		loginAs("revocation", connState, expectSuccess)
+	}
+
+	// OCSP through the Authority Information Access of the certificate
+	writeCert(map[string]interface{}{
+		"ocsp_enabled": true,
+	})
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "certs/revocation",
+		Storage:   storage,
+	})
+	if err != nil || resp.Data["ocsp_enabled"] != true || resp.Data["revocation_fail_open"] != false {
+		t.Fatalf("err:%v resp:%#v", err, resp)
+	}
+	login(true)
+	aiaStatus = ocsp.Revoked
+	login(false)
+	aiaStatus = ocsp.Unknown
+	login(false)
+
+	// A configured responder is used instead
+	writeCert(map[string]interface{}{
+		"ocsp_enabled":          true,
+		"ocsp_servers_override": overrideServer.URL,
+	})
+	login(true)
+	overrideStatus = ocsp.Revoked
+	login(false)
+
+	// Failing closed or open when the responder can't be reached, but never
+	// allowing revoked certificates
+	overrideStatus = -1
+	login(false)
+	writeCert(map[string]interface{}{
+		"ocsp_enabled":          true,
+		"ocsp_servers_override": overrideServer.URL,
+		"revocation_fail_open":  true,
+	})
+	login(true)
+	overrideStatus = ocsp.Revoked
+	login(false)
+
+	// CRLs are fetched from the distribution points and cached
+	writeCert(map[string]interface{}{
+		"crl_distribution_points_enabled": true,
+	})
+	login(true)
+	crlRevoked = []pkix.RevokedCertificate{{SerialNumber: clientCert.SerialNumber, RevocationTime: time.Now()}}
+	login(true)
+	if crlFetches != 1 {
+		t.Fatalf("expected the CRL to be cached, got %d fetches", crlFetches)
+	}
+
+	// They are refreshed in the background once the refresh interval passed
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"crl_refresh_interval": "30m",
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%v resp:%#v", err, resp)
+	}
+	if err := b.periodicFunc(context.Background(), &logical.Request{Storage: storage}); err != nil {
+		t.Fatal(err)
+	}
+	if crlFetches != 1 {
+		t.Fatalf("expected the CRL to be cached, got %d fetches", crlFetches)
+	}
+	b.dpCRLs[crlServer.URL].fetchedAt = time.Now().Add(-31 * time.Minute)
+	if err := b.periodicFunc(context.Background(), &logical.Request{Storage: storage}); err != nil {
+		t.Fatal(err)
+	}
+	if crlFetches != 2 {
+		t.Fatalf("expected the CRL to be refreshed, got %d fetches", crlFetches)
+	}
+	login(false)
+
+	// A CRL which can't be refreshed is used until its next update
+	crlRevoked = nil
+	crlDown = true
+	b.dpCRLs[crlServer.URL].fetchedAt = time.Now().Add(-31 * time.Minute)
+	login(false)
+	if crlFetches != 3 {
+		t.Fatalf("expected the CRL to be refreshed, got %d fetches", crlFetches)
+	}
+	delete(b.dpCRLs, crlServer.URL)
+	login(false)
+	writeCert(map[string]interface{}{
+		"crl_distribution_points_enabled": true,
+		"revocation_fail_open":            true,
+	})
+	login(true)
+
+	// Pinned non-CA certificates are checked with the issuer presented by
+	// the client, and rejected when it is missing unless failing open
+	writePinned := func(failOpen bool) {
+		t.Helper()
+
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "certs/pinned",
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"certificate":           string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: clientBytes})),
+				"policies":              "foo",
+				"ocsp_enabled":          true,
+				"ocsp_servers_override": overrideServer.URL,
+				"revocation_fail_open":  failOpen,
+			},
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%v resp:%#v", err, resp)
+		}
+	}
+	chainConnState := &tls.ConnectionState{PeerCertificates: []*x509.Certificate{clientCert, caCert}}
+	writePinned(false)
+	overrideStatus = ocsp.Good
+	loginAs("pinned", chainConnState, true)
+	overrideStatus = ocsp.Revoked
+	loginAs("pinned", chainConnState, false)
+	loginAs("pinned", connState, false)
+	overrideStatus = ocsp.Good
+	loginAs("pinned", connState, false)
+	writePinned(true)
+	loginAs("pinned", connState, true)
+	overrideStatus = ocsp.Revoked
+	loginAs("pinned", chainConnState, false)
+
+	// The revocation is checked once per entry and chain, however many of
+	// the certificates of the entry are in the chain
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "certs/revocation",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"certificate":           caPEM + caPEM,
+			"policies":              "foo",
+			"ocsp_enabled":          true,
+			"ocsp_servers_override": overrideServer.URL,
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%v resp:%#v", err, resp)
+	}
+	ocspQueries = 0
+	login(false)
+	if ocspQueries != 1 {
+		t.Fatalf("expected one OCSP query, got %d", ocspQueries)
+	}
+
+	// The cached CRLs are bounded, and dropped with the entries
+	crlDown = false
+	for i := 0; i <= maxDistributionPointCRLs; i++ {
+		if _, err := b.fetchDistributionPointCRL(context.Background(), fmt.Sprintf("%s/%d", crlServer.URL, i)); err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			b.dpCRLs[crlServer.URL+"/0"].fetchedAt = time.Now().Add(-time.Minute)
+		}
+	}
+	if len(b.dpCRLs) != maxDistributionPointCRLs || b.dpCRLs[crlServer.URL+"/0"] != nil {
+		t.Fatalf("expected the oldest CRL to be evicted, got %d CRLs", len(b.dpCRLs))
+	}
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      "certs/revocation",
+		Storage:   storage,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%v resp:%#v", err, resp)
+	}
+	if len(b.dpCRLs) != 0 {
+		t.Fatalf("expected the CRLs to be dropped, got %d", len(b.dpCRLs))
+	}
+}
@@ -123,6 +123,34 @@ TTL will be set to the value of this parameter.`,
 				Description: `Comma separated string or list of CIDR blocks. If set, specifies the blocks of
 IP addresses which can perform the login operation.`,
 			},
+
+			"ocsp_enabled": &framework.FieldSchema{
+				Type: framework.TypeBool,
+				Description: `If set, the client certificate is checked against
+the OCSP responders of its Authority Information Access, or
+against "ocsp_servers_override".`,
+			},
+
+			"ocsp_servers_override": &framework.FieldSchema{
+				Type: framework.TypeCommaStringSlice,
+				Description: `A comma-separated list of OCSP responder URLs
+queried instead of the ones of the client certificate.`,
+			},
+
+			"crl_distribution_points_enabled": &framework.FieldSchema{
+				Type: framework.TypeBool,
+				Description: `If set, the client certificate is checked against
+the CRL fetched from its CRL distribution points, which is cached
+and refreshed according to the "crl_refresh_interval" of the
+configuration.`,
+			},
+
+			"revocation_fail_open": &framework.FieldSchema{
+				Type: framework.TypeBool,
+				Description: `If set, client certificates whose revocation status
+can't be determined through OCSP or CRL distribution points are
+allowed; by default they are rejected.`,
+			},
 		},
 
 		Callbacks: map[logical.Operation]framework.OperationFunc{
@@ -157,6 +185,7 @@ func (b *backend) pathCertDelete(ctx context.Context, req *logical.Request, d *f
 	if err != nil {
 		return nil, err
 	}
+	b.purgeDistributionPointCRLs()
 	return nil, nil
 }
 
@@ -191,6 +220,11 @@ func (b *backend) pathCertRead(ctx context.Context, req *logical.Request, d *fra
 			"allowed_email_sans":   cert.AllowedEmailSANs,
 			"allowed_uri_sans":     cert.AllowedURISANs,
 			"required_extensions":  cert.RequiredExtensions,
+
+			"ocsp_enabled":                    cert.OCSPEnabled,
+			"ocsp_servers_override":           cert.OCSPServersOverride,
+			"crl_distribution_points_enabled": cert.CRLDistributionPointsEnabled,
+			"revocation_fail_open":            cert.RevocationFailOpen,
 		},
 	}, nil
 }
@@ -206,6 +240,10 @@ func (b *backend) pathCertWrite(ctx context.Context, req *logical.Request, d *fr
 	allowedEmailSANs := d.Get("allowed_email_sans").([]string)
 	allowedURISANs := d.Get("allowed_uri_sans").([]string)
 	requiredExtensions := d.Get("required_extensions").([]string)
+	ocspEnabled := d.Get("ocsp_enabled").(bool)
+	ocspServersOverride := d.Get("ocsp_servers_override").([]string)
+	crlDistributionPointsEnabled := d.Get("crl_distribution_points_enabled").(bool)
+	revocationFailOpen := d.Get("revocation_fail_open").(bool)
 
 	var resp logical.Response
 
@@ -272,6 +310,12 @@ func (b *backend) pathCertWrite(ctx context.Context, req *logical.Request, d *fr
 		}
 	}
 
+	for _, server := range ocspServersOverride {
+		if !strings.HasPrefix(server, "http://") && !strings.HasPrefix(server, "https://") {
+			return logical.ErrorResponse(fmt.Sprintf("invalid OCSP responder URL %q", server)), nil
+		}
+	}
+
 	parsedCIDRs, err := parseutil.ParseAddrs(d.Get("bound_cidrs"))
 	if err != nil {
 		return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
@@ -292,6 +336,11 @@ func (b *backend) pathCertWrite(ctx context.Context, req *logical.Request, d *fr
 		MaxTTL:             maxTTL,
 		Period:             period,
 		BoundCIDRs:         parsedCIDRs,
+
+		OCSPEnabled:                  ocspEnabled,
+		OCSPServersOverride:          ocspServersOverride,
+		CRLDistributionPointsEnabled: crlDistributionPointsEnabled,
+		RevocationFailOpen:           revocationFailOpen,
 	}
 
 	// Store it
@@ -325,6 +374,11 @@ type CertEntry struct {
 	AllowedURISANs     []string
 	RequiredExtensions []string
 	BoundCIDRs         []*sockaddr.SockAddrMarshaler
+
+	OCSPEnabled                  bool
+	OCSPServersOverride          []string
+	CRLDistributionPointsEnabled bool
+	RevocationFailOpen           bool
 }
 
 const pathCertHelpSyn = `
@@ -2,6 +2,7 @@ package cert
 
 import (
 	"context"
+	"time"
 
 	"github.com/hashicorp/errwrap"
 	"github.com/hashicorp/vault/logical"
@@ -17,6 +18,11 @@ func pathConfig(b *backend) *framework.Path {
 				Default:     false,
 				Description: `If set, during renewal, skips the matching of presented client identity with the client identity used during login. Defaults to false.`,
 			},
+			"crl_refresh_interval": &framework.FieldSchema{
+				Type:        framework.TypeDurationSecond,
+				Default:     int(defaultCRLRefreshInterval.Seconds()),
+				Description: `The interval at which the CRLs fetched from the CRL distribution points of client certificates are refreshed, unless their next update comes first. Defaults to 1 hour.`,
+			},
 		},
 
 		Callbacks: map[logical.Operation]framework.OperationFunc{
@@ -27,9 +33,15 @@ func pathConfig(b *backend) *framework.Path {
 
 func (b *backend) pathConfigWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	disableBinding := data.Get("disable_binding").(bool)
+	crlRefreshInterval := time.Duration(data.Get("crl_refresh_interval").(int)) * time.Second
+
+	if crlRefreshInterval <= 0 {
+		return logical.ErrorResponse("crl_refresh_interval must be greater than zero"), nil
+	}
 
 	entry, err := logical.StorageEntryJSON("config", config{
-		DisableBinding: disableBinding,
+		DisableBinding:     disableBinding,
+		CRLRefreshInterval: crlRefreshInterval,
 	})
 	if err != nil {
 		return nil, err
@@ -59,5 +71,16 @@ func (b *backend) Config(ctx context.Context, s logical.Storage) (*config, error
 }
 
 type config struct {
-	DisableBinding bool `json:"disable_binding"`
+	DisableBinding     bool          `json:"disable_binding"`
+	CRLRefreshInterval time.Duration `json:"crl_refresh_interval"`
+}
+
+// crlRefreshInterval returns the refresh interval of the CRLs fetched from
+// CRL distribution points, which configurations written before it existed
+// lack
+func (c *config) crlRefreshInterval() time.Duration {
+	if c.CRLRefreshInterval <= 0 {
+		return defaultCRLRefreshInterval
+	}
+	return c.CRLRefreshInterval
 }
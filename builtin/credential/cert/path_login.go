@@ -190,6 +190,11 @@ func (b *backend) verifyCredentials(ctx context.Context, req *logical.Request, d
 		certName = d.Get("name").(string)
 	}
 
+	conf, err := b.Config(ctx, req.Storage)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	// Load the trusted certificates
 	roots, trusted, trustedNonCAs := b.loadTrustedCerts(ctx, req.Storage, certName)
 
@@ -208,7 +213,7 @@ func (b *backend) verifyCredentials(ctx context.Context, req *logical.Request, d
 			// Check for client cert being explicitly listed in the config (and matching other constraints)
 			if tCert.SerialNumber.Cmp(clientCert.SerialNumber) == 0 &&
 				bytes.Equal(tCert.AuthorityKeyId, clientCert.AuthorityKeyId) &&
-				b.matchesConstraints(clientCert, trustedNonCA.Certificates, trustedNonCA) {
+				b.matchesConstraints(ctx, conf, connState, clientCert, trustedNonCA.Certificates, trustedNonCA) {
 				return trustedNonCA, nil, nil
 			}
 		}
@@ -220,39 +225,45 @@ func (b *backend) verifyCredentials(ctx context.Context, req *logical.Request, d
 		return nil, logical.ErrorResponse("invalid certificate or no client certificate supplied"), nil
 	}
 
-	// Search for a ParsedCert that intersects with the validated chains and any additional constraints
-	matches := make([]*ParsedCert, 0)
+	// Search for a ParsedCert that intersects with the validated chains and any
+	// additional constraints. The constraints, whose revocation checks may
+	// query remote servers, are only checked once per entry and chain.
 	for _, trust := range trusted { // For each ParsedCert in the config
-		for _, tCert := range trust.Certificates { // For each certificate in the entry
-			for _, chain := range trustedChains { // For each root chain that we matched
-				for _, cCert := range chain { // For each cert in the matched chain
-					if tCert.Equal(cCert) && // ParsedCert intersects with matched chain
-						b.matchesConstraints(clientCert, chain, trust) { // validate client cert + matched chain against the config
-						// Add the match to the list
-						matches = append(matches, trust)
-					}
-				}
+		for _, chain := range trustedChains { // For each root chain that we matched
+			if chainIntersects(trust.Certificates, chain) && // ParsedCert intersects with matched chain
+				b.matchesConstraints(ctx, conf, connState, clientCert, chain, trust) { // validate client cert + matched chain against the config
+				// Return the first matching entry (for backwards compatibility, we continue to just pick one if multiple match)
+				return trust, nil, nil
 			}
 		}
 	}
 
 	// Fail on no matches
-	if len(matches) == 0 {
-		return nil, logical.ErrorResponse("no chain matching all constraints could be found for this login certificate"), nil
-	}
+	return nil, logical.ErrorResponse("no chain matching all constraints could be found for this login certificate"), nil
+}
 
-	// Return the first matching entry (for backwards compatibility, we continue to just pick one if multiple match)
-	return matches[0], nil, nil
+// chainIntersects returns whether any of the certificates is in the chain
+func chainIntersects(certs []*x509.Certificate, chain []*x509.Certificate) bool {
+	for _, tCert := range certs {
+		for _, cCert := range chain {
+			if tCert.Equal(cCert) {
+				return true
+			}
+		}
+	}
+	return false
 }
 
-func (b *backend) matchesConstraints(clientCert *x509.Certificate, trustedChain []*x509.Certificate, config *ParsedCert) bool {
+func (b *backend) matchesConstraints(ctx context.Context, conf *config, connState *tls.ConnectionState, clientCert *x509.Certificate, trustedChain []*x509.Certificate, config *ParsedCert) bool {
+	// The revocation checks may query remote servers, so they come last
 	return !b.checkForChainInCRLs(trustedChain) &&
 		b.matchesNames(clientCert, config) &&
 		b.matchesCommonName(clientCert, config) &&
 		b.matchesDNSSANs(clientCert, config) &&
 		b.matchesEmailSANs(clientCert, config) &&
 		b.matchesURISANs(clientCert, config) &&
-		b.matchesCertificateExtensions(clientCert, config)
+		b.matchesCertificateExtensions(clientCert, config) &&
+		!b.checkForChainRevocation(ctx, conf, connState, trustedChain, config.Entry)
 }
 
 // matchesNames verifies that the certificate matches at least one configured
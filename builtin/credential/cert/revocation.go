@@ -0,0 +1,343 @@
+package cert
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/logical"
+	"golang.org/x/crypto/ocsp"
+)
+
+const (
+	// revocationRequestTimeout bounds the OCSP requests and CRL downloads
+	revocationRequestTimeout = 10 * time.Second
+
+	// maxRevocationResponseSize bounds the size of OCSP responses and CRLs
+	maxRevocationResponseSize = 32 * 1024 * 1024
+
+	// maxDistributionPointCRLs bounds the number of cached CRLs of the
+	// distribution points; the ones fetched the longest ago are evicted
+	maxDistributionPointCRLs = 64
+
+	defaultCRLRefreshInterval = time.Hour
+)
+
+var errCertRevoked = errors.New("certificate is revoked")
+
+// distributionPointCRL is a CRL fetched from a CRL distribution point. Its
+// signature is checked against the issuer of the certificate on each use,
+// since any issuer may point to the same URL.
+type distributionPointCRL struct {
+	crl       *pkix.CertificateList
+	serials   map[string]bool
+	fetchedAt time.Time
+}
+
+// needsRefresh returns whether the CRL was fetched longer than interval ago,
+// or is past its next update
+func (c *distributionPointCRL) needsRefresh(interval time.Duration) bool {
+	nextUpdate := c.crl.TBSCertList.NextUpdate
+	return time.Since(c.fetchedAt) >= interval || (!nextUpdate.IsZero() && time.Now().After(nextUpdate))
+}
+
+// checkForChainRevocation checks the client certificate of the chain against
+// the OCSP responders and CRL distribution points enabled on the entry, and
+// returns whether the chain must be rejected: when the certificate is
+// revoked, or when its status can't be determined and the entry fails closed
+func (b *backend) checkForChainRevocation(ctx context.Context, conf *config, connState *tls.ConnectionState, chain []*x509.Certificate, entry *CertEntry) bool {
+	if !entry.OCSPEnabled && !entry.CRLDistributionPointsEnabled {
+		return false
+	}
+
+	cert := chain[0]
+
+	// A trusted self-signed certificate has no issuer to check it with
+	if len(chain) < 2 && bytes.Equal(cert.RawIssuer, cert.RawSubject) &&
+		cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature) == nil {
+		return false
+	}
+
+	issuer := findIssuer(connState, chain)
+	if issuer == nil {
+		if entry.RevocationFailOpen {
+			b.Logger().Warn("unable to find the issuer of the client certificate to check its revocation; allowing it", "cert_name", entry.Name, "serial_number", cert.SerialNumber.String())
+			return false
+		}
+		b.Logger().Warn("unable to find the issuer of the client certificate to check its revocation; rejecting it", "cert_name", entry.Name, "serial_number", cert.SerialNumber.String())
+		return true
+	}
+
+	var checks []func() error
+	if entry.OCSPEnabled {
+		checks = append(checks, func() error {
+			return b.checkOCSP(ctx, cert, issuer, entry.OCSPServersOverride)
+		})
+	}
+	if entry.CRLDistributionPointsEnabled {
+		checks = append(checks, func() error {
+			return b.checkCRLDistributionPoints(ctx, conf, cert, issuer)
+		})
+	}
+
+	for _, check := range checks {
+		err := check()
+		switch {
+		case err == nil:
+		case err == errCertRevoked:
+			b.Logger().Info("client certificate is revoked", "cert_name", entry.Name, "serial_number", cert.SerialNumber.String())
+			return true
+		case entry.RevocationFailOpen:
+			b.Logger().Warn("unable to check the revocation of the client certificate; allowing it", "cert_name", entry.Name, "serial_number", cert.SerialNumber.String(), "error", err)
+		default:
+			b.Logger().Warn("unable to check the revocation of the client certificate; rejecting it", "cert_name", entry.Name, "serial_number", cert.SerialNumber.String(), "error", err)
+			return true
+		}
+	}
+
+	return false
+}
+
+// findIssuer returns the issuer of the client certificate of the chain: the
+// next certificate of a chain validated against a CA entry, or else, for
+// pinned non-CA entries, a certificate of the connection which signed it
+func findIssuer(connState *tls.ConnectionState, chain []*x509.Certificate) *x509.Certificate {
+	cert := chain[0]
+	if len(chain) > 1 && cert.CheckSignatureFrom(chain[1]) == nil {
+		return chain[1]
+	}
+	if connState == nil {
+		return nil
+	}
+
+	for _, verifiedChain := range connState.VerifiedChains {
+		if len(verifiedChain) > 1 && verifiedChain[0].Equal(cert) {
+			return verifiedChain[1]
+		}
+	}
+	for _, peerCert := range connState.PeerCertificates {
+		if !peerCert.Equal(cert) && cert.CheckSignatureFrom(peerCert) == nil {
+			return peerCert
+		}
+	}
+
+	return nil
+}
+
+// checkOCSP queries the configured OCSP responders, or else the ones of the
+// certificate's Authority Information Access, until one of them answers with
+// the status of the certificate
+func (b *backend) checkOCSP(ctx context.Context, cert, issuer *x509.Certificate, serversOverride []string) error {
+	servers := serversOverride
+	if len(servers) == 0 {
+		servers = cert.OCSPServer
+	}
+	if len(servers) == 0 {
+		return errors.New("the certificate has no OCSP responder")
+	}
+
+	ocspReq, err := ocsp.CreateRequest(cert, issuer, nil)
+	if err != nil {
+		return errwrap.Wrapf("error creating OCSP request: {{err}}", err)
+	}
+
+	var lastErr error
+	for _, server := range servers {
+		body, err := b.fetchRevocationData(ctx, http.MethodPost, server, "application/ocsp-request", ocspReq)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		ocspResp, err := ocsp.ParseResponseForCert(body, cert, issuer)
+		if err != nil {
+			lastErr = errwrap.Wrapf(fmt.Sprintf("invalid OCSP response from %q: {{err}}", server), err)
+			continue
+		}
+		if !ocspResp.NextUpdate.IsZero() && time.Now().After(ocspResp.NextUpdate) {
+			lastErr = fmt.Errorf("stale OCSP response from %q", server)
+			continue
+		}
+
+		switch ocspResp.Status {
+		case ocsp.Good:
+			return nil
+		case ocsp.Revoked:
+			return errCertRevoked
+		default:
+			lastErr = fmt.Errorf("OCSP responder %q doesn't know the certificate", server)
+		}
+	}
+
+	return lastErr
+}
+
+// checkCRLDistributionPoints looks up the certificate in the CRL of its
+// first HTTP distribution point which can be fetched
+func (b *backend) checkCRLDistributionPoints(ctx context.Context, conf *config, cert, issuer *x509.Certificate) error {
+	var lastErr error
+	for _, url := range cert.CRLDistributionPoints {
+		if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+			continue
+		}
+
+		crl, err := b.distributionPointCRL(ctx, conf, url)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if err := issuer.CheckCRLSignature(crl.crl); err != nil {
+			lastErr = errwrap.Wrapf(fmt.Sprintf("invalid signature of the CRL from %q: {{err}}", url), err)
+			continue
+		}
+
+		if crl.serials[cert.SerialNumber.String()] {
+			return errCertRevoked
+		}
+		return nil
+	}
+
+	if lastErr == nil {
+		return errors.New("the certificate has no HTTP CRL distribution point")
+	}
+	return lastErr
+}
+
+// distributionPointCRL returns the cached CRL of the distribution point,
+// fetching it when it needs to be refreshed. A CRL which can't be refreshed
+// is still used until its next update.
+func (b *backend) distributionPointCRL(ctx context.Context, conf *config, url string) (*distributionPointCRL, error) {
+	b.dpCRLsLock.RLock()
+	cached := b.dpCRLs[url]
+	b.dpCRLsLock.RUnlock()
+
+	if cached != nil && !cached.needsRefresh(conf.crlRefreshInterval()) {
+		return cached, nil
+	}
+
+	crl, err := b.fetchDistributionPointCRL(ctx, url)
+	if err != nil {
+		if cached != nil && time.Now().Before(cached.crl.TBSCertList.NextUpdate) {
+			b.Logger().Warn("unable to refresh CRL; using the cached one", "url", url, "error", err)
+			return cached, nil
+		}
+		return nil, err
+	}
+
+	return crl, nil
+}
+
+func (b *backend) fetchDistributionPointCRL(ctx context.Context, url string) (*distributionPointCRL, error) {
+	body, err := b.fetchRevocationData(ctx, http.MethodGet, url, "", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	certList, err := x509.ParseCRL(body)
+	if err != nil {
+		return nil, errwrap.Wrapf(fmt.Sprintf("failed to parse CRL from %q: {{err}}", url), err)
+	}
+
+	crl := &distributionPointCRL{
+		crl:       certList,
+		serials:   map[string]bool{},
+		fetchedAt: time.Now(),
+	}
+	for _, revokedCert := range certList.TBSCertList.RevokedCertificates {
+		crl.serials[revokedCert.SerialNumber.String()] = true
+	}
+
+	b.dpCRLsLock.Lock()
+	if _, ok := b.dpCRLs[url]; !ok && len(b.dpCRLs) >= maxDistributionPointCRLs {
+		var oldest string
+		for u, c := range b.dpCRLs {
+			if oldest == "" || c.fetchedAt.Before(b.dpCRLs[oldest].fetchedAt) {
+				oldest = u
+			}
+		}
+		delete(b.dpCRLs, oldest)
+	}
+	b.dpCRLs[url] = crl
+	b.dpCRLsLock.Unlock()
+
+	return crl, nil
+}
+
+// purgeDistributionPointCRLs drops the cached CRLs, once the entries whose
+// certificates pointed to them may be gone
+func (b *backend) purgeDistributionPointCRLs() {
+	b.dpCRLsLock.Lock()
+	defer b.dpCRLsLock.Unlock()
+
+	b.dpCRLs = map[string]*distributionPointCRL{}
+}
+
+func (b *backend) fetchRevocationData(ctx context.Context, method, url, contentType string, body []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, revocationRequestTimeout)
+	defer cancel()
+
+	httpReq, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, errwrap.Wrapf(fmt.Sprintf("error creating request to %q: {{err}}", url), err)
+	}
+	httpReq = httpReq.WithContext(ctx)
+	if contentType != "" {
+		httpReq.Header.Set("Content-Type", contentType)
+	}
+
+	httpResp, err := b.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, errwrap.Wrapf(fmt.Sprintf("error querying %q: {{err}}", url), err)
+	}
+	defer httpResp.Body.Close()
+
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d from %q", httpResp.StatusCode, url)
+	}
+
+	respBody, err := ioutil.ReadAll(io.LimitReader(httpResp.Body, maxRevocationResponseSize+1))
+	if err != nil {
+		return nil, errwrap.Wrapf(fmt.Sprintf("error reading the response from %q: {{err}}", url), err)
+	}
+	if len(respBody) > maxRevocationResponseSize {
+		return nil, fmt.Errorf("the response from %q is too large", url)
+	}
+
+	return respBody, nil
+}
+
+// periodicFunc refreshes the cached CRLs of the distribution points in the
+// background, so that logins rarely wait for them
+func (b *backend) periodicFunc(ctx context.Context, req *logical.Request) error {
+	conf, err := b.Config(ctx, req.Storage)
+	if err != nil {
+		return err
+	}
+
+	b.dpCRLsLock.RLock()
+	var urls []string
+	for url, crl := range b.dpCRLs {
+		if crl.needsRefresh(conf.crlRefreshInterval()) {
+			urls = append(urls, url)
+		}
+	}
+	b.dpCRLsLock.RUnlock()
+
+	for _, url := range urls {
+		if _, err := b.fetchDistributionPointCRL(ctx, url); err != nil {
+			b.Logger().Warn("unable to refresh CRL", "url", url, "error", err)
+		}
+	}
+
+	return nil
+}
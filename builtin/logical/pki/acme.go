@@ -0,0 +1,547 @@
+package pki
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault/helper/locksutil"
+	"github.com/hashicorp/vault/logical"
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+const (
+	acmeStatusPending     = "pending"
+	acmeStatusProcessing  = "processing"
+	acmeStatusReady       = "ready"
+	acmeStatusValid       = "valid"
+	acmeStatusInvalid     = "invalid"
+	acmeStatusDeactivated = "deactivated"
+	acmeStatusExpired     = "expired"
+
+	acmeChallengeHTTP01 = "http-01"
+	acmeChallengeDNS01  = "dns-01"
+
+	// acmeOrderLifetime is how long orders and their authorizations can be
+	// completed
+	acmeOrderLifetime = 24 * time.Hour
+
+	// acmeNonceLifetime is how long a nonce can be used
+	acmeNonceLifetime = 15 * time.Minute
+
+	acmeContentTypeJSON    = "application/json"
+	acmeContentTypeProblem = "application/problem+json"
+	acmeContentTypePEM     = "application/pem-certificate-chain"
+)
+
+// acmeAccount is an ACME account, identified by its key
+type acmeAccount struct {
+	ID         string           `json:"id"`
+	Key        *jose.JSONWebKey `json:"key"`
+	Thumbprint string           `json:"thumbprint"`
+	Status     string           `json:"status"`
+	Contact    []string         `json:"contact"`
+	OrderIDs   []string         `json:"order_ids"`
+	CreatedAt  time.Time        `json:"created_at"`
+}
+
+// acmeIdentifier is the identifier of an order or an authorization
+type acmeIdentifier struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// acmeOrder is an ACME order for a certificate
+type acmeOrder struct {
+	ID               string           `json:"id"`
+	AccountID        string           `json:"account_id"`
+	Status           string           `json:"status"`
+	Identifiers      []acmeIdentifier `json:"identifiers"`
+	AuthorizationIDs []string         `json:"authorization_ids"`
+	Expires          time.Time        `json:"expires"`
+	CertSerial       string           `json:"cert_serial"`
+	IssuerID         string           `json:"issuer_id,omitempty"`
+	Error            *acmeError       `json:"error,omitempty"`
+}
+
+// acmeAuthorization is the authorization of an account for an identifier
+type acmeAuthorization struct {
+	ID         string           `json:"id"`
+	AccountID  string           `json:"account_id"`
+	Identifier acmeIdentifier   `json:"identifier"`
+	Wildcard   bool             `json:"wildcard"`
+	Status     string           `json:"status"`
+	Expires    time.Time        `json:"expires"`
+	Challenges []*acmeChallenge `json:"challenges"`
+}
+
+// acmeChallenge is a way of proving control of the identifier of an
+// authorization
+type acmeChallenge struct {
+	Type      string     `json:"type"`
+	Token     string     `json:"token"`
+	Status    string     `json:"status"`
+	Validated time.Time  `json:"validated"`
+	Error     *acmeError `json:"error,omitempty"`
+}
+
+// acmeError is an ACME problem document
+type acmeError struct {
+	Type   string `json:"type"`
+	Detail string `json:"detail"`
+	Status int    `json:"status"`
+}
+
+func (e *acmeError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Type, e.Detail)
+}
+
+// newACMEError returns an error of one of the types of RFC 8555 section 6.7
+func newACMEError(errType string, status int, format string, args ...interface{}) *acmeError {
+	return &acmeError{
+		Type:   "urn:ietf:params:acme:error:" + errType,
+		Detail: fmt.Sprintf(format, args...),
+		Status: status,
+	}
+}
+
+func acmeMalformed(format string, args ...interface{}) *acmeError {
+	return newACMEError("malformed", http.StatusBadRequest, format, args...)
+}
+
+func acmeUnauthorized(format string, args ...interface{}) *acmeError {
+	return newACMEError("unauthorized", http.StatusForbidden, format, args...)
+}
+
+func acmeNotFound(resource string) *acmeError {
+	return newACMEError("malformed", http.StatusNotFound, "%s not found", resource)
+}
+
+// acmeState holds the state of the ACME server that isn't persisted
+type acmeState struct {
+	// locks serialize the requests of each account, by its ID, or by the
+	// thumbprint of their key for requests signed with a JWK
+	locks []*locksutil.LockEntry
+
+	nonceLock sync.Mutex
+	nonces    map[string]time.Time
+
+	// validationCtx is cancelled when the backend is cleaned up, which
+	// stops the challenge validations running in the background. The
+	// challenges being validated, by authorization and type, are in
+	// validating.
+	validationLock   sync.Mutex
+	validationCtx    context.Context
+	validationCancel context.CancelFunc
+	validating       map[string]struct{}
+	validations      sync.WaitGroup
+
+	// http01Port is the port http-01 challenges are validated on, which is
+	// only changed by tests
+	http01Port int
+}
+
+func newACMEState() *acmeState {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &acmeState{
+		locks:            locksutil.CreateLocks(),
+		nonces:           make(map[string]time.Time),
+		validationCtx:    ctx,
+		validationCancel: cancel,
+		validating:       make(map[string]struct{}),
+		http01Port:       80,
+	}
+}
+
+// startValidation runs validate in the background with the validation
+// context, unless the challenge is already being validated or the backend
+// is being cleaned up. It returns whether validate was started.
+func (s *acmeState) startValidation(authzID, challengeType string, validate func(context.Context)) bool {
+	key := authzID + "/" + challengeType
+
+	s.validationLock.Lock()
+	defer s.validationLock.Unlock()
+
+	if _, ok := s.validating[key]; ok || s.validationCtx.Err() != nil {
+		return false
+	}
+	s.validating[key] = struct{}{}
+	s.validations.Add(1)
+
+	go func() {
+		defer func() {
+			s.validationLock.Lock()
+			delete(s.validating, key)
+			s.validationLock.Unlock()
+			s.validations.Done()
+		}()
+
+		validate(s.validationCtx)
+	}()
+
+	return true
+}
+
+// stopValidations cancels the validations running in the background and
+// waits for them to return
+func (s *acmeState) stopValidations() {
+	s.validationLock.Lock()
+	s.validationCancel()
+	s.validationLock.Unlock()
+
+	s.validations.Wait()
+}
+
+// newNonce returns a new nonce, removing the expired ones
+func (s *acmeState) newNonce() (string, error) {
+	nonce, err := acmeRandomToken()
+	if err != nil {
+		return "", err
+	}
+
+	s.nonceLock.Lock()
+	defer s.nonceLock.Unlock()
+
+	now := time.Now()
+	for n, expires := range s.nonces {
+		if now.After(expires) {
+			delete(s.nonces, n)
+		}
+	}
+	s.nonces[nonce] = now.Add(acmeNonceLifetime)
+
+	return nonce, nil
+}
+
+// redeemNonce returns whether the nonce was valid, and makes it invalid
+func (s *acmeState) redeemNonce(nonce string) bool {
+	s.nonceLock.Lock()
+	defer s.nonceLock.Unlock()
+
+	expires, ok := s.nonces[nonce]
+	if !ok {
+		return false
+	}
+	delete(s.nonces, nonce)
+
+	return time.Now().Before(expires)
+}
+
+// acmeRandomToken returns a random base64url string, used for nonces,
+// challenge tokens and resource IDs
+func acmeRandomToken() (string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// acmeContext is the context of an ACME request
+type acmeContext struct {
+	config *acmeConfig
+	role   *roleEntry
+}
+
+func (c *acmeContext) url(path string) string {
+	return c.config.BaseURL + "/acme/" + path
+}
+
+func (c *acmeContext) accountURL(id string) string {
+	return c.url("account/" + id)
+}
+
+func (c *acmeContext) orderURL(id string) string {
+	return c.url("order/" + id)
+}
+
+func (c *acmeContext) authorizationURL(id string) string {
+	return c.url("authorization/" + id)
+}
+
+func (c *acmeContext) challengeURL(authzID, challengeType string) string {
+	return c.url("challenge/" + authzID + "/" + challengeType)
+}
+
+// acmeContext loads the configuration of ACME, or returns an error if ACME
+// isn't enabled
+func (b *backend) acmeContext(ctx context.Context, req *logical.Request) (*acmeContext, error) {
+	config, err := b.ACMEConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil || !config.Enabled {
+		return nil, newACMEError("serverInternal", http.StatusNotFound, "ACME is not enabled on this mount")
+	}
+
+	role, err := b.getRole(ctx, req.Storage, config.Role)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return nil, newACMEError("serverInternal", http.StatusInternalServerError, "the ACME role %q does not exist", config.Role)
+	}
+
+	return &acmeContext{
+		config: config,
+		role:   role,
+	}, nil
+}
+
+// acmeRequest is a verified JWS-signed ACME request
+type acmeRequest struct {
+	// Key is the key the request was signed with
+	Key *jose.JSONWebKey
+
+	// Account is the account of the key, if the request was signed with an
+	// account URL, or if the key belongs to an account
+	Account *acmeAccount
+
+	// Payload is the verified payload, which is empty for POST-as-GET
+	// requests
+	Payload []byte
+
+	// lock is the lock of the account or key held while handling the
+	// request
+	lock *locksutil.LockEntry
+}
+
+// decode decodes the JSON payload into out
+func (r *acmeRequest) decode(out interface{}) error {
+	if len(r.Payload) == 0 {
+		return acmeMalformed("missing payload")
+	}
+	if err := json.Unmarshal(r.Payload, out); err != nil {
+		return acmeMalformed("invalid payload: %s", err)
+	}
+	return nil
+}
+
+// parseJWS verifies the flattened JWS of the request, as described in RFC
+// 8555 section 6.2. Unless allowJWK is set, the request must be signed by an
+// existing account, referenced by its URL. The lock of the account or key is
+// held on success, and must be released once the request is handled.
+func (b *backend) parseJWS(ctx context.Context, req *logical.Request, ac *acmeContext, allowJWK bool) (*acmeRequest, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"protected": req.Data["protected"],
+		"payload":   req.Data["payload"],
+		"signature": req.Data["signature"],
+	})
+	if err != nil {
+		return nil, err
+	}
+	sig, err := jose.ParseSigned(string(body))
+	if err != nil {
+		return nil, acmeMalformed("invalid JWS: %s", err)
+	}
+	if len(sig.Signatures) != 1 {
+		return nil, acmeMalformed("the JWS must have exactly one signature")
+	}
+	header := sig.Signatures[0].Protected
+
+	switch jose.SignatureAlgorithm(header.Algorithm) {
+	case jose.RS256, jose.RS384, jose.RS512, jose.PS256, jose.PS384, jose.PS512,
+		jose.ES256, jose.ES384, jose.ES512, jose.EdDSA:
+	default:
+		return nil, newACMEError("badSignatureAlgorithm", http.StatusBadRequest, "unsupported signature algorithm %q", header.Algorithm)
+	}
+
+	if header.Nonce == "" || !b.acme.redeemNonce(header.Nonce) {
+		return nil, newACMEError("badNonce", http.StatusBadRequest, "invalid or expired nonce")
+	}
+
+	url, _ := header.ExtraHeaders["url"].(string)
+	if expected := ac.config.BaseURL + "/" + req.Path; url != expected {
+		return nil, acmeUnauthorized("the JWS url %q does not match the request URL %q", url, expected)
+	}
+
+	result := &acmeRequest{}
+	switch {
+	case header.JSONWebKey != nil && header.KeyID != "":
+		return nil, acmeMalformed("the JWS must have either a jwk or a kid, not both")
+
+	case header.JSONWebKey != nil:
+		if !allowJWK {
+			return nil, acmeMalformed("the JWS must be signed with an account URL")
+		}
+		if !header.JSONWebKey.Valid() || !header.JSONWebKey.IsPublic() {
+			return nil, acmeMalformed("invalid jwk")
+		}
+		result.Key = header.JSONWebKey
+
+		// The key may already belong to an account
+		thumbprint, err := acmeThumbprint(result.Key)
+		if err != nil {
+			return nil, acmeMalformed("invalid jwk: %s", err)
+		}
+		result.lock = locksutil.LockForKey(b.acme.locks, thumbprint)
+		result.lock.Lock()
+		if result.Account, err = b.acmeAccountByThumbprint(ctx, req.Storage, thumbprint); err != nil {
+			result.lock.Unlock()
+			return nil, err
+		}
+
+	case header.KeyID != "":
+		accountID := strings.TrimPrefix(header.KeyID, ac.accountURL(""))
+		if accountID == header.KeyID || accountID == "" {
+			return nil, newACMEError("accountDoesNotExist", http.StatusBadRequest, "unknown account %q", header.KeyID)
+		}
+		result.lock = locksutil.LockForKey(b.acme.locks, accountID)
+		result.lock.Lock()
+		account, err := b.acmeAccount(ctx, req.Storage, accountID)
+		if err != nil {
+			result.lock.Unlock()
+			return nil, err
+		}
+		if account == nil {
+			result.lock.Unlock()
+			return nil, newACMEError("accountDoesNotExist", http.StatusBadRequest, "unknown account %q", header.KeyID)
+		}
+		if account.Status != acmeStatusValid {
+			result.lock.Unlock()
+			return nil, acmeUnauthorized("the account is %s", account.Status)
+		}
+		result.Account = account
+		result.Key = account.Key
+
+	default:
+		return nil, acmeMalformed("the JWS must have a jwk or a kid")
+	}
+
+	if result.Payload, err = sig.Verify(result.Key); err != nil {
+		result.lock.Unlock()
+		return nil, acmeMalformed("invalid JWS signature")
+	}
+
+	return result, nil
+}
+
+// acmeThumbprint returns the RFC 7638 thumbprint of the key, as used in key
+// authorizations
+func acmeThumbprint(key *jose.JSONWebKey) (string, error) {
+	thumbprint, err := key.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(thumbprint), nil
+}
+
+// acmeResponse returns a raw response with the headers required by ACME
+func (b *backend) acmeResponse(ac *acmeContext, status int, contentType string, body []byte) (*logical.Response, error) {
+	nonce, err := b.acme.newNonce()
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			logical.HTTPStatusCode: status,
+		},
+		Headers: map[string][]string{
+			"Replay-Nonce":  []string{nonce},
+			"Cache-Control": []string{"no-store"},
+		},
+	}
+	if ac != nil {
+		resp.Headers["Link"] = []string{fmt.Sprintf("<%s>;rel=\"index\"", ac.url("directory"))}
+	}
+	if body != nil {
+		resp.Data[logical.HTTPContentType] = contentType
+		resp.Data[logical.HTTPRawBody] = body
+	}
+
+	return resp, nil
+}
+
+// acmeJSONResponse returns a response with the JSON encoding of body, and a
+// Location header if location is set
+func (b *backend) acmeJSONResponse(ac *acmeContext, status int, body interface{}, location string) (*logical.Response, error) {
+	raw, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := b.acmeResponse(ac, status, acmeContentTypeJSON, raw)
+	if err != nil {
+		return nil, err
+	}
+	if location != "" {
+		resp.Headers["Location"] = []string{location}
+	}
+
+	return resp, nil
+}
+
+// acmeErrorResponse turns the errors of the ACME handlers into problem
+// documents
+func (b *backend) acmeErrorResponse(ac *acmeContext, err error) (*logical.Response, error) {
+	problem, ok := err.(*acmeError)
+	if !ok {
+		b.Logger().Error("error handling ACME request", "error", err)
+		problem = newACMEError("serverInternal", http.StatusInternalServerError, "internal error")
+	}
+
+	raw, err := json.Marshal(problem)
+	if err != nil {
+		return nil, err
+	}
+	return b.acmeResponse(ac, problem.Status, acmeContentTypeProblem, raw)
+}
+
+func (b *backend) acmeAccount(ctx context.Context, s logical.Storage, id string) (*acmeAccount, error) {
+	var account acmeAccount
+	if ok, err := acmeGet(ctx, s, "acme/accounts/"+id, &account); err != nil || !ok {
+		return nil, err
+	}
+	return &account, nil
+}
+
+func (b *backend) acmeAccountByThumbprint(ctx context.Context, s logical.Storage, thumbprint string) (*acmeAccount, error) {
+	entry, err := s.Get(ctx, "acme/thumbprints/"+thumbprint)
+	if err != nil || entry == nil {
+		return nil, err
+	}
+	return b.acmeAccount(ctx, s, string(entry.Value))
+}
+
+func (b *backend) acmeOrder(ctx context.Context, s logical.Storage, id string) (*acmeOrder, error) {
+	var order acmeOrder
+	if ok, err := acmeGet(ctx, s, "acme/orders/"+id, &order); err != nil || !ok {
+		return nil, err
+	}
+	return &order, nil
+}
+
+func (b *backend) acmeAuthorization(ctx context.Context, s logical.Storage, id string) (*acmeAuthorization, error) {
+	var authz acmeAuthorization
+	if ok, err := acmeGet(ctx, s, "acme/authorizations/"+id, &authz); err != nil || !ok {
+		return nil, err
+	}
+	return &authz, nil
+}
+
+func acmeGet(ctx context.Context, s logical.Storage, key string, out interface{}) (bool, error) {
+	entry, err := s.Get(ctx, key)
+	if err != nil || entry == nil {
+		return false, err
+	}
+	if err := entry.DecodeJSON(out); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func acmePut(ctx context.Context, s logical.Storage, key string, value interface{}) error {
+	entry, err := logical.StorageEntryJSON(key, value)
+	if err != nil {
+		return err
+	}
+	return s.Put(ctx, entry)
+}
@@ -0,0 +1,102 @@
+package pki
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// acmeValidationTimeout bounds the time spent validating a challenge
+const acmeValidationTimeout = 10 * time.Second
+
+// validateACMEChallenge checks that the challenge was fulfilled for the
+// identifier of the authorization, as described in RFC 8555 section 8
+func (b *backend) validateACMEChallenge(ctx context.Context, ac *acmeContext, account *acmeAccount, authz *acmeAuthorization, challenge *acmeChallenge) *acmeError {
+	keyAuthorization := challenge.Token + "." + account.Thumbprint
+
+	ctx, cancel := context.WithTimeout(ctx, acmeValidationTimeout)
+	defer cancel()
+
+	switch challenge.Type {
+	case acmeChallengeHTTP01:
+		return b.validateHTTP01(ctx, authz.Identifier.Value, challenge.Token, keyAuthorization)
+	case acmeChallengeDNS01:
+		return validateDNS01(ctx, ac.config.DNSResolver, authz.Identifier.Value, keyAuthorization)
+	}
+
+	return acmeMalformed("unsupported challenge type %q", challenge.Type)
+}
+
+// validateHTTP01 checks that the key authorization is served at the
+// well-known URL of the token on the domain
+func (b *backend) validateHTTP01(ctx context.Context, domain, token, keyAuthorization string) *acmeError {
+	host := domain
+	if b.acme.http01Port != 80 {
+		host = net.JoinHostPort(domain, strconv.Itoa(b.acme.http01Port))
+	}
+	url := fmt.Sprintf("http://%s/.well-known/acme-challenge/%s", host, token)
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return acmeMalformed("invalid challenge URL %q: %s", url, err)
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return newACMEError("connection", http.StatusBadRequest, "error fetching %s: %s", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return newACMEError("incorrectResponse", http.StatusForbidden, "%s returned status %d", url, resp.StatusCode)
+	}
+
+	// The key authorization is short, anything bigger is wrong
+	body, err := ioutil.ReadAll(http.MaxBytesReader(nil, resp.Body, 1024))
+	if err != nil {
+		return newACMEError("connection", http.StatusBadRequest, "error reading %s: %s", url, err)
+	}
+	if strings.TrimSpace(string(body)) != keyAuthorization {
+		return newACMEError("incorrectResponse", http.StatusForbidden, "%s returned the wrong key authorization", url)
+	}
+
+	return nil
+}
+
+// validateDNS01 checks that a TXT record of the _acme-challenge subdomain of
+// the domain holds the digest of the key authorization. The records are
+// looked up through resolver if set, or the system resolver.
+func validateDNS01(ctx context.Context, resolver, domain, keyAuthorization string) *acmeError {
+	digest := sha256.Sum256([]byte(keyAuthorization))
+	expected := base64.RawURLEncoding.EncodeToString(digest[:])
+
+	r := net.DefaultResolver
+	if resolver != "" {
+		r = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, resolver)
+			},
+		}
+	}
+
+	name := "_acme-challenge." + domain
+	records, err := r.LookupTXT(ctx, name)
+	if err != nil {
+		return newACMEError("dns", http.StatusBadRequest, "error looking up the TXT records of %s: %s", name, err)
+	}
+	for _, record := range records {
+		if record == expected {
+			return nil
+		}
+	}
+
+	return newACMEError("incorrectResponse", http.StatusForbidden, "no TXT record of %s holds the expected value", name)
+}
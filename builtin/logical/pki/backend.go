@@ -6,6 +6,8 @@ import (
 	"sync"
 	"time"
 
+	multierror "github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/vault/helper/consts"
 	"github.com/hashicorp/vault/logical"
 	"github.com/hashicorp/vault/logical/framework"
 )
@@ -33,12 +35,29 @@ func Backend(conf *logical.BackendConfig) *backend {
 				"ca",
 				"crl/pem",
 				"crl",
+				"crl/delta",
+				"crl/delta/pem",
+				"acme/*",
+				"issuer/*",
+				"ocsp",
+				"ocsp/*",
+				"est/*",
+			},
+
+			Head: []string{
+				"acme/new-nonce",
 			},
 
 			LocalStorage: []string{
 				"revoked/",
 				"crl",
 				"certs/",
+				"acme/",
+				"crls/",
+				"delta-crl",
+				"delta-wal/",
+				"cert-metadata/",
+				"cert-metadata-index",
 			},
 
 			Root: []string{
@@ -48,19 +67,28 @@ func Backend(conf *logical.BackendConfig) *backend {
 
 			SealWrapStorage: []string{
 				"config/ca_bundle",
+				"config/pending_intermediate",
+				"config/ocsp",
+				"issuers/",
 			},
 		},
 
-		Paths: []*framework.Path{
+		Paths: framework.PathAppend([]*framework.Path{
 			pathListRoles(&b),
 			pathRoles(&b),
 			pathGenerateRoot(&b),
+			pathRotateRoot(&b),
 			pathSignIntermediate(&b),
 			pathSignSelfIssued(&b),
 			pathDeleteRoot(&b),
 			pathGenerateIntermediate(&b),
 			pathSetSignedIntermediate(&b),
+			pathCrossSignIntermediate(&b),
 			pathConfigCA(&b),
+			pathConfigIssuers(&b),
+			pathListIssuers(&b),
+			pathIssuers(&b),
+			pathFetchIssuer(&b),
 			pathConfigCRL(&b),
 			pathConfigURLs(&b),
 			pathSignVerbatim(&b),
@@ -73,20 +101,41 @@ func Backend(conf *logical.BackendConfig) *backend {
 			pathFetchCRLViaCertPath(&b),
 			pathFetchValid(&b),
 			pathFetchListCerts(&b),
+			pathSearchCerts(&b),
+			pathExpiringCerts(&b),
 			pathRevoke(&b),
 			pathTidy(&b),
+			pathTidyStatus(&b),
+			pathConfigAutoTidy(&b),
+			pathConfigACME(&b),
+			pathConfigOCSP(&b),
+			pathConfigEST(&b),
 		},
+			pathACME(&b),
+			pathOCSP(&b),
+			pathEST(&b),
+		),
 
 		Secrets: []*framework.Secret{
 			secretCerts(&b),
 		},
 
+		PeriodicFunc: b.periodicFunc,
+		Invalidate:   b.invalidate,
+		Clean:        b.cleanup,
+
 		BackendType: logical.TypeLogical,
 	}
 
 	b.crlLifetime = time.Hour * 72
 	b.tidyCASGuard = new(uint32)
+	b.legacyCAMigrated = new(uint32)
+	b.deltaCRLDirty = new(uint32)
+	// Revocations may have been logged before the backend was loaded
+	*b.deltaCRLDirty = 1
 	b.storage = conf.StorageView
+	b.acme = newACMEState()
+	b.ocspCache = newOCSPCache()
 
 	return &b
 }
@@ -98,6 +147,80 @@ type backend struct {
 	crlLifetime       time.Duration
 	revokeStorageLock sync.RWMutex
 	tidyCASGuard      *uint32
+	acme              *acmeState
+	ocspCache         *ocspCache
+
+	// tidyStatusLock guards the status of the last tidy operation, and the
+	// time the last one started which schedules auto-tidy. Both are read back
+	// from storage the first time they are needed, setting tidyStatusLoaded.
+	tidyStatusLock   sync.RWMutex
+	tidyStatus       *tidyStatus
+	lastTidy         time.Time
+	tidyStatusLoaded bool
+
+	// crlBuildLock serializes CRL builds. deltaCRLDirty is set when
+	// certificates were revoked since the last delta CRLs were built, at
+	// deltaCRLLastBuild.
+	crlBuildLock      sync.Mutex
+	deltaCRLDirty     *uint32
+	deltaCRLLastBuild time.Time
+
+	// certIndexLock serializes indexing the certificates stored before
+	// their metadata was indexed
+	certIndexLock sync.Mutex
+
+	// legacyCALock serializes the migration of the legacy CA bundle, which
+	// sets legacyCAMigrated once done
+	legacyCALock     sync.Mutex
+	legacyCAMigrated *uint32
+}
+
+// HandleRequest migrates the legacy CA bundle before the first request
+// handled by the active node
+func (b *backend) HandleRequest(ctx context.Context, req *logical.Request) (*logical.Response, error) {
+	if req.Storage != nil && req.Operation != logical.HelpOperation &&
+		!b.System().ReplicationState().HasState(consts.ReplicationPerformanceStandby) {
+		if err := b.migrateLegacyCA(ctx, req.Storage); err != nil {
+			return nil, err
+		}
+	}
+
+	return b.Backend.HandleRequest(ctx, req)
+}
+
+// cleanup stops the ACME challenge validations running in the background
+func (b *backend) cleanup(_ context.Context) {
+	b.acme.stopValidations()
+}
+
+// periodicFunc rebuilds the CRLs in the background when the CRL configuration
+// asks for it, and runs auto-tidy. Only the active node runs them.
+func (b *backend) periodicFunc(ctx context.Context, req *logical.Request) error {
+	if b.System().ReplicationState().HasState(consts.ReplicationPerformanceStandby) {
+		return nil
+	}
+
+	var result error
+	if err := b.migrateLegacyCA(ctx, req.Storage); err != nil {
+		result = multierror.Append(result, err)
+	}
+	if err := b.autoRebuildCRLs(ctx, req); err != nil {
+		result = multierror.Append(result, err)
+	}
+	if err := b.autoTidy(ctx, req); err != nil {
+		result = multierror.Append(result, err)
+	}
+	return result
+}
+
+// invalidate purges the cached OCSP responses and issuers when the
+// revocation information or the issuers change on another node
+func (b *backend) invalidate(_ context.Context, key string) {
+	switch {
+	case strings.HasPrefix(key, "revoked/"), strings.HasPrefix(key, "issuers/"),
+		key == "config/ocsp", key == "config/crl":
+		b.ocspCache.purge()
+	}
 }
 
 const backendHelp = `
@@ -21,10 +21,17 @@ func (b *backend) getGenerationParams(
 		return
 	}
 
-	format = getFormat(data)
+	if exported {
+		format = getKeyExportFormat(data)
+	} else {
+		format = getFormat(data)
+	}
 	if format == "" {
 		errorResp = logical.ErrorResponse(
-			`the "format" path parameter must be "pem", "der", "der_pkcs", or "pem_bundle"`)
+			`the "format" path parameter must be "pem", "der", "der_pkcs", "pem_bundle", or, when exported, "pkcs12"`)
+		return
+	}
+	if errorResp = validatePKCS12Encoding(data); errorResp != nil {
 		return
 	}
 
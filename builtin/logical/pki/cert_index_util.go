@@ -0,0 +1,334 @@
+package pki
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/helper/certutil"
+	"github.com/hashicorp/vault/logical"
+	"github.com/ryanuber/go-glob"
+)
+
+// certMetadata is the searchable metadata of a stored certificate, indexed at
+// "cert-metadata/<serial>" when the certificate is stored so that searches
+// don't parse every certificate
+type certMetadata struct {
+	SerialNumber   string    `json:"serial_number"`
+	CommonName     string    `json:"common_name"`
+	AltNames       []string  `json:"alt_names"`
+	Role           string    `json:"role"`
+	IssuerID       string    `json:"issuer_id"`
+	IsCA           bool      `json:"is_ca"`
+	NotBefore      time.Time `json:"not_before"`
+	NotAfter       time.Time `json:"not_after"`
+	RevocationTime time.Time `json:"revocation_time"`
+}
+
+func newCertMetadata(cert *x509.Certificate, roleName, issuerID string) *certMetadata {
+	meta := &certMetadata{
+		SerialNumber: certutil.GetHexFormatted(cert.SerialNumber.Bytes(), ":"),
+		CommonName:   cert.Subject.CommonName,
+		Role:         roleName,
+		IssuerID:     issuerID,
+		IsCA:         cert.IsCA,
+		NotBefore:    cert.NotBefore.UTC(),
+		NotAfter:     cert.NotAfter.UTC(),
+	}
+	meta.AltNames = append(meta.AltNames, cert.DNSNames...)
+	meta.AltNames = append(meta.AltNames, cert.EmailAddresses...)
+	for _, ip := range cert.IPAddresses {
+		meta.AltNames = append(meta.AltNames, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		meta.AltNames = append(meta.AltNames, uri.String())
+	}
+	return meta
+}
+
+func (m *certMetadata) responseData() map[string]interface{} {
+	var revocationTime int64
+	if !m.RevocationTime.IsZero() {
+		revocationTime = m.RevocationTime.Unix()
+	}
+	altNames := m.AltNames
+	if altNames == nil {
+		altNames = []string{}
+	}
+	return map[string]interface{}{
+		"serial_number":   m.SerialNumber,
+		"common_name":     m.CommonName,
+		"alt_names":       altNames,
+		"role":            m.Role,
+		"issuer_id":       m.IssuerID,
+		"is_ca":           m.IsCA,
+		"not_before":      m.NotBefore.Unix(),
+		"not_after":       m.NotAfter.Unix(),
+		"revocation_time": revocationTime,
+	}
+}
+
+// storeCert stores the certificate identified by serial number, so it can be
+// fetched and revoked, and indexes its metadata. The role and issuer are
+// empty when unknown.
+func storeCert(ctx context.Context, s logical.Storage, cert *x509.Certificate, roleName, issuerID string) error {
+	meta := newCertMetadata(cert, roleName, issuerID)
+	serial := normalizeSerial(meta.SerialNumber)
+
+	err := s.Put(ctx, &logical.StorageEntry{
+		Key:   "certs/" + serial,
+		Value: cert.Raw,
+	})
+	if err != nil {
+		return err
+	}
+
+	return putCertMetadata(ctx, s, meta)
+}
+
+func putCertMetadata(ctx context.Context, s logical.Storage, meta *certMetadata) error {
+	entry, err := logical.StorageEntryJSON("cert-metadata/"+normalizeSerial(meta.SerialNumber), meta)
+	if err != nil {
+		return err
+	}
+	return s.Put(ctx, entry)
+}
+
+func fetchCertMetadata(ctx context.Context, s logical.Storage, serial string) (*certMetadata, error) {
+	entry, err := s.Get(ctx, "cert-metadata/"+normalizeSerial(serial))
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	var meta certMetadata
+	if err := entry.DecodeJSON(&meta); err != nil {
+		return nil, err
+	}
+	return &meta, nil
+}
+
+// markCertMetadataRevoked records the revocation of the certificate in its
+// metadata, if it was indexed
+func markCertMetadataRevoked(ctx context.Context, s logical.Storage, serial string, revocationTime time.Time) error {
+	meta, err := fetchCertMetadata(ctx, s, serial)
+	if err != nil || meta == nil {
+		return err
+	}
+	meta.RevocationTime = revocationTime.UTC()
+	return putCertMetadata(ctx, s, meta)
+}
+
+// certIssuerID returns the ID of the issuer of the mount which signed the
+// certificate, or an empty string if there is none
+func certIssuerID(ctx context.Context, s logical.Storage, cert *x509.Certificate) (string, error) {
+	issuerIDs, err := listIssuers(ctx, s)
+	if err != nil {
+		return "", err
+	}
+	for _, id := range issuerIDs {
+		issuer, err := fetchIssuer(ctx, s, id)
+		if err != nil {
+			return "", err
+		}
+		if issuer == nil {
+			continue
+		}
+		parsedBundle, err := issuer.Bundle.ToParsedCertBundle()
+		if err != nil {
+			return "", err
+		}
+		if issuedBy(cert, parsedBundle.Certificate) {
+			return id, nil
+		}
+	}
+	return "", nil
+}
+
+// indexStoredCerts indexes the metadata of the certificates stored before
+// certificates were indexed, once per mount. Their role is unknown.
+func (b *backend) indexStoredCerts(ctx context.Context, s logical.Storage) error {
+	b.certIndexLock.Lock()
+	defer b.certIndexLock.Unlock()
+
+	entry, err := s.Get(ctx, "cert-metadata-index")
+	if err != nil {
+		return err
+	}
+	if entry != nil {
+		return nil
+	}
+
+	issuerIDs, err := listIssuers(ctx, s)
+	if err != nil {
+		return err
+	}
+	var issuers []*issuerEntry
+	var issuerCerts []*x509.Certificate
+	for _, id := range issuerIDs {
+		issuer, err := fetchIssuer(ctx, s, id)
+		if err != nil {
+			return err
+		}
+		if issuer == nil {
+			continue
+		}
+		parsedBundle, err := issuer.Bundle.ToParsedCertBundle()
+		if err != nil {
+			return err
+		}
+		issuers = append(issuers, issuer)
+		issuerCerts = append(issuerCerts, parsedBundle.Certificate)
+	}
+
+	serials, err := s.List(ctx, "certs/")
+	if err != nil {
+		return err
+	}
+	for _, serial := range serials {
+		meta, err := fetchCertMetadata(ctx, s, serial)
+		if err != nil {
+			return err
+		}
+		if meta != nil {
+			continue
+		}
+
+		certEntry, err := s.Get(ctx, "certs/"+serial)
+		if err != nil {
+			return err
+		}
+		if certEntry == nil || len(certEntry.Value) == 0 {
+			continue
+		}
+		cert, err := x509.ParseCertificate(certEntry.Value)
+		if err != nil {
+			b.Logger().Warn("unable to parse stored certificate; not indexing it", "serial", serial, "error", err)
+			continue
+		}
+
+		var issuerID string
+		for i, issuerCert := range issuerCerts {
+			if issuedBy(cert, issuerCert) {
+				issuerID = issuers[i].ID
+				break
+			}
+		}
+		meta = newCertMetadata(cert, "", issuerID)
+
+		revokedEntry, err := s.Get(ctx, "revoked/"+serial)
+		if err != nil {
+			return err
+		}
+		if revokedEntry != nil {
+			var revInfo revocationInfo
+			if err := revokedEntry.DecodeJSON(&revInfo); err != nil {
+				return fmt.Errorf("error decoding revocation entry for serial %s: %s", serial, err)
+			}
+			meta.RevocationTime = revInfo.RevocationTimeUTC
+			if meta.RevocationTime.IsZero() {
+				meta.RevocationTime = time.Unix(revInfo.RevocationTime, 0).UTC()
+			}
+		}
+
+		if err := putCertMetadata(ctx, s, meta); err != nil {
+			return err
+		}
+	}
+
+	return s.Put(ctx, &logical.StorageEntry{
+		Key:   "cert-metadata-index",
+		Value: []byte("1"),
+	})
+}
+
+// certFilter selects indexed certificates. Empty fields match everything.
+type certFilter struct {
+	// CommonName and AltName are case-insensitive globs
+	CommonName string
+	AltName    string
+	Role       string
+	IssuerID   string
+
+	// The certificates expire within [NotAfterStart, NotAfterEnd]
+	NotAfterStart time.Time
+	NotAfterEnd   time.Time
+
+	// Revoked selects revoked or unrevoked certificates if set
+	Revoked *bool
+}
+
+func (f *certFilter) matches(meta *certMetadata) bool {
+	if f.CommonName != "" && !glob.Glob(strings.ToLower(f.CommonName), strings.ToLower(meta.CommonName)) {
+		return false
+	}
+	if f.AltName != "" {
+		var found bool
+		for _, altName := range meta.AltNames {
+			if glob.Glob(strings.ToLower(f.AltName), strings.ToLower(altName)) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if f.Role != "" && f.Role != meta.Role {
+		return false
+	}
+	if f.IssuerID != "" && f.IssuerID != meta.IssuerID {
+		return false
+	}
+	if !f.NotAfterStart.IsZero() && meta.NotAfter.Before(f.NotAfterStart) {
+		return false
+	}
+	if !f.NotAfterEnd.IsZero() && meta.NotAfter.After(f.NotAfterEnd) {
+		return false
+	}
+	if f.Revoked != nil && *f.Revoked != !meta.RevocationTime.IsZero() {
+		return false
+	}
+	return true
+}
+
+// searchCerts returns up to limit indexed certificates matching the filter,
+// in serial number order after the given serial number. The serial number to
+// continue from is returned if there are more.
+func (b *backend) searchCerts(ctx context.Context, s logical.Storage, filter *certFilter, after string, limit int) ([]*certMetadata, string, error) {
+	if err := b.indexStoredCerts(ctx, s); err != nil {
+		return nil, "", err
+	}
+
+	serials, err := s.List(ctx, "cert-metadata/")
+	if err != nil {
+		return nil, "", err
+	}
+
+	after = normalizeSerial(after)
+	var results []*certMetadata
+	for _, serial := range serials {
+		if after != "" && serial <= after {
+			continue
+		}
+
+		meta, err := fetchCertMetadata(ctx, s, serial)
+		if err != nil {
+			return nil, "", err
+		}
+		if meta == nil || !filter.matches(meta) {
+			continue
+		}
+
+		if len(results) == limit {
+			return results, results[limit-1].SerialNumber, nil
+		}
+		results = append(results, meta)
+	}
+
+	return results, "", nil
+}
@@ -91,7 +91,8 @@ type creationParameters struct {
 
 type caInfoBundle struct {
 	certutil.ParsedCertBundle
-	URLs *urlEntries
+	URLs     *urlEntries
+	IssuerID string
 }
 
 func (b *caInfoBundle) GetCAChain() []*certutil.CertBlock {
@@ -107,9 +108,11 @@ func (b *caInfoBundle) GetCAChain() []*certutil.CertBlock {
 			Certificate: b.Certificate,
 			Bytes:       b.CertificateBytes,
 		})
-		if b.CAChain != nil && len(b.CAChain) > 0 {
-			chain = append(chain, b.CAChain...)
-		}
+	}
+
+	// Roots only have a chain when they were cross-signed
+	if b.CAChain != nil && len(b.CAChain) > 0 {
+		chain = append(chain, b.CAChain...)
 	}
 
 	return chain
@@ -145,6 +148,44 @@ func getFormat(data *framework.FieldData) string {
 	return format
 }
 
+// getKeyExportFormat is getFormat for the endpoints returning a certificate
+// with its private key, which can also return them as a "pkcs12" archive
+func getKeyExportFormat(data *framework.FieldData) string {
+	if data.Get("format").(string) == "pkcs12" {
+		return "pkcs12"
+	}
+	return getFormat(data)
+}
+
+// validatePKCS12Encoding checks the "pkcs12_encoding" field of the endpoints
+// which can return a PKCS#12 archive. Other endpoints don't have it.
+func validatePKCS12Encoding(data *framework.FieldData) *logical.Response {
+	encoding, ok := data.GetOk("pkcs12_encoding")
+	if !ok {
+		return nil
+	}
+	switch encoding.(string) {
+	case "modern", "legacy":
+		return nil
+	}
+	return logical.ErrorResponse(`the "pkcs12_encoding" parameter must be "modern" or "legacy"`)
+}
+
+// pkcs12Response returns the certificate, its CA chain and its private key as
+// a base64-encoded PKCS#12 archive protected by the "password" field, with
+// the algorithms of the "pkcs12_encoding" field
+func pkcs12Response(parsedBundle *certutil.ParsedCertBundle, data *framework.FieldData) (string, error) {
+	toPKCS12 := parsedBundle.ToPKCS12
+	if data.Get("pkcs12_encoding").(string) == "legacy" {
+		toPKCS12 = parsedBundle.ToLegacyPKCS12
+	}
+	pfx, err := toPKCS12(data.Get("password").(string))
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(pfx), nil
+}
+
 func validateKeyTypeLength(keyType string, keyBits int) *logical.Response {
 	switch keyType {
 	case "rsa":
@@ -166,6 +207,8 @@ func validateKeyTypeLength(keyType string, keyBits int) *logical.Response {
 			return logical.ErrorResponse(fmt.Sprintf(
 				"unsupported bit length for EC key: %d", keyBits))
 		}
+	case "ed25519":
+		// Ed25519 keys have a fixed length, so the key bits are ignored
 	case "any":
 	default:
 		return logical.ErrorResponse(fmt.Sprintf(
@@ -175,47 +218,11 @@ func validateKeyTypeLength(keyType string, keyBits int) *logical.Response {
 	return nil
 }
 
-// Fetches the CA info. Unlike other certificates, the CA info is stored
-// in the backend as a CertBundle, because we are storing its private key
+// Fetches the CA info of the default issuer. Unlike other certificates, the
+// CA info is stored in the backend as a CertBundle, because we are storing
+// its private key
 func fetchCAInfo(ctx context.Context, req *logical.Request) (*caInfoBundle, error) {
-	bundleEntry, err := req.Storage.Get(ctx, "config/ca_bundle")
-	if err != nil {
-		return nil, errutil.InternalError{Err: fmt.Sprintf("unable to fetch local CA certificate/key: %v", err)}
-	}
-	if bundleEntry == nil {
-		return nil, errutil.UserError{Err: "backend must be configured with a CA certificate/key"}
-	}
-
-	var bundle certutil.CertBundle
-	if err := bundleEntry.DecodeJSON(&bundle); err != nil {
-		return nil, errutil.InternalError{Err: fmt.Sprintf("unable to decode local CA certificate/key: %v", err)}
-	}
-
-	parsedBundle, err := bundle.ToParsedCertBundle()
-	if err != nil {
-		return nil, errutil.InternalError{Err: err.Error()}
-	}
-
-	if parsedBundle.Certificate == nil {
-		return nil, errutil.InternalError{Err: "stored CA information not able to be parsed"}
-	}
-
-	caInfo := &caInfoBundle{*parsedBundle, nil}
-
-	entries, err := getURLs(ctx, req)
-	if err != nil {
-		return nil, errutil.InternalError{Err: fmt.Sprintf("unable to fetch URL information: %v", err)}
-	}
-	if entries == nil {
-		entries = &urlEntries{
-			IssuingCertificates:   []string{},
-			CRLDistributionPoints: []string{},
-			OCSPServers:           []string{},
-		}
-	}
-	caInfo.URLs = entries
-
-	return caInfo, nil
+	return fetchCAInfoByRef(ctx, req, defaultIssuerRef)
 }
 
 // Allows fetching certificates from the backend; it handles the slightly
@@ -238,6 +245,8 @@ func fetchCertBySerial(ctx context.Context, req *logical.Request, prefix, serial
 		path = "ca"
 	case serial == "crl":
 		path = "crl"
+	case serial == "delta-crl":
+		path = "delta-crl"
 	default:
 		legacyPath = "certs/" + colonSerial
 		path = "certs/" + hyphenSerial
@@ -678,6 +687,14 @@ func signCert(b *backend,
 				pubKey.Params().BitSize)}
 		}
 
+	case "ed25519":
+		// Verify that the key matches the role type
+		if csr.PublicKeyAlgorithm != x509.Ed25519 {
+			return nil, errutil.UserError{Err: fmt.Sprintf(
+				"role requires keys of type %s",
+				data.role.KeyType)}
+		}
+
 	case "any":
 		// We only care about running RSA < 2048 bit checks, so if not RSA
 		// break out
@@ -1211,6 +1228,8 @@ func createCertificate(data *dataBundle) (*certutil.ParsedCertBundle, error) {
 			certTemplate.SignatureAlgorithm = x509.SHA256WithRSA
 		case certutil.ECPrivateKey:
 			certTemplate.SignatureAlgorithm = x509.ECDSAWithSHA256
+		case certutil.Ed25519PrivateKey:
+			certTemplate.SignatureAlgorithm = x509.PureEd25519
 		}
 
 		caCert := data.signingBundle.Certificate
@@ -1231,6 +1250,8 @@ func createCertificate(data *dataBundle) (*certutil.ParsedCertBundle, error) {
 			certTemplate.SignatureAlgorithm = x509.SHA256WithRSA
 		case "ec":
 			certTemplate.SignatureAlgorithm = x509.ECDSAWithSHA256
+		case "ed25519":
+			certTemplate.SignatureAlgorithm = x509.PureEd25519
 		}
 
 		certTemplate.AuthorityKeyId = subjKeyID
@@ -1249,17 +1270,7 @@ func createCertificate(data *dataBundle) (*certutil.ParsedCertBundle, error) {
 	}
 
 	if data.signingBundle != nil {
-		if len(data.signingBundle.Certificate.AuthorityKeyId) > 0 &&
-			!bytes.Equal(data.signingBundle.Certificate.AuthorityKeyId, data.signingBundle.Certificate.SubjectKeyId) {
-
-			result.CAChain = []*certutil.CertBlock{
-				&certutil.CertBlock{
-					Certificate: data.signingBundle.Certificate,
-					Bytes:       data.signingBundle.CertificateBytes,
-				},
-			}
-			result.CAChain = append(result.CAChain, data.signingBundle.CAChain...)
-		}
+		result.CAChain = data.signingBundle.GetCAChain()
 	}
 
 	return result, nil
@@ -1312,6 +1323,8 @@ func createCSR(data *dataBundle) (*certutil.ParsedCSRBundle, error) {
 		csrTemplate.SignatureAlgorithm = x509.SHA256WithRSA
 	case "ec":
 		csrTemplate.SignatureAlgorithm = x509.ECDSAWithSHA256
+	case "ed25519":
+		csrTemplate.SignatureAlgorithm = x509.PureEd25519
 	}
 
 	csr, err := x509.CreateCertificateRequest(rand.Reader, csrTemplate, result.PrivateKey)
@@ -1376,6 +1389,8 @@ func signCertificate(data *dataBundle) (*certutil.ParsedCertBundle, error) {
 		certTemplate.SignatureAlgorithm = x509.SHA256WithRSA
 	case certutil.ECPrivateKey:
 		certTemplate.SignatureAlgorithm = x509.ECDSAWithSHA256
+	case certutil.Ed25519PrivateKey:
+		certTemplate.SignatureAlgorithm = x509.PureEd25519
 	}
 
 	if data.params.UseCSRValues {
@@ -1496,6 +1511,9 @@ func convertRespToPKCS8(resp *logical.Response) error {
 		signer, err = x509.ParsePKCS1PrivateKey(keyData)
 	case certutil.ECPrivateKey:
 		signer, err = x509.ParseECPrivateKey(keyData)
+	case certutil.Ed25519PrivateKey:
+		// Ed25519 keys are always encoded as PKCS#8
+		return nil
 	default:
 		return fmt.Errorf("unknown private key type %q", privKeyType)
 	}
@@ -1,8 +1,12 @@
 package pki
 
 import (
+	"context"
 	"crypto/x509"
+	"encoding/asn1"
+	"math/big"
 	"testing"
+	"time"
 
 	"github.com/hashicorp/vault/api"
 	vaulthttp "github.com/hashicorp/vault/http"
@@ -122,3 +126,168 @@ func TestBackend_CRL_EnableDisable(t *testing.T) {
 	toggle(false)
 	test(6)
 }
+
+func crlTestParse(t *testing.T, b *backend, s logical.Storage, path string) *x509.RevocationList {
+	t.Helper()
+
+	resp := issuersTestRequest(t, b, s, logical.ReadOperation, path, nil)
+	crl, err := x509.ParseRevocationList(resp.Data[logical.HTTPRawBody].([]byte))
+	if err != nil {
+		t.Fatalf("bad CRL at %s: %v", path, err)
+	}
+	return crl
+}
+
+// crlTestBaseNumber returns the number of the complete CRL of the delta CRL
+func crlTestBaseNumber(t *testing.T, crl *x509.RevocationList) *big.Int {
+	t.Helper()
+
+	for _, ext := range crl.Extensions {
+		if ext.Id.Equal(oidDeltaCRLIndicator) {
+			if !ext.Critical {
+				t.Fatal("the delta CRL indicator must be critical")
+			}
+			var number *big.Int
+			if _, err := asn1.Unmarshal(ext.Value, &number); err != nil {
+				t.Fatal(err)
+			}
+			return number
+		}
+	}
+	t.Fatal("no delta CRL indicator")
+	return nil
+}
+
+func TestPki_AutoRebuildDeltaCRL(t *testing.T) {
+	b, s := createBackendWithStorage(t)
+	ctx := context.Background()
+
+	issuersTestRequest(t, b, s, logical.UpdateOperation, "root/generate/internal", map[string]interface{}{
+		"common_name": "Root",
+		"ttl":         "40h",
+	})
+	issuersTestRequest(t, b, s, logical.UpdateOperation, "roles/example", map[string]interface{}{
+		"allowed_domains":  "example.com",
+		"allow_subdomains": true,
+		"ttl":              "1h",
+	})
+	var serials []string
+	for i := 0; i < 2; i++ {
+		resp := issuersTestRequest(t, b, s, logical.UpdateOperation, "issue/example", map[string]interface{}{
+			"common_name": "test.example.com",
+		})
+		serials = append(serials, resp.Data["serial_number"].(string))
+	}
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config/crl",
+		Storage:   s,
+		Data: map[string]interface{}{
+			"auto_rebuild":              true,
+			"auto_rebuild_grace_period": "80h",
+		},
+	})
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error for a grace period longer than the expiry, got err: %v resp: %#v", err, resp)
+	}
+
+	issuersTestRequest(t, b, s, logical.UpdateOperation, "config/crl", map[string]interface{}{
+		"expiry":                    "1h",
+		"auto_rebuild":              true,
+		"auto_rebuild_grace_period": "30m",
+		"enable_delta":              true,
+	})
+	resp = issuersTestRequest(t, b, s, logical.ReadOperation, "config/crl", nil)
+	if resp.Data["auto_rebuild_grace_period"] != "30m" || resp.Data["delta_rebuild_interval"] != "15m" {
+		t.Fatalf("bad: %#v", resp.Data)
+	}
+
+	// Enabling delta CRLs rotates the CRL so that it has a number
+	base := crlTestParse(t, b, s, "crl")
+	if base.Number == nil || len(base.RevokedCertificateEntries) != 0 {
+		t.Fatalf("bad: number %v, %d entries", base.Number, len(base.RevokedCertificateEntries))
+	}
+	delta := crlTestParse(t, b, s, "crl/delta")
+	if crlTestBaseNumber(t, delta).Cmp(base.Number) != 0 || delta.Number.Cmp(base.Number) <= 0 {
+		t.Fatalf("bad: delta %v of base %v", delta.Number, base.Number)
+	}
+
+	// Revocations don't rebuild the CRL anymore
+	issuersTestRequest(t, b, s, logical.UpdateOperation, "revoke", map[string]interface{}{
+		"serial_number": serials[0],
+	})
+	if len(issuersTestCRLSerials(t, b, s, "crl")) != 0 {
+		t.Fatal("the CRL shouldn't be rebuilt on revocation")
+	}
+
+	// Delta CRLs are rebuilt once the interval has passed
+	if err := b.periodicFunc(ctx, &logical.Request{Storage: s}); err != nil {
+		t.Fatal(err)
+	}
+	if len(issuersTestCRLSerials(t, b, s, "crl/delta")) != 0 {
+		t.Fatal("the delta CRL shouldn't be rebuilt before the interval passed")
+	}
+	b.deltaCRLLastBuild = time.Time{}
+	if err := b.periodicFunc(ctx, &logical.Request{Storage: s}); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"crl/delta", "issuer/default/crl/delta"} {
+		deltaSerials := issuersTestCRLSerials(t, b, s, path)
+		if len(deltaSerials) != 1 || !deltaSerials[serials[0]] {
+			t.Fatalf("bad: %s serials %v", path, deltaSerials)
+		}
+	}
+	previousDelta := delta
+	delta = crlTestParse(t, b, s, "crl/delta")
+	if crlTestBaseNumber(t, delta).Cmp(base.Number) != 0 || delta.Number.Cmp(previousDelta.Number) <= 0 {
+		t.Fatalf("bad: delta %v of base %v", delta.Number, base.Number)
+	}
+
+	// The CRL is rebuilt within the grace period of its expiry, and the new
+	// delta CRL starts over from it
+	issuersTestRequest(t, b, s, logical.UpdateOperation, "config/crl", map[string]interface{}{
+		"auto_rebuild_grace_period": "59m58s",
+	})
+	time.Sleep(3 * time.Second)
+	if err := b.periodicFunc(ctx, &logical.Request{Storage: s}); err != nil {
+		t.Fatal(err)
+	}
+	crlSerials := issuersTestCRLSerials(t, b, s, "crl")
+	if len(crlSerials) != 1 || !crlSerials[serials[0]] {
+		t.Fatalf("bad: CRL serials %v", crlSerials)
+	}
+	newBase := crlTestParse(t, b, s, "crl")
+	if newBase.Number.Cmp(delta.Number) <= 0 {
+		t.Fatalf("bad: CRL number %v after delta CRL %v", newBase.Number, delta.Number)
+	}
+	delta = crlTestParse(t, b, s, "crl/delta")
+	if crlTestBaseNumber(t, delta).Cmp(newBase.Number) != 0 || len(delta.RevokedCertificateEntries) != 0 {
+		t.Fatalf("bad: delta %v of base %v with %d entries", delta.Number, newBase.Number, len(delta.RevokedCertificateEntries))
+	}
+
+	// Disabling delta CRLs removes them
+	issuersTestRequest(t, b, s, logical.UpdateOperation, "config/crl", map[string]interface{}{
+		"enable_delta": false,
+	})
+	resp = issuersTestRequest(t, b, s, logical.ReadOperation, "crl/delta", nil)
+	if resp.Data[logical.HTTPStatusCode] != 204 {
+		t.Fatalf("bad: %#v", resp.Data)
+	}
+
+	// Every new complete CRL clears the revocations logged for delta CRLs,
+	// even when CRLs are disabled
+	if err := s.Put(ctx, &logical.StorageEntry{Key: "delta-wal/" + normalizeSerial(serials[1])}); err != nil {
+		t.Fatal(err)
+	}
+	issuersTestRequest(t, b, s, logical.UpdateOperation, "config/crl", map[string]interface{}{
+		"disable": true,
+	})
+	walSerials, err := s.List(ctx, "delta-wal/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(walSerials) != 0 {
+		t.Fatalf("bad: delta CRL revocations %v", walSerials)
+	}
+}
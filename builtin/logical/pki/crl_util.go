@@ -5,17 +5,23 @@ import (
 	"crypto/rand"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/asn1"
 	"errors"
 	"fmt"
+	"math/big"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/hashicorp/errwrap"
-	"github.com/hashicorp/vault/helper/certutil"
 	"github.com/hashicorp/vault/helper/errutil"
 	"github.com/hashicorp/vault/logical"
 )
 
+// oidDeltaCRLIndicator identifies the delta CRL indicator extension of RFC
+// 5280 section 5.2.4
+var oidDeltaCRLIndicator = asn1.ObjectIdentifier{2, 5, 29, 27}
+
 type revocationInfo struct {
 	CertificateBytes  []byte    `json:"certificate_bytes"`
 	RevocationTime    int64     `json:"revocation_time"`
@@ -43,9 +49,19 @@ func revokeCert(ctx context.Context, b *backend, req *logical.Request, serial st
 	if signingBundle == nil {
 		return nil, errors.New("CA info not found")
 	}
+	issuerIDs, err := listIssuers(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
 	colonSerial := strings.Replace(strings.ToLower(serial), "-", ":", -1)
-	if colonSerial == certutil.GetHexFormatted(signingBundle.Certificate.SerialNumber.Bytes(), ":") {
-		return logical.ErrorResponse("adding CA to CRL is not allowed"), nil
+	for _, id := range issuerIDs {
+		issuer, err := fetchIssuer(ctx, req.Storage, id)
+		if err != nil {
+			return nil, err
+		}
+		if issuer != nil && colonSerial == strings.ToLower(issuer.Bundle.SerialNumber) {
+			return logical.ErrorResponse("adding CA to CRL is not allowed"), nil
+		}
 	}
 
 	alreadyRevoked := false
@@ -118,14 +134,40 @@ func revokeCert(ctx context.Context, b *backend, req *logical.Request, serial st
 			return nil, fmt.Errorf("error saving revoked certificate to new location")
 		}
 
+		if err := markCertMetadataRevoked(ctx, req.Storage, serial, currTime); err != nil {
+			return nil, errwrap.Wrapf("error indexing the revocation: {{err}}", err)
+		}
+
 	}
 
-	crlErr := buildCRL(ctx, b, req, false)
-	switch crlErr.(type) {
-	case errutil.UserError:
-		return logical.ErrorResponse(fmt.Sprintf("Error during CRL building: %s", crlErr)), nil
-	case errutil.InternalError:
-		return nil, errwrap.Wrapf("error encountered during CRL building: {{err}}", crlErr)
+	crlInfo, err := b.CRL(ctx, req.Storage)
+	if err != nil {
+		return nil, errwrap.Wrapf("error fetching CRL config information: {{err}}", err)
+	}
+
+	// Record the revocation for the next delta CRLs
+	if !alreadyRevoked && crlInfo != nil && crlInfo.EnableDelta && !crlInfo.Disable {
+		err = req.Storage.Put(ctx, &logical.StorageEntry{
+			Key: "delta-wal/" + normalizeSerial(serial),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error saving revoked certificate to the delta CRL log")
+		}
+		atomic.StoreUint32(b.deltaCRLDirty, 1)
+	}
+
+	if crlInfo != nil && crlInfo.AutoRebuild {
+		// The CRLs are rebuilt in the background, but OCSP answers from the
+		// revoked certificates right away
+		b.ocspCache.purge()
+	} else {
+		crlErr := buildCRL(ctx, b, req, false)
+		switch crlErr.(type) {
+		case errutil.UserError:
+			return logical.ErrorResponse(fmt.Sprintf("Error during CRL building: %s", crlErr)), nil
+		case errutil.InternalError:
+			return nil, errwrap.Wrapf("error encountered during CRL building: {{err}}", crlErr)
+		}
 	}
 
 	resp := &logical.Response{
@@ -139,18 +181,41 @@ func revokeCert(ctx context.Context, b *backend, req *logical.Request, serial st
 	return resp, nil
 }
 
-// Builds a CRL by going through the list of revoked certificates and building
-// a new CRL with the stored revocation times and serial numbers.
+// Builds the CRLs of the issuers by going through the list of revoked
+// certificates and building new CRLs with the stored revocation times and
+// serial numbers. Each issuer lists the certificates it issued, and the
+// default issuer also lists the ones issued by no current issuer. When delta
+// CRLs are enabled, they are rebuilt against the new CRLs.
 func buildCRL(ctx context.Context, b *backend, req *logical.Request, forceNew bool) error {
+	b.crlBuildLock.Lock()
+	defer b.crlBuildLock.Unlock()
+
+	// The revocation information or the issuers changed, so the cached OCSP
+	// responses may be stale
+	b.ocspCache.purge()
+
 	crlInfo, err := b.CRL(ctx, req.Storage)
 	if err != nil {
 		return errutil.InternalError{Err: fmt.Sprintf("error fetching CRL config information: %s", err)}
 	}
 
 	crlLifetime := b.crlLifetime
-	var revokedCerts []pkix.RevokedCertificate
-	var revInfo revocationInfo
+	var revokedCerts []*x509.Certificate
+	var revokedInfos []pkix.RevokedCertificate
 	var revokedSerials []string
+	var walSerials []string
+	var issuers []*caInfoBundle
+	var config *issuerConfig
+	var issuerRevoked map[string][]pkix.RevokedCertificate
+	now := time.Now()
+
+	// The revocations logged so far are all listed in the new CRLs, or no
+	// longer listed at all when they are disabled, so they are listed before
+	// the revoked certificates and cleared once the new CRLs are written
+	walSerials, err = req.Storage.List(ctx, "delta-wal/")
+	if err != nil {
+		return errutil.InternalError{Err: fmt.Sprintf("error fetching list of delta CRL revocations: %s", err)}
+	}
 
 	if crlInfo != nil {
 		if crlInfo.Expiry != "" {
@@ -189,6 +254,9 @@ func buildCRL(ctx context.Context, b *backend, req *logical.Request, forceNew bo
 			return errutil.InternalError{Err: fmt.Sprintf("found revoked serial but actual certificate is empty")}
 		}
 
+		// The parsed certificates are kept, so they must not share the
+		// decoded bytes
+		var revInfo revocationInfo
 		err = revokedEntry.DecodeJSON(&revInfo)
 		if err != nil {
 			return errutil.InternalError{Err: fmt.Sprintf("error decoding revocation entry for serial %s: %s", serial, err)}
@@ -209,30 +277,381 @@ func buildCRL(ctx context.Context, b *backend, req *logical.Request, forceNew bo
 		} else {
 			newRevCert.RevocationTime = time.Unix(revInfo.RevocationTime, 0).UTC()
 		}
-		revokedCerts = append(revokedCerts, newRevCert)
+		revokedCerts = append(revokedCerts, revokedCert)
+		revokedInfos = append(revokedInfos, newRevCert)
 	}
 
 WRITE:
-	signingBundle, caErr := fetchCAInfo(ctx, req)
-	switch caErr.(type) {
-	case errutil.UserError:
-		return errutil.UserError{Err: fmt.Sprintf("could not fetch the CA certificate: %s", caErr)}
-	case errutil.InternalError:
-		return errutil.InternalError{Err: fmt.Sprintf("error fetching CA certificate: %s", caErr)}
+	// Fail like before when there is no CA at all
+	if _, caErr := fetchCAInfo(ctx, req); caErr != nil {
+		switch caErr.(type) {
+		case errutil.UserError:
+			return errutil.UserError{Err: fmt.Sprintf("could not fetch the CA certificate: %s", caErr)}
+		default:
+			return errutil.InternalError{Err: fmt.Sprintf("error fetching CA certificate: %s", caErr)}
+		}
+	}
+
+	issuers, config, err = fetchCRLIssuers(ctx, req)
+	if err != nil {
+		return err
+	}
+	issuerRevoked = sortRevokedByIssuer(issuers, config, revokedCerts, revokedInfos)
+
+	for _, issuer := range issuers {
+		number, err := nextCRLNumber(ctx, req.Storage, issuer.IssuerID)
+		if err != nil {
+			return errutil.InternalError{Err: fmt.Sprintf("error fetching the current CRL: %s", err)}
+		}
+		crlBytes, err := signCRL(issuer, issuerRevoked[issuer.IssuerID], number, nil, now, now.Add(crlLifetime))
+		if err != nil {
+			return errutil.InternalError{Err: fmt.Sprintf("error creating new CRL: %s", err)}
+		}
+
+		err = req.Storage.Put(ctx, &logical.StorageEntry{
+			Key:   "crls/" + issuer.IssuerID,
+			Value: crlBytes,
+		})
+		if err != nil {
+			return errutil.InternalError{Err: fmt.Sprintf("error storing CRL: %s", err)}
+		}
+
+		// The CRL of the default issuer is also served by the "crl" endpoints
+		if issuer.IssuerID == config.DefaultIssuerID {
+			err = req.Storage.Put(ctx, &logical.StorageEntry{
+				Key:   "crl",
+				Value: crlBytes,
+			})
+			if err != nil {
+				return errutil.InternalError{Err: fmt.Sprintf("error storing CRL: %s", err)}
+			}
+		}
+	}
+
+	for _, serial := range walSerials {
+		if err := req.Storage.Delete(ctx, "delta-wal/"+serial); err != nil {
+			return errutil.InternalError{Err: fmt.Sprintf("error deleting delta CRL revocation of serial %s: %s", serial, err)}
+		}
+	}
+
+	if crlInfo == nil || !crlInfo.EnableDelta || crlInfo.Disable {
+		return nil
 	}
+	return buildDeltaCRLsLocked(ctx, b, req, crlLifetime)
+}
+
+// Builds the delta CRLs of the issuers, as described in RFC 5280 section
+// 5.2.4, listing the certificates revoked since their complete CRLs were
+// built. Delta CRLs aren't built for issuers which can only sign legacy CRLs.
+func buildDeltaCRLs(ctx context.Context, b *backend, req *logical.Request) error {
+	b.crlBuildLock.Lock()
+	defer b.crlBuildLock.Unlock()
 
-	crlBytes, err := signingBundle.Certificate.CreateCRL(rand.Reader, signingBundle.PrivateKey, revokedCerts, time.Now(), time.Now().Add(crlLifetime))
+	crlLifetime, err := b.crlExpiry(ctx, req.Storage)
 	if err != nil {
-		return errutil.InternalError{Err: fmt.Sprintf("error creating new CRL: %s", err)}
+		return err
+	}
+	return buildDeltaCRLsLocked(ctx, b, req, crlLifetime)
+}
+
+func buildDeltaCRLsLocked(ctx context.Context, b *backend, req *logical.Request, crlLifetime time.Duration) error {
+	// Revocations from now on call for another delta CRL
+	atomic.StoreUint32(b.deltaCRLDirty, 0)
+	b.deltaCRLLastBuild = time.Now()
+
+	walSerials, err := req.Storage.List(ctx, "delta-wal/")
+	if err != nil {
+		return errutil.InternalError{Err: fmt.Sprintf("error fetching list of delta CRL revocations: %s", err)}
+	}
+
+	var revokedCerts []*x509.Certificate
+	var revokedInfos []pkix.RevokedCertificate
+	for _, serial := range walSerials {
+		revokedEntry, err := req.Storage.Get(ctx, "revoked/"+serial)
+		if err != nil {
+			return errutil.InternalError{Err: fmt.Sprintf("unable to fetch revoked cert with serial %s: %s", serial, err)}
+		}
+		// Tidied since then
+		if revokedEntry == nil {
+			continue
+		}
+
+		var revInfo revocationInfo
+		if err := revokedEntry.DecodeJSON(&revInfo); err != nil {
+			return errutil.InternalError{Err: fmt.Sprintf("error decoding revocation entry for serial %s: %s", serial, err)}
+		}
+		revokedCert, err := x509.ParseCertificate(revInfo.CertificateBytes)
+		if err != nil {
+			return errutil.InternalError{Err: fmt.Sprintf("unable to parse stored revoked certificate with serial %s: %s", serial, err)}
+		}
+
+		newRevCert := pkix.RevokedCertificate{
+			SerialNumber:   revokedCert.SerialNumber,
+			RevocationTime: revInfo.RevocationTimeUTC,
+		}
+		if revInfo.RevocationTimeUTC.IsZero() {
+			newRevCert.RevocationTime = time.Unix(revInfo.RevocationTime, 0).UTC()
+		}
+		revokedCerts = append(revokedCerts, revokedCert)
+		revokedInfos = append(revokedInfos, newRevCert)
 	}
 
-	err = req.Storage.Put(ctx, &logical.StorageEntry{
-		Key:   "crl",
-		Value: crlBytes,
-	})
+	issuers, config, err := fetchCRLIssuers(ctx, req)
 	if err != nil {
-		return errutil.InternalError{Err: fmt.Sprintf("error storing CRL: %s", err)}
+		return err
+	}
+	issuerRevoked := sortRevokedByIssuer(issuers, config, revokedCerts, revokedInfos)
+
+	now := time.Now()
+	for _, issuer := range issuers {
+		base, err := fetchStoredCRL(ctx, req.Storage, "crls/"+issuer.IssuerID)
+		if err != nil {
+			return errutil.InternalError{Err: fmt.Sprintf("error fetching the current CRL: %s", err)}
+		}
+		if base == nil || base.Number == nil || !canSignRevocationList(issuer.Certificate) {
+			continue
+		}
+
+		number, err := nextCRLNumber(ctx, req.Storage, issuer.IssuerID)
+		if err != nil {
+			return errutil.InternalError{Err: fmt.Sprintf("error fetching the current CRL: %s", err)}
+		}
+
+		// The delta CRL is only useful along with its complete CRL
+		nextUpdate := base.NextUpdate
+		if !nextUpdate.After(now) {
+			nextUpdate = now.Add(crlLifetime)
+		}
+
+		crlBytes, err := signCRL(issuer, issuerRevoked[issuer.IssuerID], number, base.Number, now, nextUpdate)
+		if err != nil {
+			return errutil.InternalError{Err: fmt.Sprintf("error creating new delta CRL: %s", err)}
+		}
+
+		err = req.Storage.Put(ctx, &logical.StorageEntry{
+			Key:   "delta-crls/" + issuer.IssuerID,
+			Value: crlBytes,
+		})
+		if err != nil {
+			return errutil.InternalError{Err: fmt.Sprintf("error storing delta CRL: %s", err)}
+		}
+
+		// The delta CRL of the default issuer is also served by the
+		// "crl/delta" endpoints
+		if issuer.IssuerID == config.DefaultIssuerID {
+			err = req.Storage.Put(ctx, &logical.StorageEntry{
+				Key:   "delta-crl",
+				Value: crlBytes,
+			})
+			if err != nil {
+				return errutil.InternalError{Err: fmt.Sprintf("error storing delta CRL: %s", err)}
+			}
+		}
 	}
 
 	return nil
 }
+
+// autoRebuildCRLs rebuilds the CRLs when they are about to expire if
+// auto_rebuild is set, removing the expired revoked certificates beforehand.
+// Delta CRLs are rebuilt once the rebuild interval has passed, if certificates
+// were revoked since they were built.
+func (b *backend) autoRebuildCRLs(ctx context.Context, req *logical.Request) error {
+	crlInfo, err := b.CRL(ctx, req.Storage)
+	if err != nil {
+		return err
+	}
+	if crlInfo == nil || crlInfo.Disable {
+		return nil
+	}
+
+	if crlInfo.AutoRebuild {
+		gracePeriod, err := crlInfo.autoRebuildGracePeriod()
+		if err != nil {
+			return err
+		}
+		rebuild, err := crlsExpireWithin(ctx, req.Storage, gracePeriod)
+		if err != nil {
+			return err
+		}
+		if rebuild {
+			b.revokeStorageLock.Lock()
+			defer b.revokeStorageLock.Unlock()
+
+			if _, err := tidyRevoked(ctx, req.Storage, defaultTidySafetyBuffer, b.Logger().Named("tidy")); err != nil {
+				return err
+			}
+			return buildCRL(ctx, b, req, false)
+		}
+	}
+
+	if !crlInfo.EnableDelta || atomic.LoadUint32(b.deltaCRLDirty) == 0 {
+		return nil
+	}
+	interval, err := crlInfo.deltaRebuildInterval()
+	if err != nil {
+		return err
+	}
+	b.crlBuildLock.Lock()
+	lastBuild := b.deltaCRLLastBuild
+	b.crlBuildLock.Unlock()
+	if time.Since(lastBuild) < interval {
+		return nil
+	}
+	return buildDeltaCRLs(ctx, b, req)
+}
+
+// crlsExpireWithin returns whether the CRL of any issuer is missing or expires
+// within the duration
+func crlsExpireWithin(ctx context.Context, s logical.Storage, d time.Duration) (bool, error) {
+	issuerIDs, err := listIssuers(ctx, s)
+	if err != nil {
+		return false, err
+	}
+	for _, id := range issuerIDs {
+		crl, err := fetchStoredCRL(ctx, s, "crls/"+id)
+		if err != nil {
+			return false, err
+		}
+		if crl == nil || time.Now().Add(d).After(crl.NextUpdate) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// deleteDeltaCRLs removes the delta CRLs and the revocations logged for them
+func deleteDeltaCRLs(ctx context.Context, s logical.Storage) error {
+	for _, prefix := range []string{"delta-crls/", "delta-wal/"} {
+		keys, err := s.List(ctx, prefix)
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			if err := s.Delete(ctx, prefix+key); err != nil {
+				return err
+			}
+		}
+	}
+	return s.Delete(ctx, "delta-crl")
+}
+
+// fetchCRLIssuers returns the issuers of the mount, which all sign CRLs
+func fetchCRLIssuers(ctx context.Context, req *logical.Request) ([]*caInfoBundle, *issuerConfig, error) {
+	issuerIDs, err := listIssuers(ctx, req.Storage)
+	if err != nil {
+		return nil, nil, err
+	}
+	config, err := fetchIssuerConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var issuers []*caInfoBundle
+	for _, id := range issuerIDs {
+		issuer, err := fetchIssuer(ctx, req.Storage, id)
+		if err != nil {
+			return nil, nil, err
+		}
+		if issuer == nil {
+			continue
+		}
+		caInfo, err := issuer.caInfo(ctx, req)
+		if err != nil {
+			return nil, nil, errutil.InternalError{Err: fmt.Sprintf("error fetching CA certificate: %s", err)}
+		}
+		issuers = append(issuers, caInfo)
+	}
+
+	return issuers, config, nil
+}
+
+// sortRevokedByIssuer maps the IDs of the issuers to the revoked certificates
+// they issued. Certificates issued by no current issuer go to the default one.
+func sortRevokedByIssuer(issuers []*caInfoBundle, config *issuerConfig, revokedCerts []*x509.Certificate, revokedInfos []pkix.RevokedCertificate) map[string][]pkix.RevokedCertificate {
+	issuerRevoked := make(map[string][]pkix.RevokedCertificate, len(issuers))
+	for i, revokedCert := range revokedCerts {
+		issuerID := config.DefaultIssuerID
+		for _, issuer := range issuers {
+			if issuedBy(revokedCert, issuer.Certificate) {
+				issuerID = issuer.IssuerID
+				break
+			}
+		}
+		issuerRevoked[issuerID] = append(issuerRevoked[issuerID], revokedInfos[i])
+	}
+	return issuerRevoked
+}
+
+// fetchStoredCRL parses the CRL stored at the key, if any
+func fetchStoredCRL(ctx context.Context, s logical.Storage, key string) (*x509.RevocationList, error) {
+	entry, err := s.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil || len(entry.Value) == 0 {
+		return nil, nil
+	}
+	return x509.ParseRevocationList(entry.Value)
+}
+
+// nextCRLNumber returns the number following those of the current complete
+// and delta CRLs of the issuer, which share a sequence
+func nextCRLNumber(ctx context.Context, s logical.Storage, issuerID string) (*big.Int, error) {
+	next := big.NewInt(1)
+	for _, key := range []string{"crls/" + issuerID, "delta-crls/" + issuerID} {
+		crl, err := fetchStoredCRL(ctx, s, key)
+		if err != nil {
+			return nil, err
+		}
+		if crl != nil && crl.Number != nil && crl.Number.Cmp(next) >= 0 {
+			next = new(big.Int).Add(crl.Number, big.NewInt(1))
+		}
+	}
+	return next, nil
+}
+
+// canSignRevocationList returns whether the issuer can sign CRLs with a CRL
+// number. Imported CAs without the CRL signing key usage or a subject key ID
+// only sign legacy CRLs.
+func canSignRevocationList(cert *x509.Certificate) bool {
+	return cert.KeyUsage&x509.KeyUsageCRLSign != 0 && len(cert.SubjectKeyId) != 0
+}
+
+// signCRL signs a CRL of the issuer. It is a delta CRL of the complete CRL
+// numbered baseNumber if that isn't nil.
+func signCRL(issuer *caInfoBundle, revoked []pkix.RevokedCertificate, number, baseNumber *big.Int, thisUpdate, nextUpdate time.Time) ([]byte, error) {
+	if !canSignRevocationList(issuer.Certificate) {
+		if baseNumber != nil {
+			return nil, errors.New("the issuer can't sign delta CRLs")
+		}
+		return issuer.Certificate.CreateCRL(rand.Reader, issuer.PrivateKey, revoked, thisUpdate, nextUpdate)
+	}
+
+	template := &x509.RevocationList{
+		Number:     number,
+		ThisUpdate: thisUpdate,
+		NextUpdate: nextUpdate,
+	}
+	for _, revokedCert := range revoked {
+		template.RevokedCertificateEntries = append(template.RevokedCertificateEntries, x509.RevocationListEntry{
+			SerialNumber:   revokedCert.SerialNumber,
+			RevocationTime: revokedCert.RevocationTime,
+		})
+	}
+	if baseNumber != nil {
+		value, err := asn1.Marshal(baseNumber)
+		if err != nil {
+			return nil, err
+		}
+		template.ExtraExtensions = []pkix.Extension{
+			{
+				Id:       oidDeltaCRLIndicator,
+				Critical: true,
+				Value:    value,
+			},
+		}
+	}
+
+	return x509.CreateRevocationList(rand.Reader, template, issuer.Certificate, issuer.PrivateKey)
+}
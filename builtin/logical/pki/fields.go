@@ -97,6 +97,12 @@ default TTL is used, in that order. Cannot
 be larger than the role max TTL.`,
 	}
 
+	fields["issuer_ref"] = &framework.FieldSchema{
+		Type: framework.TypeString,
+		Description: `The ID or name of the issuer signing the
+certificate, overriding the issuer of the role.`,
+	}
+
 	return fields
 }
 
@@ -207,8 +213,41 @@ the key_type.`,
 	fields["key_type"] = &framework.FieldSchema{
 		Type:    framework.TypeString,
 		Default: "rsa",
-		Description: `The type of key to use; defaults to RSA. "rsa"
-and "ec" are the only valid values.`,
+		Description: `The type of key to use; defaults to RSA. "rsa",
+"ec" and "ed25519" are the only valid values.`,
+	}
+
+	return fields
+}
+
+// addPKCS12Fields adds the fields of endpoints which can return a certificate
+// with its private key as a PKCS#12 archive
+func addPKCS12Fields(fields map[string]*framework.FieldSchema) map[string]*framework.FieldSchema {
+	fields["format"] = &framework.FieldSchema{
+		Type:    framework.TypeString,
+		Default: "pem",
+		Description: `Format for returned data. Can be "pem", "der",
+"pem_bundle" or "pkcs12". If "pem_bundle" any private
+key and issuing cert will be appended to the
+certificate pem. If "pkcs12", the certificate, its
+CA chain and private key are returned as a base64-encoded
+PKCS#12 archive protected by "password". Defaults to "pem".`,
+	}
+
+	fields["password"] = &framework.FieldSchema{
+		Type: framework.TypeString,
+		Description: `The password protecting the PKCS#12 archive
+when "format" is "pkcs12".`,
+	}
+
+	fields["pkcs12_encoding"] = &framework.FieldSchema{
+		Type:    framework.TypeString,
+		Default: "modern",
+		Description: `The algorithms protecting the PKCS#12 archive
+when "format" is "pkcs12". Can be "modern", for AES-256
+encryption with a PBKDF2 key and an HMAC-SHA256 MAC, or
+"legacy", for the weak Triple DES encryption and HMAC-SHA1
+MAC required by older consumers. Defaults to "modern".`,
 	}
 
 	return fields
@@ -230,3 +269,31 @@ func addCAIssueFields(fields map[string]*framework.FieldSchema) map[string]*fram
 
 	return fields
 }
+
+// addIssuerNameField adds the name given to a new issuer
+func addIssuerNameField(fields map[string]*framework.FieldSchema) map[string]*framework.FieldSchema {
+	fields["issuer_name"] = &framework.FieldSchema{
+		Type: framework.TypeString,
+		Description: `An optional name for the new issuer, which can be
+used instead of its ID to reference it.`,
+	}
+
+	return fields
+}
+
+// addIssuerRefField adds the reference to the issuer signing certificates
+func addIssuerRefField(fields map[string]*framework.FieldSchema) map[string]*framework.FieldSchema {
+	fields["issuer_ref"] = issuerRefField()
+
+	return fields
+}
+
+func issuerRefField() *framework.FieldSchema {
+	return &framework.FieldSchema{
+		Type:    framework.TypeString,
+		Default: defaultIssuerRef,
+		Description: `The ID or name of the issuer signing the
+certificate. Defaults to "default", the default
+issuer of the mount.`,
+	}
+}
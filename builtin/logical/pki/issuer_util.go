@@ -0,0 +1,357 @@
+package pki
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"sync/atomic"
+	"time"
+
+	uuid "github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/vault/helper/certutil"
+	"github.com/hashicorp/vault/helper/errutil"
+	"github.com/hashicorp/vault/logical"
+)
+
+// defaultIssuerRef is the issuer reference resolving to the default issuer
+const defaultIssuerRef = "default"
+
+var issuerNameRegex = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// issuerEntry is a CA of the mount, along with its private key
+type issuerEntry struct {
+	ID     string               `json:"id"`
+	Name   string               `json:"name"`
+	Bundle *certutil.CertBundle `json:"bundle"`
+}
+
+// issuerConfig holds the issuer used when no issuer is referenced
+type issuerConfig struct {
+	DefaultIssuerID string `json:"default"`
+}
+
+// legacyCAMigrationLog records the migration of the legacy CA bundle, which
+// is kept for older versions of Vault. The bundle is migrated again only when
+// its hash changed.
+type legacyCAMigrationLog struct {
+	Hash     string    `json:"hash"`
+	IssuerID string    `json:"issuer_id"`
+	Time     time.Time `json:"time"`
+}
+
+// migrateLegacyCA copies the single CA bundle of mounts created before
+// multiple issuers were supported to an issuer, which becomes the default
+// one. A bundle holding only a key is the key of an intermediate CA waiting
+// for its certificate. It writes to storage, so only the active node runs
+// it, once after the backend is loaded.
+func (b *backend) migrateLegacyCA(ctx context.Context, s logical.Storage) error {
+	if atomic.LoadUint32(b.legacyCAMigrated) == 1 {
+		return nil
+	}
+
+	b.legacyCALock.Lock()
+	defer b.legacyCALock.Unlock()
+
+	if atomic.LoadUint32(b.legacyCAMigrated) == 1 {
+		return nil
+	}
+
+	entry, err := s.Get(ctx, "config/ca_bundle")
+	if err != nil {
+		return errutil.InternalError{Err: fmt.Sprintf("unable to fetch local CA certificate/key: %v", err)}
+	}
+	if entry != nil {
+		if err := migrateLegacyCAEntry(ctx, s, entry); err != nil {
+			return err
+		}
+	}
+
+	atomic.StoreUint32(b.legacyCAMigrated, 1)
+	return nil
+}
+
+func migrateLegacyCAEntry(ctx context.Context, s logical.Storage, entry *logical.StorageEntry) error {
+	hash := sha256.Sum256(entry.Value)
+	migrationLog := &legacyCAMigrationLog{
+		Hash: hex.EncodeToString(hash[:]),
+		Time: time.Now(),
+	}
+
+	logEntry, err := s.Get(ctx, "config/legacy_ca_migration")
+	if err != nil {
+		return errutil.InternalError{Err: fmt.Sprintf("unable to fetch legacy CA migration log: %v", err)}
+	}
+	if logEntry != nil {
+		var previous legacyCAMigrationLog
+		if err := logEntry.DecodeJSON(&previous); err != nil {
+			return errutil.InternalError{Err: fmt.Sprintf("unable to decode legacy CA migration log: %v", err)}
+		}
+		if previous.Hash == migrationLog.Hash {
+			return nil
+		}
+	}
+
+	var cb certutil.CertBundle
+	if err := entry.DecodeJSON(&cb); err != nil {
+		return errutil.InternalError{Err: fmt.Sprintf("unable to decode local CA certificate/key: %v", err)}
+	}
+
+	if cb.Certificate == "" {
+		pending, err := logical.StorageEntryJSON("config/pending_intermediate", &cb)
+		if err != nil {
+			return err
+		}
+		if err := s.Put(ctx, pending); err != nil {
+			return err
+		}
+	} else {
+		issuer, err := storeIssuer(ctx, s, &cb, "")
+		if err != nil {
+			return err
+		}
+		migrationLog.IssuerID = issuer.ID
+	}
+
+	logEntry, err = logical.StorageEntryJSON("config/legacy_ca_migration", migrationLog)
+	if err != nil {
+		return err
+	}
+	return s.Put(ctx, logEntry)
+}
+
+// listIssuers returns the IDs of the issuers of the mount
+func listIssuers(ctx context.Context, s logical.Storage) ([]string, error) {
+	ids, err := s.List(ctx, "issuers/")
+	if err != nil {
+		return nil, errutil.InternalError{Err: fmt.Sprintf("unable to list issuers: %v", err)}
+	}
+	return ids, nil
+}
+
+func fetchIssuer(ctx context.Context, s logical.Storage, id string) (*issuerEntry, error) {
+	entry, err := s.Get(ctx, "issuers/"+id)
+	if err != nil {
+		return nil, errutil.InternalError{Err: fmt.Sprintf("unable to fetch issuer %q: %v", id, err)}
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	var issuer issuerEntry
+	if err := entry.DecodeJSON(&issuer); err != nil {
+		return nil, errutil.InternalError{Err: fmt.Sprintf("unable to decode issuer %q: %v", id, err)}
+	}
+	return &issuer, nil
+}
+
+func fetchIssuerConfig(ctx context.Context, s logical.Storage) (*issuerConfig, error) {
+	entry, err := s.Get(ctx, "config/issuers")
+	if err != nil {
+		return nil, errutil.InternalError{Err: fmt.Sprintf("unable to fetch issuers configuration: %v", err)}
+	}
+
+	var config issuerConfig
+	if entry != nil {
+		if err := entry.DecodeJSON(&config); err != nil {
+			return nil, errutil.InternalError{Err: fmt.Sprintf("unable to decode issuers configuration: %v", err)}
+		}
+	}
+	return &config, nil
+}
+
+// resolveIssuerRef returns the ID of the issuer referenced by its ID, its
+// name, or "default"
+func resolveIssuerRef(ctx context.Context, s logical.Storage, ref string) (string, error) {
+	ids, err := listIssuers(ctx, s)
+	if err != nil {
+		return "", err
+	}
+
+	if ref == "" || ref == defaultIssuerRef {
+		config, err := fetchIssuerConfig(ctx, s)
+		if err != nil {
+			return "", err
+		}
+		if config.DefaultIssuerID == "" {
+			return "", errutil.UserError{Err: "backend must be configured with a CA certificate/key"}
+		}
+		return config.DefaultIssuerID, nil
+	}
+
+	for _, id := range ids {
+		if id == ref {
+			return id, nil
+		}
+	}
+	for _, id := range ids {
+		issuer, err := fetchIssuer(ctx, s, id)
+		if err != nil {
+			return "", err
+		}
+		if issuer != nil && issuer.Name == ref {
+			return id, nil
+		}
+	}
+
+	return "", errutil.UserError{Err: fmt.Sprintf("unknown issuer %q", ref)}
+}
+
+// storeIssuer stores the bundle as a new issuer. The first issuer of the
+// mount becomes the default one.
+func storeIssuer(ctx context.Context, s logical.Storage, cb *certutil.CertBundle, name string) (*issuerEntry, error) {
+	id, err := uuid.GenerateUUID()
+	if err != nil {
+		return nil, err
+	}
+
+	issuer := &issuerEntry{
+		ID:     id,
+		Name:   name,
+		Bundle: cb,
+	}
+	if err := putIssuer(ctx, s, issuer); err != nil {
+		return nil, err
+	}
+
+	config, err := fetchIssuerConfig(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	if config.DefaultIssuerID == "" {
+		if err := setDefaultIssuer(ctx, s, issuer); err != nil {
+			return nil, err
+		}
+	}
+
+	return issuer, nil
+}
+
+func putIssuer(ctx context.Context, s logical.Storage, issuer *issuerEntry) error {
+	entry, err := logical.StorageEntryJSON("issuers/"+issuer.ID, issuer)
+	if err != nil {
+		return err
+	}
+	return s.Put(ctx, entry)
+}
+
+// setDefaultIssuer makes the issuer the default one, which is also served by
+// the "ca" endpoints
+func setDefaultIssuer(ctx context.Context, s logical.Storage, issuer *issuerEntry) error {
+	entry, err := logical.StorageEntryJSON("config/issuers", &issuerConfig{
+		DefaultIssuerID: issuer.ID,
+	})
+	if err != nil {
+		return err
+	}
+	if err := s.Put(ctx, entry); err != nil {
+		return err
+	}
+
+	parsedBundle, err := issuer.Bundle.ToParsedCertBundle()
+	if err != nil {
+		return err
+	}
+
+	// For ease of later use, also store just the certificate at a known
+	// location
+	return s.Put(ctx, &logical.StorageEntry{
+		Key:   "ca",
+		Value: parsedBundle.CertificateBytes,
+	})
+}
+
+// fetchCAInfoByRef returns the bundle of the referenced issuer, along with
+// the URLs of the mount
+func fetchCAInfoByRef(ctx context.Context, req *logical.Request, ref string) (*caInfoBundle, error) {
+	id, err := resolveIssuerRef(ctx, req.Storage, ref)
+	if err != nil {
+		return nil, err
+	}
+	issuer, err := fetchIssuer(ctx, req.Storage, id)
+	if err != nil {
+		return nil, err
+	}
+	if issuer == nil {
+		return nil, errutil.UserError{Err: fmt.Sprintf("unknown issuer %q", ref)}
+	}
+
+	return issuer.caInfo(ctx, req)
+}
+
+func (i *issuerEntry) caInfo(ctx context.Context, req *logical.Request) (*caInfoBundle, error) {
+	parsedBundle, err := i.Bundle.ToParsedCertBundle()
+	if err != nil {
+		return nil, errutil.InternalError{Err: err.Error()}
+	}
+	if parsedBundle.Certificate == nil {
+		return nil, errutil.InternalError{Err: "stored CA information not able to be parsed"}
+	}
+
+	caInfo := &caInfoBundle{
+		ParsedCertBundle: *parsedBundle,
+		IssuerID:         i.ID,
+	}
+
+	entries, err := getURLs(ctx, req)
+	if err != nil {
+		return nil, errutil.InternalError{Err: fmt.Sprintf("unable to fetch URL information: %v", err)}
+	}
+	if entries == nil {
+		entries = &urlEntries{
+			IssuingCertificates:   []string{},
+			CRLDistributionPoints: []string{},
+			OCSPServers:           []string{},
+		}
+	}
+	caInfo.URLs = entries
+
+	return caInfo, nil
+}
+
+// validateIssuerName checks that the name can reference an issuer and isn't
+// used by another issuer
+func validateIssuerName(ctx context.Context, s logical.Storage, name, id string) error {
+	if name == "" {
+		return nil
+	}
+	if name == defaultIssuerRef || !issuerNameRegex.MatchString(name) {
+		return errutil.UserError{Err: fmt.Sprintf("invalid issuer name %q", name)}
+	}
+
+	ids, err := listIssuers(ctx, s)
+	if err != nil {
+		return err
+	}
+	for _, otherID := range ids {
+		if otherID == name {
+			return errutil.UserError{Err: fmt.Sprintf("invalid issuer name %q", name)}
+		}
+		if otherID == id {
+			continue
+		}
+		other, err := fetchIssuer(ctx, s, otherID)
+		if err != nil {
+			return err
+		}
+		if other != nil && other.Name == name {
+			return errutil.UserError{Err: fmt.Sprintf("issuer name %q is already used", name)}
+		}
+	}
+
+	return nil
+}
+
+// issuedBy returns whether the certificate was issued by the CA
+func issuedBy(cert, ca *x509.Certificate) bool {
+	if !bytes.Equal(cert.RawIssuer, ca.RawSubject) {
+		return false
+	}
+	if len(cert.AuthorityKeyId) > 0 && len(ca.SubjectKeyId) > 0 {
+		return bytes.Equal(cert.AuthorityKeyId, ca.SubjectKeyId)
+	}
+	return cert.CheckSignatureFrom(ca) == nil
+}
@@ -0,0 +1,934 @@
+package pki
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/helper/certutil"
+	"github.com/hashicorp/vault/helper/errutil"
+	"github.com/hashicorp/vault/helper/locksutil"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+// acmeHandlerFunc handles a verified ACME request
+type acmeHandlerFunc func(ctx context.Context, req *logical.Request, data *framework.FieldData, ac *acmeContext, ar *acmeRequest) (*logical.Response, error)
+
+func acmeJWSFields() map[string]*framework.FieldSchema {
+	return map[string]*framework.FieldSchema{
+		"protected": &framework.FieldSchema{
+			Type:        framework.TypeString,
+			Description: `The base64url-encoded protected header of the JWS.`,
+		},
+		"payload": &framework.FieldSchema{
+			Type:        framework.TypeString,
+			Description: `The base64url-encoded payload of the JWS.`,
+		},
+		"signature": &framework.FieldSchema{
+			Type:        framework.TypeString,
+			Description: `The base64url-encoded signature of the JWS.`,
+		},
+	}
+}
+
+// acmeIDRegex matches an ACME resource ID. IDs are random base64url strings,
+// which may start or end with a hyphen unlike generic names.
+func acmeIDRegex(name string) string {
+	return fmt.Sprintf(`(?P<%s>[\w-]+)`, name)
+}
+
+// acmePath returns an ACME endpoint taking JWS-signed POST requests
+func acmePath(pattern string, handler framework.OperationFunc, fields map[string]*framework.FieldSchema) *framework.Path {
+	path := &framework.Path{
+		Pattern: pattern,
+		Fields:  acmeJWSFields(),
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: handler,
+		},
+
+		HelpSynopsis:    pathACMEHelpSyn,
+		HelpDescription: pathACMEHelpDesc,
+	}
+	for name, field := range fields {
+		path.Fields[name] = field
+	}
+	return path
+}
+
+func pathACME(b *backend) []*framework.Path {
+	return []*framework.Path{
+		&framework.Path{
+			Pattern: "acme/directory",
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ReadOperation: b.pathACMEDirectory,
+			},
+			HelpSynopsis:    pathACMEHelpSyn,
+			HelpDescription: pathACMEHelpDesc,
+		},
+		&framework.Path{
+			Pattern: "acme/new-nonce",
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ReadOperation: b.pathACMENewNonce,
+			},
+			HelpSynopsis:    pathACMEHelpSyn,
+			HelpDescription: pathACMEHelpDesc,
+		},
+		acmePath("acme/new-account", b.acmeHandler(true, b.pathACMENewAccount), nil),
+		acmePath("acme/account/"+acmeIDRegex("account_id")+"/orders", b.acmeHandler(false, b.pathACMEAccountOrders), map[string]*framework.FieldSchema{
+			"account_id": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: `The ID of the account.`,
+			},
+		}),
+		acmePath("acme/account/"+acmeIDRegex("account_id"), b.acmeHandler(false, b.pathACMEAccount), map[string]*framework.FieldSchema{
+			"account_id": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: `The ID of the account.`,
+			},
+		}),
+		acmePath("acme/new-order", b.acmeHandler(false, b.pathACMENewOrder), nil),
+		acmePath("acme/order/"+acmeIDRegex("order_id")+"/finalize", b.acmeHandler(false, b.pathACMEFinalize), map[string]*framework.FieldSchema{
+			"order_id": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: `The ID of the order.`,
+			},
+		}),
+		acmePath("acme/order/"+acmeIDRegex("order_id")+"/cert", b.acmeHandler(false, b.pathACMECertificate), map[string]*framework.FieldSchema{
+			"order_id": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: `The ID of the order.`,
+			},
+		}),
+		acmePath("acme/order/"+acmeIDRegex("order_id"), b.acmeHandler(false, b.pathACMEOrder), map[string]*framework.FieldSchema{
+			"order_id": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: `The ID of the order.`,
+			},
+		}),
+		acmePath("acme/authorization/"+acmeIDRegex("authz_id"), b.acmeHandler(false, b.pathACMEAuthorization), map[string]*framework.FieldSchema{
+			"authz_id": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: `The ID of the authorization.`,
+			},
+		}),
+		acmePath("acme/challenge/"+acmeIDRegex("authz_id")+"/"+framework.GenericNameRegex("type"), b.acmeHandler(false, b.pathACMEChallenge), map[string]*framework.FieldSchema{
+			"authz_id": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: `The ID of the authorization.`,
+			},
+			"type": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: `The type of the challenge.`,
+			},
+		}),
+		acmePath("acme/revoke-cert", b.acmeHandler(true, b.pathACMERevokeCert), nil),
+	}
+}
+
+// acmeHandler returns an operation verifying the JWS of the request before
+// calling handler, and turning its errors into ACME problem documents
+func (b *backend) acmeHandler(allowJWK bool, handler acmeHandlerFunc) framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		ac, err := b.acmeContext(ctx, req)
+		if err != nil {
+			return b.acmeErrorResponse(nil, err)
+		}
+
+		ar, err := b.parseJWS(ctx, req, ac, allowJWK)
+		if err != nil {
+			return b.acmeErrorResponse(ac, err)
+		}
+		defer ar.lock.Unlock()
+
+		resp, err := handler(ctx, req, data, ac, ar)
+		if err != nil {
+			return b.acmeErrorResponse(ac, err)
+		}
+		return resp, nil
+	}
+}
+
+func (b *backend) pathACMEDirectory(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	ac, err := b.acmeContext(ctx, req)
+	if err != nil {
+		return b.acmeErrorResponse(nil, err)
+	}
+
+	return b.acmeJSONResponse(ac, http.StatusOK, map[string]interface{}{
+		"newNonce":   ac.url("new-nonce"),
+		"newAccount": ac.url("new-account"),
+		"newOrder":   ac.url("new-order"),
+		"revokeCert": ac.url("revoke-cert"),
+	}, "")
+}
+
+func (b *backend) pathACMENewNonce(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	ac, err := b.acmeContext(ctx, req)
+	if err != nil {
+		return b.acmeErrorResponse(nil, err)
+	}
+
+	return b.acmeResponse(ac, http.StatusOK, acmeContentTypeJSON, []byte{})
+}
+
+func (b *backend) pathACMENewAccount(ctx context.Context, req *logical.Request, data *framework.FieldData, ac *acmeContext, ar *acmeRequest) (*logical.Response, error) {
+	var payload struct {
+		Contact              []string `json:"contact"`
+		TermsOfServiceAgreed bool     `json:"termsOfServiceAgreed"`
+		OnlyReturnExisting   bool     `json:"onlyReturnExisting"`
+	}
+	if err := ar.decode(&payload); err != nil {
+		return nil, err
+	}
+
+	if ar.Account != nil {
+		return b.acmeJSONResponse(ac, http.StatusOK, acmeAccountJSON(ac, ar.Account), ac.accountURL(ar.Account.ID))
+	}
+	if payload.OnlyReturnExisting {
+		return nil, newACMEError("accountDoesNotExist", http.StatusBadRequest, "no account exists for this key")
+	}
+	if err := validateACMEContact(payload.Contact); err != nil {
+		return nil, err
+	}
+
+	id, err := acmeRandomToken()
+	if err != nil {
+		return nil, err
+	}
+	thumbprint, err := acmeThumbprint(ar.Key)
+	if err != nil {
+		return nil, err
+	}
+	account := &acmeAccount{
+		ID:         id,
+		Key:        ar.Key,
+		Thumbprint: thumbprint,
+		Status:     acmeStatusValid,
+		Contact:    payload.Contact,
+		CreatedAt:  time.Now(),
+	}
+
+	if err := acmePut(ctx, req.Storage, "acme/accounts/"+id, account); err != nil {
+		return nil, err
+	}
+	err = req.Storage.Put(ctx, &logical.StorageEntry{
+		Key:   "acme/thumbprints/" + thumbprint,
+		Value: []byte(id),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return b.acmeJSONResponse(ac, http.StatusCreated, acmeAccountJSON(ac, account), ac.accountURL(id))
+}
+
+func (b *backend) pathACMEAccount(ctx context.Context, req *logical.Request, data *framework.FieldData, ac *acmeContext, ar *acmeRequest) (*logical.Response, error) {
+	account := ar.Account
+	if account.ID != data.Get("account_id").(string) {
+		return nil, acmeUnauthorized("the request was not signed by the account")
+	}
+
+	if len(ar.Payload) > 0 {
+		var payload struct {
+			Contact []string `json:"contact"`
+			Status  string   `json:"status"`
+		}
+		if err := ar.decode(&payload); err != nil {
+			return nil, err
+		}
+
+		switch payload.Status {
+		case "":
+		case acmeStatusDeactivated:
+			account.Status = acmeStatusDeactivated
+		default:
+			return nil, acmeMalformed("invalid account status %q", payload.Status)
+		}
+		if payload.Contact != nil {
+			if err := validateACMEContact(payload.Contact); err != nil {
+				return nil, err
+			}
+			account.Contact = payload.Contact
+		}
+
+		if err := acmePut(ctx, req.Storage, "acme/accounts/"+account.ID, account); err != nil {
+			return nil, err
+		}
+	}
+
+	return b.acmeJSONResponse(ac, http.StatusOK, acmeAccountJSON(ac, account), ac.accountURL(account.ID))
+}
+
+func (b *backend) pathACMEAccountOrders(ctx context.Context, req *logical.Request, data *framework.FieldData, ac *acmeContext, ar *acmeRequest) (*logical.Response, error) {
+	if ar.Account.ID != data.Get("account_id").(string) {
+		return nil, acmeUnauthorized("the request was not signed by the account")
+	}
+
+	orders := []string{}
+	for _, id := range ar.Account.OrderIDs {
+		orders = append(orders, ac.orderURL(id))
+	}
+
+	return b.acmeJSONResponse(ac, http.StatusOK, map[string]interface{}{
+		"orders": orders,
+	}, "")
+}
+
+func (b *backend) pathACMENewOrder(ctx context.Context, req *logical.Request, data *framework.FieldData, ac *acmeContext, ar *acmeRequest) (*logical.Response, error) {
+	var payload struct {
+		Identifiers []acmeIdentifier `json:"identifiers"`
+		NotBefore   string           `json:"notBefore"`
+		NotAfter    string           `json:"notAfter"`
+	}
+	if err := ar.decode(&payload); err != nil {
+		return nil, err
+	}
+
+	if payload.NotBefore != "" || payload.NotAfter != "" {
+		return nil, acmeMalformed("notBefore and notAfter are not supported, the validity of certificates is set by the role")
+	}
+	if len(payload.Identifiers) == 0 {
+		return nil, acmeMalformed("the order has no identifiers")
+	}
+
+	var names []string
+	seen := make(map[string]bool)
+	for _, identifier := range payload.Identifiers {
+		if identifier.Type != "dns" {
+			return nil, newACMEError("unsupportedIdentifier", http.StatusBadRequest, "unsupported identifier type %q", identifier.Type)
+		}
+		name := strings.ToLower(identifier.Value)
+		if name == "" {
+			return nil, acmeMalformed("empty identifier")
+		}
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	if badName := validateNames(&dataBundle{role: ac.role}, names); badName != "" {
+		return nil, newACMEError("rejectedIdentifier", http.StatusBadRequest, "the identifier %q is not allowed by the role", badName)
+	}
+
+	orderID, err := acmeRandomToken()
+	if err != nil {
+		return nil, err
+	}
+	order := &acmeOrder{
+		ID:        orderID,
+		AccountID: ar.Account.ID,
+		Status:    acmeStatusPending,
+		Expires:   time.Now().Add(acmeOrderLifetime),
+	}
+
+	for _, name := range names {
+		authz, err := newACMEAuthorization(ar.Account.ID, name, order.Expires)
+		if err != nil {
+			return nil, err
+		}
+		if err := acmePut(ctx, req.Storage, "acme/authorizations/"+authz.ID, authz); err != nil {
+			return nil, err
+		}
+
+		order.Identifiers = append(order.Identifiers, acmeIdentifier{Type: "dns", Value: name})
+		order.AuthorizationIDs = append(order.AuthorizationIDs, authz.ID)
+	}
+
+	if err := acmePut(ctx, req.Storage, "acme/orders/"+orderID, order); err != nil {
+		return nil, err
+	}
+	ar.Account.OrderIDs = append(ar.Account.OrderIDs, orderID)
+	if err := acmePut(ctx, req.Storage, "acme/accounts/"+ar.Account.ID, ar.Account); err != nil {
+		return nil, err
+	}
+
+	return b.acmeJSONResponse(ac, http.StatusCreated, acmeOrderJSON(ac, order), ac.orderURL(orderID))
+}
+
+func (b *backend) pathACMEOrder(ctx context.Context, req *logical.Request, data *framework.FieldData, ac *acmeContext, ar *acmeRequest) (*logical.Response, error) {
+	order, err := b.acmeAccountOrder(ctx, req.Storage, ar.Account, data.Get("order_id").(string))
+	if err != nil {
+		return nil, err
+	}
+
+	return b.acmeJSONResponse(ac, http.StatusOK, acmeOrderJSON(ac, order), ac.orderURL(order.ID))
+}
+
+func (b *backend) pathACMEFinalize(ctx context.Context, req *logical.Request, data *framework.FieldData, ac *acmeContext, ar *acmeRequest) (*logical.Response, error) {
+	order, err := b.acmeAccountOrder(ctx, req.Storage, ar.Account, data.Get("order_id").(string))
+	if err != nil {
+		return nil, err
+	}
+	if order.Status != acmeStatusReady {
+		return nil, newACMEError("orderNotReady", http.StatusForbidden, "the order is %s", order.Status)
+	}
+
+	var payload struct {
+		CSR string `json:"csr"`
+	}
+	if err := ar.decode(&payload); err != nil {
+		return nil, err
+	}
+	der, err := base64.RawURLEncoding.DecodeString(payload.CSR)
+	if err != nil {
+		return nil, newACMEError("badCSR", http.StatusBadRequest, "invalid CSR encoding: %s", err)
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		return nil, newACMEError("badCSR", http.StatusBadRequest, "invalid CSR: %s", err)
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, newACMEError("badCSR", http.StatusBadRequest, "invalid CSR signature: %s", err)
+	}
+	if err := checkACMECSRNames(csr, order.Identifiers); err != nil {
+		return nil, err
+	}
+
+	signingBundle, err := fetchCAInfoByRef(ctx, req, ac.role.IssuerRef)
+	if err != nil {
+		return nil, err
+	}
+
+	// The CSR is signed as if it was sent to the sign endpoint of the role
+	commonName := csr.Subject.CommonName
+	if commonName == "" {
+		commonName = order.Identifiers[0].Value
+	}
+	var altNames []string
+	for _, identifier := range order.Identifiers {
+		altNames = append(altNames, identifier.Value)
+	}
+	signData := &framework.FieldData{
+		Raw: map[string]interface{}{
+			"csr": string(pem.EncodeToMemory(&pem.Block{
+				Type:  "CERTIFICATE REQUEST",
+				Bytes: der,
+			})),
+			"common_name": commonName,
+			"alt_names":   strings.Join(altNames, ","),
+		},
+		Schema: pathSign(b).Fields,
+	}
+
+	parsedBundle, err := signCert(b, &dataBundle{
+		req:           req,
+		apiData:       signData,
+		role:          ac.role,
+		signingBundle: signingBundle,
+	}, false, false)
+	switch err.(type) {
+	case nil:
+	case errutil.UserError:
+		return nil, newACMEError("badCSR", http.StatusBadRequest, "%s", err)
+	default:
+		return nil, err
+	}
+
+	// Certificates issued through ACME are always stored, as they can be
+	// revoked by their account
+	serial := certutil.GetHexFormatted(parsedBundle.Certificate.SerialNumber.Bytes(), ":")
+	err = storeCert(ctx, req.Storage, parsedBundle.Certificate, ac.config.Role, signingBundle.IssuerID)
+	if err != nil {
+		return nil, errwrap.Wrapf("unable to store certificate locally: {{err}}", err)
+	}
+	err = req.Storage.Put(ctx, &logical.StorageEntry{
+		Key:   "acme/certs/" + normalizeSerial(serial),
+		Value: []byte(ar.Account.ID),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	order.Status = acmeStatusValid
+	order.CertSerial = serial
+	order.IssuerID = signingBundle.IssuerID
+	if err := acmePut(ctx, req.Storage, "acme/orders/"+order.ID, order); err != nil {
+		return nil, err
+	}
+
+	return b.acmeJSONResponse(ac, http.StatusOK, acmeOrderJSON(ac, order), ac.orderURL(order.ID))
+}
+
+func (b *backend) pathACMECertificate(ctx context.Context, req *logical.Request, data *framework.FieldData, ac *acmeContext, ar *acmeRequest) (*logical.Response, error) {
+	order, err := b.acmeAccountOrder(ctx, req.Storage, ar.Account, data.Get("order_id").(string))
+	if err != nil {
+		return nil, err
+	}
+	if order.Status != acmeStatusValid {
+		return nil, newACMEError("orderNotReady", http.StatusForbidden, "the order is %s", order.Status)
+	}
+
+	certEntry, err := req.Storage.Get(ctx, "certs/"+normalizeSerial(order.CertSerial))
+	if err != nil {
+		return nil, err
+	}
+	if certEntry == nil {
+		return nil, acmeNotFound("certificate")
+	}
+
+	// The chain is the one of the issuer that signed the certificate, unless
+	// it was deleted since
+	signingBundle, err := fetchCAInfoByRef(ctx, req, order.IssuerID)
+	if _, ok := err.(errutil.UserError); ok {
+		signingBundle, err = fetchCAInfo(ctx, req)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	chain := pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: certEntry.Value,
+	})
+	for _, block := range signingBundle.GetCAChain() {
+		chain = append(chain, pem.EncodeToMemory(&pem.Block{
+			Type:  "CERTIFICATE",
+			Bytes: block.Bytes,
+		})...)
+	}
+
+	return b.acmeResponse(ac, http.StatusOK, acmeContentTypePEM, chain)
+}
+
+func (b *backend) pathACMEAuthorization(ctx context.Context, req *logical.Request, data *framework.FieldData, ac *acmeContext, ar *acmeRequest) (*logical.Response, error) {
+	authz, err := b.acmeAccountAuthorization(ctx, req.Storage, ar.Account, data.Get("authz_id").(string))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ar.Payload) > 0 {
+		var payload struct {
+			Status string `json:"status"`
+		}
+		if err := ar.decode(&payload); err != nil {
+			return nil, err
+		}
+		if payload.Status != acmeStatusDeactivated {
+			return nil, acmeMalformed("invalid authorization status %q", payload.Status)
+		}
+		if authz.Status != acmeStatusPending && authz.Status != acmeStatusValid {
+			return nil, acmeMalformed("the authorization is %s", authz.Status)
+		}
+
+		authz.Status = acmeStatusDeactivated
+		if err := acmePut(ctx, req.Storage, "acme/authorizations/"+authz.ID, authz); err != nil {
+			return nil, err
+		}
+	}
+
+	return b.acmeJSONResponse(ac, http.StatusOK, acmeAuthorizationJSON(ac, authz), "")
+}
+
+func (b *backend) pathACMEChallenge(ctx context.Context, req *logical.Request, data *framework.FieldData, ac *acmeContext, ar *acmeRequest) (*logical.Response, error) {
+	authz, err := b.acmeAccountAuthorization(ctx, req.Storage, ar.Account, data.Get("authz_id").(string))
+	if err != nil {
+		return nil, err
+	}
+
+	var challenge *acmeChallenge
+	for _, c := range authz.Challenges {
+		if c.Type == data.Get("type").(string) {
+			challenge = c
+		}
+	}
+	if challenge == nil {
+		return nil, acmeNotFound("challenge")
+	}
+
+	// An empty payload only fetches the challenge, while an empty object asks
+	// for it to be validated. The validation happens in the background while
+	// the challenge is processing, and clients poll the authorization. A
+	// validation stopped by a seal, step-down or unmount left the challenge
+	// processing, and is started again.
+	if len(ar.Payload) > 0 && authz.Status == acmeStatusPending &&
+		(challenge.Status == acmeStatusPending || challenge.Status == acmeStatusProcessing) {
+		if challenge.Status == acmeStatusPending {
+			challenge.Status = acmeStatusProcessing
+			if err := acmePut(ctx, req.Storage, "acme/authorizations/"+authz.ID, authz); err != nil {
+				return nil, err
+			}
+		}
+
+		s, account, authzID, challengeType := req.Storage, ar.Account, authz.ID, challenge.Type
+		b.acme.startValidation(authzID, challengeType, func(ctx context.Context) {
+			b.processACMEChallenge(ctx, s, ac, account, authzID, challengeType)
+		})
+	}
+
+	resp, err := b.acmeJSONResponse(ac, http.StatusOK, acmeChallengeJSON(ac, authz, challenge), "")
+	if err != nil {
+		return nil, err
+	}
+	resp.Headers["Link"] = append(resp.Headers["Link"], fmt.Sprintf("<%s>;rel=\"up\"", ac.authorizationURL(authz.ID)))
+
+	return resp, nil
+}
+
+// processACMEChallenge validates a processing challenge of the
+// authorization, and records the result. The context isn't the one of the
+// original client request, which may go away first; once it is cancelled
+// the challenge is left processing.
+func (b *backend) processACMEChallenge(ctx context.Context, s logical.Storage, ac *acmeContext, account *acmeAccount, authzID, challengeType string) {
+	authz, err := b.acmeAuthorization(ctx, s, authzID)
+	if err != nil || authz == nil {
+		b.Logger().Error("failed to load ACME authorization", "authz_id", authzID, "error", err)
+		return
+	}
+	var challenge *acmeChallenge
+	for _, c := range authz.Challenges {
+		if c.Type == challengeType {
+			challenge = c
+		}
+	}
+	if challenge == nil {
+		return
+	}
+	validationErr := b.validateACMEChallenge(ctx, ac, account, authz, challenge)
+	if ctx.Err() != nil {
+		return
+	}
+
+	lock := locksutil.LockForKey(b.acme.locks, account.ID)
+	lock.Lock()
+	defer lock.Unlock()
+
+	// The authorization may have changed during the validation
+	authz, err = b.acmeAuthorization(ctx, s, authzID)
+	if err != nil || authz == nil {
+		b.Logger().Error("failed to load ACME authorization", "authz_id", authzID, "error", err)
+		return
+	}
+	if authz.Status != acmeStatusPending {
+		return
+	}
+	for _, c := range authz.Challenges {
+		if c.Type != challengeType || c.Status != acmeStatusProcessing {
+			continue
+		}
+		if validationErr != nil {
+			c.Status = acmeStatusInvalid
+			c.Error = validationErr
+			authz.Status = acmeStatusInvalid
+		} else {
+			c.Status = acmeStatusValid
+			c.Validated = time.Now()
+			authz.Status = acmeStatusValid
+		}
+	}
+
+	if err := acmePut(ctx, s, "acme/authorizations/"+authz.ID, authz); err != nil {
+		b.Logger().Error("failed to store ACME authorization", "authz_id", authzID, "error", err)
+	}
+}
+
+func (b *backend) pathACMERevokeCert(ctx context.Context, req *logical.Request, data *framework.FieldData, ac *acmeContext, ar *acmeRequest) (*logical.Response, error) {
+	var payload struct {
+		Certificate string `json:"certificate"`
+		Reason      int    `json:"reason"`
+	}
+	if err := ar.decode(&payload); err != nil {
+		return nil, err
+	}
+	der, err := base64.RawURLEncoding.DecodeString(payload.Certificate)
+	if err != nil {
+		return nil, acmeMalformed("invalid certificate encoding: %s", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, acmeMalformed("invalid certificate: %s", err)
+	}
+
+	serial := certutil.GetHexFormatted(cert.SerialNumber.Bytes(), ":")
+	certEntry, err := req.Storage.Get(ctx, "certs/"+normalizeSerial(serial))
+	if err != nil {
+		return nil, err
+	}
+	if certEntry == nil || !bytes.Equal(certEntry.Value, der) {
+		return nil, acmeNotFound("certificate")
+	}
+
+	// The request must be signed either by the account that ordered the
+	// certificate, or by the key of the certificate
+	authorized := false
+	if ar.Account != nil {
+		ownerEntry, err := req.Storage.Get(ctx, "acme/certs/"+normalizeSerial(serial))
+		if err != nil {
+			return nil, err
+		}
+		authorized = ownerEntry != nil && string(ownerEntry.Value) == ar.Account.ID
+	}
+	if !authorized {
+		requestKey, err := x509.MarshalPKIXPublicKey(ar.Key.Key)
+		if err != nil {
+			return nil, acmeUnauthorized("the request was not signed by the account or the key of the certificate")
+		}
+		certKey, err := x509.MarshalPKIXPublicKey(cert.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		authorized = bytes.Equal(requestKey, certKey)
+	}
+	if !authorized {
+		return nil, acmeUnauthorized("the request was not signed by the account or the key of the certificate")
+	}
+
+	b.revokeStorageLock.Lock()
+	defer b.revokeStorageLock.Unlock()
+
+	revokedEntry, err := req.Storage.Get(ctx, "revoked/"+normalizeSerial(serial))
+	if err != nil {
+		return nil, err
+	}
+	if revokedEntry != nil {
+		return nil, newACMEError("alreadyRevoked", http.StatusBadRequest, "the certificate is already revoked")
+	}
+
+	resp, err := revokeCert(ctx, b, req, serial, false)
+	if err != nil {
+		return nil, err
+	}
+	if resp != nil && resp.IsError() {
+		return nil, acmeMalformed("%s", resp.Data["error"])
+	}
+
+	return b.acmeResponse(ac, http.StatusOK, acmeContentTypeJSON, []byte{})
+}
+
+// acmeAccountOrder returns an order of the account, with its current status
+func (b *backend) acmeAccountOrder(ctx context.Context, s logical.Storage, account *acmeAccount, id string) (*acmeOrder, error) {
+	order, err := b.acmeOrder(ctx, s, id)
+	if err != nil {
+		return nil, err
+	}
+	if order == nil {
+		return nil, acmeNotFound("order")
+	}
+	if order.AccountID != account.ID {
+		return nil, acmeUnauthorized("the order belongs to another account")
+	}
+
+	if order.Status != acmeStatusPending && order.Status != acmeStatusReady {
+		return order, nil
+	}
+	if time.Now().After(order.Expires) {
+		order.Status = acmeStatusInvalid
+		return order, nil
+	}
+
+	status := acmeStatusReady
+	for _, authzID := range order.AuthorizationIDs {
+		authz, err := b.acmeAuthorization(ctx, s, authzID)
+		if err != nil {
+			return nil, err
+		}
+		if authz == nil {
+			return nil, fmt.Errorf("missing authorization %q of order %q", authzID, order.ID)
+		}
+		switch authz.Status {
+		case acmeStatusValid:
+		case acmeStatusPending:
+			status = acmeStatusPending
+		default:
+			order.Status = acmeStatusInvalid
+			return order, nil
+		}
+	}
+	order.Status = status
+
+	return order, nil
+}
+
+// acmeAccountAuthorization returns an authorization of the account, with its
+// current status
+func (b *backend) acmeAccountAuthorization(ctx context.Context, s logical.Storage, account *acmeAccount, id string) (*acmeAuthorization, error) {
+	authz, err := b.acmeAuthorization(ctx, s, id)
+	if err != nil {
+		return nil, err
+	}
+	if authz == nil {
+		return nil, acmeNotFound("authorization")
+	}
+	if authz.AccountID != account.ID {
+		return nil, acmeUnauthorized("the authorization belongs to another account")
+	}
+
+	if authz.Status == acmeStatusPending && time.Now().After(authz.Expires) {
+		authz.Status = acmeStatusExpired
+	}
+
+	return authz, nil
+}
+
+// newACMEAuthorization returns a pending authorization for the DNS name,
+// offering the challenges that can validate it
+func newACMEAuthorization(accountID, name string, expires time.Time) (*acmeAuthorization, error) {
+	id, err := acmeRandomToken()
+	if err != nil {
+		return nil, err
+	}
+
+	authz := &acmeAuthorization{
+		ID:         id,
+		AccountID:  accountID,
+		Identifier: acmeIdentifier{Type: "dns", Value: name},
+		Status:     acmeStatusPending,
+		Expires:    expires,
+	}
+
+	// Wildcard names can only be validated through DNS
+	challengeTypes := []string{acmeChallengeHTTP01, acmeChallengeDNS01}
+	if strings.HasPrefix(name, "*.") {
+		authz.Identifier.Value = strings.TrimPrefix(name, "*.")
+		authz.Wildcard = true
+		challengeTypes = []string{acmeChallengeDNS01}
+	}
+
+	for _, challengeType := range challengeTypes {
+		token, err := acmeRandomToken()
+		if err != nil {
+			return nil, err
+		}
+		authz.Challenges = append(authz.Challenges, &acmeChallenge{
+			Type:   challengeType,
+			Token:  token,
+			Status: acmeStatusPending,
+		})
+	}
+
+	return authz, nil
+}
+
+// checkACMECSRNames checks that the CSR requests exactly the identifiers of
+// the order
+func checkACMECSRNames(csr *x509.CertificateRequest, identifiers []acmeIdentifier) error {
+	if len(csr.EmailAddresses) > 0 || len(csr.IPAddresses) > 0 || len(csr.URIs) > 0 {
+		return newACMEError("badCSR", http.StatusBadRequest, "the CSR can only request DNS names")
+	}
+
+	requested := make(map[string]bool)
+	if csr.Subject.CommonName != "" {
+		requested[strings.ToLower(csr.Subject.CommonName)] = true
+	}
+	for _, name := range csr.DNSNames {
+		requested[strings.ToLower(name)] = true
+	}
+
+	ordered := make(map[string]bool)
+	for _, identifier := range identifiers {
+		ordered[identifier.Value] = true
+	}
+
+	for name := range requested {
+		if !ordered[name] {
+			return newACMEError("badCSR", http.StatusBadRequest, "the CSR requests %q, which is not in the order", name)
+		}
+	}
+	for name := range ordered {
+		if !requested[name] {
+			return newACMEError("badCSR", http.StatusBadRequest, "the CSR does not request %q", name)
+		}
+	}
+
+	return nil
+}
+
+func validateACMEContact(contact []string) error {
+	for _, c := range contact {
+		if !strings.HasPrefix(c, "mailto:") {
+			return newACMEError("unsupportedContact", http.StatusBadRequest, "unsupported contact %q, only mailto: contacts are supported", c)
+		}
+	}
+	return nil
+}
+
+func acmeAccountJSON(ac *acmeContext, account *acmeAccount) map[string]interface{} {
+	contact := account.Contact
+	if contact == nil {
+		contact = []string{}
+	}
+	return map[string]interface{}{
+		"status":  account.Status,
+		"contact": contact,
+		"orders":  ac.accountURL(account.ID) + "/orders",
+	}
+}
+
+func acmeOrderJSON(ac *acmeContext, order *acmeOrder) map[string]interface{} {
+	authorizations := []string{}
+	for _, id := range order.AuthorizationIDs {
+		authorizations = append(authorizations, ac.authorizationURL(id))
+	}
+
+	result := map[string]interface{}{
+		"status":         order.Status,
+		"expires":        order.Expires.UTC().Format(time.RFC3339),
+		"identifiers":    order.Identifiers,
+		"authorizations": authorizations,
+		"finalize":       ac.orderURL(order.ID) + "/finalize",
+	}
+	if order.Status == acmeStatusValid {
+		result["certificate"] = ac.orderURL(order.ID) + "/cert"
+	}
+	if order.Error != nil {
+		result["error"] = order.Error
+	}
+
+	return result
+}
+
+func acmeAuthorizationJSON(ac *acmeContext, authz *acmeAuthorization) map[string]interface{} {
+	challenges := []interface{}{}
+	for _, challenge := range authz.Challenges {
+		challenges = append(challenges, acmeChallengeJSON(ac, authz, challenge))
+	}
+
+	result := map[string]interface{}{
+		"identifier": authz.Identifier,
+		"status":     authz.Status,
+		"expires":    authz.Expires.UTC().Format(time.RFC3339),
+		"challenges": challenges,
+	}
+	if authz.Wildcard {
+		result["wildcard"] = true
+	}
+
+	return result
+}
+
+func acmeChallengeJSON(ac *acmeContext, authz *acmeAuthorization, challenge *acmeChallenge) map[string]interface{} {
+	result := map[string]interface{}{
+		"type":   challenge.Type,
+		"url":    ac.challengeURL(authz.ID, challenge.Type),
+		"token":  challenge.Token,
+		"status": challenge.Status,
+	}
+	if !challenge.Validated.IsZero() {
+		result["validated"] = challenge.Validated.UTC().Format(time.RFC3339)
+	}
+	if challenge.Error != nil {
+		result["error"] = challenge.Error
+	}
+
+	return result
+}
+
+const pathACMEHelpSyn = `
+ACME (RFC 8555) endpoints.
+`
+
+const pathACMEHelpDesc = `
+These endpoints implement the ACME protocol, letting standard ACME clients
+create accounts, order certificates and revoke them without a Vault token.
+The identifiers of orders are validated against the role set in
+"config/acme", and proven with the http-01 or dns-01 challenge. ACME must be
+enabled through "config/acme" first.
+`
@@ -0,0 +1,581 @@
+package pki
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/helper/certutil"
+	vaulthttp "github.com/hashicorp/vault/http"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+	"github.com/hashicorp/vault/vault"
+	"github.com/miekg/dns"
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+// acmeTestClient is a minimal ACME client
+type acmeTestClient struct {
+	t         *testing.T
+	http      *http.Client
+	key       *ecdsa.PrivateKey
+	directory map[string]string
+	account   string
+	nonce     string
+}
+
+func (c *acmeTestClient) Nonce() (string, error) {
+	if c.nonce != "" {
+		nonce := c.nonce
+		c.nonce = ""
+		return nonce, nil
+	}
+
+	resp, err := c.http.Head(c.directory["newNonce"])
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	return resp.Header.Get("Replay-Nonce"), nil
+}
+
+// post sends a JWS-signed request, with a nil payload for POST-as-GET
+// requests, and returns the response and its body
+func (c *acmeTestClient) post(url string, payload interface{}) (*http.Response, []byte) {
+	c.t.Helper()
+
+	rawPayload := []byte{}
+	if payload != nil {
+		var err error
+		if rawPayload, err = json.Marshal(payload); err != nil {
+			c.t.Fatal(err)
+		}
+	}
+
+	opts := (&jose.SignerOptions{
+		NonceSource: c,
+		EmbedJWK:    c.account == "",
+	}).WithHeader("url", url)
+	signer, err := jose.NewSigner(jose.SigningKey{
+		Algorithm: jose.ES256,
+		Key: jose.JSONWebKey{
+			Key:   c.key,
+			KeyID: c.account,
+		},
+	}, opts)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	jws, err := signer.Sign(rawPayload)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+
+	resp, err := c.http.Post(url, "application/jose+json", strings.NewReader(jws.FullSerialize()))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	c.nonce = resp.Header.Get("Replay-Nonce")
+
+	return resp, body
+}
+
+// postJSON sends a JWS-signed request and decodes its response, failing if
+// the status isn't the expected one
+func (c *acmeTestClient) postJSON(url string, payload interface{}, status int, out interface{}) *http.Response {
+	c.t.Helper()
+
+	resp, body := c.post(url, payload)
+	if resp.StatusCode != status {
+		c.t.Fatalf("bad status %d for %s, expected %d: %s", resp.StatusCode, url, status, body)
+	}
+	if out != nil {
+		if err := json.Unmarshal(body, out); err != nil {
+			c.t.Fatalf("error decoding %s: %s", body, err)
+		}
+	}
+	return resp
+}
+
+func (c *acmeTestClient) keyAuthorization(token string) string {
+	jwk := jose.JSONWebKey{Key: c.key.Public()}
+	thumbprint, err := acmeThumbprint(&jwk)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return token + "." + thumbprint
+}
+
+type acmeTestOrder struct {
+	Status         string   `json:"status"`
+	Authorizations []string `json:"authorizations"`
+	Finalize       string   `json:"finalize"`
+	Certificate    string   `json:"certificate"`
+}
+
+type acmeTestAuthorization struct {
+	Status     string `json:"status"`
+	Identifier struct {
+		Value string `json:"value"`
+	} `json:"identifier"`
+	Wildcard   bool `json:"wildcard"`
+	Challenges []struct {
+		Type   string `json:"type"`
+		URL    string `json:"url"`
+		Token  string `json:"token"`
+		Status string `json:"status"`
+	} `json:"challenges"`
+}
+
+// dnsTestServer answers TXT queries from its records
+type dnsTestServer struct {
+	l       sync.Mutex
+	records map[string][]string
+}
+
+func (s *dnsTestServer) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	m := new(dns.Msg)
+	m.SetReply(r)
+	for _, q := range r.Question {
+		if q.Qtype != dns.TypeTXT {
+			continue
+		}
+		for _, txt := range s.records[strings.ToLower(q.Name)] {
+			m.Answer = append(m.Answer, &dns.TXT{
+				Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 0},
+				Txt: []string{txt},
+			})
+		}
+	}
+	w.WriteMsg(m)
+}
+
+func (s *dnsTestServer) add(name, value string) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	s.records[dns.Fqdn(name)] = append(s.records[dns.Fqdn(name)], value)
+}
+
+func TestBackend_ACME(t *testing.T) {
+	// http-01 challenges are served by a stub responder
+	var tokensLock sync.Mutex
+	tokens := make(map[string]string)
+	httpResponder := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokensLock.Lock()
+		defer tokensLock.Unlock()
+		keyAuthorization, ok := tokens[strings.TrimPrefix(r.URL.Path, "/.well-known/acme-challenge/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, keyAuthorization)
+	}))
+	defer httpResponder.Close()
+	_, portRaw, _ := net.SplitHostPort(httpResponder.Listener.Addr().String())
+	httpPort, _ := strconv.Atoi(portRaw)
+
+	// dns-01 challenges are served by a stub DNS server
+	dnsServer := &dnsTestServer{records: make(map[string][]string)}
+	udpConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dnsStarted := make(chan struct{})
+	server := &dns.Server{
+		PacketConn:        udpConn,
+		Handler:           dnsServer,
+		NotifyStartedFunc: func() { close(dnsStarted) },
+	}
+	go server.ActivateAndServe()
+	defer server.Shutdown()
+	<-dnsStarted
+
+	coreConfig := &vault.CoreConfig{
+		LogicalBackends: map[string]logical.Factory{
+			"pki": func(ctx context.Context, conf *logical.BackendConfig) (logical.Backend, error) {
+				b := Backend(conf)
+				b.acme.http01Port = httpPort
+				if err := b.Setup(ctx, conf); err != nil {
+					return nil, err
+				}
+				return b, nil
+			},
+		},
+	}
+	cluster := vault.NewTestCluster(t, coreConfig, &vault.TestClusterOptions{
+		HandlerFunc: vaulthttp.Handler,
+	})
+	cluster.Start()
+	defer cluster.Cleanup()
+
+	client := cluster.Cores[0].Client
+	err = client.Sys().Mount("pki", &api.MountInput{
+		Type: "pki",
+		Config: api.MountConfigInput{
+			DefaultLeaseTTL: "16h",
+			MaxLeaseTTL:     "32h",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, err := client.Logical().Write("pki/root/generate/internal", map[string]interface{}{
+		"common_name": "myvault.com",
+		"ttl":         "32h",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	caPool := x509.NewCertPool()
+	caPool.AppendCertsFromPEM([]byte(secret.Data["certificate"].(string)))
+	_, err = client.Logical().Write("pki/roles/acme", map[string]interface{}{
+		"allowed_domains":    "example.com",
+		"allow_bare_domains": true,
+		"allow_subdomains":   true,
+		"allow_localhost":    true,
+		"key_type":           "ec",
+		"key_bits":           256,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// ACME is disabled by default
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(cluster.CACertPEM)
+	acmeClient := &acmeTestClient{
+		t: t,
+		http: &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{RootCAs: pool},
+			},
+		},
+	}
+	baseURL := client.Address() + "/v1/pki"
+	resp, err := acmeClient.http.Get(baseURL + "/acme/directory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("bad status for disabled ACME: %d", resp.StatusCode)
+	}
+
+	_, err = client.Logical().Write("pki/config/acme", map[string]interface{}{
+		"enabled": true,
+		"role":    "unknown",
+	})
+	if err == nil {
+		t.Fatal("expected an error enabling ACME without base_url and with an unknown role")
+	}
+	_, err = client.Logical().Write("pki/config/acme", map[string]interface{}{
+		"enabled":      true,
+		"role":         "acme",
+		"base_url":     baseURL,
+		"dns_resolver": udpConn.LocalAddr().String(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err = acmeClient.http.Get(baseURL + "/acme/directory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(&acmeClient.directory); err != nil {
+		t.Fatal(err)
+	}
+	if acmeClient.directory["newAccount"] != baseURL+"/acme/new-account" {
+		t.Fatalf("bad directory: %#v", acmeClient.directory)
+	}
+
+	// Accounts
+	if acmeClient.key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+		t.Fatal(err)
+	}
+	resp, body := acmeClient.post(acmeClient.directory["newAccount"], map[string]interface{}{
+		"onlyReturnExisting": true,
+	})
+	if resp.StatusCode != http.StatusBadRequest || !strings.Contains(string(body), "accountDoesNotExist") {
+		t.Fatalf("bad response for an unknown account: %d %s", resp.StatusCode, body)
+	}
+	resp = acmeClient.postJSON(acmeClient.directory["newAccount"], map[string]interface{}{
+		"contact":              []string{"mailto:admin@example.com"},
+		"termsOfServiceAgreed": true,
+	}, http.StatusCreated, nil)
+	acmeClient.account = resp.Header.Get("Location")
+	if !strings.HasPrefix(acmeClient.account, baseURL+"/acme/account/") {
+		t.Fatalf("bad account location %q", acmeClient.account)
+	}
+
+	// A replayed nonce is rejected
+	acmeClient.nonce = "replayed"
+	resp, body = acmeClient.post(acmeClient.account, nil)
+	if resp.StatusCode != http.StatusBadRequest || !strings.Contains(string(body), "badNonce") {
+		t.Fatalf("bad response for an invalid nonce: %d %s", resp.StatusCode, body)
+	}
+
+	// Identifiers outside of the role are rejected
+	resp, body = acmeClient.post(acmeClient.directory["newOrder"], map[string]interface{}{
+		"identifiers": []map[string]string{{"type": "dns", "value": "example.org"}},
+	})
+	if resp.StatusCode != http.StatusBadRequest || !strings.Contains(string(body), "rejectedIdentifier") {
+		t.Fatalf("bad response for a rejected identifier: %d %s", resp.StatusCode, body)
+	}
+
+	issue := func(names []string, challengeType string) *x509.Certificate {
+		t.Helper()
+
+		var identifiers []map[string]string
+		for _, name := range names {
+			identifiers = append(identifiers, map[string]string{"type": "dns", "value": name})
+		}
+		var order acmeTestOrder
+		resp := acmeClient.postJSON(acmeClient.directory["newOrder"], map[string]interface{}{
+			"identifiers": identifiers,
+		}, http.StatusCreated, &order)
+		orderURL := resp.Header.Get("Location")
+		if order.Status != acmeStatusPending || len(order.Authorizations) != len(names) {
+			t.Fatalf("bad order: %#v", order)
+		}
+
+		// The order can't be finalized before the authorizations are valid
+		resp, body := acmeClient.post(order.Finalize, map[string]interface{}{"csr": ""})
+		if resp.StatusCode != http.StatusForbidden || !strings.Contains(string(body), "orderNotReady") {
+			t.Fatalf("bad response finalizing a pending order: %d %s", resp.StatusCode, body)
+		}
+
+		for _, authzURL := range order.Authorizations {
+			var authz acmeTestAuthorization
+			acmeClient.postJSON(authzURL, nil, http.StatusOK, &authz)
+			for _, challenge := range authz.Challenges {
+				if challenge.Type != challengeType {
+					continue
+				}
+
+				keyAuthorization := acmeClient.keyAuthorization(challenge.Token)
+				switch challengeType {
+				case acmeChallengeHTTP01:
+					tokensLock.Lock()
+					tokens[challenge.Token] = keyAuthorization
+					tokensLock.Unlock()
+				case acmeChallengeDNS01:
+					digest := sha256.Sum256([]byte(keyAuthorization))
+					dnsServer.add("_acme-challenge."+authz.Identifier.Value, base64.RawURLEncoding.EncodeToString(digest[:]))
+				}
+
+				var result struct {
+					Status string `json:"status"`
+				}
+				acmeClient.postJSON(challenge.URL, map[string]interface{}{}, http.StatusOK, &result)
+				if result.Status != acmeStatusProcessing && result.Status != acmeStatusValid {
+					t.Fatalf("challenge %s of %s is %s", challengeType, authz.Identifier.Value, result.Status)
+				}
+			}
+
+			// The challenge is validated in the background
+			for i := 0; authz.Status == acmeStatusPending && i < 50; i++ {
+				time.Sleep(100 * time.Millisecond)
+				acmeClient.postJSON(authzURL, nil, http.StatusOK, &authz)
+			}
+			if authz.Status != acmeStatusValid {
+				t.Fatalf("authorization of %s is %s", authz.Identifier.Value, authz.Status)
+			}
+		}
+
+		acmeClient.postJSON(orderURL, nil, http.StatusOK, &order)
+		if order.Status != acmeStatusReady {
+			t.Fatalf("bad order status %q", order.Status)
+		}
+
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+			Subject:  pkix.Name{CommonName: names[0]},
+			DNSNames: names,
+		}, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		acmeClient.postJSON(order.Finalize, map[string]interface{}{
+			"csr": base64.RawURLEncoding.EncodeToString(csr),
+		}, http.StatusOK, &order)
+		if order.Status != acmeStatusValid || order.Certificate == "" {
+			t.Fatalf("bad finalized order: %#v", order)
+		}
+
+		resp, body = acmeClient.post(order.Certificate, nil)
+		if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != acmeContentTypePEM {
+			t.Fatalf("bad certificate response: %d %s", resp.StatusCode, body)
+		}
+		block, _ := pem.Decode(body)
+		if block == nil {
+			t.Fatalf("bad certificate chain: %s", body)
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range names {
+			_, err := cert.Verify(x509.VerifyOptions{
+				DNSName: strings.Replace(name, "*", "www", 1),
+				Roots:   caPool,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		return cert
+	}
+
+	httpCert := issue([]string{"localhost"}, acmeChallengeHTTP01)
+	dnsCert := issue([]string{"example.com", "*.example.com"}, acmeChallengeDNS01)
+
+	// The certificates are in the cert store
+	serial := certutil.GetHexFormatted(httpCert.SerialNumber.Bytes(), ":")
+	secret, err = client.Logical().Read("pki/cert/" + serial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret == nil || secret.Data["certificate"] == "" {
+		t.Fatalf("certificate %s isn't stored", serial)
+	}
+
+	// Certificates can be revoked through ACME and the API
+	acmeClient.postJSON(acmeClient.directory["revokeCert"], map[string]interface{}{
+		"certificate": base64.RawURLEncoding.EncodeToString(httpCert.Raw),
+	}, http.StatusOK, nil)
+	resp, body = acmeClient.post(acmeClient.directory["revokeCert"], map[string]interface{}{
+		"certificate": base64.RawURLEncoding.EncodeToString(httpCert.Raw),
+	})
+	if resp.StatusCode != http.StatusBadRequest || !strings.Contains(string(body), "alreadyRevoked") {
+		t.Fatalf("bad response revoking a revoked certificate: %d %s", resp.StatusCode, body)
+	}
+	_, err = client.Logical().Write("pki/revoke", map[string]interface{}{
+		"serial_number": certutil.GetHexFormatted(dnsCert.SerialNumber.Bytes(), ":"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secret, err = client.Logical().Read("pki/cert/crl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	crl, err := x509.ParseCRL([]byte(secret.Data["certificate"].(string)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	revoked := make(map[string]bool)
+	for _, cert := range crl.TBSCertList.RevokedCertificates {
+		revoked[cert.SerialNumber.String()] = true
+	}
+	if !revoked[httpCert.SerialNumber.String()] || !revoked[dnsCert.SerialNumber.String()] {
+		t.Fatalf("the certificates aren't in the CRL: %#v", revoked)
+	}
+
+	// Deactivated accounts can't be used anymore
+	acmeClient.postJSON(acmeClient.account, map[string]interface{}{
+		"status": acmeStatusDeactivated,
+	}, http.StatusOK, nil)
+	resp, body = acmeClient.post(acmeClient.directory["newOrder"], map[string]interface{}{
+		"identifiers": []map[string]string{{"type": "dns", "value": "localhost"}},
+	})
+	if resp.StatusCode != http.StatusForbidden || !strings.Contains(string(body), "unauthorized") {
+		t.Fatalf("bad response for a deactivated account: %d %s", resp.StatusCode, body)
+	}
+}
+
+func TestBackend_ACMERouting(t *testing.T) {
+	b, _ := createBackendWithStorage(t)
+
+	// Random IDs may start or end with a hyphen
+	for path, expected := range map[string]string{
+		"acme/account/-abc":            "acme/account/" + acmeIDRegex("account_id"),
+		"acme/account/abc-/orders":     "acme/account/" + acmeIDRegex("account_id") + "/orders",
+		"acme/order/-abc-":             "acme/order/" + acmeIDRegex("order_id"),
+		"acme/order/-abc/finalize":     "acme/order/" + acmeIDRegex("order_id") + "/finalize",
+		"acme/order/abc-/cert":         "acme/order/" + acmeIDRegex("order_id") + "/cert",
+		"acme/authorization/-a_b-":     "acme/authorization/" + acmeIDRegex("authz_id"),
+		"acme/challenge/-abc-/http-01": "acme/challenge/" + acmeIDRegex("authz_id") + "/" + framework.GenericNameRegex("type"),
+	} {
+		p := b.Route(path)
+		if p == nil {
+			t.Fatalf("no route for %q", path)
+		}
+		if p.Pattern != "^"+expected+"$" {
+			t.Fatalf("bad route for %q: %s", path, p.Pattern)
+		}
+	}
+}
+
+func TestBackend_ACMECleanupStopsValidations(t *testing.T) {
+	b, _ := createBackendWithStorage(t)
+
+	started := make(chan struct{})
+	stopped := make(chan struct{})
+	if !b.acme.startValidation("authz", acmeChallengeHTTP01, func(ctx context.Context) {
+		close(started)
+		<-ctx.Done()
+		close(stopped)
+	}) {
+		t.Fatal("validation wasn't started")
+	}
+	<-started
+
+	// A challenge is validated once at a time
+	if b.acme.startValidation("authz", acmeChallengeHTTP01, func(context.Context) {}) {
+		t.Fatal("validation was started twice")
+	}
+
+	cleanedUp := make(chan struct{})
+	go func() {
+		b.Cleanup(context.Background())
+		close(cleanedUp)
+	}()
+	select {
+	case <-cleanedUp:
+	case <-time.After(5 * time.Second):
+		t.Fatal("cleanup didn't stop the validation")
+	}
+	select {
+	case <-stopped:
+	default:
+		t.Fatal("cleanup returned before the validation")
+	}
+
+	if b.acme.startValidation("authz", acmeChallengeDNS01, func(context.Context) {}) {
+		t.Fatal("validation was started after cleanup")
+	}
+}
@@ -0,0 +1,219 @@
+package pki
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+const (
+	defaultCertSearchLimit = 100
+	maxCertSearchLimit     = 1000
+)
+
+func addCertSearchPagingFields(fields map[string]*framework.FieldSchema) map[string]*framework.FieldSchema {
+	fields["after"] = &framework.FieldSchema{
+		Type: framework.TypeString,
+		Description: `Only return certificates whose serial number sorts
+after this one; set to the "next_after" value of the previous page.`,
+	}
+
+	fields["limit"] = &framework.FieldSchema{
+		Type: framework.TypeInt,
+		Description: fmt.Sprintf(`The maximum number of certificates to return;
+defaults to %d, and can't exceed %d.`, defaultCertSearchLimit, maxCertSearchLimit),
+		Default: defaultCertSearchLimit,
+	}
+
+	return fields
+}
+
+func pathSearchCerts(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "certs/search",
+		Fields: addCertSearchPagingFields(map[string]*framework.FieldSchema{
+			"common_name": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `Only return certificates with this common name.
+Matching is case-insensitive, and "*" matches any sequence of characters.`,
+			},
+
+			"alt_name": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `Only return certificates with a DNS, email, IP or
+URI subject alternative name matching this one. Matching is case-insensitive,
+and "*" matches any sequence of characters.`,
+			},
+
+			"role": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: `Only return certificates issued from this role.`,
+			},
+
+			"issuer_ref": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `Only return certificates signed by this issuer,
+referenced by ID or name.`,
+			},
+
+			"not_after_start": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `Only return certificates expiring at or after this
+RFC 3339 time.`,
+			},
+
+			"not_after_end": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `Only return certificates expiring at or before
+this RFC 3339 time.`,
+			},
+
+			"revoked": &framework.FieldSchema{
+				Type: framework.TypeBool,
+				Description: `If set, only return revoked certificates when true,
+or certificates which weren't revoked when false.`,
+			},
+		}),
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation: b.pathSearchCertsRead,
+		},
+
+		HelpSynopsis:    pathSearchCertsHelpSyn,
+		HelpDescription: pathSearchCertsHelpDesc,
+	}
+}
+
+func pathExpiringCerts(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "certs/expiring",
+		Fields: addCertSearchPagingFields(map[string]*framework.FieldSchema{
+			"within": &framework.FieldSchema{
+				Type: framework.TypeDurationSecond,
+				Description: `Return the certificates expiring within this
+duration from now; defaults to 30 days.`,
+				Default: 2592000, // 30 days
+			},
+
+			"include_revoked": &framework.FieldSchema{
+				Type:        framework.TypeBool,
+				Description: `If set to true, also return revoked certificates.`,
+			},
+		}),
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation: b.pathExpiringCertsRead,
+		},
+
+		HelpSynopsis:    pathExpiringCertsHelpSyn,
+		HelpDescription: pathExpiringCertsHelpDesc,
+	}
+}
+
+func (b *backend) pathSearchCertsRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	filter := &certFilter{
+		CommonName: data.Get("common_name").(string),
+		AltName:    data.Get("alt_name").(string),
+		Role:       data.Get("role").(string),
+	}
+
+	if issuerRef := data.Get("issuer_ref").(string); issuerRef != "" {
+		issuerID, err := resolveIssuerRef(ctx, req.Storage, issuerRef)
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+		filter.IssuerID = issuerID
+	}
+
+	for field, t := range map[string]*time.Time{
+		"not_after_start": &filter.NotAfterStart,
+		"not_after_end":   &filter.NotAfterEnd,
+	} {
+		if value := data.Get(field).(string); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return logical.ErrorResponse(fmt.Sprintf("%s could not be parsed as an RFC 3339 time: %s", field, err)), nil
+			}
+			*t = parsed
+		}
+	}
+
+	if revokedRaw, ok := data.GetOk("revoked"); ok {
+		revoked := revokedRaw.(bool)
+		filter.Revoked = &revoked
+	}
+
+	return b.certSearchResponse(ctx, req, data, filter)
+}
+
+func (b *backend) pathExpiringCertsRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	within := data.Get("within").(int)
+	if within < 0 {
+		return logical.ErrorResponse("within must not be negative"), nil
+	}
+
+	now := time.Now()
+	filter := &certFilter{
+		NotAfterStart: now,
+		NotAfterEnd:   now.Add(time.Duration(within) * time.Second),
+	}
+	if !data.Get("include_revoked").(bool) {
+		filter.Revoked = new(bool)
+	}
+
+	return b.certSearchResponse(ctx, req, data, filter)
+}
+
+func (b *backend) certSearchResponse(ctx context.Context, req *logical.Request, data *framework.FieldData, filter *certFilter) (*logical.Response, error) {
+	limit := data.Get("limit").(int)
+	if limit < 1 || limit > maxCertSearchLimit {
+		return logical.ErrorResponse(fmt.Sprintf("limit must be between 1 and %d", maxCertSearchLimit)), nil
+	}
+
+	results, next, err := b.searchCerts(ctx, req.Storage, filter, data.Get("after").(string), limit)
+	if err != nil {
+		return nil, err
+	}
+
+	certs := make([]map[string]interface{}, 0, len(results))
+	for _, meta := range results {
+		certs = append(certs, meta.responseData())
+	}
+
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"certificates": certs,
+		},
+	}
+	if next != "" {
+		resp.Data["next_after"] = next
+	}
+	return resp, nil
+}
+
+const pathSearchCertsHelpSyn = `
+Search the stored certificates.
+`
+
+const pathSearchCertsHelpDesc = `
+This endpoint returns the metadata of the stored certificates matching all the
+given filters: their serial number, common name, subject alternative names,
+role, issuer, validity period and revocation time. The role of certificates
+stored before certificates were indexed is unknown.
+
+Results are sorted by serial number and paged; when there are more, the
+response holds the "next_after" value to pass as "after" for the next page.
+`
+
+const pathExpiringCertsHelpSyn = `
+List the stored certificates expiring soon.
+`
+
+const pathExpiringCertsHelpDesc = `
+This endpoint returns the metadata of the stored certificates which expire
+within the given duration, excluding revoked certificates unless
+"include_revoked" is set. Results are paged like those of "certs/search".
+`
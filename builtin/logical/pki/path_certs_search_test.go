@@ -0,0 +1,183 @@
+package pki
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+func certsSearchTestSerials(t *testing.T, resp *logical.Response) []string {
+	t.Helper()
+
+	var serials []string
+	for _, cert := range resp.Data["certificates"].([]map[string]interface{}) {
+		serials = append(serials, cert["serial_number"].(string))
+	}
+	sort.Strings(serials)
+	return serials
+}
+
+func certsSearchTestEqual(t *testing.T, resp *logical.Response, expected ...string) {
+	t.Helper()
+
+	sort.Strings(expected)
+	serials := certsSearchTestSerials(t, resp)
+	if len(serials) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, serials)
+	}
+	for i := range serials {
+		if serials[i] != expected[i] {
+			t.Fatalf("expected %v, got %v", expected, serials)
+		}
+	}
+}
+
+func TestPki_CertsSearch(t *testing.T) {
+	b, s := createBackendWithStorage(t)
+
+	resp := issuersTestRequest(t, b, s, logical.UpdateOperation, "root/generate/internal", map[string]interface{}{
+		"common_name": "Root",
+		"ttl":         "40h",
+		"issuer_name": "root-a",
+	})
+	rootSerial := resp.Data["serial_number"].(string)
+	resp = issuersTestRequest(t, b, s, logical.UpdateOperation, "root/rotate/internal", map[string]interface{}{
+		"common_name": "Root B",
+		"ttl":         "40h",
+		"issuer_name": "root-b",
+	})
+	rootBSerial := resp.Data["serial_number"].(string)
+
+	issuersTestRequest(t, b, s, logical.UpdateOperation, "roles/web", map[string]interface{}{
+		"allowed_domains":  "example.com",
+		"allow_subdomains": true,
+		"max_ttl":          "30h",
+	})
+	issuersTestRequest(t, b, s, logical.UpdateOperation, "roles/internal", map[string]interface{}{
+		"allowed_domains":  "internal.net",
+		"allow_subdomains": true,
+		"issuer_ref":       "root-b",
+		"max_ttl":          "30h",
+	})
+
+	issue := func(role, commonName, altNames, ttl string) string {
+		t.Helper()
+
+		resp := issuersTestRequest(t, b, s, logical.UpdateOperation, "issue/"+role, map[string]interface{}{
+			"common_name": commonName,
+			"alt_names":   altNames,
+			"ttl":         ttl,
+		})
+		return resp.Data["serial_number"].(string)
+	}
+	www := issue("web", "www.example.com", "cdn.example.com", "1h")
+	api := issue("web", "api.example.com", "", "24h")
+	db := issue("internal", "db.internal.net", "", "2h")
+
+	search := func(data map[string]interface{}) *logical.Response {
+		t.Helper()
+		return issuersTestRequest(t, b, s, logical.ReadOperation, "certs/search", data)
+	}
+
+	certsSearchTestEqual(t, search(nil), rootSerial, rootBSerial, www, api, db)
+	certsSearchTestEqual(t, search(map[string]interface{}{"common_name": "*.EXAMPLE.com"}), www, api)
+	certsSearchTestEqual(t, search(map[string]interface{}{"alt_name": "cdn.*"}), www)
+	certsSearchTestEqual(t, search(map[string]interface{}{"role": "internal"}), db)
+	certsSearchTestEqual(t, search(map[string]interface{}{"issuer_ref": "root-b"}), rootBSerial, db)
+	certsSearchTestEqual(t, search(map[string]interface{}{
+		"not_after_end": time.Now().Add(3 * time.Hour).Format(time.RFC3339),
+	}), www, db)
+
+	// Metadata is parsed from the certificate
+	resp = search(map[string]interface{}{"common_name": "www.example.com"})
+	meta := resp.Data["certificates"].([]map[string]interface{})[0]
+	altNames := meta["alt_names"].([]string)
+	if meta["role"] != "web" || meta["is_ca"] != false || meta["revocation_time"] != int64(0) || len(altNames) != 2 {
+		t.Fatalf("bad: %#v", meta)
+	}
+	if notAfter := time.Unix(meta["not_after"].(int64), 0); notAfter.Before(time.Now()) || notAfter.After(time.Now().Add(time.Hour)) {
+		t.Fatalf("bad: not_after %s", notAfter)
+	}
+
+	// Revocations are indexed
+	issuersTestRequest(t, b, s, logical.UpdateOperation, "revoke", map[string]interface{}{
+		"serial_number": api,
+	})
+	certsSearchTestEqual(t, search(map[string]interface{}{"revoked": true}), api)
+	certsSearchTestEqual(t, search(map[string]interface{}{"revoked": false, "role": "web"}), www)
+
+	// Results are paged
+	var paged []string
+	var after string
+	for i := 0; i < 5; i++ {
+		resp = search(map[string]interface{}{"limit": 2, "after": after})
+		paged = append(paged, certsSearchTestSerials(t, resp)...)
+		next, ok := resp.Data["next_after"].(string)
+		if !ok {
+			break
+		}
+		after = next
+	}
+	if len(paged) != 5 {
+		t.Fatalf("bad: paged results %v", paged)
+	}
+
+	// Expiring certificates exclude the revoked ones by default
+	resp = issuersTestRequest(t, b, s, logical.ReadOperation, "certs/expiring", map[string]interface{}{
+		"within": "30h",
+	})
+	certsSearchTestEqual(t, resp, www, db)
+	resp = issuersTestRequest(t, b, s, logical.ReadOperation, "certs/expiring", map[string]interface{}{
+		"within":          "30h",
+		"include_revoked": true,
+	})
+	certsSearchTestEqual(t, resp, www, api, db)
+	resp = issuersTestRequest(t, b, s, logical.ReadOperation, "certs/expiring", map[string]interface{}{
+		"within": "90m",
+	})
+	certsSearchTestEqual(t, resp, www)
+}
+
+func TestPki_CertsSearchLegacyIndex(t *testing.T) {
+	b, s := createBackendWithStorage(t)
+	ctx := context.Background()
+
+	resp := issuersTestRequest(t, b, s, logical.UpdateOperation, "root/generate/internal", map[string]interface{}{
+		"common_name": "Root",
+		"ttl":         "40h",
+	})
+	rootSerial := resp.Data["serial_number"].(string)
+	issuersTestRequest(t, b, s, logical.UpdateOperation, "roles/web", map[string]interface{}{
+		"allowed_domains":  "example.com",
+		"allow_subdomains": true,
+	})
+	resp = issuersTestRequest(t, b, s, logical.UpdateOperation, "issue/web", map[string]interface{}{
+		"common_name": "www.example.com",
+	})
+	serial := resp.Data["serial_number"].(string)
+	issuersTestRequest(t, b, s, logical.UpdateOperation, "revoke", map[string]interface{}{
+		"serial_number": serial,
+	})
+
+	// Simulate certificates stored before they were indexed
+	for _, key := range []string{"cert-metadata/" + normalizeSerial(rootSerial), "cert-metadata/" + normalizeSerial(serial)} {
+		if err := s.Delete(ctx, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	resp = issuersTestRequest(t, b, s, logical.ReadOperation, "certs/search", map[string]interface{}{
+		"common_name": "www.example.com",
+	})
+	certsSearchTestEqual(t, resp, serial)
+	meta := resp.Data["certificates"].([]map[string]interface{})[0]
+	issuers := issuersTestRequest(t, b, s, logical.ListOperation, "issuers", nil)
+	if meta["role"] != "" || meta["issuer_id"] != issuers.Data["keys"].([]string)[0] || meta["revocation_time"] == int64(0) {
+		t.Fatalf("bad: %#v", meta)
+	}
+
+	certsSearchTestEqual(t, issuersTestRequest(t, b, s, logical.ReadOperation, "certs/search", nil), rootSerial, serial)
+}
@@ -0,0 +1,164 @@
+package pki
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/asaskevich/govalidator"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+// acmeConfig holds the configuration of the ACME server
+type acmeConfig struct {
+	Enabled     bool   `json:"enabled"`
+	Role        string `json:"role"`
+	BaseURL     string `json:"base_url"`
+	DNSResolver string `json:"dns_resolver"`
+}
+
+func pathConfigACME(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "config/acme",
+		Fields: map[string]*framework.FieldSchema{
+			"enabled": &framework.FieldSchema{
+				Type:        framework.TypeBool,
+				Description: `If set to true, enables the ACME endpoints.`,
+			},
+
+			"role": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `The role used to validate the identifiers of
+ACME orders and to sign their certificates.`,
+			},
+
+			"base_url": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `The URL of this mount as seen by ACME clients,
+for instance "https://vault.example.com:8200/v1/pki". It is used to build
+the URLs of the ACME resources.`,
+			},
+
+			"dns_resolver": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `The address, as host:port, of the DNS resolver
+used to validate dns-01 challenges. The system resolver is used if empty.`,
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation:   b.pathACMEConfigRead,
+			logical.UpdateOperation: b.pathACMEConfigWrite,
+		},
+
+		HelpSynopsis:    pathConfigACMEHelpSyn,
+		HelpDescription: pathConfigACMEHelpDesc,
+	}
+}
+
+func (b *backend) ACMEConfig(ctx context.Context, s logical.Storage) (*acmeConfig, error) {
+	entry, err := s.Get(ctx, "config/acme")
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	var result acmeConfig
+	if err := entry.DecodeJSON(&result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (b *backend) pathACMEConfigRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	config, err := b.ACMEConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		return nil, nil
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"enabled":      config.Enabled,
+			"role":         config.Role,
+			"base_url":     config.BaseURL,
+			"dns_resolver": config.DNSResolver,
+		},
+	}, nil
+}
+
+func (b *backend) pathACMEConfigWrite(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	config, err := b.ACMEConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		config = &acmeConfig{}
+	}
+
+	if enabledRaw, ok := d.GetOk("enabled"); ok {
+		config.Enabled = enabledRaw.(bool)
+	}
+	if roleRaw, ok := d.GetOk("role"); ok {
+		config.Role = roleRaw.(string)
+	}
+	if baseURLRaw, ok := d.GetOk("base_url"); ok {
+		config.BaseURL = strings.TrimSuffix(baseURLRaw.(string), "/")
+	}
+	if resolverRaw, ok := d.GetOk("dns_resolver"); ok {
+		config.DNSResolver = resolverRaw.(string)
+	}
+
+	if config.BaseURL != "" && !govalidator.IsURL(config.BaseURL) {
+		return logical.ErrorResponse(fmt.Sprintf("invalid base_url %q", config.BaseURL)), nil
+	}
+	if config.DNSResolver != "" {
+		if _, _, err := net.SplitHostPort(config.DNSResolver); err != nil {
+			return logical.ErrorResponse(fmt.Sprintf("invalid dns_resolver %q: %s", config.DNSResolver, err)), nil
+		}
+	}
+
+	if config.Enabled {
+		if config.BaseURL == "" {
+			return logical.ErrorResponse("base_url is required to enable ACME"), nil
+		}
+		if config.Role == "" {
+			return logical.ErrorResponse("role is required to enable ACME"), nil
+		}
+		role, err := b.getRole(ctx, req.Storage, config.Role)
+		if err != nil {
+			return nil, err
+		}
+		if role == nil {
+			return logical.ErrorResponse(fmt.Sprintf("unknown role: %s", config.Role)), nil
+		}
+	}
+
+	entry, err := logical.StorageEntryJSON("config/acme", config)
+	if err != nil {
+		return nil, err
+	}
+	if err := req.Storage.Put(ctx, entry); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+const pathConfigACMEHelpSyn = `
+Configure the ACME server.
+`
+
+const pathConfigACMEHelpDesc = `
+This endpoint enables the ACME (RFC 8555) endpoints under "acme/", which
+let standard ACME clients obtain certificates without a Vault token.
+Certificates are signed with the given role, after the identifiers of the
+order were validated with the http-01 or dns-01 challenge.
+`
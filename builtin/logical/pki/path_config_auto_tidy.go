@@ -0,0 +1,178 @@
+package pki
+
+import (
+	"context"
+	"time"
+
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+const defaultAutoTidyInterval = 12 * time.Hour
+
+// autoTidyConfig holds the configuration of the tidy operations run in the
+// background every Interval
+type autoTidyConfig struct {
+	Enabled          bool          `json:"enabled"`
+	Interval         time.Duration `json:"interval"`
+	TidyCertStore    bool          `json:"tidy_cert_store"`
+	TidyRevokedCerts bool          `json:"tidy_revoked_certs"`
+	SafetyBuffer     time.Duration `json:"safety_buffer"`
+}
+
+func pathConfigAutoTidy(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "config/auto-tidy",
+		Fields: map[string]*framework.FieldSchema{
+			"enabled": &framework.FieldSchema{
+				Type:        framework.TypeBool,
+				Description: `If set to true, enables tidying up in the background.`,
+			},
+
+			"interval": &framework.FieldSchema{
+				Type: framework.TypeDurationSecond,
+				Description: `The amount of time between tidy operations;
+defaults to 12 hours.`,
+				Default: int(defaultAutoTidyInterval.Seconds()),
+			},
+
+			"tidy_cert_store": &framework.FieldSchema{
+				Type: framework.TypeBool,
+				Description: `Set to true to enable tidying up
+the certificate store`,
+			},
+
+			"tidy_revocation_list": &framework.FieldSchema{
+				Type:        framework.TypeBool,
+				Description: `Deprecated; synonym for 'tidy_revoked_certs`,
+			},
+
+			"tidy_revoked_certs": &framework.FieldSchema{
+				Type: framework.TypeBool,
+				Description: `Set to true to expire all revoked
+certificates, even if their duration has not yet passed, removing
+them both from the CRL and from storage. The CRL will be rotated
+if this causes any values to be removed.`,
+			},
+
+			"safety_buffer": &framework.FieldSchema{
+				Type: framework.TypeDurationSecond,
+				Description: `The amount of extra time that must have passed
+beyond certificate expiration before it is removed
+from the backend storage and/or revocation list.
+Defaults to 72 hours.`,
+				Default: int(defaultTidySafetyBuffer.Seconds()),
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation:   b.pathAutoTidyConfigRead,
+			logical.UpdateOperation: b.pathAutoTidyConfigWrite,
+		},
+
+		HelpSynopsis:    pathConfigAutoTidyHelpSyn,
+		HelpDescription: pathConfigAutoTidyHelpDesc,
+	}
+}
+
+func (b *backend) autoTidyConfig(ctx context.Context, s logical.Storage) (*autoTidyConfig, error) {
+	entry, err := s.Get(ctx, "config/auto-tidy")
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	var result autoTidyConfig
+	if err := entry.DecodeJSON(&result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (b *backend) pathAutoTidyConfigRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	config, err := b.autoTidyConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		config = &autoTidyConfig{
+			Interval:     defaultAutoTidyInterval,
+			SafetyBuffer: defaultTidySafetyBuffer,
+		}
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"enabled":            config.Enabled,
+			"interval":           int64(config.Interval.Seconds()),
+			"tidy_cert_store":    config.TidyCertStore,
+			"tidy_revoked_certs": config.TidyRevokedCerts,
+			"safety_buffer":      int64(config.SafetyBuffer.Seconds()),
+		},
+	}, nil
+}
+
+func (b *backend) pathAutoTidyConfigWrite(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	config, err := b.autoTidyConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		config = &autoTidyConfig{
+			Interval:     defaultAutoTidyInterval,
+			SafetyBuffer: defaultTidySafetyBuffer,
+		}
+	}
+
+	if enabledRaw, ok := d.GetOk("enabled"); ok {
+		config.Enabled = enabledRaw.(bool)
+	}
+	if intervalRaw, ok := d.GetOk("interval"); ok {
+		config.Interval = time.Duration(intervalRaw.(int)) * time.Second
+	}
+	if tidyCertStoreRaw, ok := d.GetOk("tidy_cert_store"); ok {
+		config.TidyCertStore = tidyCertStoreRaw.(bool)
+	}
+	if tidyRevokedCertsRaw, ok := d.GetOk("tidy_revoked_certs"); ok {
+		config.TidyRevokedCerts = tidyRevokedCertsRaw.(bool)
+	}
+	if tidyRevocationListRaw, ok := d.GetOk("tidy_revocation_list"); ok {
+		config.TidyRevokedCerts = tidyRevocationListRaw.(bool)
+	}
+	if safetyBufferRaw, ok := d.GetOk("safety_buffer"); ok {
+		config.SafetyBuffer = time.Duration(safetyBufferRaw.(int)) * time.Second
+	}
+
+	if config.Interval <= 0 {
+		return logical.ErrorResponse("interval must be greater than zero"), nil
+	}
+	if config.SafetyBuffer <= 0 {
+		return logical.ErrorResponse("safety_buffer must be greater than zero"), nil
+	}
+	if config.Enabled && !config.TidyCertStore && !config.TidyRevokedCerts {
+		return logical.ErrorResponse("tidy_cert_store or tidy_revoked_certs is required to enable auto-tidy"), nil
+	}
+
+	entry, err := logical.StorageEntryJSON("config/auto-tidy", config)
+	if err != nil {
+		return nil, err
+	}
+	if err := req.Storage.Put(ctx, entry); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+const pathConfigAutoTidyHelpSyn = `
+Configure tidying up in the background.
+`
+
+const pathConfigAutoTidyHelpDesc = `
+This endpoint enables running the tidy operation in the background every
+'interval', with the same parameters as the 'tidy' endpoint. Only the active
+node runs it; the 'tidy-status' endpoint reports the last tidy operation.
+`
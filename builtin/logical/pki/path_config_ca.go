@@ -2,6 +2,7 @@ package pki
 
 import (
 	"context"
+	"fmt"
 
 	"github.com/hashicorp/errwrap"
 	"github.com/hashicorp/vault/helper/certutil"
@@ -11,7 +12,7 @@ import (
 )
 
 func pathConfigCA(b *backend) *framework.Path {
-	return &framework.Path{
+	ret := &framework.Path{
 		Pattern: "config/ca",
 		Fields: map[string]*framework.FieldSchema{
 			"pem_bundle": &framework.FieldSchema{
@@ -28,6 +29,10 @@ secret key and certificate.`,
 		HelpSynopsis:    pathConfigCAHelpSyn,
 		HelpDescription: pathConfigCAHelpDesc,
 	}
+
+	ret.Fields = addIssuerNameField(ret.Fields)
+
+	return ret
 }
 
 func (b *backend) pathCAWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
@@ -37,6 +42,11 @@ func (b *backend) pathCAWrite(ctx context.Context, req *logical.Request, data *f
 		return logical.ErrorResponse("'pem_bundle' was empty"), nil
 	}
 
+	issuerName := data.Get("issuer_name").(string)
+	if err := validateIssuerName(ctx, req.Storage, issuerName, ""); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
 	parsedBundle, err := certutil.ParsePEMBundle(pemBundle)
 	if err != nil {
 		switch err.(type) {
@@ -60,32 +70,44 @@ func (b *backend) pathCAWrite(ctx context.Context, req *logical.Request, data *f
 		return logical.ErrorResponse("the given certificate is not marked for CA use and cannot be used with this backend"), nil
 	}
 
+	// Make sure the key is the one of the certificate before storing them,
+	// since the mismatch would only show when signing
+	equal, err := certutil.ComparePublicKeys(parsedBundle.Certificate.PublicKey, parsedBundle.PrivateKey.Public())
+	if err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("could not compare the private key with the certificate: %v", err)), nil
+	}
+	if !equal {
+		return logical.ErrorResponse("the private key does not match the public key of the certificate"), nil
+	}
+
 	cb, err := parsedBundle.ToCertBundle()
 	if err != nil {
 		return nil, errwrap.Wrapf("error converting raw values into cert bundle: {{err}}", err)
 	}
 
-	entry, err := logical.StorageEntryJSON("config/ca_bundle", cb)
+	issuer, err := storeIssuer(ctx, req.Storage, cb, issuerName)
 	if err != nil {
 		return nil, err
 	}
-	err = req.Storage.Put(ctx, entry)
+
+	// Also store it as just the certificate identified by serial number, so
+	// it can be revoked by the issuer that signed it
+	signerID, err := certIssuerID(ctx, req.Storage, parsedBundle.Certificate)
 	if err != nil {
 		return nil, err
 	}
-
-	// For ease of later use, also store just the certificate at a known
-	// location, plus a fresh CRL
-	entry.Key = "ca"
-	entry.Value = parsedBundle.CertificateBytes
-	err = req.Storage.Put(ctx, entry)
+	err = storeCert(ctx, req.Storage, parsedBundle.Certificate, "", signerID)
 	if err != nil {
 		return nil, err
 	}
 
+	// Build a fresh CRL
 	err = buildCRL(ctx, b, req, true)
+	if err != nil {
+		return nil, err
+	}
 
-	return nil, err
+	return nonDefaultIssuerResponse(ctx, req, issuer)
 }
 
 const pathConfigCAHelpSyn = `
@@ -93,9 +115,10 @@ Set the CA certificate and private key used for generated credentials.
 `
 
 const pathConfigCAHelpDesc = `
-This sets the CA information used for credentials generated by this
-by this mount. This must be a PEM-format, concatenated unencrypted
-secret key and certificate.
+This adds the CA information used for credentials generated by this
+by this mount as a new issuer. This must be a PEM-format, concatenated
+unencrypted secret key and certificate; the key must match the
+certificate. The first issuer of the mount becomes its default issuer.
 
 For security reasons, the secret key cannot be retrieved later.
 `
@@ -13,8 +13,34 @@ import (
 
 // CRLConfig holds basic CRL configuration information
 type crlConfig struct {
-	Expiry  string `json:"expiry" mapstructure:"expiry"`
-	Disable bool   `json:"disable"`
+	Expiry                 string `json:"expiry" mapstructure:"expiry"`
+	Disable                bool   `json:"disable"`
+	AutoRebuild            bool   `json:"auto_rebuild"`
+	AutoRebuildGracePeriod string `json:"auto_rebuild_grace_period"`
+	EnableDelta            bool   `json:"enable_delta"`
+	DeltaRebuildInterval   string `json:"delta_rebuild_interval"`
+}
+
+const (
+	defaultCRLAutoRebuildGracePeriod = "12h"
+	defaultDeltaCRLRebuildInterval   = "15m"
+)
+
+// autoRebuildGracePeriod returns how long before their expiry the CRLs are
+// rebuilt automatically
+func (c *crlConfig) autoRebuildGracePeriod() (time.Duration, error) {
+	if c.AutoRebuildGracePeriod == "" {
+		return time.ParseDuration(defaultCRLAutoRebuildGracePeriod)
+	}
+	return time.ParseDuration(c.AutoRebuildGracePeriod)
+}
+
+// deltaRebuildInterval returns the minimum time between delta CRL builds
+func (c *crlConfig) deltaRebuildInterval() (time.Duration, error) {
+	if c.DeltaRebuildInterval == "" {
+		return time.ParseDuration(defaultDeltaCRLRebuildInterval)
+	}
+	return time.ParseDuration(c.DeltaRebuildInterval)
 }
 
 func pathConfigCRL(b *backend) *framework.Path {
@@ -31,6 +57,29 @@ valid; defaults to 72 hours`,
 				Type:        framework.TypeBool,
 				Description: `If set to true, disables generating the CRL entirely.`,
 			},
+			"auto_rebuild": &framework.FieldSchema{
+				Type: framework.TypeBool,
+				Description: `If set to true, revocations don't rebuild the CRL;
+it is instead rebuilt in the background before it expires, which also removes
+revoked certificates expired for longer than the tidy safety buffer.`,
+			},
+			"auto_rebuild_grace_period": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `The amount of time before the expiry of the CRL
+that it is rebuilt when auto_rebuild is set; defaults to 12 hours`,
+				Default: defaultCRLAutoRebuildGracePeriod,
+			},
+			"enable_delta": &framework.FieldSchema{
+				Type: framework.TypeBool,
+				Description: `If set to true, delta CRLs listing the certificates
+revoked since the CRL was built are served at "crl/delta".`,
+			},
+			"delta_rebuild_interval": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `The minimum amount of time between delta CRL
+builds; defaults to 15 minutes`,
+				Default: defaultDeltaCRLRebuildInterval,
+			},
 		},
 
 		Callbacks: map[logical.Operation]framework.OperationFunc{
@@ -60,6 +109,23 @@ func (b *backend) CRL(ctx context.Context, s logical.Storage) (*crlConfig, error
 	return &result, nil
 }
 
+// crlExpiry returns the lifetime of CRLs and OCSP responses
+func (b *backend) crlExpiry(ctx context.Context, s logical.Storage) (time.Duration, error) {
+	config, err := b.CRL(ctx, s)
+	if err != nil {
+		return 0, err
+	}
+	if config == nil || config.Expiry == "" {
+		return b.crlLifetime, nil
+	}
+
+	expiry, err := time.ParseDuration(config.Expiry)
+	if err != nil {
+		return 0, errutil.InternalError{Err: fmt.Sprintf("error parsing CRL duration of %s", config.Expiry)}
+	}
+	return expiry, nil
+}
+
 func (b *backend) pathCRLRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	config, err := b.CRL(ctx, req.Storage)
 	if err != nil {
@@ -69,10 +135,23 @@ func (b *backend) pathCRLRead(ctx context.Context, req *logical.Request, data *f
 		return nil, nil
 	}
 
+	gracePeriod := config.AutoRebuildGracePeriod
+	if gracePeriod == "" {
+		gracePeriod = defaultCRLAutoRebuildGracePeriod
+	}
+	deltaInterval := config.DeltaRebuildInterval
+	if deltaInterval == "" {
+		deltaInterval = defaultDeltaCRLRebuildInterval
+	}
+
 	return &logical.Response{
 		Data: map[string]interface{}{
-			"expiry":  config.Expiry,
-			"disable": config.Disable,
+			"expiry":                    config.Expiry,
+			"disable":                   config.Disable,
+			"auto_rebuild":              config.AutoRebuild,
+			"auto_rebuild_grace_period": gracePeriod,
+			"enable_delta":              config.EnableDelta,
+			"delta_rebuild_interval":    deltaInterval,
 		},
 	}, nil
 }
@@ -101,6 +180,50 @@ func (b *backend) pathCRLWrite(ctx context.Context, req *logical.Request, d *fra
 		config.Disable = disableRaw.(bool)
 	}
 
+	if autoRebuildRaw, ok := d.GetOk("auto_rebuild"); ok {
+		config.AutoRebuild = autoRebuildRaw.(bool)
+	}
+
+	if gracePeriodRaw, ok := d.GetOk("auto_rebuild_grace_period"); ok {
+		gracePeriod := gracePeriodRaw.(string)
+		_, err := time.ParseDuration(gracePeriod)
+		if err != nil {
+			return logical.ErrorResponse(fmt.Sprintf("given auto_rebuild_grace_period could not be decoded: %s", err)), nil
+		}
+		config.AutoRebuildGracePeriod = gracePeriod
+	}
+
+	oldEnableDelta := config.EnableDelta
+	if enableDeltaRaw, ok := d.GetOk("enable_delta"); ok {
+		config.EnableDelta = enableDeltaRaw.(bool)
+	}
+
+	if deltaIntervalRaw, ok := d.GetOk("delta_rebuild_interval"); ok {
+		deltaInterval := deltaIntervalRaw.(string)
+		interval, err := time.ParseDuration(deltaInterval)
+		if err != nil {
+			return logical.ErrorResponse(fmt.Sprintf("given delta_rebuild_interval could not be decoded: %s", err)), nil
+		}
+		if interval <= 0 {
+			return logical.ErrorResponse("delta_rebuild_interval must be greater than zero"), nil
+		}
+		config.DeltaRebuildInterval = deltaInterval
+	}
+
+	if config.AutoRebuild {
+		expiry := b.crlLifetime
+		if config.Expiry != "" {
+			expiry, _ = time.ParseDuration(config.Expiry)
+		}
+		gracePeriod, err := config.autoRebuildGracePeriod()
+		if err != nil {
+			return nil, err
+		}
+		if gracePeriod >= expiry {
+			return logical.ErrorResponse(fmt.Sprintf("auto_rebuild_grace_period (%s) must be shorter than the expiry (%s)", gracePeriod, expiry)), nil
+		}
+	}
+
 	entry, err := logical.StorageEntryJSON("config/crl", config)
 	if err != nil {
 		return nil, err
@@ -110,7 +233,21 @@ func (b *backend) pathCRLWrite(ctx context.Context, req *logical.Request, d *fra
 		return nil, err
 	}
 
-	if oldDisable != config.Disable {
+	// Cached OCSP responses may outlive the new expiry
+	b.ocspCache.purge()
+
+	if oldEnableDelta && (!config.EnableDelta || config.Disable) {
+		b.crlBuildLock.Lock()
+		err := deleteDeltaCRLs(ctx, req.Storage)
+		b.crlBuildLock.Unlock()
+		if err != nil {
+			return nil, errwrap.Wrapf("error deleting delta CRLs: {{err}}", err)
+		}
+	}
+
+	// Delta CRLs reference the number of the complete CRL, which legacy CRLs
+	// don't have, so the CRL is rotated when they are enabled
+	if oldDisable != config.Disable || (config.EnableDelta && !oldEnableDelta) {
 		// It wasn't disabled but now it is, rotate
 		crlErr := buildCRL(ctx, b, req, true)
 		switch crlErr.(type) {
@@ -125,9 +262,10 @@ func (b *backend) pathCRLWrite(ctx context.Context, req *logical.Request, d *fra
 }
 
 const pathConfigCRLHelpSyn = `
-Configure the CRL expiration.
+Configure the CRL expiration and rebuilding.
 `
 
 const pathConfigCRLHelpDesc = `
-This endpoint allows configuration of the CRL lifetime.
+This endpoint allows configuration of the CRL lifetime, of rebuilding the CRL
+in the background rather than on every revocation, and of delta CRLs.
 `
@@ -0,0 +1,232 @@
+package pki
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+// estLabelRegex matches the labels under which EST clients reach a role,
+// which can't be the names of the EST operations
+var estLabelRegex = regexp.MustCompile(`^\w[\w-]*$`)
+
+// estConfig holds the configuration of the EST server. LabelToRole maps the
+// labels of the "est/<label>/" endpoints to the roles signing their
+// certificates; DefaultRole is used by the endpoints without a label.
+type estConfig struct {
+	Enabled       bool              `json:"enabled"`
+	DefaultRole   string            `json:"default_role"`
+	LabelToRole   map[string]string `json:"label_to_role"`
+	UserpassMount string            `json:"userpass_mount"`
+	CertMount     string            `json:"cert_mount"`
+	CertRole      string            `json:"cert_role"`
+}
+
+// role returns the name of the role of the label, or an empty string if the
+// label is unknown
+func (c *estConfig) role(label string) string {
+	if label == "" {
+		return c.DefaultRole
+	}
+	return c.LabelToRole[label]
+}
+
+func pathConfigEST(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "config/est",
+		Fields: map[string]*framework.FieldSchema{
+			"enabled": &framework.FieldSchema{
+				Type:        framework.TypeBool,
+				Description: `If set to true, enables the EST endpoints.`,
+			},
+
+			"default_role": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `The role used by the EST endpoints without a
+label, "est/cacerts", "est/simpleenroll" and so on.`,
+			},
+
+			"label_to_role": &framework.FieldSchema{
+				Type: framework.TypeKVPairs,
+				Description: `A map of labels to the roles used by the
+"est/<label>/" endpoints.`,
+			},
+
+			"userpass_mount": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `The path of the userpass auth mount which
+authenticates EST clients using HTTP basic authentication. The Authorization
+header must be in the passthrough_request_headers of the PKI mount.`,
+			},
+
+			"cert_mount": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `The path of the cert auth mount which authenticates
+EST clients using TLS client certificates.`,
+			},
+
+			"cert_role": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `The role of the cert auth mount to log in against;
+all of its roles are tried if empty.`,
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation:   b.pathESTConfigRead,
+			logical.UpdateOperation: b.pathESTConfigWrite,
+		},
+
+		HelpSynopsis:    pathConfigESTHelpSyn,
+		HelpDescription: pathConfigESTHelpDesc,
+	}
+}
+
+func (b *backend) ESTConfig(ctx context.Context, s logical.Storage) (*estConfig, error) {
+	entry, err := s.Get(ctx, "config/est")
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	var result estConfig
+	if err := entry.DecodeJSON(&result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (b *backend) pathESTConfigRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	config, err := b.ESTConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		return nil, nil
+	}
+
+	labelToRole := config.LabelToRole
+	if labelToRole == nil {
+		labelToRole = map[string]string{}
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"enabled":        config.Enabled,
+			"default_role":   config.DefaultRole,
+			"label_to_role":  labelToRole,
+			"userpass_mount": config.UserpassMount,
+			"cert_mount":     config.CertMount,
+			"cert_role":      config.CertRole,
+		},
+	}, nil
+}
+
+func (b *backend) pathESTConfigWrite(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	config, err := b.ESTConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		config = &estConfig{}
+	}
+
+	if enabledRaw, ok := d.GetOk("enabled"); ok {
+		config.Enabled = enabledRaw.(bool)
+	}
+	if roleRaw, ok := d.GetOk("default_role"); ok {
+		config.DefaultRole = roleRaw.(string)
+	}
+	if labelsRaw, ok := d.GetOk("label_to_role"); ok {
+		config.LabelToRole = labelsRaw.(map[string]string)
+	}
+	if mountRaw, ok := d.GetOk("userpass_mount"); ok {
+		config.UserpassMount = normalizeAuthMount(mountRaw.(string))
+	}
+	if mountRaw, ok := d.GetOk("cert_mount"); ok {
+		config.CertMount = normalizeAuthMount(mountRaw.(string))
+	}
+	if roleRaw, ok := d.GetOk("cert_role"); ok {
+		config.CertRole = roleRaw.(string)
+	}
+
+	labels := make([]string, 0, len(config.LabelToRole))
+	for label := range config.LabelToRole {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	for _, label := range labels {
+		switch {
+		case !estLabelRegex.MatchString(label):
+			return logical.ErrorResponse(fmt.Sprintf("invalid label %q", label)), nil
+		case label == "cacerts", label == "simpleenroll", label == "simplereenroll", label == "csrattrs":
+			return logical.ErrorResponse(fmt.Sprintf("label %q is reserved", label)), nil
+		}
+	}
+
+	if config.Enabled {
+		if config.DefaultRole == "" && len(config.LabelToRole) == 0 {
+			return logical.ErrorResponse("default_role or label_to_role is required to enable EST"), nil
+		}
+		if config.UserpassMount == "" && config.CertMount == "" {
+			return logical.ErrorResponse("userpass_mount or cert_mount is required to enable EST"), nil
+		}
+
+		roleNames := []string{}
+		if config.DefaultRole != "" {
+			roleNames = append(roleNames, config.DefaultRole)
+		}
+		for _, label := range labels {
+			roleNames = append(roleNames, config.LabelToRole[label])
+		}
+		for _, roleName := range roleNames {
+			role, err := b.getRole(ctx, req.Storage, roleName)
+			if err != nil {
+				return nil, err
+			}
+			if role == nil {
+				return logical.ErrorResponse(fmt.Sprintf("unknown role: %s", roleName)), nil
+			}
+		}
+	}
+
+	entry, err := logical.StorageEntryJSON("config/est", config)
+	if err != nil {
+		return nil, err
+	}
+	if err := req.Storage.Put(ctx, entry); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// normalizeAuthMount returns the path of an auth mount relative to "auth/",
+// without trailing slash
+func normalizeAuthMount(path string) string {
+	return strings.Trim(strings.TrimPrefix(strings.Trim(path, "/"), "auth/"), "/")
+}
+
+const pathConfigESTHelpSyn = `
+Configure the EST server.
+`
+
+const pathConfigESTHelpDesc = `
+This endpoint enables the EST (RFC 7030) endpoints under "est/", which let
+EST clients such as network appliances obtain certificates without a Vault
+token. Each label of the "est/<label>/" endpoints signs certificates with its
+role, and the endpoints without a label with the default role.
+
+Clients enroll after authenticating against a userpass auth mount with HTTP
+basic authentication, or against a cert auth mount with a TLS client
+certificate. The policies granted by the login must allow updating the
+enrollment endpoints, e.g. "pki/est/<label>/simpleenroll".
+`
@@ -0,0 +1,227 @@
+package pki
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/helper/certutil"
+	"github.com/hashicorp/vault/helper/errutil"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+// ocspConfig holds the configuration of the OCSP responder. Responders maps
+// the ID of an issuer to the delegated responder signing the responses for
+// its certificates, which are otherwise signed by the issuer itself.
+type ocspConfig struct {
+	Disable    bool                            `json:"disable"`
+	Responders map[string]*certutil.CertBundle `json:"responders"`
+}
+
+func pathConfigOCSP(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "config/ocsp",
+		Fields: map[string]*framework.FieldSchema{
+			"disable": &framework.FieldSchema{
+				Type:        framework.TypeBool,
+				Description: `If set to true, disables the OCSP responder.`,
+			},
+
+			"responder_pem_bundle": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `PEM-format, concatenated unencrypted secret key
+and certificate of a delegated OCSP responder. The certificate must be issued
+by an issuer of this mount with the OCSP signing extended key usage, and
+replaces any delegated responder of that issuer.`,
+			},
+
+			"remove_responder": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `The ID or name of an issuer whose delegated
+responder is removed, so that the issuer signs its responses again.`,
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation:   b.pathOCSPConfigRead,
+			logical.UpdateOperation: b.pathOCSPConfigWrite,
+		},
+
+		HelpSynopsis:    pathConfigOCSPHelpSyn,
+		HelpDescription: pathConfigOCSPHelpDesc,
+	}
+}
+
+func (b *backend) OCSPConfig(ctx context.Context, s logical.Storage) (*ocspConfig, error) {
+	entry, err := s.Get(ctx, "config/ocsp")
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	var result ocspConfig
+	if err := entry.DecodeJSON(&result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (b *backend) pathOCSPConfigRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	config, err := b.OCSPConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		return nil, nil
+	}
+
+	responders := make(map[string]interface{}, len(config.Responders))
+	for issuerID, responder := range config.Responders {
+		parsedBundle, err := responder.ToParsedCertBundle()
+		if err != nil {
+			return nil, err
+		}
+		responders[issuerID] = map[string]interface{}{
+			"serial_number": responder.SerialNumber,
+			"expiration":    parsedBundle.Certificate.NotAfter.Unix(),
+		}
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"disable":    config.Disable,
+			"responders": responders,
+		},
+	}, nil
+}
+
+func (b *backend) pathOCSPConfigWrite(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	config, err := b.OCSPConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		config = &ocspConfig{}
+	}
+	if config.Responders == nil {
+		config.Responders = make(map[string]*certutil.CertBundle)
+	}
+
+	if disableRaw, ok := d.GetOk("disable"); ok {
+		config.Disable = disableRaw.(bool)
+	}
+
+	if issuerRef := d.Get("remove_responder").(string); issuerRef != "" {
+		issuerID, err := resolveIssuerRef(ctx, req.Storage, issuerRef)
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+		delete(config.Responders, issuerID)
+	}
+
+	if pemBundle := d.Get("responder_pem_bundle").(string); pemBundle != "" {
+		issuerID, cb, err := parseOCSPResponder(ctx, req, pemBundle)
+		if err != nil {
+			switch err.(type) {
+			case errutil.UserError:
+				return logical.ErrorResponse(err.Error()), nil
+			default:
+				return nil, err
+			}
+		}
+		config.Responders[issuerID] = cb
+	}
+
+	entry, err := logical.StorageEntryJSON("config/ocsp", config)
+	if err != nil {
+		return nil, err
+	}
+	err = req.Storage.Put(ctx, entry)
+	if err != nil {
+		return nil, err
+	}
+
+	b.ocspCache.purge()
+
+	return nil, nil
+}
+
+// parseOCSPResponder checks that the bundle holds the key and certificate of
+// a delegated OCSP responder, and returns the ID of its issuer
+func parseOCSPResponder(ctx context.Context, req *logical.Request, pemBundle string) (string, *certutil.CertBundle, error) {
+	parsedBundle, err := certutil.ParsePEMBundle(pemBundle)
+	if err != nil {
+		return "", nil, err
+	}
+	if parsedBundle.PrivateKey == nil || parsedBundle.PrivateKeyType == certutil.UnknownPrivateKey {
+		return "", nil, errutil.UserError{Err: "private key not found in the PEM bundle"}
+	}
+	if parsedBundle.PrivateKeyType == certutil.Ed25519PrivateKey {
+		return "", nil, errutil.UserError{Err: "OCSP responses can't be signed with Ed25519 keys; use an RSA or EC key"}
+	}
+	if parsedBundle.Certificate == nil {
+		return "", nil, errutil.UserError{Err: "no certificate found in the PEM bundle"}
+	}
+	if err := parsedBundle.Verify(); err != nil {
+		return "", nil, errutil.UserError{Err: fmt.Sprintf("verification of the PEM bundle failed: %s", err)}
+	}
+
+	cert := parsedBundle.Certificate
+	var ocspSigning bool
+	for _, usage := range cert.ExtKeyUsage {
+		if usage == x509.ExtKeyUsageOCSPSigning {
+			ocspSigning = true
+		}
+	}
+	if !ocspSigning {
+		return "", nil, errutil.UserError{Err: "the given certificate doesn't have the OCSP signing extended key usage"}
+	}
+	if time.Now().After(cert.NotAfter) {
+		return "", nil, errutil.UserError{Err: "the given certificate has expired"}
+	}
+
+	issuerIDs, err := listIssuers(ctx, req.Storage)
+	if err != nil {
+		return "", nil, err
+	}
+	for _, id := range issuerIDs {
+		issuer, err := fetchIssuer(ctx, req.Storage, id)
+		if err != nil {
+			return "", nil, err
+		}
+		if issuer == nil {
+			continue
+		}
+		issuerBundle, err := issuer.Bundle.ToParsedCertBundle()
+		if err != nil {
+			return "", nil, err
+		}
+		if issuedBy(cert, issuerBundle.Certificate) && cert.CheckSignatureFrom(issuerBundle.Certificate) == nil {
+			cb, err := parsedBundle.ToCertBundle()
+			if err != nil {
+				return "", nil, errwrap.Wrapf("error converting raw values into cert bundle: {{err}}", err)
+			}
+			return id, cb, nil
+		}
+	}
+
+	return "", nil, errutil.UserError{Err: "the given certificate wasn't issued by an issuer of this mount"}
+}
+
+const pathConfigOCSPHelpSyn = `
+Configure the OCSP responder.
+`
+
+const pathConfigOCSPHelpDesc = `
+This endpoint allows disabling the OCSP responder, and configuring delegated
+responders signing the OCSP responses of an issuer in place of the issuer
+itself. A delegated responder certificate is issued by the issuer with the
+OCSP signing extended key usage, for instance from a role with "OCSPSigning"
+in its "ext_key_usage".
+`
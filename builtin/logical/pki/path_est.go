@@ -0,0 +1,487 @@
+package pki
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/fullsailor/pkcs7"
+	"github.com/hashicorp/vault/helper/certutil"
+	"github.com/hashicorp/vault/helper/errutil"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+const (
+	estCertsContentType    = "application/pkcs7-mime; smime-type=certs-only"
+	estCSRAttrsContentType = "application/csrattrs"
+
+	// estLabelPattern matches the optional label of the EST endpoints
+	estLabelPattern = `(?:(?P<label>\w[\w-]*)/)?`
+)
+
+var (
+	oidRSAEncryption           = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidSHA256WithRSAEncryption = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}
+	oidECPublicKey             = asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1}
+	oidECDSAWithSHA256         = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+	oidEd25519                 = asn1.ObjectIdentifier{1, 3, 101, 112}
+
+	estCurveOIDs = map[int]asn1.ObjectIdentifier{
+		224: {1, 3, 132, 0, 33},
+		256: {1, 2, 840, 10045, 3, 1, 7},
+		384: {1, 3, 132, 0, 34},
+		521: {1, 3, 132, 0, 35},
+	}
+)
+
+func pathEST(b *backend) []*framework.Path {
+	fields := map[string]*framework.FieldSchema{
+		"label": &framework.FieldSchema{
+			Type: framework.TypeString,
+			Description: `The label mapped to the role signing the
+certificates; the default role is used if empty.`,
+		},
+	}
+
+	return []*framework.Path{
+		{
+			Pattern: "est/" + estLabelPattern + "cacerts",
+			Fields:  fields,
+
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ReadOperation: b.pathESTCACerts,
+			},
+
+			HelpSynopsis:    pathESTCACertsHelpSyn,
+			HelpDescription: pathESTCACertsHelpDesc,
+		},
+		{
+			Pattern: "est/" + estLabelPattern + "csrattrs",
+			Fields:  fields,
+
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ReadOperation: b.pathESTCSRAttrs,
+			},
+
+			HelpSynopsis:    pathESTCSRAttrsHelpSyn,
+			HelpDescription: pathESTCSRAttrsHelpDesc,
+		},
+		{
+			Pattern: "est/" + estLabelPattern + "simpleenroll",
+			Fields:  fields,
+
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.UpdateOperation: b.pathESTSimpleEnroll,
+			},
+
+			HelpSynopsis:    pathESTEnrollHelpSyn,
+			HelpDescription: pathESTEnrollHelpDesc,
+		},
+		{
+			Pattern: "est/" + estLabelPattern + "simplereenroll",
+			Fields:  fields,
+
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.UpdateOperation: b.pathESTSimpleReenroll,
+			},
+
+			HelpSynopsis:    pathESTReenrollHelpSyn,
+			HelpDescription: pathESTReenrollHelpDesc,
+		},
+	}
+}
+
+// estRole returns the EST configuration and the role of the label, or an
+// error response if EST is disabled or the label unknown
+func (b *backend) estRole(ctx context.Context, req *logical.Request, data *framework.FieldData) (*estConfig, string, *roleEntry, *logical.Response, error) {
+	config, err := b.ESTConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, "", nil, nil, err
+	}
+	if config == nil || !config.Enabled {
+		resp, err := logical.RespondWithStatusCode(logical.ErrorResponse("EST is not enabled"), req, http.StatusNotFound)
+		return nil, "", nil, resp, err
+	}
+
+	label := data.Get("label").(string)
+	roleName := config.role(label)
+	if roleName == "" {
+		resp, err := logical.RespondWithStatusCode(logical.ErrorResponse(fmt.Sprintf("unknown EST label %q", label)), req, http.StatusNotFound)
+		return nil, "", nil, resp, err
+	}
+
+	role, err := b.getRole(ctx, req.Storage, roleName)
+	if err != nil {
+		return nil, "", nil, nil, err
+	}
+	if role == nil {
+		return nil, "", nil, logical.ErrorResponse(fmt.Sprintf("unknown role: %s", roleName)), nil
+	}
+
+	return config, roleName, role, nil, nil
+}
+
+func (b *backend) pathESTCACerts(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	_, _, role, resp, err := b.estRole(ctx, req, data)
+	if resp != nil || err != nil {
+		return resp, err
+	}
+
+	signingBundle, err := fetchCAInfoByRef(ctx, req, role.IssuerRef)
+	if err != nil {
+		return nil, err
+	}
+
+	certs := [][]byte{signingBundle.CertificateBytes}
+	for _, block := range signingBundle.CAChain {
+		if !bytes.Equal(block.Bytes, signingBundle.CertificateBytes) {
+			certs = append(certs, block.Bytes)
+		}
+	}
+
+	return estCertsResponse(certs...)
+}
+
+func (b *backend) pathESTCSRAttrs(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	_, _, role, resp, err := b.estRole(ctx, req, data)
+	if resp != nil || err != nil {
+		return resp, err
+	}
+
+	// The key type of the role is requested from clients, as an OID of a
+	// public key algorithm or an attribute holding the curve
+	var attrs []asn1.RawValue
+	appendAttr := func(v interface{}) error {
+		der, err := asn1.Marshal(v)
+		if err != nil {
+			return err
+		}
+		attrs = append(attrs, asn1.RawValue{FullBytes: der})
+		return nil
+	}
+	switch role.KeyType {
+	case "rsa":
+		if err := appendAttr(oidRSAEncryption); err != nil {
+			return nil, err
+		}
+		if err := appendAttr(oidSHA256WithRSAEncryption); err != nil {
+			return nil, err
+		}
+
+	case "ec":
+		if curve, ok := estCurveOIDs[role.KeyBits]; ok {
+			err = appendAttr(struct {
+				Type   asn1.ObjectIdentifier
+				Values []asn1.ObjectIdentifier `asn1:"set"`
+			}{
+				Type:   oidECPublicKey,
+				Values: []asn1.ObjectIdentifier{curve},
+			})
+		} else {
+			err = appendAttr(oidECPublicKey)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := appendAttr(oidECDSAWithSHA256); err != nil {
+			return nil, err
+		}
+
+	case "ed25519":
+		if err := appendAttr(oidEd25519); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(attrs) == 0 {
+		return &logical.Response{
+			Data: map[string]interface{}{
+				logical.HTTPStatusCode: http.StatusNoContent,
+			},
+		}, nil
+	}
+
+	der, err := asn1.Marshal(attrs)
+	if err != nil {
+		return nil, err
+	}
+	return estRawResponse(estCSRAttrsContentType, der), nil
+}
+
+func (b *backend) pathESTSimpleEnroll(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	return b.estEnroll(ctx, req, data, false)
+}
+
+func (b *backend) pathESTSimpleReenroll(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	return b.estEnroll(ctx, req, data, true)
+}
+
+func (b *backend) estEnroll(ctx context.Context, req *logical.Request, data *framework.FieldData, reenroll bool) (*logical.Response, error) {
+	config, roleName, role, resp, err := b.estRole(ctx, req, data)
+	if resp != nil || err != nil {
+		return resp, err
+	}
+
+	// The core authenticates the client and routes the request again
+	if req.DelegatedAuth == nil {
+		return estDelegatedAuth(req, config)
+	}
+
+	csr, err := parseESTCSR(req)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	if reenroll {
+		if err := checkESTReenrollment(ctx, req, csr); err != nil {
+			switch err.(type) {
+			case errutil.UserError:
+				return logical.RespondWithStatusCode(logical.ErrorResponse(err.Error()), req, http.StatusForbidden)
+			default:
+				return nil, err
+			}
+		}
+	}
+
+	signingBundle, err := fetchCAInfoByRef(ctx, req, role.IssuerRef)
+	if err != nil {
+		return nil, err
+	}
+
+	// The CSR is signed as if it was sent to the sign endpoint of the role
+	var altNames []string
+	altNames = append(altNames, csr.DNSNames...)
+	altNames = append(altNames, csr.EmailAddresses...)
+	var ipSANs, uriSANs []string
+	for _, ip := range csr.IPAddresses {
+		ipSANs = append(ipSANs, ip.String())
+	}
+	for _, uri := range csr.URIs {
+		uriSANs = append(uriSANs, uri.String())
+	}
+	signData := &framework.FieldData{
+		Raw: map[string]interface{}{
+			"csr": string(pem.EncodeToMemory(&pem.Block{
+				Type:  "CERTIFICATE REQUEST",
+				Bytes: csr.Raw,
+			})),
+			"common_name": csr.Subject.CommonName,
+			"alt_names":   strings.Join(altNames, ","),
+			"ip_sans":     strings.Join(ipSANs, ","),
+			"uri_sans":    strings.Join(uriSANs, ","),
+		},
+		Schema: pathSign(b).Fields,
+	}
+
+	parsedBundle, err := signCert(b, &dataBundle{
+		req:           req,
+		apiData:       signData,
+		role:          role,
+		signingBundle: signingBundle,
+	}, false, false)
+	switch err.(type) {
+	case nil:
+	case errutil.UserError:
+		return logical.ErrorResponse(err.Error()), nil
+	default:
+		return nil, err
+	}
+
+	if !role.NoStore {
+		if err := storeCert(ctx, req.Storage, parsedBundle.Certificate, roleName, signingBundle.IssuerID); err != nil {
+			return nil, err
+		}
+	}
+
+	return estCertsResponse(parsedBundle.CertificateBytes)
+}
+
+// estDelegatedAuth asks the core to authenticate the client with the HTTP
+// basic credentials or TLS client certificate of the request, against the
+// configured auth mounts
+func estDelegatedAuth(req *logical.Request, config *estConfig) (*logical.Response, error) {
+	username, password, ok := (&http.Request{Header: http.Header(req.Headers)}).BasicAuth()
+	if ok && config.UserpassMount != "" && username != "" && !strings.Contains(username, "/") {
+		return nil, &logical.DelegatedAuthRequest{
+			Path: "auth/" + config.UserpassMount + "/login/" + username,
+			Data: map[string]interface{}{
+				"password": password,
+			},
+		}
+	}
+
+	if config.CertMount != "" && req.Connection != nil && req.Connection.ConnState != nil &&
+		len(req.Connection.ConnState.PeerCertificates) > 0 {
+		loginData := map[string]interface{}{}
+		if config.CertRole != "" {
+			loginData["name"] = config.CertRole
+		}
+		return nil, &logical.DelegatedAuthRequest{
+			Path: "auth/" + config.CertMount + "/login",
+			Data: loginData,
+		}
+	}
+
+	if config.UserpassMount == "" {
+		return nil, logical.ErrPermissionDenied
+	}
+
+	// Challenge clients which only send credentials when asked
+	return &logical.Response{
+		Data: map[string]interface{}{
+			logical.HTTPStatusCode:  http.StatusUnauthorized,
+			logical.HTTPContentType: "text/plain",
+			logical.HTTPRawBody:     []byte("authentication required"),
+		},
+		Headers: map[string][]string{
+			"WWW-Authenticate": []string{`Basic realm="EST"`},
+		},
+	}, nil
+}
+
+// parseESTCSR parses the base64-encoded DER CSR in the body of the request
+func parseESTCSR(req *logical.Request) (*x509.CertificateRequest, error) {
+	body, ok := req.Data[logical.HTTPRawBody].([]byte)
+	if !ok || len(body) == 0 {
+		return nil, fmt.Errorf("the request body must be a CSR with the application/pkcs10 content type")
+	}
+
+	der, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(string(body)), ""))
+	if err != nil {
+		// Some clients send the DER as is
+		der = body
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		return nil, fmt.Errorf("certificate request could not be parsed: %v", err)
+	}
+	return csr, nil
+}
+
+// checkESTReenrollment checks that the client authenticated the TLS
+// connection with a valid certificate of this mount, and that the CSR keeps
+// its subject and doesn't add subject alternative names
+func checkESTReenrollment(ctx context.Context, req *logical.Request, csr *x509.CertificateRequest) error {
+	if req.Connection == nil || req.Connection.ConnState == nil || len(req.Connection.ConnState.PeerCertificates) == 0 {
+		return errutil.UserError{Err: "re-enrollment requires the certificate being renewed as TLS client certificate"}
+	}
+	cert := req.Connection.ConnState.PeerCertificates[0]
+
+	serial := certutil.GetHexFormatted(cert.SerialNumber.Bytes(), ":")
+	certEntry, err := fetchCertBySerial(ctx, req, "certs/", serial)
+	if err != nil {
+		return err
+	}
+	if certEntry == nil || !bytes.Equal(certEntry.Value, cert.Raw) {
+		return errutil.UserError{Err: "the TLS client certificate wasn't issued by this mount"}
+	}
+	revokedEntry, err := req.Storage.Get(ctx, "revoked/"+normalizeSerial(serial))
+	if err != nil {
+		return err
+	}
+	if revokedEntry != nil {
+		return errutil.UserError{Err: "the TLS client certificate is revoked"}
+	}
+	if now := time.Now(); now.Before(cert.NotBefore) || now.After(cert.NotAfter) {
+		return errutil.UserError{Err: "the TLS client certificate isn't valid"}
+	}
+
+	if cert.Subject.String() != csr.Subject.String() {
+		return errutil.UserError{Err: fmt.Sprintf("the CSR subject %q doesn't match the certificate subject %q", csr.Subject, cert.Subject)}
+	}
+	// The CSR can't request names the certificate doesn't have
+	certNames := make(map[string]bool)
+	for _, name := range newCertMetadata(cert, "", "").AltNames {
+		certNames[strings.ToLower(name)] = true
+	}
+	var csrNames []string
+	csrNames = append(csrNames, csr.DNSNames...)
+	csrNames = append(csrNames, csr.EmailAddresses...)
+	for _, ip := range csr.IPAddresses {
+		csrNames = append(csrNames, ip.String())
+	}
+	for _, uri := range csr.URIs {
+		csrNames = append(csrNames, uri.String())
+	}
+	for _, name := range csrNames {
+		if !certNames[strings.ToLower(name)] {
+			return errutil.UserError{Err: fmt.Sprintf("the CSR subject alternative name %q isn't one of the certificate", name)}
+		}
+	}
+
+	return nil
+}
+
+// estCertsResponse returns the certificates as a base64-encoded certs-only
+// PKCS#7 message
+func estCertsResponse(certs ...[]byte) (*logical.Response, error) {
+	p7, err := pkcs7.DegenerateCertificate(bytes.Join(certs, nil))
+	if err != nil {
+		return nil, err
+	}
+	return estRawResponse(estCertsContentType, p7), nil
+}
+
+// estRawResponse returns the body base64-encoded, as EST requires
+func estRawResponse(contentType string, body []byte) *logical.Response {
+	return &logical.Response{
+		Data: map[string]interface{}{
+			logical.HTTPStatusCode:  http.StatusOK,
+			logical.HTTPContentType: contentType,
+			logical.HTTPRawBody:     []byte(base64.StdEncoding.EncodeToString(body)),
+		},
+		Headers: map[string][]string{
+			"Content-Transfer-Encoding": []string{"base64"},
+		},
+	}
+}
+
+const pathESTCACertsHelpSyn = `
+Fetch the EST CA certificates.
+`
+
+const pathESTCACertsHelpDesc = `
+This endpoint returns the certificate of the issuer of the role of the label,
+and its chain, as a base64-encoded certs-only PKCS#7 message.
+`
+
+const pathESTCSRAttrsHelpSyn = `
+Fetch the EST CSR attributes.
+`
+
+const pathESTCSRAttrsHelpDesc = `
+This endpoint returns the base64-encoded attributes requested in the CSRs of
+the label, which are the key type and size of its role. There are none when
+the role accepts any key type.
+`
+
+const pathESTEnrollHelpSyn = `
+Enroll a certificate with EST.
+`
+
+const pathESTEnrollHelpDesc = `
+This endpoint signs the base64-encoded DER CSR of the request body with the
+role of the label, which validates it like the "sign/<role>" endpoint. The
+certificate is returned as a base64-encoded certs-only PKCS#7 message.
+
+Clients are authenticated against the auth mounts of the EST configuration.
+`
+
+const pathESTReenrollHelpSyn = `
+Re-enroll a certificate with EST.
+`
+
+const pathESTReenrollHelpDesc = `
+This endpoint renews the certificate the client authenticated the TLS
+connection with, which must be a valid certificate of this mount. The CSR
+must keep its subject, can only request subject alternative names of the
+certificate, and is signed like with the "simpleenroll" endpoint.
+`
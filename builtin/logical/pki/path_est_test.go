@@ -0,0 +1,272 @@
+package pki
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/fullsailor/pkcs7"
+	"github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/builtin/credential/cert"
+	"github.com/hashicorp/vault/builtin/credential/userpass"
+	"github.com/hashicorp/vault/helper/certutil"
+	vaulthttp "github.com/hashicorp/vault/http"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/vault"
+)
+
+// estTestCSR returns a base64-encoded DER CSR for the subject and DNS names
+func estTestCSR(t *testing.T, key *ecdsa.PrivateKey, commonName string, dnsNames ...string) []byte {
+	t.Helper()
+
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: commonName},
+		DNSNames: dnsNames,
+	}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return []byte(base64.StdEncoding.EncodeToString(der))
+}
+
+// estTestCerts parses the base64-encoded certs-only PKCS#7 body of a response
+func estTestCerts(t *testing.T, resp *http.Response) []*x509.Certificate {
+	t.Helper()
+
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != estCertsContentType {
+		t.Fatalf("bad: status %d, content type %q: %s", resp.StatusCode, resp.Header.Get("Content-Type"), body)
+	}
+	der, err := base64.StdEncoding.DecodeString(string(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p7, err := pkcs7.Parse(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p7.Certificates
+}
+
+func TestBackend_ESTHTTP(t *testing.T) {
+	coreConfig := &vault.CoreConfig{
+		LogicalBackends: map[string]logical.Factory{
+			"pki": Factory,
+		},
+		CredentialBackends: map[string]logical.Factory{
+			"userpass": userpass.Factory,
+			"cert":     cert.Factory,
+		},
+	}
+	cluster := vault.NewTestCluster(t, coreConfig, &vault.TestClusterOptions{
+		HandlerFunc: vaulthttp.Handler,
+	})
+	cluster.Start()
+	defer cluster.Cleanup()
+
+	client := cluster.Cores[0].Client
+	err := client.Sys().Mount("pki", &api.MountInput{
+		Type: "pki",
+		Config: api.MountConfigInput{
+			DefaultLeaseTTL:           "16h",
+			MaxLeaseTTL:               "32h",
+			PassthroughRequestHeaders: []string{"Authorization"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, err := client.Logical().Write("pki/root/generate/internal", map[string]interface{}{
+		"common_name": "Plant CA",
+		"ttl":         "32h",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootPEM := secret.Data["certificate"].(string)
+	root := issuersTestParseCert(t, rootPEM)
+	_, err = client.Logical().Write("pki/roles/device", map[string]interface{}{
+		"allowed_domains":  "devices.example.com",
+		"allow_subdomains": true,
+		"key_type":         "ec",
+		"key_bits":         256,
+		"ttl":              "1h",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// EST clients log in with the userpass and cert mounts, and need a policy
+	// granting access to the EST endpoints
+	err = client.Sys().PutPolicy("est", `path "pki/est/*" { capabilities = ["update"] }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Sys().EnableAuthWithOptions("userpass", &api.EnableAuthOptions{Type: "userpass"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Sys().EnableAuthWithOptions("cert", &api.EnableAuthOptions{Type: "cert"}); err != nil {
+		t.Fatal(err)
+	}
+	for user, policies := range map[string]string{"gateway": "est", "operator": "default"} {
+		_, err = client.Logical().Write("auth/userpass/users/"+user, map[string]interface{}{
+			"password": "secret",
+			"policies": policies,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err = client.Logical().Write("auth/cert/certs/devices", map[string]interface{}{
+		"certificate": rootPEM,
+		"policies":    "est",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = client.Logical().Write("pki/config/est", map[string]interface{}{
+		"enabled":        true,
+		"label_to_role":  map[string]interface{}{"plant": "device"},
+		"userpass_mount": "userpass",
+		"cert_mount":     "auth/cert/",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(cluster.CACertPEM)
+	httpClient := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: pool},
+		},
+	}
+	baseURL := client.Address() + "/v1/pki/est/plant/"
+	post := func(client *http.Client, op, username string, body []byte) *http.Response {
+		t.Helper()
+
+		req, err := http.NewRequest("POST", baseURL+op, bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/pkcs10")
+		if username != "" {
+			req.SetBasicAuth(username, "secret")
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	expectStatus := func(resp *http.Response, status int) {
+		t.Helper()
+
+		resp.Body.Close()
+		if resp.StatusCode != status {
+			t.Fatalf("expected status %d, got %d", status, resp.StatusCode)
+		}
+	}
+
+	// The CA certificates and CSR attributes don't require authentication
+	resp, err := httpClient.Get(baseURL + "cacerts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if certs := estTestCerts(t, resp); len(certs) != 1 || !certs[0].Equal(root) {
+		t.Fatalf("bad: %v", certs)
+	}
+	resp, err = httpClient.Get(client.Address() + "/v1/pki/est/cacerts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectStatus(resp, http.StatusNotFound)
+
+	resp, err = httpClient.Get(baseURL + "csrattrs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := base64.StdEncoding.DecodeString(string(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var attrs []asn1.RawValue
+	if _, err := asn1.Unmarshal(der, &attrs); err != nil || len(attrs) != 2 {
+		t.Fatalf("bad: csrattrs %x: %v", der, err)
+	}
+
+	// Enrollment requires HTTP basic credentials of a user with access
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr := estTestCSR(t, key, "sensor1.devices.example.com")
+
+	resp = post(httpClient, "simpleenroll", "", csr)
+	if resp.Header.Get("WWW-Authenticate") == "" {
+		t.Fatalf("bad: headers %v", resp.Header)
+	}
+	expectStatus(resp, http.StatusUnauthorized)
+	expectStatus(post(httpClient, "simpleenroll", "operator", csr), http.StatusForbidden)
+
+	certs := estTestCerts(t, post(httpClient, "simpleenroll", "gateway", csr))
+	if len(certs) != 1 || certs[0].Subject.CommonName != "sensor1.devices.example.com" || certs[0].CheckSignatureFrom(root) != nil {
+		t.Fatalf("bad: %v", certs)
+	}
+	enrolled := certs[0]
+
+	// The role validates the CSR
+	resp = post(httpClient, "simpleenroll", "gateway", estTestCSR(t, key, "www.example.com"))
+	expectStatus(resp, http.StatusBadRequest)
+
+	// Re-enrollment is bound to the TLS client certificate, which also
+	// authenticates against the cert mount
+	tlsClient := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				RootCAs: pool,
+				GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+					return &tls.Certificate{
+						Certificate: [][]byte{enrolled.Raw},
+						PrivateKey:  key,
+					}, nil
+				},
+			},
+		},
+	}
+	expectStatus(post(httpClient, "simplereenroll", "gateway", csr), http.StatusForbidden)
+	expectStatus(post(tlsClient, "simplereenroll", "", estTestCSR(t, key, "sensor2.devices.example.com")), http.StatusForbidden)
+
+	certs = estTestCerts(t, post(tlsClient, "simplereenroll", "", csr))
+	if len(certs) != 1 || certs[0].Subject.CommonName != enrolled.Subject.CommonName || certs[0].SerialNumber.Cmp(enrolled.SerialNumber) == 0 {
+		t.Fatalf("bad: %v", certs)
+	}
+
+	// Revoked certificates can't be renewed
+	_, err = client.Logical().Write("pki/revoke", map[string]interface{}{
+		"serial_number": certutil.GetHexFormatted(enrolled.SerialNumber.Bytes(), ":"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectStatus(post(tlsClient, "simplereenroll", "gateway", csr), http.StatusForbidden)
+}
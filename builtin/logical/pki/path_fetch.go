@@ -39,10 +39,10 @@ func pathFetchCAChain(b *backend) *framework.Path {
 	}
 }
 
-// Returns the CRL in raw format
+// Returns the CRL or delta CRL in raw format
 func pathFetchCRL(b *backend) *framework.Path {
 	return &framework.Path{
-		Pattern: `crl(/pem)?`,
+		Pattern: `crl(/delta)?(/pem)?`,
 
 		Callbacks: map[logical.Operation]framework.OperationFunc{
 			logical.ReadOperation: b.pathFetchRead,
@@ -145,6 +145,12 @@ func (b *backend) pathFetchRead(ctx context.Context, req *logical.Request, data
 		if req.Path == "crl/pem" {
 			pemType = "X509 CRL"
 		}
+	case req.Path == "crl/delta" || req.Path == "crl/delta/pem":
+		serial = "delta-crl"
+		contentType = "application/pkix-crl"
+		if req.Path == "crl/delta/pem" {
+			pemType = "X509 CRL"
+		}
 	case req.Path == "cert/crl":
 		serial = "crl"
 		pemType = "X509 CRL"
@@ -272,5 +278,7 @@ This allows certificates to be fetched. If using the fetch/ prefix any non-revok
 
 Using "ca" or "crl" as the value fetches the appropriate information in DER encoding. Add "/pem" to either to get PEM encoding.
 
+The "crl/delta" and "crl/delta/pem" endpoints return the delta CRL, when enabled through "config/crl".
+
 Using "ca_chain" as the value fetches the certificate authority trust chain in PEM encoding.
 `
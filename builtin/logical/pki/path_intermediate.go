@@ -2,8 +2,16 @@ package pki
 
 import (
 	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509"
 	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/hashicorp/errwrap"
 	"github.com/hashicorp/vault/helper/certutil"
@@ -59,6 +67,77 @@ endpoint.`,
 		HelpDescription: pathSetSignedIntermediateHelpDesc,
 	}
 
+	ret.Fields = addIssuerNameField(ret.Fields)
+
+	return ret
+}
+
+func pathCrossSignIntermediate(b *backend) *framework.Path {
+	ret := &framework.Path{
+		Pattern: "intermediate/cross-sign",
+
+		Fields: map[string]*framework.FieldSchema{
+			"issuer_ref": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `The ID or name of the issuer whose
+certificate is cross-signed. Mutually
+exclusive with "csr".`,
+			},
+			"csr": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `PEM-format CSR of a CA outside of
+the mount, such as another mount or an
+external CA, whose subject and key are
+cross-signed. Mutually exclusive with
+"issuer_ref".`,
+			},
+			"key_id": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `The hex-encoded subject key ID of
+the certificate cross-signed from "csr",
+when it must match the key ID of an
+existing certificate of the CA computed
+differently than by this backend.`,
+			},
+			"signing_issuer_ref": &framework.FieldSchema{
+				Type:    framework.TypeString,
+				Default: defaultIssuerRef,
+				Description: `The ID or name of the issuer signing the
+cross-signed certificate. Defaults to "default",
+the default issuer of the mount.`,
+			},
+			"ttl": &framework.FieldSchema{
+				Type: framework.TypeDurationSecond,
+				Description: `The requested Time To Live for the
+cross-signed certificate. Defaults to the
+remaining lifetime of the signing issuer.`,
+			},
+			"add_to_chain": &framework.FieldSchema{
+				Type:    framework.TypeBool,
+				Default: false,
+				Description: `Whether to add the cross-signed
+certificate to the CA chain of the issuer, so
+that clients trusting only the signing issuer
+can validate the certificates it issues.`,
+			},
+			"format": &framework.FieldSchema{
+				Type:    framework.TypeString,
+				Default: "pem",
+				Description: `Format for returned data. Can be "pem", "der",
+or "pem_bundle". If "pem_bundle" any private
+key and issuing cert will be appended to the
+certificate pem. Defaults to "pem".`,
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: b.pathCrossSignIntermediate,
+		},
+
+		HelpSynopsis:    pathCrossSignIntermediateHelpSyn,
+		HelpDescription: pathCrossSignIntermediateHelpDesc,
+	}
+
 	return ret
 }
 
@@ -69,6 +148,9 @@ func (b *backend) pathGenerateIntermediate(ctx context.Context, req *logical.Req
 	if errorResp != nil {
 		return errorResp, nil
 	}
+	if format == "pkcs12" {
+		return logical.ErrorResponse(`the "pkcs12" format requires a certificate and is not supported when generating a CSR`), nil
+	}
 
 	var resp *logical.Response
 	input := &dataBundle{
@@ -130,7 +212,8 @@ func (b *backend) pathGenerateIntermediate(ctx context.Context, req *logical.Req
 	cb.PrivateKey = csrb.PrivateKey
 	cb.PrivateKeyType = csrb.PrivateKeyType
 
-	entry, err := logical.StorageEntryJSON("config/ca_bundle", cb)
+	// The key waits for its certificate, which makes it a new issuer
+	entry, err := logical.StorageEntryJSON("config/pending_intermediate", cb)
 	if err != nil {
 		return nil, err
 	}
@@ -163,8 +246,13 @@ func (b *backend) pathSetSignedIntermediate(ctx context.Context, req *logical.Re
 		return logical.ErrorResponse("supplied certificate could not be successfully parsed"), nil
 	}
 
+	issuerName := data.Get("issuer_name").(string)
+	if err := validateIssuerName(ctx, req.Storage, issuerName, ""); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
 	cb := &certutil.CertBundle{}
-	entry, err := req.Storage.Get(ctx, "config/ca_bundle")
+	entry, err := req.Storage.Get(ctx, "config/pending_intermediate")
 	if err != nil {
 		return nil, err
 	}
@@ -206,35 +294,245 @@ func (b *backend) pathSetSignedIntermediate(ctx context.Context, req *logical.Re
 		return nil, errwrap.Wrapf("error converting raw values into cert bundle: {{err}}", err)
 	}
 
-	entry, err = logical.StorageEntryJSON("config/ca_bundle", cb)
+	issuer, err := storeIssuer(ctx, req.Storage, cb, issuerName)
 	if err != nil {
 		return nil, err
 	}
-	err = req.Storage.Put(ctx, entry)
+
+	signerID, err := certIssuerID(ctx, req.Storage, inputBundle.Certificate)
 	if err != nil {
 		return nil, err
 	}
-
-	entry.Key = "certs/" + normalizeSerial(cb.SerialNumber)
-	entry.Value = inputBundle.CertificateBytes
-	err = req.Storage.Put(ctx, entry)
+	err = storeCert(ctx, req.Storage, inputBundle.Certificate, "", signerID)
 	if err != nil {
 		return nil, err
 	}
 
-	// For ease of later use, also store just the certificate at a known
-	// location
-	entry.Key = "ca"
-	entry.Value = inputBundle.CertificateBytes
-	err = req.Storage.Put(ctx, entry)
+	err = req.Storage.Delete(ctx, "config/pending_intermediate")
 	if err != nil {
 		return nil, err
 	}
 
 	// Build a fresh CRL
 	err = buildCRL(ctx, b, req, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return nonDefaultIssuerResponse(ctx, req, issuer)
+}
+
+// pathCrossSignIntermediate issues a certificate for the key and subject of
+// an issuer, or of the CSR of a CA outside of the mount, signed by another
+// issuer. Clients trusting only the signing issuer can then validate the
+// certificates of the cross-signed one, which is how trust moves from an old
+// root to a new one.
+func (b *backend) pathCrossSignIntermediate(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	format := getFormat(data)
+	if format == "" {
+		return logical.ErrorResponse(
+			`The "format" path parameter must be "pem", "der", or "pem_bundle"`), nil
+	}
+
+	issuerRef := data.Get("issuer_ref").(string)
+	csrString := data.Get("csr").(string)
+	switch {
+	case issuerRef == "" && csrString == "":
+		return logical.ErrorResponse("no issuer or CSR provided in the \"issuer_ref\" or \"csr\" parameters"), nil
+	case issuerRef != "" && csrString != "":
+		return logical.ErrorResponse("only one of \"issuer_ref\" and \"csr\" can be provided"), nil
+	}
+
+	signingBundle, err := fetchCAInfoByRef(ctx, req, data.Get("signing_issuer_ref").(string))
+	if err != nil {
+		switch err.(type) {
+		case errutil.UserError:
+			return logical.ErrorResponse(err.Error()), nil
+		default:
+			return nil, err
+		}
+	}
+	if signingBundle.Certificate.MaxPathLen == 0 && signingBundle.Certificate.MaxPathLenZero {
+		return logical.ErrorResponse("the signing issuer has a max path length of zero, and cannot cross-sign CA certificates"), nil
+	}
+
+	notAfter := signingBundle.Certificate.NotAfter
+	if ttl := time.Duration(data.Get("ttl").(int)) * time.Second; ttl > 0 {
+		if time.Now().Add(ttl).After(notAfter) {
+			return logical.ErrorResponse(fmt.Sprintf(
+				"cannot satisfy request, as TTL would result in notAfter %s that is beyond the expiration of the signing issuer at %s",
+				time.Now().Add(ttl).Format(time.RFC3339Nano), notAfter.Format(time.RFC3339Nano))), nil
+		}
+		notAfter = time.Now().Add(ttl)
+	}
+
+	serialNumber, err := certutil.GenerateSerialNumber()
+	if err != nil {
+		return nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serialNumber,
+		NotBefore:             time.Now().Add(-30 * time.Second),
+		NotAfter:              notAfter,
+		AuthorityKeyId:        signingBundle.Certificate.SubjectKeyId,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		IssuingCertificateURL: signingBundle.URLs.IssuingCertificates,
+		CRLDistributionPoints: signingBundle.URLs.CRLDistributionPoints,
+		OCSPServer:            signingBundle.URLs.OCSPServers,
+	}
 
-	return nil, err
+	// The cross-signed certificate keeps the subject, in its original
+	// encoding, and the key ID of the CA, so that the certificates the CA
+	// issued chain to it
+	var publicKey crypto.PublicKey
+	var issuerBundle *caInfoBundle
+	if issuerRef != "" {
+		if data.Get("key_id").(string) != "" {
+			return logical.ErrorResponse("\"key_id\" can only be provided with \"csr\"; the key ID of the issuer is kept"), nil
+		}
+
+		issuerBundle, err = fetchCAInfoByRef(ctx, req, issuerRef)
+		if err != nil {
+			switch err.(type) {
+			case errutil.UserError:
+				return logical.ErrorResponse(err.Error()), nil
+			default:
+				return nil, err
+			}
+		}
+		if issuerBundle.IssuerID == signingBundle.IssuerID {
+			return logical.ErrorResponse("an issuer cannot cross-sign itself"), nil
+		}
+
+		cert := issuerBundle.Certificate
+		publicKey = cert.PublicKey
+		template.RawSubject = cert.RawSubject
+		template.SubjectKeyId = cert.SubjectKeyId
+		template.KeyUsage = cert.KeyUsage
+		template.ExtKeyUsage = cert.ExtKeyUsage
+		template.MaxPathLen = cert.MaxPathLen
+		template.MaxPathLenZero = cert.MaxPathLenZero
+		template.DNSNames = cert.DNSNames
+		template.EmailAddresses = cert.EmailAddresses
+		template.IPAddresses = cert.IPAddresses
+		template.URIs = cert.URIs
+	} else {
+		if data.Get("add_to_chain").(bool) {
+			return logical.ErrorResponse("\"add_to_chain\" requires an issuer of the mount in \"issuer_ref\""), nil
+		}
+
+		pemBlock, _ := pem.Decode([]byte(csrString))
+		if pemBlock == nil {
+			return logical.ErrorResponse("csr contains no data"), nil
+		}
+		csr, err := x509.ParseCertificateRequest(pemBlock.Bytes)
+		if err != nil {
+			return logical.ErrorResponse(fmt.Sprintf("certificate request could not be parsed: %v", err)), nil
+		}
+		if err := csr.CheckSignature(); err != nil {
+			return logical.ErrorResponse("request signature invalid"), nil
+		}
+
+		subjectKeyID, err := crossSignKeyID(csr.PublicKey, data.Get("key_id").(string))
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+
+		publicKey = csr.PublicKey
+		template.RawSubject = csr.RawSubject
+		template.SubjectKeyId = subjectKeyID
+		template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+		template.MaxPathLen = -1
+		if signingBundle.Certificate.MaxPathLen > 0 {
+			template.MaxPathLen = signingBundle.Certificate.MaxPathLen - 1
+			template.MaxPathLenZero = template.MaxPathLen == 0
+		}
+		template.DNSNames = csr.DNSNames
+		template.EmailAddresses = csr.EmailAddresses
+		template.IPAddresses = csr.IPAddresses
+		template.URIs = csr.URIs
+	}
+
+	certBytes, err := x509.CreateCertificate(rand.Reader, template, signingBundle.Certificate, publicKey, signingBundle.PrivateKey)
+	if err != nil {
+		return nil, errwrap.Wrapf("unable to cross-sign certificate: {{err}}", err)
+	}
+
+	crossSigned, err := x509.ParseCertificate(certBytes)
+	if err != nil {
+		return nil, errwrap.Wrapf("unable to parse the cross-signed certificate: {{err}}", err)
+	}
+	serial := certutil.GetHexFormatted(serialNumber.Bytes(), ":")
+	err = storeCert(ctx, req.Storage, crossSigned, "", signingBundle.IssuerID)
+	if err != nil {
+		return nil, errwrap.Wrapf("unable to store certificate locally: {{err}}", err)
+	}
+
+	if issuerBundle != nil && data.Get("add_to_chain").(bool) {
+		issuer, err := fetchIssuer(ctx, req.Storage, issuerBundle.IssuerID)
+		if err != nil {
+			return nil, err
+		}
+		issuer.Bundle.CAChain = append(issuer.Bundle.CAChain, string(pem.EncodeToMemory(&pem.Block{
+			Type:  "CERTIFICATE",
+			Bytes: certBytes,
+		})))
+		if err := putIssuer(ctx, req.Storage, issuer); err != nil {
+			return nil, err
+		}
+	}
+
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"serial_number": serial,
+			"expiration":    notAfter.Unix(),
+		},
+	}
+
+	certPEM := strings.TrimSpace(string(pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: certBytes,
+	})))
+	issuingPEM := strings.TrimSpace(string(pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: signingBundle.CertificateBytes,
+	})))
+
+	switch format {
+	case "pem":
+		resp.Data["certificate"] = certPEM
+		resp.Data["issuing_ca"] = issuingPEM
+	case "pem_bundle":
+		resp.Data["certificate"] = fmt.Sprintf("%s\n%s", certPEM, issuingPEM)
+		resp.Data["issuing_ca"] = issuingPEM
+	case "der":
+		resp.Data["certificate"] = base64.StdEncoding.EncodeToString(certBytes)
+		resp.Data["issuing_ca"] = base64.StdEncoding.EncodeToString(signingBundle.CertificateBytes)
+	}
+
+	return resp, nil
+}
+
+// crossSignKeyID returns the subject key ID of a CA cross-signed from a CSR:
+// the hex-encoded keyID when given, or the SHA1 sum of the marshaled public
+// key, as for the certificates this backend issues
+func crossSignKeyID(publicKey crypto.PublicKey, keyID string) ([]byte, error) {
+	if keyID != "" {
+		subjectKeyID, err := hex.DecodeString(strings.Replace(keyID, ":", "", -1))
+		if err != nil || len(subjectKeyID) == 0 {
+			return nil, fmt.Errorf("invalid key ID %q; it must be hex-encoded", keyID)
+		}
+		return subjectKeyID, nil
+	}
+
+	marshaledKey, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling public key: %s", err)
+	}
+	subjectKeyID := sha1.Sum(marshaledKey)
+	return subjectKeyID[:], nil
 }
 
 const pathGenerateIntermediateHelpSyn = `
@@ -252,3 +550,20 @@ Provide the signed intermediate CA cert.
 const pathSetSignedIntermediateHelpDesc = `
 See the API documentation for more information.
 `
+
+const pathCrossSignIntermediateHelpSyn = `
+Cross-sign the certificate of an issuer with another issuer.
+`
+
+const pathCrossSignIntermediateHelpDesc = `
+This endpoint issues a new certificate for the subject and key of an issuer,
+signed by another issuer of the mount. It is typically used when rotating
+roots, so that clients still trusting the old root can validate
+certificates issued by the new one.
+
+The subject and key can instead come from the CSR of a CA outside of the
+mount, such as another mount or a legacy CA being migrated; the certificate
+then gets the key ID given in 'key_id', or the key ID this backend computes.
+
+See the API documentation for more information.
+`
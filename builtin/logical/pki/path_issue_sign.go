@@ -26,6 +26,7 @@ func pathIssue(b *backend) *framework.Path {
 	}
 
 	ret.Fields = addNonCACommonFields(map[string]*framework.FieldSchema{})
+	ret.Fields = addPKCS12Fields(ret.Fields)
 	return ret
 }
 
@@ -184,6 +185,7 @@ func (b *backend) pathSignVerbatim(ctx context.Context, req *logical.Request, da
 			*entry.GenerateLease = *role.GenerateLease
 		}
 		entry.NoStore = role.NoStore
+		entry.IssuerRef = role.IssuerRef
 	}
 
 	if entry.MaxTTL > 0 && entry.TTL > entry.MaxTTL {
@@ -194,14 +196,31 @@ func (b *backend) pathSignVerbatim(ctx context.Context, req *logical.Request, da
 }
 
 func (b *backend) pathIssueSignCert(ctx context.Context, req *logical.Request, data *framework.FieldData, role *roleEntry, useCSR, useCSRValues bool) (*logical.Response, error) {
-	format := getFormat(data)
-	if format == "" {
-		return logical.ErrorResponse(
-			`the "format" path parameter must be "pem", "der", or "pem_bundle"`), nil
+	var format string
+	if useCSR {
+		format = getFormat(data)
+		if format == "" {
+			return logical.ErrorResponse(
+				`the "format" path parameter must be "pem", "der", or "pem_bundle"`), nil
+		}
+	} else {
+		format = getKeyExportFormat(data)
+		if format == "" {
+			return logical.ErrorResponse(
+				`the "format" path parameter must be "pem", "der", "pem_bundle", or "pkcs12"`), nil
+		}
+		if resp := validatePKCS12Encoding(data); resp != nil {
+			return resp, nil
+		}
+	}
+
+	issuerRef := role.IssuerRef
+	if ref := data.Get("issuer_ref").(string); ref != "" {
+		issuerRef = ref
 	}
 
 	var caErr error
-	signingBundle, caErr := fetchCAInfo(ctx, req)
+	signingBundle, caErr := fetchCAInfoByRef(ctx, req, issuerRef)
 	switch caErr.(type) {
 	case errutil.UserError:
 		return nil, errutil.UserError{Err: fmt.Sprintf(
@@ -286,6 +305,14 @@ func (b *backend) pathIssueSignCert(ctx context.Context, req *logical.Request, d
 			respData["private_key"] = base64.StdEncoding.EncodeToString(parsedBundle.PrivateKeyBytes)
 			respData["private_key_type"] = cb.PrivateKeyType
 		}
+
+	case "pkcs12":
+		respData["issuing_ca"] = signingCB.Certificate
+		respData["certificate"], err = pkcs12Response(parsedBundle, data)
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+		respData["private_key_type"] = cb.PrivateKeyType
 	}
 
 	var resp *logical.Response
@@ -315,10 +342,7 @@ func (b *backend) pathIssueSignCert(ctx context.Context, req *logical.Request, d
 	}
 
 	if !role.NoStore {
-		err = req.Storage.Put(ctx, &logical.StorageEntry{
-			Key:   "certs/" + normalizeSerial(cb.SerialNumber),
-			Value: parsedBundle.CertificateBytes,
-		})
+		err = storeCert(ctx, req.Storage, parsedBundle.Certificate, data.Get("role").(string), signingBundle.IssuerID)
 		if err != nil {
 			return nil, errwrap.Wrapf("unable to store certificate locally: {{err}}", err)
 		}
@@ -0,0 +1,451 @@
+package pki
+
+import (
+	"context"
+	"encoding/pem"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/vault/helper/errutil"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+func pathListIssuers(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "issuers/?$",
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ListOperation: b.pathIssuerList,
+		},
+
+		HelpSynopsis:    pathListIssuersHelpSyn,
+		HelpDescription: pathListIssuersHelpDesc,
+	}
+}
+
+func pathIssuers(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "issuers/" + framework.GenericNameRegex("issuer_ref"),
+		Fields: map[string]*framework.FieldSchema{
+			"issuer_ref": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: `The ID or name of the issuer.`,
+			},
+			"issuer_name": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `The name of the issuer, which can be used
+instead of its ID to reference it.`,
+			},
+		},
+
+		ExistenceCheck: b.pathIssuerExistenceCheck,
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation:   b.pathIssuerRead,
+			logical.UpdateOperation: b.pathIssuerWrite,
+			logical.DeleteOperation: b.pathIssuerDelete,
+		},
+
+		HelpSynopsis:    pathIssuersHelpSyn,
+		HelpDescription: pathIssuersHelpDesc,
+	}
+}
+
+func pathConfigIssuers(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "config/issuers",
+		Fields: map[string]*framework.FieldSchema{
+			"default": &framework.FieldSchema{
+				Type: framework.TypeString,
+				Description: `The ID or name of the issuer used when
+no issuer is referenced.`,
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation:   b.pathConfigIssuersRead,
+			logical.UpdateOperation: b.pathConfigIssuersWrite,
+		},
+
+		HelpSynopsis:    pathConfigIssuersHelpSyn,
+		HelpDescription: pathConfigIssuersHelpDesc,
+	}
+}
+
+// Returns the certificate, CA chain or CRL of an issuer
+func pathFetchIssuer(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "issuer/" + framework.GenericNameRegex("issuer_ref") + `/(?P<kind>ca|ca/pem|ca_chain|crl|crl/pem|crl/delta|crl/delta/pem)`,
+		Fields: map[string]*framework.FieldSchema{
+			"issuer_ref": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: `The ID or name of the issuer.`,
+			},
+			"kind": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: `What to fetch.`,
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation: b.pathFetchIssuerRead,
+		},
+
+		HelpSynopsis:    pathFetchIssuerHelpSyn,
+		HelpDescription: pathFetchIssuerHelpDesc,
+	}
+}
+
+func (b *backend) pathIssuerList(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	ids, err := listIssuers(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	config, err := fetchIssuerConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	keyInfo := make(map[string]interface{}, len(ids))
+	for _, id := range ids {
+		issuer, err := fetchIssuer(ctx, req.Storage, id)
+		if err != nil {
+			return nil, err
+		}
+		if issuer == nil {
+			continue
+		}
+		keyInfo[id] = map[string]interface{}{
+			"issuer_name":   issuer.Name,
+			"serial_number": issuer.Bundle.SerialNumber,
+			"is_default":    id == config.DefaultIssuerID,
+		}
+	}
+
+	return logical.ListResponseWithInfo(ids, keyInfo), nil
+}
+
+func (b *backend) pathIssuerExistenceCheck(ctx context.Context, req *logical.Request, data *framework.FieldData) (bool, error) {
+	issuer, err := b.issuerByRef(ctx, req.Storage, data.Get("issuer_ref").(string))
+	if err != nil {
+		return false, err
+	}
+	return issuer != nil, nil
+}
+
+// issuerByRef returns the referenced issuer, or nil if there is none
+func (b *backend) issuerByRef(ctx context.Context, s logical.Storage, ref string) (*issuerEntry, error) {
+	id, err := resolveIssuerRef(ctx, s, ref)
+	if err != nil {
+		if _, ok := err.(errutil.UserError); ok {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return fetchIssuer(ctx, s, id)
+}
+
+func (b *backend) pathIssuerRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	issuer, err := b.issuerByRef(ctx, req.Storage, data.Get("issuer_ref").(string))
+	if err != nil {
+		return nil, err
+	}
+	if issuer == nil {
+		return nil, nil
+	}
+
+	return issuerResponse(ctx, req.Storage, issuer)
+}
+
+func (b *backend) pathIssuerWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	issuer, err := b.issuerByRef(ctx, req.Storage, data.Get("issuer_ref").(string))
+	if err != nil {
+		return nil, err
+	}
+	if issuer == nil {
+		return logical.ErrorResponse("unknown issuer"), nil
+	}
+
+	if nameRaw, ok := data.GetOk("issuer_name"); ok {
+		name := nameRaw.(string)
+		if err := validateIssuerName(ctx, req.Storage, name, issuer.ID); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+		issuer.Name = name
+	}
+
+	if err := putIssuer(ctx, req.Storage, issuer); err != nil {
+		return nil, err
+	}
+
+	return issuerResponse(ctx, req.Storage, issuer)
+}
+
+func (b *backend) pathIssuerDelete(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	issuer, err := b.issuerByRef(ctx, req.Storage, data.Get("issuer_ref").(string))
+	if err != nil {
+		return nil, err
+	}
+	if issuer == nil {
+		return nil, nil
+	}
+
+	ids, err := listIssuers(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	config, err := fetchIssuerConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	isDefault := issuer.ID == config.DefaultIssuerID
+	if isDefault && len(ids) > 1 {
+		return logical.ErrorResponse("cannot delete the default issuer while other issuers exist; make another issuer the default first"), nil
+	}
+
+	if err := req.Storage.Delete(ctx, "issuers/"+issuer.ID); err != nil {
+		return nil, err
+	}
+	if err := req.Storage.Delete(ctx, "crls/"+issuer.ID); err != nil {
+		return nil, err
+	}
+	if err := req.Storage.Delete(ctx, "delta-crls/"+issuer.ID); err != nil {
+		return nil, err
+	}
+	if isDefault {
+		if err := req.Storage.Delete(ctx, "config/issuers"); err != nil {
+			return nil, err
+		}
+	}
+
+	// The issuer no longer answers OCSP requests
+	b.ocspCache.purge()
+
+	return nil, nil
+}
+
+func (b *backend) pathConfigIssuersRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	config, err := fetchIssuerConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"default": config.DefaultIssuerID,
+		},
+	}, nil
+}
+
+func (b *backend) pathConfigIssuersWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	ref := data.Get("default").(string)
+	if ref == "" || ref == defaultIssuerRef {
+		return logical.ErrorResponse("no issuer provided in the \"default\" parameter"), nil
+	}
+
+	issuer, err := b.issuerByRef(ctx, req.Storage, ref)
+	if err != nil {
+		return nil, err
+	}
+	if issuer == nil {
+		return logical.ErrorResponse(fmt.Sprintf("unknown issuer %q", ref)), nil
+	}
+
+	if err := setDefaultIssuer(ctx, req.Storage, issuer); err != nil {
+		return nil, err
+	}
+
+	// The "crl" endpoints now serve the CRL of the new default issuer
+	if err := buildCRL(ctx, b, req, true); err != nil {
+		switch err.(type) {
+		case errutil.UserError:
+			return logical.ErrorResponse(err.Error()), nil
+		default:
+			return nil, err
+		}
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"default": issuer.ID,
+		},
+	}, nil
+}
+
+func (b *backend) pathFetchIssuerRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	kind := data.Get("kind").(string)
+
+	var contentType string
+	var certificate []byte
+	var retErr error
+
+	switch kind {
+	case "ca", "ca/pem", "ca_chain":
+		contentType = "application/pkix-cert"
+	case "crl", "crl/pem", "crl/delta", "crl/delta/pem":
+		contentType = "application/pkix-crl"
+	}
+
+	issuer, err := b.issuerByRef(ctx, req.Storage, data.Get("issuer_ref").(string))
+	if err != nil {
+		retErr = err
+		goto reply
+	}
+	if issuer == nil {
+		goto reply
+	}
+
+	switch kind {
+	case "ca", "ca/pem":
+		parsedBundle, err := issuer.Bundle.ToParsedCertBundle()
+		if err != nil {
+			retErr = err
+			goto reply
+		}
+		certificate = parsedBundle.CertificateBytes
+		if kind == "ca/pem" {
+			certificate = []byte(strings.TrimSpace(issuer.Bundle.Certificate))
+		}
+
+	case "ca_chain":
+		caInfo, err := issuer.caInfo(ctx, req)
+		if err != nil {
+			retErr = err
+			goto reply
+		}
+		var chain []string
+		for _, ca := range caInfo.GetCAChain() {
+			chain = append(chain, strings.TrimSpace(string(pem.EncodeToMemory(&pem.Block{
+				Type:  "CERTIFICATE",
+				Bytes: ca.Bytes,
+			}))))
+		}
+		certificate = []byte(strings.Join(chain, "\n"))
+
+	case "crl", "crl/pem", "crl/delta", "crl/delta/pem":
+		key := "crls/" + issuer.ID
+		if strings.HasPrefix(kind, "crl/delta") {
+			key = "delta-crls/" + issuer.ID
+		}
+		entry, err := req.Storage.Get(ctx, key)
+		if err != nil {
+			retErr = err
+			goto reply
+		}
+		if entry == nil {
+			goto reply
+		}
+		certificate = entry.Value
+		if strings.HasSuffix(kind, "/pem") {
+			certificate = []byte(strings.TrimSpace(string(pem.EncodeToMemory(&pem.Block{
+				Type:  "X509 CRL",
+				Bytes: entry.Value,
+			}))))
+		}
+	}
+
+reply:
+	if retErr != nil && b.Logger().IsWarn() {
+		b.Logger().Warn("Possible error, but cannot return in raw response", "error", retErr)
+	}
+
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			logical.HTTPContentType: contentType,
+			logical.HTTPRawBody:     certificate,
+			logical.HTTPStatusCode:  200,
+		},
+	}
+	if len(certificate) == 0 {
+		resp.Data[logical.HTTPStatusCode] = 204
+	}
+	return resp, nil
+}
+
+// issuerResponse describes the issuer, without its private key
+func issuerResponse(ctx context.Context, s logical.Storage, issuer *issuerEntry) (*logical.Response, error) {
+	config, err := fetchIssuerConfig(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+
+	parsedBundle, err := issuer.Bundle.ToParsedCertBundle()
+	if err != nil {
+		return nil, err
+	}
+
+	caChain := []string{}
+	for _, ca := range issuer.Bundle.CAChain {
+		caChain = append(caChain, strings.TrimSpace(ca))
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"issuer_id":     issuer.ID,
+			"issuer_name":   issuer.Name,
+			"certificate":   strings.TrimSpace(issuer.Bundle.Certificate),
+			"ca_chain":      caChain,
+			"serial_number": issuer.Bundle.SerialNumber,
+			"expiration":    parsedBundle.Certificate.NotAfter.Unix(),
+			"is_default":    issuer.ID == config.DefaultIssuerID,
+		},
+	}, nil
+}
+
+// nonDefaultIssuerResponse describes a new issuer that doesn't sign
+// certificates yet, pointing at config/issuers. A new default issuer replaces
+// the CA of the mount like before multiple issuers were supported, and gets
+// no response.
+func nonDefaultIssuerResponse(ctx context.Context, req *logical.Request, issuer *issuerEntry) (*logical.Response, error) {
+	resp, err := issuerResponse(ctx, req.Storage, issuer)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Data["is_default"].(bool) {
+		return nil, nil
+	}
+
+	resp.AddWarning(fmt.Sprintf("The new issuer is not the default issuer of the mount; to issue certificates with it by default, set it as the default through %sconfig/issuers.", req.MountPoint))
+	return resp, nil
+}
+
+const pathListIssuersHelpSyn = `
+List the issuers of the mount.
+`
+
+const pathListIssuersHelpDesc = `
+This lists the IDs of the issuers of the mount, along with their names and
+whether they are the default issuer.
+`
+
+const pathIssuersHelpSyn = `
+Read, rename or delete an issuer of the mount.
+`
+
+const pathIssuersHelpDesc = `
+This endpoint manages an issuer referenced by its ID or its name. Its
+certificate can be read, its name changed, and the issuer deleted along with
+its private key. The default issuer can only be deleted when it is the last
+one.
+`
+
+const pathConfigIssuersHelpSyn = `
+Read or set the default issuer of the mount.
+`
+
+const pathConfigIssuersHelpDesc = `
+The default issuer signs the certificates of the roles and requests that
+don't reference an issuer, and is served by the "ca" and "crl" endpoints.
+`
+
+const pathFetchIssuerHelpSyn = `
+Fetch the CA certificate, CA chain, CRL, or delta CRL of an issuer.
+`
+
+const pathFetchIssuerHelpDesc = `
+This fetches the CA certificate of the issuer in DER encoding from "ca", or PEM
+encoding from "ca/pem", its CA chain in PEM encoding from "ca_chain", and its
+CRL in DER encoding from "crl", or PEM encoding from "crl/pem". When delta CRLs
+are enabled, the delta CRL is fetched from "crl/delta" and "crl/delta/pem".
+`
@@ -0,0 +1,530 @@
+package pki
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/helper/certutil"
+	"github.com/hashicorp/vault/logical"
+)
+
+func issuersTestRequest(t *testing.T, b *backend, s logical.Storage, op logical.Operation, path string, data map[string]interface{}) *logical.Response {
+	t.Helper()
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: op,
+		Path:      path,
+		Storage:   s,
+		Data:      data,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("bad: %s %s: err: %v resp: %#v", op, path, err, resp)
+	}
+	return resp
+}
+
+func issuersTestParseCert(t *testing.T, pemCert string) *x509.Certificate {
+	t.Helper()
+
+	block, _ := pem.Decode([]byte(pemCert))
+	if block == nil {
+		t.Fatalf("bad PEM certificate: %q", pemCert)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func issuersTestCRLSerials(t *testing.T, b *backend, s logical.Storage, path string) map[string]bool {
+	t.Helper()
+
+	resp := issuersTestRequest(t, b, s, logical.ReadOperation, path, nil)
+	crl, err := x509.ParseCRL(resp.Data[logical.HTTPRawBody].([]byte))
+	if err != nil {
+		t.Fatal(err)
+	}
+	serials := make(map[string]bool)
+	for _, revoked := range crl.TBSCertList.RevokedCertificates {
+		serials[certutil.GetHexFormatted(revoked.SerialNumber.Bytes(), ":")] = true
+	}
+	return serials
+}
+
+func TestPki_MultipleIssuers(t *testing.T) {
+	b, s := createBackendWithStorage(t)
+
+	resp := issuersTestRequest(t, b, s, logical.UpdateOperation, "root/generate/internal", map[string]interface{}{
+		"common_name": "Old Root",
+		"ttl":         "40h",
+		"issuer_name": "old",
+	})
+	oldRoot := issuersTestParseCert(t, resp.Data["certificate"].(string))
+	oldID := resp.Data["issuer_id"].(string)
+
+	// Generating another root over the existing one is refused
+	resp = issuersTestRequest(t, b, s, logical.UpdateOperation, "root/generate/internal", map[string]interface{}{
+		"common_name": "Other Root",
+	})
+	if len(resp.Warnings) == 0 {
+		t.Fatal("expected a warning")
+	}
+
+	resp = issuersTestRequest(t, b, s, logical.UpdateOperation, "root/rotate/internal", map[string]interface{}{
+		"common_name": "New Root",
+		"ttl":         "80h",
+		"issuer_name": "new",
+	})
+	newRoot := issuersTestParseCert(t, resp.Data["certificate"].(string))
+	newID := resp.Data["issuer_id"].(string)
+
+	// Issuer names must be unique
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "root/rotate/internal",
+		Storage:   s,
+		Data: map[string]interface{}{
+			"common_name": "Newer Root",
+			"issuer_name": "new",
+		},
+	})
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error, got err: %v resp: %#v", err, resp)
+	}
+
+	resp = issuersTestRequest(t, b, s, logical.ListOperation, "issuers/", nil)
+	if keys := resp.Data["keys"].([]string); len(keys) != 2 {
+		t.Fatalf("bad: issuers: %v", keys)
+	}
+	keyInfo := resp.Data["key_info"].(map[string]interface{})
+	if !keyInfo[oldID].(map[string]interface{})["is_default"].(bool) {
+		t.Fatal("the first issuer should be the default one")
+	}
+
+	issuersTestRequest(t, b, s, logical.UpdateOperation, "roles/example", map[string]interface{}{
+		"allowed_domains":  "example.com",
+		"allow_subdomains": true,
+		"ttl":              "1h",
+	})
+	issuersTestRequest(t, b, s, logical.UpdateOperation, "roles/pinned", map[string]interface{}{
+		"allowed_domains":  "example.com",
+		"allow_subdomains": true,
+		"ttl":              "1h",
+		"issuer_ref":       "new",
+	})
+
+	issue := func(role, issuerRef string, issuer *x509.Certificate) string {
+		t.Helper()
+
+		data := map[string]interface{}{
+			"common_name": "test.example.com",
+		}
+		if issuerRef != "" {
+			data["issuer_ref"] = issuerRef
+		}
+		resp := issuersTestRequest(t, b, s, logical.UpdateOperation, "issue/"+role, data)
+		cert := issuersTestParseCert(t, resp.Data["certificate"].(string))
+		if err := cert.CheckSignatureFrom(issuer); err != nil {
+			t.Fatalf("certificate of role %q and issuer %q not issued by %q: %v", role, issuerRef, issuer.Subject.CommonName, err)
+		}
+		return resp.Data["serial_number"].(string)
+	}
+
+	oldSerial := issue("example", "", oldRoot)
+	issue("example", "new", newRoot)
+	issue("example", newID, newRoot)
+	newSerial := issue("pinned", "", newRoot)
+	issue("pinned", "old", oldRoot)
+
+	// Each issuer lists the certificates it issued in its own CRL
+	for _, serial := range []string{oldSerial, newSerial} {
+		issuersTestRequest(t, b, s, logical.UpdateOperation, "revoke", map[string]interface{}{
+			"serial_number": serial,
+		})
+	}
+	oldCRL := issuersTestCRLSerials(t, b, s, "issuer/old/crl")
+	newCRL := issuersTestCRLSerials(t, b, s, "issuer/"+newID+"/crl")
+	if !oldCRL[oldSerial] || oldCRL[newSerial] {
+		t.Fatalf("bad: CRL of the old issuer: %v", oldCRL)
+	}
+	if !newCRL[newSerial] || newCRL[oldSerial] {
+		t.Fatalf("bad: CRL of the new issuer: %v", newCRL)
+	}
+	if defaultCRL := issuersTestCRLSerials(t, b, s, "crl"); !defaultCRL[oldSerial] || defaultCRL[newSerial] {
+		t.Fatalf("bad: CRL of the default issuer: %v", defaultCRL)
+	}
+
+	// Issuers can't be revoked
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "revoke",
+		Storage:   s,
+		Data: map[string]interface{}{
+			"serial_number": certutil.GetHexFormatted(newRoot.SerialNumber.Bytes(), ":"),
+		},
+	})
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error, got err: %v resp: %#v", err, resp)
+	}
+
+	// Clients trusting only the old root can validate the certificates of the
+	// new one through the cross-signed certificate
+	resp = issuersTestRequest(t, b, s, logical.UpdateOperation, "intermediate/cross-sign", map[string]interface{}{
+		"issuer_ref":         "new",
+		"signing_issuer_ref": "old",
+		"add_to_chain":       true,
+	})
+	crossSigned := issuersTestParseCert(t, resp.Data["certificate"].(string))
+	if err := crossSigned.CheckSignatureFrom(oldRoot); err != nil {
+		t.Fatal(err)
+	}
+
+	resp = issuersTestRequest(t, b, s, logical.UpdateOperation, "issue/pinned", map[string]interface{}{
+		"common_name": "test.example.com",
+	})
+	leaf := issuersTestParseCert(t, resp.Data["certificate"].(string))
+	if len(resp.Data["ca_chain"].([]string)) != 1 {
+		t.Fatalf("bad: CA chain: %v", resp.Data["ca_chain"])
+	}
+	oldPool := x509.NewCertPool()
+	oldPool.AddCert(oldRoot)
+	intermediates := x509.NewCertPool()
+	for _, ca := range resp.Data["ca_chain"].([]string) {
+		intermediates.AddCert(issuersTestParseCert(t, ca))
+	}
+	if _, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         oldPool,
+		Intermediates: intermediates,
+		DNSName:       "test.example.com",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Switching the default issuer
+	issuersTestRequest(t, b, s, logical.UpdateOperation, "config/issuers", map[string]interface{}{
+		"default": "new",
+	})
+	resp = issuersTestRequest(t, b, s, logical.ReadOperation, "config/issuers", nil)
+	if resp.Data["default"] != newID {
+		t.Fatalf("bad: default issuer: %v", resp.Data["default"])
+	}
+	issue("example", "", newRoot)
+	resp = issuersTestRequest(t, b, s, logical.ReadOperation, "ca", nil)
+	if ca, err := x509.ParseCertificate(resp.Data[logical.HTTPRawBody].([]byte)); err != nil || !ca.Equal(newRoot) {
+		t.Fatalf("bad: CA of the mount: %v", err)
+	}
+	if defaultCRL := issuersTestCRLSerials(t, b, s, "crl"); !defaultCRL[newSerial] {
+		t.Fatalf("bad: CRL of the default issuer: %v", defaultCRL)
+	}
+
+	// Renaming, then deleting the old issuer; the default one can only be
+	// deleted last
+	issuersTestRequest(t, b, s, logical.UpdateOperation, "issuers/old", map[string]interface{}{
+		"issuer_name": "retired",
+	})
+	resp = issuersTestRequest(t, b, s, logical.ReadOperation, "issuers/retired", nil)
+	if resp.Data["issuer_id"] != oldID || resp.Data["is_default"].(bool) {
+		t.Fatalf("bad: %#v", resp.Data)
+	}
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      "issuers/new",
+		Storage:   s,
+	})
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error, got err: %v resp: %#v", err, resp)
+	}
+	issuersTestRequest(t, b, s, logical.DeleteOperation, "issuers/retired", nil)
+	resp = issuersTestRequest(t, b, s, logical.ListOperation, "issuers/", nil)
+	if keys := resp.Data["keys"].([]string); len(keys) != 1 || keys[0] != newID {
+		t.Fatalf("bad: issuers: %v", keys)
+	}
+}
+
+func TestPki_IntermediateIssuer(t *testing.T) {
+	rootBackend, rootStorage := createBackendWithStorage(t)
+	b, s := createBackendWithStorage(t)
+
+	issuersTestRequest(t, rootBackend, rootStorage, logical.UpdateOperation, "root/generate/internal", map[string]interface{}{
+		"common_name": "Root",
+		"ttl":         "40h",
+	})
+
+	sign := func() string {
+		t.Helper()
+
+		resp := issuersTestRequest(t, b, s, logical.UpdateOperation, "intermediate/generate/internal", map[string]interface{}{
+			"common_name": "Intermediate",
+		})
+		resp = issuersTestRequest(t, rootBackend, rootStorage, logical.UpdateOperation, "root/sign-intermediate", map[string]interface{}{
+			"csr": resp.Data["csr"],
+			"ttl": "20h",
+		})
+		return resp.Data["certificate"].(string)
+	}
+
+	// The first intermediate replaces the CA of the mount
+	resp := issuersTestRequest(t, b, s, logical.UpdateOperation, "intermediate/set-signed", map[string]interface{}{
+		"certificate": sign(),
+		"issuer_name": "first",
+	})
+	if resp != nil {
+		t.Fatalf("expected no response, got %#v", resp)
+	}
+
+	// The next ones are added alongside it
+	resp = issuersTestRequest(t, b, s, logical.UpdateOperation, "intermediate/set-signed", map[string]interface{}{
+		"certificate": sign(),
+		"issuer_name": "second",
+	})
+	if resp == nil || resp.Data["issuer_name"] != "second" || resp.Data["is_default"].(bool) || len(resp.Warnings) == 0 {
+		t.Fatalf("bad: %#v", resp)
+	}
+
+	resp = issuersTestRequest(t, b, s, logical.ReadOperation, "issuers/default", nil)
+	if resp.Data["issuer_name"] != "first" {
+		t.Fatalf("bad: default issuer: %#v", resp.Data)
+	}
+}
+
+func TestPki_LegacyCAMigration(t *testing.T) {
+	b, s := createBackendWithStorage(t)
+
+	// Mounts created before multiple issuers were supported have a single CA
+	// bundle
+	resp := issuersTestRequest(t, b, s, logical.UpdateOperation, "root/generate/exported", map[string]interface{}{
+		"common_name": "Legacy Root",
+		"ttl":         "40h",
+	})
+	root := issuersTestParseCert(t, resp.Data["certificate"].(string))
+	cb := &certutil.CertBundle{
+		Certificate:    resp.Data["certificate"].(string),
+		PrivateKey:     resp.Data["private_key"].(string),
+		PrivateKeyType: resp.Data["private_key_type"].(certutil.PrivateKeyType),
+		SerialNumber:   resp.Data["serial_number"].(string),
+	}
+
+	b, s = createBackendWithStorage(t)
+	entry, err := logical.StorageEntryJSON("config/ca_bundle", cb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put(context.Background(), entry); err != nil {
+		t.Fatal(err)
+	}
+
+	issuersTestRequest(t, b, s, logical.UpdateOperation, "roles/example", map[string]interface{}{
+		"allowed_domains":  "example.com",
+		"allow_subdomains": true,
+		"ttl":              "1h",
+	})
+	resp = issuersTestRequest(t, b, s, logical.UpdateOperation, "issue/example", map[string]interface{}{
+		"common_name": "test.example.com",
+	})
+	if err := issuersTestParseCert(t, resp.Data["certificate"].(string)).CheckSignatureFrom(root); err != nil {
+		t.Fatal(err)
+	}
+
+	resp = issuersTestRequest(t, b, s, logical.ListOperation, "issuers/", nil)
+	keys := resp.Data["keys"].([]string)
+	if len(keys) != 1 {
+		t.Fatalf("bad: issuers: %v", keys)
+	}
+	resp = issuersTestRequest(t, b, s, logical.ReadOperation, "config/issuers", nil)
+	if resp.Data["default"] != keys[0] {
+		t.Fatalf("bad: default issuer: %v", resp.Data["default"])
+	}
+
+	// The legacy CA bundle is kept for older versions, and isn't migrated
+	// again by another backend
+	entry, err = s.Get(context.Background(), "config/ca_bundle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry == nil {
+		t.Fatal("the legacy CA bundle should be kept")
+	}
+	config := logical.TestBackendConfig()
+	config.StorageView = s
+	b = Backend(config)
+	if err := b.Setup(context.Background(), config); err != nil {
+		t.Fatal(err)
+	}
+	resp = issuersTestRequest(t, b, s, logical.ListOperation, "issuers/", nil)
+	if reloaded := resp.Data["keys"].([]string); len(reloaded) != 1 || reloaded[0] != keys[0] {
+		t.Fatalf("bad: issuers: %v", reloaded)
+	}
+}
+
+func TestPki_CrossSignExternalCA(t *testing.T) {
+	b, s := createBackendWithStorage(t)
+
+	issuersTestRequest(t, b, s, logical.UpdateOperation, "root/generate/internal", map[string]interface{}{
+		"common_name": "New Root",
+		"ttl":         "40h",
+	})
+	resp := issuersTestRequest(t, b, s, logical.ReadOperation, "cert/ca", nil)
+	newRoot := issuersTestParseCert(t, resp.Data["certificate"].(string))
+
+	// A legacy CA whose key ID is computed differently, and a certificate it
+	// issued
+	legacyKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacyKeyID := []byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08}
+	legacyTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Legacy CA", Organization: []string{"Example"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(20 * time.Hour),
+		SubjectKeyId:          legacyKeyID,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	legacyCertBytes, err := x509.CreateCertificate(rand.Reader, legacyTemplate, legacyTemplate, legacyKey.Public(), legacyKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacyCert, err := x509.ParseCertificate(legacyCertBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leafBytes, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "legacy.example.com"},
+		DNSNames:     []string{"legacy.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(10 * time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, legacyCert, leafKey.Public(), legacyKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(leafBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	csrBytes, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		RawSubject: legacyCert.RawSubject,
+	}, legacyKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csrBytes}))
+
+	for _, data := range []map[string]interface{}{
+		{},
+		{"csr": csr, "issuer_ref": "default"},
+		{"csr": csr, "key_id": "zz"},
+		{"csr": csr, "add_to_chain": true},
+		{"csr": "not a CSR"},
+		{"issuer_ref": "default", "key_id": "01:02"},
+	} {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "intermediate/cross-sign",
+			Storage:   s,
+			Data:      data,
+		})
+		if err != nil || resp == nil || !resp.IsError() {
+			t.Fatalf("expected an error for %v, got err: %v resp: %#v", data, err, resp)
+		}
+	}
+
+	// Without a key ID, the certificate gets the one of this backend
+	resp = issuersTestRequest(t, b, s, logical.UpdateOperation, "intermediate/cross-sign", map[string]interface{}{
+		"csr": csr,
+	})
+	crossSigned := issuersTestParseCert(t, resp.Data["certificate"].(string))
+	marshaledKey, err := x509.MarshalPKIXPublicKey(legacyKey.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyID := sha1.Sum(marshaledKey); !bytes.Equal(crossSigned.SubjectKeyId, keyID[:]) {
+		t.Fatalf("bad: key ID %x", crossSigned.SubjectKeyId)
+	}
+
+	// With the key ID of the legacy CA, the certificates it issued chain to
+	// the new root
+	resp = issuersTestRequest(t, b, s, logical.UpdateOperation, "intermediate/cross-sign", map[string]interface{}{
+		"csr":    csr,
+		"key_id": certutil.GetHexFormatted(legacyKeyID, ":"),
+	})
+	crossSigned = issuersTestParseCert(t, resp.Data["certificate"].(string))
+	if !bytes.Equal(crossSigned.RawSubject, legacyCert.RawSubject) || !bytes.Equal(crossSigned.SubjectKeyId, legacyKeyID) ||
+		!crossSigned.IsCA || !bytes.Equal(crossSigned.AuthorityKeyId, newRoot.SubjectKeyId) {
+		t.Fatalf("bad: cross-signed certificate: %#v", crossSigned)
+	}
+	if err := crossSigned.CheckSignatureFrom(newRoot); err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(newRoot)
+	intermediates := x509.NewCertPool()
+	intermediates.AddCert(crossSigned)
+	if _, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		DNSName:       "legacy.example.com",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The legacy CA can then be imported with its key, which must match the
+	// certificate
+	keyBytes, err := x509.MarshalECPrivateKey(legacyKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKeyBytes, err := x509.MarshalECPrivateKey(otherKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := resp.Data["certificate"].(string)
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config/ca",
+		Storage:   s,
+		Data: map[string]interface{}{
+			"pem_bundle": string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: otherKeyBytes})) + certPEM,
+		},
+	})
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error for a key not matching the certificate, got err: %v resp: %#v", err, resp)
+	}
+	resp = issuersTestRequest(t, b, s, logical.ListOperation, "issuers/", nil)
+	if keys := resp.Data["keys"].([]string); len(keys) != 1 {
+		t.Fatalf("bad: issuers: %v", keys)
+	}
+
+	issuersTestRequest(t, b, s, logical.UpdateOperation, "config/ca", map[string]interface{}{
+		"pem_bundle":  string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes})) + certPEM,
+		"issuer_name": "legacy",
+	})
+	resp = issuersTestRequest(t, b, s, logical.ReadOperation, "issuers/legacy", nil)
+	if resp.Data["issuer_name"] != "legacy" {
+		t.Fatalf("bad: %#v", resp.Data)
+	}
+}
@@ -0,0 +1,448 @@
+package pki
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault/helper/certutil"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+	"golang.org/x/crypto/ocsp"
+)
+
+const (
+	ocspContentType = "application/ocsp-response"
+
+	// ocspCacheMaxEntries bounds the number of cached OCSP responses
+	ocspCacheMaxEntries = 10000
+)
+
+func pathOCSP(b *backend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "ocsp",
+
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.UpdateOperation: b.pathOCSPPost,
+			},
+
+			HelpSynopsis:    pathOCSPHelpSyn,
+			HelpDescription: pathOCSPHelpDesc,
+		},
+		{
+			Pattern: "ocsp/(?P<req>.+)",
+			Fields: map[string]*framework.FieldSchema{
+				"req": &framework.FieldSchema{
+					Type:        framework.TypeString,
+					Description: `The base64-encoded DER OCSP request.`,
+				},
+			},
+
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ReadOperation: b.pathOCSPGet,
+			},
+
+			HelpSynopsis:    pathOCSPHelpSyn,
+			HelpDescription: pathOCSPHelpDesc,
+		},
+	}
+}
+
+// ocspCache holds signed OCSP responses until their next update, and the
+// issuers by the hashes of their name and key. It is purged whenever the
+// revocation information or the issuers may change, which starts a new
+// generation: responses built from storage read during an older generation
+// are not cached.
+type ocspCache struct {
+	l          sync.Mutex
+	generation uint64
+	responses  map[string]*ocspCacheEntry
+	issuers    map[crypto.Hash]map[string]*issuerEntry
+}
+
+type ocspCacheEntry struct {
+	response   []byte
+	thisUpdate time.Time
+	nextUpdate time.Time
+}
+
+func newOCSPCache() *ocspCache {
+	return &ocspCache{
+		responses: make(map[string]*ocspCacheEntry),
+		issuers:   make(map[crypto.Hash]map[string]*issuerEntry),
+	}
+}
+
+// currentGeneration returns the generation to pass to put for a response
+// built from storage read from now on
+func (c *ocspCache) currentGeneration() uint64 {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	return c.generation
+}
+
+func (c *ocspCache) get(key string) *ocspCacheEntry {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	entry := c.responses[key]
+	if entry == nil {
+		return nil
+	}
+	if time.Now().After(entry.nextUpdate) {
+		delete(c.responses, key)
+		return nil
+	}
+	return entry
+}
+
+// put caches the response unless the cache was purged since generation
+func (c *ocspCache) put(key string, entry *ocspCacheEntry, generation uint64) {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	if generation != c.generation {
+		return
+	}
+
+	if len(c.responses) >= ocspCacheMaxEntries {
+		now := time.Now()
+		for k, e := range c.responses {
+			if now.After(e.nextUpdate) {
+				delete(c.responses, k)
+			}
+		}
+		if len(c.responses) >= ocspCacheMaxEntries {
+			c.responses = make(map[string]*ocspCacheEntry)
+		}
+	}
+	c.responses[key] = entry
+}
+
+// getIssuers returns the issuers by the hashes of their name and key for the
+// hash algorithm, if they are cached
+func (c *ocspCache) getIssuers(hash crypto.Hash) (map[string]*issuerEntry, bool) {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	issuers, ok := c.issuers[hash]
+	return issuers, ok
+}
+
+// putIssuers caches the issuers unless the cache was purged since generation
+func (c *ocspCache) putIssuers(hash crypto.Hash, issuers map[string]*issuerEntry, generation uint64) {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	if generation == c.generation {
+		c.issuers[hash] = issuers
+	}
+}
+
+func (c *ocspCache) purge() {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	c.generation++
+	c.responses = make(map[string]*ocspCacheEntry)
+	c.issuers = make(map[crypto.Hash]map[string]*issuerEntry)
+}
+
+func (b *backend) pathOCSPPost(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	body, _ := req.Data[logical.HTTPRawBody].([]byte)
+	return b.ocspResponse(ctx, req, body), nil
+}
+
+func (b *backend) pathOCSPGet(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	encoded := data.Get("req").(string)
+	body, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		body = ocspRestoreSlashes(encoded, (4-len(encoded)%4)%4)
+		if body == nil {
+			return ocspRawResponse(ocsp.MalformedRequestErrorResponse, nil), nil
+		}
+	}
+	return b.ocspResponse(ctx, req, body), nil
+}
+
+// ocspRestoreSlashes returns the OCSP request encoded in the base64 string
+// once up to missing slashes are restored, or nil if there is none. The HTTP
+// server cleans request paths, which collapses a "//" in the encoding of GET
+// requests to "/".
+func ocspRestoreSlashes(encoded string, missing int) []byte {
+	if missing == 0 {
+		body, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil
+		}
+		if _, err := ocsp.ParseRequest(body); err != nil {
+			return nil
+		}
+		return body
+	}
+
+	for i := 0; i < len(encoded); i++ {
+		if encoded[i] != '/' {
+			continue
+		}
+		if body := ocspRestoreSlashes(encoded[:i]+"/"+encoded[i:], missing-1); body != nil {
+			return body
+		}
+	}
+	return nil
+}
+
+// ocspResponse answers the DER OCSP request as described in RFC 6960, and
+// the lightweight profile of RFC 5019. Failures are OCSP error responses
+// rather than Vault errors, which OCSP clients don't understand.
+func (b *backend) ocspResponse(ctx context.Context, req *logical.Request, body []byte) *logical.Response {
+	ocspReq, err := ocsp.ParseRequest(body)
+	if err != nil {
+		return ocspRawResponse(ocsp.MalformedRequestErrorResponse, nil)
+	}
+	if !ocspReq.HashAlgorithm.Available() {
+		return ocspRawResponse(ocsp.MalformedRequestErrorResponse, nil)
+	}
+
+	entry, err := b.ocspSignedResponse(ctx, req, ocspReq)
+	if err != nil {
+		b.Logger().Error("error answering OCSP request", "error", err)
+		return ocspRawResponse(ocsp.InternalErrorErrorResponse, nil)
+	}
+	if entry == nil {
+		return ocspRawResponse(ocsp.UnauthorizedErrorResponse, nil)
+	}
+
+	// Responses to GET requests can be cached by HTTP proxies until their next
+	// update, as recommended in RFC 5019 section 6
+	var headers map[string][]string
+	if req.Operation == logical.ReadOperation {
+		maxAge := int(time.Until(entry.nextUpdate).Seconds())
+		if maxAge < 0 {
+			maxAge = 0
+		}
+		headers = map[string][]string{
+			"Last-Modified": []string{entry.thisUpdate.UTC().Format(http.TimeFormat)},
+			"Expires":       []string{entry.nextUpdate.UTC().Format(http.TimeFormat)},
+			"Cache-Control": []string{"max-age=" + strconv.Itoa(maxAge) + ", public, no-transform, must-revalidate"},
+		}
+	}
+
+	return ocspRawResponse(entry.response, headers)
+}
+
+// ocspSignedResponse returns the signed response for the certificate of the
+// request, or nil if none of the issuers of the mount issued it
+func (b *backend) ocspSignedResponse(ctx context.Context, req *logical.Request, ocspReq *ocsp.Request) (*ocspCacheEntry, error) {
+	// A revocation while the response is built purges the cache, so the
+	// response isn't cached
+	generation := b.ocspCache.currentGeneration()
+
+	config, err := b.OCSPConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if config != nil && config.Disable {
+		return nil, nil
+	}
+
+	issuer, err := b.ocspRequestIssuer(ctx, req.Storage, ocspReq, generation)
+	if err != nil || issuer == nil {
+		return nil, err
+	}
+
+	serial := certutil.GetHexFormatted(ocspReq.SerialNumber.Bytes(), ":")
+	cacheKey := fmt.Sprintf("%s/%s/%d", issuer.ID, normalizeSerial(serial), ocspReq.HashAlgorithm)
+	if entry := b.ocspCache.get(cacheKey); entry != nil {
+		return entry, nil
+	}
+
+	issuerBundle, err := issuer.Bundle.ToParsedCertBundle()
+	if err != nil {
+		return nil, err
+	}
+
+	lifetime, err := b.crlExpiry(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	template := ocsp.Response{
+		Status:       ocsp.Unknown,
+		SerialNumber: ocspReq.SerialNumber,
+		ThisUpdate:   now,
+		NextUpdate:   now.Add(lifetime),
+		IssuerHash:   ocspReq.HashAlgorithm,
+	}
+
+	certEntry, err := fetchCertBySerial(ctx, req, "certs/", serial)
+	if err != nil {
+		return nil, err
+	}
+	if certEntry != nil {
+		cert, err := x509.ParseCertificate(certEntry.Value)
+		if err != nil {
+			return nil, err
+		}
+		if issuedBy(cert, issuerBundle.Certificate) {
+			template.Status = ocsp.Good
+		}
+	}
+
+	if template.Status == ocsp.Good {
+		revokedEntry, err := fetchCertBySerial(ctx, req, "revoked/", serial)
+		if err != nil {
+			return nil, err
+		}
+		if revokedEntry != nil {
+			var revInfo revocationInfo
+			if err := revokedEntry.DecodeJSON(&revInfo); err != nil {
+				return nil, fmt.Errorf("error decoding revocation entry for serial %s: %s", serial, err)
+			}
+			template.Status = ocsp.Revoked
+			template.RevocationReason = ocsp.Unspecified
+			template.RevokedAt = time.Unix(revInfo.RevocationTime, 0).UTC()
+			if !revInfo.RevocationTimeUTC.IsZero() {
+				template.RevokedAt = revInfo.RevocationTimeUTC
+			}
+		}
+	}
+
+	// Responses are signed by the delegated responder of the issuer if it is
+	// still valid, or else the issuer itself
+	responderCert := issuerBundle.Certificate
+	var signer crypto.Signer = issuerBundle.PrivateKey
+	if config != nil && config.Responders[issuer.ID] != nil {
+		responderBundle, err := config.Responders[issuer.ID].ToParsedCertBundle()
+		if err != nil {
+			return nil, err
+		}
+		if now.Before(responderBundle.Certificate.NotAfter) {
+			responderCert = responderBundle.Certificate
+			signer = responderBundle.PrivateKey
+			template.Certificate = responderBundle.Certificate
+		}
+	}
+
+	// OCSP responses can't be signed with Ed25519 keys, so Ed25519 issuers
+	// only answer through a delegated responder
+	if _, ok := signer.Public().(ed25519.PublicKey); ok {
+		b.Logger().Warn("Ed25519 issuers can't sign OCSP responses; configure a delegated responder with an RSA or EC key", "issuer_id", issuer.ID)
+		return nil, nil
+	}
+
+	response, err := ocsp.CreateResponse(issuerBundle.Certificate, responderCert, template, signer)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &ocspCacheEntry{
+		response:   response,
+		thisUpdate: template.ThisUpdate,
+		nextUpdate: template.NextUpdate,
+	}
+
+	// Serials unknown now may be issued later
+	if template.Status != ocsp.Unknown {
+		b.ocspCache.put(cacheKey, entry, generation)
+	}
+
+	return entry, nil
+}
+
+// ocspRequestIssuer returns the issuer identified by the hashes of its name
+// and key in the request, or nil if there is none
+func (b *backend) ocspRequestIssuer(ctx context.Context, s logical.Storage, ocspReq *ocsp.Request, generation uint64) (*issuerEntry, error) {
+	issuers, ok := b.ocspCache.getIssuers(ocspReq.HashAlgorithm)
+	if !ok {
+		var err error
+		issuers, err = ocspIssuersByHash(ctx, s, ocspReq.HashAlgorithm)
+		if err != nil {
+			return nil, err
+		}
+		b.ocspCache.putIssuers(ocspReq.HashAlgorithm, issuers, generation)
+	}
+
+	return issuers[string(ocspReq.IssuerNameHash)+string(ocspReq.IssuerKeyHash)], nil
+}
+
+// ocspIssuersByHash returns the issuers of the mount by the hashes of their
+// name and key with the hash algorithm
+func ocspIssuersByHash(ctx context.Context, s logical.Storage, hash crypto.Hash) (map[string]*issuerEntry, error) {
+	issuerIDs, err := listIssuers(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+
+	issuers := make(map[string]*issuerEntry, len(issuerIDs))
+	for _, id := range issuerIDs {
+		issuer, err := fetchIssuer(ctx, s, id)
+		if err != nil {
+			return nil, err
+		}
+		if issuer == nil {
+			continue
+		}
+		parsedBundle, err := issuer.Bundle.ToParsedCertBundle()
+		if err != nil {
+			return nil, err
+		}
+
+		var spki struct {
+			Algorithm pkix.AlgorithmIdentifier
+			PublicKey asn1.BitString
+		}
+		if _, err := asn1.Unmarshal(parsedBundle.Certificate.RawSubjectPublicKeyInfo, &spki); err != nil {
+			return nil, err
+		}
+
+		h := hash.New()
+		h.Write(parsedBundle.Certificate.RawSubject)
+		nameHash := h.Sum(nil)
+		h.Reset()
+		h.Write(spki.PublicKey.RightAlign())
+		keyHash := h.Sum(nil)
+
+		issuers[string(nameHash)+string(keyHash)] = issuer
+	}
+
+	return issuers, nil
+}
+
+func ocspRawResponse(response []byte, headers map[string][]string) *logical.Response {
+	return &logical.Response{
+		Data: map[string]interface{}{
+			logical.HTTPContentType: ocspContentType,
+			logical.HTTPRawBody:     response,
+			logical.HTTPStatusCode:  http.StatusOK,
+		},
+		Headers: headers,
+	}
+}
+
+const pathOCSPHelpSyn = `
+Query the revocation status of a certificate through OCSP.
+`
+
+const pathOCSPHelpDesc = `
+This endpoint is an OCSP responder, as described in RFC 6960. Requests are
+either POSTed to "ocsp" in DER encoding with the "application/ocsp-request"
+content type, or sent with GET to "ocsp/<request>" with the base64 encoding of
+the DER request.
+
+Responses are signed by the issuer of the certificate, or by its delegated
+responder set through "config/ocsp", and are valid for the "expiry" of the
+CRL configuration.
+`
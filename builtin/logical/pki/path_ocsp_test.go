@@ -0,0 +1,428 @@
+package pki
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/api"
+	vaulthttp "github.com/hashicorp/vault/http"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/vault"
+	"golang.org/x/crypto/ocsp"
+)
+
+// ocspTestQuery POSTs an OCSP request for the certificate to the backend and
+// parses the response
+func ocspTestQuery(t *testing.T, b *backend, s logical.Storage, cert, issuer *x509.Certificate) (*ocsp.Response, error) {
+	t.Helper()
+
+	ocspReq, err := ocsp.CreateRequest(cert, issuer, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := issuersTestRequest(t, b, s, logical.UpdateOperation, "ocsp", map[string]interface{}{
+		logical.HTTPRawBody: ocspReq,
+	})
+	if resp.Data[logical.HTTPContentType] != "application/ocsp-response" {
+		t.Fatalf("bad: %#v", resp.Data)
+	}
+	return ocsp.ParseResponseForCert(resp.Data[logical.HTTPRawBody].([]byte), cert, issuer)
+}
+
+func TestPki_OCSP(t *testing.T) {
+	b, s := createBackendWithStorage(t)
+
+	resp := issuersTestRequest(t, b, s, logical.UpdateOperation, "root/generate/internal", map[string]interface{}{
+		"common_name": "Root",
+		"ttl":         "40h",
+	})
+	root := issuersTestParseCert(t, resp.Data["certificate"].(string))
+
+	issuersTestRequest(t, b, s, logical.UpdateOperation, "roles/example", map[string]interface{}{
+		"allowed_domains":  "example.com",
+		"allow_subdomains": true,
+		"ttl":              "1h",
+	})
+	issuersTestRequest(t, b, s, logical.UpdateOperation, "roles/unstored", map[string]interface{}{
+		"allowed_domains":  "example.com",
+		"allow_subdomains": true,
+		"ttl":              "1h",
+		"no_store":         true,
+	})
+	issuersTestRequest(t, b, s, logical.UpdateOperation, "roles/responder", map[string]interface{}{
+		"allowed_domains":  "example.com",
+		"allow_subdomains": true,
+		"ttl":              "1h",
+		"ext_key_usage":    []string{"OCSPSigning"},
+	})
+	issuersTestRequest(t, b, s, logical.UpdateOperation, "config/crl", map[string]interface{}{
+		"expiry": "2h",
+	})
+
+	resp = issuersTestRequest(t, b, s, logical.UpdateOperation, "issue/example", map[string]interface{}{
+		"common_name": "test.example.com",
+	})
+	leaf := issuersTestParseCert(t, resp.Data["certificate"].(string))
+	serial := resp.Data["serial_number"].(string)
+
+	ocspResp, err := ocspTestQuery(t, b, s, leaf, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ocspResp.Status != ocsp.Good {
+		t.Fatalf("bad: status %d", ocspResp.Status)
+	}
+	if lifetime := ocspResp.NextUpdate.Sub(ocspResp.ThisUpdate); lifetime.Hours() != 2 {
+		t.Fatalf("bad: the response should be valid for the CRL expiry, got %s", lifetime)
+	}
+
+	// Revocations aren't hidden by the cached response
+	issuersTestRequest(t, b, s, logical.UpdateOperation, "revoke", map[string]interface{}{
+		"serial_number": serial,
+	})
+	ocspResp, err = ocspTestQuery(t, b, s, leaf, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ocspResp.Status != ocsp.Revoked || ocspResp.RevokedAt.IsZero() {
+		t.Fatalf("bad: status %d revoked at %s", ocspResp.Status, ocspResp.RevokedAt)
+	}
+
+	// Certificates that weren't stored are unknown
+	resp = issuersTestRequest(t, b, s, logical.UpdateOperation, "issue/unstored", map[string]interface{}{
+		"common_name": "test.example.com",
+	})
+	unstored := issuersTestParseCert(t, resp.Data["certificate"].(string))
+	ocspResp, err = ocspTestQuery(t, b, s, unstored, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ocspResp.Status != ocsp.Unknown {
+		t.Fatalf("bad: status %d", ocspResp.Status)
+	}
+
+	// The responses of a delegated responder embed its certificate
+	resp = issuersTestRequest(t, b, s, logical.UpdateOperation, "issue/responder", map[string]interface{}{
+		"common_name": "ocsp.example.com",
+	})
+	responder := issuersTestParseCert(t, resp.Data["certificate"].(string))
+	issuersTestRequest(t, b, s, logical.UpdateOperation, "config/ocsp", map[string]interface{}{
+		"responder_pem_bundle": resp.Data["certificate"].(string) + "\n" + resp.Data["private_key"].(string),
+	})
+	ocspResp, err = ocspTestQuery(t, b, s, leaf, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ocspResp.Status != ocsp.Revoked || ocspResp.Certificate == nil || !ocspResp.Certificate.Equal(responder) {
+		t.Fatalf("bad: status %d responder %v", ocspResp.Status, ocspResp.Certificate)
+	}
+
+	// Certificates without the OCSP signing usage can't be responders
+	resp = issuersTestRequest(t, b, s, logical.UpdateOperation, "issue/example", map[string]interface{}{
+		"common_name": "other.example.com",
+	})
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config/ocsp",
+		Storage:   s,
+		Data: map[string]interface{}{
+			"responder_pem_bundle": resp.Data["certificate"].(string) + "\n" + resp.Data["private_key"].(string),
+		},
+	})
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error, got err: %v resp: %#v", err, resp)
+	}
+
+	issuersTestRequest(t, b, s, logical.UpdateOperation, "config/ocsp", map[string]interface{}{
+		"remove_responder": "default",
+	})
+	ocspResp, err = ocspTestQuery(t, b, s, leaf, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ocspResp.Certificate != nil {
+		t.Fatal("the response should be signed by the issuer")
+	}
+
+	// Other CAs are unauthorized
+	otherBackend, otherStorage := createBackendWithStorage(t)
+	resp = issuersTestRequest(t, otherBackend, otherStorage, logical.UpdateOperation, "root/generate/internal", map[string]interface{}{
+		"common_name": "Other Root",
+	})
+	otherRoot := issuersTestParseCert(t, resp.Data["certificate"].(string))
+	if _, err := ocspTestQuery(t, b, s, leaf, otherRoot); err != (ocsp.ResponseError{Status: ocsp.Unauthorized}) {
+		t.Fatalf("expected an unauthorized response, got %v", err)
+	}
+
+	// The responder can be disabled
+	issuersTestRequest(t, b, s, logical.UpdateOperation, "config/ocsp", map[string]interface{}{
+		"disable": true,
+	})
+	if _, err := ocspTestQuery(t, b, s, leaf, root); err != (ocsp.ResponseError{Status: ocsp.Unauthorized}) {
+		t.Fatalf("expected an unauthorized response, got %v", err)
+	}
+}
+
+// ocspTestRevokingStorage runs revoke once, right after a revocation entry
+// was read and before the caller gets it
+type ocspTestRevokingStorage struct {
+	logical.Storage
+	revoke func()
+}
+
+func (s *ocspTestRevokingStorage) Get(ctx context.Context, key string) (*logical.StorageEntry, error) {
+	entry, err := s.Storage.Get(ctx, key)
+	if strings.HasPrefix(key, "revoked/") && s.revoke != nil {
+		revoke := s.revoke
+		s.revoke = nil
+		revoke()
+	}
+	return entry, err
+}
+
+func TestPki_OCSPRevokedWhileAnswering(t *testing.T) {
+	b, s := createBackendWithStorage(t)
+
+	resp := issuersTestRequest(t, b, s, logical.UpdateOperation, "root/generate/internal", map[string]interface{}{
+		"common_name": "Root",
+		"ttl":         "40h",
+	})
+	root := issuersTestParseCert(t, resp.Data["certificate"].(string))
+	issuersTestRequest(t, b, s, logical.UpdateOperation, "roles/example", map[string]interface{}{
+		"allowed_domains":  "example.com",
+		"allow_subdomains": true,
+		"ttl":              "1h",
+	})
+	resp = issuersTestRequest(t, b, s, logical.UpdateOperation, "issue/example", map[string]interface{}{
+		"common_name": "test.example.com",
+	})
+	leaf := issuersTestParseCert(t, resp.Data["certificate"].(string))
+	serial := resp.Data["serial_number"].(string)
+
+	// The certificate is revoked after the request found it unrevoked, so
+	// the good response it sends isn't cached
+	revoking := &ocspTestRevokingStorage{
+		Storage: s,
+		revoke: func() {
+			issuersTestRequest(t, b, s, logical.UpdateOperation, "revoke", map[string]interface{}{
+				"serial_number": serial,
+			})
+		},
+	}
+	ocspResp, err := ocspTestQuery(t, b, revoking, leaf, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ocspResp.Status != ocsp.Good {
+		t.Fatalf("bad: status %d", ocspResp.Status)
+	}
+
+	ocspResp, err = ocspTestQuery(t, b, s, leaf, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ocspResp.Status != ocsp.Revoked {
+		t.Fatalf("bad: status %d", ocspResp.Status)
+	}
+}
+
+func TestPki_OCSPRestoreSlashes(t *testing.T) {
+	for serial := int64(1); ; serial++ {
+		req := &ocsp.Request{
+			HashAlgorithm:  crypto.SHA1,
+			IssuerNameHash: make([]byte, 20),
+			IssuerKeyHash:  make([]byte, 20),
+			SerialNumber:   big.NewInt(serial),
+		}
+		body, err := req.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		encoded := base64.StdEncoding.EncodeToString(body)
+		if !strings.Contains(encoded, "//") {
+			continue
+		}
+
+		collapsed := strings.Replace(encoded, "//", "/", -1)
+		restored := ocspRestoreSlashes(collapsed, (4-len(collapsed)%4)%4)
+		if !bytes.Equal(restored, body) {
+			t.Fatalf("bad: %q restored from %q", restored, collapsed)
+		}
+		return
+	}
+}
+
+func TestPki_OCSPEd25519(t *testing.T) {
+	b, s := createBackendWithStorage(t)
+
+	resp := issuersTestRequest(t, b, s, logical.UpdateOperation, "root/generate/internal", map[string]interface{}{
+		"common_name": "Root",
+		"key_type":    "ed25519",
+		"ttl":         "40h",
+	})
+	root := issuersTestParseCert(t, resp.Data["certificate"].(string))
+
+	issuersTestRequest(t, b, s, logical.UpdateOperation, "roles/example", map[string]interface{}{
+		"allowed_domains":  "example.com",
+		"allow_subdomains": true,
+		"ttl":              "1h",
+	})
+	resp = issuersTestRequest(t, b, s, logical.UpdateOperation, "issue/example", map[string]interface{}{
+		"common_name": "test.example.com",
+	})
+	leaf := issuersTestParseCert(t, resp.Data["certificate"].(string))
+
+	// Ed25519 issuers can't sign the responses themselves
+	if _, err := ocspTestQuery(t, b, s, leaf, root); err != (ocsp.ResponseError{Status: ocsp.Unauthorized}) {
+		t.Fatalf("expected an unauthorized response, got %v", err)
+	}
+
+	// Nor can Ed25519 delegated responders
+	issuersTestRequest(t, b, s, logical.UpdateOperation, "roles/ed25519-responder", map[string]interface{}{
+		"allowed_domains":  "example.com",
+		"allow_subdomains": true,
+		"ttl":              "1h",
+		"key_type":         "ed25519",
+		"ext_key_usage":    []string{"OCSPSigning"},
+	})
+	resp = issuersTestRequest(t, b, s, logical.UpdateOperation, "issue/ed25519-responder", map[string]interface{}{
+		"common_name": "ocsp.example.com",
+	})
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config/ocsp",
+		Storage:   s,
+		Data: map[string]interface{}{
+			"responder_pem_bundle": resp.Data["certificate"].(string) + "\n" + resp.Data["private_key"].(string),
+		},
+	})
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error, got err: %v resp: %#v", err, resp)
+	}
+
+	// An EC delegated responder answers for them
+	issuersTestRequest(t, b, s, logical.UpdateOperation, "roles/responder", map[string]interface{}{
+		"allowed_domains":  "example.com",
+		"allow_subdomains": true,
+		"ttl":              "1h",
+		"key_type":         "ec",
+		"key_bits":         256,
+		"ext_key_usage":    []string{"OCSPSigning"},
+	})
+	resp = issuersTestRequest(t, b, s, logical.UpdateOperation, "issue/responder", map[string]interface{}{
+		"common_name": "ocsp.example.com",
+	})
+	responder := issuersTestParseCert(t, resp.Data["certificate"].(string))
+	issuersTestRequest(t, b, s, logical.UpdateOperation, "config/ocsp", map[string]interface{}{
+		"responder_pem_bundle": resp.Data["certificate"].(string) + "\n" + resp.Data["private_key"].(string),
+	})
+	ocspResp, err := ocspTestQuery(t, b, s, leaf, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ocspResp.Status != ocsp.Good || ocspResp.Certificate == nil || !ocspResp.Certificate.Equal(responder) {
+		t.Fatalf("bad: status %d responder %v", ocspResp.Status, ocspResp.Certificate)
+	}
+}
+
+func TestBackend_OCSPHTTP(t *testing.T) {
+	coreConfig := &vault.CoreConfig{
+		LogicalBackends: map[string]logical.Factory{
+			"pki": Factory,
+		},
+	}
+	cluster := vault.NewTestCluster(t, coreConfig, &vault.TestClusterOptions{
+		HandlerFunc: vaulthttp.Handler,
+	})
+	cluster.Start()
+	defer cluster.Cleanup()
+
+	client := cluster.Cores[0].Client
+	err := client.Sys().Mount("pki", &api.MountInput{
+		Type: "pki",
+		Config: api.MountConfigInput{
+			DefaultLeaseTTL: "16h",
+			MaxLeaseTTL:     "32h",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, err := client.Logical().Write("pki/root/generate/internal", map[string]interface{}{
+		"common_name": "myvault.com",
+		"ttl":         "32h",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := issuersTestParseCert(t, secret.Data["certificate"].(string))
+	_, err = client.Logical().Write("pki/roles/example", map[string]interface{}{
+		"allowed_domains":  "example.com",
+		"allow_subdomains": true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, err = client.Logical().Write("pki/issue/example", map[string]interface{}{
+		"common_name": "test.example.com",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf := issuersTestParseCert(t, secret.Data["certificate"].(string))
+
+	ocspReq, err := ocsp.CreateRequest(leaf, root, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(cluster.CACertPEM)
+	httpClient := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: pool},
+		},
+	}
+
+	// OCSP clients don't authenticate
+	check := func(resp *http.Response, err error) *http.Response {
+		t.Helper()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/ocsp-response" {
+			t.Fatalf("bad: status %d, content type %q: %s", resp.StatusCode, resp.Header.Get("Content-Type"), body)
+		}
+		ocspResp, err := ocsp.ParseResponseForCert(body, leaf, root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ocspResp.Status != ocsp.Good {
+			t.Fatalf("bad: status %d", ocspResp.Status)
+		}
+		return resp
+	}
+
+	check(httpClient.Post(client.Address()+"/v1/pki/ocsp", "application/ocsp-request", bytes.NewReader(ocspReq)))
+
+	resp := check(httpClient.Get(client.Address() + "/v1/pki/ocsp/" + url.PathEscape(base64.StdEncoding.EncodeToString(ocspReq))))
+	if resp.Header.Get("Cache-Control") == "" || resp.Header.Get("Expires") == "" {
+		t.Fatalf("bad: caching headers %v", resp.Header)
+	}
+}
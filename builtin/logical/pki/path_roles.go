@@ -154,8 +154,8 @@ protection use. Defaults to false.`,
 			"key_type": &framework.FieldSchema{
 				Type:    framework.TypeString,
 				Default: "rsa",
-				Description: `The type of key to use; defaults to RSA. "rsa"
-and "ec" are the only valid values.`,
+				Description: `The type of key to use; defaults to RSA. "rsa",
+"ec" and "ed25519" are the only valid values.`,
 			},
 
 			"key_bits": &framework.FieldSchema{
@@ -286,6 +286,13 @@ for "generate_lease".`,
 				Type:        framework.TypeBool,
 				Description: `Mark Basic Constraints valid when issuing non-CA certificates.`,
 			},
+			"issuer_ref": &framework.FieldSchema{
+				Type:    framework.TypeString,
+				Default: defaultIssuerRef,
+				Description: `The ID or name of the issuer signing the
+certificates of this role. Defaults to "default", the default
+issuer of the mount at the time of issuance.`,
+			},
 		},
 
 		Callbacks: map[logical.Operation]framework.OperationFunc{
@@ -378,6 +385,11 @@ func (b *backend) getRole(ctx context.Context, s logical.Storage, n string) (*ro
 		modified = true
 	}
 
+	// Roles created before multiple issuers use the default one
+	if result.IssuerRef == "" {
+		result.IssuerRef = defaultIssuerRef
+	}
+
 	// Upgrade key usages
 	if result.KeyUsageOld != "" {
 		result.KeyUsage = strings.Split(result.KeyUsageOld, ",")
@@ -493,6 +505,16 @@ func (b *backend) pathRoleCreate(ctx context.Context, req *logical.Request, data
 		AllowedSerialNumbers:          data.Get("allowed_serial_numbers").([]string),
 		PolicyIdentifiers:             data.Get("policy_identifiers").([]string),
 		BasicConstraintsValidForNonCA: data.Get("basic_constraints_valid_for_non_ca").(bool),
+		IssuerRef:                     data.Get("issuer_ref").(string),
+	}
+
+	if entry.IssuerRef == "" {
+		entry.IssuerRef = defaultIssuerRef
+	}
+	if entry.IssuerRef != defaultIssuerRef {
+		if _, err := resolveIssuerRef(ctx, req.Storage, entry.IssuerRef); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
 	}
 
 	otherSANs := data.Get("allowed_other_sans").([]string)
@@ -683,6 +705,7 @@ type roleEntry struct {
 	PolicyIdentifiers             []string      `json:"policy_identifiers" mapstructure:"policy_identifiers"`
 	ExtKeyUsageOIDs               []string      `json:"ext_key_usage_oids" mapstructure:"ext_key_usage_oids"`
 	BasicConstraintsValidForNonCA bool          `json:"basic_constraints_valid_for_non_ca" mapstructure:"basic_constraints_valid_for_non_ca"`
+	IssuerRef                     string        `json:"issuer_ref" mapstructure:"issuer_ref"`
 
 	// Used internally for signing intermediates
 	AllowExpirationPastCA bool
@@ -726,6 +749,7 @@ func (r *roleEntry) ToResponseData() map[string]interface{} {
 		"require_cn":                         r.RequireCN,
 		"policy_identifiers":                 r.PolicyIdentifiers,
 		"basic_constraints_valid_for_non_ca": r.BasicConstraintsValidForNonCA,
+		"issuer_ref":                         r.IssuerRef,
 	}
 	if r.MaxPathLength != nil {
 		responseData["max_path_length"] = r.MaxPathLength
@@ -32,6 +32,29 @@ func pathGenerateRoot(b *backend) *framework.Path {
 	ret.Fields = addCACommonFields(map[string]*framework.FieldSchema{})
 	ret.Fields = addCAKeyGenerationFields(ret.Fields)
 	ret.Fields = addCAIssueFields(ret.Fields)
+	ret.Fields = addIssuerNameField(ret.Fields)
+	ret.Fields = addPKCS12Fields(ret.Fields)
+
+	return ret
+}
+
+func pathRotateRoot(b *backend) *framework.Path {
+	ret := &framework.Path{
+		Pattern: "root/rotate/" + framework.GenericNameRegex("exported"),
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: b.pathCARotateRoot,
+		},
+
+		HelpSynopsis:    pathRotateRootHelpSyn,
+		HelpDescription: pathRotateRootHelpDesc,
+	}
+
+	ret.Fields = addCACommonFields(map[string]*framework.FieldSchema{})
+	ret.Fields = addCAKeyGenerationFields(ret.Fields)
+	ret.Fields = addCAIssueFields(ret.Fields)
+	ret.Fields = addIssuerNameField(ret.Fields)
+	ret.Fields = addPKCS12Fields(ret.Fields)
 
 	return ret
 }
@@ -72,6 +95,8 @@ func pathSignIntermediate(b *backend) *framework.Path {
 		Description: `PEM-format CSR to be signed.`,
 	}
 
+	ret.Fields = addIssuerRefField(ret.Fields)
+
 	ret.Fields["use_csr_values"] = &framework.FieldSchema{
 		Type:    framework.TypeBool,
 		Default: false,
@@ -102,6 +127,7 @@ func pathSignSelfIssued(b *backend) *framework.Path {
 				Type:        framework.TypeString,
 				Description: `PEM-format self-issued certificate to be signed.`,
 			},
+			"issuer_ref": issuerRefField(),
 		},
 
 		HelpSynopsis:    pathSignSelfIssuedHelpSyn,
@@ -111,23 +137,64 @@ func pathSignSelfIssued(b *backend) *framework.Path {
 	return ret
 }
 
+// pathCADeleteRoot deletes all the issuers of the mount, along with any
+// pending intermediate key
 func (b *backend) pathCADeleteRoot(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
-	return nil, req.Storage.Delete(ctx, "config/ca_bundle")
+	issuerIDs, err := listIssuers(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range issuerIDs {
+		if err := req.Storage.Delete(ctx, "issuers/"+id); err != nil {
+			return nil, err
+		}
+		if err := req.Storage.Delete(ctx, "crls/"+id); err != nil {
+			return nil, err
+		}
+		if err := req.Storage.Delete(ctx, "delta-crls/"+id); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := req.Storage.Delete(ctx, "config/issuers"); err != nil {
+		return nil, err
+	}
+	b.ocspCache.purge()
+	return nil, req.Storage.Delete(ctx, "config/pending_intermediate")
 }
 
 func (b *backend) pathCAGenerateRoot(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
-	var err error
-
-	entry, err := req.Storage.Get(ctx, "config/ca_bundle")
+	issuerIDs, err := listIssuers(ctx, req.Storage)
 	if err != nil {
 		return nil, err
 	}
-	if entry != nil {
+	pendingEntry, err := req.Storage.Get(ctx, "config/pending_intermediate")
+	if err != nil {
+		return nil, err
+	}
+	if len(issuerIDs) > 0 || pendingEntry != nil {
 		resp := &logical.Response{}
-		resp.AddWarning(fmt.Sprintf("Refusing to generate a root certificate over an existing root certificate. If you really want to destroy the original root certificate, please issue a delete against %sroot.", req.MountPoint))
+		resp.AddWarning(fmt.Sprintf("Refusing to generate a root certificate over an existing root certificate. To add a new root alongside the existing one, use %sroot/rotate. If you really want to destroy the original root certificate, please issue a delete against %sroot.", req.MountPoint, req.MountPoint))
 		return resp, nil
 	}
 
+	return b.generateRoot(ctx, req, data)
+}
+
+// pathCARotateRoot generates a new root issuer, leaving the current ones
+// untouched so that both chains can be trusted during a migration
+func (b *backend) pathCARotateRoot(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	return b.generateRoot(ctx, req, data)
+}
+
+func (b *backend) generateRoot(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	var err error
+
+	issuerName := data.Get("issuer_name").(string)
+	if err := validateIssuerName(ctx, req.Storage, issuerName, ""); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
 	exported, format, role, errorResp := b.getGenerationParams(data)
 	if errorResp != nil {
 		return errorResp, nil
@@ -193,6 +260,14 @@ func (b *backend) pathCAGenerateRoot(ctx context.Context, req *logical.Request,
 			resp.Data["private_key"] = base64.StdEncoding.EncodeToString(parsedBundle.PrivateKeyBytes)
 			resp.Data["private_key_type"] = cb.PrivateKeyType
 		}
+
+	case "pkcs12":
+		resp.Data["issuing_ca"] = cb.Certificate
+		resp.Data["certificate"], err = pkcs12Response(parsedBundle, data)
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+		resp.Data["private_key_type"] = cb.PrivateKeyType
 	}
 
 	if data.Get("private_key_format").(string) == "pkcs8" {
@@ -202,35 +277,21 @@ func (b *backend) pathCAGenerateRoot(ctx context.Context, req *logical.Request,
 		}
 	}
 
-	// Store it as the CA bundle
-	entry, err = logical.StorageEntryJSON("config/ca_bundle", cb)
-	if err != nil {
-		return nil, err
-	}
-	err = req.Storage.Put(ctx, entry)
+	// Store it as a new issuer
+	issuer, err := storeIssuer(ctx, req.Storage, cb, issuerName)
 	if err != nil {
 		return nil, err
 	}
+	resp.Data["issuer_id"] = issuer.ID
+	resp.Data["issuer_name"] = issuer.Name
 
 	// Also store it as just the certificate identified by serial number, so it
 	// can be revoked
-	err = req.Storage.Put(ctx, &logical.StorageEntry{
-		Key:   "certs/" + normalizeSerial(cb.SerialNumber),
-		Value: parsedBundle.CertificateBytes,
-	})
+	err = storeCert(ctx, req.Storage, parsedBundle.Certificate, "", issuer.ID)
 	if err != nil {
 		return nil, errwrap.Wrapf("unable to store certificate locally: {{err}}", err)
 	}
 
-	// For ease of later use, also store just the certificate at a known
-	// location
-	entry.Key = "ca"
-	entry.Value = parsedBundle.CertificateBytes
-	err = req.Storage.Put(ctx, entry)
-	if err != nil {
-		return nil, err
-	}
-
 	// Build a fresh CRL
 	err = buildCRL(ctx, b, req, true)
 	if err != nil {
@@ -278,7 +339,7 @@ func (b *backend) pathCASignIntermediate(ctx context.Context, req *logical.Reque
 	}
 
 	var caErr error
-	signingBundle, caErr := fetchCAInfo(ctx, req)
+	signingBundle, caErr := fetchCAInfoByRef(ctx, req, data.Get("issuer_ref").(string))
 	switch caErr.(type) {
 	case errutil.UserError:
 		return nil, errutil.UserError{Err: fmt.Sprintf(
@@ -365,10 +426,7 @@ func (b *backend) pathCASignIntermediate(ctx context.Context, req *logical.Reque
 		}
 	}
 
-	err = req.Storage.Put(ctx, &logical.StorageEntry{
-		Key:   "certs/" + normalizeSerial(cb.SerialNumber),
-		Value: parsedBundle.CertificateBytes,
-	})
+	err = storeCert(ctx, req.Storage, parsedBundle.Certificate, "", signingBundle.IssuerID)
 	if err != nil {
 		return nil, errwrap.Wrapf("unable to store certificate locally: {{err}}", err)
 	}
@@ -405,7 +463,7 @@ func (b *backend) pathCASignSelfIssued(ctx context.Context, req *logical.Request
 	}
 
 	var caErr error
-	signingBundle, caErr := fetchCAInfo(ctx, req)
+	signingBundle, caErr := fetchCAInfoByRef(ctx, req, data.Get("issuer_ref").(string))
 	switch caErr.(type) {
 	case errutil.UserError:
 		return nil, errutil.UserError{Err: fmt.Sprintf(
@@ -456,8 +514,21 @@ const pathGenerateRootHelpDesc = `
 See the API documentation for more information.
 `
 
+const pathRotateRootHelpSyn = `
+Generate a new root CA certificate and private key, alongside the existing ones.
+`
+
+const pathRotateRootHelpDesc = `
+This endpoint generates a new root CA as a new issuer of the mount. Unlike
+"root/generate", it works when the mount already has issuers, and doesn't
+change the default issuer, so that certificates keep being issued by the
+current CA until the new one is made the default through "config/issuers".
+
+See the API documentation for more information.
+`
+
 const pathDeleteRootHelpSyn = `
-Deletes the root CA key to allow a new one to be generated.
+Deletes the CA keys of all the issuers to allow a new one to be generated.
 `
 
 const pathDeleteRootHelpDesc = `
@@ -3,17 +3,23 @@ package pki
 import (
 	"context"
 	"crypto/x509"
+	"errors"
 	"fmt"
 	"net/http"
 	"sync/atomic"
 	"time"
 
 	"github.com/hashicorp/errwrap"
+	log "github.com/hashicorp/go-hclog"
 	"github.com/hashicorp/vault/helper/consts"
 	"github.com/hashicorp/vault/logical"
 	"github.com/hashicorp/vault/logical/framework"
 )
 
+// defaultTidySafetyBuffer is the default safety_buffer of tidy, also used when
+// the CRLs are rebuilt automatically
+const defaultTidySafetyBuffer = 72 * time.Hour
+
 func pathTidy(b *backend) *framework.Path {
 	return &framework.Path{
 		Pattern: "tidy",
@@ -56,6 +62,73 @@ Defaults to 72 hours.`,
 	}
 }
 
+// tidyParams selects what a tidy operation removes. Source is "manual" for
+// tidy operations requested on the tidy endpoint and "auto" for auto-tidy.
+type tidyParams struct {
+	TidyCertStore    bool          `json:"tidy_cert_store"`
+	TidyRevokedCerts bool          `json:"tidy_revoked_certs"`
+	SafetyBuffer     time.Duration `json:"safety_buffer"`
+	Source           string        `json:"source"`
+}
+
+type tidyStatusState int
+
+const (
+	tidyStatusInactive tidyStatusState = iota
+	tidyStatusRunning
+	tidyStatusFinished
+	tidyStatusError
+)
+
+func (s tidyStatusState) String() string {
+	switch s {
+	case tidyStatusRunning:
+		return "Running"
+	case tidyStatusFinished:
+		return "Finished"
+	case tidyStatusError:
+		return "Error"
+	default:
+		return "Inactive"
+	}
+}
+
+// tidyStatus is the status of the last tidy operation
+type tidyStatus struct {
+	params                  *tidyParams
+	state                   tidyStatusState
+	err                     error
+	timeStarted             time.Time
+	timeFinished            time.Time
+	certStoreDeletedCount   uint
+	revokedCertDeletedCount uint
+}
+
+// tidyStatusEntry is the tidy status as stored at tidy-status, so that it and
+// the auto-tidy schedule survive restarts and changes of the active node
+type tidyStatusEntry struct {
+	Params                  *tidyParams     `json:"params"`
+	State                   tidyStatusState `json:"state"`
+	Error                   string          `json:"error"`
+	TimeStarted             time.Time       `json:"time_started"`
+	TimeFinished            time.Time       `json:"time_finished"`
+	CertStoreDeletedCount   uint            `json:"cert_store_deleted_count"`
+	RevokedCertDeletedCount uint            `json:"revoked_cert_deleted_count"`
+}
+
+func pathTidyStatus(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "tidy-status",
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation: b.pathTidyStatusRead,
+		},
+
+		HelpSynopsis:    pathTidyStatusHelpSyn,
+		HelpDescription: pathTidyStatusHelpDesc,
+	}
+}
+
 func (b *backend) pathTidyWrite(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
 	// If we are a performance standby forward the request to the active node
 	if b.System().ReplicationState().HasState(consts.ReplicationPerformanceStandby) {
@@ -71,140 +144,382 @@ func (b *backend) pathTidyWrite(ctx context.Context, req *logical.Request, d *fr
 		return logical.ErrorResponse("safety_buffer must be greater than zero"), nil
 	}
 
-	bufferDuration := time.Duration(safetyBuffer) * time.Second
+	params := &tidyParams{
+		TidyCertStore:    tidyCertStore,
+		TidyRevokedCerts: tidyRevokedCerts || tidyRevocationList,
+		SafetyBuffer:     time.Duration(safetyBuffer) * time.Second,
+		Source:           "manual",
+	}
 
-	if !atomic.CompareAndSwapUint32(b.tidyCASGuard, 0, 1) {
+	if !b.startTidy(req.Storage, params) {
 		resp := &logical.Response{}
 		resp.AddWarning("Tidy operation already in progress.")
 		return resp, nil
 	}
 
+	resp := &logical.Response{}
+	resp.AddWarning("Tidy operation successfully started. Any information from the operation will be printed to Vault's server logs and reported by the tidy-status endpoint.")
+	return logical.RespondWithStatusCode(resp, req, http.StatusAccepted)
+}
+
+// startTidy runs a tidy operation in the background, unless one is already
+// running, and returns whether it was started
+func (b *backend) startTidy(s logical.Storage, params *tidyParams) bool {
+	if !atomic.CompareAndSwapUint32(b.tidyCASGuard, 0, 1) {
+		return false
+	}
+
+	b.tidyStatusLock.Lock()
+	b.tidyStatus = &tidyStatus{
+		params:      params,
+		state:       tidyStatusRunning,
+		timeStarted: time.Now(),
+	}
+	b.lastTidy = b.tidyStatus.timeStarted
+	b.tidyStatusLoaded = true
+	b.tidyStatusLock.Unlock()
+
 	// Tests using framework will screw up the storage so make a locally
 	// scoped req to hold a reference
-	req = &logical.Request{
-		Storage: req.Storage,
+	req := &logical.Request{
+		Storage: s,
 	}
 
 	go func() {
 		defer atomic.StoreUint32(b.tidyCASGuard, 0)
 
 		// Don't cancel when the original client request goes away
-		ctx = context.Background()
+		ctx := context.Background()
 
 		logger := b.Logger().Named("tidy")
 
-		doTidy := func() error {
-			if tidyCertStore {
-				serials, err := req.Storage.List(ctx, "certs/")
-				if err != nil {
-					return errwrap.Wrapf("error fetching list of certs: {{err}}", err)
-				}
+		if err := b.storeTidyStatus(ctx, req.Storage); err != nil {
+			logger.Error("error storing tidy status", "error", err)
+		}
 
-				for _, serial := range serials {
-					certEntry, err := req.Storage.Get(ctx, "certs/"+serial)
-					if err != nil {
-						return errwrap.Wrapf(fmt.Sprintf("error fetching certificate %q: {{err}}", serial), err)
-					}
-
-					if certEntry == nil {
-						logger.Warn("certificate entry is nil; tidying up since it is no longer useful for any server operations", "serial", serial)
-						if err := req.Storage.Delete(ctx, "certs/"+serial); err != nil {
-							return errwrap.Wrapf(fmt.Sprintf("error deleting nil entry with serial %s: {{err}}", serial), err)
-						}
-					}
-
-					if certEntry.Value == nil || len(certEntry.Value) == 0 {
-						logger.Warn("certificate entry has no value; tidying up since it is no longer useful for any server operations", "serial", serial)
-						if err := req.Storage.Delete(ctx, "certs/"+serial); err != nil {
-							return errwrap.Wrapf(fmt.Sprintf("error deleting entry with nil value with serial %s: {{err}}", serial), err)
-						}
-					}
-
-					cert, err := x509.ParseCertificate(certEntry.Value)
-					if err != nil {
-						return errwrap.Wrapf(fmt.Sprintf("unable to parse stored certificate with serial %q: {{err}}", serial), err)
-					}
-
-					if time.Now().After(cert.NotAfter.Add(bufferDuration)) {
-						if err := req.Storage.Delete(ctx, "certs/"+serial); err != nil {
-							return errwrap.Wrapf(fmt.Sprintf("error deleting serial %q from storage: {{err}}", serial), err)
-						}
-					}
-				}
-			}
+		err := b.doTidy(ctx, req, params, logger)
+		if err != nil {
+			logger.Error("error running tidy", "error", err)
+		}
+
+		b.tidyStatusLock.Lock()
+		b.tidyStatus.timeFinished = time.Now()
+		b.tidyStatus.err = err
+		if err != nil {
+			b.tidyStatus.state = tidyStatusError
+		} else {
+			b.tidyStatus.state = tidyStatusFinished
+		}
+		b.tidyStatusLock.Unlock()
+
+		if err := b.storeTidyStatus(ctx, req.Storage); err != nil {
+			logger.Error("error storing tidy status", "error", err)
+		}
+	}()
+
+	return true
+}
 
-			if tidyRevokedCerts || tidyRevocationList {
-				b.revokeStorageLock.Lock()
-				defer b.revokeStorageLock.Unlock()
+func (b *backend) doTidy(ctx context.Context, req *logical.Request, params *tidyParams, logger log.Logger) error {
+	if params.TidyCertStore {
+		serials, err := req.Storage.List(ctx, "certs/")
+		if err != nil {
+			return errwrap.Wrapf("error fetching list of certs: {{err}}", err)
+		}
 
-				tidiedRevoked := false
+		for _, serial := range serials {
+			certEntry, err := req.Storage.Get(ctx, "certs/"+serial)
+			if err != nil {
+				return errwrap.Wrapf(fmt.Sprintf("error fetching certificate %q: {{err}}", serial), err)
+			}
 
-				revokedSerials, err := req.Storage.List(ctx, "revoked/")
-				if err != nil {
-					return errwrap.Wrapf("error fetching list of revoked certs: {{err}}", err)
+			if certEntry == nil {
+				logger.Warn("certificate entry is nil; tidying up since it is no longer useful for any server operations", "serial", serial)
+				if err := req.Storage.Delete(ctx, "certs/"+serial); err != nil {
+					return errwrap.Wrapf(fmt.Sprintf("error deleting nil entry with serial %s: {{err}}", serial), err)
 				}
+				b.tidyStatusIncCertStoreCount()
+				continue
+			}
 
-				var revInfo revocationInfo
-				for _, serial := range revokedSerials {
-					revokedEntry, err := req.Storage.Get(ctx, "revoked/"+serial)
-					if err != nil {
-						return errwrap.Wrapf(fmt.Sprintf("unable to fetch revoked cert with serial %q: {{err}}", serial), err)
-					}
-
-					if revokedEntry == nil {
-						logger.Warn("revoked entry is nil; tidying up since it is no longer useful for any server operations", "serial", serial)
-						if err := req.Storage.Delete(ctx, "revoked/"+serial); err != nil {
-							return errwrap.Wrapf(fmt.Sprintf("error deleting nil revoked entry with serial %s: {{err}}", serial), err)
-						}
-					}
-
-					if revokedEntry.Value == nil || len(revokedEntry.Value) == 0 {
-						logger.Warn("revoked entry has nil value; tidying up since it is no longer useful for any server operations", "serial", serial)
-						if err := req.Storage.Delete(ctx, "revoked/"+serial); err != nil {
-							return errwrap.Wrapf(fmt.Sprintf("error deleting revoked entry with nil value with serial %s: {{err}}", serial), err)
-						}
-					}
-
-					err = revokedEntry.DecodeJSON(&revInfo)
-					if err != nil {
-						return errwrap.Wrapf(fmt.Sprintf("error decoding revocation entry for serial %q: {{err}}", serial), err)
-					}
-
-					revokedCert, err := x509.ParseCertificate(revInfo.CertificateBytes)
-					if err != nil {
-						return errwrap.Wrapf(fmt.Sprintf("unable to parse stored revoked certificate with serial %q: {{err}}", serial), err)
-					}
-
-					if time.Now().After(revokedCert.NotAfter.Add(bufferDuration)) {
-						if err := req.Storage.Delete(ctx, "revoked/"+serial); err != nil {
-							return errwrap.Wrapf(fmt.Sprintf("error deleting serial %q from revoked list: {{err}}", serial), err)
-						}
-						if err := req.Storage.Delete(ctx, "certs/"+serial); err != nil {
-							return errwrap.Wrapf(fmt.Sprintf("error deleting serial %q from store when tidying revoked: {{err}}", serial), err)
-						}
-						tidiedRevoked = true
-					}
+			if certEntry.Value == nil || len(certEntry.Value) == 0 {
+				logger.Warn("certificate entry has no value; tidying up since it is no longer useful for any server operations", "serial", serial)
+				if err := req.Storage.Delete(ctx, "certs/"+serial); err != nil {
+					return errwrap.Wrapf(fmt.Sprintf("error deleting entry with nil value with serial %s: {{err}}", serial), err)
 				}
+				b.tidyStatusIncCertStoreCount()
+				continue
+			}
 
-				if tidiedRevoked {
-					if err := buildCRL(ctx, b, req, false); err != nil {
-						return err
-					}
+			cert, err := x509.ParseCertificate(certEntry.Value)
+			if err != nil {
+				return errwrap.Wrapf(fmt.Sprintf("unable to parse stored certificate with serial %q: {{err}}", serial), err)
+			}
+
+			if time.Now().After(cert.NotAfter.Add(params.SafetyBuffer)) {
+				if err := req.Storage.Delete(ctx, "certs/"+serial); err != nil {
+					return errwrap.Wrapf(fmt.Sprintf("error deleting serial %q from storage: {{err}}", serial), err)
+				}
+				if err := req.Storage.Delete(ctx, "acme/certs/"+serial); err != nil {
+					return errwrap.Wrapf(fmt.Sprintf("error deleting the ACME account of serial %q from storage: {{err}}", serial), err)
 				}
+				if err := req.Storage.Delete(ctx, "cert-metadata/"+serial); err != nil {
+					return errwrap.Wrapf(fmt.Sprintf("error deleting the metadata of serial %q from storage: {{err}}", serial), err)
+				}
+				b.tidyStatusIncCertStoreCount()
 			}
+		}
+	}
+
+	if params.TidyRevokedCerts {
+		b.revokeStorageLock.Lock()
+		defer b.revokeStorageLock.Unlock()
 
-			return nil
+		tidiedRevoked, err := tidyRevoked(ctx, req.Storage, params.SafetyBuffer, logger)
+		if err != nil {
+			return err
 		}
 
-		if err := doTidy(); err != nil {
-			logger.Error("error running tidy", "error", err)
-			return
+		if tidiedRevoked > 0 {
+			b.tidyStatusLock.Lock()
+			b.tidyStatus.revokedCertDeletedCount += uint(tidiedRevoked)
+			b.tidyStatusLock.Unlock()
+
+			if err := buildCRL(ctx, b, req, false); err != nil {
+				return err
+			}
 		}
-	}()
+	}
 
-	resp := &logical.Response{}
-	resp.AddWarning("Tidy operation successfully started. Any information from the operation will be printed to Vault's server logs.")
-	return logical.RespondWithStatusCode(resp, req, http.StatusAccepted)
+	return nil
+}
+
+// storeTidyStatus persists the status of the current tidy operation
+func (b *backend) storeTidyStatus(ctx context.Context, s logical.Storage) error {
+	b.tidyStatusLock.RLock()
+	status := b.tidyStatus
+	stored := &tidyStatusEntry{
+		Params:                  status.params,
+		State:                   status.state,
+		TimeStarted:             status.timeStarted,
+		TimeFinished:            status.timeFinished,
+		CertStoreDeletedCount:   status.certStoreDeletedCount,
+		RevokedCertDeletedCount: status.revokedCertDeletedCount,
+	}
+	if status.err != nil {
+		stored.Error = status.err.Error()
+	}
+	b.tidyStatusLock.RUnlock()
+
+	entry, err := logical.StorageEntryJSON("tidy-status", stored)
+	if err != nil {
+		return err
+	}
+	return s.Put(ctx, entry)
+}
+
+// loadTidyStatus reads the status of the last tidy operation, which also
+// schedules auto-tidy, back from storage the first time it is needed after
+// the backend was loaded
+func (b *backend) loadTidyStatus(ctx context.Context, s logical.Storage) error {
+	b.tidyStatusLock.Lock()
+	defer b.tidyStatusLock.Unlock()
+
+	if b.tidyStatusLoaded {
+		return nil
+	}
+
+	entry, err := s.Get(ctx, "tidy-status")
+	if err != nil {
+		return errwrap.Wrapf("error fetching tidy status: {{err}}", err)
+	}
+	if entry != nil {
+		var stored tidyStatusEntry
+		if err := entry.DecodeJSON(&stored); err != nil {
+			return errwrap.Wrapf("error decoding tidy status: {{err}}", err)
+		}
+
+		status := &tidyStatus{
+			params:                  stored.Params,
+			state:                   stored.State,
+			timeStarted:             stored.TimeStarted,
+			timeFinished:            stored.TimeFinished,
+			certStoreDeletedCount:   stored.CertStoreDeletedCount,
+			revokedCertDeletedCount: stored.RevokedCertDeletedCount,
+		}
+		if status.params == nil {
+			status.params = &tidyParams{}
+		}
+		if stored.Error != "" {
+			status.err = errors.New(stored.Error)
+		}
+		// No tidy operation runs yet on this node, so one which was still
+		// running was interrupted
+		if status.state == tidyStatusRunning {
+			status.state = tidyStatusError
+			status.err = errors.New("the tidy operation was interrupted")
+		}
+
+		b.tidyStatus = status
+		b.lastTidy = status.timeStarted
+	}
+
+	b.tidyStatusLoaded = true
+	return nil
+}
+
+func (b *backend) tidyStatusIncCertStoreCount() {
+	b.tidyStatusLock.Lock()
+	defer b.tidyStatusLock.Unlock()
+
+	b.tidyStatus.certStoreDeletedCount++
+}
+
+// autoTidy starts a tidy operation with the auto-tidy configuration once
+// its interval has passed since the last tidy operation
+func (b *backend) autoTidy(ctx context.Context, req *logical.Request) error {
+	config, err := b.autoTidyConfig(ctx, req.Storage)
+	if err != nil {
+		return err
+	}
+	if config == nil || !config.Enabled {
+		return nil
+	}
+
+	if err := b.loadTidyStatus(ctx, req.Storage); err != nil {
+		return err
+	}
+
+	b.tidyStatusLock.RLock()
+	lastTidy := b.lastTidy
+	b.tidyStatusLock.RUnlock()
+	if time.Since(lastTidy) < config.Interval {
+		return nil
+	}
+
+	b.startTidy(req.Storage, &tidyParams{
+		TidyCertStore:    config.TidyCertStore,
+		TidyRevokedCerts: config.TidyRevokedCerts,
+		SafetyBuffer:     config.SafetyBuffer,
+		Source:           "auto",
+	})
+	return nil
+}
+
+func (b *backend) pathTidyStatusRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	// If we are a performance standby forward the request to the active node,
+	// which runs the tidy operations
+	if b.System().ReplicationState().HasState(consts.ReplicationPerformanceStandby) {
+		return nil, logical.ErrReadOnly
+	}
+
+	if err := b.loadTidyStatus(ctx, req.Storage); err != nil {
+		return nil, err
+	}
+
+	b.tidyStatusLock.RLock()
+	defer b.tidyStatusLock.RUnlock()
+
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"state":                      tidyStatusInactive.String(),
+			"source":                     nil,
+			"tidy_cert_store":            nil,
+			"tidy_revoked_certs":         nil,
+			"safety_buffer":              nil,
+			"error":                      nil,
+			"time_started":               nil,
+			"time_finished":              nil,
+			"cert_store_deleted_count":   nil,
+			"revoked_cert_deleted_count": nil,
+		},
+	}
+
+	status := b.tidyStatus
+	if status == nil {
+		return resp, nil
+	}
+
+	resp.Data["state"] = status.state.String()
+	resp.Data["source"] = status.params.Source
+	resp.Data["tidy_cert_store"] = status.params.TidyCertStore
+	resp.Data["tidy_revoked_certs"] = status.params.TidyRevokedCerts
+	resp.Data["safety_buffer"] = int64(status.params.SafetyBuffer.Seconds())
+	resp.Data["time_started"] = status.timeStarted.Format(time.RFC3339Nano)
+	resp.Data["cert_store_deleted_count"] = status.certStoreDeletedCount
+	resp.Data["revoked_cert_deleted_count"] = status.revokedCertDeletedCount
+	if !status.timeFinished.IsZero() {
+		resp.Data["time_finished"] = status.timeFinished.Format(time.RFC3339Nano)
+	}
+	if status.err != nil {
+		resp.Data["error"] = status.err.Error()
+	}
+
+	return resp, nil
+}
+
+// tidyRevoked removes the revoked certificates which expired for longer
+// than the safety buffer, and returns how many were removed so that the CRL
+// can be rotated. The caller holds the revocation storage lock.
+func tidyRevoked(ctx context.Context, s logical.Storage, bufferDuration time.Duration, logger log.Logger) (int, error) {
+	tidiedRevoked := 0
+
+	revokedSerials, err := s.List(ctx, "revoked/")
+	if err != nil {
+		return 0, errwrap.Wrapf("error fetching list of revoked certs: {{err}}", err)
+	}
+
+	var revInfo revocationInfo
+	for _, serial := range revokedSerials {
+		revokedEntry, err := s.Get(ctx, "revoked/"+serial)
+		if err != nil {
+			return 0, errwrap.Wrapf(fmt.Sprintf("unable to fetch revoked cert with serial %q: {{err}}", serial), err)
+		}
+
+		if revokedEntry == nil {
+			logger.Warn("revoked entry is nil; tidying up since it is no longer useful for any server operations", "serial", serial)
+			if err := s.Delete(ctx, "revoked/"+serial); err != nil {
+				return 0, errwrap.Wrapf(fmt.Sprintf("error deleting nil revoked entry with serial %s: {{err}}", serial), err)
+			}
+			continue
+		}
+
+		if revokedEntry.Value == nil || len(revokedEntry.Value) == 0 {
+			logger.Warn("revoked entry has nil value; tidying up since it is no longer useful for any server operations", "serial", serial)
+			if err := s.Delete(ctx, "revoked/"+serial); err != nil {
+				return 0, errwrap.Wrapf(fmt.Sprintf("error deleting revoked entry with nil value with serial %s: {{err}}", serial), err)
+			}
+			continue
+		}
+
+		err = revokedEntry.DecodeJSON(&revInfo)
+		if err != nil {
+			return 0, errwrap.Wrapf(fmt.Sprintf("error decoding revocation entry for serial %q: {{err}}", serial), err)
+		}
+
+		revokedCert, err := x509.ParseCertificate(revInfo.CertificateBytes)
+		if err != nil {
+			return 0, errwrap.Wrapf(fmt.Sprintf("unable to parse stored revoked certificate with serial %q: {{err}}", serial), err)
+		}
+
+		if time.Now().After(revokedCert.NotAfter.Add(bufferDuration)) {
+			if err := s.Delete(ctx, "revoked/"+serial); err != nil {
+				return 0, errwrap.Wrapf(fmt.Sprintf("error deleting serial %q from revoked list: {{err}}", serial), err)
+			}
+			if err := s.Delete(ctx, "certs/"+serial); err != nil {
+				return 0, errwrap.Wrapf(fmt.Sprintf("error deleting serial %q from store when tidying revoked: {{err}}", serial), err)
+			}
+			if err := s.Delete(ctx, "acme/certs/"+serial); err != nil {
+				return 0, errwrap.Wrapf(fmt.Sprintf("error deleting the ACME account of serial %q from store when tidying revoked: {{err}}", serial), err)
+			}
+			if err := s.Delete(ctx, "cert-metadata/"+serial); err != nil {
+				return 0, errwrap.Wrapf(fmt.Sprintf("error deleting the metadata of serial %q from store when tidying revoked: {{err}}", serial), err)
+			}
+			tidiedRevoked++
+		}
+	}
+
+	return tidiedRevoked, nil
 }
 
 const pathTidyHelpSyn = `
@@ -232,4 +547,17 @@ certificate/revocation information of each certificate being held in
 certificate storage or in revocation information will then be checked. If the
 current time, minus the value of 'safety_buffer', is greater than the
 expiration, it will be removed.
+
+The operation runs in the background; its progress is reported by the
+'tidy-status' endpoint.
+`
+
+const pathTidyStatusHelpSyn = `
+Returns the status of the tidy operation.
+`
+
+const pathTidyStatusHelpDesc = `
+This endpoint reports the status of the last tidy operation, started either on the 'tidy' endpoint or by auto-tidy: whether it is running,
+when it started and finished, the number of certificates and revoked
+certificates it removed, and its error if it failed.
 `
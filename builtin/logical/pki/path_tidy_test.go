@@ -0,0 +1,184 @@
+package pki
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+func tidyTestWaitForStatus(t *testing.T, b *backend, s logical.Storage) *logical.Response {
+	t.Helper()
+
+	for i := 0; i < 50; i++ {
+		resp := issuersTestRequest(t, b, s, logical.ReadOperation, "tidy-status", nil)
+		if resp.Data["state"] != tidyStatusRunning.String() {
+			return resp
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Fatal("the tidy operation didn't finish")
+	return nil
+}
+
+func TestPki_TidyStatus(t *testing.T) {
+	b, s := createBackendWithStorage(t)
+	ctx := context.Background()
+
+	resp := issuersTestRequest(t, b, s, logical.ReadOperation, "tidy-status", nil)
+	if resp.Data["state"] != "Inactive" || resp.Data["time_started"] != nil {
+		t.Fatalf("bad: %#v", resp.Data)
+	}
+
+	issuersTestRequest(t, b, s, logical.UpdateOperation, "root/generate/internal", map[string]interface{}{
+		"common_name": "Root",
+		"ttl":         "40h",
+	})
+	issuersTestRequest(t, b, s, logical.UpdateOperation, "roles/example", map[string]interface{}{
+		"allowed_domains":  "example.com",
+		"allow_subdomains": true,
+	})
+	issue := func(ttl string) string {
+		t.Helper()
+
+		resp := issuersTestRequest(t, b, s, logical.UpdateOperation, "issue/example", map[string]interface{}{
+			"common_name": "test.example.com",
+			"ttl":         ttl,
+		})
+		return resp.Data["serial_number"].(string)
+	}
+	issue("1s")
+	issue("1s")
+	revoked := issue("5s")
+	issue("1h")
+	issuersTestRequest(t, b, s, logical.UpdateOperation, "revoke", map[string]interface{}{
+		"serial_number": revoked,
+	})
+	if len(issuersTestCRLSerials(t, b, s, "crl")) != 1 {
+		t.Fatal("the revoked certificate should be on the CRL")
+	}
+	time.Sleep(6 * time.Second)
+
+	// A manual tidy of the certificate store also removes the revoked
+	// certificate from it, but not from the revocation list
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "tidy",
+		Storage:   s,
+		Data: map[string]interface{}{
+			"tidy_cert_store": true,
+			"safety_buffer":   "1s",
+		},
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("bad: err: %v resp: %#v", err, resp)
+	}
+	resp = tidyTestWaitForStatus(t, b, s)
+	if resp.Data["state"] != "Finished" || resp.Data["source"] != "manual" || resp.Data["error"] != nil ||
+		resp.Data["tidy_cert_store"] != true || resp.Data["tidy_revoked_certs"] != false ||
+		resp.Data["safety_buffer"] != int64(1) || resp.Data["time_finished"] == nil ||
+		resp.Data["cert_store_deleted_count"] != uint(3) || resp.Data["revoked_cert_deleted_count"] != uint(0) {
+		t.Fatalf("bad: %#v", resp.Data)
+	}
+	lastStarted := resp.Data["time_started"]
+
+	resp = issuersTestRequest(t, b, s, logical.ListOperation, "certs/", nil)
+	if keys := resp.Data["keys"].([]string); len(keys) != 2 {
+		t.Fatalf("bad: %v", keys)
+	}
+
+	// Auto-tidy is disabled by default, and requires something to tidy
+	resp = issuersTestRequest(t, b, s, logical.ReadOperation, "config/auto-tidy", nil)
+	if resp.Data["enabled"] != false || resp.Data["interval"] != int64(43200) || resp.Data["safety_buffer"] != int64(259200) {
+		t.Fatalf("bad: %#v", resp.Data)
+	}
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config/auto-tidy",
+		Storage:   s,
+		Data: map[string]interface{}{
+			"enabled": true,
+		},
+	})
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error when enabling auto-tidy without anything to tidy, got err: %v resp: %#v", err, resp)
+	}
+
+	issuersTestRequest(t, b, s, logical.UpdateOperation, "config/auto-tidy", map[string]interface{}{
+		"enabled":              true,
+		"interval":             "1h",
+		"tidy_revocation_list": true,
+		"safety_buffer":        "1s",
+	})
+	resp = issuersTestRequest(t, b, s, logical.ReadOperation, "config/auto-tidy", nil)
+	if resp.Data["enabled"] != true || resp.Data["interval"] != int64(3600) || resp.Data["tidy_revoked_certs"] != true ||
+		resp.Data["tidy_cert_store"] != false || resp.Data["safety_buffer"] != int64(1) {
+		t.Fatalf("bad: %#v", resp.Data)
+	}
+
+	// Auto-tidy waits for the interval since the last tidy operation
+	if err := b.periodicFunc(ctx, &logical.Request{Storage: s}); err != nil {
+		t.Fatal(err)
+	}
+	resp = tidyTestWaitForStatus(t, b, s)
+	if resp.Data["time_started"] != lastStarted {
+		t.Fatalf("auto-tidy shouldn't run before the interval passed: %#v", resp.Data)
+	}
+
+	b.tidyStatusLock.Lock()
+	b.lastTidy = time.Time{}
+	b.tidyStatusLock.Unlock()
+	if err := b.periodicFunc(ctx, &logical.Request{Storage: s}); err != nil {
+		t.Fatal(err)
+	}
+	resp = tidyTestWaitForStatus(t, b, s)
+	if resp.Data["state"] != "Finished" || resp.Data["source"] != "auto" ||
+		resp.Data["tidy_cert_store"] != false || resp.Data["tidy_revoked_certs"] != true ||
+		resp.Data["cert_store_deleted_count"] != uint(0) || resp.Data["revoked_cert_deleted_count"] != uint(1) {
+		t.Fatalf("bad: %#v", resp.Data)
+	}
+	if len(issuersTestCRLSerials(t, b, s, "crl")) != 0 {
+		t.Fatal("the tidied certificate should be removed from the CRL")
+	}
+
+	// The status and the auto-tidy schedule survive reloading the backend
+	reload := func() *backend {
+		t.Helper()
+
+		config := logical.TestBackendConfig()
+		config.StorageView = s
+		b := Backend(config)
+		if err := b.Setup(ctx, config); err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	b = reload()
+	if err := b.periodicFunc(ctx, &logical.Request{Storage: s}); err != nil {
+		t.Fatal(err)
+	}
+	reloaded := tidyTestWaitForStatus(t, b, s)
+	if !reflect.DeepEqual(reloaded.Data, resp.Data) {
+		t.Fatalf("bad: expected %#v, got %#v", resp.Data, reloaded.Data)
+	}
+
+	// A tidy operation still running when the backend was unloaded failed
+	entry, err := logical.StorageEntryJSON("tidy-status", &tidyStatusEntry{
+		Params:      &tidyParams{TidyCertStore: true, Source: "manual"},
+		State:       tidyStatusRunning,
+		TimeStarted: time.Now(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put(ctx, entry); err != nil {
+		t.Fatal(err)
+	}
+	b = reload()
+	resp = issuersTestRequest(t, b, s, logical.ReadOperation, "tidy-status", nil)
+	if resp.Data["state"] != "Error" || resp.Data["error"] == nil || resp.Data["source"] != "manual" {
+		t.Fatalf("bad: %#v", resp.Data)
+	}
+}
@@ -4,29 +4,50 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"net"
+	"net/http"
 	"os"
 	"sort"
 	"strings"
 	"sync"
+	"time"
 
+	metrics "github.com/armon/go-metrics"
+	"github.com/armon/go-metrics/prometheus"
 	"github.com/kr/pretty"
 	"github.com/mitchellh/cli"
 	"github.com/posener/complete"
+	prom "github.com/prometheus/client_golang/prometheus"
 
 	"github.com/hashicorp/errwrap"
 	log "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/api"
 	"github.com/hashicorp/vault/command/agent/auth"
 	"github.com/hashicorp/vault/command/agent/auth/alicloud"
+	"github.com/hashicorp/vault/command/agent/auth/approle"
 	"github.com/hashicorp/vault/command/agent/auth/aws"
 	"github.com/hashicorp/vault/command/agent/auth/azure"
+	"github.com/hashicorp/vault/command/agent/auth/cert"
 	"github.com/hashicorp/vault/command/agent/auth/gcp"
 	"github.com/hashicorp/vault/command/agent/auth/jwt"
 	"github.com/hashicorp/vault/command/agent/auth/kubernetes"
+	"github.com/hashicorp/vault/command/agent/auth/tokenfile"
+	"github.com/hashicorp/vault/command/agent/cache"
+	"github.com/hashicorp/vault/command/agent/cache/cacheboltdb"
 	"github.com/hashicorp/vault/command/agent/config"
+	agentexec "github.com/hashicorp/vault/command/agent/exec"
 	"github.com/hashicorp/vault/command/agent/sink"
+	sinkexec "github.com/hashicorp/vault/command/agent/sink/exec"
 	"github.com/hashicorp/vault/command/agent/sink/file"
+	"github.com/hashicorp/vault/command/agent/sink/inmem"
+	"github.com/hashicorp/vault/command/agent/sink/socket"
+	"github.com/hashicorp/vault/command/agent/status"
+	"github.com/hashicorp/vault/command/agent/template"
+	"github.com/hashicorp/vault/command/server"
+	"github.com/hashicorp/vault/helper/consts"
 	"github.com/hashicorp/vault/helper/gated-writer"
 	"github.com/hashicorp/vault/helper/logging"
+	"github.com/hashicorp/vault/helper/metricsutil"
 	"github.com/hashicorp/vault/version"
 )
 
@@ -194,10 +215,13 @@ func (c *AgentCommand) Run(args []string) int {
 				"-config flag."))
 		return 1
 	}
-	if config.AutoAuth == nil {
-		c.UI.Error("No auto_auth block found in config file")
+	if config.AutoAuth == nil && config.Cache == nil {
+		c.UI.Error("No auto_auth or cache block found in config file")
 		return 1
 	}
+	if config.AutoAuth == nil {
+		c.UI.Info("No auto_auth block found in config file, not starting automatic authentication feature")
+	}
 
 	infoKeys := make([]string, 0, 10)
 	info := make(map[string]string)
@@ -250,60 +274,212 @@ func (c *AgentCommand) Run(args []string) int {
 	}
 
 	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
 
-	var sinks []*sink.SinkConfig
-	for _, sc := range config.AutoAuth.Sinks {
-		switch sc.Type {
-		case "file":
-			config := &sink.SinkConfig{
-				Logger:  c.logger.Named("sink.file"),
-				Config:  sc.Config,
-				Client:  client,
-				WrapTTL: sc.WrapTTL,
-				DHType:  sc.DHType,
-				DHPath:  sc.DHPath,
-				AAD:     sc.AAD,
-			}
-			s, err := file.NewFileSink(config)
+	metricsHelper, telemetryCleanup, err := c.setupTelemetry()
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("Error initializing telemetry: %s", err))
+		return 1
+	}
+	defer telemetryCleanup()
+
+	// The sinks and the auth method are replaced on reload, along with the
+	// templates
+	rs := &reloadState{
+		config: config,
+		client: client,
+	}
+	defer func() {
+		closeSinks(rs.sinks)
+	}()
+
+	var method auth.AuthMethod
+	if config.AutoAuth != nil {
+		rs.sinks, err = c.newSinks(config.AutoAuth, client)
+		if err != nil {
+			c.UI.Error(err.Error())
+			return 1
+		}
+		method, err = c.newAuthMethod(config.AutoAuth.Method)
+		if err != nil {
+			c.UI.Error(err.Error())
+			return 1
+		}
+	}
+
+	agentStatus := status.New(method != nil)
+
+	// The health and metrics endpoints are served on all the listeners
+	mux := http.NewServeMux()
+	mux.Handle(consts.AgentPathHealth, agentStatus.HealthHandler())
+	mux.Handle(consts.AgentPathMetrics, agentStatus.MetricsHandler(metricsHelper))
+
+	// An auto-auth token restored from the persistent cache
+	var restoredToken string
+
+	// Start the caching proxy, if configured
+	if config.Cache != nil {
+		cacheLogger := c.logger.Named("cache")
+
+		// Create the API proxier
+		apiProxy, err := cache.NewAPIProxy(&cache.APIProxyConfig{
+			Client: client,
+			Logger: cacheLogger.Named("apiproxy"),
+		})
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error creating API proxy: %v", err))
+			return 1
+		}
+
+		// Open the persistent cache, if configured, and retrieve its key
+		var ps *cacheboltdb.BoltStorage
+		var restore bool
+		if persist := config.Cache.Persist; persist != nil {
+			ps, err = cacheboltdb.NewBoltStorage(&cacheboltdb.BoltStorageConfig{
+				Path:   persist.Path,
+				Logger: cacheLogger.Named("cacheboltdb"),
+			})
 			if err != nil {
-				c.UI.Error(errwrap.Wrapf("Error creating file sink: {{err}}", err).Error())
+				c.UI.Error(fmt.Sprintf("Error opening persistent cache: %v", err))
 				return 1
 			}
-			config.Sink = s
-			sinks = append(sinks, config)
-		default:
-			c.UI.Error(fmt.Sprintf("Unknown sink type %q", sc.Type))
+			defer ps.Close()
+
+			kmConfig := &cache.KeyManagerConfig{
+				Storage:           ps,
+				Client:            client,
+				Logger:            cacheLogger.Named("keymanager"),
+				KeyFile:           persist.KeyFile,
+				RetrievalTokenTTL: persist.RetrievalTokenTTL,
+			}
+			km, restored, err := cache.NewKeyManager(kmConfig)
+			if err != nil {
+				if persist.ExitOnErr {
+					c.UI.Error(fmt.Sprintf("Error retrieving the persistent cache key: %v", err))
+					return 1
+				}
+				cacheLogger.Warn("could not retrieve the persistent cache key, starting with an empty cache", "error", err)
+				if err := ps.Reset(); err != nil {
+					c.UI.Error(fmt.Sprintf("Error resetting persistent cache: %v", err))
+					return 1
+				}
+				if km, restored, err = cache.NewKeyManager(kmConfig); err != nil {
+					c.UI.Error(fmt.Sprintf("Error creating persistent cache key: %v", err))
+					return 1
+				}
+			}
+			restore = restored
+
+			// The key manager wraps the key with auto-auth tokens when there
+			// is no key file, and the storage keeps the auto-auth token for
+			// the next run
+			if method != nil {
+				rs.internalSinks = append(rs.internalSinks, &sink.SinkConfig{
+					Name:   "cache.persist.key",
+					Logger: cacheLogger,
+					Sink:   km,
+				}, &sink.SinkConfig{
+					Name:   "cache.persist.token",
+					Logger: cacheLogger,
+					Sink:   ps,
+				})
+			}
+			go km.Run(ctx)
+		}
+
+		// Create the lease cache proxier and set its underlying proxier to
+		// the API proxier.
+		leaseCache, err := cache.NewLeaseCache(&cache.LeaseCacheConfig{
+			Client:      client,
+			BaseContext: ctx,
+			Proxier:     apiProxy,
+			Logger:      cacheLogger.Named("leasecache"),
+			Storage:     ps,
+		})
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error creating lease cache: %v", err))
 			return 1
 		}
-	}
 
-	var method auth.AuthMethod
-	authConfig := &auth.AuthConfig{
-		Logger:    c.logger.Named(fmt.Sprintf("auth.%s", config.AutoAuth.Method.Type)),
-		MountPath: config.AutoAuth.Method.MountPath,
-		Config:    config.AutoAuth.Method.Config,
+		if restore {
+			cacheLogger.Info("restoring persistent cache")
+			if err := leaseCache.Restore(); err != nil {
+				if config.Cache.Persist.ExitOnErr {
+					c.UI.Error(fmt.Sprintf("Error restoring persistent cache: %v", err))
+					return 1
+				}
+				cacheLogger.Warn("could not restore persistent cache, starting with an empty cache", "error", err)
+				if err := ps.Clear(); err != nil {
+					c.UI.Error(fmt.Sprintf("Error clearing persistent cache: %v", err))
+					return 1
+				}
+			}
+
+			token, err := ps.Get(cacheboltdb.AutoAuthTokenID, cacheboltdb.AutoAuthTokenType)
+			if err != nil {
+				cacheLogger.Warn("could not restore auto-auth token", "error", err)
+			}
+			restoredToken = string(token)
+		}
+
+		var inmemSink sink.Sink
+		if config.Cache.UseAutoAuthToken {
+			cacheLogger.Debug("auto-auth token is allowed to be used; configuring inmem sink")
+			inmemSink, err = inmem.New(&sink.SinkConfig{
+				Logger: cacheLogger,
+			})
+			if err != nil {
+				c.UI.Error(fmt.Sprintf("Error creating inmem sink for cache: %v", err))
+				return 1
+			}
+			rs.internalSinks = append(rs.internalSinks, &sink.SinkConfig{
+				Name:   "cache",
+				Logger: cacheLogger,
+				Sink:   inmemSink,
+			})
+		}
+
+		// Add paths relevant for the lease cache layer
+		mux.Handle(consts.AgentPathCacheClear, leaseCache.HandleCacheClear(ctx))
+		mux.Handle("/", cache.ProxyHandler(ctx, cacheLogger, leaseCache, inmemSink))
 	}
-	switch config.AutoAuth.Method.Type {
-	case "alicloud":
-		method, err = alicloud.NewAliCloudAuthMethod(authConfig)
-	case "aws":
-		method, err = aws.NewAWSAuthMethod(authConfig)
-	case "azure":
-		method, err = azure.NewAzureAuthMethod(authConfig)
-	case "gcp":
-		method, err = gcp.NewGCPAuthMethod(authConfig)
-	case "jwt":
-		method, err = jwt.NewJWTAuthMethod(authConfig)
-	case "kubernetes":
-		method, err = kubernetes.NewKubernetesAuthMethod(authConfig)
-	default:
-		c.UI.Error(fmt.Sprintf("Unknown auth method %q", config.AutoAuth.Method.Type))
-		return 1
+
+	var listeners []net.Listener
+	for _, lnConfig := range config.Listeners {
+		ln, props, _, err := server.NewListener(lnConfig.Type, lnConfig.Config, c.logWriter, c.UI)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error starting listener: %v", err))
+			return 1
+		}
+		listeners = append(listeners, ln)
+
+		scheme := "https://"
+		if props["tls"] == "disabled" {
+			scheme = "http://"
+		}
+		if lnConfig.Type == "unix" {
+			scheme = "unix://"
+		}
+
+		c.logger.Info("starting listener", "addr", scheme+ln.Addr().String())
+
+		server := &http.Server{
+			Handler:           mux,
+			ReadHeaderTimeout: 10 * time.Second,
+			ReadTimeout:       30 * time.Second,
+			IdleTimeout:       5 * time.Minute,
+			ErrorLog:          c.logger.Named("listener").StandardLogger(nil),
+		}
+		go server.Serve(ln)
 	}
-	if err != nil {
-		c.UI.Error(errwrap.Wrapf(fmt.Sprintf("Error creating %s auth method: {{err}}", config.AutoAuth.Method.Type), err).Error())
-		return 1
+
+	// Ensure that listeners are closed at all the exits
+	listenerCloseFunc := func() {
+		for _, ln := range listeners {
+			ln.Close()
+		}
 	}
+	defer c.cleanupGuard.Do(listenerCloseFunc)
 
 	// Output the header that the server has started
 	if !c.flagCombineLogs {
@@ -316,21 +492,69 @@ func (c *AgentCommand) Run(args []string) int {
 	default:
 	}
 
-	ss := sink.NewSinkServer(&sink.SinkServerConfig{
-		Logger:        c.logger.Named("sink.server"),
-		Client:        client,
-		ExitAfterAuth: config.ExitAfterAuth,
-	})
+	// Create the exec server up front so that invalid signals are reported
+	// before anything starts
+	var es *agentexec.Server
+	if config.Exec != nil {
+		es, err = agentexec.NewServer(&agentexec.ServerConfig{
+			Logger: c.logger.Named("exec.server"),
+			Config: config.Exec,
+		})
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error creating exec server: %v", err))
+			return 1
+		}
+	}
 
-	ah := auth.NewAuthHandler(&auth.AuthHandlerConfig{
-		Logger:  c.logger.Named("auth.handler"),
-		Client:  c.client,
-		WrapTTL: config.AutoAuth.Method.WrapTTL,
-	})
+	var ssDoneCh, ahDoneCh, tsDoneCh, esDoneCh chan struct{}
+	// Start auto-auth and sink servers
+	if method != nil {
+		enableTemplateTokenCh := len(config.Templates) > 0 || es != nil
+		ah := auth.NewAuthHandler(&auth.AuthHandlerConfig{
+			Logger:                c.logger.Named("auth.handler"),
+			Client:                c.client,
+			WrapTTL:               config.AutoAuth.Method.WrapTTL,
+			EnableTemplateTokenCh: enableTemplateTokenCh,
+			Token:                 restoredToken,
+			Status:                agentStatus,
+		})
+		ahDoneCh = ah.DoneCh
+		rs.ah = ah
+
+		ss := sink.NewSinkServer(&sink.SinkServerConfig{
+			Logger:        c.logger.Named("sink.server"),
+			Client:        client,
+			ExitAfterAuth: config.ExitAfterAuth,
+			Status:        agentStatus,
+		})
+		ssDoneCh = ss.DoneCh
+		rs.ss = ss
+
+		go ah.Run(ctx, method)
+		go ss.Run(ctx, ah.OutputCh, append(rs.sinks, rs.internalSinks...))
+
+		if enableTemplateTokenCh {
+			tsConfig := &template.ServerConfig{
+				Logger: c.logger.Named("template.server"),
+				Client: client,
+			}
+
+			if es != nil {
+				envCh := make(chan map[string]string)
+				tsConfig.EnvTemplates = config.EnvTemplates
+				tsConfig.EnvCh = envCh
+				esDoneCh = es.DoneCh
 
-	// Start things running
-	go ah.Run(ctx, method)
-	go ss.Run(ctx, ah.OutputCh, sinks)
+				go es.Run(ctx, envCh)
+			}
+
+			ts := template.NewServer(tsConfig)
+			tsDoneCh = ts.DoneCh
+			rs.ts = ts
+
+			go ts.Run(ctx, ah.TemplateTokenCh, config.Templates)
+		}
+	}
 
 	// Release the log gate.
 	c.logGate.Flush()
@@ -347,20 +571,234 @@ func (c *AgentCommand) Run(args []string) int {
 		}
 	}()
 
-	select {
-	case <-ss.DoneCh:
-		// This will happen if we exit-on-auth
-		c.logger.Info("sinks finished, exiting")
-	case <-c.ShutdownCh:
-		c.UI.Output("==> Vault agent shutdown triggered")
+RunLoop:
+	for {
+		select {
+		case <-ssDoneCh:
+			// This will happen if we exit-on-auth
+			c.logger.Info("sinks finished, exiting")
+			break RunLoop
+		case <-esDoneCh:
+			// The agent exits along with its child process
+			c.logger.Info("child process exited, exiting")
+			cancelFunc()
+			break RunLoop
+		case <-c.SighupCh:
+			c.UI.Output("==> Vault agent reload triggered")
+			c.reload(rs)
+		case <-c.ShutdownCh:
+			c.UI.Output("==> Vault agent shutdown triggered")
+			cancelFunc()
+			if ahDoneCh != nil {
+				<-ahDoneCh
+			}
+			if ssDoneCh != nil {
+				<-ssDoneCh
+			}
+			if tsDoneCh != nil {
+				<-tsDoneCh
+			}
+			break RunLoop
+		}
+	}
+
+	if es != nil {
 		cancelFunc()
-		<-ah.DoneCh
-		<-ss.DoneCh
+		<-es.DoneCh
+		return es.ExitCode()
 	}
 
 	return 0
 }
 
+// reloadState holds what is replaced when the configuration is reloaded
+type reloadState struct {
+	config *config.Config
+	client *api.Client
+	ah     *auth.AuthHandler
+	ss     *sink.SinkServer
+	ts     *template.Server
+
+	// sinks are the configured sinks, and internalSinks the ones used by
+	// the cache, which are kept on reload
+	sinks         []*sink.SinkConfig
+	internalSinks []*sink.SinkConfig
+}
+
+// reload loads the configuration file again, and replaces the sinks, the
+// templates and the parameters of the auth method. The current token is kept
+// until the new method is needed. Other changes require a restart. If
+// anything fails, the current configuration is kept.
+func (c *AgentCommand) reload(rs *reloadState) {
+	newConfig, err := config.LoadConfig(c.flagConfigs[0], c.logger)
+	if err != nil {
+		c.logger.Error("could not reload config", "path", c.flagConfigs[0], "error", err)
+		return
+	}
+	if newConfig == nil {
+		c.logger.Error("no config found at reload time")
+		return
+	}
+
+	if (newConfig.AutoAuth == nil) != (rs.config.AutoAuth == nil) {
+		c.logger.Error("adding or removing auto_auth requires a restart, not reloading")
+		return
+	}
+	if newConfig.AutoAuth == nil || rs.ah == nil {
+		c.logger.Info("no auto_auth configured, nothing to reload")
+		return
+	}
+	if newConfig.AutoAuth.Method.WrapTTL != rs.config.AutoAuth.Method.WrapTTL {
+		c.logger.Error("changing the wrap_ttl of the auth method requires a restart, not reloading")
+		return
+	}
+	if rs.ts == nil && len(newConfig.Templates) > 0 {
+		c.logger.Error("adding templates requires a restart, not reloading")
+		return
+	}
+
+	method, err := c.newAuthMethod(newConfig.AutoAuth.Method)
+	if err != nil {
+		c.logger.Error("could not reload auth method", "error", err)
+		return
+	}
+	sinks, err := c.newSinks(newConfig.AutoAuth, rs.client)
+	if err != nil {
+		method.Shutdown()
+		c.logger.Error("could not reload sinks", "error", err)
+		return
+	}
+
+	rs.ah.Reload(method)
+	rs.ss.Reload(append(sinks, rs.internalSinks...))
+	closeSinks(rs.sinks)
+	rs.sinks = sinks
+	if rs.ts != nil {
+		rs.ts.Reload(newConfig.Templates)
+	}
+
+	// Only the reloaded parts are taken from the new configuration
+	rs.config.AutoAuth = newConfig.AutoAuth
+	rs.config.Templates = newConfig.Templates
+
+	c.logger.Info("configuration reloaded")
+}
+
+// newSinks creates the sinks of the auto_auth block
+func (c *AgentCommand) newSinks(autoAuth *config.AutoAuth, client *api.Client) ([]*sink.SinkConfig, error) {
+	var sinks []*sink.SinkConfig
+	for _, sc := range autoAuth.Sinks {
+		name := sc.Type
+		if path, ok := sc.Config["path"].(string); ok {
+			name = fmt.Sprintf("%s:%s", sc.Type, path)
+		}
+		config := &sink.SinkConfig{
+			Name:    name,
+			Logger:  c.logger.Named(fmt.Sprintf("sink.%s", sc.Type)),
+			Config:  sc.Config,
+			Client:  client,
+			WrapTTL: sc.WrapTTL,
+			DHType:  sc.DHType,
+			DHPath:  sc.DHPath,
+			AAD:     sc.AAD,
+		}
+		var s sink.Sink
+		var err error
+		switch sc.Type {
+		case "exec":
+			s, err = sinkexec.NewExecSink(config)
+		case "file":
+			s, err = file.NewFileSink(config)
+		case "socket":
+			s, err = socket.NewSocketSink(config)
+		case "tmpfs":
+			s, err = file.NewTmpfsSink(config)
+		default:
+			err = fmt.Errorf("unknown sink type %q", sc.Type)
+		}
+		if err != nil {
+			closeSinks(sinks)
+			return nil, errwrap.Wrapf(fmt.Sprintf("Error creating %s sink: {{err}}", sc.Type), err)
+		}
+		config.Sink = s
+		sinks = append(sinks, config)
+	}
+	return sinks, nil
+}
+
+// closeSinks closes the sinks that hold resources, such as a listening
+// socket
+func closeSinks(sinks []*sink.SinkConfig) {
+	for _, s := range sinks {
+		if closer, ok := s.Sink.(io.Closer); ok {
+			closer.Close()
+		}
+	}
+}
+
+// newAuthMethod creates the auth method of the auto_auth block
+func (c *AgentCommand) newAuthMethod(m *config.Method) (auth.AuthMethod, error) {
+	authConfig := &auth.AuthConfig{
+		Logger:    c.logger.Named(fmt.Sprintf("auth.%s", m.Type)),
+		MountPath: m.MountPath,
+		WrapTTL:   m.WrapTTL,
+		Config:    m.Config,
+	}
+
+	var method auth.AuthMethod
+	var err error
+	switch m.Type {
+	case "alicloud":
+		method, err = alicloud.NewAliCloudAuthMethod(authConfig)
+	case "approle":
+		method, err = approle.NewApproleAuthMethod(authConfig)
+	case "aws":
+		method, err = aws.NewAWSAuthMethod(authConfig)
+	case "azure":
+		method, err = azure.NewAzureAuthMethod(authConfig)
+	case "cert":
+		method, err = cert.NewCertAuthMethod(authConfig)
+	case "gcp":
+		method, err = gcp.NewGCPAuthMethod(authConfig)
+	case "jwt":
+		method, err = jwt.NewJWTAuthMethod(authConfig)
+	case "kubernetes":
+		method, err = kubernetes.NewKubernetesAuthMethod(authConfig)
+	case "token_file":
+		method, err = tokenfile.NewTokenFileAuthMethod(authConfig)
+	default:
+		return nil, fmt.Errorf("Unknown auth method %q", m.Type)
+	}
+	if err != nil {
+		return nil, errwrap.Wrapf(fmt.Sprintf("Error creating %s auth method: {{err}}", m.Type), err)
+	}
+	return method, nil
+}
+
+// setupTelemetry collects the agent metrics in memory and in the Prometheus
+// format, for the metrics endpoint. The returned function unregisters the
+// Prometheus collector.
+func (c *AgentCommand) setupTelemetry() (*metricsutil.MetricsHelper, func(), error) {
+	inm := metrics.NewInmemSink(10*time.Second, time.Minute)
+	metrics.DefaultInmemSignal(inm)
+
+	promSink, err := prometheus.NewPrometheusSinkFrom(prometheus.PrometheusOpts{})
+	if err != nil {
+		return nil, nil, errwrap.Wrapf("failed to start Prometheus sink: {{err}}", err)
+	}
+
+	metricsConf := metrics.DefaultConfig("vault")
+	metricsConf.EnableHostname = false
+	if _, err := metrics.NewGlobal(metricsConf, metrics.FanoutSink{promSink, inm}); err != nil {
+		prom.Unregister(promSink)
+		return nil, nil, err
+	}
+
+	return metricsutil.NewMetricsHelper(inm, true), func() {
+		prom.Unregister(promSink)
+	}, nil
+}
+
 // storePidFile is used to write out our PID to a file if necessary
 func (c *AgentCommand) storePidFile(pidPath string) error {
 	// Quit fast if no pidfile
@@ -0,0 +1,285 @@
+package agent
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/api"
+	credAppRole "github.com/hashicorp/vault/builtin/credential/approle"
+	"github.com/hashicorp/vault/command/agent/auth"
+	agentapprole "github.com/hashicorp/vault/command/agent/auth/approle"
+	"github.com/hashicorp/vault/command/agent/sink"
+	"github.com/hashicorp/vault/command/agent/sink/file"
+	"github.com/hashicorp/vault/helper/logging"
+	vaulthttp "github.com/hashicorp/vault/http"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/vault"
+)
+
+func TestAppRoleEndToEnd(t *testing.T) {
+	testAppRoleEndToEnd(t, false)
+	testAppRoleEndToEnd(t, true)
+}
+
+func testAppRoleEndToEnd(t *testing.T, wrappedSecretID bool) {
+	logger := logging.NewVaultLogger(hclog.Trace)
+	coreConfig := &vault.CoreConfig{
+		Logger: logger,
+		CredentialBackends: map[string]logical.Factory{
+			"approle": credAppRole.Factory,
+		},
+	}
+	cluster := vault.NewTestCluster(t, coreConfig, &vault.TestClusterOptions{
+		HandlerFunc: vaulthttp.Handler,
+	})
+	cluster.Start()
+	defer cluster.Cleanup()
+
+	vault.TestWaitActive(t, cluster.Cores[0].Core)
+	client := cluster.Cores[0].Client
+
+	// Setup Vault
+	err := client.Sys().EnableAuthWithOptions("approle", &api.EnableAuthOptions{
+		Type: "approle",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = client.Logical().Write("auth/approle/role/test", map[string]interface{}{
+		"bind_secret_id": "true",
+		"period":         "3s",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := client.Logical().Read("auth/approle/role/test/role-id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	roleID := resp.Data["role_id"].(string)
+
+	secretIDClient := client
+	if wrappedSecretID {
+		secretIDClient, err = client.Clone()
+		if err != nil {
+			t.Fatal(err)
+		}
+		secretIDClient.SetToken(client.Token())
+		secretIDClient.SetWrappingLookupFunc(func(string, string) string {
+			return "5m"
+		})
+	}
+	newSecretID := func() string {
+		resp, err := secretIDClient.Logical().Write("auth/approle/role/test/secret-id", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if wrappedSecretID {
+			return resp.WrapInfo.Token
+		}
+		return resp.Data["secret_id"].(string)
+	}
+
+	dir, err := ioutil.TempDir("", "auth.approle.test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	roleIDPath := filepath.Join(dir, "role_id")
+	secretIDPath := filepath.Join(dir, "secret_id")
+	out := filepath.Join(dir, "token")
+
+	if err := ioutil.WriteFile(roleIDPath, []byte(roleID+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(secretIDPath, []byte(newSecretID()), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	timer := time.AfterFunc(30*time.Second, func() {
+		cancelFunc()
+	})
+	defer timer.Stop()
+
+	conf := map[string]interface{}{
+		"role_id_file_path":   roleIDPath,
+		"secret_id_file_path": secretIDPath,
+	}
+	if wrappedSecretID {
+		conf["secret_id_response_wrapping_path"] = "auth/approle/role/test/secret-id"
+	}
+	am, err := agentapprole.NewApproleAuthMethod(&auth.AuthConfig{
+		Logger:    logger.Named("auth.approle"),
+		MountPath: "auth/approle",
+		Config:    conf,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ah := auth.NewAuthHandler(&auth.AuthHandlerConfig{
+		Logger: logger.Named("auth.handler"),
+		Client: client,
+	})
+	go ah.Run(ctx, am)
+	defer func() {
+		<-ah.DoneCh
+	}()
+
+	ss := testTokenFileSinkServer(t, ctx, logger, client, ah, out)
+	defer func() {
+		<-ss.DoneCh
+	}()
+
+	// This has to be after the other defers so it happens first
+	defer cancelFunc()
+
+	token := testWaitForToken(t, out)
+
+	// The secret ID file is removed by default once read
+	if _, err := os.Lstat(secretIDPath); !os.IsNotExist(err) {
+		t.Fatalf("expected secret ID file to be removed, got %v", err)
+	}
+
+	// Period of 3 seconds, so the token should still be alive after it
+	// through renewals
+	time.Sleep(5 * time.Second)
+	testLookupToken(t, client, token)
+}
+
+func TestAppRole_WrappedSecretIDCreationPath(t *testing.T) {
+	logger := logging.NewVaultLogger(hclog.Trace)
+	coreConfig := &vault.CoreConfig{
+		Logger: logger,
+		CredentialBackends: map[string]logical.Factory{
+			"approle": credAppRole.Factory,
+		},
+	}
+	cluster := vault.NewTestCluster(t, coreConfig, &vault.TestClusterOptions{
+		HandlerFunc: vaulthttp.Handler,
+	})
+	cluster.Start()
+	defer cluster.Cleanup()
+
+	vault.TestWaitActive(t, cluster.Cores[0].Core)
+	client := cluster.Cores[0].Client
+
+	// A token wrapped by another path must be rejected
+	wrapClient, err := client.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrapClient.SetToken(client.Token())
+	wrapClient.SetWrappingLookupFunc(func(string, string) string {
+		return "5m"
+	})
+	resp, err := wrapClient.Logical().Write("sys/wrapping/wrap", map[string]interface{}{
+		"secret_id": "not-a-secret-id",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "auth.approle.test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	roleIDPath := filepath.Join(dir, "role_id")
+	secretIDPath := filepath.Join(dir, "secret_id")
+	if err := ioutil.WriteFile(roleIDPath, []byte("role"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(secretIDPath, []byte(resp.WrapInfo.Token), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	am, err := agentapprole.NewApproleAuthMethod(&auth.AuthConfig{
+		Logger:    logger.Named("auth.approle"),
+		MountPath: "auth/approle",
+		Config: map[string]interface{}{
+			"role_id_file_path":                   roleIDPath,
+			"secret_id_file_path":                 secretIDPath,
+			"remove_secret_id_file_after_reading": "false",
+			"secret_id_response_wrapping_path":    "auth/approle/role/test/secret-id",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := am.Authenticate(context.Background(), client); err == nil {
+		t.Fatal("expected error for a wrapping token with a bad creation path")
+	}
+	if _, err := os.Lstat(secretIDPath); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testTokenFileSinkServer runs a sink server writing the tokens of ah,
+// unencrypted and unwrapped, to out
+func testTokenFileSinkServer(t *testing.T, ctx context.Context, logger hclog.Logger, client *api.Client, ah *auth.AuthHandler, out string) *sink.SinkServer {
+	t.Helper()
+
+	config := &sink.SinkConfig{
+		Logger: logger.Named("sink.file"),
+		Config: map[string]interface{}{
+			"path": out,
+		},
+	}
+	fs, err := file.NewFileSink(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Sink = fs
+
+	ss := sink.NewSinkServer(&sink.SinkServerConfig{
+		Logger: logger.Named("sink.server"),
+		Client: client,
+	})
+	go ss.Run(ctx, ah.OutputCh, []*sink.SinkConfig{config})
+	return ss
+}
+
+// testWaitForToken waits for a token to be written to path, removing the
+// file once read
+func testWaitForToken(t *testing.T, path string) string {
+	t.Helper()
+
+	timeout := time.Now().Add(10 * time.Second)
+	for {
+		if time.Now().After(timeout) {
+			t.Fatal("did not find a written token after timeout")
+		}
+		val, err := ioutil.ReadFile(path)
+		if err == nil && len(val) > 0 {
+			os.Remove(path)
+			return string(val)
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+}
+
+// testLookupToken checks that token is valid
+func testLookupToken(t *testing.T, client *api.Client, token string) *api.Secret {
+	t.Helper()
+
+	cloned, err := client.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cloned.SetToken(token)
+	secret, err := cloned.Auth().Token().LookupSelf()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return secret
+}
@@ -0,0 +1,218 @@
+package approle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/command/agent/auth"
+	"github.com/hashicorp/vault/helper/jsonutil"
+	"github.com/hashicorp/vault/helper/parseutil"
+)
+
+type approleMethod struct {
+	logger    hclog.Logger
+	mountPath string
+
+	roleIDFilePath                 string
+	secretIDFilePath               string
+	cachedRoleID                   string
+	cachedSecretID                 string
+	removeSecretIDFileAfterReading bool
+	secretIDResponseWrappingPath   string
+}
+
+func NewApproleAuthMethod(conf *auth.AuthConfig) (auth.AuthMethod, error) {
+	if conf == nil {
+		return nil, errors.New("empty config")
+	}
+	if conf.Config == nil {
+		return nil, errors.New("empty config data")
+	}
+
+	a := &approleMethod{
+		logger:                         conf.Logger,
+		mountPath:                      conf.MountPath,
+		removeSecretIDFileAfterReading: true,
+	}
+
+	roleIDFilePathRaw, ok := conf.Config["role_id_file_path"]
+	if !ok {
+		return nil, errors.New("missing 'role_id_file_path' value")
+	}
+	a.roleIDFilePath, ok = roleIDFilePathRaw.(string)
+	if !ok {
+		return nil, errors.New("could not convert 'role_id_file_path' config value to string")
+	}
+	if a.roleIDFilePath == "" {
+		return nil, errors.New("'role_id_file_path' value is empty")
+	}
+
+	secretIDFilePathRaw, ok := conf.Config["secret_id_file_path"]
+	if ok {
+		a.secretIDFilePath, ok = secretIDFilePathRaw.(string)
+		if !ok {
+			return nil, errors.New("could not convert 'secret_id_file_path' config value to string")
+		}
+		if a.secretIDFilePath == "" {
+			return nil, errors.New("'secret_id_file_path' value is empty")
+		}
+
+		if removeSecretIDFileAfterReadingRaw, ok := conf.Config["remove_secret_id_file_after_reading"]; ok {
+			removeSecretIDFileAfterReading, err := parseutil.ParseBool(removeSecretIDFileAfterReadingRaw)
+			if err != nil {
+				return nil, errwrap.Wrapf("error parsing 'remove_secret_id_file_after_reading' value: {{err}}", err)
+			}
+			a.removeSecretIDFileAfterReading = removeSecretIDFileAfterReading
+		}
+
+		if secretIDResponseWrappingPathRaw, ok := conf.Config["secret_id_response_wrapping_path"]; ok {
+			a.secretIDResponseWrappingPath, ok = secretIDResponseWrappingPathRaw.(string)
+			if !ok {
+				return nil, errors.New("could not convert 'secret_id_response_wrapping_path' config value to string")
+			}
+			if a.secretIDResponseWrappingPath == "" {
+				return nil, errors.New("'secret_id_response_wrapping_path' value is empty")
+			}
+			a.secretIDResponseWrappingPath = strings.Trim(a.secretIDResponseWrappingPath, "/")
+		}
+	}
+
+	return a, nil
+}
+
+func (a *approleMethod) Authenticate(ctx context.Context, client *api.Client) (string, map[string]interface{}, error) {
+	a.logger.Trace("beginning authentication")
+
+	if _, err := os.Stat(a.roleIDFilePath); err == nil {
+		roleID, err := ioutil.ReadFile(a.roleIDFilePath)
+		if err != nil {
+			if a.cachedRoleID == "" {
+				return "", nil, errwrap.Wrapf("error reading role ID file and no cached role ID known: {{err}}", err)
+			}
+			a.logger.Warn("error reading role ID file", "error", err)
+		}
+		if len(roleID) == 0 {
+			if a.cachedRoleID == "" {
+				return "", nil, errors.New("role ID file empty and no cached role ID known")
+			}
+			a.logger.Warn("role ID file exists but read empty value, re-using cached value")
+		} else {
+			a.cachedRoleID = strings.TrimSpace(string(roleID))
+		}
+	}
+
+	if a.cachedRoleID == "" {
+		return "", nil, errors.New("no known role ID")
+	}
+
+	if a.secretIDFilePath != "" {
+		if _, err := os.Stat(a.secretIDFilePath); err == nil {
+			secretID, err := ioutil.ReadFile(a.secretIDFilePath)
+			if err != nil {
+				if a.cachedSecretID == "" {
+					return "", nil, errwrap.Wrapf("error reading secret ID file and no cached secret ID known: {{err}}", err)
+				}
+				a.logger.Warn("error reading secret ID file", "error", err)
+			}
+			stringSecretID := strings.TrimSpace(string(secretID))
+
+			if len(stringSecretID) == 0 {
+				if a.cachedSecretID == "" {
+					return "", nil, errors.New("secret ID file empty and no cached secret ID known")
+				}
+				a.logger.Warn("secret ID file exists but read empty value, re-using cached value")
+			} else {
+				if a.secretIDResponseWrappingPath != "" {
+					stringSecretID, err = a.unwrapSecretID(client, stringSecretID)
+					if err != nil {
+						return "", nil, err
+					}
+				}
+
+				a.cachedSecretID = stringSecretID
+				if a.removeSecretIDFileAfterReading {
+					if err := os.Remove(a.secretIDFilePath); err != nil {
+						a.logger.Error("error removing secret ID file after reading", "error", err)
+					}
+				}
+			}
+		}
+
+		if a.cachedSecretID == "" {
+			return "", nil, errors.New("no known secret ID")
+		}
+	}
+
+	data := map[string]interface{}{
+		"role_id": a.cachedRoleID,
+	}
+	if a.cachedSecretID != "" {
+		data["secret_id"] = a.cachedSecretID
+	}
+
+	return fmt.Sprintf("%s/login", a.mountPath), data, nil
+}
+
+// unwrapSecretID unwraps a response-wrapped secret ID, after checking that it
+// was wrapped by the configured path. The wrapping token can be given as is or
+// as the JSON wrap info written by token sinks.
+func (a *approleMethod) unwrapSecretID(client *api.Client, wrapped string) (string, error) {
+	wrapInfo := new(api.SecretWrapInfo)
+	if err := jsonutil.DecodeJSON([]byte(wrapped), wrapInfo); err == nil && wrapInfo.Token != "" {
+		wrapped = wrapInfo.Token
+	}
+
+	clonedClient, err := client.Clone()
+	if err != nil {
+		return "", errwrap.Wrapf("error cloning client to unwrap secret ID: {{err}}", err)
+	}
+	clonedClient.SetToken(wrapped)
+
+	// Validate the creation path
+	resp, err := clonedClient.Logical().Write("sys/wrapping/lookup", map[string]interface{}{
+		"token": wrapped,
+	})
+	if err != nil {
+		return "", errwrap.Wrapf("error looking up wrapped secret ID: {{err}}", err)
+	}
+	if resp == nil || resp.Data == nil {
+		return "", errors.New("response wrapping lookup returned no data")
+	}
+	creationPath, _ := resp.Data["creation_path"].(string)
+	if strings.Trim(creationPath, "/") != a.secretIDResponseWrappingPath {
+		a.logger.Error("SECURITY: unable to validate wrapping token creation path", "expected", a.secretIDResponseWrappingPath, "found", creationPath)
+		return "", errors.New("unable to validate wrapping token creation path")
+	}
+
+	// Now get the secret ID
+	resp, err = clonedClient.Logical().Unwrap("")
+	if err != nil {
+		return "", errwrap.Wrapf("error unwrapping secret ID: {{err}}", err)
+	}
+	if resp == nil || resp.Data == nil {
+		return "", errors.New("unwrapping secret ID returned no data")
+	}
+	secretID, _ := resp.Data["secret_id"].(string)
+	if secretID == "" {
+		return "", errors.New("unwrapped response did not contain a secret ID")
+	}
+
+	return secretID, nil
+}
+
+func (a *approleMethod) NewCreds() chan struct{} {
+	return nil
+}
+
+func (a *approleMethod) CredSuccess() {
+}
+
+func (a *approleMethod) Shutdown() {
+}
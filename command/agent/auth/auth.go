@@ -7,6 +7,7 @@ import (
 
 	"github.com/hashicorp/go-hclog"
 	"github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/command/agent/status"
 	"github.com/hashicorp/vault/helper/jsonutil"
 )
 
@@ -17,6 +18,13 @@ type AuthMethod interface {
 	Shutdown()
 }
 
+// AuthMethodWithClient is an AuthMethod that makes its authentication call
+// with its own client, such as one presenting a TLS client certificate
+type AuthMethodWithClient interface {
+	AuthMethod
+	AuthClient(client *api.Client) (*api.Client, error)
+}
+
 type AuthConfig struct {
 	Logger    hclog.Logger
 	MountPath string
@@ -27,33 +35,73 @@ type AuthConfig struct {
 // AuthHandler is responsible for keeping a token alive and renewed and passing
 // new tokens to the sink server
 type AuthHandler struct {
-	DoneCh   chan struct{}
-	OutputCh chan string
-	logger   hclog.Logger
-	client   *api.Client
-	random   *rand.Rand
-	wrapTTL  time.Duration
+	DoneCh                chan struct{}
+	OutputCh              chan string
+	TemplateTokenCh       chan string
+	logger                hclog.Logger
+	client                *api.Client
+	random                *rand.Rand
+	wrapTTL               time.Duration
+	enableTemplateTokenCh bool
+	token                 string
+	status                *status.Status
+	reloadCh              chan AuthMethod
 }
 
 type AuthHandlerConfig struct {
 	Logger  hclog.Logger
 	Client  *api.Client
 	WrapTTL time.Duration
+
+	// Token is a token from a previous run, such as one restored from the
+	// persistent cache. If it's still valid, it's used instead of
+	// authenticating the first time. It's ignored when wrapping.
+	Token string
+
+	// EnableTemplateTokenCh makes the handler also send new, unwrapped
+	// tokens on TemplateTokenCh, for use by the template server
+	EnableTemplateTokenCh bool
+
+	// Status, if set, records the authentications and renewals
+	Status *status.Status
 }
 
 func NewAuthHandler(conf *AuthHandlerConfig) *AuthHandler {
 	ah := &AuthHandler{
 		DoneCh:   make(chan struct{}),
 		OutputCh: make(chan string),
-		logger:   conf.Logger,
-		client:   conf.Client,
-		random:   rand.New(rand.NewSource(int64(time.Now().Nanosecond()))),
-		wrapTTL:  conf.WrapTTL,
+		// This is buffered so that a token sent while the template server is
+		// shutting down doesn't block the handler
+		TemplateTokenCh:       make(chan string, 1),
+		logger:                conf.Logger,
+		client:                conf.Client,
+		random:                rand.New(rand.NewSource(int64(time.Now().Nanosecond()))),
+		wrapTTL:               conf.WrapTTL,
+		enableTemplateTokenCh: conf.EnableTemplateTokenCh,
+		status:                conf.Status,
+		reloadCh:              make(chan AuthMethod, 1),
+	}
+	if conf.WrapTTL == 0 {
+		ah.token = conf.Token
 	}
 
 	return ah
 }
 
+// Reload replaces the auth method used by Run. The current token is kept, and
+// the new method is used the next time the handler authenticates.
+func (ah *AuthHandler) Reload(am AuthMethod) {
+	for {
+		select {
+		case ah.reloadCh <- am:
+			return
+		case pending := <-ah.reloadCh:
+			// A method that was never used is discarded
+			pending.Shutdown()
+		}
+	}
+}
+
 func backoffOrQuit(ctx context.Context, backoff time.Duration) {
 	select {
 	case <-time.After(backoff):
@@ -70,15 +118,39 @@ func (ah *AuthHandler) Run(ctx context.Context, am AuthMethod) {
 	defer func() {
 		am.Shutdown()
 		close(ah.OutputCh)
+		close(ah.TemplateTokenCh)
 		close(ah.DoneCh)
 		ah.logger.Info("auth handler stopped")
 	}()
 
+	if ah.token != "" {
+		am = &initialTokenMethod{
+			AuthMethod: am,
+			token:      ah.token,
+		}
+	}
+
 	credCh := am.NewCreds()
 	if credCh == nil {
 		credCh = make(chan struct{})
 	}
 
+	// reload switches to a new auth method. authenticated is whether the
+	// handler holds a token, in which case the new method is told that its
+	// credentials succeeded, so that it watches for new ones.
+	reload := func(newAm AuthMethod, authenticated bool) {
+		ah.logger.Info("auth method reloaded")
+		am.Shutdown()
+		am = newAm
+		credCh = am.NewCreds()
+		if credCh == nil {
+			credCh = make(chan struct{})
+		}
+		if authenticated {
+			am.CredSuccess()
+		}
+	}
+
 	var renewer *api.Renewer
 
 	for {
@@ -86,6 +158,9 @@ func (ah *AuthHandler) Run(ctx context.Context, am AuthMethod) {
 		case <-ctx.Done():
 			return
 
+		case newAm := <-ah.reloadCh:
+			reload(newAm, false)
+
 		default:
 		}
 
@@ -96,13 +171,24 @@ func (ah *AuthHandler) Run(ctx context.Context, am AuthMethod) {
 		path, data, err := am.Authenticate(ctx, ah.client)
 		if err != nil {
 			ah.logger.Error("error getting path or data from method", "error", err, "backoff", backoff.Seconds())
+			ah.status.AuthFailed(err)
 			backoffOrQuit(ctx, backoff)
 			continue
 		}
 
-		clientToUse := ah.client
+		authClient := ah.client
+		if amWithClient, ok := am.(AuthMethodWithClient); ok {
+			authClient, err = amWithClient.AuthClient(ah.client)
+			if err != nil {
+				ah.logger.Error("error creating client for authentication call", "error", err, "backoff", backoff.Seconds())
+				backoffOrQuit(ctx, backoff)
+				continue
+			}
+		}
+
+		clientToUse := authClient
 		if ah.wrapTTL > 0 {
-			wrapClient, err := ah.client.Clone()
+			wrapClient, err := authClient.Clone()
 			if err != nil {
 				ah.logger.Error("error creating client for wrapped call", "error", err, "backoff", backoff.Seconds())
 				backoffOrQuit(ctx, backoff)
@@ -118,6 +204,7 @@ func (ah *AuthHandler) Run(ctx context.Context, am AuthMethod) {
 		// Check errors/sanity
 		if err != nil {
 			ah.logger.Error("error authenticating", "error", err, "backoff", backoff.Seconds())
+			ah.status.AuthFailed(err)
 			backoffOrQuit(ctx, backoff)
 			continue
 		}
@@ -142,21 +229,38 @@ func (ah *AuthHandler) Run(ctx context.Context, am AuthMethod) {
 			}
 			ah.logger.Info("authentication successful, sending wrapped token to sinks and pausing")
 			ah.OutputCh <- string(wrappedResp)
+			ah.status.AuthSucceeded(0)
 
 			am.CredSuccess()
 
-			select {
-			case <-ctx.Done():
-				ah.logger.Info("shutdown triggered")
-				continue
+		WrappedLoop:
+			for {
+				select {
+				case <-ctx.Done():
+					ah.logger.Info("shutdown triggered")
+					break WrappedLoop
 
-			case <-credCh:
-				ah.logger.Info("auth method found new credentials, re-authenticating")
-				continue
+				case newAm := <-ah.reloadCh:
+					reload(newAm, true)
+
+				case <-credCh:
+					ah.logger.Info("auth method found new credentials, re-authenticating")
+					break WrappedLoop
+				}
 			}
+			continue
 
 		default:
-			if secret.Auth == nil {
+			// Methods that provide an existing token, rather than logging
+			// in, return a lookup of that token
+			if secret != nil && secret.Auth == nil && secret.Data != nil {
+				if secret.Auth, err = lookupAuth(secret); err != nil {
+					ah.logger.Error("error parsing token lookup", "error", err, "backoff", backoff.Seconds())
+					backoffOrQuit(ctx, backoff)
+					continue
+				}
+			}
+			if secret == nil || secret.Auth == nil {
 				ah.logger.Error("authentication returned nil auth info", "backoff", backoff.Seconds())
 				backoffOrQuit(ctx, backoff)
 				continue
@@ -167,7 +271,11 @@ func (ah *AuthHandler) Run(ctx context.Context, am AuthMethod) {
 				continue
 			}
 			ah.logger.Info("authentication successful, sending token to sinks")
+			ah.status.AuthSucceeded(time.Duration(secret.Auth.LeaseDuration) * time.Second)
 			ah.OutputCh <- secret.Auth.ClientToken
+			if ah.enableTemplateTokenCh {
+				ah.TemplateTokenCh <- secret.Auth.ClientToken
+			}
 
 			am.CredSuccess()
 		}
@@ -176,7 +284,7 @@ func (ah *AuthHandler) Run(ctx context.Context, am AuthMethod) {
 			renewer.Stop()
 		}
 
-		renewer, err = ah.client.NewRenewer(&api.RenewerInput{
+		renewer, err = authClient.NewRenewer(&api.RenewerInput{
 			Secret: secret,
 		})
 		if err != nil {
@@ -199,13 +307,25 @@ func (ah *AuthHandler) Run(ctx context.Context, am AuthMethod) {
 
 			case err := <-renewer.DoneCh():
 				ah.logger.Info("renewer done channel triggered")
+				if err == api.ErrRenewerNotRenewable {
+					// Keep using the token until it expires, or until the
+					// method finds new credentials
+					ah.waitForExpiry(ctx, secret, &credCh, reload)
+					break RenewerLoop
+				}
 				if err != nil {
 					ah.logger.Error("error renewing token", "error", err)
 				}
 				break RenewerLoop
 
-			case <-renewer.RenewCh():
+			case renewal := <-renewer.RenewCh():
 				ah.logger.Info("renewed auth token")
+				if renewal.Secret != nil && renewal.Secret.Auth != nil {
+					ah.status.TokenRenewed(time.Duration(renewal.Secret.Auth.LeaseDuration) * time.Second)
+				}
+
+			case newAm := <-ah.reloadCh:
+				reload(newAm, true)
 
 			case <-credCh:
 				ah.logger.Info("auth method found new credentials, re-authenticating")
@@ -214,3 +334,115 @@ func (ah *AuthHandler) Run(ctx context.Context, am AuthMethod) {
 		}
 	}
 }
+
+// reauthenticateAfter returns how long a token which can't be renewed is
+// used before re-authenticating: about two thirds of its TTL, with jitter so
+// that agents started together don't all re-authenticate at once, leaving
+// time to retry before the token expires
+func (ah *AuthHandler) reauthenticateAfter(ttl time.Duration) time.Duration {
+	jitter := time.Duration(ah.random.Int63()%int64(ttl/5+1)) - ttl/10
+	return ttl*2/3 + jitter
+}
+
+// initialTokenMethod wraps an AuthMethod to look up an existing token on its
+// first authentication, so that the token is used if it's still valid. Later
+// authentications, including when the lookup fails, use the wrapped method.
+type initialTokenMethod struct {
+	AuthMethod
+	token   string
+	current bool
+}
+
+func (m *initialTokenMethod) Authenticate(ctx context.Context, client *api.Client) (string, map[string]interface{}, error) {
+	if m.token != "" {
+		m.current = true
+		return "auth/token/lookup-self", nil, nil
+	}
+	m.current = false
+	return m.AuthMethod.Authenticate(ctx, client)
+}
+
+func (m *initialTokenMethod) AuthClient(client *api.Client) (*api.Client, error) {
+	if m.current {
+		token := m.token
+		m.token = ""
+
+		clonedClient, err := client.Clone()
+		if err != nil {
+			return nil, err
+		}
+		clonedClient.SetToken(token)
+		return clonedClient, nil
+	}
+
+	if amWithClient, ok := m.AuthMethod.(AuthMethodWithClient); ok {
+		return amWithClient.AuthClient(client)
+	}
+	return client, nil
+}
+
+// waitForExpiry waits until the token in secret is about to expire, ctx is
+// done, or credCh fires. Tokens without a TTL are used until one of the
+// latter. Auth method reloads are passed to reload, which replaces credCh.
+func (ah *AuthHandler) waitForExpiry(ctx context.Context, secret *api.Secret, credCh *chan struct{}, reload func(AuthMethod, bool)) {
+	var expiryCh <-chan time.Time
+	if secret.Auth.LeaseDuration > 0 {
+		ttl := time.Duration(secret.Auth.LeaseDuration) * time.Second
+		wait := ah.reauthenticateAfter(ttl)
+		ah.logger.Info("token is not renewable, re-authenticating before it expires", "ttl", ttl.String(), "wait", wait.String())
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		expiryCh = timer.C
+	} else {
+		ah.logger.Info("token is not renewable and does not expire")
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-expiryCh:
+			return
+		case newAm := <-ah.reloadCh:
+			reload(newAm, true)
+		case <-*credCh:
+			ah.logger.Info("auth method found new credentials, re-authenticating")
+			return
+		}
+	}
+}
+
+// lookupAuth returns the auth info of the token looked up in secret
+func lookupAuth(secret *api.Secret) (*api.SecretAuth, error) {
+	token, err := secret.TokenID()
+	if err != nil {
+		return nil, err
+	}
+	if token == "" {
+		return nil, nil
+	}
+	accessor, err := secret.TokenAccessor()
+	if err != nil {
+		return nil, err
+	}
+	policies, err := secret.TokenPolicies()
+	if err != nil {
+		return nil, err
+	}
+	renewable, err := secret.TokenIsRenewable()
+	if err != nil {
+		return nil, err
+	}
+	ttl, err := secret.TokenTTL()
+	if err != nil {
+		return nil, err
+	}
+
+	return &api.SecretAuth{
+		ClientToken:   token,
+		Accessor:      accessor,
+		Policies:      policies,
+		Renewable:     renewable,
+		LeaseDuration: int(ttl.Seconds()),
+	}, nil
+}
@@ -98,3 +98,22 @@ consumption:
 		}
 	}
 }
+
+func TestAuthHandler_ReauthenticateAfter(t *testing.T) {
+	ah := NewAuthHandler(&AuthHandlerConfig{
+		Logger: logging.NewVaultLogger(hclog.Trace),
+	})
+
+	ttl := time.Hour
+	for i := 0; i < 100; i++ {
+		wait := ah.reauthenticateAfter(ttl)
+		if wait < ttl*2/3-ttl/10 || wait > ttl*2/3+ttl/10 {
+			t.Fatalf("bad: waiting %s for a TTL of %s", wait, ttl)
+		}
+	}
+
+	// Short TTLs still leave time to re-authenticate
+	if wait := ah.reauthenticateAfter(time.Second); wait <= 0 || wait >= time.Second {
+		t.Fatalf("bad: waiting %s for a TTL of 1s", wait)
+	}
+}
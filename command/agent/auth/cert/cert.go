@@ -0,0 +1,123 @@
+package cert
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/command/agent/auth"
+)
+
+type certMethod struct {
+	logger    hclog.Logger
+	mountPath string
+	name      string
+
+	caCert     string
+	clientCert string
+	clientKey  string
+
+	// client is created on first use, from the agent's client with the
+	// configured TLS settings
+	l      sync.Mutex
+	client *api.Client
+}
+
+func NewCertAuthMethod(conf *auth.AuthConfig) (auth.AuthMethod, error) {
+	if conf == nil {
+		return nil, errors.New("empty config")
+	}
+
+	c := &certMethod{
+		logger:    conf.Logger,
+		mountPath: conf.MountPath,
+	}
+
+	for key, dest := range map[string]*string{
+		"name":        &c.name,
+		"ca_cert":     &c.caCert,
+		"client_cert": &c.clientCert,
+		"client_key":  &c.clientKey,
+	} {
+		raw, ok := conf.Config[key]
+		if !ok {
+			continue
+		}
+		*dest, ok = raw.(string)
+		if !ok {
+			return nil, fmt.Errorf("could not convert '%s' config value to string", key)
+		}
+	}
+
+	if (c.clientCert == "") != (c.clientKey == "") {
+		return nil, errors.New("'client_cert' and 'client_key' must be set together")
+	}
+
+	return c, nil
+}
+
+func (c *certMethod) Authenticate(_ context.Context, client *api.Client) (string, map[string]interface{}, error) {
+	c.logger.Trace("beginning authentication")
+
+	data := map[string]interface{}{}
+	if c.name != "" {
+		data["name"] = c.name
+	}
+
+	return fmt.Sprintf("%s/login", c.mountPath), data, nil
+}
+
+// AuthClient returns a client presenting the configured client certificate,
+// or the agent's client if none is configured, in which case the certificate
+// comes from the agent's own TLS settings, e.g. VAULT_CLIENT_CERT.
+func (c *certMethod) AuthClient(client *api.Client) (*api.Client, error) {
+	if c.clientCert == "" && c.caCert == "" {
+		return client, nil
+	}
+
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	if c.client != nil {
+		return c.client, nil
+	}
+
+	// Only the TLS settings differ from the agent's client
+	config := client.CloneConfig()
+	if config.Error != nil {
+		return nil, config.Error
+	}
+
+	if err := config.ConfigureTLS(&api.TLSConfig{
+		CACert:     c.caCert,
+		ClientCert: c.clientCert,
+		ClientKey:  c.clientKey,
+	}); err != nil {
+		return nil, errwrap.Wrapf("error configuring TLS: {{err}}", err)
+	}
+
+	newClient, err := api.NewClient(config)
+	if err != nil {
+		return nil, err
+	}
+	newClient.SetHeaders(client.Headers())
+	// The token comes from the login, not the environment
+	newClient.ClearToken()
+
+	c.client = newClient
+	return c.client, nil
+}
+
+func (c *certMethod) NewCreds() chan struct{} {
+	return nil
+}
+
+func (c *certMethod) CredSuccess() {
+}
+
+func (c *certMethod) Shutdown() {
+}
@@ -0,0 +1,185 @@
+package tokenfile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/command/agent/auth"
+)
+
+type tokenFileMethod struct {
+	logger          hclog.Logger
+	mountPath       string
+	tokenFilePath   string
+	credsFound      chan struct{}
+	stopCh          chan struct{}
+	doneCh          chan struct{}
+	credSuccessGate chan struct{}
+	ticker          *time.Ticker
+	once            *sync.Once
+
+	l           sync.Mutex
+	cachedToken string
+}
+
+// NewTokenFileAuthMethod returns a method that uses an existing token read
+// from a file instead of logging in. The file is watched, and the agent
+// switches to the new token when it changes.
+func NewTokenFileAuthMethod(conf *auth.AuthConfig) (auth.AuthMethod, error) {
+	if conf == nil {
+		return nil, errors.New("empty config")
+	}
+	if conf.Config == nil {
+		return nil, errors.New("empty config data")
+	}
+	if conf.WrapTTL > 0 {
+		return nil, errors.New("'wrap_ttl' is not supported, as the method does not log in")
+	}
+
+	a := &tokenFileMethod{
+		logger:          conf.Logger,
+		mountPath:       conf.MountPath,
+		credsFound:      make(chan struct{}),
+		stopCh:          make(chan struct{}),
+		doneCh:          make(chan struct{}),
+		credSuccessGate: make(chan struct{}),
+		once:            new(sync.Once),
+	}
+
+	tokenFilePathRaw, ok := conf.Config["token_file_path"]
+	if !ok {
+		return nil, errors.New("missing 'token_file_path' value")
+	}
+	a.tokenFilePath, ok = tokenFilePathRaw.(string)
+	if !ok {
+		return nil, errors.New("could not convert 'token_file_path' config value to string")
+	}
+	if a.tokenFilePath == "" {
+		return nil, errors.New("'token_file_path' value is empty")
+	}
+
+	a.ticker = time.NewTicker(500 * time.Millisecond)
+
+	go a.runWatcher()
+
+	a.logger.Info("token_file auth method created", "path", a.tokenFilePath)
+
+	return a, nil
+}
+
+func (a *tokenFileMethod) Authenticate(_ context.Context, client *api.Client) (string, map[string]interface{}, error) {
+	a.logger.Trace("beginning authentication")
+
+	if _, err := a.readToken(); err != nil {
+		return "", nil, err
+	}
+
+	// The lookup is made with the token itself by the client returned by
+	// AuthClient
+	return fmt.Sprintf("%s/lookup-self", a.mountPath), nil, nil
+}
+
+// AuthClient returns a clone of client using the token read from the file
+func (a *tokenFileMethod) AuthClient(client *api.Client) (*api.Client, error) {
+	a.l.Lock()
+	token := a.cachedToken
+	a.l.Unlock()
+
+	if token == "" {
+		return nil, errors.New("no known token")
+	}
+
+	clonedClient, err := client.Clone()
+	if err != nil {
+		return nil, err
+	}
+	clonedClient.SetToken(token)
+	return clonedClient, nil
+}
+
+func (a *tokenFileMethod) NewCreds() chan struct{} {
+	return a.credsFound
+}
+
+func (a *tokenFileMethod) CredSuccess() {
+	a.once.Do(func() {
+		close(a.credSuccessGate)
+	})
+}
+
+func (a *tokenFileMethod) Shutdown() {
+	a.ticker.Stop()
+	close(a.stopCh)
+	<-a.doneCh
+}
+
+// readToken reads the token file, caching its value. It returns whether the
+// token changed. If the file can't be read or is empty, the cached token is
+// kept, and an error is only returned if there is none.
+func (a *tokenFileMethod) readToken() (bool, error) {
+	a.l.Lock()
+	defer a.l.Unlock()
+
+	token, err := ioutil.ReadFile(a.tokenFilePath)
+	if err != nil {
+		if a.cachedToken == "" {
+			return false, errwrap.Wrapf("error reading token file and no cached token known: {{err}}", err)
+		}
+		a.logger.Warn("error reading token file", "error", err)
+		return false, nil
+	}
+
+	newToken := strings.TrimSpace(string(token))
+	if newToken == "" {
+		if a.cachedToken == "" {
+			return false, errors.New("token file empty and no cached token known")
+		}
+		a.logger.Warn("token file exists but read empty value, re-using cached value")
+		return false, nil
+	}
+
+	changed := newToken != a.cachedToken
+	a.cachedToken = newToken
+	return changed, nil
+}
+
+func (a *tokenFileMethod) runWatcher() {
+	defer close(a.doneCh)
+
+	select {
+	case <-a.stopCh:
+		return
+
+	case <-a.credSuccessGate:
+		// We only start watching once we're initially successful, since at
+		// startup Authenticate will be called and we don't want to end up
+		// immediately reauthenticating by having found a new value
+	}
+
+	for {
+		select {
+		case <-a.stopCh:
+			return
+
+		case <-a.ticker.C:
+			changed, err := a.readToken()
+			if err != nil || !changed {
+				continue
+			}
+			a.logger.Debug("new token found in token file")
+			select {
+			case a.credsFound <- struct{}{}:
+			case <-a.stopCh:
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/helper/consts"
+)
+
+// APIProxy is an implementation of the proxier interface that is used to
+// forward the request to Vault and get the response.
+type APIProxy struct {
+	client *api.Client
+	logger hclog.Logger
+}
+
+type APIProxyConfig struct {
+	Client *api.Client
+	Logger hclog.Logger
+}
+
+func NewAPIProxy(config *APIProxyConfig) (Proxier, error) {
+	if config.Client == nil {
+		return nil, errors.New("nil API client")
+	}
+	return &APIProxy{
+		client: config.Client,
+		logger: config.Logger,
+	}, nil
+}
+
+func (ap *APIProxy) Send(ctx context.Context, req *SendRequest) (*SendResponse, error) {
+	client, err := ap.client.Clone()
+	if err != nil {
+		return nil, err
+	}
+	client.SetToken(req.Token)
+
+	// The headers of the incoming request are sent as-is, except for the
+	// token which is set above.
+	headers := make(map[string][]string, len(req.Request.Header))
+	for k, v := range req.Request.Header {
+		headers[k] = v
+	}
+	delete(headers, consts.AuthHeaderName)
+	client.SetHeaders(headers)
+
+	// Response wrapping is decided by the caller through the wrapping header,
+	// not by the environment of the agent.
+	client.SetWrappingLookupFunc(func(string, string) string {
+		return ""
+	})
+
+	fwReq := client.NewRequest(req.Request.Method, req.Request.URL.Path)
+	fwReq.BodyBytes = req.RequestBody
+	if query := req.Request.URL.Query(); len(query) > 0 {
+		fwReq.Params = query
+	}
+
+	// Make the request to Vault and get the response
+	ap.logger.Info("forwarding request", "path", req.Request.URL.Path, "method", req.Request.Method)
+
+	resp, err := client.RawRequestWithContext(ctx, fwReq)
+	if resp == nil && err != nil {
+		// We don't want to cache nil responses, so we simply return the error
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// Error responses from Vault are passed through to the caller untouched
+	body, readErr := ioutil.ReadAll(resp.Body)
+	if readErr != nil {
+		return nil, readErr
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	return &SendResponse{
+		Response:     resp,
+		ResponseBody: body,
+	}, nil
+}
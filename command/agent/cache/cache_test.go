@@ -0,0 +1,407 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/command/agent/cache/cacheboltdb"
+	"github.com/hashicorp/vault/command/agent/cache/cachememdb"
+	"github.com/hashicorp/vault/command/agent/sink"
+	"github.com/hashicorp/vault/command/agent/sink/inmem"
+	"github.com/hashicorp/vault/helper/consts"
+	"github.com/hashicorp/vault/helper/logging"
+	vaulthttp "github.com/hashicorp/vault/http"
+	"github.com/hashicorp/vault/vault"
+)
+
+// setupClusterAndAgent starts a Vault server and an agent with a lease cache
+// in front of it, and returns a client pointing to the agent.
+func setupClusterAndAgent(ctx context.Context, t *testing.T, inmemSink sink.Sink) (*api.Client, *api.Client, func()) {
+	t.Helper()
+
+	logger := logging.NewVaultLogger(hclog.Trace)
+
+	core, _, token := vault.TestCoreUnsealed(t)
+	ln, addr := vaulthttp.TestServer(t, core)
+
+	config := api.DefaultConfig()
+	config.Address = addr
+	serverClient, err := api.NewClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverClient.SetToken(token)
+
+	if err := serverClient.Sys().Mount("kv", &api.MountInput{
+		Type: "kv",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	apiProxy, err := NewAPIProxy(&APIProxyConfig{
+		Client: serverClient,
+		Logger: logger.Named("cache.apiproxy"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leaseCache, err := NewLeaseCache(&LeaseCacheConfig{
+		Client:      serverClient,
+		BaseContext: ctx,
+		Proxier:     apiProxy,
+		Logger:      logger.Named("cache.leasecache"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(consts.AgentPathCacheClear, leaseCache.HandleCacheClear(ctx))
+	mux.Handle("/", ProxyHandler(ctx, logger.Named("cache.handler"), leaseCache, inmemSink))
+
+	agentLn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       30 * time.Second,
+		IdleTimeout:       5 * time.Minute,
+		ErrorLog:          logger.StandardLogger(nil),
+	}
+	go server.Serve(agentLn)
+
+	testClient, err := serverClient.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := testClient.SetAddress("http://" + agentLn.Addr().String()); err != nil {
+		t.Fatal(err)
+	}
+	testClient.SetToken(token)
+
+	cleanup := func() {
+		agentLn.Close()
+		ln.Close()
+	}
+
+	return serverClient, testClient, cleanup
+}
+
+func TestCache_LeaseCaching(t *testing.T) {
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	serverClient, testClient, cleanup := setupClusterAndAgent(ctx, t, nil)
+	defer cleanup()
+
+	if _, err := serverClient.Logical().Write("kv/foo", map[string]interface{}{
+		"value": "bar",
+		"ttl":   "1h",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Read through the agent twice; the second read should be served from
+	// the cache
+	secret1, err := testClient.Logical().Read("kv/foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret1 == nil || secret1.LeaseID == "" {
+		t.Fatalf("expected a lease, got: %#v", secret1)
+	}
+
+	secret2, err := testClient.Logical().Read("kv/foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret1.LeaseID != secret2.LeaseID {
+		t.Fatalf("expected a cached response, got lease %q and %q", secret1.LeaseID, secret2.LeaseID)
+	}
+
+	// Revoke the lease through the agent, which evicts the cached response
+	if err := testClient.Sys().Revoke(secret1.LeaseID); err != nil {
+		t.Fatal(err)
+	}
+
+	secret3, err := testClient.Logical().Read("kv/foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret3.LeaseID == secret1.LeaseID {
+		t.Fatal("expected a new lease after revocation")
+	}
+
+	// Clear the cache and expect a new lease
+	req := testClient.NewRequest("POST", consts.AgentPathCacheClear)
+	if err := req.SetJSONBody(map[string]interface{}{"type": "all"}); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := testClient.RawRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	secret4, err := testClient.Logical().Read("kv/foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret4.LeaseID == secret3.LeaseID {
+		t.Fatal("expected a new lease after clearing the cache")
+	}
+}
+
+func TestCache_TokenCaching(t *testing.T) {
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	_, testClient, cleanup := setupClusterAndAgent(ctx, t, nil)
+	defer cleanup()
+
+	createRequest := &api.TokenCreateRequest{
+		Policies: []string{"default"},
+		TTL:      "1h",
+	}
+
+	secret1, err := testClient.Auth().Token().Create(createRequest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secret2, err := testClient.Auth().Token().Create(createRequest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret1.Auth.ClientToken != secret2.Auth.ClientToken {
+		t.Fatal("expected a cached token")
+	}
+
+	// Revoke the token by its accessor and expect a new token to be created
+	if err := testClient.Auth().Token().RevokeAccessor(secret1.Auth.Accessor); err != nil {
+		t.Fatal(err)
+	}
+
+	secret3, err := testClient.Auth().Token().Create(createRequest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret3.Auth.ClientToken == secret1.Auth.ClientToken {
+		t.Fatal("expected a new token after revocation")
+	}
+}
+
+func TestCache_AutoAuthToken(t *testing.T) {
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	inmemSink, err := inmem.New(&sink.SinkConfig{
+		Logger: logging.NewVaultLogger(hclog.Trace),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, testClient, cleanup := setupClusterAndAgent(ctx, t, inmemSink)
+	defer cleanup()
+
+	if err := inmemSink.WriteToken(testClient.Token()); err != nil {
+		t.Fatal(err)
+	}
+	testClient.ClearToken()
+
+	secret, err := testClient.Auth().Token().LookupSelf()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret == nil || secret.Data["id"] == nil {
+		t.Fatalf("bad: %#v", secret)
+	}
+}
+
+func TestCache_Persist(t *testing.T) {
+	logger := logging.NewVaultLogger(hclog.Trace)
+
+	core, _, token := vault.TestCoreUnsealed(t)
+	ln, addr := vaulthttp.TestServer(t, core)
+	defer ln.Close()
+
+	config := api.DefaultConfig()
+	config.Address = addr
+	client, err := api.NewClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetToken(token)
+
+	if err := client.Sys().Mount("kv", &api.MountInput{
+		Type: "kv",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Logical().Write("kv/foo", map[string]interface{}{
+		"value": "bar",
+		"ttl":   "1h",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	path, err := ioutil.TempDir("", "agent-cache-persist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(path)
+
+	// newCache opens the persistent cache and returns a lease cache using
+	// it, and whether it can be restored
+	newCache := func(ctx context.Context) (*LeaseCache, *KeyManager, *cacheboltdb.BoltStorage, bool, error) {
+		ps, err := cacheboltdb.NewBoltStorage(&cacheboltdb.BoltStorageConfig{
+			Path:   path,
+			Logger: logger.Named("cache.cacheboltdb"),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		km, restored, err := NewKeyManager(&KeyManagerConfig{
+			Storage:           ps,
+			Client:            client,
+			Logger:            logger.Named("cache.keymanager"),
+			RetrievalTokenTTL: time.Hour,
+		})
+		if err != nil {
+			return nil, nil, ps, false, err
+		}
+
+		apiProxy, err := NewAPIProxy(&APIProxyConfig{
+			Client: client,
+			Logger: logger.Named("cache.apiproxy"),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		lc, err := NewLeaseCache(&LeaseCacheConfig{
+			Client:      client,
+			BaseContext: ctx,
+			Proxier:     apiProxy,
+			Logger:      logger.Named("cache.leasecache"),
+			Storage:     ps,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return lc, km, ps, restored, nil
+	}
+
+	sendToken := func(lc *LeaseCache, body string) string {
+		resp, err := lc.Send(context.Background(), testSendRequest(t, "POST", "/v1/auth/token/create", token, body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		secret, err := api.ParseSecret(bytes.NewReader(resp.ResponseBody))
+		if err != nil || secret == nil || secret.Auth == nil {
+			t.Fatalf("bad: %#v, %v", secret, err)
+		}
+		return secret.Auth.ClientToken
+	}
+
+	// Cache two tokens and a lease in a first run
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	lc, km, ps, restored, err := newCache(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored {
+		t.Fatal("expected a new cache")
+	}
+	if err := km.WriteToken(token); err != nil {
+		t.Fatal(err)
+	}
+
+	token1 := sendToken(lc, `{"policies": ["default"], "ttl": "1h"}`)
+	token2 := sendToken(lc, `{"policies": ["default"], "ttl": "2h"}`)
+	resp, err := lc.Send(context.Background(), testSendRequest(t, "GET", "/v1/kv/foo", token, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, err := api.ParseSecret(bytes.NewReader(resp.ResponseBody))
+	if err != nil || secret == nil || secret.LeaseID == "" {
+		t.Fatalf("bad: %#v, %v", secret, err)
+	}
+	leaseID := secret.LeaseID
+
+	cancelFunc()
+	ps.Close()
+
+	// Revoke the second token while the agent is down
+	if err := client.Auth().Token().RevokeOrphan(token2); err != nil {
+		t.Fatal(err)
+	}
+
+	// The second run restores the valid entries only
+	ctx, cancelFunc = context.WithCancel(context.Background())
+	defer cancelFunc()
+	lc, _, ps, restored, err = newCache(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !restored {
+		t.Fatal("expected a restored cache")
+	}
+
+	// Entries which can't be validated because Vault is unreachable are kept
+	unreachableConfig := api.DefaultConfig()
+	unreachableConfig.Address = "http://127.0.0.1:0"
+	unreachableConfig.MaxRetries = 0
+	unreachable, err := api.NewClient(unreachableConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lc.client = unreachable
+	if err := lc.Restore(); err != nil {
+		t.Fatal(err)
+	}
+	if index, err := lc.db.Get(cachememdb.IndexNameToken, token1); err != nil || index != nil {
+		t.Fatalf("expected token not to be restored: %v", err)
+	}
+	if tokens, err := ps.GetByType(cacheboltdb.TokenType); err != nil || len(tokens) != 2 {
+		t.Fatalf("expected the tokens to be kept: %d, %v", len(tokens), err)
+	}
+	lc.client = client
+
+	if err := lc.Restore(); err != nil {
+		t.Fatal(err)
+	}
+
+	if index, err := lc.db.Get(cachememdb.IndexNameToken, token1); err != nil || index == nil {
+		t.Fatalf("expected token to be restored: %v", err)
+	}
+	if index, err := lc.db.Get(cachememdb.IndexNameToken, token2); err != nil || index != nil {
+		t.Fatalf("expected revoked token to be dropped: %v", err)
+	}
+	if index, err := lc.db.Get(cachememdb.IndexNameLease, leaseID); err != nil || index == nil {
+		t.Fatalf("expected lease to be restored: %v", err)
+	}
+	if tokens, err := ps.GetByType(cacheboltdb.TokenType); err != nil || len(tokens) != 1 {
+		t.Fatalf("expected the revoked token to be deleted: %d, %v", len(tokens), err)
+	}
+	ps.Close()
+
+	// The retrieval token was used, so without a new auto-auth token the key
+	// can't be retrieved again
+	_, _, ps, _, err = newCache(ctx)
+	ps.Close()
+	if err == nil {
+		t.Fatal("expected an error reusing the retrieval token")
+	}
+}
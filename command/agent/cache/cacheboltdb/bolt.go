@@ -0,0 +1,299 @@
+package cacheboltdb
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+	hclog "github.com/hashicorp/go-hclog"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	// DatabaseFileName is the name of the cache file in the persist path
+	DatabaseFileName = "vault-agent-cache.db"
+
+	// TokenType and LeaseType are the types of the persisted indexes
+	TokenType = "token"
+	LeaseType = "lease"
+
+	// AutoAuthTokenType is the type of the persisted auto-auth token, stored
+	// under AutoAuthTokenID
+	AutoAuthTokenType = "auto-auth"
+	AutoAuthTokenID   = "auto-auth-token"
+
+	// metaBucketName holds values stored in plaintext, such as the wrapped
+	// key, which are needed before anything can be decrypted
+	metaBucketName = "meta"
+
+	// KeySize is the size of the key used to encrypt the persisted values
+	KeySize = 32
+)
+
+var (
+	// ErrNoKey is returned when values are read or written before the key is
+	// set
+	ErrNoKey = errors.New("encryption key not set")
+
+	dataBuckets = []string{TokenType, LeaseType, AutoAuthTokenType}
+)
+
+// BoltStorage persists the agent cache in a BoltDB file. Values are encrypted
+// with AES-GCM, using their ID as additional data so that they can't be
+// swapped around.
+type BoltStorage struct {
+	db     *bolt.DB
+	logger hclog.Logger
+
+	l    sync.RWMutex
+	aead cipher.AEAD
+}
+
+// BoltStorageConfig is the configuration for NewBoltStorage
+type BoltStorageConfig struct {
+	Path   string
+	Logger hclog.Logger
+}
+
+// NewBoltStorage opens or creates the cache file in the configured path
+func NewBoltStorage(config *BoltStorageConfig) (*BoltStorage, error) {
+	if config.Path == "" {
+		return nil, errors.New("no path provided")
+	}
+	if err := os.MkdirAll(config.Path, 0700); err != nil {
+		return nil, errwrap.Wrapf("error creating persist path: {{err}}", err)
+	}
+
+	dbPath := filepath.Join(config.Path, DatabaseFileName)
+	db, err := bolt.Open(dbPath, 0600, &bolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		return nil, errwrap.Wrapf(fmt.Sprintf("error opening %s: {{err}}", dbPath), err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range append(dataBuckets, metaBucketName) {
+			if _, err := tx.CreateBucketIfNotExists([]byte(name)); err != nil {
+				return errwrap.Wrapf(fmt.Sprintf("error creating bucket %s: {{err}}", name), err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &BoltStorage{
+		db:     db,
+		logger: config.Logger,
+	}, nil
+}
+
+// SetKey sets the key used to encrypt and decrypt values
+func (b *BoltStorage) SetKey(key []byte) error {
+	aead, err := NewAEAD(key)
+	if err != nil {
+		return err
+	}
+
+	b.l.Lock()
+	b.aead = aead
+	b.l.Unlock()
+	return nil
+}
+
+// NewAEAD returns an AES-GCM AEAD for key, which must be KeySize bytes long
+func NewAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("key must be %d bytes long", KeySize)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Encrypt seals plaintext with aead, prepending the nonce
+func Encrypt(aead cipher.AEAD, plaintext, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+// Decrypt opens ciphertext sealed by Encrypt
+func Decrypt(aead cipher.AEAD, ciphertext, additionalData []byte) ([]byte, error) {
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce := ciphertext[:aead.NonceSize()]
+	return aead.Open(nil, nonce, ciphertext[aead.NonceSize():], additionalData)
+}
+
+func (b *BoltStorage) getAEAD() (cipher.AEAD, error) {
+	b.l.RLock()
+	defer b.l.RUnlock()
+
+	if b.aead == nil {
+		return nil, ErrNoKey
+	}
+	return b.aead, nil
+}
+
+// Set encrypts and stores the value under id in the bucket for indexType
+func (b *BoltStorage) Set(id string, plaintext []byte, indexType string) error {
+	aead, err := b.getAEAD()
+	if err != nil {
+		return err
+	}
+	ciphertext, err := Encrypt(aead, plaintext, []byte(id))
+	if err != nil {
+		return errwrap.Wrapf("error encrypting value: {{err}}", err)
+	}
+
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(indexType))
+		if bucket == nil {
+			return fmt.Errorf("bucket %q not found", indexType)
+		}
+		return bucket.Put([]byte(id), ciphertext)
+	})
+}
+
+// Delete removes the value stored under id, whatever its type
+func (b *BoltStorage) Delete(id string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		for _, name := range dataBuckets {
+			if err := tx.Bucket([]byte(name)).Delete([]byte(id)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Get returns the decrypted value stored under id in the bucket for
+// indexType, or nil if there is none
+func (b *BoltStorage) Get(id string, indexType string) ([]byte, error) {
+	aead, err := b.getAEAD()
+	if err != nil {
+		return nil, err
+	}
+
+	var ciphertext []byte
+	err = b.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(indexType))
+		if bucket == nil {
+			return fmt.Errorf("bucket %q not found", indexType)
+		}
+		if v := bucket.Get([]byte(id)); v != nil {
+			ciphertext = append([]byte(nil), v...)
+		}
+		return nil
+	})
+	if err != nil || ciphertext == nil {
+		return nil, err
+	}
+
+	return Decrypt(aead, ciphertext, []byte(id))
+}
+
+// GetByType returns the decrypted values of the given type, keyed by ID
+func (b *BoltStorage) GetByType(indexType string) (map[string][]byte, error) {
+	aead, err := b.getAEAD()
+	if err != nil {
+		return nil, err
+	}
+
+	ciphertexts := make(map[string][]byte)
+	err = b.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(indexType))
+		if bucket == nil {
+			return fmt.Errorf("bucket %q not found", indexType)
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			ciphertexts[string(k)] = append([]byte(nil), v...)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string][]byte, len(ciphertexts))
+	for id, ciphertext := range ciphertexts {
+		plaintext, err := Decrypt(aead, ciphertext, []byte(id))
+		if err != nil {
+			return nil, errwrap.Wrapf(fmt.Sprintf("error decrypting %s %s: {{err}}", indexType, id), err)
+		}
+		values[id] = plaintext
+	}
+	return values, nil
+}
+
+// GetMeta returns the plaintext value stored under key, or nil
+func (b *BoltStorage) GetMeta(key string) ([]byte, error) {
+	var value []byte
+	err := b.db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket([]byte(metaBucketName)).Get([]byte(key)); v != nil {
+			value = append([]byte(nil), v...)
+		}
+		return nil
+	})
+	return value, err
+}
+
+// SetMeta stores value in plaintext under key
+func (b *BoltStorage) SetMeta(key string, value []byte) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(metaBucketName)).Put([]byte(key), value)
+	})
+}
+
+// Clear removes all the values, keeping the meta values
+func (b *BoltStorage) Clear() error {
+	return b.clearBuckets(dataBuckets)
+}
+
+// Reset removes all the values, including the meta values, for when the key
+// of the stored values is lost
+func (b *BoltStorage) Reset() error {
+	return b.clearBuckets(append(dataBuckets, metaBucketName))
+}
+
+func (b *BoltStorage) clearBuckets(names []string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		for _, name := range names {
+			if err := tx.DeleteBucket([]byte(name)); err != nil {
+				return err
+			}
+			if _, err := tx.CreateBucket([]byte(name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Close closes the file
+func (b *BoltStorage) Close() error {
+	return b.db.Close()
+}
+
+// WriteToken implements sink.Sink, storing the auto-auth token so that it can
+// be reused after a restart
+func (b *BoltStorage) WriteToken(token string) error {
+	if token == "" {
+		return nil
+	}
+	return b.Set(AutoAuthTokenID, []byte(token), AutoAuthTokenType)
+}
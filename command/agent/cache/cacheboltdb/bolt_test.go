@@ -0,0 +1,155 @@
+package cacheboltdb
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/helper/logging"
+	bolt "go.etcd.io/bbolt"
+)
+
+func testBoltStorage(t *testing.T) (*BoltStorage, func()) {
+	t.Helper()
+
+	path, err := ioutil.TempDir("", "agent-cache-bolt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewBoltStorage(&BoltStorageConfig{
+		Path:   path,
+		Logger: logging.NewVaultLogger(hclog.Trace),
+	})
+	if err != nil {
+		os.RemoveAll(path)
+		t.Fatal(err)
+	}
+
+	return b, func() {
+		b.Close()
+		os.RemoveAll(path)
+	}
+}
+
+func TestBoltStorage(t *testing.T) {
+	b, cleanup := testBoltStorage(t)
+	defer cleanup()
+
+	if err := b.Set("foo", []byte("bar"), TokenType); err != ErrNoKey {
+		t.Fatalf("expected ErrNoKey, got %v", err)
+	}
+
+	if err := b.SetKey([]byte("short")); err == nil {
+		t.Fatal("expected an error with a short key")
+	}
+	if err := b.SetKey(bytes.Repeat([]byte{1}, KeySize)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.Set("foo", []byte("bar"), TokenType); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Set("baz", []byte("qux"), LeaseType); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.SetMeta("meta", []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+
+	value, err := b.Get("foo", TokenType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(value) != "bar" {
+		t.Fatalf("bad: %q", value)
+	}
+
+	// Values are not stored in plaintext
+	err = b.db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket([]byte(TokenType)).Get([]byte("foo")); bytes.Contains(v, []byte("bar")) {
+			t.Fatalf("value stored in plaintext: %q", v)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Missing values are nil
+	value, err = b.Get("foo", LeaseType)
+	if err != nil || value != nil {
+		t.Fatalf("bad: %q, %v", value, err)
+	}
+
+	values, err := b.GetByType(LeaseType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 1 || string(values["baz"]) != "qux" {
+		t.Fatalf("bad: %#v", values)
+	}
+
+	// Values can't be decrypted with another key
+	if err := b.SetKey(bytes.Repeat([]byte{2}, KeySize)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Get("foo", TokenType); err == nil {
+		t.Fatal("expected an error with the wrong key")
+	}
+	if err := b.SetKey(bytes.Repeat([]byte{1}, KeySize)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.Delete("foo"); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := b.Get("foo", TokenType); err != nil || value != nil {
+		t.Fatalf("bad: %q, %v", value, err)
+	}
+
+	// Clear keeps the meta values
+	if err := b.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	if values, err := b.GetByType(LeaseType); err != nil || len(values) != 0 {
+		t.Fatalf("bad: %#v, %v", values, err)
+	}
+	if value, err := b.GetMeta("meta"); err != nil || string(value) != "value" {
+		t.Fatalf("bad: %q, %v", value, err)
+	}
+
+	// Reset doesn't
+	if err := b.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := b.GetMeta("meta"); err != nil || value != nil {
+		t.Fatalf("bad: %q, %v", value, err)
+	}
+}
+
+func TestBoltStorage_SwappedValues(t *testing.T) {
+	b, cleanup := testBoltStorage(t)
+	defer cleanup()
+
+	if err := b.SetKey(bytes.Repeat([]byte{1}, KeySize)); err != nil {
+		t.Fatal(err)
+	}
+
+	// A value moved to another ID fails to decrypt
+	aead, err := b.getAEAD()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphertext, err := Encrypt(aead, []byte("bar"), []byte("foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Decrypt(aead, ciphertext, []byte("other")); err == nil {
+		t.Fatal("expected an error decrypting with another ID")
+	}
+	if plaintext, err := Decrypt(aead, ciphertext, []byte("foo")); err != nil || string(plaintext) != "bar" {
+		t.Fatalf("bad: %q, %v", plaintext, err)
+	}
+}
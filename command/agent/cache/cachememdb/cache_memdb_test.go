@@ -0,0 +1,309 @@
+package cachememdb
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-test/deep"
+)
+
+func testContextInfo() *ContextInfo {
+	ctx, cancelFunc := context.WithCancel(context.Background())
+
+	return &ContextInfo{
+		Ctx:        ctx,
+		CancelFunc: cancelFunc,
+	}
+}
+
+func TestNew(t *testing.T) {
+	_, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCacheMemDB_Get(t *testing.T) {
+	cache, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Test invalid index name
+	_, err = cache.Get("foo", "bar")
+	if err == nil {
+		t.Fatal("expected error")
+	}
+
+	// Test on empty cache
+	index, err := cache.Get(IndexNameID, "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if index != nil {
+		t.Fatalf("expected nil index, got: %v", index)
+	}
+
+	// Populate cache
+	in := &Index{
+		ID:            "test_id",
+		Namespace:     "test_ns/",
+		RequestPath:   "/v1/request/path",
+		Token:         "test_token",
+		TokenAccessor: "test_accessor",
+		Lease:         "test_lease",
+		Response:      []byte("hello world"),
+	}
+
+	if err := cache.Set(in); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name        string
+		indexName   string
+		indexValues []interface{}
+	}{
+		{
+			"by_index_id",
+			"id",
+			[]interface{}{in.ID},
+		},
+		{
+			"by_request_path",
+			"request_path",
+			[]interface{}{in.Namespace, in.RequestPath},
+		},
+		{
+			"by_lease",
+			"lease",
+			[]interface{}{in.Lease},
+		},
+		{
+			"by_token",
+			"token",
+			[]interface{}{in.Token},
+		},
+		{
+			"by_token_accessor",
+			"token_accessor",
+			[]interface{}{in.TokenAccessor},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			out, err := cache.Get(tc.indexName, tc.indexValues...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := deep.Equal(in, out); diff != nil {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestCacheMemDB_GetByPrefix(t *testing.T) {
+	cache, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Test invalid index name
+	_, err = cache.GetByPrefix("foo", "bar", "baz")
+	if err == nil {
+		t.Fatal("expected error")
+	}
+
+	// Test on empty cache
+	index, err := cache.GetByPrefix(IndexNameRequestPath, "foo", "bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if index != nil {
+		t.Fatalf("expected nil index, got: %v", index)
+	}
+
+	// Populate cache
+	in := &Index{
+		ID:            "test_id",
+		Namespace:     "test_ns/",
+		RequestPath:   "/v1/request/path/1",
+		Token:         "test_token",
+		TokenParent:   "test_token_parent",
+		TokenAccessor: "test_accessor",
+		Lease:         "path/to/test_lease/1",
+		LeaseToken:    "test_lease_token",
+		Response:      []byte("hello world"),
+	}
+
+	if err := cache.Set(in); err != nil {
+		t.Fatal(err)
+	}
+
+	// Populate cache
+	in2 := &Index{
+		ID:            "test_id_2",
+		Namespace:     "test_ns/",
+		RequestPath:   "/v1/request/path/2",
+		Token:         "test_token2",
+		TokenParent:   "test_token_parent",
+		TokenAccessor: "test_accessor2",
+		Lease:         "path/to/test_lease/2",
+		LeaseToken:    "test_lease_token",
+		Response:      []byte("hello world"),
+	}
+
+	if err := cache.Set(in2); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name        string
+		indexName   string
+		indexValues []interface{}
+	}{
+		{
+			"by_request_path",
+			"request_path",
+			[]interface{}{"test_ns/", "/v1/request/path"},
+		},
+		{
+			"by_lease",
+			"lease",
+			[]interface{}{"path/to/test_lease"},
+		},
+		{
+			"by_token_parent",
+			"token_parent",
+			[]interface{}{"test_token_parent"},
+		},
+		{
+			"by_lease_token",
+			"lease_token",
+			[]interface{}{"test_lease_token"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			out, err := cache.GetByPrefix(tc.indexName, tc.indexValues...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := deep.Equal([]*Index{in, in2}, out); diff != nil {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestCacheMemDB_Set(t *testing.T) {
+	cache, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cache.Set(nil); err == nil {
+		t.Fatal("expected error")
+	}
+
+	index := &Index{
+		ID:           "test_id",
+		Namespace:    "test_ns/",
+		RequestPath:  "/v1/request/path",
+		Lease:        "test_lease",
+		LeaseToken:   "test_lease_token",
+		Response:     []byte("hello world"),
+		RenewCtxInfo: testContextInfo(),
+	}
+	if err := cache.Set(index); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := cache.Get(IndexNameLeaseToken, "test_lease_token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != index {
+		t.Fatalf("bad: %#v", out)
+	}
+}
+
+func TestCacheMemDB_Evict(t *testing.T) {
+	cache, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Test on empty cache
+	if err := cache.Evict(IndexNameID, "foo"); err != nil {
+		t.Fatal(err)
+	}
+
+	in := &Index{
+		ID:            "test_id",
+		Namespace:     "test_ns/",
+		RequestPath:   "/v1/request/path",
+		Token:         "test_token",
+		TokenAccessor: "test_token_accessor",
+		Lease:         "test_lease",
+		Response:      []byte("hello world"),
+	}
+	if err := cache.Set(in); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cache.Evict(IndexNameToken, "test_token"); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := cache.Get(IndexNameID, "test_id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != nil {
+		t.Fatalf("expected nil entry, got = %#v", out)
+	}
+}
+
+func TestCacheMemDB_Flush(t *testing.T) {
+	cache, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	in := &Index{
+		ID:          "test_id",
+		Token:       "test_token",
+		Lease:       "test_lease",
+		Namespace:   "test_ns/",
+		RequestPath: "/v1/request/path",
+		Response:    []byte("hello world"),
+	}
+	if err := cache.Set(in); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cache.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := cache.Get(IndexNameID, "test_id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != nil {
+		t.Fatalf("expected cache to be empty, got = %v", out)
+	}
+
+	indexes, err := cache.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(indexes) != 0 {
+		t.Fatalf("expected cache to be empty, got = %v", indexes)
+	}
+}
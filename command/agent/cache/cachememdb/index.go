@@ -0,0 +1,80 @@
+package cachememdb
+
+import (
+	"context"
+
+	"github.com/hashicorp/vault/helper/jsonutil"
+)
+
+// Index holds a cached response along with the values it is looked up and
+// evicted by.
+type Index struct {
+	// ID is a hash of the request and the token it was made with, and is
+	// the key of the cache entry.
+	ID string
+
+	// Namespace is the namespace the request was made in, as set in the
+	// X-Vault-Namespace header.
+	Namespace string
+
+	// RequestPath is the path of the request that produced the response.
+	RequestPath string
+
+	// Token is the token returned by the response, if it created one.
+	Token string
+
+	// TokenParent is the token used to create Token. It is used to evict
+	// child tokens when their parent is revoked.
+	TokenParent string
+
+	// TokenAccessor is the accessor of Token.
+	TokenAccessor string
+
+	// Lease is the lease ID returned by the response, if it created one.
+	Lease string
+
+	// LeaseToken is the token the lease was created with. It is used to
+	// evict leases when the token that created them is revoked.
+	LeaseToken string
+
+	// Response is the serialized HTTP response.
+	Response []byte
+
+	// RenewCtxInfo holds the context that stops renewal of the token or
+	// lease when the entry is evicted. It is not serialized.
+	RenewCtxInfo *ContextInfo `json:"-"`
+}
+
+// Serialize returns the index in a form suitable for persisting it.
+func (i *Index) Serialize() ([]byte, error) {
+	return jsonutil.EncodeJSON(i)
+}
+
+// Deserialize returns the index serialized in b.
+func Deserialize(b []byte) (*Index, error) {
+	index := new(Index)
+	if err := jsonutil.DecodeJSON(b, index); err != nil {
+		return nil, err
+	}
+	return index, nil
+}
+
+// ContextInfo is a context along with its cancel function and a channel that
+// is closed once everything using the context has stopped.
+type ContextInfo struct {
+	Ctx        context.Context
+	CancelFunc context.CancelFunc
+	DoneCh     chan struct{}
+}
+
+// NewContextInfo returns a ContextInfo whose context is derived from ctx.
+func NewContextInfo(ctx context.Context) *ContextInfo {
+	if ctx == nil {
+		return nil
+	}
+
+	ctxInfo := new(ContextInfo)
+	ctxInfo.Ctx, ctxInfo.CancelFunc = context.WithCancel(ctx)
+	ctxInfo.DoneCh = make(chan struct{})
+	return ctxInfo
+}
@@ -0,0 +1,86 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/hashicorp/errwrap"
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/command/agent/sink"
+	"github.com/hashicorp/vault/helper/consts"
+)
+
+// maxRequestSize is the largest request body the agent proxies, matching the
+// default of the Vault server.
+const maxRequestSize = 32 * 1024 * 1024
+
+// ProxyHandler returns a handler that sends incoming requests through proxier.
+// If inmemSink is set, requests made without a token use the auto-auth token
+// stored in it.
+func ProxyHandler(ctx context.Context, logger hclog.Logger, proxier Proxier, inmemSink sink.Sink) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger.Info("received request", "path", r.URL.Path, "method", r.Method)
+
+		token := r.Header.Get(consts.AuthHeaderName)
+		if token == "" && inmemSink != nil {
+			logger.Debug("using auto auth token", "path", r.URL.Path, "method", r.Method)
+			token = inmemSink.(sink.SinkReader).Token()
+		}
+
+		// Parse and reset body.
+		reqBody, err := ioutil.ReadAll(io.LimitReader(r.Body, maxRequestSize))
+		if err != nil {
+			logger.Error("failed to read request body")
+			respondError(w, http.StatusInternalServerError, errors.New("failed to read request body"))
+			return
+		}
+		if r.Body != nil {
+			r.Body.Close()
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(reqBody))
+		req := &SendRequest{
+			Token:       token,
+			Request:     r,
+			RequestBody: reqBody,
+		}
+
+		// The proxied request is cancelled when the client goes away or the
+		// agent shuts down
+		reqCtx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+		go func() {
+			select {
+			case <-ctx.Done():
+				cancel()
+			case <-reqCtx.Done():
+			}
+		}()
+
+		resp, err := proxier.Send(reqCtx, req)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, errwrap.Wrapf("failed to get the response: {{err}}", err))
+			return
+		}
+
+		// Set headers
+		copyHeader(w.Header(), resp.Response.Header)
+
+		// Set response body
+		w.Header().Set("Content-Length", fmt.Sprintf("%d", len(resp.ResponseBody)))
+		w.WriteHeader(resp.Response.StatusCode)
+		w.Write(resp.ResponseBody)
+	})
+}
+
+func copyHeader(dst, src http.Header) {
+	for k, vv := range src {
+		for _, v := range vv {
+			dst.Add(k, v)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package cache
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/helper/logging"
+)
+
+// blockingProxier blocks until the context of the request is done
+type blockingProxier struct {
+	sendCh chan struct{}
+}
+
+func (p *blockingProxier) Send(ctx context.Context, req *SendRequest) (*SendResponse, error) {
+	close(p.sendCh)
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestProxyHandler_Cancel(t *testing.T) {
+	logger := logging.NewVaultLogger(hclog.Trace)
+
+	for _, name := range []string{"client", "agent"} {
+		agentCtx, agentCancel := context.WithCancel(context.Background())
+		reqCtx, reqCancel := context.WithCancel(context.Background())
+
+		proxier := &blockingProxier{
+			sendCh: make(chan struct{}),
+		}
+		handler := ProxyHandler(agentCtx, logger, proxier, nil)
+
+		req := httptest.NewRequest(http.MethodGet, "/v1/secret/foo", nil).WithContext(reqCtx)
+		rec := httptest.NewRecorder()
+		doneCh := make(chan struct{})
+		go func() {
+			handler.ServeHTTP(rec, req)
+			close(doneCh)
+		}()
+
+		<-proxier.sendCh
+		if name == "client" {
+			reqCancel()
+		} else {
+			agentCancel()
+		}
+
+		select {
+		case <-doneCh:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("the proxied request wasn't cancelled with the %s", name)
+		}
+		if rec.Code != http.StatusInternalServerError {
+			t.Fatalf("bad: %d", rec.Code)
+		}
+
+		agentCancel()
+		reqCancel()
+	}
+}
@@ -0,0 +1,257 @@
+package cache
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/command/agent/cache/cacheboltdb"
+)
+
+const (
+	// metaKeyName holds the cache key encrypted with the key file
+	metaKeyName = "key"
+
+	// metaRetrievalTokenName holds the wrapping token of the cache key when
+	// there is no key file
+	metaRetrievalTokenName = "retrieval_token"
+)
+
+// KeyManagerConfig is the configuration of the key of the persistent cache
+type KeyManagerConfig struct {
+	Storage *cacheboltdb.BoltStorage
+	Client  *api.Client
+	Logger  hclog.Logger
+
+	// KeyFile is the path of a file with a key used to encrypt the cache
+	// key. If not set, the cache key is response-wrapped by Vault with the
+	// auto-auth token, and the wrapping token, valid for RetrievalTokenTTL,
+	// is stored instead.
+	KeyFile           string
+	RetrievalTokenTTL time.Duration
+}
+
+// KeyManager sets the key of the persistent cache, reusing the key of a
+// previous run if it can be retrieved, and keeps it retrievable by the next
+// run.
+type KeyManager struct {
+	storage *cacheboltdb.BoltStorage
+	client  *api.Client
+	logger  hclog.Logger
+	ttl     time.Duration
+	key     []byte
+
+	l           sync.Mutex
+	latestToken string
+}
+
+// NewKeyManager sets the key of the storage. It returns whether the key of a
+// previous run was retrieved, in which case the storage can be restored. If
+// it can't be, an error is returned, and the caller can clear the storage
+// and call it again to start with a new key.
+func NewKeyManager(conf *KeyManagerConfig) (*KeyManager, bool, error) {
+	k := &KeyManager{
+		storage: conf.Storage,
+		client:  conf.Client,
+		logger:  conf.Logger,
+	}
+	if conf.KeyFile == "" {
+		k.ttl = conf.RetrievalTokenTTL
+	}
+
+	var restored bool
+	var err error
+	if conf.KeyFile != "" {
+		k.key, restored, err = k.keyFromKeyFile(conf.KeyFile)
+	} else {
+		k.key, restored, err = k.keyFromRetrievalToken()
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	if err := k.storage.SetKey(k.key); err != nil {
+		return nil, false, err
+	}
+
+	return k, restored, nil
+}
+
+func newKey() ([]byte, error) {
+	key := make([]byte, cacheboltdb.KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, errwrap.Wrapf("error generating key: {{err}}", err)
+	}
+	return key, nil
+}
+
+// keyFromKeyFile decrypts the stored key with the key file, or stores a new
+// key encrypted with it. The key file holds the key, either raw or base64
+// encoded.
+func (k *KeyManager) keyFromKeyFile(path string) ([]byte, bool, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, false, errwrap.Wrapf("error reading key file: {{err}}", err)
+	}
+	fileKey := contents
+	if decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(contents))); err == nil {
+		fileKey = decoded
+	}
+	aead, err := cacheboltdb.NewAEAD(fileKey)
+	if err != nil {
+		return nil, false, errwrap.Wrapf("invalid key file: {{err}}", err)
+	}
+
+	encrypted, err := k.storage.GetMeta(metaKeyName)
+	if err != nil {
+		return nil, false, err
+	}
+	if encrypted != nil {
+		key, err := cacheboltdb.Decrypt(aead, encrypted, []byte(metaKeyName))
+		if err != nil {
+			return nil, false, errwrap.Wrapf("error decrypting key with key file: {{err}}", err)
+		}
+		return key, true, nil
+	}
+
+	key, err := newKey()
+	if err != nil {
+		return nil, false, err
+	}
+	encrypted, err = cacheboltdb.Encrypt(aead, key, []byte(metaKeyName))
+	if err != nil {
+		return nil, false, err
+	}
+	if err := k.storage.SetMeta(metaKeyName, encrypted); err != nil {
+		return nil, false, err
+	}
+	return key, false, nil
+}
+
+// keyFromRetrievalToken unwraps the key with the stored retrieval token, or
+// returns a new key. Since the retrieval token can only be used once, the
+// key is wrapped again once an auto-auth token is received by WriteToken.
+func (k *KeyManager) keyFromRetrievalToken() ([]byte, bool, error) {
+	retrievalToken, err := k.storage.GetMeta(metaRetrievalTokenName)
+	if err != nil {
+		return nil, false, err
+	}
+	if retrievalToken == nil {
+		key, err := newKey()
+		return key, false, err
+	}
+
+	client, err := k.client.Clone()
+	if err != nil {
+		return nil, false, err
+	}
+	client.ClearToken()
+	secret, err := client.Logical().Unwrap(string(retrievalToken))
+	if err != nil {
+		return nil, false, errwrap.Wrapf("error unwrapping key with retrieval token: {{err}}", err)
+	}
+	if secret == nil || secret.Data == nil {
+		return nil, false, errors.New("unwrapping key returned no data")
+	}
+	encoded, _ := secret.Data["key"].(string)
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(key) != cacheboltdb.KeySize {
+		return nil, false, errors.New("unwrapped key is invalid")
+	}
+	return key, true, nil
+}
+
+// WriteToken implements sink.Sink. Without a key file, the key is wrapped
+// with each new auto-auth token, so that a retrieval token is stored for the
+// next run.
+func (k *KeyManager) WriteToken(token string) error {
+	if token == "" || k.ttl == 0 {
+		return nil
+	}
+
+	k.l.Lock()
+	k.latestToken = token
+	k.l.Unlock()
+
+	return k.wrapKey(token)
+}
+
+// Run wraps the key again before the retrieval token expires, with the latest
+// auto-auth token, until ctx is done. It does nothing with a key file.
+func (k *KeyManager) Run(ctx context.Context) {
+	if k.ttl == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(k.ttl / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			k.l.Lock()
+			token := k.latestToken
+			k.l.Unlock()
+			if token == "" {
+				continue
+			}
+			if err := k.wrapKey(token); err != nil {
+				k.logger.Error("error wrapping cache key", "error", err)
+			}
+		}
+	}
+}
+
+// wrapKey stores a new retrieval token for the key, and revokes the previous
+// one by unwrapping it.
+func (k *KeyManager) wrapKey(token string) error {
+	client, err := k.client.Clone()
+	if err != nil {
+		return err
+	}
+	client.SetToken(token)
+	client.SetWrappingLookupFunc(func(string, string) string {
+		return k.ttl.String()
+	})
+	secret, err := client.Logical().Write("sys/wrapping/wrap", map[string]interface{}{
+		"key": base64.StdEncoding.EncodeToString(k.key),
+	})
+	if err != nil {
+		return errwrap.Wrapf("error wrapping cache key: {{err}}", err)
+	}
+	if secret == nil || secret.WrapInfo == nil || secret.WrapInfo.Token == "" {
+		return errors.New("wrapping cache key returned no wrapping token")
+	}
+
+	previous, err := k.storage.GetMeta(metaRetrievalTokenName)
+	if err != nil {
+		return err
+	}
+	if err := k.storage.SetMeta(metaRetrievalTokenName, []byte(secret.WrapInfo.Token)); err != nil {
+		return err
+	}
+	k.logger.Debug("stored new retrieval token for the cache key")
+
+	if previous != nil {
+		unwrapClient, err := k.client.Clone()
+		if err != nil {
+			return err
+		}
+		unwrapClient.ClearToken()
+		if _, err := unwrapClient.Logical().Unwrap(string(previous)); err != nil {
+			k.logger.Trace("previous retrieval token could not be revoked", "error", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,846 @@
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/command/agent/cache/cacheboltdb"
+	"github.com/hashicorp/vault/command/agent/cache/cachememdb"
+	"github.com/hashicorp/vault/helper/consts"
+	"github.com/hashicorp/vault/helper/jsonutil"
+	"github.com/hashicorp/vault/helper/locksutil"
+	"github.com/hashicorp/vault/helper/namespace"
+	"github.com/hashicorp/vault/helper/parseutil"
+)
+
+const (
+	vaultPathTokenCreateOrphan   = "/v1/auth/token/create-orphan"
+	vaultPathTokenRevoke         = "/v1/auth/token/revoke"
+	vaultPathTokenRevokeSelf     = "/v1/auth/token/revoke-self"
+	vaultPathTokenRevokeAccessor = "/v1/auth/token/revoke-accessor"
+	vaultPathTokenRevokeOrphan   = "/v1/auth/token/revoke-orphan"
+	vaultPathLeaseRevoke         = "/v1/sys/leases/revoke"
+	vaultPathLeaseRevokeForce    = "/v1/sys/leases/revoke-force"
+	vaultPathLeaseRevokePrefix   = "/v1/sys/leases/revoke-prefix"
+	vaultPathLegacyRevoke        = "/v1/sys/revoke"
+	vaultPathLegacyRevokeForce   = "/v1/sys/revoke-force"
+	vaultPathLegacyRevokePrefix  = "/v1/sys/revoke-prefix"
+
+	// rootNamespace is the value indexed for requests made without a
+	// namespace header, so that the request path index always has a
+	// namespace component to match against.
+	rootNamespace = "root/"
+)
+
+var (
+	errInvalidType = errors.New("invalid type provided")
+)
+
+// LeaseCache is an implementation of Proxier that handles the caching of
+// responses. It passes the incoming request to an underlying Proxier
+// implementation.
+type LeaseCache struct {
+	proxier     Proxier
+	client      *api.Client
+	logger      hclog.Logger
+	db          *cachememdb.CacheMemDB
+	baseCtxInfo *cachememdb.ContextInfo
+
+	// ps persists the cached entries across restarts, if set
+	ps *cacheboltdb.BoltStorage
+
+	// idLocks serializes requests that map to the same cache entry, so that
+	// concurrent misses for the same request result in a single request to
+	// Vault.
+	idLocks []*locksutil.LockEntry
+}
+
+// LeaseCacheConfig is the configuration for initializing a new
+// Lease.
+type LeaseCacheConfig struct {
+	Client      *api.Client
+	BaseContext context.Context
+	Proxier     Proxier
+	Logger      hclog.Logger
+
+	// Storage optionally persists the cache, see Restore
+	Storage *cacheboltdb.BoltStorage
+}
+
+// NewLeaseCache creates a new instance of a LeaseCache.
+func NewLeaseCache(conf *LeaseCacheConfig) (*LeaseCache, error) {
+	if conf == nil {
+		return nil, errors.New("nil configuration provided")
+	}
+
+	if conf.Proxier == nil || conf.Logger == nil {
+		return nil, fmt.Errorf("missing configuration required params: %v", conf)
+	}
+
+	if conf.Client == nil {
+		return nil, fmt.Errorf("nil API client")
+	}
+
+	db, err := cachememdb.New()
+	if err != nil {
+		return nil, err
+	}
+
+	// Create a base context for the lease cache layer
+	baseCtxInfo := cachememdb.NewContextInfo(conf.BaseContext)
+
+	return &LeaseCache{
+		client:      conf.Client,
+		proxier:     conf.Proxier,
+		logger:      conf.Logger,
+		db:          db,
+		baseCtxInfo: baseCtxInfo,
+		ps:          conf.Storage,
+		idLocks:     locksutil.CreateLocks(),
+	}, nil
+}
+
+// Send performs a cache lookup on the incoming request. If it's a cache hit,
+// it will return the cached response, otherwise it will delegate to the
+// underlying Proxier and cache the received response.
+func (c *LeaseCache) Send(ctx context.Context, req *SendRequest) (*SendResponse, error) {
+	// Compute the index ID
+	id, err := computeIndexID(req)
+	if err != nil {
+		c.logger.Error("failed to compute cache key", "error", err)
+		return nil, err
+	}
+
+	idLock := locksutil.LockForKey(c.idLocks, id)
+	idLock.Lock()
+	defer idLock.Unlock()
+
+	// Check if the response for this request is already in the cache
+	index, err := c.db.Get(cachememdb.IndexNameID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	// Cached request is found, deserialize the response and return early
+	if index != nil {
+		c.logger.Debug("returning cached response", "path", req.Request.URL.Path)
+
+		reader := bufio.NewReader(bytes.NewReader(index.Response))
+		resp, err := http.ReadResponse(reader, nil)
+		if err != nil {
+			c.logger.Error("failed to deserialize response", "error", err)
+			return nil, err
+		}
+		defer resp.Body.Close()
+
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		return &SendResponse{
+			Response: &api.Response{
+				Response: resp,
+			},
+			ResponseBody: body,
+		}, nil
+	}
+
+	c.logger.Debug("forwarding request", "path", req.Request.URL.Path, "method", req.Request.Method)
+
+	// Pass the request down and get a response
+	resp, err := c.proxier.Send(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only successful responses are cached or acted upon
+	if resp.Response.StatusCode >= 300 {
+		return resp, nil
+	}
+
+	// Evict any entries invalidated by a revocation made through the agent
+	if err := c.handleRevocationRequest(req); err != nil {
+		c.logger.Error("failed to evict revoked entries from the cache", "error", err)
+	}
+
+	// Get the namespace from the request header
+	ns := requestNamespace(req.Request)
+
+	index = &cachememdb.Index{
+		ID:          id,
+		Namespace:   ns,
+		RequestPath: req.Request.URL.Path,
+	}
+
+	secret, err := api.ParseSecret(bytes.NewReader(resp.ResponseBody))
+	if err != nil {
+		// Not every successful response is a secret, e.g. a 204 or a raw
+		// response, so there is nothing to cache
+		c.logger.Trace("response is not a secret, skipping cache", "path", req.Request.URL.Path)
+		return resp, nil
+	}
+
+	switch {
+	case secret == nil || secret.WrapInfo != nil:
+		// Wrapped responses are single-use and are never cached
+		return resp, nil
+
+	case secret.LeaseID != "":
+		c.logger.Debug("processing lease response", "path", req.Request.URL.Path)
+
+		// The lease is tied to the request token, so that it is evicted
+		// when that token is revoked through the agent
+		index.Lease = secret.LeaseID
+		index.LeaseToken = req.Token
+
+	case secret.Auth != nil && secret.Auth.ClientToken != "":
+		c.logger.Debug("processing auth response", "path", req.Request.URL.Path)
+
+		// Tokens created without a parent, such as those from a login or
+		// orphan tokens, are not tied to the request token
+		if !isLoginPath(req.Request.URL.Path) && !strings.HasPrefix(req.Request.URL.Path, vaultPathTokenCreateOrphan) {
+			index.TokenParent = req.Token
+		}
+
+		index.Token = secret.Auth.ClientToken
+		index.TokenAccessor = secret.Auth.Accessor
+
+	default:
+		// The response is neither a lease nor a token, so the response is
+		// not cached.
+		c.logger.Trace("pass-through response; secret not renewable", "path", req.Request.URL.Path)
+		return resp, nil
+	}
+
+	// Serialize the response to store it in the cached index
+	var respBytes bytes.Buffer
+	cachedResp := *resp.Response.Response
+	cachedResp.Body = ioutil.NopCloser(bytes.NewReader(resp.ResponseBody))
+	cachedResp.ContentLength = int64(len(resp.ResponseBody))
+	cachedResp.TransferEncoding = nil
+	if err := cachedResp.Write(&respBytes); err != nil {
+		c.logger.Error("failed to serialize response", "error", err)
+		return nil, err
+	}
+
+	// Reset the response body for upper layers to read
+	resp.Response.Body = ioutil.NopCloser(bytes.NewReader(resp.ResponseBody))
+
+	index.Response = respBytes.Bytes()
+	index.RenewCtxInfo = cachememdb.NewContextInfo(c.baseCtxInfo.Ctx)
+
+	// Store the index in the cache
+	c.logger.Debug("storing response into the cache", "path", req.Request.URL.Path)
+	if err := c.db.Set(index); err != nil {
+		c.logger.Error("failed to cache the proxied response", "error", err)
+		index.RenewCtxInfo.CancelFunc()
+		return nil, err
+	}
+	c.persist(index)
+
+	// Start renewing the secret in the response
+	go c.startRenewing(index, secret)
+
+	return resp, nil
+}
+
+func (c *LeaseCache) startRenewing(index *cachememdb.Index, secret *api.Secret) {
+	ctx := index.RenewCtxInfo.Ctx
+	defer close(index.RenewCtxInfo.DoneCh)
+
+	cleanup := func() {
+		c.logger.Debug("evicting index from cache", "id", index.ID, "path", index.RequestPath)
+		var err error
+		if index.Token != "" {
+			err = c.evictToken(index.Token, true)
+		} else {
+			err = c.evict(index)
+		}
+		if err != nil {
+			c.logger.Error("failed to evict index", "id", index.ID, "error", err)
+		}
+	}
+
+	client, err := c.client.Clone()
+	if err != nil {
+		c.logger.Error("failed to create API client in the renewer", "error", err)
+		cleanup()
+		return
+	}
+	switch {
+	case index.Token != "":
+		client.SetToken(index.Token)
+	default:
+		client.SetToken(index.LeaseToken)
+	}
+	if index.Namespace != rootNamespace {
+		client.SetNamespace(index.Namespace)
+	}
+
+	renewer, err := client.NewRenewer(&api.RenewerInput{
+		Secret: secret,
+	})
+	if err != nil {
+		c.logger.Error("failed to create secret renewer", "error", err)
+		cleanup()
+		return
+	}
+
+	c.logger.Debug("initiating renewal", "path", index.RequestPath)
+	go renewer.Renew()
+	defer renewer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			// This is the case which captures context cancellations from
+			// token and leases. Since all the contexts are derived from the
+			// agent's context, this will also cover the shutdown scenario.
+			c.logger.Debug("context cancelled; stopping renewer", "path", index.RequestPath)
+			return
+
+		case err := <-renewer.DoneCh():
+			if err == api.ErrRenewerNotRenewable {
+				// The secret can't be renewed, but it is still valid until
+				// it expires, so the cached response is kept until then.
+				ttl := secretTTL(secret)
+				if ttl == 0 && index.Token != "" {
+					// Non-expiring tokens are kept until they are revoked
+					<-ctx.Done()
+					return
+				}
+				c.logger.Debug("secret not renewable; keeping cached response until expiry", "path", index.RequestPath, "ttl", ttl)
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(ttl):
+				}
+			} else if err != nil {
+				c.logger.Debug("failed to renew secret", "path", index.RequestPath, "error", err)
+			}
+
+			// The secret is either expiring or could not be renewed, so the
+			// cached response can no longer be served.
+			cleanup()
+			return
+
+		case update := <-renewer.RenewCh():
+			c.logger.Debug("renewed secret", "path", index.RequestPath, "renewed_at", update.RenewedAt)
+		}
+	}
+}
+
+// secretTTL returns the remaining duration of the token or lease in secret.
+func secretTTL(secret *api.Secret) time.Duration {
+	if secret.Auth != nil {
+		return time.Duration(secret.Auth.LeaseDuration) * time.Second
+	}
+	return time.Duration(secret.LeaseDuration) * time.Second
+}
+
+// computeIndexID results in a value that uniquely identifies a request
+// received by the agent. It does so by SHA256 hashing the serialized request
+// object containing the request path, query parameters and body parameters,
+// along with the namespace and the token the request was made with.
+func computeIndexID(req *SendRequest) (string, error) {
+	var b bytes.Buffer
+
+	// Serialize the request
+	if err := req.Request.Write(&b); err != nil {
+		return "", fmt.Errorf("failed to serialize request: %v", err)
+	}
+
+	// Reset the request body after it has been closed by Write
+	req.Request.Body = ioutil.NopCloser(bytes.NewReader(req.RequestBody))
+
+	// Append req.Token into the byte slice. This is needed since auto-auth'ed
+	// requests sets the token directly into SendRequest.Token
+	b.Write([]byte(req.Token))
+
+	sum := sha256.Sum256(b.Bytes())
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// requestNamespace returns the canonicalized namespace of the request.
+func requestNamespace(req *http.Request) string {
+	ns := namespace.Canonicalize(req.Header.Get(consts.NamespaceHeaderName))
+	if ns == "" {
+		return rootNamespace
+	}
+	return ns
+}
+
+func isLoginPath(path string) bool {
+	return strings.HasPrefix(path, "/v1/auth/") && strings.Contains(path, "/login")
+}
+
+// handleRevocationRequest checks whether the originating request is a
+// revocation request, and if so evicts the revoked tokens and leases from the
+// cache. It is only called once the revocation has succeeded.
+func (c *LeaseCache) handleRevocationRequest(req *SendRequest) error {
+	rawPath := req.Request.URL.Path
+	method := req.Request.Method
+	if method != http.MethodPut && method != http.MethodPost {
+		return nil
+	}
+
+	switch {
+	case rawPath == vaultPathTokenRevoke:
+		token, err := bodyField(req, "token")
+		if err != nil {
+			return err
+		}
+		return c.evictToken(token, true)
+
+	case rawPath == vaultPathTokenRevokeSelf:
+		return c.evictToken(req.Token, true)
+
+	case rawPath == vaultPathTokenRevokeAccessor:
+		accessor, err := bodyField(req, "accessor")
+		if err != nil {
+			return err
+		}
+		index, err := c.db.Get(cachememdb.IndexNameTokenAccessor, accessor)
+		if err != nil || index == nil {
+			return err
+		}
+		return c.evictToken(index.Token, true)
+
+	case rawPath == vaultPathTokenRevokeOrphan:
+		token, err := bodyField(req, "token")
+		if err != nil {
+			return err
+		}
+		return c.evictToken(token, false)
+
+	case rawPath == vaultPathLeaseRevoke || rawPath == vaultPathLegacyRevoke:
+		leaseID, err := bodyField(req, "lease_id")
+		if err != nil {
+			return err
+		}
+		return c.evictLease(leaseID)
+
+	case strings.HasPrefix(rawPath, vaultPathLeaseRevoke+"/"):
+		return c.evictLease(strings.TrimPrefix(rawPath, vaultPathLeaseRevoke+"/"))
+
+	case strings.HasPrefix(rawPath, vaultPathLegacyRevoke+"/"):
+		return c.evictLease(strings.TrimPrefix(rawPath, vaultPathLegacyRevoke+"/"))
+
+	case strings.HasPrefix(rawPath, vaultPathLeaseRevokePrefix+"/"):
+		return c.evictLeasePrefix(strings.TrimPrefix(rawPath, vaultPathLeaseRevokePrefix+"/"))
+
+	case strings.HasPrefix(rawPath, vaultPathLeaseRevokeForce+"/"):
+		return c.evictLeasePrefix(strings.TrimPrefix(rawPath, vaultPathLeaseRevokeForce+"/"))
+
+	case strings.HasPrefix(rawPath, vaultPathLegacyRevokePrefix+"/"):
+		return c.evictLeasePrefix(strings.TrimPrefix(rawPath, vaultPathLegacyRevokePrefix+"/"))
+
+	case strings.HasPrefix(rawPath, vaultPathLegacyRevokeForce+"/"):
+		return c.evictLeasePrefix(strings.TrimPrefix(rawPath, vaultPathLegacyRevokeForce+"/"))
+	}
+
+	return nil
+}
+
+// bodyField returns the string value of key in the JSON body of req.
+func bodyField(req *SendRequest, key string) (string, error) {
+	var body map[string]interface{}
+	if err := jsonutil.DecodeJSON(req.RequestBody, &body); err != nil {
+		return "", errwrap.Wrapf("failed to parse request body: {{err}}", err)
+	}
+	value, _ := body[key].(string)
+	if value == "" {
+		return "", fmt.Errorf("%q not found in request body", key)
+	}
+	return value, nil
+}
+
+// evict cancels the renewal of the index and removes it from the cache.
+func (c *LeaseCache) evict(index *cachememdb.Index) error {
+	if index.RenewCtxInfo != nil {
+		index.RenewCtxInfo.CancelFunc()
+	}
+	if c.ps != nil {
+		if err := c.ps.Delete(index.ID); err != nil {
+			c.logger.Error("failed to delete index from the persistent cache", "id", index.ID, "error", err)
+		}
+	}
+	return c.db.Evict(cachememdb.IndexNameID, index.ID)
+}
+
+// evictToken removes the token and the leases created with it from the cache.
+// If cascade is set, the child tokens of the token are evicted as well,
+// otherwise they are kept as orphans.
+func (c *LeaseCache) evictToken(token string, cascade bool) error {
+	if token == "" {
+		return nil
+	}
+
+	leases, err := c.db.GetByPrefix(cachememdb.IndexNameLeaseToken, token)
+	if err != nil {
+		return err
+	}
+	for _, index := range leases {
+		// The prefix lookup matches longer tokens as well
+		if index.LeaseToken != token {
+			continue
+		}
+		if err := c.evict(index); err != nil {
+			return err
+		}
+	}
+
+	children, err := c.db.GetByPrefix(cachememdb.IndexNameTokenParent, token)
+	if err != nil {
+		return err
+	}
+	for _, index := range children {
+		if index.TokenParent != token {
+			continue
+		}
+		if cascade {
+			if err := c.evictToken(index.Token, true); err != nil {
+				return err
+			}
+			continue
+		}
+
+		orphan := *index
+		orphan.TokenParent = ""
+		if err := c.db.Set(&orphan); err != nil {
+			return err
+		}
+		c.persist(&orphan)
+	}
+
+	index, err := c.db.Get(cachememdb.IndexNameToken, token)
+	if err != nil || index == nil {
+		return err
+	}
+	return c.evict(index)
+}
+
+// evictLease removes the lease from the cache.
+func (c *LeaseCache) evictLease(leaseID string) error {
+	index, err := c.db.Get(cachememdb.IndexNameLease, leaseID)
+	if err != nil || index == nil {
+		return err
+	}
+	return c.evict(index)
+}
+
+// evictLeasePrefix removes all the leases under prefix from the cache.
+func (c *LeaseCache) evictLeasePrefix(prefix string) error {
+	indexes, err := c.db.GetByPrefix(cachememdb.IndexNameLease, prefix)
+	if err != nil {
+		return err
+	}
+	for _, index := range indexes {
+		if err := c.evict(index); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cacheClearRequest represents the request body of the cache-clear endpoint.
+type cacheClearRequest struct {
+	Type      string `json:"type"`
+	Value     string `json:"value"`
+	Namespace string `json:"namespace"`
+}
+
+// HandleCacheClear returns a handlerFunc that can perform cache clearing
+// operations.
+func (c *LeaseCache) HandleCacheClear(ctx context.Context) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPut, http.MethodPost:
+		default:
+			respondError(w, http.StatusMethodNotAllowed, nil)
+			return
+		}
+
+		req := new(cacheClearRequest)
+		if err := jsonutil.DecodeJSONFromReader(io.LimitReader(r.Body, maxRequestSize), req); err != nil {
+			if err == io.EOF {
+				err = errors.New("empty JSON provided")
+			}
+			respondError(w, http.StatusBadRequest, errwrap.Wrapf("failed to parse JSON input: {{err}}", err))
+			return
+		}
+
+		if req.Type != "all" && req.Value == "" {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("'value' is required for type %q", req.Type))
+			return
+		}
+
+		c.logger.Debug("received cache-clear request", "type", req.Type, "namespace", req.Namespace)
+
+		if err := c.handleCacheClear(req); err != nil {
+			status := http.StatusInternalServerError
+			if err == errInvalidType {
+				status = http.StatusBadRequest
+			}
+			respondError(w, status, errwrap.Wrapf("failed to clear cache: {{err}}", err))
+			return
+		}
+	})
+}
+
+func (c *LeaseCache) handleCacheClear(req *cacheClearRequest) error {
+	switch req.Type {
+	case "request_path":
+		ns := namespace.Canonicalize(req.Namespace)
+		if ns == "" {
+			ns = rootNamespace
+		}
+		indexes, err := c.db.GetByPrefix(cachememdb.IndexNameRequestPath, ns, req.Value)
+		if err != nil {
+			return err
+		}
+		for _, index := range indexes {
+			if err := c.evict(index); err != nil {
+				return err
+			}
+		}
+
+	case "token":
+		return c.evictToken(req.Value, true)
+
+	case "token_accessor":
+		index, err := c.db.Get(cachememdb.IndexNameTokenAccessor, req.Value)
+		if err != nil || index == nil {
+			return err
+		}
+		return c.evictToken(index.Token, true)
+
+	case "lease":
+		return c.evictLease(req.Value)
+
+	case "all":
+		indexes, err := c.db.List()
+		if err != nil {
+			return err
+		}
+		for _, index := range indexes {
+			if index.RenewCtxInfo != nil {
+				index.RenewCtxInfo.CancelFunc()
+			}
+		}
+		if c.ps != nil {
+			if err := c.ps.Clear(); err != nil {
+				return err
+			}
+		}
+		return c.db.Flush()
+
+	default:
+		return errInvalidType
+	}
+
+	return nil
+}
+
+// persist stores the index in the persistent cache, if there is one. Errors
+// are only logged, since the in-memory cache is still usable.
+func (c *LeaseCache) persist(index *cachememdb.Index) {
+	if c.ps == nil {
+		return
+	}
+
+	indexType := cacheboltdb.LeaseType
+	if index.Token != "" {
+		indexType = cacheboltdb.TokenType
+	}
+
+	b, err := index.Serialize()
+	if err == nil {
+		err = c.ps.Set(index.ID, b, indexType)
+	}
+	if err != nil {
+		c.logger.Error("failed to persist index", "id", index.ID, "error", err)
+	}
+}
+
+// Restore loads the entries of the persistent cache, typically persisted by a
+// previous run. Each token and lease is checked with Vault first, and the
+// ones that are no longer valid are dropped; the others are renewed as if
+// they had just been cached.
+func (c *LeaseCache) Restore() error {
+	if c.ps == nil {
+		return errors.New("no persistent storage configured")
+	}
+
+	for _, indexType := range []string{cacheboltdb.TokenType, cacheboltdb.LeaseType} {
+		entries, err := c.ps.GetByType(indexType)
+		if err != nil {
+			return err
+		}
+		for id, b := range entries {
+			if err := c.restoreIndex(b); err != nil {
+				if _, ok := err.(*restoreLookupError); ok {
+					c.logger.Warn("unable to validate persisted entry; keeping it for the next restore", "type", indexType, "id", id, "error", err)
+					continue
+				}
+				c.logger.Info("dropping persisted entry", "type", indexType, "id", id, "reason", err)
+				if err := c.ps.Delete(id); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// restoreLookupError is returned when the token or lease of a persisted index
+// can't be looked up for another reason than Vault rejecting it, for instance
+// when Vault can't be reached, so that the index is kept.
+type restoreLookupError struct {
+	err error
+}
+
+func (e *restoreLookupError) Error() string {
+	return e.err.Error()
+}
+
+// restoreLookup looks up the token or lease of a persisted index. The error
+// is a restoreLookupError unless Vault rejected the token or lease as invalid.
+func restoreLookup(client *api.Client, method, path string, body interface{}) (*api.Secret, error) {
+	r := client.NewRequest(method, path)
+	if body != nil {
+		if err := r.SetJSONBody(body); err != nil {
+			return nil, err
+		}
+	}
+
+	resp, err := client.RawRequest(r)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		if resp != nil && (resp.StatusCode == http.StatusBadRequest || resp.StatusCode == http.StatusForbidden) {
+			return nil, err
+		}
+		return nil, &restoreLookupError{err: err}
+	}
+
+	return api.ParseSecret(resp.Body)
+}
+
+// restoreIndex validates the token or lease of a persisted index with Vault,
+// and adds it to the cache if it's still valid.
+func (c *LeaseCache) restoreIndex(b []byte) error {
+	index, err := cachememdb.Deserialize(b)
+	if err != nil {
+		return errwrap.Wrapf("failed to deserialize index: {{err}}", err)
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(index.Response)), nil)
+	if err != nil {
+		return errwrap.Wrapf("failed to deserialize response: {{err}}", err)
+	}
+	secret, err := api.ParseSecret(resp.Body)
+	resp.Body.Close()
+	if err != nil || secret == nil {
+		return errors.New("failed to parse cached secret")
+	}
+
+	client, err := c.client.Clone()
+	if err != nil {
+		return err
+	}
+	if index.Namespace != rootNamespace {
+		client.SetNamespace(index.Namespace)
+	}
+
+	// The remaining TTL of the token or lease is taken from Vault, since the
+	// cached response has the TTL at the time it was cached
+	switch {
+	case index.Token != "":
+		if secret.Auth == nil {
+			return errors.New("cached token response has no auth")
+		}
+		client.SetToken(index.Token)
+		lookup, err := restoreLookup(client, "GET", "/v1/auth/token/lookup-self", nil)
+		if err != nil {
+			return err
+		}
+		if lookup == nil {
+			return errors.New("token lookup returned no data")
+		}
+		ttl, err := lookup.TokenTTL()
+		if err != nil {
+			return err
+		}
+		renewable, err := lookup.TokenIsRenewable()
+		if err != nil {
+			return err
+		}
+		secret.Auth.LeaseDuration = int(ttl.Seconds())
+		secret.Auth.Renewable = renewable
+
+	default:
+		// The lookup fails if the token that created the lease was revoked
+		client.SetToken(index.LeaseToken)
+		lookup, err := restoreLookup(client, "PUT", "/v1/sys/leases/lookup", map[string]interface{}{
+			"lease_id": index.Lease,
+		})
+		if err != nil {
+			return err
+		}
+		if lookup == nil || lookup.Data == nil {
+			return errors.New("lease lookup returned no data")
+		}
+		ttl, err := parseutil.ParseDurationSecond(lookup.Data["ttl"])
+		if err != nil {
+			return err
+		}
+		renewable, err := parseutil.ParseBool(lookup.Data["renewable"])
+		if err != nil {
+			return err
+		}
+		secret.LeaseDuration = int(ttl.Seconds())
+		secret.Renewable = renewable
+	}
+
+	index.RenewCtxInfo = cachememdb.NewContextInfo(c.baseCtxInfo.Ctx)
+	if err := c.db.Set(index); err != nil {
+		index.RenewCtxInfo.CancelFunc()
+		return err
+	}
+
+	go c.startRenewing(index, secret)
+
+	return nil
+}
+
+func respondError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	resp := &api.ErrorResponse{Errors: make([]string, 0, 1)}
+	if err != nil {
+		resp.Errors = append(resp.Errors, err.Error())
+	}
+
+	enc := json.NewEncoder(w)
+	enc.Encode(resp)
+}
@@ -0,0 +1,260 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/command/agent/cache/cachememdb"
+	"github.com/hashicorp/vault/helper/logging"
+)
+
+// mockProxier returns the canned responses in order, one per request.
+type mockProxier struct {
+	responses []*SendResponse
+	count     int
+}
+
+func newMockProxier(responses []*SendResponse) *mockProxier {
+	return &mockProxier{
+		responses: responses,
+	}
+}
+
+func (p *mockProxier) Send(ctx context.Context, req *SendRequest) (*SendResponse, error) {
+	if p.count >= len(p.responses) {
+		return nil, fmt.Errorf("index out of bounds: responses: %d, request: %d", len(p.responses), p.count)
+	}
+	resp := p.responses[p.count]
+	p.count++
+	return resp, nil
+}
+
+func newTestSendResponse(status int, body string) *SendResponse {
+	return &SendResponse{
+		Response: &api.Response{
+			Response: &http.Response{
+				StatusCode: status,
+				Header: http.Header{
+					"Content-Type": []string{"application/json"},
+				},
+				Body: ioutil.NopCloser(strings.NewReader(body)),
+			},
+		},
+		ResponseBody: []byte(body),
+	}
+}
+
+func testNewLeaseCache(t *testing.T, responses []*SendResponse) *LeaseCache {
+	t.Helper()
+
+	client, err := api.NewClient(api.DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lc, err := NewLeaseCache(&LeaseCacheConfig{
+		Client:      client,
+		BaseContext: context.Background(),
+		Proxier:     newMockProxier(responses),
+		Logger:      logging.NewVaultLogger(hclog.Trace).Named("cache.leasecache"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return lc
+}
+
+func testSendRequest(t *testing.T, method, path, token, body string) *SendRequest {
+	t.Helper()
+
+	return &SendRequest{
+		Token:       token,
+		Request:     httptest.NewRequest(method, path, strings.NewReader(body)),
+		RequestBody: []byte(body),
+	}
+}
+
+func TestLeaseCache_EmptyToken(t *testing.T) {
+	responses := []*SendResponse{
+		newTestSendResponse(http.StatusCreated, `{"value": "invalid", "auth": {"client_token": "testtoken"}}`),
+	}
+	lc := testNewLeaseCache(t, responses)
+
+	// Even if the send request doesn't have a token on it, a successful
+	// cacheable response should result in the index properly getting
+	// populated with a token and memdb shouldn't complain while inserting
+	// the index.
+	resp, err := lc.Send(context.Background(), testSendRequest(t, "POST", "/v1/auth/approle/login", "", `{"role_id": "abc"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil {
+		t.Fatalf("expected a non empty response")
+	}
+
+	index, err := lc.db.Get(cachememdb.IndexNameToken, "testtoken")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if index == nil || index.TokenParent != "" {
+		t.Fatalf("bad: %#v", index)
+	}
+}
+
+func TestLeaseCache_SendCacheable(t *testing.T) {
+	// Emulate 2 distinct responses with the same lease ID, the second one
+	// should never be returned.
+	responses := []*SendResponse{
+		newTestSendResponse(http.StatusOK, `{"lease_id": "foo/bar/1", "lease_duration": 600, "data": {"value": "first"}}`),
+		newTestSendResponse(http.StatusOK, `{"lease_id": "foo/bar/2", "lease_duration": 600, "data": {"value": "second"}}`),
+	}
+	lc := testNewLeaseCache(t, responses)
+
+	resp, err := lc.Send(context.Background(), testSendRequest(t, "GET", "/v1/foo/bar", "roottoken", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(resp.ResponseBody), "first") {
+		t.Fatalf("bad: %s", resp.ResponseBody)
+	}
+
+	// Send the same request again to get the cached response
+	resp, err = lc.Send(context.Background(), testSendRequest(t, "GET", "/v1/foo/bar", "roottoken", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(resp.ResponseBody), "first") {
+		t.Fatalf("expected a cached response, got: %s", resp.ResponseBody)
+	}
+	if resp.Response.StatusCode != http.StatusOK {
+		t.Fatalf("bad: %d", resp.Response.StatusCode)
+	}
+
+	// A request with a different token results in a different entry
+	resp, err = lc.Send(context.Background(), testSendRequest(t, "GET", "/v1/foo/bar", "othertoken", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(resp.ResponseBody), "second") {
+		t.Fatalf("bad: %s", resp.ResponseBody)
+	}
+}
+
+func TestLeaseCache_SendNonCacheable(t *testing.T) {
+	responses := []*SendResponse{
+		newTestSendResponse(http.StatusOK, `{"data": {"value": "output"}}`),
+		newTestSendResponse(http.StatusNotFound, `{"errors": []}`),
+		newTestSendResponse(http.StatusOK, `{"wrap_info": {"token": "wrapped", "ttl": 300}}`),
+		newTestSendResponse(http.StatusNoContent, ``),
+	}
+	lc := testNewLeaseCache(t, responses)
+
+	for _, expected := range []int{http.StatusOK, http.StatusNotFound, http.StatusOK, http.StatusNoContent} {
+		resp, err := lc.Send(context.Background(), testSendRequest(t, "GET", "/v1/foo/bar", "roottoken", ""))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Response.StatusCode != expected {
+			t.Fatalf("expected %d, got %d", expected, resp.Response.StatusCode)
+		}
+	}
+
+	indexes, err := lc.db.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(indexes) != 0 {
+		t.Fatalf("expected nothing to be cached, got: %d entries", len(indexes))
+	}
+}
+
+func TestLeaseCache_RevocationEviction(t *testing.T) {
+	responses := []*SendResponse{
+		// Child token created with the parent token
+		newTestSendResponse(http.StatusOK, `{"auth": {"client_token": "child", "accessor": "child_accessor", "lease_duration": 600}}`),
+		// Lease created with the child token
+		newTestSendResponse(http.StatusOK, `{"lease_id": "foo/bar/1", "lease_duration": 600, "data": {"value": "first"}}`),
+		// Revocation of the parent token
+		newTestSendResponse(http.StatusNoContent, ``),
+	}
+	lc := testNewLeaseCache(t, responses)
+
+	if _, err := lc.Send(context.Background(), testSendRequest(t, "POST", "/v1/auth/token/create", "parent", `{"policies": ["default"]}`)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lc.Send(context.Background(), testSendRequest(t, "GET", "/v1/foo/bar", "child", "")); err != nil {
+		t.Fatal(err)
+	}
+
+	index, err := lc.db.Get(cachememdb.IndexNameTokenAccessor, "child_accessor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if index == nil || index.TokenParent != "parent" {
+		t.Fatalf("bad: %#v", index)
+	}
+
+	// Revoking the parent should evict the child token and its lease
+	if _, err := lc.Send(context.Background(), testSendRequest(t, "POST", "/v1/auth/token/revoke-self", "parent", "")); err != nil {
+		t.Fatal(err)
+	}
+
+	indexes, err := lc.db.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(indexes) != 0 {
+		t.Fatalf("expected the cache to be empty, got: %d entries", len(indexes))
+	}
+}
+
+func TestLeaseCache_HandleCacheClear(t *testing.T) {
+	responses := []*SendResponse{
+		newTestSendResponse(http.StatusOK, `{"lease_id": "foo/bar/1", "lease_duration": 600, "data": {"value": "first"}}`),
+	}
+	lc := testNewLeaseCache(t, responses)
+
+	if _, err := lc.Send(context.Background(), testSendRequest(t, "GET", "/v1/foo/bar", "roottoken", "")); err != nil {
+		t.Fatal(err)
+	}
+
+	handler := lc.HandleCacheClear(context.Background())
+
+	testCases := []struct {
+		name     string
+		method   string
+		body     string
+		expected int
+	}{
+		{"bad_method", "GET", `{"type": "all"}`, http.StatusMethodNotAllowed},
+		{"empty_body", "POST", ``, http.StatusBadRequest},
+		{"invalid_type", "POST", `{"type": "foo", "value": "bar"}`, http.StatusBadRequest},
+		{"missing_value", "POST", `{"type": "lease"}`, http.StatusBadRequest},
+		{"request_path", "POST", `{"type": "request_path", "value": "/v1/foo"}`, http.StatusOK},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(tc.method, "/agent/v1/cache-clear", strings.NewReader(tc.body)))
+			if w.Code != tc.expected {
+				t.Fatalf("expected %d, got %d: %s", tc.expected, w.Code, w.Body.String())
+			}
+		})
+	}
+
+	indexes, err := lc.db.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(indexes) != 0 {
+		t.Fatalf("expected the cache to be empty, got: %d entries", len(indexes))
+	}
+}
@@ -0,0 +1,159 @@
+package agent
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/api"
+	credCert "github.com/hashicorp/vault/builtin/credential/cert"
+	"github.com/hashicorp/vault/command/agent/auth"
+	agentcert "github.com/hashicorp/vault/command/agent/auth/cert"
+	"github.com/hashicorp/vault/helper/logging"
+	vaulthttp "github.com/hashicorp/vault/http"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/vault"
+)
+
+func TestCertEndToEnd(t *testing.T) {
+	logger := logging.NewVaultLogger(hclog.Trace)
+	coreConfig := &vault.CoreConfig{
+		Logger: logger,
+		CredentialBackends: map[string]logical.Factory{
+			"cert": credCert.Factory,
+		},
+	}
+	cluster := vault.NewTestCluster(t, coreConfig, &vault.TestClusterOptions{
+		HandlerFunc: vaulthttp.Handler,
+	})
+	cluster.Start()
+	defer cluster.Cleanup()
+
+	vault.TestWaitActive(t, cluster.Cores[0].Core)
+	client := cluster.Cores[0].Client
+
+	// Setup Vault, trusting the cluster CA, which also signed the node
+	// certificates used as client certificates below
+	err := client.Sys().EnableAuthWithOptions("cert", &api.EnableAuthOptions{
+		Type: "cert",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = client.Logical().Write("auth/cert/certs/test", map[string]interface{}{
+		"certificate": string(cluster.CACertPEM),
+		"policies":    "test",
+		"period":      "3s",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFiles, err := filepath.Glob(filepath.Join(cluster.TempDir, "node1_port_*_cert.pem"))
+	if err != nil || len(certFiles) != 1 {
+		t.Fatalf("could not find node certificate: %v %v", certFiles, err)
+	}
+	keyFiles, err := filepath.Glob(filepath.Join(cluster.TempDir, "node1_port_*_key.pem"))
+	if err != nil || len(keyFiles) != 1 {
+		t.Fatalf("could not find node key: %v %v", keyFiles, err)
+	}
+
+	dir, err := ioutil.TempDir("", "auth.cert.test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "token")
+
+	// Without a client certificate, logging in fails. The cluster's client
+	// presents the node certificate, which the method's client would keep.
+	config := api.DefaultConfig()
+	if config.Error != nil {
+		t.Fatal(config.Error)
+	}
+	config.Address = client.Address()
+	config.MaxRetries = 0
+	noCertClient, err := api.NewClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	am, err := agentcert.NewCertAuthMethod(&auth.AuthConfig{
+		Logger:    logger.Named("auth.cert"),
+		MountPath: "auth/cert",
+		Config: map[string]interface{}{
+			"name":    "test",
+			"ca_cert": cluster.CACertPEMFile,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	authClient, err := am.(auth.AuthMethodWithClient).AuthClient(noCertClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path, data, err := am.Authenticate(context.Background(), client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := authClient.Logical().Write(path, data); err == nil {
+		t.Fatal("expected login without a client certificate to fail")
+	}
+
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	timer := time.AfterFunc(30*time.Second, func() {
+		cancelFunc()
+	})
+	defer timer.Stop()
+
+	am, err = agentcert.NewCertAuthMethod(&auth.AuthConfig{
+		Logger:    logger.Named("auth.cert"),
+		MountPath: "auth/cert",
+		Config: map[string]interface{}{
+			"name":        "test",
+			"ca_cert":     cluster.CACertPEMFile,
+			"client_cert": certFiles[0],
+			"client_key":  keyFiles[0],
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ah := auth.NewAuthHandler(&auth.AuthHandlerConfig{
+		Logger: logger.Named("auth.handler"),
+		Client: client,
+	})
+	go ah.Run(ctx, am)
+	defer func() {
+		<-ah.DoneCh
+	}()
+
+	ss := testTokenFileSinkServer(t, ctx, logger, client, ah, out)
+	defer func() {
+		<-ss.DoneCh
+	}()
+
+	// This has to be after the other defers so it happens first
+	defer cancelFunc()
+
+	token := testWaitForToken(t, out)
+	secret := testLookupToken(t, client, token)
+	policies, err := secret.TokenPolicies()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(policies) != 2 || policies[1] != "test" {
+		t.Fatalf("bad policies: %v", policies)
+	}
+
+	// Period of 3 seconds, so the token should still be alive after it
+	// through renewals
+	time.Sleep(5 * time.Second)
+	testLookupToken(t, client, token)
+}
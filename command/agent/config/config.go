@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -19,9 +20,67 @@ import (
 
 // Config is the configuration for the vault server.
 type Config struct {
-	AutoAuth      *AutoAuth `hcl:"auto_auth"`
-	ExitAfterAuth bool      `hcl:"exit_after_auth"`
-	PidFile       string    `hcl:"pid_file"`
+	AutoAuth      *AutoAuth      `hcl:"auto_auth"`
+	ExitAfterAuth bool           `hcl:"exit_after_auth"`
+	PidFile       string         `hcl:"pid_file"`
+	Listeners     []*Listener    `hcl:"listeners"`
+	Cache         *Cache         `hcl:"cache"`
+	Templates     []*Template    `hcl:"templates"`
+	EnvTemplates  []*EnvTemplate `hcl:"env_templates"`
+	Exec          *Exec          `hcl:"exec"`
+}
+
+// EnvTemplate is a template rendered into an environment variable of the
+// child process started by the agent
+type EnvTemplate struct {
+	Name       string `hcl:"-"`
+	Contents   string `hcl:"contents"`
+	LeftDelim  string `hcl:"left_delimiter"`
+	RightDelim string `hcl:"right_delimiter"`
+}
+
+// Exec is the configuration of the child process supervised by the agent
+type Exec struct {
+	Command                []string `hcl:"command"`
+	RestartOnSecretChanges string   `hcl:"restart_on_secret_changes"`
+	RestartStopSignal      string   `hcl:"restart_stop_signal"`
+	SecretChangeSignal     string   `hcl:"secret_change_signal"`
+}
+
+// Template is a template rendered by the agent to a file on disk
+type Template struct {
+	Source            string        `hcl:"source"`
+	Contents          string        `hcl:"contents"`
+	Destination       string        `hcl:"destination"`
+	PermsRaw          interface{}   `hcl:"perms"`
+	Perms             os.FileMode   `hcl:"-"`
+	Command           string        `hcl:"command"`
+	CommandTimeoutRaw interface{}   `hcl:"command_timeout"`
+	CommandTimeout    time.Duration `hcl:"-"`
+	LeftDelim         string        `hcl:"left_delimiter"`
+	RightDelim        string        `hcl:"right_delimiter"`
+}
+
+// Cache contains any configuration needed for Cache mode
+type Cache struct {
+	UseAutoAuthToken bool     `hcl:"use_auto_auth_token"`
+	Persist          *Persist `hcl:"-"`
+}
+
+// Persist contains the configuration for persisting the cache to disk across
+// restarts
+type Persist struct {
+	Path                 string        `hcl:"path"`
+	KeyFile              string        `hcl:"key_file"`
+	RetrievalTokenTTLRaw interface{}   `hcl:"retrieval_token_ttl"`
+	RetrievalTokenTTL    time.Duration `hcl:"-"`
+	ExitOnErr            bool          `hcl:"exit_on_err"`
+}
+
+// Listener contains configuration for any Vault Agent listeners
+type Listener struct {
+	Type   string
+	Config map[string]interface{}
 }
 
 type AutoAuth struct {
@@ -87,13 +146,323 @@ func LoadConfig(path string, logger log.Logger) (*Config, error) {
 		return nil, errwrap.Wrapf("error parsing 'auto_auth': {{err}}", err)
 	}
 
+	if err := parseCache(&result, list); err != nil {
+		return nil, errwrap.Wrapf("error parsing 'cache': {{err}}", err)
+	}
+
+	if err := parseListeners(&result, list); err != nil {
+		return nil, errwrap.Wrapf("error parsing 'listener' stanzas: {{err}}", err)
+	}
+
+	if err := parseTemplates(&result, list); err != nil {
+		return nil, errwrap.Wrapf("error parsing 'template' stanzas: {{err}}", err)
+	}
+
+	if err := parseEnvTemplates(&result, list); err != nil {
+		return nil, errwrap.Wrapf("error parsing 'env_template' stanzas: {{err}}", err)
+	}
+
+	if err := parseExec(&result, list); err != nil {
+		return nil, errwrap.Wrapf("error parsing 'exec': {{err}}", err)
+	}
+
+	if len(result.EnvTemplates) > 0 && result.Exec == nil {
+		return nil, fmt.Errorf("env_template stanzas require an exec block")
+	}
+
+	if len(result.Templates) > 0 || result.Exec != nil {
+		if result.AutoAuth == nil {
+			return nil, fmt.Errorf("templates and exec require auto_auth to be configured")
+		}
+		if result.AutoAuth.Method.WrapTTL > 0 {
+			return nil, fmt.Errorf("templates cannot be used when auto_auth uses wrapping")
+		}
+	}
+
+	if result.Cache != nil {
+		if len(result.Listeners) < 1 {
+			return nil, fmt.Errorf("at least one listener required when cache enabled")
+		}
+
+		if result.Cache.UseAutoAuthToken {
+			if result.AutoAuth == nil {
+				return nil, fmt.Errorf("cache.use_auto_auth_token is true but auto_auth not configured")
+			}
+			if result.AutoAuth.Method.WrapTTL > 0 {
+				return nil, fmt.Errorf("cache.use_auto_auth_token is true and auto_auth uses wrapping")
+			}
+		}
+
+		// Without a key file, the cache key is wrapped with the auto-auth
+		// token
+		if result.Cache.Persist != nil && result.Cache.Persist.KeyFile == "" {
+			if result.AutoAuth == nil {
+				return nil, fmt.Errorf("cache.persist requires auto_auth to be configured when no key_file is set")
+			}
+			if result.AutoAuth.Method.WrapTTL > 0 {
+				return nil, fmt.Errorf("cache.persist requires a key_file when auto_auth uses wrapping")
+			}
+		}
+	}
+
+	if result.AutoAuth != nil {
+		if len(result.AutoAuth.Sinks) == 0 && len(result.Templates) == 0 && result.Exec == nil && (result.Cache == nil || !result.Cache.UseAutoAuthToken) {
+			return nil, fmt.Errorf("auto_auth requires at least one sink, template, exec or cache.use_auto_auth_token=true")
+		}
+	}
+
 	return &result, nil
 }
 
+func parseCache(result *Config, list *ast.ObjectList) error {
+	name := "cache"
+
+	cacheList := list.Filter(name)
+	if len(cacheList.Items) == 0 {
+		return nil
+	}
+
+	if len(cacheList.Items) > 1 {
+		return fmt.Errorf("one and only one %q block is required", name)
+	}
+
+	item := cacheList.Items[0]
+
+	var c Cache
+	err := hcl.DecodeObject(&c, item.Val)
+	if err != nil {
+		return err
+	}
+
+	if ot, ok := item.Val.(*ast.ObjectType); ok {
+		if err := parsePersist(&c, ot.List); err != nil {
+			return errwrap.Wrapf("error parsing 'persist': {{err}}", err)
+		}
+	}
+
+	result.Cache = &c
+	return nil
+}
+
+func parsePersist(result *Cache, list *ast.ObjectList) error {
+	name := "persist"
+
+	persistList := list.Filter(name)
+	if len(persistList.Items) == 0 {
+		return nil
+	}
+
+	if len(persistList.Items) > 1 {
+		return fmt.Errorf("one and only one %q block is required", name)
+	}
+
+	item := persistList.Items[0]
+
+	var p Persist
+	if err := hcl.DecodeObject(&p, item.Val); err != nil {
+		return err
+	}
+
+	if p.Path == "" {
+		return errors.New("'path' must be specified")
+	}
+
+	p.RetrievalTokenTTL = 24 * time.Hour
+	if p.RetrievalTokenTTLRaw != nil {
+		var err error
+		if p.RetrievalTokenTTL, err = parseutil.ParseDurationSecond(p.RetrievalTokenTTLRaw); err != nil {
+			return err
+		}
+		p.RetrievalTokenTTLRaw = nil
+	}
+
+	result.Persist = &p
+	return nil
+}
+
+func parseListeners(result *Config, list *ast.ObjectList) error {
+	name := "listener"
+
+	listenerList := list.Filter(name)
+
+	var listeners []*Listener
+	for _, item := range listenerList.Items {
+		var lnConfig map[string]interface{}
+		err := hcl.DecodeObject(&lnConfig, item.Val)
+		if err != nil {
+			return err
+		}
+
+		var lnType string
+		switch {
+		case lnConfig["type"] != nil:
+			var ok bool
+			lnType, ok = lnConfig["type"].(string)
+			if !ok {
+				return errors.New("listener type must be a string")
+			}
+			delete(lnConfig, "type")
+		case len(item.Keys) == 1:
+			lnType = strings.ToLower(item.Keys[0].Token.Value().(string))
+		default:
+			return errors.New("listener type must be specified")
+		}
+
+		switch lnType {
+		case "unix", "tcp":
+		default:
+			return fmt.Errorf("invalid listener type %q", lnType)
+		}
+
+		listeners = append(listeners, &Listener{
+			Type:   lnType,
+			Config: lnConfig,
+		})
+	}
+
+	result.Listeners = listeners
+
+	return nil
+}
+
+func parseTemplates(result *Config, list *ast.ObjectList) error {
+	name := "template"
+
+	templateList := list.Filter(name)
+
+	var templates []*Template
+	for _, item := range templateList.Items {
+		var t Template
+		if err := hcl.DecodeObject(&t, item.Val); err != nil {
+			return err
+		}
+
+		switch {
+		case t.Source == "" && t.Contents == "":
+			return errors.New("template 'source' or 'contents' must be specified")
+		case t.Source != "" && t.Contents != "":
+			return errors.New("only one of template 'source' or 'contents' can be specified")
+		case t.Destination == "":
+			return errors.New("template 'destination' must be specified")
+		}
+
+		t.Perms = 0644
+		if t.PermsRaw != nil {
+			permsStr, ok := t.PermsRaw.(string)
+			if !ok {
+				return multierror.Prefix(errors.New("'perms' must be an octal string"), fmt.Sprintf("template.%s", t.Destination))
+			}
+			perms, err := strconv.ParseUint(permsStr, 8, 32)
+			if err != nil {
+				return multierror.Prefix(fmt.Errorf("invalid 'perms': %v", err), fmt.Sprintf("template.%s", t.Destination))
+			}
+			t.Perms = os.FileMode(perms)
+			t.PermsRaw = nil
+		}
+
+		t.CommandTimeout = 30 * time.Second
+		if t.CommandTimeoutRaw != nil {
+			var err error
+			if t.CommandTimeout, err = parseutil.ParseDurationSecond(t.CommandTimeoutRaw); err != nil {
+				return multierror.Prefix(err, fmt.Sprintf("template.%s", t.Destination))
+			}
+			t.CommandTimeoutRaw = nil
+		}
+
+		templates = append(templates, &t)
+	}
+
+	result.Templates = templates
+	return nil
+}
+
+func parseEnvTemplates(result *Config, list *ast.ObjectList) error {
+	name := "env_template"
+
+	envTemplateList := list.Filter(name)
+
+	seen := make(map[string]bool)
+	var envTemplates []*EnvTemplate
+	for _, item := range envTemplateList.Items {
+		var t EnvTemplate
+		if err := hcl.DecodeObject(&t, item.Val); err != nil {
+			return err
+		}
+
+		if len(item.Keys) != 1 {
+			return errors.New("env_template name must be specified")
+		}
+		t.Name = item.Keys[0].Token.Value().(string)
+
+		switch {
+		case t.Name == "":
+			return errors.New("env_template name must be specified")
+		case strings.ContainsAny(t.Name, "="):
+			return fmt.Errorf("invalid env_template name %q", t.Name)
+		case seen[t.Name]:
+			return fmt.Errorf("duplicate env_template %q", t.Name)
+		case t.Contents == "":
+			return multierror.Prefix(errors.New("'contents' must be specified"), fmt.Sprintf("env_template.%s", t.Name))
+		}
+		seen[t.Name] = true
+
+		envTemplates = append(envTemplates, &t)
+	}
+
+	result.EnvTemplates = envTemplates
+	return nil
+}
+
+func parseExec(result *Config, list *ast.ObjectList) error {
+	name := "exec"
+
+	execList := list.Filter(name)
+	if len(execList.Items) == 0 {
+		return nil
+	}
+
+	if len(execList.Items) > 1 {
+		return fmt.Errorf("one and only one %q block is required", name)
+	}
+
+	item := execList.Items[0]
+
+	var e Exec
+	if err := hcl.DecodeObject(&e, item.Val); err != nil {
+		return err
+	}
+
+	if len(e.Command) == 0 {
+		return errors.New("'command' must be specified")
+	}
+
+	switch e.RestartOnSecretChanges {
+	case "":
+		e.RestartOnSecretChanges = "always"
+	case "always", "never":
+	default:
+		return fmt.Errorf("invalid value %q for 'restart_on_secret_changes', must be \"always\" or \"never\"", e.RestartOnSecretChanges)
+	}
+
+	if e.RestartStopSignal == "" {
+		e.RestartStopSignal = "SIGTERM"
+	}
+
+	if e.SecretChangeSignal != "" && e.RestartOnSecretChanges != "never" {
+		return errors.New("'secret_change_signal' requires 'restart_on_secret_changes' to be \"never\"")
+	}
+
+	result.Exec = &e
+	return nil
+}
+
 func parseAutoAuth(result *Config, list *ast.ObjectList) error {
 	name := "auto_auth"
 
 	autoAuthList := list.Filter(name)
+	if len(autoAuthList.Items) == 0 {
+		return nil
+	}
 	if len(autoAuthList.Items) != 1 {
 		return fmt.Errorf("one and only one %q block is required", name)
 	}
@@ -122,11 +491,8 @@ func parseAutoAuth(result *Config, list *ast.ObjectList) error {
 		return errwrap.Wrapf("error parsing 'sink' stanzas: {{err}}", err)
 	}
 
-	switch {
-	case a.Method == nil:
+	if a.Method == nil {
 		return fmt.Errorf("no 'method' block found")
-	case len(a.Sinks) == 0:
-		return fmt.Errorf("at least one 'sink' block must be provided")
 	}
 
 	return nil
@@ -160,6 +526,10 @@ func parseMethod(result *Config, list *ast.ObjectList) error {
 	// Default to Vault's default
 	if m.MountPath == "" {
 		m.MountPath = fmt.Sprintf("auth/%s", m.Type)
+		// The token_file method uses the token store
+		if m.Type == "token_file" {
+			m.MountPath = "auth/token"
+		}
 	}
 	// Standardize on no trailing slash
 	m.MountPath = strings.TrimSuffix(m.MountPath, "/")
@@ -181,7 +551,7 @@ func parseSinks(result *Config, list *ast.ObjectList) error {
 
 	sinkList := list.Filter(name)
 	if len(sinkList.Items) < 1 {
-		return fmt.Errorf("at least one %q block is required", name)
+		return nil
 	}
 
 	var ts []*Sink
@@ -2,6 +2,7 @@ package config
 
 import (
 	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -69,3 +70,175 @@ func TestLoadConfigFile(t *testing.T) {
 		t.Fatal(diff)
 	}
 }
+
+func TestLoadConfigFile_AgentCache(t *testing.T) {
+	logger := logging.NewVaultLogger(log.Debug)
+
+	config, err := LoadConfig("./test-fixtures/config-cache.hcl", logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := &Config{
+		AutoAuth: &AutoAuth{
+			Method: &Method{
+				Type:      "aws",
+				MountPath: "auth/aws",
+				Config: map[string]interface{}{
+					"role": "foobar",
+				},
+			},
+			Sinks: []*Sink{
+				&Sink{
+					Type:   "file",
+					DHType: "curve25519",
+					DHPath: "/tmp/file-foo-dhpath",
+					AAD:    "foobar",
+					Config: map[string]interface{}{
+						"path": "/tmp/file-foo",
+					},
+				},
+			},
+		},
+		Cache: &Cache{
+			UseAutoAuthToken: true,
+		},
+		Listeners: []*Listener{
+			&Listener{
+				Type: "unix",
+				Config: map[string]interface{}{
+					"address":     "/path/to/socket",
+					"tls_disable": true,
+				},
+			},
+			&Listener{
+				Type: "tcp",
+				Config: map[string]interface{}{
+					"address":     "127.0.0.1:8300",
+					"tls_disable": true,
+				},
+			},
+		},
+		PidFile: "./pidfile",
+	}
+
+	if diff := deep.Equal(config, expected); diff != nil {
+		t.Fatal(diff)
+	}
+
+	config, err = LoadConfig("./test-fixtures/config-cache-no-sinks.hcl", logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.AutoAuth.Sinks) != 0 || !config.Cache.UseAutoAuthToken {
+		t.Fatalf("bad: %#v", config)
+	}
+}
+
+func TestLoadConfigFile_AgentCache_NoListeners(t *testing.T) {
+	logger := logging.NewVaultLogger(log.Debug)
+
+	_, err := LoadConfig("./test-fixtures/config-cache-no-listeners.hcl", logger)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestLoadConfigFile_AgentCache_BadListenerType(t *testing.T) {
+	logger := logging.NewVaultLogger(log.Debug)
+
+	_, err := LoadConfig("./test-fixtures/config-cache-bad-listener-type.hcl", logger)
+	if err == nil || !strings.Contains(err.Error(), "listener type must be a string") {
+		t.Fatalf("expected error, got: %v", err)
+	}
+}
+
+func TestLoadConfigFile_AgentCache_Persist(t *testing.T) {
+	logger := logging.NewVaultLogger(log.Debug)
+
+	config, err := LoadConfig("./test-fixtures/config-cache-persist.hcl", logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := &Cache{
+		UseAutoAuthToken: true,
+		Persist: &Persist{
+			Path:              "/var/lib/vault-agent",
+			RetrievalTokenTTL: 12 * time.Hour,
+			ExitOnErr:         true,
+		},
+	}
+	if diff := deep.Equal(config.Cache, expected); diff != nil {
+		t.Fatal(diff)
+	}
+
+	// Without a key file, the key is wrapped with the auto-auth token
+	_, err = LoadConfig("./test-fixtures/config-cache-persist-no-auto-auth.hcl", logger)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestLoadConfigFile_Template(t *testing.T) {
+	logger := logging.NewVaultLogger(log.Debug)
+
+	config, err := LoadConfig("./test-fixtures/config-template.hcl", logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []*Template{
+		&Template{
+			Source:         "/path/on/disk/to/template.ctmpl",
+			Destination:    "/path/on/disk/where/template/will/render.txt",
+			Perms:          0600,
+			Command:        "systemctl reload app",
+			CommandTimeout: 30 * time.Second,
+		},
+		&Template{
+			Source:         "/path/on/disk/to/other.ctmpl",
+			Destination:    "/path/on/disk/where/other/will/render.txt",
+			Perms:          0644,
+			CommandTimeout: 10 * time.Second,
+			LeftDelim:      "<<",
+			RightDelim:     ">>",
+		},
+	}
+
+	if diff := deep.Equal(config.Templates, expected); diff != nil {
+		t.Fatal(diff)
+	}
+}
+
+func TestLoadConfigFile_Exec(t *testing.T) {
+	logger := logging.NewVaultLogger(log.Debug)
+
+	config, err := LoadConfig("./test-fixtures/config-exec.hcl", logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedEnv := []*EnvTemplate{
+		&EnvTemplate{
+			Name:     "DB_PASSWORD",
+			Contents: `{{ with secret "database/creds/app" }}{{ .Data.password }}{{ end }}`,
+		},
+		&EnvTemplate{
+			Name:     "API_KEY",
+			Contents: `{{ with secret "secret/data/app" }}{{ .Data.data.api_key }}{{ end }}`,
+		},
+	}
+	if diff := deep.Equal(config.EnvTemplates, expectedEnv); diff != nil {
+		t.Fatal(diff)
+	}
+
+	expectedExec := &Exec{
+		Command:                []string{"/app/bin/server", "--port", "8080"},
+		RestartOnSecretChanges: "always",
+		RestartStopSignal:      "SIGINT",
+	}
+	if diff := deep.Equal(config.Exec, expectedExec); diff != nil {
+		t.Fatal(diff)
+	}
+}
@@ -0,0 +1,11 @@
+pid_file = "./pidfile"
+
+cache {
+	use_auto_auth_token = true
+}
+
+listener {
+	type = 1
+	address = "127.0.0.1:8300"
+	tls_disable = true
+}
@@ -0,0 +1,5 @@
+pid_file = "./pidfile"
+
+cache {
+	use_auto_auth_token = true
+}
@@ -0,0 +1,10 @@
+cache {
+	persist {
+		path = "/var/lib/vault-agent"
+	}
+}
+
+listener "tcp" {
+	address = "127.0.0.1:8300"
+	tls_disable = true
+}
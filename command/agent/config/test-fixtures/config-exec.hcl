@@ -0,0 +1,21 @@
+auto_auth {
+	method {
+		type = "aws"
+		config = {
+			role = "foobar"
+		}
+	}
+}
+
+env_template "DB_PASSWORD" {
+	contents = "{{ with secret \"database/creds/app\" }}{{ .Data.password }}{{ end }}"
+}
+
+env_template "API_KEY" {
+	contents = "{{ with secret \"secret/data/app\" }}{{ .Data.data.api_key }}{{ end }}"
+}
+
+exec {
+	command = ["/app/bin/server", "--port", "8080"]
+	restart_stop_signal = "SIGINT"
+}
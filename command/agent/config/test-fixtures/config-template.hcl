@@ -0,0 +1,25 @@
+pid_file = "./pidfile"
+
+auto_auth {
+	method {
+		type = "aws"
+		config = {
+			role = "foobar"
+		}
+	}
+}
+
+template {
+	source = "/path/on/disk/to/template.ctmpl"
+	destination = "/path/on/disk/where/template/will/render.txt"
+	perms = "0600"
+	command = "systemctl reload app"
+}
+
+template {
+	source = "/path/on/disk/to/other.ctmpl"
+	destination = "/path/on/disk/where/other/will/render.txt"
+	command_timeout = "10s"
+	left_delimiter = "<<"
+	right_delimiter = ">>"
+}
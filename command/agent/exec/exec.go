@@ -0,0 +1,296 @@
+package exec
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/command/agent/config"
+)
+
+// DefaultKillTimeout is how long the child process is given to exit after
+// its stop signal before it is killed.
+const DefaultKillTimeout = 30 * time.Second
+
+// ServerConfig is the configuration of the exec server
+type ServerConfig struct {
+	Logger hclog.Logger
+	Config *config.Exec
+
+	// Stdin, Stdout and Stderr of the child process default to those of
+	// the agent
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+
+	// KillTimeout overrides DefaultKillTimeout if set
+	KillTimeout time.Duration
+}
+
+// Server runs a child process with the environment rendered from the
+// env_template stanzas, restarting or signaling it when that environment
+// changes.
+type Server struct {
+	DoneCh       chan struct{}
+	logger       hclog.Logger
+	command      []string
+	restart      bool
+	stopSignal   os.Signal
+	changeSignal os.Signal
+	stdin        io.Reader
+	stdout       io.Writer
+	stderr       io.Writer
+	killTimeout  time.Duration
+	exitCode     int
+}
+
+// child is a running child process
+type child struct {
+	cmd      *exec.Cmd
+	exitCh   chan struct{}
+	exitCode int
+}
+
+// NewServer returns a new exec server
+func NewServer(conf *ServerConfig) (*Server, error) {
+	if conf.Config == nil || len(conf.Config.Command) == 0 {
+		return nil, fmt.Errorf("no command provided")
+	}
+
+	s := &Server{
+		DoneCh:      make(chan struct{}),
+		logger:      conf.Logger,
+		command:     conf.Config.Command,
+		restart:     conf.Config.RestartOnSecretChanges != "never",
+		stdin:       conf.Stdin,
+		stdout:      conf.Stdout,
+		stderr:      conf.Stderr,
+		killTimeout: conf.KillTimeout,
+	}
+
+	var err error
+	if s.stopSignal, err = parseSignal(conf.Config.RestartStopSignal); err != nil {
+		return nil, errwrap.Wrapf("invalid 'restart_stop_signal': {{err}}", err)
+	}
+	if s.stopSignal == nil {
+		s.stopSignal = signals["SIGTERM"]
+	}
+	if s.changeSignal, err = parseSignal(conf.Config.SecretChangeSignal); err != nil {
+		return nil, errwrap.Wrapf("invalid 'secret_change_signal': {{err}}", err)
+	}
+
+	if s.stdin == nil {
+		s.stdin = os.Stdin
+	}
+	if s.stdout == nil {
+		s.stdout = os.Stdout
+	}
+	if s.stderr == nil {
+		s.stderr = os.Stderr
+	}
+	if s.killTimeout == 0 {
+		s.killTimeout = DefaultKillTimeout
+	}
+
+	return s, nil
+}
+
+func parseSignal(name string) (os.Signal, error) {
+	if name == "" {
+		return nil, nil
+	}
+
+	name = strings.ToUpper(name)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+
+	sig, ok := signals[name]
+	if !ok {
+		return nil, fmt.Errorf("unsupported signal %q", name)
+	}
+	return sig, nil
+}
+
+// ExitCode returns the exit code of the child process. It is only meaningful
+// once DoneCh is closed.
+func (s *Server) ExitCode() int {
+	return s.exitCode
+}
+
+// Run starts the child process once the first environment is received on
+// envCh, and restarts or signals it on each new environment. It returns when
+// the child process exits, or after stopping it when ctx is done.
+func (s *Server) Run(ctx context.Context, envCh <-chan map[string]string) {
+	s.logger.Info("starting exec server")
+	defer func() {
+		s.logger.Info("exec server stopped", "exit_code", s.exitCode)
+		close(s.DoneCh)
+	}()
+
+	sigCh := make(chan os.Signal, 1)
+	if len(forwardedSignals) > 0 {
+		signal.Notify(sigCh, forwardedSignals...)
+		defer signal.Stop(sigCh)
+	}
+
+	var env map[string]string
+	select {
+	case <-ctx.Done():
+		return
+	case env = <-envCh:
+	}
+
+	c, err := s.start(env)
+	if err != nil {
+		s.logger.Error("error starting child process", "error", err)
+		s.exitCode = 1
+		return
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			s.stop(c)
+			s.exitCode = c.exitCode
+			return
+
+		case <-c.exitCh:
+			s.logger.Info("child process exited", "exit_code", c.exitCode)
+			s.exitCode = c.exitCode
+			return
+
+		case sig := <-sigCh:
+			s.logger.Debug("forwarding signal to child process", "signal", sig.String())
+			if err := c.cmd.Process.Signal(sig); err != nil {
+				s.logger.Warn("error forwarding signal to child process", "signal", sig.String(), "error", err)
+			}
+
+		case env = <-envCh:
+			switch {
+			case s.restart:
+				s.logger.Info("secrets changed, restarting child process")
+				s.stop(c)
+				if c, err = s.start(env); err != nil {
+					s.logger.Error("error restarting child process", "error", err)
+					s.exitCode = 1
+					return
+				}
+
+			case s.changeSignal != nil:
+				s.logger.Info("secrets changed, signaling child process", "signal", s.changeSignal.String())
+				if err := c.cmd.Process.Signal(s.changeSignal); err != nil {
+					s.logger.Warn("error signaling child process", "error", err)
+				}
+
+			default:
+				s.logger.Debug("secrets changed, leaving child process running")
+			}
+		}
+	}
+}
+
+// start starts the child process with env added to the environment of the
+// agent.
+func (s *Server) start(env map[string]string) (*child, error) {
+	cmd := exec.Command(s.command[0], s.command[1:]...)
+	cmd.Stdin = s.stdin
+	cmd.Stdout = s.stdout
+	cmd.Stderr = s.stderr
+	cmd.Env = buildEnv(os.Environ(), env)
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	s.logger.Info("started child process", "pid", cmd.Process.Pid)
+
+	c := &child{
+		cmd:    cmd,
+		exitCh: make(chan struct{}),
+	}
+	go func() {
+		c.exitCode = exitCode(cmd.Wait())
+		close(c.exitCh)
+	}()
+
+	return c, nil
+}
+
+// stop sends the stop signal to the child process and waits for it to exit,
+// killing it if it doesn't exit in time.
+func (s *Server) stop(c *child) {
+	select {
+	case <-c.exitCh:
+		return
+	default:
+	}
+
+	if err := c.cmd.Process.Signal(s.stopSignal); err != nil {
+		s.logger.Debug("error sending stop signal, killing child process", "error", err)
+		c.cmd.Process.Kill()
+	}
+
+	select {
+	case <-c.exitCh:
+	case <-time.After(s.killTimeout):
+		s.logger.Warn("child process did not exit in time, killing it", "timeout", s.killTimeout.String())
+		c.cmd.Process.Kill()
+		<-c.exitCh
+	}
+}
+
+// buildEnv returns base with the variables in env added, replacing any
+// existing variable of the same name.
+func buildEnv(base []string, env map[string]string) []string {
+	result := make([]string, 0, len(base)+len(env))
+	for _, kv := range base {
+		name := kv
+		if i := strings.Index(kv, "="); i >= 0 {
+			name = kv[:i]
+		}
+		if _, ok := env[name]; ok {
+			continue
+		}
+		result = append(result, kv)
+	}
+
+	names := make([]string, 0, len(env))
+	for name := range env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		result = append(result, name+"="+env[name])
+	}
+
+	return result
+}
+
+// exitCode returns the exit code of a child process from the error returned
+// by Wait. A child killed by a signal exits with 128 plus the signal number,
+// as it would from a shell.
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+			if status.Signaled() {
+				return 128 + int(status.Signal())
+			}
+			return status.ExitStatus()
+		}
+	}
+
+	return 1
+}
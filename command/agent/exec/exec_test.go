@@ -0,0 +1,165 @@
+// +build !windows
+
+package exec
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/command/agent/config"
+	"github.com/hashicorp/vault/helper/logging"
+)
+
+func testServer(t *testing.T, conf *config.Exec) *Server {
+	t.Helper()
+
+	s, err := NewServer(&ServerConfig{
+		Logger:      logging.NewVaultLogger(hclog.Trace),
+		Config:      conf,
+		KillTimeout: 5 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func testWaitForFile(t *testing.T, path, expected string) {
+	t.Helper()
+
+	var contents []byte
+	var err error
+	for i := 0; i < 100; i++ {
+		contents, err = ioutil.ReadFile(path)
+		if err == nil && string(contents) == expected {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Fatalf("expected %q in %s, got %q (err: %v)", expected, path, contents, err)
+}
+
+func TestNewServer_Signals(t *testing.T) {
+	_, err := NewServer(&ServerConfig{
+		Logger: logging.NewVaultLogger(hclog.Trace),
+		Config: &config.Exec{
+			Command:           []string{"true"},
+			RestartStopSignal: "SIGNOPE",
+		},
+	})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+
+	s := testServer(t, &config.Exec{
+		Command:                []string{"true"},
+		RestartOnSecretChanges: "never",
+		RestartStopSignal:      "int",
+		SecretChangeSignal:     "SIGHUP",
+	})
+	if s.stopSignal != signals["SIGINT"] || s.changeSignal != signals["SIGHUP"] {
+		t.Fatalf("bad: %v %v", s.stopSignal, s.changeSignal)
+	}
+}
+
+func TestServer_ExitCode(t *testing.T) {
+	s := testServer(t, &config.Exec{
+		Command: []string{"/bin/sh", "-c", "exit 3"},
+	})
+
+	envCh := make(chan map[string]string, 1)
+	envCh <- map[string]string{}
+	go s.Run(context.Background(), envCh)
+
+	select {
+	case <-s.DoneCh:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the child to exit")
+	}
+	if s.ExitCode() != 3 {
+		t.Fatalf("expected exit code 3, got %d", s.ExitCode())
+	}
+}
+
+func TestServer_Restart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agent-exec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "out")
+
+	s := testServer(t, &config.Exec{
+		Command:                []string{"/bin/sh", "-c", `echo "$TEST_SECRET" >> ` + out + `; while true; do sleep 0.1; done`},
+		RestartOnSecretChanges: "always",
+		RestartStopSignal:      "SIGTERM",
+	})
+
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	envCh := make(chan map[string]string)
+	go s.Run(ctx, envCh)
+
+	envCh <- map[string]string{"TEST_SECRET": "first"}
+	testWaitForFile(t, out, "first\n")
+
+	// A new environment restarts the child with it
+	envCh <- map[string]string{"TEST_SECRET": "second"}
+	testWaitForFile(t, out, "first\nsecond\n")
+
+	// Stopping the server stops the child with the stop signal
+	cancelFunc()
+	select {
+	case <-s.DoneCh:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the child to exit")
+	}
+	if s.ExitCode() != 128+15 {
+		t.Fatalf("expected the child to be terminated, got exit code %d", s.ExitCode())
+	}
+}
+
+func TestServer_SecretChangeSignal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agent-exec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "out")
+
+	s := testServer(t, &config.Exec{
+		Command:                []string{"/bin/sh", "-c", `trap 'echo changed >> ` + out + `' USR1; echo started >> ` + out + `; while true; do sleep 0.1; done`},
+		RestartOnSecretChanges: "never",
+		RestartStopSignal:      "SIGKILL",
+		SecretChangeSignal:     "SIGUSR1",
+	})
+
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	envCh := make(chan map[string]string)
+	go s.Run(ctx, envCh)
+
+	envCh <- map[string]string{"TEST_SECRET": "first"}
+	testWaitForFile(t, out, "started\n")
+
+	envCh <- map[string]string{"TEST_SECRET": "second"}
+	testWaitForFile(t, out, "started\nchanged\n")
+
+	cancelFunc()
+	<-s.DoneCh
+}
+
+func TestBuildEnv(t *testing.T) {
+	env := buildEnv([]string{"A=1", "B=2", "C"}, map[string]string{"B": "3", "D": "x=y"})
+	if strings.Join(env, " ") != "A=1 C B=3 D=x=y" {
+		t.Fatalf("bad: %v", env)
+	}
+}
@@ -0,0 +1,31 @@
+// +build !windows
+
+package exec
+
+import (
+	"os"
+	"syscall"
+)
+
+// signals are the signals that can be configured for the child process
+var signals = map[string]os.Signal{
+	"SIGABRT": syscall.SIGABRT,
+	"SIGALRM": syscall.SIGALRM,
+	"SIGHUP":  syscall.SIGHUP,
+	"SIGINT":  syscall.SIGINT,
+	"SIGKILL": syscall.SIGKILL,
+	"SIGQUIT": syscall.SIGQUIT,
+	"SIGTERM": syscall.SIGTERM,
+	"SIGUSR1": syscall.SIGUSR1,
+	"SIGUSR2": syscall.SIGUSR2,
+}
+
+// forwardedSignals are the signals received by the agent that are passed on
+// to the child process. Interrupts and terminations shut the agent down,
+// which stops the child with its stop signal.
+var forwardedSignals = []os.Signal{
+	syscall.SIGUSR1,
+	syscall.SIGUSR2,
+	syscall.SIGQUIT,
+	syscall.SIGALRM,
+}
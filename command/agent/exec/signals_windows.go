@@ -0,0 +1,19 @@
+// +build windows
+
+package exec
+
+import (
+	"os"
+	"syscall"
+)
+
+// signals are the signals that can be configured for the child process
+var signals = map[string]os.Signal{
+	"SIGINT":  syscall.SIGINT,
+	"SIGKILL": syscall.SIGKILL,
+	"SIGTERM": syscall.SIGTERM,
+}
+
+// forwardedSignals are the signals received by the agent that are passed on
+// to the child process
+var forwardedSignals = []os.Signal{}
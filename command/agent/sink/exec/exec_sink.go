@@ -0,0 +1,109 @@
+package exec
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/command/agent/sink"
+	"github.com/hashicorp/vault/helper/parseutil"
+)
+
+// DefaultTimeout is how long the command may run before it is killed
+const DefaultTimeout = 30 * time.Second
+
+// execSink is a Sink implementation that pipes the token into the stdin of a
+// command
+type execSink struct {
+	command []string
+	timeout time.Duration
+	logger  hclog.Logger
+}
+
+// NewExecSink creates a new exec sink with the given configuration
+func NewExecSink(conf *sink.SinkConfig) (sink.Sink, error) {
+	if conf.Logger == nil {
+		return nil, errors.New("nil logger provided")
+	}
+
+	conf.Logger.Info("creating exec sink")
+
+	e := &execSink{
+		logger:  conf.Logger,
+		timeout: DefaultTimeout,
+	}
+
+	commandRaw, ok := conf.Config["command"]
+	if !ok {
+		return nil, errors.New("'command' not specified for exec sink")
+	}
+	switch command := commandRaw.(type) {
+	case []string:
+		e.command = command
+	case []interface{}:
+		for _, arg := range command {
+			s, ok := arg.(string)
+			if !ok {
+				return nil, errors.New("could not parse 'command' as a list of strings")
+			}
+			e.command = append(e.command, s)
+		}
+	default:
+		return nil, errors.New("could not parse 'command' as a list of strings")
+	}
+	if len(e.command) == 0 || e.command[0] == "" {
+		return nil, errors.New("'command' is empty")
+	}
+
+	if timeoutRaw, ok := conf.Config["timeout"]; ok {
+		timeout, err := parseutil.ParseDurationSecond(timeoutRaw)
+		if err != nil {
+			return nil, errwrap.Wrapf("error parsing 'timeout': {{err}}", err)
+		}
+		if timeout > 0 {
+			e.timeout = timeout
+		}
+	}
+
+	if _, err := exec.LookPath(e.command[0]); err != nil {
+		return nil, errwrap.Wrapf("error finding command: {{err}}", err)
+	}
+
+	e.logger.Info("exec sink configured", "command", e.command[0])
+
+	return e, nil
+}
+
+// WriteToken implements the Server interface and runs the command with the
+// token on its stdin. A command that fails, or doesn't exit within the
+// timeout, is an error, so the sink server retries it.
+func (e *execSink) WriteToken(token string) error {
+	e.logger.Trace("enter write_token", "command", e.command[0])
+	defer e.logger.Trace("exit write_token", "command", e.command[0])
+
+	ctx, cancel := context.WithTimeout(context.Background(), e.timeout)
+	defer cancel()
+
+	// Only stderr is kept, for errors; stdout is discarded in case the
+	// command echoes the token
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, e.command[0], e.command[1:]...)
+	cmd.Stdin = strings.NewReader(token)
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			err = fmt.Errorf("command did not exit within %s", e.timeout)
+		}
+		return errwrap.Wrapf(fmt.Sprintf("error running command, stderr %q: {{err}}", strings.TrimSpace(stderr.String())), err)
+	}
+
+	e.logger.Info("token written", "command", e.command[0])
+	return nil
+}
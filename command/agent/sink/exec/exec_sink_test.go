@@ -0,0 +1,88 @@
+// +build !windows
+
+package exec
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/command/agent/sink"
+	"github.com/hashicorp/vault/helper/logging"
+)
+
+func TestExecSink(t *testing.T) {
+	log := logging.NewVaultLogger(hclog.Trace)
+
+	tmpDir, err := ioutil.TempDir("", "vault-agent-exec-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	path := filepath.Join(tmpDir, "token")
+
+	if _, err := NewExecSink(&sink.SinkConfig{
+		Logger: log.Named("sink.exec"),
+		Config: map[string]interface{}{
+			"command": []interface{}{"/nonexistent/command"},
+		},
+	}); err == nil {
+		t.Fatal("expected error for a missing command")
+	}
+
+	s, err := NewExecSink(&sink.SinkConfig{
+		Logger: log.Named("sink.exec"),
+		Config: map[string]interface{}{
+			"command": []interface{}{"/bin/sh", "-c", fmt.Sprintf("cat > %s", path)},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.WriteToken("foobar"); err != nil {
+		t.Fatal(err)
+	}
+	fileBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(fileBytes) != "foobar" {
+		t.Fatalf("expected %q, got %q", "foobar", fileBytes)
+	}
+
+	// Failures are returned with the command's stderr
+	s, err = NewExecSink(&sink.SinkConfig{
+		Logger: log.Named("sink.exec"),
+		Config: map[string]interface{}{
+			"command": []interface{}{"/bin/sh", "-c", "echo failed >&2; exit 3"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.WriteToken("foobar")
+	if err == nil || !strings.Contains(err.Error(), "failed") {
+		t.Fatalf("expected error with stderr, got %v", err)
+	}
+
+	// Commands that run too long are killed
+	s, err = NewExecSink(&sink.SinkConfig{
+		Logger: log.Named("sink.exec"),
+		Config: map[string]interface{}{
+			"command": []interface{}{"/bin/sleep", "10"},
+			"timeout": "1s",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.WriteToken("foobar")
+	if err == nil || !strings.Contains(err.Error(), "did not exit") {
+		t.Fatalf("expected timeout error, got %v", err)
+	}
+}
@@ -16,24 +16,37 @@ import (
 // fileSink is a Sink implementation that writes a token to a file
 type fileSink struct {
 	path   string
+	mode   os.FileMode
 	logger hclog.Logger
 }
 
 // NewFileSink creates a new file sink with the given configuration
 func NewFileSink(conf *sink.SinkConfig) (sink.Sink, error) {
+	return newFileSink(conf, "file", 0640)
+}
+
+// NewTmpfsSink creates a new sink meant for files on a tmpfs, or another
+// memory-backed filesystem. Tokens are written as by the file sink, but to
+// files readable only by the agent's user.
+func NewTmpfsSink(conf *sink.SinkConfig) (sink.Sink, error) {
+	return newFileSink(conf, "tmpfs", 0400)
+}
+
+func newFileSink(conf *sink.SinkConfig, sinkType string, mode os.FileMode) (sink.Sink, error) {
 	if conf.Logger == nil {
 		return nil, errors.New("nil logger provided")
 	}
 
-	conf.Logger.Info("creating file sink")
+	conf.Logger.Info(fmt.Sprintf("creating %s sink", sinkType))
 
 	f := &fileSink{
+		mode:   mode,
 		logger: conf.Logger,
 	}
 
 	pathRaw, ok := conf.Config["path"]
 	if !ok {
-		return nil, errors.New("'path' not specified for file sink")
+		return nil, fmt.Errorf("'path' not specified for %s sink", sinkType)
 	}
 	path, ok := pathRaw.(string)
 	if !ok {
@@ -46,7 +59,7 @@ func NewFileSink(conf *sink.SinkConfig) (sink.Sink, error) {
 		return nil, errwrap.Wrapf("error during write check: {{err}}", err)
 	}
 
-	f.logger.Info("file sink configured", "path", f.path)
+	f.logger.Info(fmt.Sprintf("%s sink configured", sinkType), "path", f.path)
 
 	return f, nil
 }
@@ -69,7 +82,10 @@ func (f *fileSink) WriteToken(token string) error {
 	fileName := filepath.Base(f.path)
 	tmpSuffix := strings.Split(u, "-")[0]
 
-	tmpFile, err := os.OpenFile(filepath.Join(targetDir, fmt.Sprintf("%s.tmp.%s", fileName, tmpSuffix)), os.O_WRONLY|os.O_CREATE, 0640)
+	// The temp file is created next to the target, so that the rename is
+	// atomic, with its final mode, so that the token is never readable by
+	// others
+	tmpFile, err := os.OpenFile(filepath.Join(targetDir, fmt.Sprintf("%s.tmp.%s", fileName, tmpSuffix)), os.O_WRONLY|os.O_CREATE|os.O_EXCL, f.mode)
 	if err != nil {
 		return errwrap.Wrapf(fmt.Sprintf("error opening temp file in dir %s for writing: {{err}}", targetDir), err)
 	}
@@ -89,6 +105,7 @@ func (f *fileSink) WriteToken(token string) error {
 
 	err = tmpFile.Close()
 	if err != nil {
+		os.Remove(tmpFile.Name())
 		return errwrap.Wrapf(fmt.Sprintf("error closing %s: {{err}}", tmpFile.Name()), err)
 	}
 
@@ -104,6 +121,7 @@ func (f *fileSink) WriteToken(token string) error {
 
 	err = os.Rename(tmpFile.Name(), f.path)
 	if err != nil {
+		os.Remove(tmpFile.Name())
 		return errwrap.Wrapf(fmt.Sprintf("error renaming temp file %s to target file %s: {{err}}", tmpFile.Name(), f.path), err)
 	}
 
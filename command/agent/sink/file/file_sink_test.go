@@ -80,3 +80,58 @@ func TestFileSink(t *testing.T) {
 		t.Fatalf("expected %s, got %s", uuidStr, string(fileBytes))
 	}
 }
+
+func TestTmpfsSink(t *testing.T) {
+	log := logging.NewVaultLogger(hclog.Trace)
+
+	tmpDir, err := ioutil.TempDir("", fmt.Sprintf("%s.", fileServerTestDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	path := filepath.Join(tmpDir, "token")
+
+	s, err := NewTmpfsSink(&sink.SinkConfig{
+		Logger: log.Named("sink.tmpfs"),
+		Config: map[string]interface{}{
+			"path": path,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Replacing a read-only token must work too
+	for i := 0; i < 2; i++ {
+		uuidStr, _ := uuid.GenerateUUID()
+		if err := s.WriteToken(uuidStr); err != nil {
+			t.Fatal(err)
+		}
+
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Mode() != os.FileMode(0400) {
+			t.Fatalf("wrong file mode %v was detected at %s", fi.Mode(), path)
+		}
+
+		fileBytes, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(fileBytes) != uuidStr {
+			t.Fatalf("expected %s, got %s", uuidStr, string(fileBytes))
+		}
+	}
+
+	// No temp files are left behind
+	files, err := ioutil.ReadDir(tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("expected only the token file, got %d files", len(files))
+	}
+}
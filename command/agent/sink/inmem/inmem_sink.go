@@ -0,0 +1,39 @@
+package inmem
+
+import (
+	"errors"
+	"sync/atomic"
+
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/command/agent/sink"
+)
+
+// inmemSink retains the auto-auth token in memory and exposes it via
+// sink.SinkReader interface.
+type inmemSink struct {
+	logger hclog.Logger
+	token  atomic.Value
+}
+
+// New creates a new instance of inmemSink.
+func New(conf *sink.SinkConfig) (sink.Sink, error) {
+	if conf.Logger == nil {
+		return nil, errors.New("nil logger provided")
+	}
+
+	s := &inmemSink{
+		logger: conf.Logger,
+	}
+	s.token.Store("")
+
+	return s, nil
+}
+
+func (s *inmemSink) WriteToken(token string) error {
+	s.token.Store(token)
+	return nil
+}
+
+func (s *inmemSink) Token() string {
+	return s.token.Load().(string)
+}
@@ -12,6 +12,7 @@ import (
 	"github.com/hashicorp/errwrap"
 	"github.com/hashicorp/go-hclog"
 	"github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/command/agent/status"
 	"github.com/hashicorp/vault/helper/dhutil"
 	"github.com/hashicorp/vault/helper/jsonutil"
 )
@@ -20,8 +21,16 @@ type Sink interface {
 	WriteToken(string) error
 }
 
+// SinkReader is implemented by sinks that can return the token they last
+// received.
+type SinkReader interface {
+	Token() string
+}
+
 type SinkConfig struct {
 	Sink
+	// Name identifies the sink in the status of the sink server
+	Name               string
 	Logger             hclog.Logger
 	Config             map[string]interface{}
 	Client             *api.Client
@@ -39,6 +48,9 @@ type SinkServerConfig struct {
 	Client        *api.Client
 	Context       context.Context
 	ExitAfterAuth bool
+
+	// Status, if set, records the result of the writes to the sinks
+	Status *status.Status
 }
 
 // SinkServer is responsible for pushing tokens to sinks
@@ -49,6 +61,8 @@ type SinkServer struct {
 	random        *rand.Rand
 	exitAfterAuth bool
 	remaining     *int32
+	status        *status.Status
+	reloadCh      chan []*SinkConfig
 }
 
 func NewSinkServer(conf *SinkServerConfig) *SinkServer {
@@ -59,11 +73,25 @@ func NewSinkServer(conf *SinkServerConfig) *SinkServer {
 		random:        rand.New(rand.NewSource(int64(time.Now().Nanosecond()))),
 		exitAfterAuth: conf.ExitAfterAuth,
 		remaining:     new(int32),
+		status:        conf.Status,
+		reloadCh:      make(chan []*SinkConfig, 1),
 	}
 
 	return ss
 }
 
+// Reload replaces the sinks used by Run. The latest token is written to the
+// new sinks.
+func (ss *SinkServer) Reload(sinks []*SinkConfig) {
+	for {
+		select {
+		case ss.reloadCh <- sinks:
+			return
+		case <-ss.reloadCh:
+		}
+	}
+}
+
 // Run executes the server's run loop, which is responsible for reading
 // in new tokens and pushing them out to the various sinks.
 func (ss *SinkServer) Run(ctx context.Context, incoming chan string, sinks []*SinkConfig) {
@@ -79,6 +107,37 @@ func (ss *SinkServer) Run(ctx context.Context, incoming chan string, sinks []*Si
 
 	latestToken := new(string)
 	sinkCh := make(chan func() error, len(sinks))
+
+	// drain drops the pending writes
+	drain := func() {
+		for {
+			select {
+			case <-sinkCh:
+				atomic.AddInt32(ss.remaining, -1)
+			default:
+				return
+			}
+		}
+	}
+
+	// queueWrites queues a write of the latest token to each sink
+	queueWrites := func() {
+		for _, s := range sinks {
+			sinkFunc := func(currSink *SinkConfig, currToken string) func() error {
+				return func() error {
+					if currToken != *latestToken {
+						return nil
+					}
+					err := currSink.write(ss.client, currToken)
+					ss.status.SinkWrite(currSink.Name, err)
+					return err
+				}
+			}
+			atomic.AddInt32(ss.remaining, 1)
+			sinkCh <- sinkFunc(s, *latestToken)
+		}
+	}
+
 	for {
 		select {
 		case <-ctx.Done():
@@ -86,46 +145,18 @@ func (ss *SinkServer) Run(ctx context.Context, incoming chan string, sinks []*Si
 
 		case token := <-incoming:
 			if token != *latestToken {
-
-				// Drain the existing funcs
-			drainLoop:
-				for {
-					select {
-					case <-sinkCh:
-						atomic.AddInt32(ss.remaining, -1)
-					default:
-						break drainLoop
-					}
-				}
-
+				drain()
 				*latestToken = token
+				queueWrites()
+			}
 
-				for _, s := range sinks {
-					sinkFunc := func(currSink *SinkConfig, currToken string) func() error {
-						return func() error {
-							if currToken != *latestToken {
-								return nil
-							}
-							var err error
-
-							if currSink.WrapTTL != 0 {
-								if currToken, err = s.wrapToken(ss.client, currSink.WrapTTL, currToken); err != nil {
-									return err
-								}
-							}
-
-							if s.DHType != "" {
-								if currToken, err = s.encryptToken(currToken); err != nil {
-									return err
-								}
-							}
-
-							return currSink.WriteToken(currToken)
-						}
-					}
-					atomic.AddInt32(ss.remaining, 1)
-					sinkCh <- sinkFunc(s, token)
-				}
+		case newSinks := <-ss.reloadCh:
+			ss.logger.Info("sinks reloaded")
+			drain()
+			sinks = newSinks
+			sinkCh = make(chan func() error, len(sinks))
+			if *latestToken != "" {
+				queueWrites()
 			}
 
 		case sinkFunc := <-sinkCh:
@@ -155,6 +186,25 @@ func (ss *SinkServer) Run(ctx context.Context, incoming chan string, sinks []*Si
 	}
 }
 
+// write wraps and encrypts the token as configured, and writes it to the sink
+func (s *SinkConfig) write(client *api.Client, token string) error {
+	var err error
+
+	if s.WrapTTL != 0 {
+		if token, err = s.wrapToken(client, s.WrapTTL, token); err != nil {
+			return err
+		}
+	}
+
+	if s.DHType != "" {
+		if token, err = s.encryptToken(token); err != nil {
+			return err
+		}
+	}
+
+	return s.WriteToken(token)
+}
+
 func (s *SinkConfig) encryptToken(token string) (string, error) {
 	var aesKey []byte
 	var err error
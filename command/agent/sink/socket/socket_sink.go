@@ -0,0 +1,217 @@
+package socket
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/command/agent/sink"
+	"github.com/hashicorp/vault/helper/parseutil"
+	"github.com/hashicorp/vault/helper/peercred"
+)
+
+// DefaultWriteTimeout is how long writing the token to a connection may take
+// before the connection is closed
+const DefaultWriteTimeout = 5 * time.Second
+
+// socketSink is a Sink implementation that serves the latest token to local
+// processes connecting to a unix socket, after checking their uid
+type socketSink struct {
+	path         string
+	logger       hclog.Logger
+	allowedUIDs  map[uint32]bool
+	listener     net.Listener
+	socketInfo   os.FileInfo
+	token        *atomic.Value
+	writeTimeout time.Duration
+	doneCh       chan struct{}
+	connWG       sync.WaitGroup
+}
+
+// NewSocketSink creates a new unix socket sink with the given configuration.
+// The sink listens until Close is called.
+func NewSocketSink(conf *sink.SinkConfig) (sink.Sink, error) {
+	if conf.Logger == nil {
+		return nil, errors.New("nil logger provided")
+	}
+
+	conf.Logger.Info("creating socket sink")
+
+	s := &socketSink{
+		logger:       conf.Logger,
+		allowedUIDs:  make(map[uint32]bool),
+		token:        new(atomic.Value),
+		writeTimeout: DefaultWriteTimeout,
+		doneCh:       make(chan struct{}),
+	}
+	s.token.Store("")
+
+	pathRaw, ok := conf.Config["path"]
+	if !ok {
+		return nil, errors.New("'path' not specified for socket sink")
+	}
+	s.path, ok = pathRaw.(string)
+	if !ok || s.path == "" {
+		return nil, errors.New("could not parse 'path' as string")
+	}
+
+	mode := os.FileMode(0600)
+	if modeRaw, ok := conf.Config["mode"]; ok {
+		m, err := strconv.ParseUint(fmt.Sprintf("%v", modeRaw), 8, 32)
+		if err != nil {
+			return nil, errwrap.Wrapf("error parsing 'mode': {{err}}", err)
+		}
+		mode = os.FileMode(m)
+	}
+
+	if uidsRaw, ok := conf.Config["allowed_uids"]; ok {
+		uids, err := parseutil.ParseCommaStringSlice(uidsRaw)
+		if err != nil {
+			return nil, errwrap.Wrapf("error parsing 'allowed_uids': {{err}}", err)
+		}
+		for _, name := range uids {
+			uid, err := lookupUID(name)
+			if err != nil {
+				return nil, errwrap.Wrapf(fmt.Sprintf("error looking up user %q: {{err}}", name), err)
+			}
+			s.allowedUIDs[uid] = true
+		}
+	}
+	if len(s.allowedUIDs) == 0 {
+		s.allowedUIDs[uint32(os.Getuid())] = true
+	}
+
+	// Remove a socket left behind by a previous run, but refuse to replace
+	// anything else
+	if fi, err := os.Lstat(s.path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%q exists and is not a socket", s.path)
+		}
+		if err := os.Remove(s.path); err != nil {
+			return nil, errwrap.Wrapf("error removing existing socket: {{err}}", err)
+		}
+	}
+
+	ln, err := net.ListenUnix("unix", &net.UnixAddr{Name: s.path, Net: "unix"})
+	if err != nil {
+		return nil, errwrap.Wrapf("error listening on socket: {{err}}", err)
+	}
+	// The socket is removed on Close only if it wasn't replaced, such as by
+	// a new sink on the same path after a reload
+	ln.SetUnlinkOnClose(false)
+	if err := os.Chmod(s.path, mode); err != nil {
+		ln.Close()
+		os.Remove(s.path)
+		return nil, errwrap.Wrapf("error setting socket mode: {{err}}", err)
+	}
+	if s.socketInfo, err = os.Lstat(s.path); err != nil {
+		ln.Close()
+		return nil, errwrap.Wrapf("error reading socket info: {{err}}", err)
+	}
+	s.listener = &peercred.Listener{UnixListener: ln}
+
+	go s.serve()
+
+	s.logger.Info("socket sink configured", "path", s.path)
+
+	return s, nil
+}
+
+// WriteToken implements the Server interface and stores the token to be
+// served to the next connections.
+func (s *socketSink) WriteToken(token string) error {
+	s.token.Store(token)
+	s.logger.Info("token updated", "path", s.path)
+	return nil
+}
+
+// Close stops listening, waits for the connections being served and removes
+// the socket, unless it was replaced
+func (s *socketSink) Close() error {
+	err := s.listener.Close()
+	<-s.doneCh
+	s.connWG.Wait()
+	if fi, statErr := os.Lstat(s.path); statErr == nil && os.SameFile(fi, s.socketInfo) {
+		os.Remove(s.path)
+	}
+	return err
+}
+
+func (s *socketSink) serve() {
+	defer close(s.doneCh)
+
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				s.logger.Warn("temporary error accepting connection", "error", err)
+				continue
+			}
+			return
+		}
+
+		// A client that doesn't read must not hold up the others
+		s.connWG.Add(1)
+		go func() {
+			defer s.connWG.Done()
+			s.handle(conn)
+		}()
+	}
+}
+
+// handle writes the token to conn if the uid of the peer is allowed. Without
+// a token yet, or if the peer isn't allowed, the connection is closed without
+// writing anything.
+func (s *socketSink) handle(conn net.Conn) {
+	defer conn.Close()
+
+	addr, ok := conn.RemoteAddr().(*peercred.Addr)
+	if !ok {
+		s.logger.Warn("rejecting connection: peer credentials unavailable")
+		return
+	}
+	if !s.allowedUIDs[addr.Creds.UID] {
+		s.logger.Warn("rejecting connection from disallowed uid", "uid", addr.Creds.UID, "pid", addr.Creds.PID)
+		return
+	}
+
+	token := s.token.Load().(string)
+	if token == "" {
+		return
+	}
+
+	if err := conn.SetWriteDeadline(time.Now().Add(s.writeTimeout)); err != nil {
+		s.logger.Warn("error setting write deadline", "error", err)
+		return
+	}
+	if _, err := conn.Write([]byte(token)); err != nil {
+		s.logger.Warn("error writing token to connection", "error", err)
+		return
+	}
+	s.logger.Trace("token served", "uid", addr.Creds.UID, "pid", addr.Creds.PID)
+}
+
+// lookupUID returns the uid of the given user name or numeric id
+func lookupUID(name string) (uint32, error) {
+	if id, err := strconv.ParseUint(name, 10, 32); err == nil {
+		return uint32(id), nil
+	}
+
+	u, err := user.Lookup(name)
+	if err != nil {
+		return 0, err
+	}
+	id, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return 0, err
+	}
+	return uint32(id), nil
+}
@@ -0,0 +1,265 @@
+// +build linux
+
+package socket
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/command/agent/sink"
+	"github.com/hashicorp/vault/helper/dhutil"
+	"github.com/hashicorp/vault/helper/jsonutil"
+	"github.com/hashicorp/vault/helper/logging"
+)
+
+func testReadSocket(t *testing.T, path string) string {
+	t.Helper()
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	val, err := ioutil.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(val)
+}
+
+func TestSocketSink(t *testing.T) {
+	log := logging.NewVaultLogger(hclog.Trace)
+
+	tmpDir, err := ioutil.TempDir("", "vault-agent-socket-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	path := filepath.Join(tmpDir, "agent.sock")
+
+	s, err := NewSocketSink(&sink.SinkConfig{
+		Logger: log.Named("sink.socket"),
+		Config: map[string]interface{}{
+			"path": path,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != os.FileMode(0600) {
+		t.Fatalf("wrong socket mode %v", fi.Mode())
+	}
+
+	// Nothing is served before the first token
+	if val := testReadSocket(t, path); val != "" {
+		t.Fatalf("expected no token, got %q", val)
+	}
+
+	if err := s.WriteToken("foobar"); err != nil {
+		t.Fatal(err)
+	}
+	if val := testReadSocket(t, path); val != "foobar" {
+		t.Fatalf("expected %q, got %q", "foobar", val)
+	}
+
+	if err := s.(*socketSink).Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Lstat(path); !os.IsNotExist(err) {
+		t.Fatalf("expected socket to be removed, got %v", err)
+	}
+
+	// A socket from a previous run is replaced, and only allowed uids are
+	// served
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.SetUnlinkOnClose(false)
+	l.Close()
+
+	s, err = NewSocketSink(&sink.SinkConfig{
+		Logger: log.Named("sink.socket"),
+		Config: map[string]interface{}{
+			"path":         path,
+			"allowed_uids": []interface{}{os.Getuid() + 1},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.(*socketSink).Close()
+
+	if err := s.WriteToken("foobar"); err != nil {
+		t.Fatal(err)
+	}
+	if val := testReadSocket(t, path); val != "" {
+		t.Fatalf("expected disallowed uid to get nothing, got %q", val)
+	}
+}
+
+func TestSocketSink_Encrypted(t *testing.T) {
+	log := logging.NewVaultLogger(hclog.Trace)
+
+	tmpDir, err := ioutil.TempDir("", "vault-agent-socket-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	path := filepath.Join(tmpDir, "agent.sock")
+	dhPath := filepath.Join(tmpDir, "dh.json")
+
+	pub, pri, err := dhutil.GeneratePublicPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mPubKey, err := jsonutil.EncodeJSON(&dhutil.PublicKeyInfo{
+		Curve25519PublicKey: pub,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(dhPath, mPubKey, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	config := &sink.SinkConfig{
+		Logger: log.Named("sink.socket"),
+		AAD:    "foobar",
+		DHType: "curve25519",
+		DHPath: dhPath,
+		Config: map[string]interface{}{
+			"path": path,
+		},
+	}
+	s, err := NewSocketSink(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.(*socketSink).Close()
+	config.Sink = s
+
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	ss := sink.NewSinkServer(&sink.SinkServerConfig{
+		Logger: log.Named("sink.server"),
+	})
+	in := make(chan string)
+	go ss.Run(ctx, in, []*sink.SinkConfig{config})
+	in <- "token"
+
+	var val string
+	timeout := time.Now().Add(5 * time.Second)
+	for val == "" {
+		if time.Now().After(timeout) {
+			t.Fatal("did not get a token after timeout")
+		}
+		time.Sleep(100 * time.Millisecond)
+		val = testReadSocket(t, path)
+	}
+	cancelFunc()
+	<-ss.DoneCh
+
+	resp := new(dhutil.Envelope)
+	if err := jsonutil.DecodeJSON([]byte(val), resp); err != nil {
+		t.Fatal(err)
+	}
+	aesKey, err := dhutil.GenerateSharedKey(pri, resp.Curve25519PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := dhutil.DecryptAES(aesKey, resp.EncryptedPayload, resp.Nonce, []byte("foobar"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(token) != "token" {
+		t.Fatalf("expected %q, got %q", "token", token)
+	}
+}
+
+func TestSocketSink_SlowClient(t *testing.T) {
+	log := logging.NewVaultLogger(hclog.Trace)
+
+	tmpDir, err := ioutil.TempDir("", "vault-agent-socket-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	path := filepath.Join(tmpDir, "agent.sock")
+
+	s, err := NewSocketSink(&sink.SinkConfig{
+		Logger: log.Named("sink.socket"),
+		Config: map[string]interface{}{
+			"path": path,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ss := s.(*socketSink)
+	ss.writeTimeout = time.Second
+
+	// The token is larger than the socket buffers, so writing it blocks
+	// until the client reads
+	token := strings.Repeat("a", 16*1024*1024)
+	if err := s.WriteToken(token); err != nil {
+		t.Fatal(err)
+	}
+
+	slow, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer slow.Close()
+
+	// Other clients are still served while the slow one doesn't read
+	doneCh := make(chan string)
+	go func() {
+		conn, err := net.Dial("unix", path)
+		if err != nil {
+			doneCh <- ""
+			return
+		}
+		defer conn.Close()
+		val, _ := ioutil.ReadAll(conn)
+		doneCh <- string(val)
+	}()
+	select {
+	case val := <-doneCh:
+		if val != token {
+			t.Fatalf("expected the token, got %d bytes", len(val))
+		}
+	case <-time.After(ss.writeTimeout / 2):
+		t.Fatal("client was not served while another one wasn't reading")
+	}
+
+	// The slow client is dropped after the write timeout, so Close doesn't
+	// wait for it
+	closedCh := make(chan error)
+	go func() {
+		closedCh <- ss.Close()
+	}()
+	select {
+	case err := <-closedCh:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * ss.writeTimeout):
+		t.Fatal("close did not return after the write timeout")
+	}
+}
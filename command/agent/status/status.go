@@ -0,0 +1,255 @@
+package status
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	metrics "github.com/armon/go-metrics"
+	"github.com/hashicorp/vault/helper/metricsutil"
+	"github.com/hashicorp/vault/logical"
+)
+
+const (
+	// AuthStateDisabled is reported when auto-auth isn't configured
+	AuthStateDisabled = "disabled"
+
+	// AuthStateAuthenticating is reported until the first successful
+	// authentication
+	AuthStateAuthenticating = "authenticating"
+
+	// AuthStateAuthenticated is reported while the auto-auth token is valid
+	AuthStateAuthenticated = "authenticated"
+
+	// AuthStateExpired is reported once the auto-auth token has expired
+	// without a new one
+	AuthStateExpired = "expired"
+)
+
+// Status tracks the state of auto-auth and of the sinks, as reported by the
+// health and metrics endpoints. A nil Status ignores all updates, so that
+// components can be used without one.
+type Status struct {
+	l sync.RWMutex
+
+	authEnabled bool
+	lastAuth    time.Time
+	lastAuthErr string
+	tokenExpiry time.Time
+	sinks       map[string]*SinkStatus
+}
+
+// AutoAuthStatus is the auto-auth part of a report
+type AutoAuthStatus struct {
+	State       string     `json:"state"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+
+	// TokenTTL is the remaining TTL of the token in seconds, or -1 if the
+	// token doesn't expire
+	TokenTTL int64 `json:"token_ttl"`
+}
+
+// SinkStatus is the status of a sink in a report
+type SinkStatus struct {
+	LastWrite     *time.Time `json:"last_write,omitempty"`
+	WriteFailures uint64     `json:"write_failures"`
+	LastError     string     `json:"last_error,omitempty"`
+}
+
+// Report is the body of the health endpoint
+type Report struct {
+	Healthy  bool                   `json:"healthy"`
+	AutoAuth *AutoAuthStatus        `json:"auto_auth"`
+	Sinks    map[string]*SinkStatus `json:"sinks"`
+}
+
+// New returns a new Status. If authEnabled is false, auto-auth is reported
+// as disabled, and is always healthy.
+func New(authEnabled bool) *Status {
+	return &Status{
+		authEnabled: authEnabled,
+		sinks:       make(map[string]*SinkStatus),
+	}
+}
+
+// AuthSucceeded records a successful authentication, with the TTL of the new
+// token. A TTL of zero means the token doesn't expire.
+func (s *Status) AuthSucceeded(ttl time.Duration) {
+	if s == nil {
+		return
+	}
+	metrics.IncrCounter([]string{"agent", "auth", "success"}, 1)
+
+	s.l.Lock()
+	defer s.l.Unlock()
+	s.lastAuth = time.Now()
+	s.lastAuthErr = ""
+	s.setTTL(ttl)
+}
+
+// TokenRenewed records the new TTL of the auto-auth token after a renewal
+func (s *Status) TokenRenewed(ttl time.Duration) {
+	if s == nil {
+		return
+	}
+
+	s.l.Lock()
+	defer s.l.Unlock()
+	s.setTTL(ttl)
+}
+
+func (s *Status) setTTL(ttl time.Duration) {
+	s.tokenExpiry = time.Time{}
+	if ttl > 0 {
+		s.tokenExpiry = time.Now().Add(ttl)
+	}
+}
+
+// AuthFailed records a failed authentication attempt
+func (s *Status) AuthFailed(err error) {
+	if s == nil {
+		return
+	}
+	metrics.IncrCounter([]string{"agent", "auth", "failure"}, 1)
+
+	s.l.Lock()
+	defer s.l.Unlock()
+	s.lastAuthErr = err.Error()
+}
+
+// SinkWrite records the result of writing a token to the named sink
+func (s *Status) SinkWrite(name string, err error) {
+	if s == nil {
+		return
+	}
+	if err != nil {
+		metrics.IncrCounterWithLabels([]string{"agent", "sink", "failure"}, 1, []metrics.Label{{Name: "sink", Value: name}})
+	} else {
+		metrics.IncrCounterWithLabels([]string{"agent", "sink", "success"}, 1, []metrics.Label{{Name: "sink", Value: name}})
+	}
+
+	s.l.Lock()
+	defer s.l.Unlock()
+	sinkStatus, ok := s.sinks[name]
+	if !ok {
+		sinkStatus = &SinkStatus{}
+		s.sinks[name] = sinkStatus
+	}
+	if err != nil {
+		sinkStatus.WriteFailures++
+		sinkStatus.LastError = err.Error()
+		return
+	}
+	now := time.Now()
+	sinkStatus.LastWrite = &now
+	sinkStatus.LastError = ""
+}
+
+// Report returns a snapshot of the status. The agent is healthy unless
+// auto-auth is enabled and there is no valid token.
+func (s *Status) Report() *Report {
+	s.l.RLock()
+	defer s.l.RUnlock()
+
+	authStatus := &AutoAuthStatus{
+		State:     AuthStateDisabled,
+		LastError: s.lastAuthErr,
+		TokenTTL:  -1,
+	}
+	if s.authEnabled {
+		switch {
+		case s.lastAuth.IsZero():
+			authStatus.State = AuthStateAuthenticating
+			authStatus.TokenTTL = 0
+		case !s.tokenExpiry.IsZero() && !time.Now().Before(s.tokenExpiry):
+			authStatus.State = AuthStateExpired
+			authStatus.TokenTTL = 0
+		default:
+			authStatus.State = AuthStateAuthenticated
+			if !s.tokenExpiry.IsZero() {
+				authStatus.TokenTTL = int64(time.Until(s.tokenExpiry).Seconds())
+			}
+		}
+		if !s.lastAuth.IsZero() {
+			lastAuth := s.lastAuth
+			authStatus.LastSuccess = &lastAuth
+		}
+	}
+
+	sinks := make(map[string]*SinkStatus, len(s.sinks))
+	for name, sinkStatus := range s.sinks {
+		copied := *sinkStatus
+		sinks[name] = &copied
+	}
+
+	return &Report{
+		Healthy:  authStatus.State == AuthStateDisabled || authStatus.State == AuthStateAuthenticated,
+		AutoAuth: authStatus,
+		Sinks:    sinks,
+	}
+}
+
+// emitGauges sets the gauges derived from the current status, so that they
+// are up to date when the metrics are read
+func (s *Status) emitGauges() {
+	report := s.Report()
+
+	var authenticated float32
+	if report.AutoAuth.State == AuthStateAuthenticated {
+		authenticated = 1
+	}
+	metrics.SetGauge([]string{"agent", "auth", "authenticated"}, authenticated)
+	if report.AutoAuth.State != AuthStateDisabled {
+		metrics.SetGauge([]string{"agent", "auth", "token_ttl"}, float32(report.AutoAuth.TokenTTL))
+	}
+}
+
+// HealthHandler returns a handler reporting the status, with a 503 status
+// code when the agent isn't healthy
+func (s *Status) HealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		report := s.Report()
+		status := http.StatusOK
+		if !report.Healthy {
+			status = http.StatusServiceUnavailable
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(report)
+	})
+}
+
+// MetricsHandler returns a handler serving the agent metrics, in the format
+// given by the format query parameter or the Accept header
+func (s *Status) MetricsHandler(helper *metricsutil.MetricsHelper) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		s.emitGauges()
+
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = metricsutil.FormatFromRequest(&logical.Request{Headers: r.Header})
+		}
+		resp, err := helper.ResponseForFormat(format)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", resp.Data[logical.HTTPContentType].(string))
+		w.WriteHeader(resp.Data[logical.HTTPStatusCode].(int))
+		w.Write(resp.Data[logical.HTTPRawBody].([]byte))
+	})
+}
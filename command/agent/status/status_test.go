@@ -0,0 +1,92 @@
+package status
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestStatus_Report(t *testing.T) {
+	s := New(false)
+	if report := s.Report(); !report.Healthy || report.AutoAuth.State != AuthStateDisabled {
+		t.Fatalf("bad: %#v", report.AutoAuth)
+	}
+
+	s = New(true)
+	if report := s.Report(); report.Healthy || report.AutoAuth.State != AuthStateAuthenticating {
+		t.Fatalf("bad: %#v", report.AutoAuth)
+	}
+
+	s.AuthFailed(errors.New("permission denied"))
+	if report := s.Report(); report.Healthy || report.AutoAuth.LastError != "permission denied" {
+		t.Fatalf("bad: %#v", report.AutoAuth)
+	}
+
+	s.AuthSucceeded(time.Hour)
+	report := s.Report()
+	if !report.Healthy || report.AutoAuth.State != AuthStateAuthenticated || report.AutoAuth.LastError != "" {
+		t.Fatalf("bad: %#v", report.AutoAuth)
+	}
+	if report.AutoAuth.TokenTTL <= 3500 || report.AutoAuth.TokenTTL > 3600 || report.AutoAuth.LastSuccess == nil {
+		t.Fatalf("bad: %#v", report.AutoAuth)
+	}
+
+	// Tokens without a TTL don't expire
+	s.TokenRenewed(0)
+	if report := s.Report(); !report.Healthy || report.AutoAuth.TokenTTL != -1 {
+		t.Fatalf("bad: %#v", report.AutoAuth)
+	}
+
+	s.TokenRenewed(time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	if report := s.Report(); report.Healthy || report.AutoAuth.State != AuthStateExpired {
+		t.Fatalf("bad: %#v", report.AutoAuth)
+	}
+}
+
+func TestStatus_SinkWrite(t *testing.T) {
+	s := New(false)
+	s.SinkWrite("file:/tmp/token", errors.New("disk full"))
+	s.SinkWrite("file:/tmp/token", errors.New("disk full"))
+
+	sinkStatus := s.Report().Sinks["file:/tmp/token"]
+	if sinkStatus == nil || sinkStatus.WriteFailures != 2 || sinkStatus.LastError != "disk full" || sinkStatus.LastWrite != nil {
+		t.Fatalf("bad: %#v", sinkStatus)
+	}
+
+	s.SinkWrite("file:/tmp/token", nil)
+	sinkStatus = s.Report().Sinks["file:/tmp/token"]
+	if sinkStatus.WriteFailures != 2 || sinkStatus.LastError != "" || sinkStatus.LastWrite == nil {
+		t.Fatalf("bad: %#v", sinkStatus)
+	}
+
+	// A nil status ignores updates
+	var nilStatus *Status
+	nilStatus.SinkWrite("file:/tmp/token", nil)
+	nilStatus.AuthSucceeded(time.Hour)
+}
+
+func TestStatus_HealthHandler(t *testing.T) {
+	s := New(true)
+
+	w := httptest.NewRecorder()
+	s.HealthHandler().ServeHTTP(w, httptest.NewRequest("GET", "/agent/v1/health", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503, got %d", w.Code)
+	}
+
+	s.AuthSucceeded(time.Hour)
+	w = httptest.NewRecorder()
+	s.HealthHandler().ServeHTTP(w, httptest.NewRequest("GET", "/agent/v1/health", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	s.HealthHandler().ServeHTTP(w, httptest.NewRequest("POST", "/agent/v1/health", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected 405, got %d", w.Code)
+	}
+}
@@ -0,0 +1,691 @@
+package template
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+	hclog "github.com/hashicorp/go-hclog"
+	uuid "github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/command/agent/config"
+)
+
+const (
+	// DefaultStaticSecretRenderInterval is how often secrets without a
+	// lease, such as KV secrets, are read again to pick up new versions.
+	DefaultStaticSecretRenderInterval = 5 * time.Minute
+
+	minBackoff = 1 * time.Second
+	maxBackoff = 1 * time.Minute
+)
+
+// ServerConfig is the configuration of the template server
+type ServerConfig struct {
+	Logger hclog.Logger
+	Client *api.Client
+
+	// StaticSecretRenderInterval overrides
+	// DefaultStaticSecretRenderInterval if set
+	StaticSecretRenderInterval time.Duration
+
+	// EnvTemplates are rendered into an environment, which is sent on EnvCh
+	// once all of them have rendered, and again each time one of them
+	// changes
+	EnvTemplates []*config.EnvTemplate
+	EnvCh        chan<- map[string]string
+}
+
+// Server is responsible for rendering templates with the auto-auth token and
+// keeping the rendered files up to date
+type Server struct {
+	DoneCh         chan struct{}
+	logger         hclog.Logger
+	client         *api.Client
+	staticInterval time.Duration
+	envTemplates   []*config.EnvTemplate
+	envCh          chan<- map[string]string
+	reloadCh       chan []*config.Template
+}
+
+// NewServer returns a new template server
+func NewServer(conf *ServerConfig) *Server {
+	ts := &Server{
+		DoneCh:         make(chan struct{}),
+		logger:         conf.Logger,
+		client:         conf.Client,
+		staticInterval: conf.StaticSecretRenderInterval,
+		envTemplates:   conf.EnvTemplates,
+		envCh:          conf.EnvCh,
+		reloadCh:       make(chan []*config.Template, 1),
+	}
+	if ts.staticInterval == 0 {
+		ts.staticInterval = DefaultStaticSecretRenderInterval
+	}
+
+	return ts
+}
+
+// Reload replaces the templates rendered by Run. The environment templates
+// are not reloaded.
+func (ts *Server) Reload(templates []*config.Template) {
+	for {
+		select {
+		case ts.reloadCh <- templates:
+			return
+		case <-ts.reloadCh:
+		}
+	}
+}
+
+// Run renders the templates each time a new token is received on incoming,
+// and re-renders them when the secrets they use change, until ctx is done.
+func (ts *Server) Run(ctx context.Context, incoming chan string, templates []*config.Template) {
+	ts.logger.Info("starting template server")
+	defer func() {
+		ts.logger.Info("template server stopped")
+		close(ts.DoneCh)
+	}()
+
+	if incoming == nil {
+		panic("incoming channel is nil")
+	}
+
+	var envRunners []*runner
+	if ts.envCh != nil {
+		envRunners = ts.envRunners(ctx)
+	}
+
+	ts.runRunners(ctx, incoming, templates, envRunners)
+}
+
+// fileRunners returns the runners of the templates rendered to files
+func (ts *Server) fileRunners(templates []*config.Template) []*runner {
+	runners := make([]*runner, 0, len(templates))
+	for _, tmpl := range templates {
+		r := ts.newRunner(tmpl, tmpl.Destination)
+		r.output = r.writeFile
+		runners = append(runners, r)
+	}
+	return runners
+}
+
+// envRunners returns the runners of the environment templates, and starts
+// sending the environment they render on the env channel.
+func (ts *Server) envRunners(ctx context.Context) []*runner {
+	templates := ts.envTemplates
+	envCh := ts.envCh
+
+	var l sync.Mutex
+	env := make(map[string]string, len(templates))
+	changedCh := make(chan struct{}, 1)
+
+	runners := make([]*runner, 0, len(templates))
+	for _, envTmpl := range templates {
+		name := envTmpl.Name
+		r := ts.newRunner(&config.Template{
+			Contents:   envTmpl.Contents,
+			LeftDelim:  envTmpl.LeftDelim,
+			RightDelim: envTmpl.RightDelim,
+		}, "env:"+name)
+		r.output = func(ctx context.Context, contents []byte) (bool, error) {
+			l.Lock()
+			defer l.Unlock()
+
+			if value, ok := env[name]; ok && value == string(contents) {
+				return false, nil
+			}
+			env[name] = string(contents)
+
+			select {
+			case changedCh <- struct{}{}:
+			default:
+			}
+			return true, nil
+		}
+		runners = append(runners, r)
+	}
+
+	go func() {
+		// Without templates the environment is complete right away
+		if len(templates) == 0 {
+			select {
+			case <-ctx.Done():
+			case envCh <- map[string]string{}:
+			}
+			return
+		}
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-changedCh:
+			}
+
+			l.Lock()
+			if len(env) < len(templates) {
+				l.Unlock()
+				continue
+			}
+			current := make(map[string]string, len(env))
+			for k, v := range env {
+				current[k] = v
+			}
+			l.Unlock()
+
+			select {
+			case <-ctx.Done():
+				return
+			case envCh <- current:
+			}
+		}
+	}()
+
+	return runners
+}
+
+func (ts *Server) newRunner(tmpl *config.Template, name string) *runner {
+	return &runner{
+		config:         tmpl,
+		logger:         ts.logger.With("destination", name),
+		baseClient:     ts.client,
+		staticInterval: ts.staticInterval,
+		tokenCh:        make(chan string, 1),
+		staleCh:        make(chan string, 1),
+		deps:           make(map[string]*dependency),
+		random:         rand.New(rand.NewSource(int64(time.Now().Nanosecond()))),
+	}
+}
+
+// runRunners runs the runners of the templates and of the environment, and
+// passes them every token received on incoming, until ctx is done. On reload,
+// the runners of the templates are replaced.
+func (ts *Server) runRunners(ctx context.Context, incoming chan string, templates []*config.Template, envRunners []*runner) {
+	var wg, fileWg sync.WaitGroup
+	start := func(ctx context.Context, wg *sync.WaitGroup, runners []*runner) {
+		for _, r := range runners {
+			wg.Add(1)
+			go func(r *runner) {
+				defer wg.Done()
+				r.run(ctx)
+			}(r)
+		}
+	}
+	defer wg.Wait()
+	defer fileWg.Wait()
+
+	start(ctx, &wg, envRunners)
+
+	// The runners of the templates are stopped with their own context
+	var fileRunners []*runner
+	var fileCancel context.CancelFunc
+	startFiles := func(templates []*config.Template) {
+		var fileCtx context.Context
+		fileCtx, fileCancel = context.WithCancel(ctx)
+		fileRunners = ts.fileRunners(templates)
+		start(fileCtx, &fileWg, fileRunners)
+	}
+	startFiles(templates)
+	defer func() {
+		fileCancel()
+	}()
+
+	var latestToken string
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case token, ok := <-incoming:
+			if !ok {
+				incoming = nil
+				continue
+			}
+			latestToken = token
+			for _, r := range fileRunners {
+				r.setToken(token)
+			}
+			for _, r := range envRunners {
+				r.setToken(token)
+			}
+
+		case templates := <-ts.reloadCh:
+			ts.logger.Info("templates reloaded")
+			fileCancel()
+			fileWg.Wait()
+
+			startFiles(templates)
+			if latestToken != "" {
+				for _, r := range fileRunners {
+					r.setToken(latestToken)
+				}
+			}
+		}
+	}
+}
+
+// dependency is a secret used by a template
+type dependency struct {
+	secret *api.Secret
+
+	// refetchAt is when a leased secret that can't be renewed is fetched
+	// again, ahead of its expiry
+	refetchAt time.Time
+
+	// stopCh stops the renewal of the secret
+	stopCh chan struct{}
+
+	// used is set when the secret was used by the latest render
+	used bool
+}
+
+// leased returns whether the secret can be reused across renders.
+func (d *dependency) leased() bool {
+	return d.secret.LeaseID != ""
+}
+
+func (d *dependency) stop() {
+	if d.stopCh != nil {
+		close(d.stopCh)
+		d.stopCh = nil
+	}
+}
+
+// runner renders a single template
+type runner struct {
+	config         *config.Template
+	logger         hclog.Logger
+	baseClient     *api.Client
+	client         *api.Client
+	staticInterval time.Duration
+	random         *rand.Rand
+
+	// tokenCh receives the latest auto-auth token
+	tokenCh chan string
+
+	// staleCh receives the keys of leased secrets that could no longer be
+	// renewed
+	staleCh chan string
+
+	// output receives the rendered template, and returns whether it changed
+	// since the last render
+	output func(context.Context, []byte) (bool, error)
+
+	deps map[string]*dependency
+}
+
+// setToken passes the latest token to the runner, replacing any token that
+// wasn't picked up yet
+func (r *runner) setToken(token string) {
+	for {
+		select {
+		case r.tokenCh <- token:
+			return
+		case <-r.tokenCh:
+		}
+	}
+}
+
+func (r *runner) run(ctx context.Context) {
+	defer r.stopDeps()
+
+	var backoff time.Duration
+	var timerCh <-chan time.Time
+	renderNow := false
+
+	for {
+		if renderNow && r.client != nil {
+			renderNow = false
+
+			wait := r.staticInterval
+			if err := r.render(ctx); err != nil {
+				backoff = nextBackoff(backoff)
+				wait = backoff
+				r.logger.Error("error rendering template, backing off", "error", err, "backoff", backoff.String())
+			} else {
+				backoff = 0
+				if next := r.nextRefetch(); next < wait {
+					wait = next
+				}
+			}
+			timerCh = time.After(wait)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+
+		case token := <-r.tokenCh:
+			client, err := r.baseClient.Clone()
+			if err != nil {
+				r.logger.Error("error creating client", "error", err)
+				continue
+			}
+			client.SetToken(token)
+			r.client = client
+
+			// Secrets read with the previous token are not reused
+			r.stopDeps()
+			r.deps = make(map[string]*dependency)
+			backoff = 0
+			renderNow = true
+
+		case key := <-r.staleCh:
+			r.logger.Debug("secret could no longer be renewed, fetching a new one")
+			if dep, ok := r.deps[key]; ok {
+				dep.stop()
+				delete(r.deps, key)
+			}
+			renderNow = true
+
+		case <-timerCh:
+			renderNow = true
+		}
+	}
+}
+
+func nextBackoff(backoff time.Duration) time.Duration {
+	if backoff == 0 {
+		return minBackoff
+	}
+	backoff *= 2
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+	return backoff
+}
+
+// nextRefetch returns the time until the next leased secret that can't be
+// renewed needs to be fetched again.
+func (r *runner) nextRefetch() time.Duration {
+	next := r.staticInterval
+	for _, dep := range r.deps {
+		if dep.refetchAt.IsZero() {
+			continue
+		}
+		if until := time.Until(dep.refetchAt); until < next {
+			next = until
+		}
+	}
+	if next < 0 {
+		next = 0
+	}
+	return next
+}
+
+func (r *runner) stopDeps() {
+	for _, dep := range r.deps {
+		dep.stop()
+	}
+}
+
+// render executes the template and passes the result to the output of the
+// runner.
+func (r *runner) render(ctx context.Context) error {
+	name := "contents"
+	contents := r.config.Contents
+	if contents == "" {
+		tmplBytes, err := ioutil.ReadFile(r.config.Source)
+		if err != nil {
+			return errwrap.Wrapf("error reading template: {{err}}", err)
+		}
+		name = filepath.Base(r.config.Source)
+		contents = string(tmplBytes)
+	}
+
+	for _, dep := range r.deps {
+		dep.used = false
+	}
+
+	tmpl, err := template.New(name).
+		Delims(r.config.LeftDelim, r.config.RightDelim).
+		Funcs(r.funcMap(ctx)).
+		Parse(contents)
+	if err != nil {
+		return errwrap.Wrapf("error parsing template: {{err}}", err)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, nil); err != nil {
+		return errwrap.Wrapf("error executing template: {{err}}", err)
+	}
+
+	// Stop renewing secrets the template no longer uses
+	for key, dep := range r.deps {
+		if !dep.used {
+			dep.stop()
+			delete(r.deps, key)
+		}
+	}
+
+	changed, err := r.output(ctx, buf.Bytes())
+	if err != nil {
+		return err
+	}
+	if !changed {
+		r.logger.Trace("rendered template unchanged")
+		return nil
+	}
+
+	r.logger.Info("rendered template")
+	return nil
+}
+
+// writeFile is the output of file templates. It writes the rendered template
+// to the destination if it changed, then runs the command if one is
+// configured.
+func (r *runner) writeFile(ctx context.Context, contents []byte) (bool, error) {
+	changed, err := r.write(contents)
+	if err != nil || !changed {
+		return changed, err
+	}
+
+	if r.config.Command != "" {
+		if err := r.runCommand(ctx); err != nil {
+			// The file was written, so the render isn't retried; the
+			// command runs again on the next change.
+			r.logger.Error("error running template command", "command", r.config.Command, "error", err)
+		}
+	}
+
+	return true, nil
+}
+
+// write atomically writes contents to the destination, unless it already
+// holds the same contents. It returns whether the destination changed.
+func (r *runner) write(contents []byte) (bool, error) {
+	dest := r.config.Destination
+
+	existing, err := ioutil.ReadFile(dest)
+	if err == nil && bytes.Equal(existing, contents) {
+		if err := os.Chmod(dest, r.config.Perms); err != nil {
+			return false, errwrap.Wrapf("error setting permissions: {{err}}", err)
+		}
+		return false, nil
+	}
+
+	dir := filepath.Dir(dest)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return false, errwrap.Wrapf("error creating destination directory: {{err}}", err)
+	}
+
+	u, err := uuid.GenerateUUID()
+	if err != nil {
+		return false, errwrap.Wrapf("error generating a uuid during template write: {{err}}", err)
+	}
+
+	tmpFile := filepath.Join(dir, fmt.Sprintf(".%s.%s.tmp", filepath.Base(dest), u))
+	if err := ioutil.WriteFile(tmpFile, contents, r.config.Perms); err != nil {
+		os.Remove(tmpFile)
+		return false, errwrap.Wrapf("error writing template: {{err}}", err)
+	}
+
+	// WriteFile doesn't change the permissions of an existing file, and the
+	// umask may have masked them
+	if err := os.Chmod(tmpFile, r.config.Perms); err != nil {
+		os.Remove(tmpFile)
+		return false, errwrap.Wrapf("error setting permissions: {{err}}", err)
+	}
+
+	if err := os.Rename(tmpFile, dest); err != nil {
+		os.Remove(tmpFile)
+		return false, errwrap.Wrapf("error moving template into place: {{err}}", err)
+	}
+
+	return true, nil
+}
+
+func (r *runner) runCommand(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, r.config.CommandTimeout)
+	defer cancel()
+
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "windows":
+		cmd = exec.CommandContext(ctx, "cmd", "/C", r.config.Command)
+	default:
+		cmd = exec.CommandContext(ctx, "/bin/sh", "-c", r.config.Command)
+	}
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	r.logger.Debug("ran template command", "command", r.config.Command)
+	return nil
+}
+
+// funcMap returns the functions available to templates.
+func (r *runner) funcMap(ctx context.Context) template.FuncMap {
+	return template.FuncMap{
+		"secret": func(path string, args ...string) (*api.Secret, error) {
+			return r.secret(ctx, path, args)
+		},
+		"env": os.Getenv,
+		"toJSON": func(v interface{}) (string, error) {
+			out, err := json.Marshal(v)
+			return string(out), err
+		},
+		"toJSONPretty": func(v interface{}) (string, error) {
+			out, err := json.MarshalIndent(v, "", "  ")
+			return string(out), err
+		},
+	}
+}
+
+// secret reads the secret at path, or writes args to it when given as
+// key=value pairs. Leased secrets are reused across renders and renewed
+// until they can't be, while other secrets are read on every render.
+func (r *runner) secret(ctx context.Context, path string, args []string) (*api.Secret, error) {
+	path = strings.Trim(path, "/")
+	key := strings.Join(append([]string{path}, args...), "\x00")
+
+	if dep, ok := r.deps[key]; ok && dep.leased() {
+		if dep.refetchAt.IsZero() || time.Now().Before(dep.refetchAt) {
+			dep.used = true
+			return dep.secret, nil
+		}
+		dep.stop()
+		delete(r.deps, key)
+	}
+
+	var secret *api.Secret
+	var err error
+	if len(args) == 0 {
+		secret, err = r.client.Logical().Read(path)
+	} else {
+		data := make(map[string]interface{}, len(args))
+		for _, arg := range args {
+			parts := strings.SplitN(arg, "=", 2)
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("invalid argument %q to secret %q, expected key=value", arg, path)
+			}
+			data[parts[0]] = parts[1]
+		}
+		secret, err = r.client.Logical().Write(path, data)
+	}
+	if err != nil {
+		return nil, errwrap.Wrapf(fmt.Sprintf("error fetching secret %q: {{err}}", path), err)
+	}
+	if secret == nil {
+		return nil, fmt.Errorf("no secret exists at %q", path)
+	}
+
+	dep := &dependency{
+		secret: secret,
+		used:   true,
+	}
+	if old, ok := r.deps[key]; ok {
+		old.stop()
+	}
+	r.deps[key] = dep
+
+	if dep.leased() {
+		if err := r.renew(ctx, key, dep); err != nil {
+			return nil, err
+		}
+	}
+
+	return secret, nil
+}
+
+// renew keeps the leased secret of dep renewed, and reports it on staleCh once
+// it can no longer be renewed. Secrets that can't be renewed at all are
+// fetched again shortly before they expire.
+func (r *runner) renew(ctx context.Context, key string, dep *dependency) error {
+	leaseDuration := time.Duration(dep.secret.LeaseDuration) * time.Second
+	if !dep.secret.Renewable {
+		// Fetch again between 85% and 95% of the lease duration
+		fraction := 0.85 + r.random.Float64()*0.1
+		dep.refetchAt = time.Now().Add(time.Duration(float64(leaseDuration) * fraction))
+		return nil
+	}
+
+	renewer, err := r.client.NewRenewer(&api.RenewerInput{
+		Secret: dep.secret,
+	})
+	if err != nil {
+		return errwrap.Wrapf("error creating secret renewer: {{err}}", err)
+	}
+
+	stopCh := make(chan struct{})
+	dep.stopCh = stopCh
+
+	go renewer.Renew()
+	go func() {
+		defer renewer.Stop()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-stopCh:
+			return
+		case err := <-renewer.DoneCh():
+			if err != nil && err != api.ErrRenewerNotRenewable {
+				r.logger.Warn("error renewing secret", "error", err)
+			}
+		}
+
+		select {
+		case r.staleCh <- key:
+		case <-ctx.Done():
+		case <-stopCh:
+		}
+	}()
+
+	return nil
+}
@@ -0,0 +1,279 @@
+package template
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	hclog "github.com/hashicorp/go-hclog"
+	kv "github.com/hashicorp/vault-plugin-secrets-kv"
+	"github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/command/agent/config"
+	"github.com/hashicorp/vault/helper/logging"
+	vaulthttp "github.com/hashicorp/vault/http"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/vault"
+)
+
+func testWaitForFile(t *testing.T, path, expected string) {
+	t.Helper()
+
+	var contents []byte
+	var err error
+	for i := 0; i < 100; i++ {
+		contents, err = ioutil.ReadFile(path)
+		if err == nil && string(contents) == expected {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Fatalf("expected %q in %s, got %q (err: %v)", expected, path, contents, err)
+}
+
+func testKVWrite(t *testing.T, client *api.Client, path string, data map[string]interface{}) {
+	t.Helper()
+
+	// Writes to a new KV v2 mount fail until the mount has finished its
+	// setup, so retry for a little while
+	var err error
+	for i := 0; i < 50; i++ {
+		if _, err = client.Logical().Write(path, data); err == nil {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Fatal(err)
+}
+
+func TestServer_Render(t *testing.T) {
+	coreConfig := &vault.CoreConfig{
+		Logger: logging.NewVaultLogger(hclog.Trace),
+		LogicalBackends: map[string]logical.Factory{
+			"kv": kv.Factory,
+		},
+	}
+	cluster := vault.NewTestCluster(t, coreConfig, &vault.TestClusterOptions{
+		HandlerFunc: vaulthttp.Handler,
+	})
+	cluster.Start()
+	defer cluster.Cleanup()
+
+	vault.TestWaitActive(t, cluster.Cores[0].Core)
+	client := cluster.Cores[0].Client
+
+	if err := client.Sys().Mount("kv", &api.MountInput{
+		Type:    "kv",
+		Options: map[string]string{"version": "2"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	testKVWrite(t, client, "kv/data/app", map[string]interface{}{
+		"data": map[string]interface{}{
+			"password": "first",
+		},
+	})
+
+	dir, err := ioutil.TempDir("", "agent-template")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	source := filepath.Join(dir, "app.ctmpl")
+	if err := ioutil.WriteFile(source, []byte(`{{ with secret "kv/data/app" }}password={{ .Data.data.password }}{{ end }}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	missingSource := filepath.Join(dir, "missing.ctmpl")
+	if err := ioutil.WriteFile(missingSource, []byte(`<<< (secret "kv/data/missing").Data.data.value >>>`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	dest := filepath.Join(dir, "out", "app.conf")
+	missingDest := filepath.Join(dir, "out", "missing.conf")
+	marker := filepath.Join(dir, "marker")
+
+	templates := []*config.Template{
+		&config.Template{
+			Source:         source,
+			Destination:    dest,
+			Perms:          0600,
+			Command:        "echo rendered >> " + marker,
+			CommandTimeout: 10 * time.Second,
+		},
+		&config.Template{
+			Source:         missingSource,
+			Destination:    missingDest,
+			Perms:          0644,
+			CommandTimeout: 10 * time.Second,
+			LeftDelim:      "<<<",
+			RightDelim:     ">>>",
+		},
+	}
+
+	ctx, cancelFunc := context.WithCancel(context.Background())
+
+	ts := NewServer(&ServerConfig{
+		Logger:                     logging.NewVaultLogger(hclog.Trace),
+		Client:                     client,
+		StaticSecretRenderInterval: time.Second,
+	})
+	tokenCh := make(chan string)
+	go ts.Run(ctx, tokenCh, templates)
+	defer func() {
+		cancelFunc()
+		<-ts.DoneCh
+	}()
+
+	tokenCh <- client.Token()
+
+	// The template is rendered with the configured permissions and the
+	// command runs
+	testWaitForFile(t, dest, "password=first")
+	fi, err := os.Stat(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Fatalf("bad permissions: %v", fi.Mode().Perm())
+	}
+	testWaitForFile(t, marker, "rendered\n")
+
+	// A new version of the secret is picked up on the next render
+	testKVWrite(t, client, "kv/data/app", map[string]interface{}{
+		"data": map[string]interface{}{
+			"password": "second",
+		},
+	})
+	testWaitForFile(t, dest, "password=second")
+	testWaitForFile(t, marker, "rendered\nrendered\n")
+
+	// The template using a missing secret is retried with backoff until the
+	// secret exists
+	if _, err := os.Stat(missingDest); !os.IsNotExist(err) {
+		t.Fatalf("expected no file to be rendered, got: %v", err)
+	}
+	testKVWrite(t, client, "kv/data/missing", map[string]interface{}{
+		"data": map[string]interface{}{
+			"value": "found",
+		},
+	})
+	testWaitForFile(t, missingDest, "found")
+}
+
+func TestServer_EnvTemplates(t *testing.T) {
+	os.Setenv("TEST_ENV_TEMPLATE", "from-env")
+	defer os.Unsetenv("TEST_ENV_TEMPLATE")
+
+	client, err := api.NewClient(api.DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	envCh := make(chan map[string]string)
+	ts := NewServer(&ServerConfig{
+		Logger: logging.NewVaultLogger(hclog.Trace),
+		Client: client,
+		EnvTemplates: []*config.EnvTemplate{
+			&config.EnvTemplate{
+				Name:     "FIRST",
+				Contents: `{{ env "TEST_ENV_TEMPLATE" }}`,
+			},
+			&config.EnvTemplate{
+				Name:       "SECOND",
+				Contents:   `[[ "static" ]]`,
+				LeftDelim:  "[[",
+				RightDelim: "]]",
+			},
+		},
+		EnvCh: envCh,
+	})
+
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	tokenCh := make(chan string)
+	go ts.Run(ctx, tokenCh, nil)
+	defer func() {
+		cancelFunc()
+		<-ts.DoneCh
+	}()
+
+	tokenCh <- "token"
+
+	select {
+	case env := <-envCh:
+		if env["FIRST"] != "from-env" || env["SECOND"] != "static" || len(env) != 2 {
+			t.Fatalf("bad: %#v", env)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the environment")
+	}
+}
+
+func TestServer_Reload(t *testing.T) {
+	coreConfig := &vault.CoreConfig{
+		Logger: logging.NewVaultLogger(hclog.Trace),
+		LogicalBackends: map[string]logical.Factory{
+			"kv": kv.Factory,
+		},
+	}
+	cluster := vault.NewTestCluster(t, coreConfig, &vault.TestClusterOptions{
+		HandlerFunc: vaulthttp.Handler,
+	})
+	cluster.Start()
+	defer cluster.Cleanup()
+
+	vault.TestWaitActive(t, cluster.Cores[0].Core)
+	client := cluster.Cores[0].Client
+
+	if err := client.Sys().Mount("kv", &api.MountInput{
+		Type: "kv",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	testKVWrite(t, client, "kv/app", map[string]interface{}{
+		"password": "secret",
+	})
+
+	dir, err := ioutil.TempDir("", "agent-template")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	first := filepath.Join(dir, "first.conf")
+	second := filepath.Join(dir, "second.conf")
+
+	ctx, cancelFunc := context.WithCancel(context.Background())
+
+	ts := NewServer(&ServerConfig{
+		Logger: logging.NewVaultLogger(hclog.Trace),
+		Client: client,
+	})
+	tokenCh := make(chan string)
+	go ts.Run(ctx, tokenCh, []*config.Template{
+		&config.Template{
+			Contents:    `{{ with secret "kv/app" }}first={{ .Data.password }}{{ end }}`,
+			Destination: first,
+			Perms:       0600,
+		},
+	})
+	defer func() {
+		cancelFunc()
+		<-ts.DoneCh
+	}()
+
+	tokenCh <- client.Token()
+	testWaitForFile(t, first, "first=secret")
+
+	// The new templates are rendered with the current token
+	ts.Reload([]*config.Template{
+		&config.Template{
+			Contents:    `{{ with secret "kv/app" }}second={{ .Data.password }}{{ end }}`,
+			Destination: second,
+			Perms:       0600,
+		},
+	})
+	testWaitForFile(t, second, "second=secret")
+}
@@ -0,0 +1,127 @@
+package agent
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/command/agent/auth"
+	agenttokenfile "github.com/hashicorp/vault/command/agent/auth/tokenfile"
+	"github.com/hashicorp/vault/helper/logging"
+	vaulthttp "github.com/hashicorp/vault/http"
+	"github.com/hashicorp/vault/vault"
+)
+
+func TestTokenFileEndToEnd(t *testing.T) {
+	logger := logging.NewVaultLogger(hclog.Trace)
+	cluster := vault.NewTestCluster(t, &vault.CoreConfig{
+		Logger: logger,
+	}, &vault.TestClusterOptions{
+		HandlerFunc: vaulthttp.Handler,
+	})
+	cluster.Start()
+	defer cluster.Cleanup()
+
+	vault.TestWaitActive(t, cluster.Cores[0].Core)
+	client := cluster.Cores[0].Client
+
+	newToken := func(opts map[string]interface{}) string {
+		secret, err := client.Logical().Write("auth/token/create", opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return secret.Auth.ClientToken
+	}
+
+	dir, err := ioutil.TempDir("", "auth.tokenfile.test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	in := filepath.Join(dir, "in")
+	out := filepath.Join(dir, "out")
+
+	// A periodic token, which the agent must keep renewed
+	origToken := newToken(map[string]interface{}{
+		"period": "3s",
+	})
+	if err := ioutil.WriteFile(in, []byte(origToken+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	timer := time.AfterFunc(30*time.Second, func() {
+		cancelFunc()
+	})
+	defer timer.Stop()
+
+	if _, err := agenttokenfile.NewTokenFileAuthMethod(&auth.AuthConfig{
+		Logger:    logger.Named("auth.token_file"),
+		MountPath: "auth/token",
+		WrapTTL:   10 * time.Second,
+		Config: map[string]interface{}{
+			"token_file_path": in,
+		},
+	}); err == nil {
+		t.Fatal("expected error with a wrap TTL")
+	}
+
+	am, err := agenttokenfile.NewTokenFileAuthMethod(&auth.AuthConfig{
+		Logger:    logger.Named("auth.token_file"),
+		MountPath: "auth/token",
+		Config: map[string]interface{}{
+			"token_file_path": in,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ah := auth.NewAuthHandler(&auth.AuthHandlerConfig{
+		Logger: logger.Named("auth.handler"),
+		Client: client,
+	})
+	go ah.Run(ctx, am)
+	defer func() {
+		<-ah.DoneCh
+	}()
+
+	ss := testTokenFileSinkServer(t, ctx, logger, client, ah, out)
+	defer func() {
+		<-ss.DoneCh
+	}()
+
+	// This has to be after the other defers so it happens first
+	defer cancelFunc()
+
+	if token := testWaitForToken(t, out); token != origToken {
+		t.Fatalf("expected %q to be written, got %q", origToken, token)
+	}
+
+	// Period of 3 seconds, so the token should still be alive after it
+	// through renewals
+	time.Sleep(5 * time.Second)
+	testLookupToken(t, client, origToken)
+
+	// A new, non-renewable token written to the file is picked up
+	nextToken := newToken(map[string]interface{}{
+		"renewable": false,
+		"ttl":       "1h",
+	})
+	if err := ioutil.WriteFile(in, []byte(nextToken), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if token := testWaitForToken(t, out); token != nextToken {
+		t.Fatalf("expected %q to be written, got %q", nextToken, token)
+	}
+
+	// The token is kept while it's valid rather than looked up again
+	time.Sleep(2 * time.Second)
+	if _, err := os.Lstat(out); !os.IsNotExist(err) {
+		t.Fatalf("expected no new token to be written, got %v", err)
+	}
+}
@@ -1,15 +1,23 @@
 package command
 
 import (
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net"
+	"net/http"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	hclog "github.com/hashicorp/go-hclog"
 	vaultjwt "github.com/hashicorp/vault-plugin-auth-jwt"
 	"github.com/hashicorp/vault/api"
 	"github.com/hashicorp/vault/command/agent"
+	"github.com/hashicorp/vault/command/agent/status"
+	"github.com/hashicorp/vault/helper/consts"
 	"github.com/hashicorp/vault/helper/logging"
 	vaulthttp "github.com/hashicorp/vault/http"
 	"github.com/hashicorp/vault/logical"
@@ -184,3 +192,166 @@ auto_auth {
 		t.Fatal("sink 1/2 values don't match")
 	}
 }
+
+func TestAgent_ReloadAndHealth(t *testing.T) {
+	logger := logging.NewVaultLogger(hclog.Trace)
+	cluster := vault.NewTestCluster(t, &vault.CoreConfig{
+		Logger: logger,
+	}, &vault.TestClusterOptions{
+		HandlerFunc: vaulthttp.Handler,
+	})
+	cluster.Start()
+	defer cluster.Cleanup()
+
+	vault.TestWaitActive(t, cluster.Cores[0].Core)
+	client := cluster.Cores[0].Client
+
+	secret, err := client.Logical().Write("auth/token/create", map[string]interface{}{
+		"period": "1h",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := secret.Auth.ClientToken
+
+	dir, err := ioutil.TempDir("", "agent.reload.test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	in := filepath.Join(dir, "in")
+	sink1 := filepath.Join(dir, "sink1")
+	sink2 := filepath.Join(dir, "sink2")
+	conf := filepath.Join(dir, "agent.hcl")
+
+	if err := ioutil.WriteFile(in, []byte(token), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Find a free port for the listener
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	config := `
+auto_auth {
+        method {
+                type = "token_file"
+                config = {
+                        token_file_path = "%s"
+                }
+        }
+
+        sink "file" {
+                config = {
+                        path = "%s"
+                }
+        }
+}
+
+listener "tcp" {
+        address = "%s"
+        tls_disable = true
+}
+`
+	writeConfig := func(in, sink string) {
+		if err := ioutil.WriteFile(conf, []byte(fmt.Sprintf(config, in, sink, addr)), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitForToken := func(path, token string) {
+		t.Helper()
+		deadline := time.Now().Add(10 * time.Second)
+		for time.Now().Before(deadline) {
+			if b, err := ioutil.ReadFile(path); err == nil && string(b) == token {
+				return
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+		t.Fatalf("token not written to %s", path)
+	}
+	get := func(path string) (*http.Response, []byte) {
+		t.Helper()
+		resp, err := http.Get("http://" + addr + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp, body
+	}
+
+	writeConfig(in, sink1)
+
+	ui, cmd := testAgentCommand(t, logger)
+	cmd.client = client
+	cmd.SighupCh = make(chan struct{})
+	cmd.startedCh = make(chan struct{}, 1)
+
+	codeCh := make(chan int)
+	go func() {
+		codeCh <- cmd.Run([]string{"-config", conf})
+	}()
+	select {
+	case <-cmd.startedCh:
+	case code := <-codeCh:
+		t.Fatalf("agent exited with %d: %s", code, ui.ErrorWriter.String())
+	}
+
+	waitForToken(sink1, token)
+
+	resp, body := get(consts.AgentPathHealth)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("bad status %d: %s", resp.StatusCode, body)
+	}
+	var report status.Report
+	if err := json.Unmarshal(body, &report); err != nil {
+		t.Fatal(err)
+	}
+	if !report.Healthy || report.AutoAuth.State != status.AuthStateAuthenticated || report.AutoAuth.TokenTTL <= 0 {
+		t.Fatalf("bad: %s", body)
+	}
+	if sinkStatus, ok := report.Sinks["file:"+sink1]; !ok || sinkStatus.LastWrite == nil || sinkStatus.WriteFailures != 0 {
+		t.Fatalf("bad: %s", body)
+	}
+
+	resp, body = get(consts.AgentPathMetrics + "?format=prometheus")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("bad status %d: %s", resp.StatusCode, body)
+	}
+	if !strings.Contains(string(body), "vault_agent_auth_token_ttl") {
+		t.Fatalf("bad: %s", body)
+	}
+
+	// Reload with another sink, which gets the current token
+	writeConfig(in, sink2)
+	cmd.SighupCh <- struct{}{}
+	waitForToken(sink2, token)
+
+	// Reload with another token file, whose token replaces the current one
+	secret, err = client.Logical().Write("auth/token/create", map[string]interface{}{
+		"period": "1h",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token2 := secret.Auth.ClientToken
+	in2 := filepath.Join(dir, "in2")
+	if err := ioutil.WriteFile(in2, []byte(token2), 0600); err != nil {
+		t.Fatal(err)
+	}
+	writeConfig(in2, sink2)
+	cmd.SighupCh <- struct{}{}
+	waitForToken(sink2, token2)
+
+	close(cmd.ShutdownCh)
+	if code := <-codeCh; code != 0 {
+		t.Fatalf("agent exited with %d: %s", code, ui.ErrorWriter.String())
+	}
+}
@@ -143,6 +143,12 @@ func (h *Seal) GoString() string {
 	return fmt.Sprintf("*%#v", *h)
 }
 
+const (
+	// PrometheusDefaultRetentionTime is the retention time of the Prometheus
+	// sink when prometheus_retention_time is not set.
+	PrometheusDefaultRetentionTime = 24 * time.Hour
+)
+
 // Telemetry is the telemetry configuration for the server
 type Telemetry struct {
 	StatsiteAddr string `hcl:"statsite_address"`
@@ -150,6 +156,12 @@ type Telemetry struct {
 
 	DisableHostname bool `hcl:"disable_hostname"`
 
+	// Prometheus:
+	// PrometheusRetentionTime is the retention time for prometheus metrics if greater than 0.
+	// Default: 24h
+	PrometheusRetentionTime    time.Duration `hcl:"-"`
+	PrometheusRetentionTimeRaw interface{}   `hcl:"prometheus_retention_time"`
+
 	// Circonus: see https://github.com/circonus-labs/circonus-gometrics
 	// for more details on the various configuration options.
 	// Valid configuration combinations:
@@ -800,5 +812,15 @@ func parseTelemetry(result *Config, list *ast.ObjectList) error {
 	if err := hcl.DecodeObject(&result.Telemetry, item.Val); err != nil {
 		return multierror.Prefix(err, "telemetry:")
 	}
+
+	if result.Telemetry.PrometheusRetentionTimeRaw != nil {
+		var err error
+		if result.Telemetry.PrometheusRetentionTime, err = parseutil.ParseDurationSecond(result.Telemetry.PrometheusRetentionTimeRaw); err != nil {
+			return multierror.Prefix(err, "telemetry:")
+		}
+	} else {
+		result.Telemetry.PrometheusRetentionTime = PrometheusDefaultRetentionTime
+	}
+
 	return nil
 }
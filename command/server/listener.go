@@ -24,7 +24,8 @@ type ListenerFactory func(map[string]interface{}, io.Writer, cli.Ui) (net.Listen
 
 // BuiltinListeners is the list of built-in listener types.
 var BuiltinListeners = map[string]ListenerFactory{
-	"tcp": tcpListenerFactory,
+	"tcp":  tcpListenerFactory,
+	"unix": unixListenerFactory,
 }
 
 // NewListener creates a new listener of the given type with the given
@@ -35,7 +36,70 @@ func NewListener(t string, config map[string]interface{}, logger io.Writer, ui c
 		return nil, nil, nil, fmt.Errorf("unknown listener type: %q", t)
 	}
 
-	return f(config, logger, ui)
+	telemetry, err := parseListenerTelemetry(config)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	ln, props, reloadFunc, err := f(config, logger, ui)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	if telemetry.UnauthenticatedMetricsAccess {
+		props["unauthenticated_metrics_access"] = "enabled"
+	}
+
+	return ln, props, reloadFunc, nil
+}
+
+// ListenerTelemetry is the per-listener telemetry configuration, set with a
+// telemetry block inside the listener stanza.
+type ListenerTelemetry struct {
+	UnauthenticatedMetricsAccess bool
+}
+
+// parseListenerTelemetry parses the telemetry block of a listener and
+// replaces it in config with a *ListenerTelemetry.
+func parseListenerTelemetry(config map[string]interface{}) (*ListenerTelemetry, error) {
+	telemetry := &ListenerTelemetry{}
+
+	raw, ok := config["telemetry"]
+	if !ok {
+		config["telemetry"] = telemetry
+		return telemetry, nil
+	}
+
+	// HCL decodes a block into a list of maps, JSON into a single map
+	var blocks []map[string]interface{}
+	switch raw := raw.(type) {
+	case *ListenerTelemetry:
+		return raw, nil
+	case map[string]interface{}:
+		blocks = []map[string]interface{}{raw}
+	case []map[string]interface{}:
+		blocks = raw
+	default:
+		return nil, fmt.Errorf("invalid \"telemetry\" block in listener configuration")
+	}
+
+	for _, block := range blocks {
+		for k, v := range block {
+			switch k {
+			case "unauthenticated_metrics_access":
+				val, err := parseutil.ParseBool(v)
+				if err != nil {
+					return nil, errwrap.Wrapf("error parsing \"unauthenticated_metrics_access\": {{err}}", err)
+				}
+				telemetry.UnauthenticatedMetricsAccess = val
+			default:
+				return nil, fmt.Errorf("invalid key %q in listener telemetry block", k)
+			}
+		}
+	}
+
+	config["telemetry"] = telemetry
+	return telemetry, nil
 }
 
 func listenerWrapProxy(ln net.Listener, config map[string]interface{}) (net.Listener, error) {
@@ -62,3 +62,34 @@ func testListenerImpl(t *testing.T, ln net.Listener, connFn testListenerConnFn,
 		t.Fatalf("bad: %v", buf.String())
 	}
 }
+
+func TestParseListenerTelemetry(t *testing.T) {
+	config := map[string]interface{}{
+		"telemetry": []map[string]interface{}{
+			{"unauthenticated_metrics_access": "true"},
+		},
+	}
+	telemetry, err := parseListenerTelemetry(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !telemetry.UnauthenticatedMetricsAccess || config["telemetry"] != telemetry {
+		t.Fatalf("bad: %#v", config)
+	}
+
+	config = map[string]interface{}{}
+	telemetry, err = parseListenerTelemetry(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if telemetry.UnauthenticatedMetricsAccess {
+		t.Fatal("expected unauthenticated metrics access to be disabled by default")
+	}
+
+	config = map[string]interface{}{
+		"telemetry": map[string]interface{}{"nope": true},
+	}
+	if _, err := parseListenerTelemetry(config); err == nil {
+		t.Fatal("expected error")
+	}
+}
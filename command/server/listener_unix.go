@@ -0,0 +1,118 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/helper/peercred"
+	"github.com/hashicorp/vault/helper/reload"
+	"github.com/mitchellh/cli"
+)
+
+func unixListenerFactory(config map[string]interface{}, _ io.Writer, ui cli.Ui) (net.Listener, map[string]string, reload.ReloadFunc, error) {
+	addrRaw, ok := config["address"]
+	if !ok {
+		return nil, nil, nil, fmt.Errorf("\"address\" is required for unix listeners")
+	}
+	addr, ok := addrRaw.(string)
+	if !ok || addr == "" {
+		return nil, nil, nil, fmt.Errorf("invalid \"address\" for unix listener")
+	}
+
+	uid, gid := -1, -1
+	if userRaw, ok := config["socket_user"]; ok {
+		var err error
+		uid, err = lookupUID(fmt.Sprintf("%v", userRaw))
+		if err != nil {
+			return nil, nil, nil, errwrap.Wrapf("error parsing \"socket_user\": {{err}}", err)
+		}
+	}
+	if groupRaw, ok := config["socket_group"]; ok {
+		var err error
+		gid, err = lookupGID(fmt.Sprintf("%v", groupRaw))
+		if err != nil {
+			return nil, nil, nil, errwrap.Wrapf("error parsing \"socket_group\": {{err}}", err)
+		}
+	}
+
+	var mode os.FileMode
+	if modeRaw, ok := config["socket_mode"]; ok {
+		m, err := strconv.ParseUint(fmt.Sprintf("%v", modeRaw), 8, 32)
+		if err != nil {
+			return nil, nil, nil, errwrap.Wrapf("error parsing \"socket_mode\": {{err}}", err)
+		}
+		mode = os.FileMode(m)
+	}
+
+	// Remove a socket left behind by a previous run, but refuse to replace
+	// anything else
+	if fi, err := os.Lstat(addr); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, nil, nil, fmt.Errorf("unix listener address %q exists and is not a socket", addr)
+		}
+		if err := os.Remove(addr); err != nil {
+			return nil, nil, nil, errwrap.Wrapf("error removing existing socket: {{err}}", err)
+		}
+	}
+
+	ln, err := net.ListenUnix("unix", &net.UnixAddr{Name: addr, Net: "unix"})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	props := map[string]string{"addr": addr, "tls": "disabled"}
+
+	if uid != -1 || gid != -1 {
+		if err := os.Chown(addr, uid, gid); err != nil {
+			ln.Close()
+			return nil, nil, nil, errwrap.Wrapf("error setting socket ownership: {{err}}", err)
+		}
+		if uid != -1 {
+			props["socket_user"] = strconv.Itoa(uid)
+		}
+		if gid != -1 {
+			props["socket_group"] = strconv.Itoa(gid)
+		}
+	}
+
+	if mode != 0 {
+		if err := os.Chmod(addr, mode); err != nil {
+			ln.Close()
+			return nil, nil, nil, errwrap.Wrapf("error setting socket mode: {{err}}", err)
+		}
+		props["socket_mode"] = fmt.Sprintf("%04o", mode)
+	}
+
+	return &peercred.Listener{UnixListener: ln}, props, nil, nil
+}
+
+// lookupUID returns the uid of the given user name or numeric id.
+func lookupUID(name string) (int, error) {
+	if id, err := strconv.Atoi(name); err == nil {
+		return id, nil
+	}
+
+	u, err := user.Lookup(name)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(u.Uid)
+}
+
+// lookupGID returns the gid of the given group name or numeric id.
+func lookupGID(name string) (int, error) {
+	if id, err := strconv.Atoi(name); err == nil {
+		return id, nil
+	}
+
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(g.Gid)
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/hashicorp/vault/helper/peercred"
+	"github.com/mitchellh/cli"
+)
+
+func TestUnixListener(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vault-test-unix-listener")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	addr := filepath.Join(dir, "vault.sock")
+
+	// A stale socket from a previous run is replaced
+	stale, err := net.Listen("unix", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	ln, props, _, err := unixListenerFactory(map[string]interface{}{
+		"address":      addr,
+		"socket_mode":  "0600",
+		"socket_group": os.Getgid(),
+	}, nil, cli.NewMockUi())
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if props["socket_mode"] != "0600" {
+		t.Fatalf("bad props: %#v", props)
+	}
+
+	fi, err := os.Stat(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Fatalf("bad mode: %s", fi.Mode())
+	}
+
+	connFn := func(lnReal net.Listener) (net.Conn, error) {
+		return net.Dial("unix", addr)
+	}
+	testListenerImpl(t, ln, connFn, "")
+}
+
+func TestUnixListener_peerCreds(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("peer credentials are only supported on linux")
+	}
+
+	dir, err := ioutil.TempDir("", "vault-test-unix-listener")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	addr := filepath.Join(dir, "vault.sock")
+
+	ln, _, _, err := unixListenerFactory(map[string]interface{}{
+		"address": addr,
+	}, nil, cli.NewMockUi())
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer ln.Close()
+
+	client, err := net.Dial("unix", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	server, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	creds, ok := peercred.ParseAddr(server.RemoteAddr().String())
+	if !ok {
+		t.Fatalf("no credentials in remote address %q", server.RemoteAddr())
+	}
+	if creds.UID != uint32(os.Getuid()) || creds.GID != uint32(os.Getgid()) || creds.PID != int32(os.Getpid()) {
+		t.Fatalf("bad credentials: %#v", creds)
+	}
+}
+
+func TestUnixListener_notSocket(t *testing.T) {
+	f, err := ioutil.TempFile("", "vault-test-unix-listener")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	_, _, _, err = unixListenerFactory(map[string]interface{}{
+		"address": f.Name(),
+	}, nil, cli.NewMockUi())
+	if err == nil {
+		t.Fatal("expected error")
+	}
+}
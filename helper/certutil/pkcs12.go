@@ -0,0 +1,44 @@
+package certutil
+
+import (
+	"crypto/x509"
+	"fmt"
+
+	"github.com/hashicorp/vault/helper/errutil"
+	gopkcs12 "software.sslmate.com/src/go-pkcs12"
+)
+
+// ToPKCS12 encodes the certificate, its CA chain and its private key as a
+// PKCS#12 archive protected by the password. The archive is encrypted with
+// AES-256-CBC, with a key derived from the password with PBKDF2, and its MAC
+// uses HMAC-SHA256. These are supported by OpenSSL 1.1.1 and later, and by
+// current operating systems.
+func (p *ParsedCertBundle) ToPKCS12(password string) ([]byte, error) {
+	return p.toPKCS12(gopkcs12.Modern, password)
+}
+
+// ToLegacyPKCS12 is ToPKCS12 using the password-based algorithms supported by
+// nearly every consumer, including older ones: pbeWithSHAAnd3-KeyTripleDES-CBC
+// encryption and an HMAC-SHA1 MAC. These are weak, so the archive should not
+// be stored or sent where others may read it.
+func (p *ParsedCertBundle) ToLegacyPKCS12(password string) ([]byte, error) {
+	return p.toPKCS12(gopkcs12.LegacyDES, password)
+}
+
+func (p *ParsedCertBundle) toPKCS12(encoder *gopkcs12.Encoder, password string) ([]byte, error) {
+	if p.Certificate == nil || p.PrivateKey == nil {
+		return nil, errutil.UserError{Err: "a certificate and private key are required to create a PKCS#12 archive"}
+	}
+
+	var caCerts []*x509.Certificate
+	for _, certBlock := range p.GetCertificatePath()[1:] {
+		caCerts = append(caCerts, certBlock.Certificate)
+	}
+
+	pfx, err := encoder.Encode(p.PrivateKey, p.Certificate, caCerts, password)
+	if err != nil {
+		return nil, errutil.InternalError{Err: fmt.Sprintf("error encoding PKCS#12 archive: %v", err)}
+	}
+
+	return pfx, nil
+}
@@ -12,6 +12,7 @@ import (
 	"bytes"
 	"crypto"
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/rsa"
 	"crypto/tls"
 	"crypto/x509"
@@ -29,8 +30,8 @@ type Secret struct {
 	Data map[string]interface{} `json:"data"`
 }
 
-// PrivateKeyType holds a string representation of the type of private key (ec
-// or rsa) referenced in CertBundle and ParsedCertBundle. This uses colloquial
+// PrivateKeyType holds a string representation of the type of private key (ec,
+// rsa or ed25519) referenced in CertBundle and ParsedCertBundle. This uses colloquial
 // names rather than official names, to eliminate confusion
 type PrivateKeyType string
 
@@ -39,6 +40,7 @@ const (
 	UnknownPrivateKey PrivateKeyType = ""
 	RSAPrivateKey     PrivateKeyType = "rsa"
 	ECPrivateKey      PrivateKeyType = "ec"
+	Ed25519PrivateKey PrivateKeyType = "ed25519"
 )
 
 // TLSUsage controls whether the intended usage of a *tls.Config
@@ -168,6 +170,8 @@ func (c *CertBundle) ToParsedCertBundle() (*ParsedCertBundle, error) {
 				c.PrivateKeyType = ECPrivateKey
 			case RSAPrivateKey:
 				c.PrivateKeyType = RSAPrivateKey
+			case Ed25519PrivateKey:
+				c.PrivateKeyType = Ed25519PrivateKey
 			}
 		default:
 			return nil, errutil.UserError{Err: fmt.Sprintf("Unsupported key block type: %s", pemBlock.Type)}
@@ -273,6 +277,8 @@ func (p *ParsedCertBundle) ToCertBundle() (*CertBundle, error) {
 				block.Type = string(ECBlock)
 			case RSAPrivateKey:
 				block.Type = string(PKCS1Block)
+			case Ed25519PrivateKey:
+				block.Type = string(PKCS8Block)
 			}
 		}
 
@@ -361,7 +367,7 @@ func (p *ParsedCertBundle) getSigner() (crypto.Signer, error) {
 	case PKCS8Block:
 		if k, err := x509.ParsePKCS8PrivateKey(p.PrivateKeyBytes); err == nil {
 			switch k := k.(type) {
-			case *rsa.PrivateKey, *ecdsa.PrivateKey:
+			case *rsa.PrivateKey, *ecdsa.PrivateKey, ed25519.PrivateKey:
 				return k.(crypto.Signer), nil
 			default:
 				return nil, errutil.UserError{Err: "Found unknown private key type in pkcs#8 wrapping"}
@@ -369,7 +375,7 @@ func (p *ParsedCertBundle) getSigner() (crypto.Signer, error) {
 		}
 		return nil, errutil.UserError{Err: fmt.Sprintf("Failed to parse pkcs#8 key: %v", err)}
 	default:
-		return nil, errutil.UserError{Err: "Unable to determine type of private key; only RSA and EC are supported"}
+		return nil, errutil.UserError{Err: "Unable to determine type of private key; only RSA, EC and Ed25519 are supported"}
 	}
 	return signer, nil
 }
@@ -392,6 +398,8 @@ func getPKCS8Type(bs []byte) (PrivateKeyType, error) {
 		return ECPrivateKey, nil
 	case *rsa.PrivateKey:
 		return RSAPrivateKey, nil
+	case ed25519.PrivateKey:
+		return Ed25519PrivateKey, nil
 	default:
 		return UnknownPrivateKey, errutil.UserError{Err: "Found unknown private key type in pkcs#8 wrapping"}
 	}
@@ -416,6 +424,13 @@ func (c *CSRBundle) ToParsedCSRBundle() (*ParsedCSRBundle, error) {
 			result.PrivateKeyType = ECPrivateKey
 		case PKCS1Block:
 			result.PrivateKeyType = RSAPrivateKey
+		case PKCS8Block:
+			t, err := getPKCS8Type(pemBlock.Bytes)
+			if err != nil {
+				return nil, errutil.UserError{Err: fmt.Sprintf("Error getting key type from pkcs#8: %v", err)}
+			}
+			result.PrivateKeyType = t
+			c.PrivateKeyType = t
 		default:
 			// Try to figure it out and correct
 			if _, err := x509.ParseECPrivateKey(pemBlock.Bytes); err == nil {
@@ -472,6 +487,9 @@ func (p *ParsedCSRBundle) ToCSRBundle() (*CSRBundle, error) {
 		case ECPrivateKey:
 			result.PrivateKeyType = "ec"
 			block.Type = "EC PRIVATE KEY"
+		case Ed25519PrivateKey:
+			result.PrivateKeyType = "ed25519"
+			block.Type = "PRIVATE KEY"
 		default:
 			return nil, errutil.InternalError{Err: "Could not determine private key type when creating block"}
 		}
@@ -506,8 +524,19 @@ func (p *ParsedCSRBundle) getSigner() (crypto.Signer, error) {
 			return nil, errutil.UserError{Err: fmt.Sprintf("Unable to parse CA's private RSA key: %s", err)}
 		}
 
+	case Ed25519PrivateKey:
+		k, err := x509.ParsePKCS8PrivateKey(p.PrivateKeyBytes)
+		if err != nil {
+			return nil, errutil.UserError{Err: fmt.Sprintf("Unable to parse CA's private Ed25519 key: %s", err)}
+		}
+		key, ok := k.(ed25519.PrivateKey)
+		if !ok {
+			return nil, errutil.UserError{Err: "Found unknown private key type in pkcs#8 wrapping"}
+		}
+		signer = key
+
 	default:
-		return nil, errutil.UserError{Err: "Unable to determine type of private key; only RSA and EC are supported"}
+		return nil, errutil.UserError{Err: "Unable to determine type of private key; only RSA, EC and Ed25519 are supported"}
 	}
 	return signer, nil
 }
@@ -0,0 +1,12 @@
+package consts
+
+// AgentPathCacheClear is the path that the agent will use as its cache-clear
+// endpoint.
+const AgentPathCacheClear = "/agent/v1/cache-clear"
+
+// AgentPathHealth is the path of the agent health endpoint, which reports the
+// auto-auth and sink status.
+const AgentPathHealth = "/agent/v1/health"
+
+// AgentPathMetrics is the path of the agent metrics endpoint.
+const AgentPathMetrics = "/agent/v1/metrics"
@@ -0,0 +1,156 @@
+package metricsutil
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	metrics "github.com/armon/go-metrics"
+	"github.com/hashicorp/vault/helper/namespace"
+	"github.com/hashicorp/vault/logical"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+const (
+	OpenMetricsMIMEType = "application/openmetrics-text"
+
+	PrometheusSchemaMIMEType = "prometheus/telemetry"
+
+	// ErrorContentType is the content type returned by an error response.
+	ErrorContentType = "text/plain"
+)
+
+const (
+	PrometheusMetricFormat = "prometheus"
+)
+
+// MetricsHelper exposes the metrics collected by the in-memory sink, and the
+// Prometheus sink if enabled, in the format requested by the caller.
+type MetricsHelper struct {
+	inMemSink         *metrics.InmemSink
+	PrometheusEnabled bool
+}
+
+func NewMetricsHelper(inMem *metrics.InmemSink, enablePrometheus bool) *MetricsHelper {
+	return &MetricsHelper{
+		inMemSink:         inMem,
+		PrometheusEnabled: enablePrometheus,
+	}
+}
+
+// MountLabels returns the labels identifying a mount within ns. The mount
+// label keeps the dashed form previously baked into metric names, and the
+// namespace label is left out for the root namespace, so that push sinks,
+// which append label values to the name, keep reporting the same metrics.
+func MountLabels(ns *namespace.Namespace, mount string) []metrics.Label {
+	if ns != nil {
+		mount = strings.TrimPrefix(mount, ns.Path)
+	}
+
+	labels := []metrics.Label{
+		{Name: "mount", Value: strings.Replace(mount, "/", "-", -1)},
+	}
+	if ns != nil && ns.ID != namespace.RootNamespaceID {
+		labels = append(labels, metrics.Label{Name: "namespace", Value: ns.Path})
+	}
+	return labels
+}
+
+// FormatFromRequest returns the metrics format requested by the Accept
+// header of req, or an empty string for the default format.
+func FormatFromRequest(req *logical.Request) string {
+	for _, accept := range req.Headers["Accept"] {
+		if strings.HasPrefix(accept, PrometheusSchemaMIMEType) || strings.HasPrefix(accept, OpenMetricsMIMEType) {
+			return PrometheusMetricFormat
+		}
+	}
+	return ""
+}
+
+// ResponseForFormat returns a raw HTTP response with the metrics rendered in
+// the given format.
+func (m *MetricsHelper) ResponseForFormat(format string) (*logical.Response, error) {
+	switch format {
+	case PrometheusMetricFormat:
+		return m.PrometheusResponse()
+	case "":
+		return m.GenericResponse()
+	default:
+		return &logical.Response{
+			Data: map[string]interface{}{
+				logical.HTTPContentType: ErrorContentType,
+				logical.HTTPRawBody:     []byte(fmt.Sprintf("metric response format %q unknown", format)),
+				logical.HTTPStatusCode:  400,
+			},
+		}, nil
+	}
+}
+
+// PrometheusResponse returns the metrics gathered by the Prometheus sink in
+// the Prometheus text exposition format.
+func (m *MetricsHelper) PrometheusResponse() (*logical.Response, error) {
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			logical.HTTPContentType: ErrorContentType,
+			logical.HTTPStatusCode:  400,
+		},
+	}
+
+	if !m.PrometheusEnabled {
+		resp.Data[logical.HTTPRawBody] = []byte("prometheus is not enabled")
+		return resp, nil
+	}
+
+	metricsFamilies, err := prometheus.DefaultGatherer.Gather()
+	if err != nil && len(metricsFamilies) == 0 {
+		resp.Data[logical.HTTPStatusCode] = 500
+		resp.Data[logical.HTTPRawBody] = []byte(fmt.Sprintf("no prometheus metrics could be decoded: %s", err))
+		return resp, nil
+	}
+
+	buf := &bytes.Buffer{}
+
+	e := expfmt.NewEncoder(buf, expfmt.FmtText)
+	for _, mf := range metricsFamilies {
+		if err := e.Encode(mf); err != nil {
+			resp.Data[logical.HTTPStatusCode] = 500
+			resp.Data[logical.HTTPRawBody] = []byte(fmt.Sprintf("error during the encoding of metrics: %s", err))
+			return resp, nil
+		}
+	}
+
+	resp.Data[logical.HTTPContentType] = string(expfmt.FmtText)
+	resp.Data[logical.HTTPRawBody] = buf.Bytes()
+	resp.Data[logical.HTTPStatusCode] = 200
+	return resp, nil
+}
+
+// GenericResponse returns a JSON summary of the most recent interval
+// collected by the in-memory sink.
+func (m *MetricsHelper) GenericResponse() (*logical.Response, error) {
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			logical.HTTPContentType: ErrorContentType,
+			logical.HTTPStatusCode:  500,
+		},
+	}
+
+	summary, err := m.inMemSink.DisplayMetrics(nil, nil)
+	if err != nil {
+		resp.Data[logical.HTTPRawBody] = []byte(fmt.Sprintf("error while fetching the in-memory metrics: %s", err))
+		return resp, nil
+	}
+
+	content, err := json.Marshal(summary)
+	if err != nil {
+		resp.Data[logical.HTTPRawBody] = []byte(fmt.Sprintf("error while marshalling the in-memory metrics: %s", err))
+		return resp, nil
+	}
+
+	resp.Data[logical.HTTPContentType] = "application/json"
+	resp.Data[logical.HTTPRawBody] = content
+	resp.Data[logical.HTTPStatusCode] = 200
+	return resp, nil
+}
@@ -0,0 +1,65 @@
+package metricsutil
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	metrics "github.com/armon/go-metrics"
+	"github.com/hashicorp/vault/helper/namespace"
+	"github.com/hashicorp/vault/logical"
+)
+
+func TestMountLabels(t *testing.T) {
+	labels := MountLabels(namespace.RootNamespace, "auth/userpass/")
+	expected := []metrics.Label{{Name: "mount", Value: "auth-userpass-"}}
+	if !reflect.DeepEqual(labels, expected) {
+		t.Fatalf("bad: %#v", labels)
+	}
+
+	ns := &namespace.Namespace{ID: "abc", Path: "team/"}
+	labels = MountLabels(ns, "team/secret/")
+	expected = []metrics.Label{
+		{Name: "mount", Value: "secret-"},
+		{Name: "namespace", Value: "team/"},
+	}
+	if !reflect.DeepEqual(labels, expected) {
+		t.Fatalf("bad: %#v", labels)
+	}
+}
+
+func TestFormatFromRequest(t *testing.T) {
+	cases := map[string]string{
+		"":                                       "",
+		"application/json":                       "",
+		OpenMetricsMIMEType:                      PrometheusMetricFormat,
+		PrometheusSchemaMIMEType + "; version=1": PrometheusMetricFormat,
+	}
+	for accept, expected := range cases {
+		req := &logical.Request{Headers: map[string][]string{"Accept": {accept}}}
+		if actual := FormatFromRequest(req); actual != expected {
+			t.Fatalf("%q: expected %q, got %q", accept, expected, actual)
+		}
+	}
+}
+
+func TestResponseForFormat(t *testing.T) {
+	inm := metrics.NewInmemSink(10*time.Second, time.Minute)
+	inm.IncrCounter([]string{"test", "counter"}, 1)
+	m := NewMetricsHelper(inm, false)
+
+	cases := map[string]int{
+		"":                     200,
+		PrometheusMetricFormat: 400,
+		"nope":                 400,
+	}
+	for format, expected := range cases {
+		resp, err := m.ResponseForFormat(format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status := resp.Data[logical.HTTPStatusCode].(int); status != expected {
+			t.Fatalf("%q: expected status %d, got %d: %s", format, expected, status, resp.Data[logical.HTTPRawBody])
+		}
+	}
+}
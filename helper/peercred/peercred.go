@@ -0,0 +1,104 @@
+// Package peercred provides a unix domain socket listener that records the
+// credentials of the process on the other end of each accepted connection.
+//
+// net/http only exposes the remote address of a connection to handlers, as
+// a string, so the credentials are carried in the String form of the
+// connection's RemoteAddr and recovered with ParseAddr.
+package peercred
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// addrPrefix starts the String form of an Addr. It contains characters that
+// net.JoinHostPort would bracket, so it cannot be produced by rewriting the
+// host of a TCP remote address.
+const addrPrefix = "unix-peer:"
+
+// Creds are the credentials of the peer process of a unix socket connection.
+type Creds struct {
+	PID int32
+	UID uint32
+	GID uint32
+}
+
+// Addr is the remote address of a connection accepted by a Listener.
+type Addr struct {
+	Creds Creds
+}
+
+func (a *Addr) Network() string {
+	return "unix"
+}
+
+func (a *Addr) String() string {
+	return fmt.Sprintf("%s%d:%d:%d", addrPrefix, a.Creds.UID, a.Creds.GID, a.Creds.PID)
+}
+
+// ParseAddr returns the credentials encoded in the String form of an Addr,
+// or false if s is not one.
+func ParseAddr(s string) (*Creds, bool) {
+	if !strings.HasPrefix(s, addrPrefix) {
+		return nil, false
+	}
+
+	parts := strings.Split(strings.TrimPrefix(s, addrPrefix), ":")
+	if len(parts) != 3 {
+		return nil, false
+	}
+
+	uid, err := strconv.ParseUint(parts[0], 10, 32)
+	if err != nil {
+		return nil, false
+	}
+	gid, err := strconv.ParseUint(parts[1], 10, 32)
+	if err != nil {
+		return nil, false
+	}
+	pid, err := strconv.ParseInt(parts[2], 10, 32)
+	if err != nil {
+		return nil, false
+	}
+
+	return &Creds{
+		PID: int32(pid),
+		UID: uint32(uid),
+		GID: uint32(gid),
+	}, true
+}
+
+// Listener wraps a unix socket listener. Connections accepted on platforms
+// where the peer credentials can be read report them as an *Addr from
+// RemoteAddr; otherwise connections are returned unchanged.
+type Listener struct {
+	*net.UnixListener
+}
+
+func (l *Listener) Accept() (net.Conn, error) {
+	c, err := l.AcceptUnix()
+	if err != nil {
+		return nil, err
+	}
+
+	creds, err := getCreds(c)
+	if err != nil {
+		return c, nil
+	}
+
+	return &conn{
+		UnixConn: c,
+		addr:     &Addr{Creds: *creds},
+	}, nil
+}
+
+type conn struct {
+	*net.UnixConn
+	addr *Addr
+}
+
+func (c *conn) RemoteAddr() net.Addr {
+	return c.addr
+}
@@ -0,0 +1,32 @@
+package peercred
+
+import (
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+func getCreds(c *net.UnixConn) (*Creds, error) {
+	raw, err := c.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	var ucred *unix.Ucred
+	var credErr error
+	err = raw.Control(func(fd uintptr) {
+		ucred, credErr = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if credErr != nil {
+		return nil, credErr
+	}
+
+	return &Creds{
+		PID: ucred.Pid,
+		UID: ucred.Uid,
+		GID: ucred.Gid,
+	}, nil
+}
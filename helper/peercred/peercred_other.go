@@ -0,0 +1,12 @@
+// +build !linux
+
+package peercred
+
+import (
+	"errors"
+	"net"
+)
+
+func getCreds(c *net.UnixConn) (*Creds, error) {
+	return nil, errors.New("peer credentials are not supported on this platform")
+}
@@ -0,0 +1,30 @@
+package peercred
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseAddr(t *testing.T) {
+	addr := &Addr{Creds: Creds{PID: 42, UID: 1000, GID: 100}}
+	creds, ok := ParseAddr(addr.String())
+	if !ok {
+		t.Fatalf("failed to parse %q", addr.String())
+	}
+	if !reflect.DeepEqual(*creds, addr.Creds) {
+		t.Fatalf("bad: %#v", creds)
+	}
+
+	for _, s := range []string{
+		"",
+		"127.0.0.1:8200",
+		"[unix-peer:0:0:1]:8200",
+		"unix-peer:0:0",
+		"unix-peer:a:0:1",
+		"unix-peer:-1:0:1",
+	} {
+		if _, ok := ParseAddr(s); ok {
+			t.Fatalf("expected %q not to parse", s)
+		}
+	}
+}
@@ -6,6 +6,8 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"mime"
 	"net"
 	"net/http"
 	"net/textproto"
@@ -118,6 +120,9 @@ func Handler(props *vault.HandlerProperties) http.Handler {
 	for _, path := range injectDataIntoTopRoutes {
 		mux.Handle(path, handleRequestForwarding(core, handleLogicalWithInjector(core)))
 	}
+	if props.UnauthenticatedMetricsAccess {
+		mux.Handle("/v1/sys/metrics", handleMetricsUnauthenticated(core))
+	}
 	mux.Handle("/v1/sys/", handleRequestForwarding(core, handleLogical(core)))
 	mux.Handle("/v1/", handleRequestForwarding(core, handleLogical(core)))
 	if core.UIEnabled() == true {
@@ -409,6 +414,35 @@ func parseRequest(r *http.Request, w http.ResponseWriter, out interface{}) error
 	return err
 }
 
+// isRawRequest returns whether the body of the request has one of the binary
+// content types passed to backends as is
+func isRawRequest(r *http.Request) bool {
+	contentType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	for _, rawType := range logical.RawRequestContentTypes {
+		if contentType == rawType {
+			return true
+		}
+	}
+	return false
+}
+
+// readRawRequest reads the body of the request, limited like JSON ones to
+// MaxRequestSize
+func readRawRequest(r *http.Request, w http.ResponseWriter) ([]byte, error) {
+	reader := r.Body
+	if maxRequestSize, ok := r.Context().Value("max_request_size").(int64); ok && maxRequestSize > 0 {
+		reader = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	}
+	body, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, errwrap.Wrapf("failed to read request body: {{err}}", err)
+	}
+	return body, nil
+}
+
 // handleRequestForwarding determines whether to forward a request or not,
 // falling back on the older behavior of redirecting the client
 func handleRequestForwarding(core *vault.Core, handler http.Handler) http.Handler {
@@ -14,10 +14,29 @@ import (
 	"github.com/hashicorp/errwrap"
 	"github.com/hashicorp/go-uuid"
 	"github.com/hashicorp/vault/helper/namespace"
+	"github.com/hashicorp/vault/helper/peercred"
 	"github.com/hashicorp/vault/logical"
 	"github.com/hashicorp/vault/vault"
 )
 
+// deniedRawResponseHeaders are the headers of raw responses that aren't sent:
+// cookies, and the hop-by-hop and framing headers that belong to the server's
+// connection rather than to the response
+var deniedRawResponseHeaders = map[string]struct{}{
+	"Set-Cookie":          struct{}{},
+	"Set-Cookie2":         struct{}{},
+	"Connection":          struct{}{},
+	"Keep-Alive":          struct{}{},
+	"Proxy-Authenticate":  struct{}{},
+	"Proxy-Authorization": struct{}{},
+	"Proxy-Connection":    struct{}{},
+	"Te":                  struct{}{},
+	"Trailer":             struct{}{},
+	"Transfer-Encoding":   struct{}{},
+	"Upgrade":             struct{}{},
+	"Content-Length":      struct{}{},
+}
+
 func buildLogicalRequest(core *vault.Core, w http.ResponseWriter, r *http.Request) (*logical.Request, int, error) {
 	ns, err := namespace.FromContext(r.Context())
 	if err != nil {
@@ -33,6 +52,14 @@ func buildLogicalRequest(core *vault.Core, w http.ResponseWriter, r *http.Reques
 	case "DELETE":
 		op = logical.DeleteOperation
 
+	case "HEAD":
+		// Only paths that opt in answer HEAD requests, with the response of
+		// their read operation written without its body
+		if !core.HeadPath(r.Context(), path) {
+			return nil, http.StatusMethodNotAllowed, nil
+		}
+		op = logical.ReadOperation
+
 	case "GET":
 		op = logical.ReadOperation
 		queryVals := r.URL.Query()
@@ -77,6 +104,18 @@ func buildLogicalRequest(core *vault.Core, w http.ResponseWriter, r *http.Reques
 
 	case "POST", "PUT":
 		op = logical.UpdateOperation
+		// Binary bodies are passed as is
+		if isRawRequest(r) {
+			body, err := readRawRequest(r, w)
+			if err != nil {
+				return nil, http.StatusBadRequest, err
+			}
+			data = map[string]interface{}{
+				logical.HTTPRawBody: body,
+			}
+			break
+		}
+
 		// Parse the request if we can
 		if op == logical.UpdateOperation {
 			err := parseRequest(r, w, &data)
@@ -354,6 +393,14 @@ func respondRaw(w http.ResponseWriter, r *http.Request, resp *logical.Response)
 
 WRITE_RESPONSE:
 	// Write the response
+	for header, values := range resp.Headers {
+		if _, ok := deniedRawResponseHeaders[http.CanonicalHeaderKey(header)]; ok {
+			continue
+		}
+		for _, value := range values {
+			w.Header().Add(header, value)
+		}
+	}
 	if contentType != "" {
 		w.Header().Set("Content-Type", contentType)
 	}
@@ -367,6 +414,19 @@ WRITE_RESPONSE:
 func getConnection(r *http.Request) (connection *logical.Connection) {
 	var remoteAddr string
 
+	// Requests from a unix socket listener carry the peer's credentials in
+	// place of a network address
+	if creds, ok := peercred.ParseAddr(r.RemoteAddr); ok {
+		return &logical.Connection{
+			ConnState: r.TLS,
+			UnixCredentials: &logical.UnixCredentials{
+				PID: creds.PID,
+				UID: creds.UID,
+				GID: creds.GID,
+			},
+		}
+	}
+
 	remoteAddr, _, err := net.SplitHostPort(r.RemoteAddr)
 	if err != nil {
 		remoteAddr = ""
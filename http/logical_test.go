@@ -18,6 +18,7 @@ import (
 	"github.com/hashicorp/vault/helper/consts"
 	"github.com/hashicorp/vault/helper/logging"
 	"github.com/hashicorp/vault/helper/namespace"
+	"github.com/hashicorp/vault/helper/peercred"
 	"github.com/hashicorp/vault/logical"
 	"github.com/hashicorp/vault/physical"
 	"github.com/hashicorp/vault/physical/inmem"
@@ -313,6 +314,48 @@ func TestLogical_ListSuffix(t *testing.T) {
 	}
 }
 
+func TestLogical_HeadNotAllowed(t *testing.T) {
+	core, _, rootToken := vault.TestCoreUnsealed(t)
+	req, _ := http.NewRequest("HEAD", "http://127.0.0.1:8200/v1/secret/foo", nil)
+	req = req.WithContext(namespace.RootContext(nil))
+	req.Header.Add(consts.AuthHeaderName, rootToken)
+	_, status, err := buildLogicalRequest(core, nil, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != http.StatusMethodNotAllowed {
+		t.Fatalf("got status %d", status)
+	}
+}
+
+func TestLogical_RawHTTPHeaders(t *testing.T) {
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			logical.HTTPStatusCode:  http.StatusOK,
+			logical.HTTPContentType: "plain/text",
+			logical.HTTPRawBody:     []byte("hello world"),
+		},
+		Headers: map[string][]string{
+			"Link":              []string{"<https://example.com>;rel=\"index\""},
+			"set-cookie":        []string{"session=foo"},
+			"Connection":        []string{"close"},
+			"Transfer-Encoding": []string{"chunked"},
+		},
+	}
+
+	w := httptest.NewRecorder()
+	respondRaw(w, nil, resp)
+
+	if w.Header().Get("Link") != "<https://example.com>;rel=\"index\"" {
+		t.Fatalf("bad: %#v", w.Header())
+	}
+	for _, header := range []string{"Set-Cookie", "Connection", "Transfer-Encoding"} {
+		if w.Header().Get(header) != "" {
+			t.Fatalf("bad: %s sent: %#v", header, w.Header())
+		}
+	}
+}
+
 func TestLogical_RespondWithStatusCode(t *testing.T) {
 	resp := &logical.Response{
 		Data: map[string]interface{}{
@@ -343,3 +386,19 @@ func TestLogical_RespondWithStatusCode(t *testing.T) {
 		t.Fatalf("bad response: %s", string(bodyRaw[:]))
 	}
 }
+
+func TestLogical_getConnection(t *testing.T) {
+	r := httptest.NewRequest("GET", "/v1/sys/health", nil)
+	r.RemoteAddr = "127.0.0.1:54321"
+	conn := getConnection(r)
+	if conn.RemoteAddr != "127.0.0.1" || conn.UnixCredentials != nil {
+		t.Fatalf("bad: %#v", conn)
+	}
+
+	r.RemoteAddr = (&peercred.Addr{Creds: peercred.Creds{PID: 42, UID: 1000, GID: 100}}).String()
+	conn = getConnection(r)
+	expected := &logical.UnixCredentials{PID: 42, UID: 1000, GID: 100}
+	if conn.RemoteAddr != "" || !reflect.DeepEqual(conn.UnixCredentials, expected) {
+		t.Fatalf("bad: %#v", conn)
+	}
+}
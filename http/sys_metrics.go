@@ -0,0 +1,45 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/hashicorp/vault/helper/metricsutil"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/vault"
+)
+
+// handleMetricsUnauthenticated serves sys/metrics from the local node without
+// requiring a token. It is only registered on listeners that opt in with
+// unauthenticated_metrics_access.
+func handleMetricsUnauthenticated(core *vault.Core) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+		default:
+			respondError(w, http.StatusMethodNotAllowed, nil)
+			return
+		}
+
+		helper := core.MetricsHelper()
+		if helper == nil {
+			respondError(w, http.StatusServiceUnavailable, errors.New("telemetry is not configured on this node"))
+			return
+		}
+
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = metricsutil.FormatFromRequest(&logical.Request{
+				Headers: r.Header,
+			})
+		}
+
+		resp, err := helper.ResponseForFormat(format)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		respondRaw(w, r, resp)
+	})
+}
@@ -0,0 +1,90 @@
+package http
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	metrics "github.com/armon/go-metrics"
+	"github.com/armon/go-metrics/prometheus"
+	"github.com/hashicorp/vault/helper/metricsutil"
+	"github.com/hashicorp/vault/vault"
+)
+
+func testMetricsCore(t *testing.T) (*vault.Core, string) {
+	inm := metrics.NewInmemSink(10*time.Second, time.Minute)
+	promSink, err := prometheus.NewPrometheusSinkFrom(prometheus.PrometheusOpts{
+		Expiration: time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	inm.SetGauge([]string{"test", "gauge"}, 42)
+	promSink.SetGauge([]string{"test", "gauge"}, 42)
+
+	core, _, token := vault.TestCoreUnsealedWithConfig(t, &vault.CoreConfig{
+		MetricsHelper: metricsutil.NewMetricsHelper(inm, true),
+	})
+	return core, token
+}
+
+func TestSysMetrics(t *testing.T) {
+	core, token := testMetricsCore(t)
+
+	// Authenticated listener
+	ln, addr := TestServer(t, core)
+	defer ln.Close()
+
+	resp := testHttpGet(t, "", addr+"/v1/sys/metrics")
+	if resp.StatusCode == 200 {
+		t.Fatal("expected metrics to require a token")
+	}
+
+	resp = testHttpGet(t, token, addr+"/v1/sys/metrics")
+	testResponseStatus(t, resp, 200)
+	var summary map[string]interface{}
+	testResponseBody(t, resp, &summary)
+	if _, ok := summary["Gauges"]; !ok {
+		t.Fatalf("bad: %#v", summary)
+	}
+
+	// Listener with unauthenticated access
+	ln2, addr2 := TestListener(t)
+	defer ln2.Close()
+	TestServerWithListenerAndProperties(t, ln2, addr2, core, &vault.HandlerProperties{
+		Core:                         core,
+		MaxRequestSize:               DefaultMaxRequestSize,
+		UnauthenticatedMetricsAccess: true,
+	})
+
+	resp = testHttpGet(t, "", addr2+"/v1/sys/metrics?format=prometheus")
+	testResponseStatus(t, resp, 200)
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/plain") {
+		t.Fatalf("bad content type: %q", resp.Header.Get("Content-Type"))
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(body), "test_gauge 42") {
+		t.Fatalf("bad body: %s", body)
+	}
+
+	req, err := http.NewRequest("GET", addr2+"/v1/sys/metrics", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept", metricsutil.OpenMetricsMIMEType)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testResponseStatus(t, resp, 200)
+	resp.Body.Close()
+
+	resp = testHttpGet(t, "", addr2+"/v1/sys/metrics?format=nope")
+	testResponseStatus(t, resp, 400)
+}
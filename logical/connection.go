@@ -12,4 +12,16 @@ type Connection struct {
 
 	// ConnState is the TLS connection state if applicable.
 	ConnState *tls.ConnectionState `sentinel:""`
+
+	// UnixCredentials are the credentials of the local process that sent the
+	// request, if it came in over a unix domain socket listener.
+	UnixCredentials *UnixCredentials `json:"unix_credentials,omitempty"`
+}
+
+// UnixCredentials identifies the process on the other end of a unix domain
+// socket connection.
+type UnixCredentials struct {
+	PID int32  `json:"pid"`
+	UID uint32 `json:"uid"`
+	GID uint32 `json:"gid"`
 }
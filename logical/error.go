@@ -1,6 +1,9 @@
 package logical
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 var (
 	// ErrUnsupportedOperation is returned if the operation is not supported
@@ -26,6 +29,25 @@ var (
 	ErrUpstreamRateLimited = errors.New("upstream rate limited")
 )
 
+// DelegatedAuthRequest is returned as an error by backends which authenticate
+// the clients of their unauthenticated paths through an auth mount. The core
+// performs the login request, which is audited and checked like any other
+// login without creating a token, checks that the policies it grants allow
+// the original request, and routes it again with the resulting authentication
+// in the DelegatedAuth of the request. It is only supported by builtin
+// backends.
+type DelegatedAuthRequest struct {
+	// Path is the login path, e.g. "auth/userpass/login/<username>"
+	Path string
+
+	// Data is the data of the login request
+	Data map[string]interface{}
+}
+
+func (d *DelegatedAuthRequest) Error() string {
+	return fmt.Sprintf("authentication delegated to %s", d.Path)
+}
+
 type HTTPCodedError interface {
 	Error() string
 	Code() int
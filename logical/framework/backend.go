@@ -370,7 +370,16 @@ func (b *Backend) handleRootHelp() (*logical.Response, error) {
 		return nil, err
 	}
 
-	return logical.HelpResponse(help, nil), nil
+	// Build OpenAPI response for the entire backend
+	doc := NewOASDocument()
+	if err := documentPaths(b, doc); err != nil {
+		b.Logger().Warn("error generating OpenAPI", "error", err)
+	}
+
+	resp := logical.HelpResponse(help, nil)
+	resp.Data["openapi"] = doc
+
+	return resp, nil
 }
 
 func (b *Backend) handleRevokeRenew(ctx context.Context, req *logical.Request) (*logical.Response, error) {
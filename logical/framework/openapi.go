@@ -0,0 +1,541 @@
+package framework
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/version"
+)
+
+// OpenAPI specification (OAS): https://github.com/OAI/OpenAPI-Specification/blob/master/versions/3.0.2.md
+const OASVersion = "3.0.2"
+
+// maxPatternExpansions bounds the number of paths a single pattern may
+// expand to, guarding against patterns with many optional sections.
+const maxPatternExpansions = 128
+
+// NewOASDocument returns an empty OpenAPI document.
+func NewOASDocument() *OASDocument {
+	return &OASDocument{
+		Version: OASVersion,
+		Info: OASInfo{
+			Title:       "HashiCorp Vault API",
+			Description: "HTTP API that gives you full access to Vault. All API routes are prefixed with `/v1/`.",
+			Version:     version.GetVersion().Version,
+			License: OASLicense{
+				Name: "Mozilla Public License 2.0",
+				URL:  "https://www.mozilla.org/en-US/MPL/2.0",
+			},
+		},
+		Paths: make(map[string]*OASPathItem),
+		Components: OASComponents{
+			SecuritySchemes: map[string]*OASSecurityScheme{
+				"vault_token": &OASSecurityScheme{
+					Type: "apiKey",
+					In:   "header",
+					Name: "X-Vault-Token",
+				},
+			},
+		},
+		Security: []map[string][]string{
+			{"vault_token": []string{}},
+		},
+	}
+}
+
+// NewOASDocumentFromMap builds an OASDocument from a generic map, such as
+// the decoded help response of an external plugin.
+func NewOASDocumentFromMap(input map[string]interface{}) (*OASDocument, error) {
+	buf, err := json.Marshal(input)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := new(OASDocument)
+	if err := json.Unmarshal(buf, doc); err != nil {
+		return nil, err
+	}
+	if doc.Paths == nil {
+		doc.Paths = make(map[string]*OASPathItem)
+	}
+
+	return doc, nil
+}
+
+type OASDocument struct {
+	Version    string                  `json:"openapi"`
+	Info       OASInfo                 `json:"info"`
+	Paths      map[string]*OASPathItem `json:"paths"`
+	Components OASComponents           `json:"components"`
+	Security   []map[string][]string   `json:"security,omitempty"`
+}
+
+type OASInfo struct {
+	Title       string     `json:"title"`
+	Description string     `json:"description"`
+	Version     string     `json:"version"`
+	License     OASLicense `json:"license"`
+}
+
+type OASLicense struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+type OASComponents struct {
+	SecuritySchemes map[string]*OASSecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+type OASSecurityScheme struct {
+	Type string `json:"type"`
+	In   string `json:"in,omitempty"`
+	Name string `json:"name,omitempty"`
+}
+
+type OASPathItem struct {
+	Description     string         `json:"description,omitempty"`
+	Parameters      []OASParameter `json:"parameters,omitempty"`
+	Sudo            bool           `json:"x-vault-sudo,omitempty"`
+	Unauthenticated bool           `json:"x-vault-unauthenticated,omitempty"`
+
+	Get    *OASOperation `json:"get,omitempty"`
+	Post   *OASOperation `json:"post,omitempty"`
+	Delete *OASOperation `json:"delete,omitempty"`
+}
+
+// Operations returns the operations of the path item keyed by HTTP method.
+func (p *OASPathItem) Operations() map[string]*OASOperation {
+	ops := make(map[string]*OASOperation)
+	if p.Get != nil {
+		ops["get"] = p.Get
+	}
+	if p.Post != nil {
+		ops["post"] = p.Post
+	}
+	if p.Delete != nil {
+		ops["delete"] = p.Delete
+	}
+	return ops
+}
+
+type OASOperation struct {
+	Summary     string                  `json:"summary,omitempty"`
+	Description string                  `json:"description,omitempty"`
+	OperationID string                  `json:"operationId,omitempty"`
+	Tags        []string                `json:"tags,omitempty"`
+	Parameters  []OASParameter          `json:"parameters,omitempty"`
+	RequestBody *OASRequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*OASResponse `json:"responses"`
+
+	// Security is set to an empty list for unauthenticated operations to
+	// override the document's default requirement.
+	Security *[]map[string][]string `json:"security,omitempty"`
+}
+
+type OASParameter struct {
+	Name        string     `json:"name"`
+	Description string     `json:"description,omitempty"`
+	In          string     `json:"in"`
+	Schema      *OASSchema `json:"schema,omitempty"`
+	Required    bool       `json:"required,omitempty"`
+}
+
+type OASRequestBody struct {
+	Description string                         `json:"description,omitempty"`
+	Required    bool                           `json:"required,omitempty"`
+	Content     map[string]*OASMediaTypeObject `json:"content"`
+}
+
+type OASMediaTypeObject struct {
+	Schema *OASSchema `json:"schema,omitempty"`
+}
+
+type OASSchema struct {
+	Type        string                `json:"type,omitempty"`
+	Description string                `json:"description,omitempty"`
+	Properties  map[string]*OASSchema `json:"properties,omitempty"`
+	Items       *OASSchema            `json:"items,omitempty"`
+	Format      string                `json:"format,omitempty"`
+	Enum        []interface{}         `json:"enum,omitempty"`
+	Default     interface{}           `json:"default,omitempty"`
+}
+
+type OASResponse struct {
+	Description string `json:"description"`
+}
+
+// CreateOperationIDs sets a unique operation ID on every operation in the
+// document that doesn't already have one, derived from its method and path,
+// e.g. "getSecretDataPath" for GET /secret/data/{path}.
+func (d *OASDocument) CreateOperationIDs() {
+	seen := make(map[string]int)
+
+	paths := make([]string, 0, len(d.Paths))
+	for path := range d.Paths {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		ops := d.Paths[path].Operations()
+		methods := make([]string, 0, len(ops))
+		for method := range ops {
+			methods = append(methods, method)
+		}
+		sort.Strings(methods)
+
+		for _, method := range methods {
+			op := ops[method]
+			if op.OperationID != "" {
+				continue
+			}
+
+			verb := method
+			for _, param := range op.Parameters {
+				if param.In == "query" && param.Name == "list" {
+					verb = "list"
+				}
+			}
+
+			id := verb
+			for _, word := range nonWordRe.Split(path, -1) {
+				if word != "" {
+					id += strings.ToUpper(word[:1]) + word[1:]
+				}
+			}
+
+			seen[id]++
+			if seen[id] > 1 {
+				id = fmt.Sprintf("%s%d", id, seen[id])
+			}
+			op.OperationID = id
+		}
+	}
+}
+
+var nonWordRe = regexp.MustCompile(`[^a-zA-Z0-9]+`)
+
+// documentPaths adds every path of the backend to doc.
+func documentPaths(backend *Backend, doc *OASDocument) error {
+	for _, p := range backend.Paths {
+		if err := documentPath(p, backend.SpecialPaths(), doc); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// documentPath adds the paths matched by a single framework.Path to doc.
+func documentPath(p *Path, specialPaths *logical.Paths, doc *OASDocument) error {
+	paths, err := expandPattern(p.Pattern)
+	if err != nil {
+		return err
+	}
+
+	expanded := make(map[string]bool, len(paths))
+	for _, path := range paths {
+		expanded[path] = true
+	}
+
+	for _, path := range paths {
+		// Vault's list operations are served on a trailing slash, so give
+		// them their own path item instead of colliding with a read.
+		var listPath string
+		if _, ok := p.Callbacks[logical.ListOperation]; ok {
+			listPath = path
+			if !strings.HasSuffix(listPath, "/") {
+				listPath += "/"
+			}
+		}
+
+		// An optional trailing slash only matters for lists
+		if path != listPath && strings.HasSuffix(path, "/") && expanded[strings.TrimSuffix(path, "/")] {
+			continue
+		}
+
+		// Requests are routed to the first matching pattern, so earlier
+		// paths take precedence
+		if _, ok := doc.Paths["/"+path]; !ok {
+			if item := documentPathItem(p, path, path == listPath, specialPaths); item != nil {
+				doc.Paths["/"+path] = item
+			}
+		}
+		if _, ok := doc.Paths["/"+listPath]; !ok && listPath != "" && listPath != path {
+			if item := documentPathItem(p, listPath, true, specialPaths); item != nil {
+				doc.Paths["/"+listPath] = item
+			}
+		}
+	}
+
+	return nil
+}
+
+// documentPathItem documents the operations of p at the expanded path. If
+// list is true the GET operation documents a list, otherwise a read.
+func documentPathItem(p *Path, path string, list bool, specialPaths *logical.Paths) *OASPathItem {
+	item := &OASPathItem{
+		Description: strings.TrimSpace(p.HelpSynopsis),
+	}
+	if specialPaths != nil {
+		item.Sudo = specialPathMatch(path, specialPaths.Root)
+		item.Unauthenticated = specialPathMatch(path, specialPaths.Unauthenticated)
+	}
+
+	// Path parameters are shared by all operations
+	pathParams := make(map[string]bool)
+	for _, name := range pathParamNames(path) {
+		pathParams[name] = true
+
+		param := OASParameter{
+			Name:     name,
+			In:       "path",
+			Required: true,
+			Schema:   &OASSchema{Type: "string"},
+		}
+		if field, ok := p.Fields[name]; ok {
+			param.Description = strings.TrimSpace(field.Description)
+			param.Schema = convertField(field)
+		}
+		item.Parameters = append(item.Parameters, param)
+	}
+
+	newOperation := func() *OASOperation {
+		op := &OASOperation{
+			Summary:     strings.TrimSpace(p.HelpSynopsis),
+			Description: strings.TrimSpace(p.HelpDescription),
+			Responses: map[string]*OASResponse{
+				"200": &OASResponse{Description: "OK"},
+			},
+		}
+		if item.Unauthenticated {
+			op.Security = &[]map[string][]string{}
+		}
+		return op
+	}
+
+	_, hasRead := p.Callbacks[logical.ReadOperation]
+	switch {
+	case list:
+		op := newOperation()
+		op.Parameters = append(op.Parameters, OASParameter{
+			Name:        "list",
+			Description: "Return a list if `true`",
+			In:          "query",
+			Required:    true,
+			Schema:      &OASSchema{Type: "string", Enum: []interface{}{"true"}},
+		})
+		item.Get = op
+	case hasRead:
+		item.Get = newOperation()
+	}
+
+	_, hasCreate := p.Callbacks[logical.CreateOperation]
+	_, hasUpdate := p.Callbacks[logical.UpdateOperation]
+	if (hasCreate || hasUpdate) && !list {
+		op := newOperation()
+
+		// All fields that are not part of the path make up the body
+		properties := make(map[string]*OASSchema)
+		for name, field := range p.Fields {
+			if pathParams[name] {
+				continue
+			}
+			properties[name] = convertField(field)
+		}
+		if len(properties) > 0 {
+			op.RequestBody = &OASRequestBody{
+				Content: map[string]*OASMediaTypeObject{
+					"application/json": &OASMediaTypeObject{
+						Schema: &OASSchema{
+							Type:       "object",
+							Properties: properties,
+						},
+					},
+				},
+			}
+		}
+		item.Post = op
+	}
+
+	if _, ok := p.Callbacks[logical.DeleteOperation]; ok && !list {
+		op := newOperation()
+		op.Responses = map[string]*OASResponse{
+			"204": &OASResponse{Description: "empty body"},
+		}
+		item.Delete = op
+	}
+
+	if item.Get == nil && item.Post == nil && item.Delete == nil {
+		return nil
+	}
+
+	return item
+}
+
+// convertField converts a field schema into an OpenAPI schema.
+func convertField(field *FieldSchema) *OASSchema {
+	schema := &OASSchema{
+		Description: strings.TrimSpace(field.Description),
+		Default:     field.Default,
+	}
+
+	switch field.Type {
+	case TypeString, TypeLowerCaseString, TypeNameString:
+		schema.Type = "string"
+	case TypeInt:
+		schema.Type = "integer"
+	case TypeBool:
+		schema.Type = "boolean"
+	case TypeDurationSecond:
+		schema.Type = "integer"
+		schema.Format = "seconds"
+	case TypeMap, TypeKVPairs, TypeHeader:
+		schema.Type = "object"
+	case TypeStringSlice, TypeCommaStringSlice:
+		schema.Type = "array"
+		schema.Items = &OASSchema{Type: "string"}
+	case TypeCommaIntSlice:
+		schema.Type = "array"
+		schema.Items = &OASSchema{Type: "integer"}
+	case TypeSlice:
+		schema.Type = "array"
+		schema.Items = &OASSchema{Type: "object"}
+	default:
+		schema.Type = "string"
+	}
+
+	return schema
+}
+
+var pathParamRe = regexp.MustCompile(`\{(\w+)\}`)
+
+// pathParamNames returns the names of the parameters in an expanded path, in
+// order of appearance.
+func pathParamNames(path string) []string {
+	var names []string
+	for _, m := range pathParamRe.FindAllStringSubmatch(path, -1) {
+		names = append(names, m[1])
+	}
+	return names
+}
+
+// specialPathMatch checks whether path matches any of the special path
+// patterns, which are either exact or end in a '*' prefix glob.
+func specialPathMatch(path string, specialPaths []string) bool {
+	for _, sp := range specialPaths {
+		if strings.HasSuffix(sp, "*") {
+			if strings.HasPrefix(path, strings.TrimSuffix(sp, "*")) {
+				return true
+			}
+			continue
+		}
+		if sp == path || sp == strings.TrimSuffix(path, "/") {
+			return true
+		}
+	}
+	return false
+}
+
+// expandPattern expands a path regex into the OpenAPI paths it matches.
+// Named captures become {name} parameters, and optional or alternate
+// sections produce a path for each variant. Variants that can only be
+// matched by an unnamed wildcard can't be represented and are dropped.
+func expandPattern(pattern string) ([]string, error) {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing pattern %q: %v", pattern, err)
+	}
+
+	expanded := expandRegexp(re)
+
+	seen := make(map[string]bool, len(expanded))
+	paths := make([]string, 0, len(expanded))
+	for _, path := range expanded {
+		path = strings.TrimPrefix(path, "/")
+		if seen[path] {
+			continue
+		}
+		seen[path] = true
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	return paths, nil
+}
+
+// expandRegexp returns every string re can match, treating named captures as
+// opaque parameters. A nil result means re can't be represented.
+func expandRegexp(re *syntax.Regexp) []string {
+	switch re.Op {
+	case syntax.OpEmptyMatch, syntax.OpBeginLine, syntax.OpEndLine,
+		syntax.OpBeginText, syntax.OpEndText:
+		return []string{""}
+
+	case syntax.OpLiteral:
+		return []string{string(re.Rune)}
+
+	case syntax.OpCharClass:
+		// Small classes come from alternations of single characters,
+		// e.g. (a|b); anything larger is a wildcard.
+		var result []string
+		for i := 0; i+1 < len(re.Rune); i += 2 {
+			for r := re.Rune[i]; r <= re.Rune[i+1]; r++ {
+				result = append(result, string(r))
+				if len(result) > 4 {
+					return nil
+				}
+			}
+		}
+		return result
+
+	case syntax.OpCapture:
+		if re.Name != "" {
+			return []string{"{" + re.Name + "}"}
+		}
+		return expandRegexp(re.Sub[0])
+
+	case syntax.OpQuest:
+		sub := expandRegexp(re.Sub[0])
+		return append([]string{""}, sub...)
+
+	case syntax.OpAlternate:
+		var result []string
+		for _, sub := range re.Sub {
+			result = append(result, expandRegexp(sub)...)
+			if len(result) > maxPatternExpansions {
+				return nil
+			}
+		}
+		return result
+
+	case syntax.OpConcat:
+		result := []string{""}
+		for _, sub := range re.Sub {
+			parts := expandRegexp(sub)
+			if parts == nil {
+				return nil
+			}
+			if len(result)*len(parts) > maxPatternExpansions {
+				return nil
+			}
+
+			next := make([]string, 0, len(result)*len(parts))
+			for _, prefix := range result {
+				for _, part := range parts {
+					next = append(next, prefix+part)
+				}
+			}
+			result = next
+		}
+		return result
+	}
+
+	// Wildcards and repetitions
+	return nil
+}
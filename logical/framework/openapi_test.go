@@ -0,0 +1,236 @@
+package framework
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+func TestOpenAPI_ExpandPattern(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		expected []string
+	}{
+		{"rotate", []string{"rotate"}},
+		{"^rotate$", []string{"rotate"}},
+		{"roles/?$", []string{"roles", "roles/"}},
+		{"roles/" + GenericNameRegex("name"), []string{"roles/{name}"}},
+		{"creds/(?P<name>.+)", []string{"creds/{name}"}},
+		{"config/(urls|crl)", []string{"config/crl", "config/urls"}},
+		{"tidy(/(?P<mode>auto|manual))?", []string{"tidy", "tidy/{mode}"}},
+		{"keys" + OptionalParamRegex("name"), []string{"keys", "keys/{name}"}},
+		{"(raw/?$|raw/(?P<path>.+))", []string{"raw", "raw/", "raw/{path}"}},
+		{"issuer/(?P<a>.+)/(?P<b>.+)/(?P<c>.+)", []string{"issuer/{a}/{b}/{c}"}},
+
+		// Unnamed wildcards can't be represented
+		{".*", []string{}},
+		{"prefix/.*", []string{}},
+		{"(foo|bar/.+)", []string{"foo"}},
+	}
+
+	for _, test := range tests {
+		paths, err := expandPattern(test.pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(paths, test.expected) {
+			t.Fatalf("pattern %q: expected %#v, got %#v", test.pattern, test.expected, paths)
+		}
+	}
+}
+
+func TestOpenAPI_DocumentPath(t *testing.T) {
+	noop := func(context.Context, *logical.Request, *FieldData) (*logical.Response, error) {
+		return nil, nil
+	}
+
+	b := &Backend{
+		PathsSpecial: &logical.Paths{
+			Root:            []string{"roles/admin"},
+			Unauthenticated: []string{"login/*"},
+		},
+		Paths: []*Path{
+			{
+				Pattern: "roles/?$",
+				Callbacks: map[logical.Operation]OperationFunc{
+					logical.ListOperation: noop,
+				},
+				HelpSynopsis: "List roles.",
+			},
+			{
+				Pattern: "roles/" + GenericNameRegex("name"),
+				Fields: map[string]*FieldSchema{
+					"name":     {Type: TypeString, Description: "Name of the role."},
+					"ttl":      {Type: TypeDurationSecond, Default: 3600},
+					"policies": {Type: TypeCommaStringSlice},
+					"enabled":  {Type: TypeBool},
+				},
+				Callbacks: map[logical.Operation]OperationFunc{
+					logical.ReadOperation:   noop,
+					logical.UpdateOperation: noop,
+					logical.DeleteOperation: noop,
+				},
+				HelpSynopsis:    "Manage roles.",
+				HelpDescription: "Longer description.",
+			},
+			{
+				Pattern: "login/" + GenericNameRegex("role"),
+				Callbacks: map[logical.Operation]OperationFunc{
+					logical.UpdateOperation: noop,
+				},
+			},
+			{
+				// Shadowed by the roles pattern above
+				Pattern: "roles/(?P<other>.+)",
+				Callbacks: map[logical.Operation]OperationFunc{
+					logical.ReadOperation: noop,
+				},
+			},
+		},
+	}
+
+	doc := NewOASDocument()
+	if err := documentPaths(b, doc); err != nil {
+		t.Fatal(err)
+	}
+
+	var paths []string
+	for path := range doc.Paths {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	expectedPaths := []string{"/login/{role}", "/roles/", "/roles/{name}", "/roles/{other}"}
+	if !reflect.DeepEqual(paths, expectedPaths) {
+		t.Fatalf("expected %v, got %v", expectedPaths, paths)
+	}
+
+	list := doc.Paths["/roles/"]
+	if list.Get == nil || list.Post != nil || list.Delete != nil {
+		t.Fatalf("bad list operations: %#v", list)
+	}
+	if len(list.Get.Parameters) != 1 || list.Get.Parameters[0].Name != "list" || !list.Get.Parameters[0].Required {
+		t.Fatalf("bad list parameters: %#v", list.Get.Parameters)
+	}
+
+	role := doc.Paths["/roles/{name}"]
+	if role.Get == nil || role.Post == nil || role.Delete == nil {
+		t.Fatalf("missing operations: %#v", role)
+	}
+	if role.Get.Summary != "Manage roles." || role.Get.Description != "Longer description." {
+		t.Fatalf("bad help: %#v", role.Get)
+	}
+	expectedParams := []OASParameter{{
+		Name:        "name",
+		Description: "Name of the role.",
+		In:          "path",
+		Required:    true,
+		Schema:      &OASSchema{Type: "string", Description: "Name of the role."},
+	}}
+	if !reflect.DeepEqual(role.Parameters, expectedParams) {
+		t.Fatalf("bad path parameters: %#v", role.Parameters)
+	}
+
+	body := role.Post.RequestBody.Content["application/json"].Schema
+	expectedBody := &OASSchema{
+		Type: "object",
+		Properties: map[string]*OASSchema{
+			"ttl":      {Type: "integer", Format: "seconds", Default: 3600},
+			"policies": {Type: "array", Items: &OASSchema{Type: "string"}},
+			"enabled":  {Type: "boolean"},
+		},
+	}
+	if !reflect.DeepEqual(body, expectedBody) {
+		t.Fatalf("bad request body: %#v", body)
+	}
+	if _, ok := role.Delete.Responses["204"]; !ok {
+		t.Fatalf("bad delete responses: %#v", role.Delete.Responses)
+	}
+	if role.Sudo || role.Unauthenticated {
+		t.Fatalf("unexpected special path flags: %#v", role)
+	}
+
+	login := doc.Paths["/login/{role}"]
+	if !login.Unauthenticated || login.Post.Security == nil || len(*login.Post.Security) != 0 {
+		t.Fatalf("login should be unauthenticated: %#v", login)
+	}
+	if login.Post.RequestBody != nil {
+		t.Fatalf("unexpected request body: %#v", login.Post.RequestBody)
+	}
+}
+
+func TestOpenAPI_OperationIDs(t *testing.T) {
+	doc := NewOASDocument()
+	doc.Paths["/secret/data/{path}"] = &OASPathItem{
+		Get:  &OASOperation{},
+		Post: &OASOperation{},
+	}
+	doc.Paths["/secret/metadata/"] = &OASPathItem{
+		Get: &OASOperation{Parameters: []OASParameter{{Name: "list", In: "query"}}},
+	}
+	doc.Paths["/secret/data-{path}"] = &OASPathItem{
+		Get: &OASOperation{},
+	}
+	doc.CreateOperationIDs()
+
+	tests := map[string]string{
+		"getSecretDataPath":  doc.Paths["/secret/data-{path}"].Get.OperationID,
+		"getSecretDataPath2": doc.Paths["/secret/data/{path}"].Get.OperationID,
+		"postSecretDataPath": doc.Paths["/secret/data/{path}"].Post.OperationID,
+		"listSecretMetadata": doc.Paths["/secret/metadata/"].Get.OperationID,
+	}
+	for expected, actual := range tests {
+		if expected != actual {
+			t.Fatalf("expected %q, got %q", expected, actual)
+		}
+	}
+}
+
+func TestOpenAPI_FromMap(t *testing.T) {
+	b := &Backend{
+		Paths: []*Path{
+			{
+				Pattern: "kv/" + GenericNameRegex("key"),
+				Fields: map[string]*FieldSchema{
+					"key":   {Type: TypeString},
+					"value": {Type: TypeString},
+				},
+				Callbacks: map[logical.Operation]OperationFunc{
+					logical.UpdateOperation: nil,
+				},
+			},
+		},
+	}
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.HelpOperation,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, ok := resp.Data["openapi"].(*OASDocument)
+	if !ok {
+		t.Fatalf("bad help response: %#v", resp.Data)
+	}
+
+	// Simulate the document being decoded on the far side of a plugin
+	buf, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var raw map[string]interface{}
+	if err := json.Unmarshal(buf, &raw); err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := NewOASDocumentFromMap(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, doc) {
+		t.Fatalf("expected %#v, got %#v", doc, decoded)
+	}
+}
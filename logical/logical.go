@@ -115,6 +115,11 @@ type Paths struct {
 	// Unauthenticated are the paths that can be accessed without any auth.
 	Unauthenticated []string
 
+	// Head are the paths that answer HEAD requests, with the response of
+	// their read operation written without its body. HEAD requests to other
+	// paths are rejected.
+	Head []string
+
 	// LocalStorage are paths (prefixes) that are local to this instance; this
 	// indicates that these paths should not be replicated
 	LocalStorage []string
@@ -9,6 +9,7 @@ import (
 	gplugin "github.com/hashicorp/go-plugin"
 	"github.com/hashicorp/vault/helper/logging"
 	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
 	"github.com/hashicorp/vault/logical/plugin/mock"
 )
 
@@ -36,6 +37,26 @@ func TestBackendPlugin_HandleRequest(t *testing.T) {
 	}
 }
 
+func TestBackendPlugin_HelpOpenAPI(t *testing.T) {
+	b, cleanup := testBackend(t)
+	defer cleanup()
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.HelpOperation,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc, ok := resp.Data["openapi"].(*framework.OASDocument)
+	if !ok {
+		t.Fatalf("bad: %#v", resp.Data)
+	}
+	if _, ok := doc.Paths["/kv/{key}"]; !ok {
+		t.Fatalf("bad: %#v", doc.Paths)
+	}
+}
+
 func TestBackendPlugin_SpecialPaths(t *testing.T) {
 	b, cleanup := testBackend(t)
 	defer cleanup()
@@ -10,6 +10,7 @@ import (
 	gplugin "github.com/hashicorp/go-plugin"
 	"github.com/hashicorp/vault/helper/logging"
 	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
 	"github.com/hashicorp/vault/logical/plugin/mock"
 )
 
@@ -37,6 +38,31 @@ func TestGRPCBackendPlugin_HandleRequest(t *testing.T) {
 	}
 }
 
+func TestGRPCBackendPlugin_HelpOpenAPI(t *testing.T) {
+	b, cleanup := testGRPCBackend(t)
+	defer cleanup()
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.HelpOperation,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw, ok := resp.Data["openapi"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("bad: %#v", resp.Data)
+	}
+	doc, err := framework.NewOASDocumentFromMap(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	item, ok := doc.Paths["/kv/{key}"]
+	if !ok || item.Get == nil || item.Post == nil || item.Delete == nil {
+		t.Fatalf("bad: %#v", doc.Paths)
+	}
+}
+
 func TestGRPCBackendPlugin_SpecialPaths(t *testing.T) {
 	b, cleanup := testGRPCBackend(t)
 	defer cleanup()
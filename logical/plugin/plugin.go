@@ -16,6 +16,7 @@ import (
 	"github.com/hashicorp/go-plugin"
 	"github.com/hashicorp/vault/helper/pluginutil"
 	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
 )
 
 // init registers basic structs with gob which will be used to transport complex
@@ -26,6 +27,10 @@ func init() {
 	gob.Register(map[string]interface{}{})
 	gob.Register(map[string]string{})
 	gob.Register(map[string]int{})
+	gob.Register([]string{})
+
+	// OpenAPI documents are returned as part of the root help response
+	gob.Register(&framework.OASDocument{})
 
 	// Register these types since we have to serialize and de-serialize
 	// tls.ConnectionState over the wire as part of logical.Request.Connection.
@@ -142,6 +142,11 @@ type Request struct {
 	// accessible.
 	Unauthenticated bool `json:"unauthenticated" structs:"unauthenticated" mapstructure:"unauthenticated"`
 
+	// DelegatedAuth is the authentication of the client set by the core when
+	// it routes the request again after performing the login requested by the
+	// backend with a DelegatedAuthRequest error
+	DelegatedAuth *Auth `json:"delegated_auth" structs:"delegated_auth" mapstructure:"delegated_auth" sentinel:""`
+
 	// MFACreds holds the parsed MFA information supplied over the API as part of
 	// X-Vault-MFA header
 	MFACreds MFACreds `json:"mfa_creds" structs:"mfa_creds" mapstructure:"mfa_creds" sentinel:""`
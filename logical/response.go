@@ -18,6 +18,9 @@ const (
 	// HTTPRawBody is the raw content of the HTTP body that goes with the HTTPContentType.
 	// This can only be specified for non-secrets, and should should be similarly
 	// avoided like the HTTPContentType. The value must be a byte slice.
+	//
+	// The HTTP front end also sets it in the Data of update requests whose body
+	// has one of the binary content types of RawRequestContentTypes.
 	HTTPRawBody = "http_raw_body"
 
 	// HTTPStatusCode is the response code of the HTTP body that goes with the HTTPContentType.
@@ -32,6 +35,14 @@ const (
 	HTTPRawBodyAlreadyJSONDecoded = "http_raw_body_already_json_decoded"
 )
 
+// RawRequestContentTypes are the content types of request bodies that are
+// passed to backends as is, in the HTTPRawBody of the request data, rather
+// than parsed as JSON. They are used by protocols with binary requests.
+var RawRequestContentTypes = []string{
+	"application/ocsp-request",
+	"application/pkcs10",
+}
+
 // Response is a struct that stores the response of a request.
 // It is used to abstract the details of the higher level request protocol.
 type Response struct {
@@ -60,6 +71,10 @@ type Response struct {
 
 	// Information for wrapping the response in a cubbyhole
 	WrapInfo *wrapping.ResponseWrapInfo `json:"wrap_info" structs:"wrap_info" mapstructure:"wrap_info"`
+
+	// Headers are HTTP headers sent with a raw response, using
+	// HTTPStatusCode. They are ignored for other responses.
+	Headers map[string][]string `json:"headers" structs:"headers" mapstructure:"headers"`
 }
 
 // AddWarning adds a warning into the response's warning list
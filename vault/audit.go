@@ -405,6 +405,10 @@ func (c *Core) teardownAudits() error {
 		}
 	}
 
+	if c.auditBroker != nil {
+		c.auditBroker.Cleanup()
+	}
+
 	c.audit = nil
 	c.auditBroker = nil
 	return nil
@@ -49,8 +49,29 @@ func (a *AuditBroker) Register(name string, b audit.Backend, v *BarrierView, loc
 // Deregister is used to remove an audit backend from the broker
 func (a *AuditBroker) Deregister(name string) {
 	a.Lock()
-	defer a.Unlock()
+	be, ok := a.backends[name]
 	delete(a.backends, name)
+	a.Unlock()
+
+	if ok {
+		cleanupAuditBackend(be.backend)
+	}
+}
+
+// Cleanup stops the audit backends running in the background, once the
+// broker is torn down
+func (a *AuditBroker) Cleanup() {
+	a.RLock()
+	defer a.RUnlock()
+	for _, be := range a.backends {
+		cleanupAuditBackend(be.backend)
+	}
+}
+
+func cleanupAuditBackend(b audit.Backend) {
+	if c, ok := b.(audit.Cleanup); ok {
+		c.Cleanup(context.Background())
+	}
 }
 
 // IsRegistered is used to check if a given audit backend is registered
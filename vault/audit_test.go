@@ -40,6 +40,8 @@ type NoopAudit struct {
 	RespReqNonHMACKeys []string
 	RespErrs           []error
 
+	CleanedUp bool
+
 	salt      *salt.Salt
 	saltMutex sync.RWMutex
 }
@@ -105,6 +107,10 @@ func (n *NoopAudit) Invalidate(ctx context.Context) {
 	n.salt = nil
 }
 
+func (n *NoopAudit) Cleanup(ctx context.Context) {
+	n.CleanedUp = true
+}
+
 func TestAudit_ReadOnlyViewDuringMount(t *testing.T) {
 	c, _, _ := TestCoreUnsealed(t)
 	c.auditBackends["noop"] = func(ctx context.Context, config *audit.BackendConfig) (audit.Backend, error) {
@@ -333,10 +339,12 @@ func TestCore_EnableAudit_Local(t *testing.T) {
 
 func TestCore_DisableAudit(t *testing.T) {
 	c, keys, _ := TestCoreUnsealed(t)
+	var noop *NoopAudit
 	c.auditBackends["noop"] = func(ctx context.Context, config *audit.BackendConfig) (audit.Backend, error) {
-		return &NoopAudit{
+		noop = &NoopAudit{
 			Config: config,
-		}, nil
+		}
+		return noop, nil
 	}
 
 	existed, err := c.disableAudit(namespace.TestContext(), "foo", true)
@@ -363,6 +371,9 @@ func TestCore_DisableAudit(t *testing.T) {
 	if c.auditBroker.IsRegistered("foo") {
 		t.Fatalf("audit backend present")
 	}
+	if !noop.CleanedUp {
+		t.Fatalf("audit backend not cleaned up")
+	}
 
 	conf := &CoreConfig{
 		Physical:     c.physical,
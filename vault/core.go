@@ -27,6 +27,7 @@ import (
 	"github.com/hashicorp/vault/audit"
 	"github.com/hashicorp/vault/helper/consts"
 	"github.com/hashicorp/vault/helper/logging"
+	"github.com/hashicorp/vault/helper/metricsutil"
 	"github.com/hashicorp/vault/helper/mlock"
 	"github.com/hashicorp/vault/helper/namespace"
 	"github.com/hashicorp/vault/helper/reload"
@@ -391,6 +392,9 @@ type Core struct {
 	// Stores loggers so we can reset the level
 	allLoggers     []log.Logger
 	allLoggersLock sync.RWMutex
+
+	// metricsHelper is used to serve the sys/metrics endpoint
+	metricsHelper *metricsutil.MetricsHelper
 }
 
 // CoreConfig is used to parameterize a core
@@ -455,6 +459,8 @@ type CoreConfig struct {
 	DisablePerformanceStandby bool
 
 	AllLoggers []log.Logger
+
+	MetricsHelper *metricsutil.MetricsHelper
 }
 
 // NewCore is used to construct a new core
@@ -527,6 +533,7 @@ func NewCore(conf *CoreConfig) (*Core, error) {
 		disablePerfStandby:               true,
 		activeContextCancelFunc:          new(atomic.Value),
 		allLoggers:                       conf.AllLoggers,
+		metricsHelper:                    conf.MetricsHelper,
 	}
 
 	atomic.StoreUint32(c.sealed, 1)
@@ -1144,6 +1151,12 @@ func (c *Core) UIEnabled() bool {
 	return c.uiConfig.Enabled()
 }
 
+// MetricsHelper returns the helper used to render the node's telemetry, or
+// nil if telemetry has not been configured
+func (c *Core) MetricsHelper() *metricsutil.MetricsHelper {
+	return c.metricsHelper
+}
+
 // UIHeaders returns configured UI headers
 func (c *Core) UIHeaders() (http.Header, error) {
 	return c.uiConfig.Headers(context.Background())
@@ -1530,6 +1543,12 @@ func (c *Core) RouterAccess() *RouterAccess {
 	return NewRouterAccess(c)
 }
 
+// HeadPath checks if the backend mounted at the path answers HEAD requests
+// to it
+func (c *Core) HeadPath(ctx context.Context, path string) bool {
+	return c.router.HeadPath(ctx, path)
+}
+
 // IsDRSecondary returns if the current cluster state is a DR secondary.
 func (c *Core) IsDRSecondary() bool {
 	return c.ReplicationState().HasState(consts.ReplicationDRSecondary)
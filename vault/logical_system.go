@@ -12,6 +12,7 @@ import (
 	"hash"
 	"net/http"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -25,6 +26,7 @@ import (
 	"github.com/hashicorp/vault/helper/consts"
 	"github.com/hashicorp/vault/helper/identity"
 	"github.com/hashicorp/vault/helper/jsonutil"
+	"github.com/hashicorp/vault/helper/metricsutil"
 	"github.com/hashicorp/vault/helper/namespace"
 	"github.com/hashicorp/vault/helper/parseutil"
 	"github.com/hashicorp/vault/helper/strutil"
@@ -143,6 +145,8 @@ func NewSystemBackend(core *Core, logger log.Logger) *SystemBackend {
 	b.Backend.Paths = append(b.Backend.Paths, b.capabilitiesPaths()...)
 	b.Backend.Paths = append(b.Backend.Paths, b.internalUIPaths()...)
 	b.Backend.Paths = append(b.Backend.Paths, b.remountPath())
+	b.Backend.Paths = append(b.Backend.Paths, b.openAPIPath())
+	b.Backend.Paths = append(b.Backend.Paths, b.metricsPath())
 
 	if core.rawEnabled {
 		b.Backend.Paths = append(b.Backend.Paths, &framework.Path{
@@ -2813,6 +2817,122 @@ func (b *SystemBackend) pathInternalUIMountRead(ctx context.Context, req *logica
 	return resp, nil
 }
 
+// pathInternalOpenAPI merges the OpenAPI documents of every mount the caller
+// can see into a single document. Each backend reports its document through
+// the root help operation, which also carries it across the plugin RPC.
+//
+// Only the mounts of the request's namespace are included, and their paths are
+// relative to it, as the mounts listing and the router both resolve paths
+// within the namespace in ctx.
+func (b *SystemBackend) pathInternalOpenAPI(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	// Limit output to mounts the caller has access to
+	mountsResp, err := b.pathInternalUIMountsRead(ctx, req, d)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := framework.NewOASDocument()
+
+	procMountGroup := func(group, mountPrefix string) error {
+		mounts := mountsResp.Data[group].(map[string]interface{})
+
+		mountPaths := make([]string, 0, len(mounts))
+		for mount := range mounts {
+			mountPaths = append(mountPaths, mount)
+		}
+		sort.Strings(mountPaths)
+
+		for _, mount := range mountPaths {
+			backend := b.Core.router.MatchingBackend(ctx, mountPrefix+mount)
+			if backend == nil {
+				continue
+			}
+
+			// Each backend only gets its own storage, never the one of the
+			// system backend
+			helpResp, err := backend.HandleRequest(ctx, &logical.Request{
+				Operation: logical.HelpOperation,
+				Storage:   b.Core.router.MatchingStorageByAPIPath(ctx, mountPrefix+mount),
+			})
+			if err != nil {
+				b.logger.Warn("error fetching OpenAPI document", "path", mountPrefix+mount, "error", err)
+				continue
+			}
+			if helpResp == nil {
+				continue
+			}
+
+			// External plugins return the document decoded into a map
+			var backendDoc *framework.OASDocument
+			switch v := helpResp.Data["openapi"].(type) {
+			case *framework.OASDocument:
+				backendDoc = v
+			case map[string]interface{}:
+				backendDoc, err = framework.NewOASDocumentFromMap(v)
+				if err != nil {
+					b.logger.Warn("error decoding OpenAPI document", "path", mountPrefix+mount, "error", err)
+					continue
+				}
+			default:
+				continue
+			}
+
+			tag := "secrets"
+			switch {
+			case mountPrefix == "auth/":
+				tag = "auth"
+			case mount == "sys/" || mount == "identity/":
+				tag = "system"
+			}
+
+			for path, item := range backendDoc.Paths {
+				for _, op := range item.Operations() {
+					op.Tags = append(op.Tags, tag)
+				}
+				doc.Paths["/"+mountPrefix+mount+strings.TrimPrefix(path, "/")] = item
+			}
+		}
+
+		return nil
+	}
+
+	if err := procMountGroup("secret", ""); err != nil {
+		return nil, err
+	}
+	if err := procMountGroup("auth", "auth/"); err != nil {
+		return nil, err
+	}
+
+	doc.CreateOperationIDs()
+
+	buf, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			logical.HTTPStatusCode:  200,
+			logical.HTTPRawBody:     buf,
+			logical.HTTPContentType: "application/json",
+		},
+	}, nil
+}
+
+// handleMetrics returns the telemetry collected by this node in the requested
+// format.
+func (b *SystemBackend) handleMetrics(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	if b.Core.metricsHelper == nil {
+		return nil, logical.CodedError(http.StatusServiceUnavailable, "telemetry is not configured on this node")
+	}
+
+	format := data.Get("format").(string)
+	if format == "" {
+		format = metricsutil.FormatFromRequest(req)
+	}
+	return b.Core.metricsHelper.ResponseForFormat(format)
+}
+
 func (b *SystemBackend) pathInternalUIResultantACL(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
 	if req.ClientToken == "" {
 		// 204 -- no ACL
@@ -3599,4 +3719,23 @@ This path responds to the following HTTP methods.
 		"Information about a token's resultant ACL. Internal API; its location, inputs, and outputs may change.",
 		"",
 	},
+	"metrics": {
+		"Export the metrics aggregated for telemetry purpose.",
+		`
+This returns the metrics collected by this node. By default they are rendered
+as a JSON summary of the most recent in-memory interval; passing
+format=prometheus returns them in the Prometheus text exposition format,
+provided prometheus_retention_time is set in the telemetry configuration.
+		`,
+	},
+	"internal-specs-openapi": {
+		"Generate an OpenAPI 3 document of all mounted paths.",
+		`
+This returns a single OpenAPI 3 document describing the paths of every
+secrets engine and auth method the token has access to, at their mount
+paths. Only the mounts of the request's namespace are included, at paths
+relative to that namespace. Internal API; its location, inputs, and outputs
+may change.
+		`,
+	},
 }
@@ -717,6 +717,39 @@ func (b *SystemBackend) remountPath() *framework.Path {
 	}
 }
 
+func (b *SystemBackend) openAPIPath() *framework.Path {
+	return &framework.Path{
+		Pattern: "internal/specs/openapi",
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation: b.pathInternalOpenAPI,
+		},
+
+		HelpSynopsis:    strings.TrimSpace(sysHelp["internal-specs-openapi"][0]),
+		HelpDescription: strings.TrimSpace(sysHelp["internal-specs-openapi"][1]),
+	}
+}
+
+func (b *SystemBackend) metricsPath() *framework.Path {
+	return &framework.Path{
+		Pattern: "metrics",
+
+		Fields: map[string]*framework.FieldSchema{
+			"format": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "Format to export metrics into. Currently accepts only \"prometheus\".",
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation: b.handleMetrics,
+		},
+
+		HelpSynopsis:    strings.TrimSpace(sysHelp["metrics"][0]),
+		HelpDescription: strings.TrimSpace(sysHelp["metrics"][1]),
+	}
+}
+
 func (b *SystemBackend) authPaths() []*framework.Path {
 	return []*framework.Path{
 		{
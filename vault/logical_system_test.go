@@ -5,6 +5,7 @@ import (
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -21,6 +22,7 @@ import (
 	"github.com/hashicorp/vault/helper/namespace"
 	"github.com/hashicorp/vault/helper/salt"
 	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
 	"github.com/mitchellh/mapstructure"
 )
 
@@ -2206,6 +2208,152 @@ func TestSystemBackend_ToolsRandom(t *testing.T) {
 	doRequest(req, true, "", 0)
 }
 
+func TestSystemBackend_OpenAPI(t *testing.T) {
+	_, b, rootToken := testCoreSystemBackend(t)
+
+	req := logical.TestRequest(t, logical.ReadOperation, "internal/specs/openapi")
+	req.ClientToken = rootToken
+	resp, err := b.HandleRequest(namespace.TestContext(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if resp.Data[logical.HTTPContentType] != "application/json" {
+		t.Fatalf("bad content type: %#v", resp.Data)
+	}
+
+	doc := new(framework.OASDocument)
+	if err := json.Unmarshal(resp.Data[logical.HTTPRawBody].([]byte), doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Version != framework.OASVersion {
+		t.Fatalf("bad version: %q", doc.Version)
+	}
+
+	// Paths are documented at their mount points
+	tests := []struct {
+		path   string
+		tag    string
+		method string
+		sudo   bool
+	}{
+		{"/sys/internal/specs/openapi", "system", "get", false},
+		{"/sys/mounts/{path}", "system", "post", false},
+		{"/sys/audit/{path}", "system", "post", true},
+		{"/auth/token/create", "auth", "post", false},
+		{"/auth/token/lookup", "auth", "post", false},
+	}
+	for _, test := range tests {
+		item, ok := doc.Paths[test.path]
+		if !ok {
+			t.Fatalf("missing path %q", test.path)
+		}
+		op := item.Operations()[test.method]
+		if op == nil {
+			t.Fatalf("missing %s operation for %q", test.method, test.path)
+		}
+		if len(op.Tags) != 1 || op.Tags[0] != test.tag {
+			t.Fatalf("bad tags for %q: %v", test.path, op.Tags)
+		}
+		if op.OperationID == "" {
+			t.Fatalf("missing operation ID for %q", test.path)
+		}
+		if item.Sudo != test.sudo {
+			t.Fatalf("bad sudo flag for %q: %v", test.path, item.Sudo)
+		}
+	}
+
+	// Without a token only mounts with unauthenticated listing visibility
+	// are included
+	req = logical.TestRequest(t, logical.ReadOperation, "internal/specs/openapi")
+	resp, err = b.HandleRequest(namespace.TestContext(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	doc = new(framework.OASDocument)
+	if err := json.Unmarshal(resp.Data[logical.HTTPRawBody].([]byte), doc); err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Paths) != 0 {
+		t.Fatalf("expected no paths, got %d", len(doc.Paths))
+	}
+}
+
+func TestSystemBackend_OpenAPI_MountStorage(t *testing.T) {
+	c, b, rootToken := testCoreSystemBackend(t)
+
+	noop := &NoopBackend{
+		Response: &logical.Response{},
+	}
+	c.logicalBackends["noop"] = func(context.Context, *logical.BackendConfig) (logical.Backend, error) {
+		return noop, nil
+	}
+	req := logical.TestRequest(t, logical.UpdateOperation, "sys/mounts/foo")
+	req.Data["type"] = "noop"
+	req.ClientToken = rootToken
+	if _, err := c.HandleRequest(namespace.RootContext(nil), req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	req = logical.TestRequest(t, logical.ReadOperation, "internal/specs/openapi")
+	req.ClientToken = rootToken
+	if _, err := b.HandleRequest(namespace.RootContext(nil), req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Mounted backends are asked for their help with their own storage
+	view := c.router.MatchingStorageByAPIPath(namespace.RootContext(nil), "foo/")
+	if len(noop.Requests) != 1 || noop.Requests[0].Operation != logical.HelpOperation {
+		t.Fatalf("bad: %#v", noop.Requests)
+	}
+	if noop.Requests[0].Storage != view || noop.Requests[0].Storage == req.Storage {
+		t.Fatalf("bad storage: %#v", noop.Requests[0].Storage)
+	}
+}
+
+func TestSystemBackend_OpenAPI_BadDocument(t *testing.T) {
+	c, b, rootToken := testCoreSystemBackend(t)
+
+	// A plugin document that can't be decoded is left out of the response
+	noop := &NoopBackend{
+		Response: &logical.Response{
+			Data: map[string]interface{}{
+				"openapi": map[string]interface{}{
+					"paths": "bad",
+				},
+			},
+		},
+	}
+	c.logicalBackends["noop"] = func(context.Context, *logical.BackendConfig) (logical.Backend, error) {
+		return noop, nil
+	}
+	req := logical.TestRequest(t, logical.UpdateOperation, "sys/mounts/foo")
+	req.Data["type"] = "noop"
+	req.ClientToken = rootToken
+	if _, err := c.HandleRequest(namespace.RootContext(nil), req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	req = logical.TestRequest(t, logical.ReadOperation, "internal/specs/openapi")
+	req.ClientToken = rootToken
+	resp, err := b.HandleRequest(namespace.RootContext(nil), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	doc := new(framework.OASDocument)
+	if err := json.Unmarshal(resp.Data[logical.HTTPRawBody].([]byte), doc); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := doc.Paths["/sys/mounts/{path}"]; !ok {
+		t.Fatal("missing path \"/sys/mounts/{path}\"")
+	}
+	for path := range doc.Paths {
+		if strings.HasPrefix(path, "/foo/") {
+			t.Fatalf("unexpected path %q", path)
+		}
+	}
+}
+
 func TestSystemBackend_InternalUIMounts(t *testing.T) {
 	_, b, rootToken := testCoreSystemBackend(t)
 
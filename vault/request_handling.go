@@ -43,6 +43,10 @@ type HandlerProperties struct {
 	MaxRequestSize        int64
 	MaxRequestDuration    time.Duration
 	DisablePrintableCheck bool
+
+	// UnauthenticatedMetricsAccess allows sys/metrics to be read without a
+	// token on this listener
+	UnauthenticatedMetricsAccess bool
 }
 
 // fetchEntityAndDerivedPolicies returns the entity object for the given entity
@@ -388,6 +392,9 @@ func (c *Core) HandleRequest(httpCtx context.Context, req *logical.Request) (res
 	var auth *logical.Auth
 	if c.router.LoginPath(ctx, req.Path) {
 		resp, auth, err = c.handleLoginRequest(ctx, req)
+		if delegated, ok := err.(*logical.DelegatedAuthRequest); ok {
+			resp, auth, err = c.handleDelegatedAuth(ctx, req, delegated)
+		}
 	} else {
 		resp, auth, err = c.handleRequest(ctx, req)
 	}
@@ -814,9 +821,16 @@ func (c *Core) handleRequest(ctx context.Context, req *logical.Request) (retResp
 
 // handleLoginRequest is used to handle a login request, which is an
 // unauthenticated request to the backend.
-func (c *Core) handleLoginRequest(ctx context.Context, req *logical.Request) (retResp *logical.Response, retAuth *logical.Auth, retErr error) {
+func (c *Core) handleLoginRequest(ctx context.Context, req *logical.Request) (*logical.Response, *logical.Auth, error) {
 	defer metrics.MeasureSince([]string{"core", "handle_login_request"}, time.Now())
 
+	return c.handleLogin(ctx, req, false)
+}
+
+// handleLogin audits and routes a login request, and sets up the
+// authentication it returns. A delegated login authenticates the client of a
+// request to another path, so it creates no token.
+func (c *Core) handleLogin(ctx context.Context, req *logical.Request, delegated bool) (retResp *logical.Response, retAuth *logical.Auth, retErr error) {
 	req.Unauthenticated = true
 
 	var auth *logical.Auth
@@ -1031,19 +1045,21 @@ func (c *Core) handleLoginRequest(ctx context.Context, req *logical.Request) (re
 			}
 		}
 
-		registerFunc, funcGetErr := getAuthRegisterFunc(c)
-		if funcGetErr != nil {
-			retErr = multierror.Append(retErr, funcGetErr)
-			return nil, auth, retErr
-		}
+		if !delegated {
+			registerFunc, funcGetErr := getAuthRegisterFunc(c)
+			if funcGetErr != nil {
+				retErr = multierror.Append(retErr, funcGetErr)
+				return nil, auth, retErr
+			}
 
-		err = registerFunc(ctx, tokenTTL, req.Path, auth)
-		switch {
-		case err == nil:
-		case err == ErrInternalError:
-			return nil, auth, err
-		default:
-			return logical.ErrorResponse(err.Error()), auth, logical.ErrInvalidRequest
+			err = registerFunc(ctx, tokenTTL, req.Path, auth)
+			switch {
+			case err == nil:
+			case err == ErrInternalError:
+				return nil, auth, err
+			default:
+				return logical.ErrorResponse(err.Error()), auth, logical.ErrInvalidRequest
+			}
 		}
 
 		auth.IdentityPolicies = policyutil.SanitizePolicies(identityPolicies[ns.ID], policyutil.DoNotAddDefaultPolicy)
@@ -1059,6 +1075,116 @@ func (c *Core) handleLoginRequest(ctx context.Context, req *logical.Request) (re
 	return resp, auth, routeErr
 }
 
+// handleDelegatedAuth performs the login requested by the backend of an
+// unauthenticated path to authenticate its client, and routes the request
+// again if the policies the login grants allow it. The login is audited and
+// checked like any other, but no token is created.
+func (c *Core) handleDelegatedAuth(ctx context.Context, req *logical.Request, delegated *logical.DelegatedAuthRequest) (*logical.Response, *logical.Auth, error) {
+	if req.DelegatedAuth != nil {
+		c.logger.Error("delegated authentication requested for an authenticated request", "request_path", req.Path)
+		return nil, nil, ErrInternalError
+	}
+	if !strings.HasPrefix(delegated.Path, credentialRoutePrefix) || !c.router.LoginPath(ctx, delegated.Path) {
+		c.logger.Error("delegated authentication requested with an invalid login path", "request_path", req.Path, "login_path", delegated.Path)
+		return nil, nil, ErrInternalError
+	}
+
+	loginReq := &logical.Request{
+		ID:         req.ID,
+		Operation:  logical.UpdateOperation,
+		Path:       delegated.Path,
+		Data:       delegated.Data,
+		Connection: req.Connection,
+	}
+	loginResp, auth, err := c.handleLogin(ctx, loginReq, true)
+
+	// Create an audit trail of the login response
+	var nonHMACReqDataKeys []string
+	var nonHMACRespDataKeys []string
+	if entry := c.router.MatchingMountEntry(ctx, loginReq.Path); entry != nil {
+		if rawVals, ok := entry.synthesizedConfigCache.Load("audit_non_hmac_request_keys"); ok {
+			nonHMACReqDataKeys = rawVals.([]string)
+		}
+		if rawVals, ok := entry.synthesizedConfigCache.Load("audit_non_hmac_response_keys"); ok {
+			nonHMACRespDataKeys = rawVals.([]string)
+		}
+	}
+	logInput := &audit.LogInput{
+		Auth:                auth,
+		Request:             loginReq,
+		Response:            loginResp,
+		OuterErr:            err,
+		NonHMACReqDataKeys:  nonHMACReqDataKeys,
+		NonHMACRespDataKeys: nonHMACRespDataKeys,
+	}
+	if auditErr := c.auditBroker.LogResponse(ctx, logInput, c.auditedHeaders); auditErr != nil {
+		c.logger.Error("failed to audit response", "request_path", loginReq.Path, "error", auditErr)
+		return nil, nil, ErrInternalError
+	}
+
+	switch {
+	case err == ErrInternalError || errwrap.Contains(err, ErrInternalError.Error()):
+		return nil, nil, ErrInternalError
+	case err != nil:
+		c.logger.Debug("delegated authentication failed", "request_path", req.Path, "login_path", delegated.Path, "error", err)
+		return nil, nil, logical.ErrPermissionDenied
+	case loginResp == nil || loginResp.IsError() || auth == nil:
+		return nil, nil, logical.ErrPermissionDenied
+	}
+
+	// CIDR checks bind the authentication like the token it would create
+	if len(auth.BoundCIDRs) > 0 {
+		var valid bool
+		remoteSockAddr, err := sockaddr.NewSockAddr(req.Connection.RemoteAddr)
+		if err != nil {
+			if c.Logger().IsDebug() {
+				c.Logger().Debug("could not parse remote addr into sockaddr", "error", err, "remote_addr", req.Connection.RemoteAddr)
+			}
+			return nil, nil, logical.ErrPermissionDenied
+		}
+		for _, cidr := range auth.BoundCIDRs {
+			if cidr.Contains(remoteSockAddr) {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return nil, nil, logical.ErrPermissionDenied
+		}
+	}
+
+	// Construct the ACL from the policies the token would have
+	ns, err := namespace.FromContext(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	entity, policies, err := c.fetchEntityAndDerivedPolicies(ctx, ns, auth.EntityID)
+	if err != nil {
+		return nil, nil, ErrInternalError
+	}
+	if policies == nil {
+		policies = make(map[string][]string)
+	}
+	policies[ns.ID] = append(policies[ns.ID], auth.TokenPolicies...)
+	acl, err := c.policyStore.ACL(ctx, entity, policies)
+	if err != nil {
+		c.logger.Error("failed to construct ACL", "error", err)
+		return nil, nil, ErrInternalError
+	}
+	if !acl.AllowOperation(ctx, req, false).Allowed {
+		return nil, auth, logical.ErrPermissionDenied
+	}
+
+	req.DelegatedAuth = auth
+	req.DisplayName = auth.DisplayName
+
+	resp, err := c.router.Route(ctx, req)
+	if err != nil {
+		resp, err = possiblyForward(ctx, c, req, resp, err)
+	}
+	return resp, auth, err
+}
+
 func (c *Core) RegisterAuth(ctx context.Context, tokenTTL time.Duration, path string, auth *logical.Auth) error {
 	// We first assign token policies to what was returned from the backend
 	// via auth.Policies. Then, we get the full set of policies into
@@ -1,10 +1,13 @@
 package vault
 
 import (
+	"context"
 	"testing"
 	"time"
 
+	sockaddr "github.com/hashicorp/go-sockaddr"
 	"github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/vault/audit"
 	credUserpass "github.com/hashicorp/vault/builtin/credential/userpass"
 	"github.com/hashicorp/vault/helper/namespace"
 	"github.com/hashicorp/vault/logical"
@@ -144,3 +147,117 @@ func TestRequestHandling_LoginWrapping(t *testing.T) {
 		t.Fatalf("bad: %#v", resp)
 	}
 }
+
+func TestRequestHandling_DelegatedAuth(t *testing.T) {
+	var noopAudit *NoopAudit
+	loginPath := "auth/foo/login"
+	credBackend := &NoopBackend{
+		Login: []string{"login"},
+	}
+	logicalBackend := &NoopBackend{
+		Login: []string{"enroll"},
+		RequestHandler: func(ctx context.Context, req *logical.Request) (*logical.Response, error) {
+			if req.DelegatedAuth == nil {
+				return nil, &logical.DelegatedAuthRequest{
+					Path: loginPath,
+				}
+			}
+			return &logical.Response{
+				Data: map[string]interface{}{
+					"display_name": req.DelegatedAuth.DisplayName,
+				},
+			}, nil
+		},
+	}
+
+	c, _, root := TestCoreUnsealed(t)
+	c.credentialBackends["noop"] = func(context.Context, *logical.BackendConfig) (logical.Backend, error) {
+		return credBackend, nil
+	}
+	c.logicalBackends["noop"] = func(context.Context, *logical.BackendConfig) (logical.Backend, error) {
+		return logicalBackend, nil
+	}
+	c.auditBackends["noop"] = func(ctx context.Context, config *audit.BackendConfig) (audit.Backend, error) {
+		noopAudit = &NoopAudit{
+			Config: config,
+		}
+		return noopAudit, nil
+	}
+
+	for path, data := range map[string]map[string]interface{}{
+		"sys/auth/foo":        {"type": "noop"},
+		"sys/mounts/delegate": {"type": "noop"},
+		"sys/audit/noop":      {"type": "noop"},
+		"sys/policy/enroll":   {"policy": `path "delegate/enroll" { capabilities = ["update"] }`},
+	} {
+		req := logical.TestRequest(t, logical.UpdateOperation, path)
+		req.Data = data
+		req.ClientToken = root
+		if _, err := c.HandleRequest(namespace.RootContext(nil), req); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	enroll := func(policies []string, boundCIDRs []string) (*logical.Response, error) {
+		t.Helper()
+
+		auth := &logical.Auth{
+			Policies:    policies,
+			DisplayName: "armon",
+		}
+		for _, cidr := range boundCIDRs {
+			sockAddr, err := sockaddr.NewSockAddr(cidr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			auth.BoundCIDRs = append(auth.BoundCIDRs, &sockaddr.SockAddrMarshaler{SockAddr: sockAddr})
+		}
+		credBackend.Response = &logical.Response{
+			Auth: auth,
+		}
+
+		return c.HandleRequest(namespace.RootContext(nil), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "delegate/enroll",
+			Connection: &logical.Connection{
+				RemoteAddr: "127.0.0.1",
+			},
+		})
+	}
+
+	// The policies granted by the login must allow the request, and the
+	// login is audited
+	resp, err := enroll([]string{"enroll"}, []string{"127.0.0.0/8"})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if resp == nil || resp.Data["display_name"] != "foo-armon" {
+		t.Fatalf("bad: %#v", resp)
+	}
+	var loginAudited, loginRespAudited bool
+	for _, req := range noopAudit.Req {
+		loginAudited = loginAudited || req.Path == loginPath
+	}
+	for _, req := range noopAudit.RespReq {
+		loginRespAudited = loginRespAudited || req.Path == loginPath
+	}
+	if !loginAudited || !loginRespAudited {
+		t.Fatalf("expected the delegated login to be audited: %#v", noopAudit)
+	}
+
+	if _, err := enroll([]string{"default"}, nil); err != logical.ErrPermissionDenied {
+		t.Fatalf("expected permission denied for insufficient policies, got: %v", err)
+	}
+	if _, err := enroll([]string{"root"}, nil); err != logical.ErrPermissionDenied {
+		t.Fatalf("expected permission denied for root policies, got: %v", err)
+	}
+	if _, err := enroll([]string{"enroll"}, []string{"10.0.0.0/8"}); err != logical.ErrPermissionDenied {
+		t.Fatalf("expected permission denied outside of the bound CIDRs, got: %v", err)
+	}
+
+	// Only login paths of auth mounts can be delegated to
+	loginPath = "delegate/enroll"
+	if _, err := enroll([]string{"enroll"}, nil); err != ErrInternalError {
+		t.Fatalf("expected an internal error for an invalid login path, got: %v", err)
+	}
+}
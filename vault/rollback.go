@@ -10,6 +10,7 @@ import (
 	log "github.com/hashicorp/go-hclog"
 
 	"github.com/armon/go-metrics"
+	"github.com/hashicorp/vault/helper/metricsutil"
 	"github.com/hashicorp/vault/helper/namespace"
 	"github.com/hashicorp/vault/logical"
 )
@@ -156,7 +157,7 @@ func (m *RollbackManager) startRollback(ctx context.Context, fullPath string, gr
 
 // attemptRollback invokes a RollbackOperation for the given path
 func (m *RollbackManager) attemptRollback(ctx context.Context, fullPath string, rs *rollbackState, grabStatelock bool) (err error) {
-	defer metrics.MeasureSince([]string{"rollback", "attempt", strings.Replace(fullPath, "/", "-", -1)}, time.Now())
+	start := time.Now()
 	if m.logger.IsDebug() {
 		m.logger.Debug("attempting rollback", "path", fullPath)
 	}
@@ -177,6 +178,7 @@ func (m *RollbackManager) attemptRollback(ctx context.Context, fullPath string,
 	if ns == nil {
 		return namespace.ErrNoNamespace
 	}
+	defer metrics.MeasureSinceWithLabels([]string{"rollback", "attempt"}, start, metricsutil.MountLabels(ns, fullPath))
 
 	// Invoke a RollbackOperation
 	req := &logical.Request{
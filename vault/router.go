@@ -10,6 +10,7 @@ import (
 
 	"github.com/armon/go-metrics"
 	"github.com/armon/go-radix"
+	"github.com/hashicorp/vault/helper/metricsutil"
 	"github.com/hashicorp/vault/helper/namespace"
 	"github.com/hashicorp/vault/helper/salt"
 	"github.com/hashicorp/vault/logical"
@@ -48,6 +49,7 @@ type routeEntry struct {
 	storagePrefix string
 	rootPaths     atomic.Value
 	loginPaths    atomic.Value
+	headPaths     atomic.Value
 	l             sync.RWMutex
 }
 
@@ -121,6 +123,7 @@ func (r *Router) Mount(backend logical.Backend, prefix string, mountEntry *Mount
 	}
 	re.rootPaths.Store(pathsToRadix(paths.Root))
 	re.loginPaths.Store(pathsToRadix(paths.Unauthenticated))
+	re.headPaths.Store(pathsToRadix(paths.Head))
 
 	switch {
 	case prefix == "":
@@ -485,8 +488,8 @@ func (r *Router) routeCommon(ctx context.Context, req *logical.Request, existenc
 		return logical.ErrorResponse(fmt.Sprintf("no handler for route '%s'", req.Path)), false, false, logical.ErrUnsupportedPath
 	}
 	req.Path = adjustedPath
-	defer metrics.MeasureSince([]string{"route", string(req.Operation),
-		strings.Replace(mount, "/", "-", -1)}, time.Now())
+	defer metrics.MeasureSinceWithLabels([]string{"route", string(req.Operation)}, time.Now(),
+		metricsutil.MountLabels(ns, mount))
 	re := raw.(*routeEntry)
 
 	// Grab a read lock on the route entry, this protects against the backend
@@ -735,6 +738,43 @@ func (r *Router) LoginPath(ctx context.Context, path string) bool {
 	return match == remain
 }
 
+// HeadPath checks if the given path answers HEAD requests
+func (r *Router) HeadPath(ctx context.Context, path string) bool {
+	ns, err := namespace.FromContext(ctx)
+	if err != nil {
+		return false
+	}
+
+	adjustedPath := ns.Path + path
+
+	r.l.RLock()
+	mount, raw, ok := r.root.LongestPrefix(adjustedPath)
+	r.l.RUnlock()
+	if !ok {
+		return false
+	}
+	re := raw.(*routeEntry)
+
+	// Trim to get remaining path
+	remain := strings.TrimPrefix(adjustedPath, mount)
+
+	// Check the headPaths of this backend
+	headPaths := re.headPaths.Load().(*radix.Tree)
+	match, raw, ok := headPaths.LongestPrefix(remain)
+	if !ok {
+		return false
+	}
+	prefixMatch := raw.(bool)
+
+	// Handle the prefix match case
+	if prefixMatch {
+		return strings.HasPrefix(remain, match)
+	}
+
+	// Handle the exact match case
+	return match == remain
+}
+
 // pathsToRadix converts a the mapping of special paths to a mapping
 // of special paths to radix trees.
 func pathsToRadix(paths []string) *radix.Tree {
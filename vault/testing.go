@@ -143,6 +143,7 @@ func TestCoreWithSealAndUI(t testing.T, opts *CoreConfig) *Core {
 	conf.EnableRaw = opts.EnableRaw
 	conf.Seal = opts.Seal
 	conf.LicensingConfig = opts.LicensingConfig
+	conf.MetricsHelper = opts.MetricsHelper
 
 	c, err := NewCore(conf)
 	if err != nil {